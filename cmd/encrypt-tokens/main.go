@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// encrypt-tokens is a one time migration, encrypting refresh tokens which
+// were stored before tokens were encrypted at rest
+func main() {
+	ctx := cx.NewOptions(context.Background())
+	ctx = db.NewPgStore(ctx).Context(ctx)
+
+	encrypted, err := db.EncryptTokens(ctx)
+	if err != nil {
+		log.Fatalf("failed to encrypt tokens after %d: %+v", encrypted, err)
+	}
+	log.Printf("encrypted %d refresh tokens", encrypted)
+}
@@ -2,12 +2,239 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/a-tal/esi-isk/isk"
 	"github.com/a-tal/esi-isk/isk/api"
 	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+	"github.com/a-tal/esi-isk/isk/worker"
 )
 
+// exit codes of every command, documented for scripting
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitUsage  = 2
+)
+
+// command is a subcommand of esi-isk
+type command struct {
+	// flags are the groups of options the command registers
+	flags cx.Flags
+	// args describes the positional arguments, for usage
+	args string
+	help string
+	run  func(ctx context.Context, args []string) error
+}
+
+// usageError is returned by commands given invalid arguments
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+var commands = map[string]*command{
+	"serve": {
+		flags: cx.FlagsAll,
+		help:  "run the HTTP server, the default command",
+		run:   serve,
+	},
+	"migrate": {
+		flags: cx.FlagsDB,
+		args:  "[dir]",
+		help:  "apply new migrations from dir, sql by default",
+		run:   migrate,
+	},
+	"backfill": {
+		flags: cx.FlagsDB | cx.FlagsESI,
+		args:  "<character ID>",
+		help:  "re-run the backfill of a character",
+		run:   backfill,
+	},
+	"recalc-totals": {
+		flags: cx.FlagsDB,
+		help:  "recalculate every character's totals and ranks",
+		run:   recalcTotals,
+	},
+	"purge-character": {
+		flags: cx.FlagsDB | cx.FlagsESI,
+		args:  "<character ID>",
+		help:  "revoke the token of a character and purge all of their data",
+		run:   purgeCharacter,
+	},
+}
+
 func main() {
-	isk.RunServer(cx.NewOptions(api.NewProvider(context.Background())))
+	os.Exit(run(os.Args[1:]))
+}
+
+// run runs the command named by the first argument, returning the exit code
+func run(args []string) int {
+	name, args := commandName(args)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		usage()
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: esi-isk %s [flags] %s\n", name, cmd.args)
+		fs.PrintDefaults()
+	}
+	options := cx.RegisterOptions(fs, cmd.flags)
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return exitOK
+	} else if err != nil {
+		return exitUsage
+	}
+
+	ctx := options(context.Background())
+	if cmd.flags&cx.FlagsESI != 0 {
+		ctx = api.NewProvider(ctx)
+	}
+
+	if err := cmd.run(ctx, fs.Args()); err != nil {
+		if _, ok := err.(usageError); ok {
+			fmt.Fprintln(os.Stderr, err)
+			fs.Usage()
+			return exitUsage
+		}
+		log.Printf("failed to %s: %+v", name, err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// commandName splits the command name from its arguments. Without one, or
+// when the arguments start with a flag, the command is serve
+func commandName(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "serve", args
+	}
+	return args[0], args[1:]
+}
+
+func usage() {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: esi-isk <command> [flags] [args]")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].help)
+	}
+}
+
+// characterArg returns the only argument as a character ID
+func characterArg(args []string) (int32, error) {
+	if len(args) != 1 {
+		return 0, usageError("expected one character ID")
+	}
+	charID, err := strconv.ParseInt(args[0], 10, 32)
+	if err != nil || charID < 1 {
+		return 0, usageError("invalid character ID: " + args[0])
+	}
+	return int32(charID), nil
+}
+
+// noArgs ensures no positional arguments were given
+func noArgs(args []string) error {
+	if len(args) > 0 {
+		return usageError("unexpected arguments: " + strings.Join(args, " "))
+	}
+	return nil
+}
+
+// openStore connects to the db once for the command, adding the PgStore to
+// the context. Call the returned func once the command is done with it
+func openStore(ctx context.Context) (context.Context, func()) {
+	store := db.NewPgStore(ctx)
+	return store.Context(ctx), func() {
+		if err := store.Close(); err != nil {
+			log.Printf("failed to close db: %+v", err)
+		}
+	}
+}
+
+func serve(ctx context.Context, args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+	// sessions and login states are signed with the app secret, anyone
+	// could sign them with the default
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	if opts.AppSecret == cx.DefaultAppSecret && !opts.Debug {
+		return usageError("-app-secret must be set, unless -debug")
+	}
+	ctx, closeStore := openStore(ctx)
+	defer closeStore()
+	isk.RunServer(ctx)
+	return nil
+}
+
+func migrate(ctx context.Context, args []string) error {
+	dir := "sql"
+	if len(args) == 1 {
+		dir = args[0]
+	} else if err := noArgs(args); err != nil {
+		return err
+	}
+
+	// statements are prepared against the migrated schema, so none are yet
+	ctx = context.WithValue(ctx, cx.DB, db.Connect(ctx))
+
+	applied, err := db.Migrate(ctx, dir)
+	for _, name := range applied {
+		log.Printf("applied migration: %s", name)
+	}
+	if err == nil {
+		log.Printf("applied %d migrations", len(applied))
+	}
+	return err
+}
+
+func backfill(ctx context.Context, args []string) error {
+	charID, err := characterArg(args)
+	if err != nil {
+		return err
+	}
+	ctx, closeStore := openStore(ctx)
+	defer closeStore()
+	return worker.RunBackfill(ctx, charID)
+}
+
+func recalcTotals(ctx context.Context, args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+	ctx, closeStore := openStore(ctx)
+	defer closeStore()
+	return worker.RecalculateTotals(ctx)
+}
+
+func purgeCharacter(ctx context.Context, args []string) error {
+	charID, err := characterArg(args)
+	if err != nil {
+		return err
+	}
+	ctx, closeStore := openStore(ctx)
+	defer closeStore()
+	err = api.RemoveCharacter(ctx, db.CLIActor, charID, true)
+	if err != nil {
+		return err
+	}
+	log.Printf("purged character: %d", charID)
+	return nil
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommandName(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		name string
+		rest []string
+	}{
+		{nil, "serve", nil},
+		{[]string{"-debug"}, "serve", []string{"-debug"}},
+		{[]string{"migrate"}, "migrate", []string{}},
+		{[]string{"backfill", "-debug", "1"}, "backfill", []string{"-debug", "1"}},
+	} {
+		name, rest := commandName(tc.args)
+		if name != tc.name || !reflect.DeepEqual(rest, tc.rest) {
+			t.Errorf("%v: received %s %v", tc.args, name, rest)
+		}
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{"unknown"},
+		{"migrate", "-unknown"},
+		{"migrate", "a", "b"},
+		{"recalc-totals", "extra"},
+		{"backfill"},
+		{"backfill", "0"},
+		{"backfill", "-1"},
+		{"backfill", "abc"},
+		{"purge-character", "1", "2"},
+		{"serve", "-port=abc"},
+		{"serve"},
+		{"serve", "-app-secret=not-secure"},
+	} {
+		if code := run(args); code != exitUsage {
+			t.Errorf("%v: expected exit %d, received %d", args, exitUsage, code)
+		}
+	}
+
+	if code := run([]string{"migrate", "-h"}); code != exitOK {
+		t.Errorf("expected help to exit %d, received %d", exitOK, code)
+	}
+}
+
+func TestCharacterArg(t *testing.T) {
+	charID, err := characterArg([]string{"90000001"})
+	if err != nil || charID != 90000001 {
+		t.Errorf("received %d, %+v", charID, err)
+	}
+}
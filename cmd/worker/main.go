@@ -5,9 +5,12 @@ import (
 
 	"github.com/a-tal/esi-isk/isk/api"
 	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
 	"github.com/a-tal/esi-isk/isk/worker"
 )
 
 func main() {
-	worker.Run(cx.NewOptions(api.NewProvider(context.Background())))
+	ctx := cx.NewOptions(context.Background())
+	ctx = db.NewPgStore(ctx).Context(ctx)
+	worker.Run(api.NewProvider(ctx))
 }
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+var errBadDirection = errors.New("direction must be received or sent")
+
+// donationsPage is a page of a character's donations
+type donationsPage struct {
+	Donations []*activityV2 `json:"donations"`
+	nextCursor
+}
+
+// contractsPage is a page of a character's contracts
+type contractsPage struct {
+	Contracts []*activityV2 `json:"contracts"`
+	nextCursor
+}
+
+// activityPage is a page of a character's donations and contracts merged
+type activityPage struct {
+	Activity []*activityV2 `json:"activity"`
+	nextCursor
+}
+
+// DonationsV2 returns a page of the character's donations, newest first
+func DonationsV2(ctx context.Context) http.HandlerFunc {
+	return activityList(ctx, true, false, func(
+		list []*activityV2,
+		next nextCursor,
+	) interface{} {
+		return &donationsPage{Donations: list, nextCursor: next}
+	})
+}
+
+// ContractsV2 returns a page of the character's contracts, newest first
+func ContractsV2(ctx context.Context) http.HandlerFunc {
+	return activityList(ctx, false, true, func(
+		list []*activityV2,
+		next nextCursor,
+	) interface{} {
+		return &contractsPage{Contracts: list, nextCursor: next}
+	})
+}
+
+// ActivityV2 returns a page of the character's donations and contracts,
+// newest first
+func ActivityV2(ctx context.Context) http.HandlerFunc {
+	return activityList(ctx, true, true, func(
+		list []*activityV2,
+		next nextCursor,
+	) interface{} {
+		return &activityPage{Activity: list, nextCursor: next}
+	})
+}
+
+// activityList writes a page of the lists, in the shape of wrap
+func activityList(
+	ctx context.Context,
+	donations, contracts bool,
+	wrap func([]*activityV2, nextCursor) interface{},
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		charID, err := getPathCharID(r)
+		if err != nil {
+			write400(w, r, "invalid character ID")
+			return
+		}
+
+		p, err := getCursorPage(r)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		received, sent, err := getDirection(r)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		if !checkCharacterAccess(ctx, w, r, charID) {
+			return
+		}
+
+		// one more row of each list than the page says if there are more
+		c, err := db.GetCharPage(ctx, charID, &db.PageQuery{
+			Donations: donations,
+			Contracts: contracts,
+			Received:  received,
+			Sent:      sent,
+			After:     p.After,
+			Limit:     p.Limit + 1,
+		})
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		if sessionCharacter(r) != charID {
+			if err := c.MaskAnonymous(ctx); err != nil {
+				write500(w, r, err)
+				return
+			}
+		}
+
+		cache.Tag(w, cache.CharacterTag(charID))
+		writeJSON(ctx, w, wrap(pageActivity(c, p.Limit)))
+	}
+}
+
+// pageActivity merges the lists read, returning up to limit of them and the
+// cursor of the next page if there are more
+func pageActivity(c *db.CharDetails, limit int) ([]*activityV2, nextCursor) {
+	list := newActivity(c)
+	next := nextCursor{}
+	if len(list) > limit {
+		list = list[:limit]
+		last := list[len(list)-1]
+		next.NextCursor = encodeCursor(&db.Cursor{
+			Timestamp: last.Timestamp,
+			ID:        last.ID,
+		})
+	}
+	return list, next
+}
+
+// getDirection reads the "direction" query arg, both directions if unset
+func getDirection(r *http.Request) (received, sent bool, err error) {
+	switch r.URL.Query().Get("direction") {
+	case "":
+		return true, true, nil
+	case activityReceived:
+		return true, false, nil
+	case activitySent:
+		return false, true, nil
+	}
+	return false, false, errBadDirection
+}
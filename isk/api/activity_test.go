@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+func TestPageActivity(t *testing.T) {
+	at := time.Date(2019, 1, 20, 12, 0, 0, 0, time.UTC)
+	c := &db.CharDetails{
+		Donations: db.Donations{
+			{ID: 3, Recipient: 1, Timestamp: at},
+			{ID: 1, Recipient: 1, Timestamp: at.Add(-time.Hour)},
+		},
+		Contracts: db.Contracts{{ID: 2, Receiver: 1, Issued: at}},
+	}
+
+	list, next := pageActivity(c, 2)
+	if len(list) != 2 || list[0].ID != 3 || list[1].ID != 2 {
+		t.Fatalf("unexpected page %+v", list)
+	}
+	after, err := decodeCursor(next.NextCursor)
+	if err != nil {
+		t.Fatalf("failed to decode next cursor: %+v", err)
+	}
+	if !after.Timestamp.Equal(at) || after.ID != 2 {
+		t.Errorf("expected the cursor of the last row, received %+v", after)
+	}
+
+	list, next = pageActivity(c, 3)
+	if len(list) != 3 || next.NextCursor != "" {
+		t.Errorf("expected the last page without a cursor, received %+v", next)
+	}
+
+	raw, err := json.Marshal(&activityPage{Activity: list, nextCursor: next})
+	if err != nil {
+		t.Fatalf("failed to marshal page: %+v", err)
+	}
+	if strings.Contains(string(raw), "next_cursor") {
+		t.Errorf("expected next_cursor to be omitted, received %s", raw)
+	}
+}
+
+func TestGetDirection(t *testing.T) {
+	for query, expected := range map[string][2]bool{
+		"":                    {true, true},
+		"?direction=received": {true, false},
+		"?direction=sent":     {false, true},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/x"+query, nil)
+		received, sent, err := getDirection(r)
+		if err != nil || received != expected[0] || sent != expected[1] {
+			t.Errorf("%q: received %v %v (%v)", query, received, sent, err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/x?direction=up", nil)
+	if _, _, err := getDirection(r); err != errBadDirection {
+		t.Errorf("expected errBadDirection, received %v", err)
+	}
+}
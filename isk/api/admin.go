@@ -0,0 +1,524 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// adminHeader carries the app secret on admin requests
+const adminHeader = "X-Admin-Secret"
+
+// purgeRequest is the body of a cache purge, without a character for all
+type purgeRequest struct {
+	CharacterID int32 `json:"character_id"`
+}
+
+// adjustmentRequest is the body of a donation adjustment
+type adjustmentRequest struct {
+	db.Correction
+	Donator  int32  `json:"donator"`
+	Receiver int32  `json:"receiver"`
+	Amount   db.ISK `json:"amount"`
+	Note     string `json:"note"`
+}
+
+// standingResponse is a character's good standing, with the contacts of the
+// standings sources granting it. Good standing without any is from donations
+type standingResponse struct {
+	CharacterID  int32         `json:"character_id"`
+	GoodStanding bool          `json:"good_standing"`
+	Sources      []*db.Contact `json:"sources"`
+}
+
+// auditPage is a page of the audit log
+type auditPage struct {
+	*page
+	Entries []*db.AuditEntry `json:"entries"`
+}
+
+// ringsPage is a page of the donation rings report
+type ringsPage struct {
+	*page
+	Rings []*db.DonationRing `json:"rings"`
+}
+
+// trackedPage is a page of the characters with a stored token
+type trackedPage struct {
+	Characters []*db.TrackedCharacter `json:"characters"`
+	nextCursor
+}
+
+// defaultStaleHours is how long since their last successful poll characters
+// are stale, unless the request says
+const defaultStaleHours = 24
+
+// cacheTarget is the audit target of actions on the whole response cache
+const cacheTarget = "cache"
+
+// isAdmin returns true if the request carries the app secret, which must
+// have been set to something other than the default
+func isAdmin(opts *cx.Options, r *http.Request) bool {
+	given := r.Header.Get(adminHeader)
+	return given != "" && opts.AppSecret != "" &&
+		opts.AppSecret != cx.DefaultAppSecret &&
+		subtle.ConstantTimeCompare([]byte(given), []byte(opts.AppSecret)) == 1
+}
+
+// PurgeCache evicts every cached response, or only those of the character
+// given in the request body
+func PurgeCache(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	respCache := ctx.Value(cx.ResponseCache).(*cache.Cache)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodPost {
+			write405(w, r)
+			return
+		}
+
+		if !isAdmin(opts, r) {
+			write403(w, r)
+			return
+		}
+
+		req := purgeRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			write400(w, r, "invalid request body")
+			return
+		}
+
+		var evicted int
+		if req.CharacterID > 0 {
+			evicted = respCache.Invalidate(cache.CharacterTag(req.CharacterID))
+		} else {
+			evicted = respCache.Purge()
+		}
+
+		target := cacheTarget
+		if req.CharacterID > 0 {
+			target = db.CharacterTarget(req.CharacterID)
+		}
+		auditAdmin(ctx, "purge_cache", target, map[string]int{"evicted": evicted})
+
+		cx.Logf(ctx, "admin purged %d cached responses", evicted)
+		writeJSONFor(w, map[string]int{"evicted": evicted}, 0)
+	}
+}
+
+// VoidDonation voids the donation with the journal ref ID in the path, the
+// body says who voided it and why
+func VoidDonation(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodPost {
+			write405(w, r)
+			return
+		}
+
+		if !isAdmin(opts, r) {
+			write403(w, r)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil || id == 0 {
+			write400(w, r, "invalid donation ID")
+			return
+		}
+
+		c := &db.Correction{}
+		if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+			write400(w, r, "invalid request body")
+			return
+		}
+
+		donation, err := db.VoidDonation(ctx, id, c)
+		if err == db.ErrDonationNotFound {
+			write404(w, r, "donation not found")
+			return
+		} else if err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+
+		cx.Logf(ctx, "admin %s voided donation %d", c.Admin, id)
+		writeJSONFor(w, donation, 0)
+	}
+}
+
+// AdjustDonation adds a manual donation between two known characters
+func AdjustDonation(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodPost {
+			write405(w, r)
+			return
+		}
+
+		if !isAdmin(opts, r) {
+			write403(w, r)
+			return
+		}
+
+		req := &adjustmentRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			write400(w, r, "invalid request body")
+			return
+		}
+
+		donation, err := db.AdjustDonation(ctx, &db.Donation{
+			Donator:   req.Donator,
+			Recipient: req.Receiver,
+			Amount:    req.Amount,
+			Note:      req.Note,
+		}, &req.Correction)
+		if err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+
+		cx.Logf(ctx, "admin %s added adjustment %d", req.Admin, donation.ID)
+		writeJSONFor(w, donation, 0)
+	}
+}
+
+// BanCharacter bans the character in the path from public listings on POST,
+// and lifts the ban on DELETE. The body says who did it and why
+func BanCharacter(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	respCache := ctx.Value(cx.ResponseCache).(*cache.Cache)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			write405(w, r)
+			return
+		}
+
+		if !isAdmin(opts, r) {
+			write403(w, r)
+			return
+		}
+
+		charID, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+		if err != nil || charID < 1 {
+			write400(w, r, "invalid character ID")
+			return
+		}
+
+		c := &db.Correction{}
+		if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+			write400(w, r, "invalid request body")
+			return
+		}
+
+		banned := r.Method == http.MethodPost
+		if err := db.SetBanned(ctx, int32(charID), banned, c); err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+
+		// their donations show on every page of the characters they sent to
+		// or received from, there's no tag for those
+		respCache.Purge()
+
+		cx.Logf(
+			ctx,
+			"admin %s set character %d banned %t",
+			c.Admin,
+			charID,
+			banned,
+		)
+		w.WriteHeader(204)
+	}
+}
+
+// AuditLog returns a page of the audit log, newest first, optionally of only
+// the action and target in the query args
+func AuditLog(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		if !isAdmin(opts, r) {
+			write403(w, r)
+			return
+		}
+
+		p, err := getPage(r)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		query := r.URL.Query()
+		entries, err := db.GetAudit(ctx, &db.AuditFilter{
+			Action: query.Get("action"),
+			Target: query.Get("target"),
+		}, p.Limit, p.Offset)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSONFor(w, &auditPage{page: p, Entries: entries}, 0)
+	}
+}
+
+// DonationRings returns a page of the donation rings found by the worker,
+// newest first. The "filter" query arg is pending (the default), reviewed or
+// all
+func DonationRings(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		if !isAdmin(opts, r) {
+			write403(w, r)
+			return
+		}
+
+		p, err := getPage(r)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		filter := r.URL.Query().Get("filter")
+		if filter == "" {
+			filter = db.RingsPending
+		} else if !db.IsRingsFilter(filter) {
+			write400(w, r, "filter must be pending, reviewed or all")
+			return
+		}
+
+		rings, err := db.GetDonationRings(ctx, filter, p.Limit, p.Offset)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSONFor(w, &ringsPage{page: p, Rings: rings}, 0)
+	}
+}
+
+// ReviewRing marks the donation ring in the path reviewed, returning its
+// members to the leaderboards. The body says who reviewed it and why
+func ReviewRing(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	respCache := ctx.Value(cx.ResponseCache).(*cache.Cache)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodPost {
+			write405(w, r)
+			return
+		}
+
+		if !isAdmin(opts, r) {
+			write403(w, r)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil || id < 1 {
+			write400(w, r, "invalid ring ID")
+			return
+		}
+
+		c := &db.Correction{}
+		if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+			write400(w, r, "invalid request body")
+			return
+		}
+
+		err = db.ReviewRing(ctx, id, c)
+		if err == db.ErrRingNotFound {
+			write404(w, r, "donation ring not found")
+			return
+		} else if err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+
+		if opts.ExcludeRings {
+			respCache.Invalidate(cache.TopTag)
+		}
+
+		cx.Logf(ctx, "admin %s reviewed donation ring %d", c.Admin, id)
+		w.WriteHeader(204)
+	}
+}
+
+// TrackedCharacters returns a page of the characters with a stored token,
+// most recently active first, optionally only those matching the filter
+func TrackedCharacters(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		if !isAdmin(opts, r) {
+			write403(w, r)
+			return
+		}
+
+		q, err := getTrackedQuery(r, time.Now())
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		// one more than the page says if there are more
+		limit := q.Limit
+		q.Limit++
+		tracked, err := db.GetTrackedCharacters(ctx, q)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		res := &trackedPage{Characters: tracked}
+		if len(tracked) > limit {
+			res.Characters = tracked[:limit]
+			res.NextCursor = encodeCursor(tracked[limit-1].Cursor())
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSONFor(w, res, 0)
+	}
+}
+
+// getTrackedQuery reads the filter, hours, order, limit and cursor query args
+func getTrackedQuery(r *http.Request, now time.Time) (*db.TrackedQuery, error) {
+	p, err := getCursorPage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.URL.Query()
+	q := &db.TrackedQuery{
+		Filter: query.Get("filter"),
+		After:  p.After,
+		Limit:  p.Limit,
+	}
+	if !db.IsTrackedFilter(q.Filter) {
+		return nil, errors.New(
+			"filter must be revoked, hidden, deleted, stale or banned",
+		)
+	}
+
+	hours := defaultStaleHours
+	if raw := query.Get("hours"); raw != "" {
+		hours, err = strconv.Atoi(raw)
+		if err != nil || hours < 1 {
+			return nil, errors.New("hours must be a positive number")
+		}
+	}
+	q.StaleBefore = now.Add(-time.Duration(hours) * time.Hour)
+
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Ascending = true
+	default:
+		return nil, errors.New("order must be asc or desc")
+	}
+	return q, nil
+}
+
+// auditAdmin records the admin's action. The action has been taken, so
+// failing to audit it is only logged
+func auditAdmin(
+	ctx context.Context,
+	action, target string,
+	detail interface{},
+) {
+	err := db.WriteAudit(ctx, db.AdminActor, action, target, detail)
+	if err != nil {
+		cx.Logf(ctx, "failed to audit %s of %s: %+v", action, target, err)
+	}
+}
+
+// Standing shows the good standing of the character in the path, and which
+// standings sources granted it
+func Standing(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		if !isAdmin(opts, r) {
+			write403(w, r)
+			return
+		}
+
+		charID, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+		if err != nil || charID < 1 {
+			write400(w, r, "invalid character ID")
+			return
+		}
+
+		char, err := db.GetCharacter(ctx, int32(charID))
+		if err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+
+		sources, err := db.GetStandingSources(ctx, char.ID)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		writeJSONFor(w, &standingResponse{
+			CharacterID:  char.ID,
+			GoodStanding: char.GoodStanding,
+			Sources:      sources,
+		}, 0)
+	}
+}
@@ -0,0 +1,448 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// auditDB stands in for the db in admin tests, recording the actor, action,
+// target, request ID and detail of each audit entry written
+type auditDB struct {
+	lock    sync.Mutex
+	entries [][]driver.Value
+}
+
+type auditConn struct{ db *auditDB }
+
+type auditStmt struct{ db *auditDB }
+
+func (a *auditDB) Connect(context.Context) (driver.Conn, error) {
+	return &auditConn{db: a}, nil
+}
+
+func (a *auditDB) Driver() driver.Driver { return nil }
+
+func (a *auditDB) written() [][]driver.Value {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.entries
+}
+
+func (c *auditConn) Prepare(string) (driver.Stmt, error) {
+	return &auditStmt{db: c.db}, nil
+}
+
+func (c *auditConn) Close() error { return nil }
+
+func (c *auditConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (s *auditStmt) Close() error { return nil }
+
+func (s *auditStmt) NumInput() int { return -1 }
+
+func (s *auditStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	s.db.entries = append(s.db.entries, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *auditStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("queries are not supported")
+}
+
+func testAdminContext() (context.Context, *cache.Cache) {
+	ctx, respCache, _ := testAuditContext()
+	return ctx, respCache
+}
+
+// testAuditContext is the admin test context, with the audit entries written
+func testAuditContext() (context.Context, *cache.Cache, *auditDB) {
+	respCache := cache.New(10, time.Minute)
+	ctx := context.WithValue(context.Background(), cx.Opts, &cx.Options{
+		AppSecret: "test-secret",
+	})
+
+	audit := &auditDB{}
+	stmt, err := sqlx.NewDb(sql.OpenDB(audit), "postgres").PrepareNamed(
+		"INSERT :actor :action :target :request_id :detail",
+	)
+	if err != nil {
+		panic(err)
+	}
+	ctx = context.WithValue(ctx, cx.Statements, map[cx.Key]*sqlx.NamedStmt{
+		cx.StmtWriteAudit: stmt,
+	})
+	return context.WithValue(ctx, cx.ResponseCache, respCache), respCache, audit
+}
+
+// fillCache caches a response for each character ID
+func fillCache(respCache *cache.Cache, charIDs ...int32) {
+	for _, charID := range charIDs {
+		id := charID
+		h := respCache.Middleware(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				cache.Tag(w, cache.CharacterTag(id))
+			},
+		), 0)
+		target := fmt.Sprintf("/api/char?c=%d", id)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+}
+
+func purge(ctx context.Context, secret, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(
+		http.MethodPost,
+		"/api/admin/cache/purge",
+		strings.NewReader(body),
+	)
+	if secret != "" {
+		r.Header.Set(adminHeader, secret)
+	}
+	w := httptest.NewRecorder()
+	PurgeCache(ctx)(w, r)
+	return w
+}
+
+func evicted(t *testing.T, w *httptest.ResponseRecorder) int {
+	res := map[string]int{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid purge response: %+v", err)
+	}
+	return res["evicted"]
+}
+
+func TestPurgeCacheAuth(t *testing.T) {
+	ctx, respCache := testAdminContext()
+	fillCache(respCache, 1)
+
+	for name, secret := range map[string]string{"missing": "", "wrong": "nope"} {
+		if w := purge(ctx, secret, ""); w.Code != 403 {
+			t.Errorf("%s secret: expected 403, received %d", name, w.Code)
+		}
+	}
+	if respCache.Stats().Entries != 1 {
+		t.Error("unauthenticated request evicted entries")
+	}
+
+	// the default secret is known to everyone
+	ctx = context.WithValue(ctx, cx.Opts, &cx.Options{
+		AppSecret: cx.DefaultAppSecret,
+	})
+	if w := purge(ctx, cx.DefaultAppSecret, ""); w.Code != 403 {
+		t.Errorf("default secret: expected 403, received %d", w.Code)
+	}
+	if respCache.Stats().Entries != 1 {
+		t.Error("request with the default secret evicted entries")
+	}
+}
+
+func TestPurgeCache(t *testing.T) {
+	ctx, respCache, audit := testAuditContext()
+	fillCache(respCache, 1, 2, 3)
+
+	w := purge(ctx, "test-secret", `{"character_id": 2}`)
+	if w.Code != 200 || evicted(t, w) != 1 {
+		t.Errorf("character purge: %d %s", w.Code, w.Body.String())
+	}
+
+	w = purge(ctx, "test-secret", "")
+	if w.Code != 200 || evicted(t, w) != 2 {
+		t.Errorf("full purge: %d %s", w.Code, w.Body.String())
+	}
+
+	if w := purge(ctx, "test-secret", "{"); w.Code != 400 {
+		t.Errorf("bad body: expected 400, received %d", w.Code)
+	}
+
+	entries := audit.written()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 audit entries, received %+v", entries)
+	}
+	for i, expected := range [][]driver.Value{
+		{"admin", "purge_cache", "character:2", "", `{"evicted":1}`},
+		{"admin", "purge_cache", "cache", "", `{"evicted":2}`},
+	} {
+		if fmt.Sprint(entries[i]) != fmt.Sprint(expected) {
+			t.Errorf("audited %v, expected %v", entries[i], expected)
+		}
+	}
+}
+
+func TestDonationCorrectionValidation(t *testing.T) {
+	ctx, _ := testAdminContext()
+	mux := http.NewServeMux()
+	mux.Handle("/api/admin/donations/{id}/void", VoidDonation(ctx))
+	mux.Handle("/api/admin/donations/adjust", AdjustDonation(ctx))
+
+	for _, tc := range []struct {
+		method, target, secret, body string
+		code                         int
+	}{
+		{http.MethodPost, "/api/admin/donations/1/void", "", `{}`, 403},
+		{http.MethodPost, "/api/admin/donations/1/void", "nope", `{}`, 403},
+		{http.MethodGet, "/api/admin/donations/1/void", "test-secret", "", 405},
+		{http.MethodPost, "/api/admin/donations/x/void", "test-secret", `{}`, 400},
+		{http.MethodPost, "/api/admin/donations/1/void", "test-secret", "{", 400},
+		{http.MethodPost, "/api/admin/donations/1/void", "test-secret",
+			`{"admin": "someone"}`, 400},
+		{http.MethodPost, "/api/admin/donations/adjust", "", `{}`, 403},
+		{http.MethodPost, "/api/admin/donations/adjust", "test-secret", "{", 400},
+		{http.MethodPost, "/api/admin/donations/adjust", "test-secret",
+			`{"admin": "a", "reason": "r", "donator": 1, "receiver": 2, "amount": 0}`, 400},
+		{http.MethodPost, "/api/admin/donations/adjust", "test-secret",
+			`{"admin": "a", "reason": "r", "donator": 1, "receiver": 1, "amount": 5}`, 400},
+		{http.MethodPost, "/api/admin/donations/adjust", "test-secret",
+			`{"donator": 1, "receiver": 2, "amount": 5}`, 400},
+	} {
+		r := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+		if tc.secret != "" {
+			r.Header.Set(adminHeader, tc.secret)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s %s: expected %d, received %d",
+				tc.method, tc.target, tc.body, tc.code, w.Code)
+		}
+	}
+}
+
+func TestStandingValidation(t *testing.T) {
+	ctx, _ := testAdminContext()
+	mux := http.NewServeMux()
+	mux.Handle("/api/admin/standings/{id}", Standing(ctx))
+
+	for _, tc := range []struct {
+		method, target, secret string
+		code                   int
+	}{
+		{http.MethodGet, "/api/admin/standings/1", "", 403},
+		{http.MethodGet, "/api/admin/standings/1", "nope", 403},
+		{http.MethodPost, "/api/admin/standings/1", "test-secret", 405},
+		{http.MethodGet, "/api/admin/standings/x", "test-secret", 400},
+		{http.MethodGet, "/api/admin/standings/0", "test-secret", 400},
+	} {
+		r := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.secret != "" {
+			r.Header.Set(adminHeader, tc.secret)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s: expected %d, received %d",
+				tc.method, tc.target, tc.code, w.Code)
+		}
+	}
+}
+
+func TestAuditLogValidation(t *testing.T) {
+	ctx, _ := testAdminContext()
+
+	for _, tc := range []struct {
+		method, target, secret string
+		code                   int
+	}{
+		{http.MethodGet, "/api/admin/audit", "", 403},
+		{http.MethodGet, "/api/admin/audit", "nope", 403},
+		{http.MethodPost, "/api/admin/audit", "test-secret", 405},
+		{http.MethodGet, "/api/admin/audit?limit=0", "test-secret", 400},
+		{http.MethodGet, "/api/admin/audit?offset=x", "test-secret", 400},
+	} {
+		r := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.secret != "" {
+			r.Header.Set(adminHeader, tc.secret)
+		}
+		w := httptest.NewRecorder()
+		AuditLog(ctx)(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s: expected %d, received %d",
+				tc.method, tc.target, tc.code, w.Code)
+		}
+	}
+}
+
+func TestBanCharacterValidation(t *testing.T) {
+	ctx, _ := testAdminContext()
+
+	for _, tc := range []struct {
+		method, id, secret, body string
+		code                     int
+	}{
+		{http.MethodPost, "1", "", "{}", 403},
+		{http.MethodDelete, "1", "nope", "{}", 403},
+		{http.MethodGet, "1", "test-secret", "{}", 405},
+		{http.MethodPost, "x", "test-secret", "{}", 400},
+		{http.MethodPost, "0", "test-secret", "{}", 400},
+		{http.MethodPost, "1", "test-secret", "nope", 400},
+		{http.MethodDelete, "1", "test-secret", `{"admin":"a"}`, 400},
+	} {
+		r := httptest.NewRequest(
+			tc.method,
+			"/api/admin/characters/"+tc.id+"/ban",
+			strings.NewReader(tc.body),
+		)
+		r.SetPathValue("id", tc.id)
+		if tc.secret != "" {
+			r.Header.Set(adminHeader, tc.secret)
+		}
+		w := httptest.NewRecorder()
+		BanCharacter(ctx)(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s: expected %d, received %d",
+				tc.method, tc.id, tc.code, w.Code)
+		}
+	}
+}
+
+func TestDonationRingsValidation(t *testing.T) {
+	ctx, _ := testAdminContext()
+
+	for _, tc := range []struct {
+		method, target, secret string
+		code                   int
+	}{
+		{http.MethodGet, "/api/admin/rings", "", 403},
+		{http.MethodGet, "/api/admin/rings", "nope", 403},
+		{http.MethodPost, "/api/admin/rings", "test-secret", 405},
+		{http.MethodGet, "/api/admin/rings?limit=0", "test-secret", 400},
+		{http.MethodGet, "/api/admin/rings?filter=nope", "test-secret", 400},
+	} {
+		r := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.secret != "" {
+			r.Header.Set(adminHeader, tc.secret)
+		}
+		w := httptest.NewRecorder()
+		DonationRings(ctx)(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s: expected %d, received %d",
+				tc.method, tc.target, tc.code, w.Code)
+		}
+	}
+}
+
+func TestReviewRingValidation(t *testing.T) {
+	ctx, _ := testAdminContext()
+
+	for _, tc := range []struct {
+		method, id, secret, body string
+		code                     int
+	}{
+		{http.MethodPost, "1", "", "{}", 403},
+		{http.MethodPost, "1", "nope", "{}", 403},
+		{http.MethodGet, "1", "test-secret", "{}", 405},
+		{http.MethodPost, "x", "test-secret", "{}", 400},
+		{http.MethodPost, "0", "test-secret", "{}", 400},
+		{http.MethodPost, "1", "test-secret", "nope", 400},
+		{http.MethodPost, "1", "test-secret", `{"reason":"ok"}`, 400},
+	} {
+		r := httptest.NewRequest(
+			tc.method,
+			"/api/admin/rings/"+tc.id+"/review",
+			strings.NewReader(tc.body),
+		)
+		r.SetPathValue("id", tc.id)
+		if tc.secret != "" {
+			r.Header.Set(adminHeader, tc.secret)
+		}
+		w := httptest.NewRecorder()
+		ReviewRing(ctx)(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s: expected %d, received %d",
+				tc.method, tc.id, tc.code, w.Code)
+		}
+	}
+}
+
+func TestGetTrackedQuery(t *testing.T) {
+	now := time.Now()
+
+	for target, expected := range map[string]*db.TrackedQuery{
+		"/": {
+			StaleBefore: now.Add(-defaultStaleHours * time.Hour),
+			Limit:       defaultPageLimit,
+		},
+		"/?filter=stale&hours=2&order=asc&limit=5": {
+			Filter:      db.FilterStale,
+			StaleBefore: now.Add(-2 * time.Hour),
+			Ascending:   true,
+			Limit:       5,
+		},
+		"/?filter=revoked&order=desc": {
+			Filter:      db.FilterRevoked,
+			StaleBefore: now.Add(-defaultStaleHours * time.Hour),
+			Limit:       defaultPageLimit,
+		},
+		"/?filter=banned": {
+			Filter:      db.FilterBanned,
+			StaleBefore: now.Add(-defaultStaleHours * time.Hour),
+			Limit:       defaultPageLimit,
+		},
+	} {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		q, err := getTrackedQuery(r, now)
+		if err != nil {
+			t.Errorf("%s: unexpected error %+v", target, err)
+		} else if !reflect.DeepEqual(q, expected) {
+			t.Errorf("%s: received %+v, expected %+v", target, q, expected)
+		}
+	}
+
+	for _, target := range []string{
+		"/?filter=nope",
+		"/?hours=0",
+		"/?hours=x",
+		"/?order=up",
+		"/?limit=0",
+		"/?cursor=bad",
+	} {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if _, err := getTrackedQuery(r, now); err == nil {
+			t.Errorf("%s: expected an error", target)
+		}
+	}
+}
+
+func TestTrackedCharactersValidation(t *testing.T) {
+	ctx, _ := testAdminContext()
+
+	for _, tc := range []struct {
+		method, target, secret string
+		code                   int
+	}{
+		{http.MethodGet, "/api/admin/characters", "", 403},
+		{http.MethodGet, "/api/admin/characters", "nope", 403},
+		{http.MethodPost, "/api/admin/characters", "test-secret", 405},
+		{http.MethodGet, "/api/admin/characters?filter=x", "test-secret", 400},
+	} {
+		r := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.secret != "" {
+			r.Header.Set(adminHeader, tc.secret)
+		}
+		w := httptest.NewRecorder()
+		TrackedCharacters(ctx)(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s: expected %d, received %d",
+				tc.method, tc.target, tc.code, w.Code)
+		}
+	}
+}
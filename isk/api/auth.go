@@ -2,119 +2,201 @@ package api
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	oidc "github.com/coreos/go-oidc"
-	sessions "github.com/goincremental/negroni-sessions"
-	"github.com/twinj/uuid"
 	"golang.org/x/oauth2"
 
 	"github.com/a-tal/esi-isk/isk/cx"
 	"github.com/a-tal/esi-isk/isk/db"
+	"github.com/a-tal/esi-isk/isk/tokens"
 )
 
-// StateStore stores state uuids we've given out
-type StateStore struct {
-	lock   *sync.Mutex
-	states map[string]time.Time
+// stateCookie carries the signed oauth state from login to the callback
+const stateCookie = "esi-isk-state"
+
+// stateTTL is how long a login attempt has to complete the oauth flow
+const stateTTL = 5 * time.Minute
+
+// loginState is the signed content of the state cookie
+type loginState struct {
+	State   string `json:"s"`
+	Next    string `json:"n,omitempty"`
+	Expires int64  `json:"e"`
+
+	// Verifier is the PKCE code verifier of the login attempt, only its
+	// challenge is sent to SSO until the token exchange
+	Verifier string `json:"v,omitempty"`
 }
 
-// NewStateStore returns a new StateStore
-func NewStateStore() *StateStore {
-	ss := &StateStore{
-		lock:   &sync.Mutex{},
-		states: map[string]time.Time{},
-	}
-	go ss.maintenance()
-	return ss
+// ssoTimeout is how long requests to EVE SSO are allowed to take
+const ssoTimeout = 10 * time.Second
+
+// requiredScopes are those polling a character needs, logins granting fewer
+// are rejected
+var requiredScopes = []string{
+	"esi-wallet.read_character_wallet.v1",
+	"esi-contracts.read_character_contracts.v1",
 }
 
-// NewProvider creates our oidc provider
+// NewProvider adds the EVE SSO client and access token verifier to context
 func NewProvider(ctx context.Context) context.Context {
-	return ctx // HACK: remove ones sso-issues#41 is done
+	client := cx.NewClient(ctx, nil, ssoTimeout)
+	ctx = context.WithValue(ctx, cx.SSOClient, client)
+	return context.WithValue(ctx, cx.Verifier, NewJWKS(client, JWKSURL))
+}
 
-	provider, err := oidc.NewProvider(ctx, "http://login.eveonline.com")
-	if err != nil {
-		log.Fatalf("failed to create provider: %+v", err)
+// ssoContext has oauth2 make its requests with our SSO client
+func ssoContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, ctx.Value(cx.SSOClient))
+}
+
+// localPath returns next if it is a path on this site, or an empty string
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") ||
+		strings.HasPrefix(next, "//") ||
+		strings.ContainsAny(next, "\\\r\n") {
+		return ""
+	}
+
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return ""
 	}
 
-	ctx = context.WithValue(ctx, cx.Provider, provider)
-	return ctx
+	return next
 }
 
-// maintenance ensures we don't leak memory storing state uuids forever
-func (s *StateStore) maintenance() {
-	for {
-		time.Sleep(30 * time.Second)
-		s.prune()
+// randomString returns 32 random bytes, base64 URL encoded
+func randomString() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
 	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
 }
 
-// prune will remove any stale states from memory
-func (s *StateStore) prune() {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+// pkceChallenge returns the S256 PKCE code challenge of the verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
 
-	cutoff := stateCutoff()
-	toPrune := []string{}
-	for state, ts := range s.states {
-		if ts.Before(cutoff) {
-			toPrune = append(toPrune, state)
-		}
+// newState creates a random state and PKCE code verifier, and binds them to
+// the client in a cookie
+func newState(
+	w http.ResponseWriter,
+	opts *cx.Options,
+	next string,
+) (*loginState, error) {
+	random, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return nil, err
 	}
 
-	for _, p := range toPrune {
-		log.Printf("pruning old state: %s ts: %s", p, s.states[p])
-		delete(s.states, p)
+	state := &loginState{
+		State:    random,
+		Next:     localPath(next),
+		Expires:  time.Now().Add(stateTTL).Unix(),
+		Verifier: verifier,
 	}
+
+	value, err := signValue(opts.AppSecret, state)
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    value,
+		Path:     "/callback",
+		MaxAge:   int(stateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   opts.HTTPS,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return state, nil
 }
 
-func knownState(ctx context.Context, state string) bool {
-	ss := ctx.Value(cx.StateStore).(*StateStore)
-	ss.lock.Lock()
-	defer ss.lock.Unlock()
+// checkState returns the login state if it matches the client's cookie
+func checkState(opts *cx.Options, r *http.Request) (*loginState, error) {
+	given := r.FormValue("state")
+	if given == "" {
+		return nil, errors.New("missing state")
+	}
 
-	ts, found := ss.states[state]
-	if !found {
-		return false
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil {
+		return nil, errors.New("missing state cookie")
 	}
 
-	delete(ss.states, state)
+	state := &loginState{}
+	if err := readSigned(opts.AppSecret, cookie.Value, state); err != nil {
+		return nil, err
+	}
 
-	return ts.After(stateCutoff())
-}
+	if time.Now().Unix() > state.Expires {
+		return nil, errors.New("expired state")
+	}
 
-func stateCutoff() time.Time {
-	return time.Now().UTC().Add(-time.Duration(300) * time.Second)
+	if subtle.ConstantTimeCompare([]byte(given), []byte(state.State)) != 1 {
+		return nil, errors.New("mismatched state")
+	}
+
+	return state, nil
 }
 
-func newState(ctx context.Context) string {
-	state := uuid.NewV4().String()
-	ss := ctx.Value(cx.StateStore).(*StateStore)
-	ss.lock.Lock()
-	ss.states[state] = time.Now().UTC()
-	ss.lock.Unlock()
-	return state
+// clearState removes the state cookie from the client
+func clearState(w http.ResponseWriter, opts *cx.Options) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Path:     "/callback",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   opts.HTTPS,
+		SameSite: http.SameSiteLaxMode,
+	})
 }
 
 // NewLogin creates a new state and throws the user into the oauth flow
 func NewLogin(ctx context.Context) http.HandlerFunc {
 	opts := ctx.Value(cx.Opts).(*cx.Options)
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
 		if opts.Auth == nil {
-			write(w, 500, []byte("auth is not configured"))
+			writeErrorPage(w, 500, "Logins are not configured on this server.")
 			return
 		}
 
-		url := opts.Auth.AuthCodeURL(newState(ctx), oauth2.AccessTypeOffline)
+		state, err := newState(w, opts, r.URL.Query().Get("next"))
+		if err != nil {
+			cx.Logf(ctx, "failed to create login state: %+v", err)
+			writeErrorPage(w, 500, "Failed to start logging in, please try again.")
+			return
+		}
+
+		challenge := pkceChallenge(state.Verifier)
+		url := opts.Auth.AuthCodeURL(
+			state.State,
+			oauth2.AccessTypeOffline,
+			oauth2.SetAuthURLParam("code_challenge", challenge),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		)
 		http.Redirect(w, r.WithContext(ctx), url, 302)
 	}
 }
@@ -123,44 +205,91 @@ func NewLogin(ctx context.Context) http.HandlerFunc {
 func Callback(ctx context.Context) http.HandlerFunc {
 	opts := ctx.Value(cx.Opts).(*cx.Options)
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
 
 		if opts.Auth == nil {
-			write(w, 500, []byte("auth is not configured"))
+			writeErrorPage(w, 500, "Logins are not configured on this server.")
 			return
 		}
 
-		state := r.FormValue("state")
-		code := r.FormValue("code")
-
-		if !knownState(ctx, state) {
-			write(w, 400, []byte("invalid state"))
+		state, err := checkState(opts, r)
+		clearState(w, opts)
+		if err != nil {
+			cx.Logf(ctx, "rejected login callback: %+v", err)
+			writeErrorPage(
+				w,
+				400,
+				"Your login attempt could not be verified, or took too long. "+
+					"Please try to log in again.",
+			)
 			return
 		}
 
-		tok, err := opts.Auth.Exchange(ctx, code)
+		// logins started before PKCE was sent carry no verifier
+		exchange := []oauth2.AuthCodeOption{}
+		if state.Verifier != "" {
+			exchange = append(
+				exchange,
+				oauth2.SetAuthURLParam("code_verifier", state.Verifier),
+			)
+		}
+
+		tok, err := opts.Auth.Exchange(
+			ssoContext(ctx),
+			r.FormValue("code"),
+			exchange...,
+		)
 		if err != nil {
-			write(w, 500, []byte("failed to complete token exchange"))
+			cx.Logf(ctx, "failed to complete token exchange: %+v", err)
+			writeErrorPage(w, 500, "Failed to complete logging in with EVE SSO.")
 			return
 		}
 
 		user, err := userFromToken(ctx, tok)
 		if err != nil {
-			write(w, 500, []byte("failed to create new user"))
+			writeErrorPage(w, 500, "Failed to verify your EVE SSO login.")
+			return
+		}
+
+		// the token is useless to the worker without them, it isn't stored
+		if missing := missingScopes(user.Scopes); len(missing) > 0 {
+			cx.Logf(
+				ctx,
+				"rejected login of %d missing scopes: %s",
+				user.CharacterID,
+				strings.Join(missing, ", "),
+			)
+			writeErrorPage(w, 403, fmt.Sprintf(
+				"Your login did not grant the scopes ESI ISK needs to read "+
+					"your donations: %s. Please log in again and allow every "+
+					"scope requested.",
+				strings.Join(missing, ", "),
+			))
 			return
 		}
 
 		if err := db.SaveUser(ctx, user); err != nil {
-			write(w, 500, []byte("failed to save new user"))
+			cx.Logf(ctx, "failed to save new user: %+v", err)
+			writeErrorPage(w, 500, "Failed to save your login, please try again.")
+			return
+		}
+
+		if err := db.SaveOwner(ctx, user); err != nil {
+			cx.Logf(ctx, "failed to save character owner: %+v", err)
+		}
+
+		if err := setSession(w, opts, user.CharacterID); err != nil {
+			cx.Logf(ctx, "failed to create session: %+v", err)
+			writeErrorPage(w, 500, "Failed to save your login, please try again.")
 			return
 		}
 
-		session := sessions.GetSession(r)
-		session.Set("c", user.CharacterID)
+		next := state.Next
+		if next == "" {
+			next = fmt.Sprintf("/#prefs&c=%d&t=d", user.CharacterID)
+		}
 
-		http.Redirect(w, r.WithContext(ctx), fmt.Sprintf(
-			"/#prefs&c=%d&t=d",
-			user.CharacterID,
-		), 302)
+		http.Redirect(w, r.WithContext(ctx), next, 302)
 	}
 }
 
@@ -169,58 +298,40 @@ func userFromToken(
 	ctx context.Context,
 	t *oauth2.Token,
 ) (*db.User, error) {
-	charID, owner, err := getOwnerFromJWT(ctx, t)
+	claims, err := VerifyToken(ctx, t.AccessToken)
 	if err != nil {
+		cx.Logf(ctx, "failed to verify token: %+v", err)
+		return nil, err
+	}
+
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	refreshToken, err := tokens.Encrypt(opts.TokenKey, t.RefreshToken)
+	if err != nil {
+		cx.Logf(ctx, "failed to encrypt refresh token: %+v", err)
 		return nil, err
 	}
 
 	user := &db.User{
-		CharacterID:   charID,
-		OwnerHash:     owner,
-		RefreshToken:  t.RefreshToken,
+		CharacterID:   claims.CharacterID,
+		OwnerHash:     claims.OwnerHash,
+		RefreshToken:  refreshToken,
 		AccessToken:   t.AccessToken,
 		AccessExpires: t.Expiry,
+		Scopes:        claims.Scopes,
 	}
 
 	return user, nil
 }
 
-// getOwnerFromJWT parses the JWT for the character ID and owner hash
-func getOwnerFromJWT(ctx context.Context, t *oauth2.Token) (
-	charID int32,
-	owner string,
-	err error,
-) {
-	// HACK: remove once ccpgames/sso-issues#41 is done
-	return VerifyTokenHack(ctx, t.AccessToken)
-
-	verifier := ctx.Value(cx.Verifier).(*oidc.IDTokenVerifier)
-
-	idToken, err := verifier.Verify(ctx, t.AccessToken)
-	if err != nil {
-		log.Printf("failed to verify token: %+v", err)
-		return charID, owner, err
-	}
-
-	// Extract custom claims
-	var claims struct {
-		Scopes    []string `json:"scp"`
-		Subject   string   `json:"sub"`
-		OwnerHash string   `json:"owner"`
-	}
-	// TODO: verify scopes
-	if claimErr := idToken.Claims(&claims); claimErr != nil {
-		log.Printf("failed to parse claims from JWT: %+v", claimErr)
-		return charID, owner, err
-	}
-
-	char, err := parseCharacterID(claims.Subject)
-	if err != nil {
-		log.Printf("failed to parse characterID from claim data: %+v", err)
-		return char, owner, err
+// missingScopes returns the required scopes which were not granted
+func missingScopes(granted []string) []string {
+	missing := []string{}
+	for _, scope := range requiredScopes {
+		if !inStrings(scope, granted) {
+			missing = append(missing, scope)
+		}
 	}
-
-	return char, claims.OwnerHash, nil
+	return missing
 }
 
 func parseCharacterID(sub string) (int32, error) {
@@ -232,39 +343,3 @@ func parseCharacterID(sub string) (int32, error) {
 	charID, err := strconv.ParseInt(subSplit[2], 10, 32)
 	return int32(charID), err
 }
-
-// VerifyModel is needed as a hack until an sso issue is fixed
-type VerifyModel struct {
-	CharacterID        int32
-	CharacterOwnerHash string
-}
-
-// VerifyTokenHack pulls the charID and owner hash from the JWT
-func VerifyTokenHack(ctx context.Context, token string) (
-	charID int32,
-	owner string,
-	err error,
-) {
-	client := ctx.Value(cx.SSOClient).(*http.Client)
-	res, err := client.Get(
-		fmt.Sprintf("https://esi.evetech.net/verify/?token=%s", token),
-	)
-	if err != nil {
-		log.Printf("failed to verify token (HACK): %+v", err)
-		return 0, "", err
-	}
-
-	model := &VerifyModel{}
-	dec := json.NewDecoder(res.Body)
-
-	if err := dec.Decode(model); err != nil {
-		log.Printf("failed to JSON decode verify response: %+v", err)
-		return 0, "", err
-	}
-
-	if err := res.Body.Close(); err != nil {
-		log.Printf("failed to close response body: %+v", err)
-	}
-
-	return model.CharacterID, model.CharacterOwnerHash, nil
-}
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func testAuthContext() context.Context {
+	return context.WithValue(context.Background(), cx.Opts, &cx.Options{
+		AppSecret: "test-secret",
+		Auth: &oauth2.Config{
+			ClientID: "client-id",
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  "https://login.eveonline.com/v2/oauth/authorize",
+				TokenURL: "https://login.eveonline.com/v2/oauth/token",
+			},
+		},
+	})
+}
+
+// login runs the login handler and returns the state and state cookie
+func login(t *testing.T, ctx context.Context, target string) (string, *http.Cookie) {
+	w := httptest.NewRecorder()
+	NewLogin(ctx)(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+	if w.Code != 302 {
+		t.Fatalf("expected login redirect, received %d", w.Code)
+	}
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid login redirect: %+v", err)
+	}
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == stateCookie {
+			if !cookie.HttpOnly {
+				t.Error("state cookie is not HttpOnly")
+			}
+			return location.Query().Get("state"), cookie
+		}
+	}
+
+	t.Fatal("login did not set a state cookie")
+	return "", nil
+}
+
+func callbackRequest(state string, cookie *http.Cookie) *http.Request {
+	r := httptest.NewRequest(
+		http.MethodGet,
+		"/callback?code=abc&state="+url.QueryEscape(state),
+		nil,
+	)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestCallbackMissingState(t *testing.T) {
+	ctx := testAuthContext()
+	_, cookie := login(t, ctx, "/signup")
+
+	for name, r := range map[string]*http.Request{
+		"no state":  callbackRequest("", cookie),
+		"no cookie": callbackRequest("some-state", nil),
+	} {
+		w := httptest.NewRecorder()
+		Callback(ctx)(w, r)
+		if w.Code != 400 {
+			t.Errorf("%s: expected 400, received %d", name, w.Code)
+		}
+	}
+}
+
+func TestCallbackTamperedState(t *testing.T) {
+	ctx := testAuthContext()
+	state, cookie := login(t, ctx, "/signup")
+
+	w := httptest.NewRecorder()
+	Callback(ctx)(w, callbackRequest(state+"x", cookie))
+	if w.Code != 400 {
+		t.Errorf("mismatched state: expected 400, received %d", w.Code)
+	}
+
+	tampered := *cookie
+	tampered.Value = "e30." + cookie.Value[len(cookie.Value)-10:]
+	w = httptest.NewRecorder()
+	Callback(ctx)(w, callbackRequest(state, &tampered))
+	if w.Code != 400 {
+		t.Errorf("tampered cookie: expected 400, received %d", w.Code)
+	}
+
+	// a cookie signed with another secret is rejected
+	other := context.WithValue(ctx, cx.Opts, &cx.Options{
+		AppSecret: "other-secret",
+		Auth:      ctx.Value(cx.Opts).(*cx.Options).Auth,
+	})
+	otherState, otherCookie := login(t, other, "/signup")
+	w = httptest.NewRecorder()
+	Callback(ctx)(w, callbackRequest(otherState, otherCookie))
+	if w.Code != 400 {
+		t.Errorf("foreign cookie: expected 400, received %d", w.Code)
+	}
+}
+
+func TestCheckStateHappyPath(t *testing.T) {
+	ctx := testAuthContext()
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+
+	state, cookie := login(t, ctx, "/signup?next=/%23char%26c=1")
+	checked, err := checkState(opts, callbackRequest(state, cookie))
+	if err != nil {
+		t.Fatalf("valid state was rejected: %+v", err)
+	}
+	if checked.Next != "/#char&c=1" {
+		t.Errorf("invalid next path: %q", checked.Next)
+	}
+}
+
+func TestCallbackPKCE(t *testing.T) {
+	// the fake token endpoint only redeems the code with the verifier of the
+	// challenge it was authorized with, as SSO does. oauth2 may retry a
+	// rejected exchange with the client credentials sent another way, so the
+	// attempts are counted by verifier
+	challenge := ""
+	verifiers := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := r.ParseForm(); err == nil {
+			verifiers[r.PostForm.Get("code_verifier")] = true
+		}
+		if r.PostForm.Get("code") != "abc" ||
+			pkceChallenge(r.PostForm.Get("code_verifier")) != challenge {
+			w.WriteHeader(400)
+			if _, err := w.Write([]byte(`{"error":"invalid_grant"}`)); err != nil {
+				t.Errorf("failed to write response: %+v", err)
+			}
+			return
+		}
+		if _, err := w.Write([]byte(`{"access_token":"a","token_type":"Bearer"}`)); err != nil {
+			t.Errorf("failed to write response: %+v", err)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.WithValue(context.Background(), cx.Opts, &cx.Options{
+		AppSecret: "test-secret",
+		Auth: &oauth2.Config{
+			ClientID: "client-id",
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  "https://login.eveonline.com/v2/oauth/authorize",
+				TokenURL: server.URL,
+			},
+		},
+	})
+	ctx = context.WithValue(ctx, cx.Verifier, NewJWKS(server.Client(), ""))
+
+	w := httptest.NewRecorder()
+	NewLogin(ctx)(w, httptest.NewRequest(http.MethodGet, "/signup", nil))
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid login redirect: %+v", err)
+	}
+	query := location.Query()
+	if method := query.Get("code_challenge_method"); method != "S256" {
+		t.Errorf("expected S256 code challenge, received %q", method)
+	}
+	challenge = query.Get("code_challenge")
+	if len(challenge) != 43 {
+		t.Fatalf("invalid code challenge: %q", challenge)
+	}
+	state := query.Get("state")
+	cookie := w.Result().Cookies()[0]
+
+	// another login attempt can't redeem the code, lacking the verifier
+	otherState, otherCookie := login(t, ctx, "/signup")
+	w = httptest.NewRecorder()
+	Callback(ctx)(w, callbackRequest(otherState, otherCookie))
+	if w.Code != 500 ||
+		!strings.Contains(w.Body.String(), "Failed to complete logging in") {
+		t.Errorf("expected the exchange to fail, received %d", w.Code)
+	}
+
+	// the exchange succeeds, the fake access token then fails verification
+	w = httptest.NewRecorder()
+	Callback(ctx)(w, callbackRequest(state, cookie))
+	if w.Code != 500 ||
+		!strings.Contains(w.Body.String(), "Failed to verify") {
+		t.Errorf("expected the exchange to succeed, received %d", w.Code)
+	}
+
+	if len(verifiers) != 2 {
+		t.Errorf("expected exchanges of 2 verifiers, received %d", len(verifiers))
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	cases := map[string]string{
+		"":                         "",
+		"/":                        "/",
+		"/#prefs&c=1":              "/#prefs&c=1",
+		"/api/char?c=1":            "/api/char?c=1",
+		"//evil.example.com":       "",
+		"/\\evil.example.com":      "",
+		"https://evil.example.com": "",
+		"javascript:alert(1)":      "",
+		"relative/path":            "",
+	}
+
+	for next, expected := range cases {
+		if received := localPath(next); received != expected {
+			t.Errorf("localPath(%q): received %q, expected %q", next, received, expected)
+		}
+	}
+}
+
+func TestMissingScopes(t *testing.T) {
+	wallet := "esi-wallet.read_character_wallet.v1"
+	contracts := "esi-contracts.read_character_contracts.v1"
+
+	for name, tc := range map[string]struct {
+		granted, missing []string
+	}{
+		"none":      {nil, []string{wallet, contracts}},
+		"wallet":    {[]string{wallet}, []string{contracts}},
+		"contracts": {[]string{contracts, "publicData"}, []string{wallet}},
+		"all":       {[]string{contracts, "publicData", wallet}, []string{}},
+	} {
+		if missing := missingScopes(tc.granted); !reflect.DeepEqual(
+			missing,
+			tc.missing,
+		) {
+			t.Errorf("%s: expected %v missing, received %v",
+				name, tc.missing, missing)
+		}
+	}
+}
+
+func TestSSOUserAgent(t *testing.T) {
+	agents := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		agents = append(agents, r.Header.Get("User-Agent"))
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(`{"access_token":"a","token_type":"Bearer"}`)); err != nil {
+			t.Errorf("failed to write response: %+v", err)
+		}
+	}))
+	defer server.Close()
+
+	ctx := NewProvider(context.WithValue(context.Background(), cx.Opts, &cx.Options{
+		UserAgent: "esi-isk/test",
+	}))
+	client := ctx.Value(cx.SSOClient).(*http.Client)
+	if ctx.Value(cx.Verifier).(*JWKS).client != client {
+		t.Error("verifier does not use the SSO client")
+	}
+
+	// token revocation
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %+v", err)
+	}
+	if err := res.Body.Close(); err != nil {
+		t.Fatalf("failed to close response body: %+v", err)
+	}
+
+	// login token exchange
+	conf := &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: server.URL}}
+	if _, err := conf.Exchange(ssoContext(ctx), "code"); err != nil {
+		t.Fatalf("token exchange failed: %+v", err)
+	}
+
+	if len(agents) != 2 {
+		t.Fatalf("expected 2 requests, received %d", len(agents))
+	}
+	for _, agent := range agents {
+		if agent != "esi-isk/test" {
+			t.Errorf("unexpected User-Agent %q", agent)
+		}
+	}
+}
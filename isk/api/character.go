@@ -2,39 +2,124 @@ package api
 
 import (
 	"context"
-	"log"
+	"errors"
 	"net/http"
 	"strconv"
 
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/cx"
 	"github.com/a-tal/esi-isk/isk/db"
 )
 
 // CharacterDetails returns JSON describing the character
 func CharacterDetails(ctx context.Context) http.HandlerFunc {
+	return characterDetails(ctx, detailsV1)
+}
+
+// characterDetails writes the character details in the shape of adapt
+func characterDetails(
+	ctx context.Context,
+	adapt func(*db.CharDetails) interface{},
+) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
 		charID, err := getCharID(r)
 		if err != nil || charID < 1 {
-			write400(w)
+			write400(w, r, "invalid character ID")
 			return
 		}
 
 		c, err := db.GetCharDetails(ctx, charID)
 		if err != nil {
-			log.Printf("failed to get character details: %+v", err)
-			write500(w)
+			writeDBError(w, r, err)
+			return
+		}
+
+		if hiddenFrom(r, c.Character) {
+			writeDBError(w, r, db.ErrCharacterNotFound)
 			return
 		}
 
 		p, err := db.GetPreferences(ctx, "d", charID)
 		if err == nil {
 			if pErr := checkPassphrase(r, c, p); pErr != nil {
-				write403(w)
+				write403(w, r)
 				return
 			}
 		}
 
-		writeJSON(ctx, w, c)
+		if err := maskAnonymous(ctx, r, c); err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		if sessionCharacter(r) == charID {
+			// the owner is watching, poll them at the fast interval
+			if err := db.BumpPoll(ctx, charID); err != nil {
+				cx.Logf(ctx, "failed to bump poll of %d: %+v", charID, err)
+			}
+
+			// only the owner sees what is waiting for them
+			c.PendingContracts, err = db.GetPendingContracts(ctx, charID)
+			if err != nil {
+				write500(w, r, err)
+				return
+			}
+		}
+
+		cache.Tag(w, cache.CharacterTag(charID))
+		writeJSON(ctx, w, adapt(c))
+	}
+}
+
+// hiddenFrom is true if the character is banned, or is hidden and is not
+// the one asking
+func hiddenFrom(r *http.Request, c *db.Character) bool {
+	return c.Banned || c.Hidden && sessionCharacter(r) != c.ID
+}
+
+// checkCharacterAccess writes an error and returns false if the character is
+// unknown, hidden, banned, or the request lacks the passphrase of their character
+// details
+func checkCharacterAccess(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	charID int32,
+) bool {
+	c, err := db.GetCharacter(ctx, charID)
+	if err != nil {
+		writeDBError(w, r, err)
+		return false
+	}
+
+	if hiddenFrom(r, c) {
+		writeDBError(w, r, db.ErrCharacterNotFound)
+		return false
+	}
+
+	p, err := db.GetPreferences(ctx, "d", charID)
+	if err == nil {
+		if pErr := checkPassphrase(r, &db.CharDetails{Character: c}, p); pErr != nil {
+			write403(w, r)
+			return false
+		}
+	}
+
+	return true
+}
+
+// getPathCharID reads the character ID of /api/char/{id}/... routes
+func getPathCharID(r *http.Request) (int32, error) {
+	charID, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		return 0, err
 	}
+	if charID < 1 {
+		return 0, errors.New("invalid character ID")
+	}
+	return int32(charID), nil
 }
 
 // getCharID reads the "c" query arg
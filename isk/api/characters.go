@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// maxBulkCharacters is the most characters one bulk lookup can request
+const maxBulkCharacters = 500
+
+var (
+	errBadCharacterIDs    = errors.New("expected a JSON array of character IDs")
+	errBulkCharacterCount = fmt.Errorf(
+		"between 1 and %d character IDs are required",
+		maxBulkCharacters,
+	)
+)
+
+// bulkCharacters is the response of a bulk character lookup
+type bulkCharacters struct {
+	Characters []*db.Character `json:"characters"`
+	Missing    []int32         `json:"missing"`
+}
+
+// Characters returns the summaries of a JSON array of character IDs
+func Characters(ctx context.Context) http.HandlerFunc {
+	return characters(ctx, charactersV1)
+}
+
+// characters writes the character summaries in the shape of adapt
+func characters(
+	ctx context.Context,
+	adapt func(*bulkCharacters) interface{},
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodPost {
+			write405(w, r)
+			return
+		}
+
+		ids, err := readCharacterIDs(w, r)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		characters, err := db.GetCharacters(ctx, ids)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		writeJSONFor(w, adapt(newBulkCharacters(ids, characters)), 0)
+	}
+}
+
+// readCharacterIDs reads the unique character IDs of the request body
+func readCharacterIDs(w http.ResponseWriter, r *http.Request) ([]int32, error) {
+	raw := []int32{}
+	body := http.MaxBytesReader(w, r.Body, maxBulkCharacters*16)
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return nil, errBadCharacterIDs
+	}
+
+	ids := []int32{}
+	seen := map[int32]bool{}
+	for _, id := range raw {
+		if id < 1 {
+			return nil, errBadCharacterIDs
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 || len(ids) > maxBulkCharacters {
+		return nil, errBulkCharacterCount
+	}
+	return ids, nil
+}
+
+// newBulkCharacters orders the characters as requested, listing the missing
+func newBulkCharacters(ids []int32, characters []*db.Character) *bulkCharacters {
+	found := map[int32]*db.Character{}
+	for _, char := range characters {
+		found[char.ID] = char
+	}
+
+	res := &bulkCharacters{
+		Characters: []*db.Character{},
+		Missing:    []int32{},
+	}
+	for _, id := range ids {
+		if char, ok := found[id]; ok {
+			res.Characters = append(res.Characters, char)
+		} else {
+			res.Missing = append(res.Missing, id)
+		}
+	}
+	return res
+}
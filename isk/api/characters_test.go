@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+func TestReadCharacterIDs(t *testing.T) {
+	tooMany := []string{}
+	for i := 1; i <= maxBulkCharacters+1; i++ {
+		tooMany = append(tooMany, fmt.Sprintf("%d", 90000000+i))
+	}
+
+	for body, valid := range map[string]bool{
+		`[1, 2, 3]`:                            true,
+		`[2114454465, 2114454465]`:             true,
+		`[]`:                                   false,
+		`{"ids": [1]}`:                         false,
+		`[0]`:                                  false,
+		`[-5]`:                                 false,
+		`["1"]`:                                false,
+		`[` + strings.Join(tooMany, ",") + `]`: false,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api/chars", strings.NewReader(body))
+		ids, err := readCharacterIDs(httptest.NewRecorder(), r)
+		if valid && err != nil {
+			t.Errorf("%.20s: unexpected error: %+v", body, err)
+		} else if !valid && err == nil {
+			t.Errorf("%.20s: expected an error, read %v", body, ids)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/chars", strings.NewReader(`[3, 1, 3]`))
+	ids, _ := readCharacterIDs(httptest.NewRecorder(), r)
+	if len(ids) != 2 || ids[0] != 3 || ids[1] != 1 {
+		t.Errorf("duplicate IDs were not removed in order: %v", ids)
+	}
+}
+
+func TestNewBulkCharacters(t *testing.T) {
+	res := newBulkCharacters(
+		[]int32{3, 1, 2},
+		[]*db.Character{{ID: 1}, {ID: 3}},
+	)
+
+	if len(res.Characters) != 2 || res.Characters[0].ID != 3 || res.Characters[1].ID != 1 {
+		t.Errorf("characters are not in request order: %+v", res.Characters)
+	}
+	if len(res.Missing) != 1 || res.Missing[0] != 2 {
+		t.Errorf("invalid missing IDs: %v", res.Missing)
+	}
+}
+
+func TestCharactersMethod(t *testing.T) {
+	w := httptest.NewRecorder()
+	Characters(testAuthContext())(w, httptest.NewRequest(http.MethodGet, "/api/chars", nil))
+	if w.Code != 405 {
+		t.Errorf("expected 405, received %d", w.Code)
+	}
+}
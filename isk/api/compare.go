@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// comparison is the response of a character compare
+type comparison struct {
+	A    *db.Character     `json:"a"`
+	B    *db.Character     `json:"b"`
+	AToB *db.TransferTotal `json:"a_to_b"`
+	BToA *db.TransferTotal `json:"b_to_a"`
+}
+
+// Compare returns the summaries of two characters and what each gave the other
+func Compare(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		a, aErr := strconv.ParseInt(r.URL.Query().Get("a"), 10, 32)
+		b, bErr := strconv.ParseInt(r.URL.Query().Get("b"), 10, 32)
+		if aErr != nil || bErr != nil || a < 1 || b < 1 {
+			write400(w, r, "invalid character ID")
+			return
+		}
+		if a == b {
+			write400(w, r, "can not compare a character with themselves")
+			return
+		}
+
+		characters, err := db.GetCharacters(ctx, []int32{int32(a), int32(b)})
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		res := newBulkCharacters([]int32{int32(a), int32(b)}, characters)
+		if len(res.Missing) > 0 {
+			write404(w, r, "character not found")
+			return
+		}
+
+		aToB, bToA, err := db.GetTransferTotals(ctx, int32(a), int32(b))
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		cache.Tag(w, cache.CharacterTag(int32(a)), cache.CharacterTag(int32(b)))
+		writeJSON(ctx, w, &comparison{
+			A:    res.Characters[0],
+			B:    res.Characters[1],
+			AToB: aToB,
+			BToA: bToA,
+		})
+	}
+}
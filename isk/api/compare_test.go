@@ -0,0 +1,23 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompareValidation(t *testing.T) {
+	for _, target := range []string{
+		"/api/compare",
+		"/api/compare?a=1",
+		"/api/compare?a=1&b=abc",
+		"/api/compare?a=-1&b=2",
+		"/api/compare?a=2&b=2",
+	} {
+		w := httptest.NewRecorder()
+		Compare(testAuthContext())(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != 400 {
+			t.Errorf("%s: expected 400, received %d", target, w.Code)
+		}
+	}
+}
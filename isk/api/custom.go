@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 	"sort"
 	"strings"
@@ -14,22 +13,29 @@ import (
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
 
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/cx"
 	"github.com/a-tal/esi-isk/isk/db"
 )
 
 // Custom character view, defined by character preferences
 func Custom(ctx context.Context) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
 		charID, err := getCharID(r)
 		if err != nil || charID < 1 {
-			write400(w)
+			write400(w, r, "invalid character ID")
 			return
 		}
 
 		c, err := db.GetCharDetails(ctx, charID)
 		if err != nil {
-			log.Printf("failed to get character details: %+v", err)
-			write500(w)
+			writeDBError(w, r, err)
+			return
+		}
+		if c.Character.Banned {
+			writeDBError(w, r, db.ErrCharacterNotFound)
 			return
 		}
 
@@ -40,20 +46,27 @@ func Custom(ctx context.Context) http.HandlerFunc {
 		}
 
 		if pErr := checkPassphrase(r, c, p); pErr != nil {
-			write403(w)
+			write403(w, r)
+			return
+		}
+
+		if err := maskAnonymous(ctx, r, c); err != nil {
+			write500(w, r, err)
 			return
 		}
 
 		header, rows, footer, err := buildTemplates(c)
 		if err != nil {
-			write500(w)
+			write500(w, r, err)
 			return
 		}
 
+		cache.Tag(w, cache.CharacterTag(charID))
 		writeCacheHeaders(ctx, w)
 
 		if wErr := writeTemplates(ctx, w, header, rows, footer, c, p); wErr != nil {
-			write400(w)
+			// some of the response may already be written
+			cx.Logf(ctx, "failed to write custom templates: %+v", wErr)
 		}
 	}
 }
@@ -93,17 +106,10 @@ func writeTemplates(
 		return err
 	}
 
-	var rowErr error
-	if p.Contracts != nil && p.Donations != nil {
-		rowErr = writeRowsMultiple(ctx, w, rows, c, p)
-	} else if p.Contracts != nil {
-		rowErr = writeRowsSingular(ctx, w, rows, c, p.Contracts, "c")
-	} else {
-		rowErr = writeRowsSingular(ctx, w, rows, c, p.Donations, "d")
-	}
-
-	if rowErr != nil {
-		return rowErr
+	for _, row := range viewRows(ctx, c, p) {
+		if err := rows.ExecuteTemplate(w, "T", row.str); err != nil {
+			return err
+		}
 	}
 
 	return writeFooter(w, p, footer)
@@ -133,41 +139,28 @@ func writeFooter(
 	return t.ExecuteTemplate(w, "T", p.Contracts.Footer)
 }
 
-func writeRowsSingular(
+// viewRows returns the row patterns of the donation, contract or combined view
+func viewRows(
 	ctx context.Context,
-	w http.ResponseWriter,
-	rows *template.Template,
 	c *db.CharDetails,
-	p *db.Prefs,
-	t string,
-) error {
-	for _, pattern := range getRowPatterns(ctx, c, p, t) {
-		if err := rows.ExecuteTemplate(w, "T", pattern.str); err != nil {
-			return err
-		}
+	p *db.Preferences,
+) rowPatterns {
+	if p.Contracts == nil {
+		return getRowPatterns(ctx, c, p.Donations, "d")
+	} else if p.Donations == nil {
+		return getRowPatterns(ctx, c, p.Contracts, "c")
 	}
-	return nil
-}
 
-func writeRowsMultiple(
-	ctx context.Context,
-	w http.ResponseWriter,
-	rows *template.Template,
-	c *db.CharDetails,
-	p *db.Preferences,
-) error {
 	rp := rowPatterns{}
 	rp = append(rp, getRowPatterns(ctx, c, p.Donations, "d")...)
 	rp = append(rp, getRowPatterns(ctx, c, p.Contracts, "c")...)
 
 	sort.Sort(rp)
 
-	for i := 0; i < p.Donations.Rows && i < len(rp); i++ {
-		if err := rows.ExecuteTemplate(w, "T", rp[i].str); err != nil {
-			return err
-		}
+	if len(rp) > p.Donations.Rows {
+		rp = rp[:p.Donations.Rows]
 	}
-	return nil
+	return rp
 }
 
 type rowPatterns []*rowPattern
@@ -177,7 +170,7 @@ func (r rowPatterns) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
 func (r rowPatterns) Less(i, j int) bool { return r[i].ts.After(r[j].ts) }
 
 type rowPattern struct {
-	str string
+	str template.HTML
 	ts  time.Time
 }
 
@@ -190,7 +183,7 @@ func getRowPatterns(
 	patterns := rowPatterns{}
 	index := 0
 	for i := 0; i < p.Rows; i++ {
-		var pattern string
+		var pattern template.HTML
 		var err error
 		var ts time.Time
 		pattern, ts, index, err = getRowPattern(ctx, c, p, t, index)
@@ -215,7 +208,7 @@ func getRowPattern(
 	p *db.Prefs,
 	t string,
 	i int,
-) (string, time.Time, int, error) {
+) (template.HTML, time.Time, int, error) {
 	switch t {
 
 	case "d", "":
@@ -249,7 +242,7 @@ func getValidDonation(c *db.CharDetails, p *db.Prefs, i int) (
 		if i >= len(c.Donations) {
 			return nil, i, errors.New("index out of bounds")
 		}
-		if c.Donations[i].Amount >= p.Minimum {
+		if c.Donations[i].Amount >= db.NewISK(p.Minimum) {
 			break
 		}
 		i++
@@ -266,7 +259,7 @@ func getValidContract(c *db.CharDetails, p *db.Prefs, i int) (
 		if i >= len(c.Contracts) {
 			return nil, i, errors.New("index out of bounds")
 		}
-		if c.Contracts[i].Value >= p.Minimum {
+		if c.Contracts[i].Value >= db.NewISK(p.Minimum) {
 			break
 		}
 		i++
@@ -274,7 +267,9 @@ func getValidContract(c *db.CharDetails, p *db.Prefs, i int) (
 	return c.Contracts[i], i, nil
 }
 
-func stdReplacements(isk float64, t time.Time) map[string]string {
+func stdReplacements(amount db.ISK, t time.Time) map[string]string {
+	isk := amount.Float64()
+	t = t.UTC()
 	printer := message.NewPrinter(language.English)
 	ampmHour, ampm := asAMPM(t.Hour())
 	return map[string]string{
@@ -320,23 +315,26 @@ func getDonationRow(
 	c *db.CharDetails,
 	p *db.Prefs,
 	d *db.Donation,
-) (string, error) {
+) (template.HTML, error) {
 	donator, err := db.GetName(ctx, d.Donator)
 	if err != nil {
 		return "", err
 	}
 
+	if db.IsTemplate(p.Pattern) {
+		values := tokenValues(
+			donator, d.Amount, d.Note, d.Timestamp, time.Now().UTC(),
+		)
+		values["corp"] = donorCorporation(ctx, d.Donator)
+		return renderRow(p.Pattern, values), nil
+	}
+
 	replacements := stdReplacements(d.Amount, d.Timestamp)
 	replacements["%NAME%"] = c.Character.Name
 	replacements["%CHARACTER%"] = donator
 	replacements["%NOTE%"] = d.Note
 
-	pattern := p.Pattern
-	for search, replace := range replacements {
-		pattern = strings.Replace(pattern, search, replace, -1)
-	}
-
-	return pattern, nil
+	return replaceRow(p.Pattern, replacements), nil
 }
 
 func asAMPM(hour int) (int, string) {
@@ -362,24 +360,45 @@ func getContractRow(
 	c *db.CharDetails,
 	p *db.Prefs,
 	k *db.Contract,
-) (string, error) {
+) (template.HTML, error) {
 	contractor, err := db.GetName(ctx, k.Donator)
 	if err != nil {
 		return "", err
 	}
 
+	if db.IsTemplate(p.Pattern) {
+		values := tokenValues(
+			contractor, k.Value, k.Note, k.Issued, time.Now().UTC(),
+		)
+		values["corp"] = donorCorporation(ctx, k.Donator)
+		return renderRow(p.Pattern, values), nil
+	}
+
 	replacements := stdReplacements(k.Value, k.Issued)
 	replacements["%NAME%"] = c.Character.Name
 	replacements["%CHARACTER%"] = contractor
 	replacements["%NOTE%"] = k.Note
 	replacements["%ITEMS%"] = fmt.Sprintf("%d", len(k.Items))
 
-	pattern := p.Pattern
+	return replaceRow(p.Pattern, replacements), nil
+}
+
+// replaceRow substitutes the %KEYWORD%s of a pattern and escapes the result
+func replaceRow(pattern string, replacements map[string]string) template.HTML {
 	for search, replace := range replacements {
 		pattern = strings.Replace(pattern, search, replace, -1)
 	}
+	return template.HTML(template.HTMLEscapeString(pattern))
+}
 
-	return pattern, nil
+// renderRow renders a {{token}} pattern, falling back to escaping the pattern
+// as is if it was stored before template patterns were checked
+func renderRow(pattern string, values map[string]string) template.HTML {
+	row, err := db.RenderPattern(pattern, values)
+	if err != nil {
+		return template.HTML(template.HTMLEscapeString(pattern))
+	}
+	return template.HTML(row)
 }
 
 func buildTemplates(c *db.CharDetails) (
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// DonorCorporations returns the ISK the character received by the
+// corporation of the donators, the top corporations and the rest summed
+func DonorCorporations(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		charID, err := getPathCharID(r)
+		if err != nil {
+			write400(w, r, "invalid character ID")
+			return
+		}
+
+		if !checkCharacterAccess(ctx, w, r, charID) {
+			return
+		}
+
+		res, err := db.GetDonorCorporations(ctx, charID)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		cache.Tag(w, cache.CharacterTag(charID))
+		writeJSON(ctx, w, res)
+	}
+}
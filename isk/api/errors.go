@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// Error codes of the JSON error envelope, for clients to switch on
+const (
+	ErrCodeBadRequest       = "bad_request"
+	ErrCodeForbidden        = "forbidden"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeConflict         = "conflict"
+	ErrCodeRateLimited      = "rate_limited"
+	ErrCodeInternal         = "internal_error"
+)
+
+// apiError is the content of the error envelope
+type apiError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// errorEnvelope is the body of every error response
+type errorEnvelope struct {
+	Error *apiError `json:"error"`
+}
+
+// codes maps statuses to their default error code
+var codes = map[int]string{
+	400: ErrCodeBadRequest,
+	403: ErrCodeForbidden,
+	404: ErrCodeNotFound,
+	405: ErrCodeMethodNotAllowed,
+	409: ErrCodeConflict,
+	429: ErrCodeRateLimited,
+	500: ErrCodeInternal,
+}
+
+// writeError writes {"error": {...}} with the status
+func writeError(
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	code, message string,
+) {
+	body, err := json.Marshal(&errorEnvelope{Error: &apiError{
+		Code:      code,
+		Message:   message,
+		RequestID: requestID(r),
+	}})
+	if err != nil {
+		// this should never happen
+		log.Printf("failed to marshal error: %+v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	write(w, status, body)
+}
+
+func write400(w http.ResponseWriter, r *http.Request, message string) {
+	writeError(w, r, 400, ErrCodeBadRequest, message)
+}
+
+func write403(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, 403, ErrCodeForbidden, "request denied")
+}
+
+func write404(w http.ResponseWriter, r *http.Request, message string) {
+	writeError(w, r, 404, ErrCodeNotFound, message)
+}
+
+func write405(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, 405, ErrCodeMethodNotAllowed, "method not allowed")
+}
+
+func write429(w http.ResponseWriter, r *http.Request, message string) {
+	writeError(w, r, 429, ErrCodeRateLimited, message)
+}
+
+// write500 logs the error, which is not passed on to the client
+func write500(w http.ResponseWriter, r *http.Request, err error) {
+	cx.Logf(r.Context(), "%s %s: %+v", r.Method, r.URL.Path, err)
+	writeError(w, r, 500, ErrCodeInternal, "internal error")
+}
+
+// writeDBError writes the appropriate response for an error from the db
+func writeDBError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == db.ErrCharacterNotFound {
+		write404(w, r, "character not found")
+	} else if ue, ok := err.(db.UserError); ok {
+		code, found := codes[ue.Code]
+		if !found {
+			code = ErrCodeInternal
+		}
+		writeError(w, r, ue.Code, code, string(ue.Msg))
+	} else {
+		write500(w, r, err)
+	}
+}
+
+// requestID returns the ID of the request, if it has one
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(cx.RequestID).(string)
+	return id
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// readError returns the error envelope of the response
+func readError(t *testing.T, w *httptest.ResponseRecorder) *apiError {
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("error is not JSON: %q", w.Header().Get("Content-Type"))
+	}
+	body := map[string]*apiError{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid error envelope: %+v\n%s", err, w.Body.String())
+	}
+	if body["error"] == nil {
+		t.Fatalf("missing error envelope: %s", w.Body.String())
+	}
+	return body["error"]
+}
+
+func TestWriteDBError(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{db.ErrCharacterNotFound, 404, ErrCodeNotFound},
+		{db.UserError{Msg: []byte("too long"), Code: 400}, 400, ErrCodeBadRequest},
+		{errors.New("pq: connection refused"), 500, ErrCodeInternal},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		writeDBError(w, httptest.NewRequest(http.MethodGet, "/api/char", nil), c.err)
+
+		if w.Code != c.status {
+			t.Errorf("%v: received %d, expected %d", c.err, w.Code, c.status)
+		}
+		if e := readError(t, w); e.Code != c.code {
+			t.Errorf("%v: received code %s, expected %s", c.err, e.Code, c.code)
+		}
+	}
+}
+
+func TestWrite500HidesDetail(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/top", nil)
+	r = r.WithContext(context.WithValue(r.Context(), cx.RequestID, "abc123"))
+
+	w := httptest.NewRecorder()
+	write500(w, r, errors.New("pq: password authentication failed"))
+
+	e := readError(t, w)
+	if e.Message != "internal error" {
+		t.Errorf("error detail was leaked: %q", e.Message)
+	}
+	if e.RequestID != "abc123" {
+		t.Errorf("invalid request ID: %q", e.RequestID)
+	}
+}
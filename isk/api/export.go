@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// exportInterval is how often each character may export their data
+const exportInterval = 1 * time.Hour
+
+// tokenMetadata is what we export about a stored token, never the token
+type tokenMetadata struct {
+	AccessExpires  time.Time  `json:"access_expires"`
+	LastProcessed  *time.Time `json:"last_processed"`
+	LastJournalID  int64      `json:"last_journal_id,omitempty"`
+	LastContractID int64      `json:"last_contract_id,omitempty"`
+	Revoked        bool       `json:"revoked"`
+	Scopes         []string   `json:"scopes"`
+}
+
+// exportLimiter remembers when each character last exported their data
+type exportLimiter struct {
+	lock *sync.Mutex
+	last map[int32]time.Time
+}
+
+// allow returns true and records the export if the character may export now
+func (l *exportLimiter) allow(charID int32, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if last, found := l.last[charID]; found && now.Sub(last) < exportInterval {
+		return false
+	}
+
+	for id, last := range l.last {
+		if now.Sub(last) >= exportInterval {
+			delete(l.last, id)
+		}
+	}
+
+	l.last[charID] = now
+	return true
+}
+
+// exportWriter streams a JSON document, keeping the first error
+type exportWriter struct {
+	w   io.Writer
+	enc *json.Encoder
+	err error
+}
+
+func (e *exportWriter) raw(s string) {
+	if e.err == nil {
+		_, e.err = io.WriteString(e.w, s)
+	}
+}
+
+func (e *exportWriter) value(v interface{}) {
+	if e.err == nil {
+		e.err = e.enc.Encode(v)
+	}
+}
+
+// list streams the items passed to add by each as a JSON array
+func (e *exportWriter) list(each func(add func(interface{}) error) error) {
+	e.raw("[")
+	first := true
+	err := each(func(v interface{}) error {
+		if !first {
+			e.raw(",")
+		}
+		first = false
+		e.value(v)
+		return e.err
+	})
+	if e.err == nil {
+		e.err = err
+	}
+	e.raw("]")
+}
+
+// Export streams everything stored about the logged in character
+func Export(ctx context.Context) http.HandlerFunc {
+	limiter := &exportLimiter{lock: &sync.Mutex{}, last: map[int32]time.Time{}}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		charID := sessionCharacter(r)
+		if charID == 0 {
+			write403(w, r)
+			return
+		}
+
+		if !limiter.allow(charID, time.Now()) {
+			w.Header().Set("Retry-After", fmt.Sprintf("%.0f", exportInterval.Seconds()))
+			write429(w, r, "data may only be exported once per hour")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(
+			"Content-Disposition",
+			fmt.Sprintf(`attachment; filename="esi-isk-%d.json"`, charID),
+		)
+
+		if err := writeExport(ctx, w, charID); err != nil {
+			// the status is already sent, all we can do is end the document early
+			cx.Logf(ctx, "failed to export character %d: %+v", charID, err)
+		}
+	}
+}
+
+func writeExport(ctx context.Context, w io.Writer, charID int32) error {
+	e := &exportWriter{w: w, enc: json.NewEncoder(w)}
+
+	e.raw(`{"character":`)
+	char, err := db.GetCharacter(ctx, charID)
+	if err != nil {
+		// characters which never sent or received ISK have no row
+		char = nil
+	}
+	e.value(char)
+
+	e.raw(`,"token":`)
+	var token *tokenMetadata
+	if user, err := db.GetUser(ctx, charID); err == nil {
+		token = &tokenMetadata{
+			AccessExpires:  user.AccessExpires.UTC(),
+			LastProcessed:  user.LastProcessed,
+			LastJournalID:  user.LastJournalID.Int64,
+			LastContractID: user.LastContractID.Int64,
+			Revoked:        user.Revoked,
+			Scopes:         user.Scopes,
+		}
+	}
+	e.value(token)
+
+	e.raw(`,"preferences":{`)
+	for i, t := range []string{"d", "c", "a"} {
+		if i > 0 {
+			e.raw(",")
+		}
+		e.raw(fmt.Sprintf("%q:", t))
+		prefs, err := db.GetPreferences(ctx, t, charID)
+		if err != nil {
+			prefs = nil
+		}
+		e.value(prefs)
+	}
+	e.raw("}")
+
+	for _, received := range []bool{true, false} {
+		key := "donations_sent"
+		if received {
+			key = "donations_received"
+		}
+		e.raw(fmt.Sprintf(",%q:", key))
+		e.list(func(add func(interface{}) error) error {
+			return db.EachDonation(ctx, charID, received, func(d *db.Donation) error {
+				return add(d)
+			})
+		})
+	}
+
+	for _, received := range []bool{true, false} {
+		key := "contracts_sent"
+		if received {
+			key = "contracts_received"
+		}
+		e.raw(fmt.Sprintf(",%q:", key))
+		e.list(func(add func(interface{}) error) error {
+			return db.EachContract(ctx, charID, received, func(c *db.Contract) error {
+				return add(c)
+			})
+		})
+	}
+
+	e.raw("}")
+	return e.err
+}
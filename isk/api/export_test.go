@@ -0,0 +1,67 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExportLimiter(t *testing.T) {
+	limiter := &exportLimiter{lock: &sync.Mutex{}, last: map[int32]time.Time{}}
+	now := time.Now()
+
+	if !limiter.allow(1, now) {
+		t.Error("first export was denied")
+	}
+	if limiter.allow(1, now.Add(59*time.Minute)) {
+		t.Error("second export within the hour was allowed")
+	}
+	if !limiter.allow(2, now.Add(time.Minute)) {
+		t.Error("export of another character was denied")
+	}
+	if !limiter.allow(1, now.Add(exportInterval)) {
+		t.Error("export after the interval was denied")
+	}
+}
+
+func TestExportWriterList(t *testing.T) {
+	buf := &bytes.Buffer{}
+	e := &exportWriter{w: buf, enc: json.NewEncoder(buf)}
+
+	e.raw(`{"a":`)
+	e.list(func(add func(interface{}) error) error {
+		for _, i := range []int{1, 2, 3} {
+			if err := add(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	e.raw(`,"b":`)
+	e.list(func(add func(interface{}) error) error { return nil })
+	e.raw("}")
+
+	if e.err != nil {
+		t.Fatalf("unexpected error: %+v", e.err)
+	}
+
+	doc := map[string][]int{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("export is not valid JSON: %+v\n%s", err, buf.String())
+	}
+	if len(doc["a"]) != 3 || len(doc["b"]) != 0 {
+		t.Errorf("invalid export: %+v", doc)
+	}
+
+	failed := &exportWriter{w: &bytes.Buffer{}}
+	failed.enc = json.NewEncoder(failed.w)
+	failed.list(func(add func(interface{}) error) error {
+		return errors.New("query failed")
+	})
+	if failed.err == nil {
+		t.Error("list did not keep the query error")
+	}
+}
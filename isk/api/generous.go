@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// generousCorporations is a page of the corporations whose members donated
+// the most over the window
+type generousCorporations struct {
+	*page
+	Window       string              `json:"window"`
+	Corporations []*db.GenerousGroup `json:"corporations"`
+}
+
+// generousAlliances is a page of the alliances whose members donated the
+// most over the window
+type generousAlliances struct {
+	*page
+	Window    string              `json:"window"`
+	Alliances []*db.GenerousGroup `json:"alliances"`
+}
+
+// GenerousCorporations returns the corporations whose members donated the
+// most over the window query arg, 30 days by default
+func GenerousCorporations(ctx context.Context) http.HandlerFunc {
+	return generous(ctx, db.GroupCorporation, func(
+		p *page,
+		window db.Window,
+		res []*db.GenerousGroup,
+	) interface{} {
+		return &generousCorporations{
+			page:         p,
+			Window:       window.String(),
+			Corporations: res,
+		}
+	})
+}
+
+// GenerousAlliances returns the alliances whose members donated the most
+// over the window query arg, 30 days by default
+func GenerousAlliances(ctx context.Context) http.HandlerFunc {
+	return generous(ctx, db.GroupAlliance, func(
+		p *page,
+		window db.Window,
+		res []*db.GenerousGroup,
+	) interface{} {
+		return &generousAlliances{
+			page:      p,
+			Window:    window.String(),
+			Alliances: res,
+		}
+	})
+}
+
+// generous writes a page of the group leaderboard in the shape of adapt
+func generous(
+	ctx context.Context,
+	group db.Group,
+	adapt func(*page, db.Window, []*db.GenerousGroup) interface{},
+) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		p, err := getPage(r)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		window, err := getWindow(r)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		res, err := db.GetGenerous(ctx, group, window, p.Limit, p.Offset)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		cache.Tag(w, cache.TopTag)
+		writeJSONFor(w, adapt(p, window, res), opts.TopCacheTime)
+	}
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGenerousValidation(t *testing.T) {
+	ctx := testAuthContext()
+
+	for path, h := range map[string]http.HandlerFunc{
+		"/api/leaderboard/corporations": GenerousCorporations(ctx),
+		"/api/leaderboard/alliances":    GenerousAlliances(ctx),
+	} {
+		for _, tc := range []struct {
+			method, query string
+			code          int
+		}{
+			{http.MethodPost, "", 405},
+			{http.MethodGet, "?limit=0", 400},
+			{http.MethodGet, "?offset=-1", 400},
+			{http.MethodGet, "?window=month", 400},
+			{http.MethodGet, "?window=14d", 400},
+		} {
+			w := httptest.NewRecorder()
+			h(w, httptest.NewRequest(tc.method, path+tc.query, nil))
+			if w.Code != tc.code {
+				t.Errorf("%s %s%s: expected %d, received %d",
+					tc.method, path, tc.query, tc.code, w.Code)
+			}
+		}
+	}
+}
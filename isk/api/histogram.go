@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// Histogram returns counts of the character's donations by size
+func Histogram(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		charID, err := getPathCharID(r)
+		if err != nil {
+			write400(w, r, "invalid character ID")
+			return
+		}
+
+		if !checkCharacterAccess(ctx, w, r, charID) {
+			return
+		}
+
+		histogram, err := db.GetHistogram(ctx, charID)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		cache.Tag(w, cache.CharacterTag(charID))
+		writeJSON(ctx, w, histogram)
+	}
+}
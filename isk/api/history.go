@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// LeaderboardHistory returns the leaderboard snapshot of a past month, given
+// as ?month=2018-11, defaulting to last month
+func LeaderboardHistory(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		month := db.MonthStart(time.Now()).AddDate(0, -1, 0)
+		if raw := r.URL.Query().Get("month"); raw != "" {
+			parsed, err := time.Parse("2006-01", raw)
+			if err != nil {
+				write400(w, r, "month must be formatted like 2018-11")
+				return
+			}
+			month = parsed
+		}
+
+		history, err := db.GetHistory(ctx, month)
+		if err == db.ErrHistoryNotFound {
+			write404(w, r, err.Error())
+			return
+		} else if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		cache.Tag(w, cache.TopTag)
+		writeJSONFor(w, history, opts.TopCacheTime)
+	}
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLeaderboardHistoryMonth(t *testing.T) {
+	for _, month := range []string{"2018", "2018-13", "11-2018", "2018-11-01"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/leaderboard/history?month="+month, nil)
+		LeaderboardHistory(testAuthContext())(w, r)
+		if w.Code != 400 {
+			t.Errorf("%s: expected 400, received %d", month, w.Code)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// JWKSURL is where EVE SSO publishes the keys signing its access tokens
+const JWKSURL = "https://login.eveonline.com/oauth/jwks"
+
+// minKeyRefresh limits how often an unknown key ID can refetch the JWKS
+const minKeyRefresh = 1 * time.Minute
+
+// ssoIssuers are the accepted values of the iss claim
+var ssoIssuers = []string{"login.eveonline.com", "https://login.eveonline.com"}
+
+// JWKS caches the EVE SSO signing keys, refetching them on an unknown key ID
+type JWKS struct {
+	lock    *sync.Mutex
+	url     string
+	client  *http.Client
+	keys    map[string]jose.JSONWebKey
+	fetched time.Time
+}
+
+// Claims are the parts of an EVE SSO v2 access token we care about
+type Claims struct {
+	CharacterID int32
+	OwnerHash   string
+	Scopes      []string
+	Expiry      time.Time
+}
+
+// NewJWKS returns a new JWKS fetching keys from url
+func NewJWKS(client *http.Client, url string) *JWKS {
+	return &JWKS{
+		lock:   &sync.Mutex{},
+		url:    url,
+		client: client,
+		keys:   map[string]jose.JSONWebKey{},
+	}
+}
+
+// key returns the key for the key ID, fetching the key set if required
+func (j *JWKS) key(ctx context.Context, kid string) (*jose.JSONWebKey, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if key, found := j.keys[kid]; found {
+		return &key, nil
+	}
+
+	if time.Since(j.fetched) < minKeyRefresh {
+		return nil, fmt.Errorf("unknown key ID: %s", kid)
+	}
+
+	if err := j.fetch(ctx); err != nil {
+		return nil, err
+	}
+
+	if key, found := j.keys[kid]; found {
+		return &key, nil
+	}
+
+	return nil, fmt.Errorf("unknown key ID: %s", kid)
+}
+
+// fetch replaces the known keys with those currently published
+func (j *JWKS) fetch(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, j.url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := j.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			log.Printf("failed to close jwks response body: %+v", err)
+		}
+	}()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch jwks: %s", res.Status)
+	}
+
+	keySet := &jose.JSONWebKeySet{}
+	if err := json.NewDecoder(res.Body).Decode(keySet); err != nil {
+		return err
+	}
+
+	j.fetched = time.Now()
+	j.keys = map[string]jose.JSONWebKey{}
+	for _, key := range keySet.Keys {
+		j.keys[key.KeyID] = key
+	}
+
+	return nil
+}
+
+// Verify checks the signature, issuer, audience and expiry of the access
+// token and returns its claims
+func (j *JWKS) Verify(ctx context.Context, token, clientID string) (
+	*Claims,
+	error,
+) {
+	jws, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(jws.Signatures) != 1 {
+		return nil, errors.New("expected exactly one token signature")
+	}
+
+	header := jws.Signatures[0].Header
+	if header.Algorithm != string(jose.RS256) {
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", header.Algorithm)
+	}
+
+	key, err := j.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := jws.Verify(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseClaims(payload, clientID, time.Now())
+}
+
+// parseClaims validates and extracts the claims of a verified token payload
+func parseClaims(payload []byte, clientID string, now time.Time) (
+	*Claims,
+	error,
+) {
+	var raw struct {
+		Issuer   string          `json:"iss"`
+		Subject  string          `json:"sub"`
+		Owner    string          `json:"owner"`
+		Expiry   int64           `json:"exp"`
+		Audience json.RawMessage `json:"aud"`
+		Scopes   json.RawMessage `json:"scp"`
+	}
+
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, err
+	}
+
+	if !inStrings(raw.Issuer, ssoIssuers) {
+		return nil, fmt.Errorf("unexpected token issuer: %s", raw.Issuer)
+	}
+
+	audience, err := stringOrSlice(raw.Audience)
+	if err != nil {
+		return nil, err
+	}
+	if !inStrings(clientID, audience) {
+		return nil, fmt.Errorf("token audience %q does not include client", audience)
+	}
+
+	expiry := time.Unix(raw.Expiry, 0).UTC()
+	if !expiry.After(now) {
+		return nil, fmt.Errorf("token expired at %s", expiry)
+	}
+
+	scopes, err := stringOrSlice(raw.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	charID, err := parseCharacterID(raw.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Claims{
+		CharacterID: charID,
+		OwnerHash:   raw.Owner,
+		Scopes:      scopes,
+		Expiry:      expiry,
+	}, nil
+}
+
+// stringOrSlice decodes a claim which is a single string when it has one value
+func stringOrSlice(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return []string{}, nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(raw, &many); err == nil {
+		return many, nil
+	}
+
+	var one string
+	if err := json.Unmarshal(raw, &one); err != nil {
+		return nil, err
+	}
+	return []string{one}, nil
+}
+
+func inStrings(s string, l []string) bool {
+	for _, i := range l {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyToken validates the access token and returns its claims
+func VerifyToken(ctx context.Context, token string) (*Claims, error) {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	if opts.Auth == nil {
+		return nil, errors.New("auth is not configured")
+	}
+	jwks := ctx.Value(cx.Verifier).(*JWKS)
+	return jwks.Verify(ctx, token, opts.Auth.ClientID)
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+type testKeys struct {
+	keys    []jose.JSONWebKey
+	fetches int
+}
+
+func (k *testKeys) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k.fetches++
+	public := []jose.JSONWebKey{}
+	for _, key := range k.keys {
+		public = append(public, key.Public())
+	}
+	if err := json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: public}); err != nil {
+		panic(err)
+	}
+}
+
+func newTestKey(t *testing.T, kid string) jose.JSONWebKey {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %+v", err)
+	}
+	return jose.JSONWebKey{Key: priv, KeyID: kid, Algorithm: string(jose.RS256)}
+}
+
+func signClaims(t *testing.T, key jose.JSONWebKey, claims map[string]interface{}) string {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("failed to create signer: %+v", err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("failed to marshal claims: %+v", err)
+	}
+
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("failed to sign claims: %+v", err)
+	}
+
+	token, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatalf("failed to serialize token: %+v", err)
+	}
+	return token
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   "login.eveonline.com",
+		"sub":   "CHARACTER:EVE:2114454465",
+		"owner": "owner-hash",
+		"aud":   []string{"client-id", "EVE Online"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scp": []string{
+			"esi-wallet.read_character_wallet.v1",
+			"esi-contracts.read_character_contracts.v1",
+		},
+	}
+}
+
+func TestJWKSVerify(t *testing.T) {
+	first := newTestKey(t, "first")
+	keys := &testKeys{keys: []jose.JSONWebKey{first}}
+	server := httptest.NewServer(keys)
+	defer server.Close()
+
+	jwks := NewJWKS(server.Client(), server.URL)
+	ctx := context.Background()
+
+	claims, err := jwks.Verify(ctx, signClaims(t, first, validClaims()), "client-id")
+	if err != nil {
+		t.Fatalf("failed to verify valid token: %+v", err)
+	}
+	if claims.CharacterID != 2114454465 || claims.OwnerHash != "owner-hash" {
+		t.Errorf("invalid claims: %+v", claims)
+	}
+	if len(claims.Scopes) != 2 {
+		t.Errorf("invalid scopes: %+v", claims.Scopes)
+	}
+
+	// a token from a key not in the set must not verify
+	other := newTestKey(t, "first")
+	if _, err := jwks.Verify(ctx, signClaims(t, other, validClaims()), "client-id"); err == nil {
+		t.Error("verified token signed by an unknown key")
+	}
+
+	if keys.fetches != 1 {
+		t.Errorf("expected 1 jwks fetch, had %d", keys.fetches)
+	}
+
+	// unknown key IDs refetch the key set once the refresh limit passes
+	second := newTestKey(t, "second")
+	keys.keys = append(keys.keys, second)
+	jwks.fetched = time.Now().Add(-2 * minKeyRefresh)
+
+	if _, err := jwks.Verify(ctx, signClaims(t, second, validClaims()), "client-id"); err != nil {
+		t.Errorf("failed to verify token from rotated key: %+v", err)
+	}
+	if keys.fetches != 2 {
+		t.Errorf("expected 2 jwks fetches, had %d", keys.fetches)
+	}
+}
+
+func TestParseClaims(t *testing.T) {
+	now := time.Now()
+
+	check := func(name string, mutate func(map[string]interface{}), valid bool) {
+		claims := validClaims()
+		mutate(claims)
+		payload, err := json.Marshal(claims)
+		if err != nil {
+			t.Fatalf("failed to marshal claims: %+v", err)
+		}
+		_, err = parseClaims(payload, "client-id", now)
+		if valid && err != nil {
+			t.Errorf("%s: unexpected error: %+v", name, err)
+		} else if !valid && err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	check("valid", func(c map[string]interface{}) {}, true)
+	check("https issuer", func(c map[string]interface{}) {
+		c["iss"] = "https://login.eveonline.com"
+	}, true)
+	check("single scope", func(c map[string]interface{}) {
+		c["scp"] = "esi-wallet.read_character_wallet.v1"
+	}, true)
+	check("wrong issuer", func(c map[string]interface{}) {
+		c["iss"] = "login.example.com"
+	}, false)
+	check("wrong audience", func(c map[string]interface{}) {
+		c["aud"] = "EVE Online"
+	}, false)
+	check("expired", func(c map[string]interface{}) {
+		c["exp"] = now.Add(-time.Minute).Unix()
+	}, false)
+	check("bad subject", func(c map[string]interface{}) {
+		c["sub"] = "2114454465"
+	}, false)
+}
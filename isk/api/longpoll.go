@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+	"github.com/a-tal/esi-isk/isk/pubsub"
+)
+
+// maxSinceRows is the most donations one poll returns, clients poll again
+// after the last of them for the rest
+const maxSinceRows = 100
+
+var (
+	errBadSinceID = errors.New("since_id must be a donation ID, or 0")
+	errBadWait    = errors.New("wait must be a number of seconds")
+)
+
+// NewDonations returns the character's donations after since_id, oldest
+// first. Without any, the request is held up to wait seconds for some to
+// arrive, or a test donation, then an empty array is returned. Held requests
+// are never cached
+func NewDonations(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	updates := ctx.Value(cx.Updates).(*pubsub.Broker)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		charID, err := getPathCharID(r)
+		if err != nil {
+			write400(w, r, "invalid character ID")
+			return
+		}
+
+		sinceID, err := strconv.ParseInt(r.URL.Query().Get("since_id"), 10, 64)
+		if err != nil || sinceID < 0 {
+			write400(w, r, errBadSinceID.Error())
+			return
+		}
+
+		wait, err := getWait(r, opts.LongPollWait)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		if !checkCharacterAccess(ctx, w, r, charID) {
+			return
+		}
+
+		// subscribe before the first read, so no update can be missed
+		var sub *pubsub.Subscription
+		if wait > 0 {
+			sub, err = updates.Subscribe(charID)
+			if err == pubsub.ErrTooManySubscribers {
+				write429(w, r, "too many requests are waiting on this character")
+				return
+			}
+			defer updates.Unsubscribe(sub)
+		}
+
+		timeout := time.NewTimer(wait)
+		defer timeout.Stop()
+
+		for {
+			donations, err := donationsSince(ctx, r, charID, sinceID)
+			if err != nil {
+				write500(w, r, err)
+				return
+			}
+			if len(donations) > 0 || sub == nil {
+				writeNewDonations(w, donations)
+				return
+			}
+
+			select {
+			case <-sub.C:
+			case event := <-sub.Events:
+				if donation, ok := event.(*db.Donation); ok {
+					writeTestDonation(w, donation)
+					return
+				}
+			case <-timeout.C:
+				writeNewDonations(w, donations)
+				return
+			case <-r.Context().Done():
+				// the client is gone, release the request
+				return
+			}
+		}
+	}
+}
+
+// getWait reads the "wait" query arg, capped at max seconds
+func getWait(r *http.Request, max int) (time.Duration, error) {
+	raw := r.URL.Query().Get("wait")
+	if raw == "" {
+		return 0, nil
+	}
+
+	seconds, err := strconv.Atoi(raw)
+	if err != nil || seconds < 0 {
+		return 0, errBadWait
+	}
+	if seconds > max {
+		seconds = max
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// donationsSince returns the donations after sinceID, masking anonymous
+// donators from everyone but the character
+func donationsSince(
+	ctx context.Context,
+	r *http.Request,
+	charID int32,
+	sinceID int64,
+) (db.Donations, error) {
+	donations, err := db.GetDonationsSince(ctx, charID, sinceID, maxSinceRows)
+	if err != nil {
+		return nil, err
+	}
+
+	if sessionCharacter(r) != charID {
+		c := &db.CharDetails{Donations: donations}
+		if err := c.MaskAnonymous(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return donations, nil
+}
+
+func writeNewDonations(w http.ResponseWriter, donations db.Donations) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSONFor(w, donations, 0)
+}
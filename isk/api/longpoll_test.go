@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetWait(t *testing.T) {
+	for query, expected := range map[string]time.Duration{
+		"":           0,
+		"?wait=0":    0,
+		"?wait=25":   25 * time.Second,
+		"?wait=300":  30 * time.Second,
+		"?wait=-1":   -1,
+		"?wait=soon": -1,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/x"+query, nil)
+		wait, err := getWait(r, 30)
+		if expected < 0 {
+			if err != errBadWait {
+				t.Errorf("%q: expected errBadWait, received %v", query, err)
+			}
+		} else if err != nil || wait != expected {
+			t.Errorf("%q: expected %s, received %s (%v)", query, expected, wait, err)
+		}
+	}
+}
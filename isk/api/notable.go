@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// notableContracts is a page of the biggest contracts with notable items
+type notableContracts struct {
+	*page
+	Contracts []*db.ContractRecord `json:"contracts"`
+}
+
+// NotableContracts returns the accepted contracts with PLEX, skill injectors
+// or extractors of the last 30 days, the highest value first
+func NotableContracts(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		p, err := getPage(r)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		res, err := db.GetNotableContracts(ctx, p.Limit, p.Offset)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		cache.Tag(w, cache.TopTag)
+		writeJSONFor(
+			w,
+			&notableContracts{page: p, Contracts: res},
+			opts.TopCacheTime,
+		)
+	}
+}
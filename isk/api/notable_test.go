@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNotableContractsValidation(t *testing.T) {
+	h := NotableContracts(testAuthContext())
+
+	for _, tc := range []struct {
+		method, target string
+		code           int
+	}{
+		{http.MethodPost, "/api/leaderboard/notable", 405},
+		{http.MethodGet, "/api/leaderboard/notable?limit=0", 400},
+		{http.MethodGet, "/api/leaderboard/notable?offset=-1", 400},
+	} {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(tc.method, tc.target, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s %s: expected %d, received %d",
+				tc.method, tc.target, tc.code, w.Code)
+		}
+	}
+}
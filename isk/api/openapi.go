@@ -0,0 +1,1251 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/buildinfo"
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// openAPI is an OpenAPI 3 document, of only the parts we use
+type openAPI struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       *openAPIInfo         `json:"info"`
+	Paths      map[string]*pathItem `json:"paths"`
+	Components *components          `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+// pathItem holds the operations of a path by method
+type pathItem struct {
+	Get    *operation `json:"get,omitempty"`
+	Post   *operation `json:"post,omitempty"`
+	Delete *operation `json:"delete,omitempty"`
+}
+
+type operation struct {
+	Summary     string                `json:"summary"`
+	Tags        []string              `json:"tags"`
+	Parameters  []*parameter          `json:"parameters,omitempty"`
+	RequestBody *requestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*mediaType `json:"content"`
+}
+
+type response struct {
+	Description string                `json:"description"`
+	Content     map[string]*mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+	OneOf                []*schema          `json:"oneOf,omitempty"`
+}
+
+type components struct {
+	Schemas         map[string]*schema         `json:"schemas"`
+	SecuritySchemes map[string]*securityScheme `json:"securitySchemes"`
+}
+
+type securityScheme struct {
+	Type string `json:"type"`
+	In   string `json:"in"`
+	Name string `json:"name"`
+}
+
+// apiRoute describes the operation of a method of a route. The request and
+// response are examples of the Go values read and written, nil for none
+type apiRoute struct {
+	Path, Method, Summary, Tag string
+
+	Params   []*parameter
+	Request  interface{}
+	Response interface{}
+
+	// Status of success, 200 if unset
+	Status int
+
+	// Media of the response when it isn't JSON
+	Media string
+
+	// Auth is the security scheme of the route, session or admin, if any
+	Auth string
+}
+
+// parameters of many routes
+var (
+	charIDPath = &parameter{
+		Name:        "id",
+		In:          "path",
+		Description: "character ID, or a claimed slug",
+		Required:    true,
+		Schema:      &schema{Type: "string"},
+	}
+	charIDQuery = &parameter{
+		Name:        "c",
+		In:          "query",
+		Description: "character ID, or a claimed slug",
+		Required:    true,
+		Schema:      &schema{Type: "string"},
+	}
+	prefTypeQuery = &parameter{
+		Name:        "t",
+		In:          "query",
+		Description: "d for donations, c for contracts or a for all",
+		Schema:      &schema{Type: "string", Enum: []string{"d", "c", "a"}},
+	}
+	passphraseQuery = &parameter{
+		Name:        "p",
+		In:          "query",
+		Description: "passphrase of locked characters",
+		Schema:      &schema{Type: "string"},
+	}
+	limitQuery = &parameter{
+		Name:        "limit",
+		In:          "query",
+		Description: fmt.Sprintf("page size, up to %d", maxPageLimit),
+		Schema:      &schema{Type: "integer"},
+	}
+	cursorQuery = &parameter{
+		Name:        "cursor",
+		In:          "query",
+		Description: "next_cursor of the previous page",
+		Schema:      &schema{Type: "string"},
+	}
+	directionQuery = &parameter{
+		Name:        "direction",
+		In:          "query",
+		Description: "received or sent, both if unset",
+		Schema: &schema{
+			Type: "string",
+			Enum: []string{activityReceived, activitySent},
+		},
+	}
+	windowQuery = &parameter{
+		Name:        "window",
+		In:          "query",
+		Description: "totals the leaderboards are of, 30d if unset",
+		Schema:      &schema{Type: "string", Enum: db.WindowNames()},
+	}
+	offsetQuery = &parameter{
+		Name:        "offset",
+		In:          "query",
+		Description: "rows to skip",
+		Schema:      &schema{Type: "integer"},
+	}
+)
+
+// prefsBody is the preferences of t=d or t=c, or of t=a
+var prefsBody = []interface{}{&db.Prefs{}, &db.Preferences{}}
+
+// apiRoutes are every route of the API. The test of the spec checks they are
+// the same routes RunServer registers
+var apiRoutes = []*apiRoute{
+	{
+		Path:     "/api/ping",
+		Method:   http.MethodGet,
+		Summary:  "Liveness check, responds ok",
+		Tag:      "status",
+		Response: "",
+		Media:    "text/plain",
+	},
+	{
+		Path:     "/api/ready",
+		Method:   http.MethodGet,
+		Summary:  "Readiness check, 503 until statements are prepared",
+		Tag:      "status",
+		Response: "",
+		Media:    "text/plain",
+	},
+	{
+		Path:     "/api/version",
+		Method:   http.MethodGet,
+		Summary:  "Build info of the running API",
+		Tag:      "status",
+		Response: &buildinfo.Info{},
+	},
+	{
+		Path:     "/api/openapi.json",
+		Method:   http.MethodGet,
+		Summary:  "This document",
+		Tag:      "status",
+		Response: map[string]interface{}{},
+	},
+	{
+		Path:     "/api/docs",
+		Method:   http.MethodGet,
+		Summary:  "Swagger UI of this document, in debug mode only",
+		Tag:      "status",
+		Response: "",
+		Media:    "text/html",
+	},
+	{
+		Path:     "/api/cache",
+		Method:   http.MethodGet,
+		Summary:  "Response cache hit and miss counters",
+		Tag:      "status",
+		Response: &cache.Stats{},
+	},
+	{
+		Path:     "/api/top",
+		Method:   http.MethodGet,
+		Summary:  "Current top recipients and donators",
+		Tag:      "leaderboards",
+		Params:   []*parameter{windowQuery},
+		Response: &topCharacters{},
+	},
+	{
+		Path:     "/api/v2/top",
+		Method:   http.MethodGet,
+		Summary:  "Current top recipients and donators, every field present",
+		Tag:      "v2",
+		Params:   []*parameter{windowQuery},
+		Response: &topCharactersV2{},
+	},
+	{
+		Path:    "/api/leaderboard/history",
+		Method:  http.MethodGet,
+		Summary: "Leaderboards of a past month",
+		Tag:     "leaderboards",
+		Params: []*parameter{{
+			Name:        "month",
+			In:          "query",
+			Description: "month like 2018-11, defaults to last month",
+			Schema:      &schema{Type: "string"},
+		}},
+		Response: &db.History{},
+	},
+	{
+		Path:     "/api/leaderboard/trending",
+		Method:   http.MethodGet,
+		Summary:  "Characters with the most page views in the last day",
+		Tag:      "leaderboards",
+		Params:   []*parameter{limitQuery, offsetQuery},
+		Response: &trending{},
+	},
+	{
+		Path:     "/api/leaderboard/notable",
+		Method:   http.MethodGet,
+		Summary:  "Biggest contracts of PLEX, skill injectors or extractors",
+		Tag:      "leaderboards",
+		Params:   []*parameter{limitQuery, offsetQuery},
+		Response: &notableContracts{},
+	},
+	{
+		Path:     "/api/leaderboard/corporations",
+		Method:   http.MethodGet,
+		Summary:  "Corporations whose members donated the most ISK",
+		Tag:      "leaderboards",
+		Params:   []*parameter{windowQuery, limitQuery, offsetQuery},
+		Response: &generousCorporations{},
+	},
+	{
+		Path:     "/api/leaderboard/alliances",
+		Method:   http.MethodGet,
+		Summary:  "Alliances whose members donated the most ISK",
+		Tag:      "leaderboards",
+		Params:   []*parameter{windowQuery, limitQuery, offsetQuery},
+		Response: &generousAlliances{},
+	},
+	{
+		Path:     "/api/chars",
+		Method:   http.MethodPost,
+		Summary:  fmt.Sprintf("Up to %d characters by ID", maxBulkCharacters),
+		Tag:      "characters",
+		Request:  []int32{},
+		Response: &bulkCharacters{},
+	},
+	{
+		Path:     "/api/char",
+		Method:   http.MethodGet,
+		Summary:  "Character details, with their donations and contracts",
+		Tag:      "characters",
+		Params:   []*parameter{charIDQuery, passphraseQuery},
+		Response: &db.CharDetails{},
+	},
+	{
+		Path:     "/api/v2/chars",
+		Method:   http.MethodPost,
+		Summary:  fmt.Sprintf("Up to %d characters by ID", maxBulkCharacters),
+		Tag:      "v2",
+		Request:  []int32{},
+		Response: &bulkCharactersV2{},
+	},
+	{
+		Path:     "/api/v2/char",
+		Method:   http.MethodGet,
+		Summary:  "Character details, with one feed of all their activity",
+		Tag:      "v2",
+		Params:   []*parameter{charIDQuery, passphraseQuery},
+		Response: &charDetailsV2{},
+	},
+	{
+		Path:    "/api/v2/char/{id}/donations",
+		Method:  http.MethodGet,
+		Summary: "Page of the character's donations, newest first",
+		Tag:     "v2",
+		Params: []*parameter{
+			charIDPath,
+			directionQuery,
+			cursorQuery,
+			limitQuery,
+		},
+		Response: &donationsPage{},
+	},
+	{
+		Path:    "/api/v2/char/{id}/contracts",
+		Method:  http.MethodGet,
+		Summary: "Page of the character's contracts, newest first",
+		Tag:     "v2",
+		Params: []*parameter{
+			charIDPath,
+			directionQuery,
+			cursorQuery,
+			limitQuery,
+		},
+		Response: &contractsPage{},
+	},
+	{
+		Path:    "/api/v2/char/{id}/activity",
+		Method:  http.MethodGet,
+		Summary: "Page of the character's donations and contracts, newest first",
+		Tag:     "v2",
+		Params: []*parameter{
+			charIDPath,
+			directionQuery,
+			cursorQuery,
+			limitQuery,
+		},
+		Response: &activityPage{},
+	},
+	{
+		Path:    "/api/char/{id}/timeseries",
+		Method:  http.MethodGet,
+		Summary: "ISK received per day, week or month",
+		Tag:     "characters",
+		Params: []*parameter{
+			charIDPath,
+			{
+				Name:        "window",
+				In:          "query",
+				Description: "up to a year before now, like 90d, 12w or 6m",
+				Schema:      &schema{Type: "string"},
+			},
+			{
+				Name:        "bucket",
+				In:          "query",
+				Description: "defaults to day",
+				Schema: &schema{Type: "string", Enum: []string{
+					db.BucketDay,
+					db.BucketWeek,
+					db.BucketMonth,
+				}},
+			},
+		},
+		Response: []*db.Point{},
+	},
+	{
+		Path:     "/api/char/{id}/histogram",
+		Method:   http.MethodGet,
+		Summary:  "Donations received and sent by size",
+		Tag:      "characters",
+		Params:   []*parameter{charIDPath},
+		Response: &db.Histogram{},
+	},
+	{
+		Path:     "/api/char/{id}/supporters",
+		Method:   http.MethodGet,
+		Summary:  "Donators of the character, by ISK sent",
+		Tag:      "characters",
+		Params:   []*parameter{charIDPath, limitQuery, offsetQuery},
+		Response: &supporters{},
+	},
+	{
+		Path:     "/api/char/{id}/donor-corps",
+		Method:   http.MethodGet,
+		Summary:  "ISK received by the corporation of the donators",
+		Tag:      "characters",
+		Params:   []*parameter{charIDPath},
+		Response: &db.DonorCorporations{},
+	},
+	{
+		Path:    "/api/char/{id}/from/{donorID}",
+		Method:  http.MethodGet,
+		Summary: "Donations and contracts from the donor to the character",
+		Tag:     "characters",
+		Params: []*parameter{
+			charIDPath,
+			{
+				Name:        "donorID",
+				In:          "path",
+				Description: "character ID of the donor",
+				Required:    true,
+				Schema:      &schema{Type: "integer"},
+			},
+			limitQuery,
+			offsetQuery,
+		},
+		Response: &transfers{},
+	},
+	{
+		Path:    "/api/char/{id}/donations",
+		Method:  http.MethodGet,
+		Summary: "Donations after since_id, waiting up to wait seconds for some",
+		Tag:     "characters",
+		Params: []*parameter{
+			charIDPath,
+			{
+				Name:        "since_id",
+				In:          "query",
+				Description: "ID of the last donation seen, 0 for all",
+				Required:    true,
+				Schema:      &schema{Type: "integer"},
+			},
+			{
+				Name:        "wait",
+				In:          "query",
+				Description: "seconds to hold the request for new donations",
+				Schema:      &schema{Type: "integer"},
+			},
+		},
+		Response: db.Donations{},
+	},
+	{
+		Path:     "/api/char/{id}/refresh",
+		Method:   http.MethodPost,
+		Summary:  "Poll the logged in owner's character now",
+		Tag:      "characters",
+		Params:   []*parameter{charIDPath},
+		Response: &db.RefreshJob{},
+		Status:   http.StatusAccepted,
+		Auth:     "session",
+	},
+	{
+		Path:     "/api/char/{id}/test-donation",
+		Method:   http.MethodPost,
+		Summary:  "Send a test donation to the logged in owner's long polls",
+		Tag:      "characters",
+		Params:   []*parameter{charIDPath},
+		Request:  &testDonationRequest{},
+		Response: &testDonation{},
+		Status:   http.StatusAccepted,
+		Auth:     "session",
+	},
+	{
+		Path:    "/api/char/{id}/refresh/{job}",
+		Method:  http.MethodGet,
+		Summary: "Status of a refresh of the logged in owner's character",
+		Tag:     "characters",
+		Params: []*parameter{charIDPath, {
+			Name:        "job",
+			In:          "path",
+			Description: "job ID of the refresh",
+			Required:    true,
+			Schema:      &schema{Type: "integer"},
+		}},
+		Response: &db.RefreshJob{},
+		Auth:     "session",
+	},
+	{
+		Path:    "/api/donation/{id}",
+		Method:  http.MethodGet,
+		Summary: "A donation, with the names of both characters",
+		Tag:     "characters",
+		Params: []*parameter{{
+			Name:        "id",
+			In:          "path",
+			Description: "journal reference ID of the donation",
+			Required:    true,
+			Schema:      &schema{Type: "integer"},
+		}},
+		Response: &db.DonationRecord{},
+	},
+	{
+		Path:    "/api/contract/{id}",
+		Method:  http.MethodGet,
+		Summary: "A contract and its items, with the names of both characters",
+		Tag:     "characters",
+		Params: []*parameter{{
+			Name:        "id",
+			In:          "path",
+			Description: "ID of the contract",
+			Required:    true,
+			Schema:      &schema{Type: "integer"},
+		}},
+		Response: &db.ContractRecord{},
+	},
+	{
+		Path:    "/api/verify",
+		Method:  http.MethodGet,
+		Summary: "If the donor sent the recipient at least min_amount after a time",
+		Tag:     "characters",
+		Params: []*parameter{
+			{
+				Name:        "donor",
+				In:          "query",
+				Description: "character ID of the donor",
+				Required:    true,
+				Schema:      &schema{Type: "integer"},
+			},
+			{
+				Name:        "recipient",
+				In:          "query",
+				Description: "character ID of the recipient",
+				Required:    true,
+				Schema:      &schema{Type: "integer"},
+			},
+			{
+				Name:        "min_amount",
+				In:          "query",
+				Description: "ISK the donations must total, any by default",
+				Schema:      &schema{Type: "number"},
+			},
+			{
+				Name:        "after",
+				In:          "query",
+				Description: "only count donations after this time",
+				Schema:      &schema{Type: "string", Format: "date-time"},
+			},
+		},
+		Response: &db.Proof{},
+	},
+	{
+		Path:    "/api/compare",
+		Method:  http.MethodGet,
+		Summary: "Two characters and the ISK between them",
+		Tag:     "characters",
+		Params: []*parameter{
+			{
+				Name:        "a",
+				In:          "query",
+				Description: "character ID",
+				Required:    true,
+				Schema:      &schema{Type: "integer"},
+			},
+			{
+				Name:        "b",
+				In:          "query",
+				Description: "character ID",
+				Required:    true,
+				Schema:      &schema{Type: "integer"},
+			},
+		},
+		Response: &comparison{},
+	},
+	{
+		Path:     "/api/custom",
+		Method:   http.MethodGet,
+		Summary:  "Overlay HTML built from the character's preferences",
+		Tag:      "overlays",
+		Params:   []*parameter{charIDQuery, prefTypeQuery, passphraseQuery},
+		Response: "",
+		Media:    "text/html",
+	},
+	{
+		Path:    "/widget/{id}",
+		Method:  http.MethodGet,
+		Summary: "Embeddable widget of the character's recent donations",
+		Tag:     "overlays",
+		Params: []*parameter{
+			charIDPath,
+			prefTypeQuery,
+			passphraseQuery,
+			{
+				Name:        "theme",
+				In:          "query",
+				Description: "defaults to dark",
+				Schema: &schema{
+					Type: "string",
+					Enum: []string{"dark", "light", "transparent"},
+				},
+			},
+			{
+				Name:        "limit",
+				In:          "query",
+				Description: "rows to show, defaults to the rows preference",
+				Schema:      &schema{Type: "integer"},
+			},
+		},
+		Response: "",
+		Media:    "text/html",
+	},
+	{
+		Path:    "/c/{slug}",
+		Method:  http.MethodGet,
+		Summary: "Redirects to the page of the character claiming the slug",
+		Tag:     "overlays",
+		Params: []*parameter{{
+			Name:        "slug",
+			In:          "path",
+			Description: "slug claimed by a character",
+			Required:    true,
+			Schema:      &schema{Type: "string"},
+		}},
+		Status: http.StatusFound,
+	},
+	{
+		Path:    "/char/{id}",
+		Method:  http.MethodGet,
+		Summary: "Redirects to the page of the character, by slug if it has one",
+		Tag:     "overlays",
+		Params: []*parameter{{
+			Name:        "id",
+			In:          "path",
+			Description: "character ID",
+			Required:    true,
+			Schema:      &schema{Type: "integer"},
+		}},
+		Status: http.StatusFound,
+	},
+	{
+		Path:    "/sitemap.xml",
+		Method:  http.MethodGet,
+		Summary: "Sitemap of the visible character pages",
+		Tag:     "overlays",
+		Params: []*parameter{{
+			Name: "page",
+			In:   "query",
+			Description: "sitemap page from 1, when there are too many " +
+				"characters for one the sitemap is an index of its pages",
+			Schema: &schema{Type: "integer"},
+		}},
+		Response: "",
+		Media:    "application/xml",
+	},
+	{
+		Path:     "/api/prefs",
+		Method:   http.MethodGet,
+		Summary:  "Preferences of the logged in character",
+		Tag:      "preferences",
+		Params:   []*parameter{prefTypeQuery},
+		Response: prefsBody,
+		Auth:     "session",
+	},
+	{
+		Path:    "/api/prefs",
+		Method:  http.MethodPost,
+		Summary: "Set preferences of the logged in character",
+		Tag:     "preferences",
+		Params:  []*parameter{prefTypeQuery},
+		Request: prefsBody,
+		Status:  http.StatusNoContent,
+		Auth:    "session",
+	},
+	{
+		Path:     "/api/prefs/preview",
+		Method:   http.MethodPost,
+		Summary:  "Render a row pattern with an example donation",
+		Tag:      "preferences",
+		Request:  &previewRequest{},
+		Response: &previewResponse{},
+		Auth:     "session",
+	},
+	{
+		Path:     "/api/prefs/export",
+		Method:   http.MethodGet,
+		Summary:  "Every preference of the logged in character",
+		Tag:      "preferences",
+		Response: &db.PreferenceDocument{},
+		Auth:     "session",
+	},
+	{
+		Path:    "/api/prefs/import",
+		Method:  http.MethodPost,
+		Summary: "Replace every preference of the logged in character",
+		Tag:     "preferences",
+		Request: &db.PreferenceDocument{},
+		Status:  http.StatusNoContent,
+		Auth:    "session",
+	},
+	{
+		Path:     "/api/prefs/privacy",
+		Method:   http.MethodGet,
+		Summary:  "Privacy preferences of the logged in character",
+		Tag:      "preferences",
+		Response: &db.Privacy{},
+		Auth:     "session",
+	},
+	{
+		Path:    "/api/prefs/privacy",
+		Method:  http.MethodPost,
+		Summary: "Set privacy preferences of the logged in character",
+		Tag:     "preferences",
+		Request: &db.Privacy{},
+		Status:  http.StatusNoContent,
+		Auth:    "session",
+	},
+	{
+		Path:     "/api/prefs/slug",
+		Method:   http.MethodGet,
+		Summary:  "Slug claimed by the logged in character",
+		Tag:      "preferences",
+		Response: &db.Slug{},
+		Auth:     "session",
+	},
+	{
+		Path:    "/api/prefs/slug",
+		Method:  http.MethodPost,
+		Summary: "Claim a slug for the logged in character",
+		Tag:     "preferences",
+		Request: &slugRequest{},
+		Status:  http.StatusNoContent,
+		Auth:    "session",
+	},
+	{
+		Path:    "/api/user",
+		Method:  http.MethodDelete,
+		Summary: "Remove the logged in character",
+		Tag:     "user",
+		Params: []*parameter{{
+			Name:        "purge",
+			In:          "query",
+			Description: "true to delete its donations, not anonymize them",
+			Schema:      &schema{Type: "boolean"},
+		}},
+		Status: http.StatusNoContent,
+		Auth:   "session",
+	},
+	{
+		Path:     "/api/user/export",
+		Method:   http.MethodGet,
+		Summary:  "Everything stored of the logged in character",
+		Tag:      "user",
+		Response: map[string]interface{}{},
+		Auth:     "session",
+	},
+	{
+		Path:     "/api/admin/cache/purge",
+		Method:   http.MethodPost,
+		Summary:  "Purge cached responses, of one character if given",
+		Tag:      "admin",
+		Request:  &purgeRequest{},
+		Response: map[string]int{},
+		Auth:     "admin",
+	},
+	{
+		Path:     "/api/admin/cache/warm",
+		Method:   http.MethodPost,
+		Summary:  "Cache the leaderboards and most viewed characters again",
+		Tag:      "admin",
+		Status:   http.StatusAccepted,
+		Response: map[string]string{},
+		Auth:     "admin",
+	},
+	{
+		Path:    "/api/admin/donations/{id}/void",
+		Method:  http.MethodPost,
+		Summary: "Void a donation, removing it from every total",
+		Tag:     "admin",
+		Params: []*parameter{{
+			Name:        "id",
+			In:          "path",
+			Description: "journal reference ID of the donation",
+			Required:    true,
+			Schema:      &schema{Type: "integer"},
+		}},
+		Request:  &db.Correction{},
+		Response: &db.Donation{},
+		Auth:     "admin",
+	},
+	{
+		Path:     "/api/admin/donations/adjust",
+		Method:   http.MethodPost,
+		Summary:  "Add a donation ESI did not report",
+		Tag:      "admin",
+		Request:  &adjustmentRequest{},
+		Response: &db.Donation{},
+		Auth:     "admin",
+	},
+	{
+		Path:    "/api/admin/characters/{id}/ban",
+		Method:  http.MethodPost,
+		Summary: "Ban a character from public listings",
+		Tag:     "admin",
+		Params: []*parameter{{
+			Name:        "id",
+			In:          "path",
+			Description: "character ID",
+			Required:    true,
+			Schema:      &schema{Type: "integer"},
+		}},
+		Request: &db.Correction{},
+		Status:  http.StatusNoContent,
+		Auth:    "admin",
+	},
+	{
+		Path:    "/api/admin/characters/{id}/ban",
+		Method:  http.MethodDelete,
+		Summary: "Lift the ban of a character",
+		Tag:     "admin",
+		Params: []*parameter{{
+			Name:        "id",
+			In:          "path",
+			Description: "character ID",
+			Required:    true,
+			Schema:      &schema{Type: "integer"},
+		}},
+		Request: &db.Correction{},
+		Status:  http.StatusNoContent,
+		Auth:    "admin",
+	},
+	{
+		Path:    "/api/admin/standings/{id}",
+		Method:  http.MethodGet,
+		Summary: "Standing of a character, with the contacts setting it",
+		Tag:     "admin",
+		Params: []*parameter{{
+			Name:        "id",
+			In:          "path",
+			Description: "character ID",
+			Required:    true,
+			Schema:      &schema{Type: "integer"},
+		}},
+		Response: &standingResponse{},
+		Auth:     "admin",
+	},
+	{
+		Path:    "/api/admin/audit",
+		Method:  http.MethodGet,
+		Summary: "Audit log of admin actions and account deletions, newest first",
+		Tag:     "admin",
+		Params: []*parameter{
+			limitQuery,
+			offsetQuery,
+			{
+				Name:        "action",
+				In:          "query",
+				Description: "only entries of the action, such as purge_cache",
+				Schema:      &schema{Type: "string"},
+			},
+			{
+				Name:        "target",
+				In:          "query",
+				Description: "only entries of the target, such as character:1",
+				Schema:      &schema{Type: "string"},
+			},
+		},
+		Response: &auditPage{},
+		Auth:     "admin",
+	},
+	{
+		Path:    "/api/admin/characters",
+		Method:  http.MethodGet,
+		Summary: "Characters with a stored token, by last activity",
+		Tag:     "admin",
+		Params: []*parameter{
+			limitQuery,
+			cursorQuery,
+			{
+				Name:        "filter",
+				In:          "query",
+				Description: "only characters matching it, stale have not polled",
+				Schema: &schema{
+					Type: "string",
+					Enum: []string{
+						db.FilterRevoked,
+						db.FilterHidden,
+						db.FilterDeleted,
+						db.FilterStale,
+						db.FilterBanned,
+					},
+				},
+			},
+			{
+				Name:        "hours",
+				In:          "query",
+				Description: "hours without a successful poll until stale, 24",
+				Schema:      &schema{Type: "integer"},
+			},
+			{
+				Name:        "order",
+				In:          "query",
+				Description: "desc, most recently active first, or asc",
+				Schema: &schema{
+					Type: "string",
+					Enum: []string{"desc", "asc"},
+				},
+			},
+		},
+		Response: &trackedPage{},
+		Auth:     "admin",
+	},
+	{
+		Path:    "/api/admin/rings",
+		Method:  http.MethodGet,
+		Summary: "Characters sending each other roughly the same ISK",
+		Tag:     "admin",
+		Params: []*parameter{
+			limitQuery,
+			offsetQuery,
+			{
+				Name:        "filter",
+				In:          "query",
+				Description: "rings pending review, the default, reviewed or all",
+				Schema: &schema{
+					Type: "string",
+					Enum: []string{
+						db.RingsPending,
+						db.RingsReviewed,
+						db.RingsAll,
+					},
+				},
+			},
+		},
+		Response: &ringsPage{},
+		Auth:     "admin",
+	},
+	{
+		Path:    "/api/admin/rings/{id}/review",
+		Method:  http.MethodPost,
+		Summary: "Mark a donation ring reviewed",
+		Tag:     "admin",
+		Params: []*parameter{{
+			Name:        "id",
+			In:          "path",
+			Description: "donation ring ID",
+			Required:    true,
+			Schema:      &schema{Type: "integer"},
+		}},
+		Request: &db.Correction{},
+		Status:  http.StatusNoContent,
+		Auth:    "admin",
+	},
+	{
+		Path:     "/metrics",
+		Method:   http.MethodGet,
+		Summary:  "Prometheus metrics",
+		Tag:      "status",
+		Response: "",
+		Media:    "text/plain",
+	},
+	{
+		Path:    "/signup",
+		Method:  http.MethodGet,
+		Summary: "Redirects to EVE SSO to log in",
+		Tag:     "user",
+		Params: []*parameter{{
+			Name:        "next",
+			In:          "query",
+			Description: "local path to return to once logged in",
+			Schema:      &schema{Type: "string"},
+		}},
+		Status: http.StatusFound,
+	},
+	{
+		Path:    "/callback",
+		Method:  http.MethodGet,
+		Summary: "EVE SSO callback, starting a session",
+		Tag:     "user",
+		Status:  http.StatusFound,
+	},
+	{
+		Path:    "/logout",
+		Method:  http.MethodGet,
+		Summary: "Ends the session",
+		Tag:     "user",
+		Status:  http.StatusFound,
+	},
+}
+
+// schemaExtras are the properties added by the MarshalJSON of the type, of
+// each type doing so
+var schemaExtras = map[reflect.Type]map[string]*schema{
+	reflect.TypeOf(db.Character{}): {
+		"received_isk_short":    {Type: "string"},
+		"received_isk_7_short":  {Type: "string"},
+		"received_isk_30_short": {Type: "string"},
+		"received_isk_90_short": {Type: "string"},
+		"donated_isk_short":     {Type: "string"},
+		"donated_isk_7_short":   {Type: "string"},
+		"donated_isk_30_short":  {Type: "string"},
+		"donated_isk_90_short":  {Type: "string"},
+	},
+	reflect.TypeOf(db.Point{}): {
+		"date": {Type: "string", Format: "date"},
+	},
+	reflect.TypeOf(db.RefreshJob{}): {
+		"started_at":  {Type: "string", Format: "date-time"},
+		"finished_at": {Type: "string", Format: "date-time"},
+	},
+	reflect.TypeOf(db.Supporter{}): {},
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	iskType       = reflect.TypeOf(db.ISK(0))
+	rawJSONType   = reflect.TypeOf(json.RawMessage{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// newOpenAPI builds the document of the routes
+func newOpenAPI(routes []*apiRoute) *openAPI {
+	spec := &openAPI{
+		OpenAPI: "3.0.3",
+		Info: &openAPIInfo{
+			Title:       "ESI ISK",
+			Description: "ISK donations and zero ISK contract tracking",
+			Version:     buildinfo.Version,
+		},
+		Paths: map[string]*pathItem{},
+		Components: &components{
+			Schemas: map[string]*schema{},
+			SecuritySchemes: map[string]*securityScheme{
+				"session": {Type: "apiKey", In: "cookie", Name: sessionCookie},
+				"admin":   {Type: "apiKey", In: "header", Name: adminHeader},
+			},
+		},
+	}
+
+	for _, route := range routes {
+		item, found := spec.Paths[route.Path]
+		if !found {
+			item = &pathItem{}
+			spec.Paths[route.Path] = item
+		}
+
+		op := spec.operation(route)
+		switch route.Method {
+		case http.MethodGet:
+			item.Get = op
+		case http.MethodPost:
+			item.Post = op
+		case http.MethodDelete:
+			item.Delete = op
+		default:
+			panic(fmt.Errorf("unsupported method %s", route.Method))
+		}
+	}
+
+	return spec
+}
+
+// operation returns the operation of the route
+func (s *openAPI) operation(route *apiRoute) *operation {
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	success := &response{Description: http.StatusText(status)}
+	if route.Response != nil {
+		success.Content = s.content(route.Response, route.Media)
+	}
+
+	op := &operation{
+		Summary:    route.Summary,
+		Tags:       []string{route.Tag},
+		Parameters: route.Params,
+		Responses: map[string]*response{
+			fmt.Sprintf("%d", status): success,
+			"default": {
+				Description: "Error",
+				Content:     s.content(&errorEnvelope{}, ""),
+			},
+		},
+	}
+	if route.Request != nil {
+		op.RequestBody = &requestBody{
+			Required: true,
+			Content:  s.content(route.Request, ""),
+		}
+	}
+	if route.Auth != "" {
+		op.Security = []map[string][]string{{route.Auth: {}}}
+	}
+	return op
+}
+
+// content returns the media of the example value, JSON unless given
+func (s *openAPI) content(v interface{}, media string) map[string]*mediaType {
+	if media == "" {
+		media = "application/json"
+	}
+
+	var sch *schema
+	if values, ok := v.([]interface{}); ok {
+		sch = &schema{}
+		for _, value := range values {
+			sch.OneOf = append(sch.OneOf, s.schemaOf(reflect.TypeOf(value)))
+		}
+	} else {
+		sch = s.schemaOf(reflect.TypeOf(v))
+	}
+	return map[string]*mediaType{media: {Schema: sch}}
+}
+
+// schemaOf returns the schema of values of the type as encoding/json writes
+// them. Structs are added to the components, and referenced
+func (s *openAPI) schemaOf(t reflect.Type) *schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return &schema{Type: "string", Format: "date-time"}
+	case iskType:
+		return &schema{Type: "number", Format: "double"}
+	case rawJSONType:
+		return &schema{Type: "object"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &schema{Type: "integer", Format: "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8,
+		reflect.Uint16, reflect.Uint32:
+		return &schema{Type: "integer", Format: "int32"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &schema{Type: "string", Format: "byte"}
+		}
+		return &schema{Type: "array", Items: s.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: s.schemaOf(t.Elem())}
+	case reflect.Interface:
+		return &schema{}
+	case reflect.Struct:
+		return s.structRef(t)
+	}
+	panic(fmt.Errorf("no schema of %s", t))
+}
+
+// structRef adds the struct to the components, returning a reference to it
+func (s *openAPI) structRef(t reflect.Type) *schema {
+	name := t.Name()
+	name = strings.ToUpper(name[:1]) + name[1:]
+	ref := &schema{Ref: "#/components/schemas/" + name}
+	if _, found := s.Components.Schemas[name]; found {
+		return ref
+	}
+
+	extras, hasExtras := schemaExtras[t]
+	if reflect.PtrTo(t).Implements(marshalerType) && !hasExtras {
+		panic(fmt.Errorf("%s has its own MarshalJSON, add its schemaExtras", t))
+	}
+
+	// set before the fields, for types referencing themselves
+	sch := &schema{Type: "object", Properties: map[string]*schema{}}
+	s.Components.Schemas[name] = sch
+	s.addFields(sch, t)
+	for property, extra := range extras {
+		sch.Properties[property] = extra
+	}
+	return ref
+}
+
+// addFields adds the properties of the struct's fields, inlining those of
+// embedded structs as encoding/json does
+func (s *openAPI) addFields(sch *schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				s.addFields(sch, embedded)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		sch.Properties[name] = s.schemaOf(field.Type)
+	}
+}
+
+// OpenAPI serves the OpenAPI 3 document of the API
+func OpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		write405(w, r)
+		return
+	}
+	writeJSONFor(w, openAPISpec, 0)
+}
+
+// openAPISpec is built once, it only changes with the code
+var openAPISpec = newOpenAPI(apiRoutes)
+
+// docsCSP allows the Swagger UI assets from unpkg
+const docsCSP = "default-src 'none'; connect-src 'self'; " +
+	"script-src https://unpkg.com 'unsafe-inline'; " +
+	"style-src https://unpkg.com; img-src 'self' data:"
+
+var docsPage = []byte(`<!doctype html>
+<html lang="en">
+ <head>
+  <meta charset="utf-8">
+  <title>ESI ISK - API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+ </head>
+ <body>
+  <div id="docs"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: "/api/openapi.json", dom_id: "#docs"});</script>
+ </body>
+</html>
+`)
+
+// Docs serves a Swagger UI of the OpenAPI document, only in debug mode
+func Docs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		write405(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", docsCSP)
+	write(w, http.StatusOK, docsPage)
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// registeredRoutes returns the patterns RunServer registers on its mux,
+// including those registered in a loop over a map of routes
+func registeredRoutes(t *testing.T) map[string]bool {
+	file, err := parser.ParseFile(token.NewFileSet(), "../web.go", nil, 0)
+	if err != nil {
+		t.Fatalf("failed to parse web.go: %+v", err)
+	}
+
+	routes := map[string]bool{}
+	addString := func(expr ast.Expr) bool {
+		lit, ok := expr.(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return false
+		}
+		route, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			t.Fatalf("failed to unquote %s: %+v", lit.Value, err)
+		}
+		routes[route] = true
+		return true
+	}
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.CallExpr:
+			sel, ok := n.Fun.(*ast.SelectorExpr)
+			if !ok || len(n.Args) == 0 {
+				return true
+			}
+			if ident, ok := sel.X.(*ast.Ident); !ok || ident.Name != "mux" {
+				return true
+			}
+			if sel.Sel.Name != "Handle" && sel.Sel.Name != "HandleFunc" {
+				return true
+			}
+			if _, ok := n.Args[0].(*ast.Ident); !ok && !addString(n.Args[0]) {
+				t.Errorf("unexpected route %#v", n.Args[0])
+			}
+		case *ast.RangeStmt:
+			if lit, ok := n.X.(*ast.CompositeLit); ok {
+				for _, elt := range lit.Elts {
+					if kv, ok := elt.(*ast.KeyValueExpr); ok {
+						addString(kv.Key)
+					}
+				}
+			}
+		}
+		return true
+	})
+	return routes
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	routes := registeredRoutes(t)
+	if len(routes) == 0 {
+		t.Fatal("no routes found in web.go")
+	}
+
+	for route := range routes {
+		if _, found := openAPISpec.Paths[route]; !found {
+			t.Errorf("route %s is missing from the OpenAPI document", route)
+		}
+	}
+	for path := range openAPISpec.Paths {
+		if !routes[path] {
+			t.Errorf("documented path %s is not registered", path)
+		}
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	w := httptest.NewRecorder()
+	OpenAPI(w, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, received %d", w.Code)
+	}
+
+	doc := struct {
+		OpenAPI    string                            `json:"openapi"`
+		Paths      map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to decode document: %+v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("expected an OpenAPI 3 document, received %q", doc.OpenAPI)
+	}
+	if _, found := doc.Paths["/api/prefs"]["post"]; !found {
+		t.Error("expected POST /api/prefs to be documented")
+	}
+
+	// every reference resolves to a component
+	for _, ref := range strings.Split(w.Body.String(), `"$ref":"`)[1:] {
+		name := ref[:strings.Index(ref, `"`)]
+		name = strings.TrimPrefix(name, "#/components/schemas/")
+		if _, found := doc.Components.Schemas[name]; !found {
+			t.Errorf("unresolved reference to %s", name)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	OpenAPI(w, httptest.NewRequest(http.MethodPost, "/api/openapi.json", nil))
+	if w.Code != 405 {
+		t.Errorf("expected 405, received %d", w.Code)
+	}
+}
+
+func TestOpenAPIMarshalers(t *testing.T) {
+	spec := newOpenAPI(apiRoutes)
+	character := spec.Components.Schemas["Character"]
+	if character == nil {
+		t.Fatal("expected a Character schema")
+	}
+	for _, property := range []string{"id", "received_isk", "received_isk_short"} {
+		if _, found := character.Properties[property]; !found {
+			t.Errorf("expected character property %s", property)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected an undocumented MarshalJSON to panic")
+		}
+	}()
+	newOpenAPI(apiRoutes).schemaOf(reflect.TypeOf(&undocumented{}))
+}
+
+// undocumented marshals itself without any schemaExtras
+type undocumented struct{}
+
+func (u *undocumented) MarshalJSON() ([]byte, error) { return []byte("{}"), nil }
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// page limits, for routes taking limit and offset, or cursor, query args
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+var errBadPage = fmt.Errorf(
+	"limit must be 1 to %d and offset must not be negative",
+	maxPageLimit,
+)
+
+// page is the limit and offset of a paginated request, included in responses
+type page struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// getPage reads the limit and offset query args
+func getPage(r *http.Request) (*page, error) {
+	p := &page{Limit: defaultPageLimit}
+	query := r.URL.Query()
+
+	for arg, dest := range map[string]*int{"limit": &p.Limit, "offset": &p.Offset} {
+		raw := query.Get(arg)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, errBadPage
+		}
+		*dest = n
+	}
+
+	if p.Limit < 1 || p.Limit > maxPageLimit || p.Offset < 0 {
+		return nil, errBadPage
+	}
+	return p, nil
+}
+
+var (
+	errBadLimit  = fmt.Errorf("limit must be 1 to %d", maxPageLimit)
+	errBadCursor = errors.New("invalid cursor")
+)
+
+// cursorPage is the limit and cursor of a keyset paginated request
+type cursorPage struct {
+	Limit int
+	After *db.Cursor
+}
+
+// nextCursor is included in pages only when there are more rows after them
+type nextCursor struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// encodeCursor returns the opaque cursor of the timestamp and ID, as clients
+// pass it back
+func encodeCursor(c *db.Cursor) string {
+	raw := fmt.Sprintf("%d,%d", c.Timestamp.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor reads a cursor of encodeCursor
+func decodeCursor(s string) (*db.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errBadCursor
+	}
+
+	parts := strings.Split(string(raw), ",")
+	if len(parts) != 2 {
+		return nil, errBadCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, errBadCursor
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, errBadCursor
+	}
+	return &db.Cursor{Timestamp: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+// getCursorPage reads the limit and cursor query args
+func getCursorPage(r *http.Request) (*cursorPage, error) {
+	p := &cursorPage{Limit: defaultPageLimit}
+	query := r.URL.Query()
+
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return nil, errBadLimit
+		}
+		p.Limit = n
+	}
+
+	if raw := query.Get("cursor"); raw != "" {
+		after, err := decodeCursor(raw)
+		if err != nil {
+			return nil, err
+		}
+		p.After = after
+	}
+	return p, nil
+}
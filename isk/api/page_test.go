@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+func TestGetPage(t *testing.T) {
+	for query, expected := range map[string]*page{
+		"":                    {Limit: defaultPageLimit},
+		"?limit=10":           {Limit: 10},
+		"?limit=10&offset=20": {Limit: 10, Offset: 20},
+		"?offset=5":           {Limit: defaultPageLimit, Offset: 5},
+		"?limit=0":            nil,
+		"?limit=501":          nil,
+		"?offset=-1":          nil,
+		"?limit=ten":          nil,
+	} {
+		p, err := getPage(httptest.NewRequest(http.MethodGet, "/api/x"+query, nil))
+		if expected == nil {
+			if err == nil {
+				t.Errorf("%q: expected an error, read %+v", query, p)
+			}
+		} else if err != nil || *p != *expected {
+			t.Errorf("%q: received %+v (%v), expected %+v", query, p, err, expected)
+		}
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	expected := &db.Cursor{
+		Timestamp: time.Date(2019, 1, 20, 12, 0, 0, 123456000, time.UTC),
+		ID:        9876543210,
+	}
+	received, err := decodeCursor(encodeCursor(expected))
+	if err != nil {
+		t.Fatalf("failed to decode cursor: %+v", err)
+	}
+	if !received.Timestamp.Equal(expected.Timestamp) ||
+		received.ID != expected.ID {
+		t.Errorf("expected %+v, received %+v", expected, received)
+	}
+}
+
+func TestGetCursorPage(t *testing.T) {
+	cursor := encodeCursor(&db.Cursor{Timestamp: time.Unix(10, 0), ID: 3})
+	for query, expected := range map[string]bool{
+		"":                            true,
+		"?limit=10":                   true,
+		"?cursor=" + cursor:           true,
+		"?limit=0":                    false,
+		"?limit=501":                  false,
+		"?cursor=%21%21":              false,
+		"?cursor=bm90IGEgY3Vyc29y":    false,
+		"?cursor=MTAsdGhyZWU":         false,
+		"?cursor=" + cursor + "&x=1":  true,
+		"?cursor=" + cursor + "extra": false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/x"+query, nil)
+		p, err := getCursorPage(r)
+		if expected && err != nil {
+			t.Errorf("%q: unexpected error %+v", query, err)
+		} else if !expected && err == nil {
+			t.Errorf("%q: expected an error, read %+v", query, p)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/x?cursor="+cursor, nil)
+	p, err := getCursorPage(r)
+	if err != nil || p.Limit != defaultPageLimit || p.After == nil ||
+		p.After.ID != 3 || p.After.Timestamp.Unix() != 10 {
+		t.Errorf("unexpected page %+v (%v)", p, err)
+	}
+}
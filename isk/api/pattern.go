@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// previewAge is how long ago the sample donation of a preview was made
+const previewAge = 5 * time.Minute
+
+// previewRequest is the body of a pattern preview
+type previewRequest struct {
+	Pattern string `json:"pattern"`
+}
+
+// previewResponse is the pattern rendered against sample data, as HTML
+type previewResponse struct {
+	Preview string `json:"preview"`
+}
+
+// PreviewPattern renders a row pattern against a sample donation
+func PreviewPattern(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodPost {
+			write405(w, r)
+			return
+		}
+
+		if sessionCharacter(r) == 0 {
+			write403(w, r)
+			return
+		}
+
+		req := &previewRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			write400(w, r, "invalid preview request")
+			return
+		}
+
+		preview, err := previewRow(ctx, req.Pattern, time.Now().UTC())
+		if err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(ctx, w, &previewResponse{Preview: preview})
+	}
+}
+
+// previewRow renders the pattern as it would be for a sample donation
+func previewRow(ctx context.Context, pattern string, now time.Time) (
+	string,
+	error,
+) {
+	p := &db.Prefs{Pattern: pattern}
+	if err := p.Sanity(ctx); err != nil {
+		return "", err
+	}
+
+	amount := db.NewISK(12345678.9)
+	ts := now.Add(-previewAge)
+
+	if db.IsTemplate(pattern) {
+		values := tokenValues("Some Pilot", amount, "o7 <3", ts, now)
+		values["corp"] = "Some Corporation"
+		return string(renderRow(pattern, values)), nil
+	}
+
+	replacements := stdReplacements(amount, ts)
+	replacements["%NAME%"] = "Your Character"
+	replacements["%CHARACTER%"] = "Some Pilot"
+	replacements["%NOTE%"] = "o7 <3"
+	replacements["%ITEMS%"] = "3"
+	return string(replaceRow(pattern, replacements)), nil
+}
+
+// tokenValues returns the values of the {{token}}s of a row pattern, other
+// than corp which costs a lookup
+func tokenValues(
+	name string,
+	amount db.ISK,
+	note string,
+	ts, now time.Time,
+) map[string]string {
+	printer := message.NewPrinter(language.English)
+	return map[string]string{
+		"name":         name,
+		"amount":       printer.Sprintf("%.2f", amount.Float64()),
+		"amount_short": shortISK(amount),
+		"note":         note,
+		"time_ago":     timeAgo(ts, now),
+	}
+}
+
+// donorCorporation returns the last known corporation name of the donator
+func donorCorporation(ctx context.Context, charID int32) string {
+	if charID == 0 {
+		return ""
+	}
+	c, err := db.GetCharacter(ctx, charID)
+	if err != nil {
+		cx.Logf(ctx, "failed to get corporation of %d: %+v", charID, err)
+		return ""
+	}
+	return c.CorporationName
+}
+
+// shortISK formats the amount with a magnitude suffix, eg 12.3M
+func shortISK(amount db.ISK) string {
+	isk := amount.Float64()
+	for _, unit := range []struct {
+		suffix string
+		size   float64
+	}{
+		{"T", 1e12},
+		{"B", 1e9},
+		{"M", 1e6},
+		{"K", 1e3},
+	} {
+		if isk >= unit.size {
+			short := fmt.Sprintf("%.1f", isk/unit.size)
+			return strings.TrimSuffix(short, ".0") + unit.suffix
+		}
+	}
+	return fmt.Sprintf("%.0f", isk)
+}
+
+// timeAgo describes how long before now ts was, eg "5 minutes ago"
+func timeAgo(ts, now time.Time) string {
+	since := now.Sub(ts)
+	for _, unit := range []struct {
+		name string
+		size time.Duration
+	}{
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	} {
+		if n := int(since / unit.size); n > 0 {
+			if n == 1 {
+				return "1 " + unit.name + " ago"
+			}
+			return fmt.Sprintf("%d %ss ago", n, unit.name)
+		}
+	}
+	return "just now"
+}
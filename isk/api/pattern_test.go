@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+func TestPreviewRow(t *testing.T) {
+	ctx := context.WithValue(context.Background(), cx.Opts, &cx.Options{
+		MaxPrefRows:   10,
+		MaxPrefLen:    100,
+		MaxPatternLen: 100,
+	})
+	now := time.Date(2018, 12, 25, 22, 34, 0, 0, time.UTC)
+
+	for pattern, expected := range map[string]string{
+		"{{name}} ({{corp}}) sent {{amount_short}} {{time_ago}}: {{note}}": "Some Pilot (Some Corporation) sent 12.3M 5 minutes ago: o7 &lt;3",
+		"{{amount}}":                "12,345,678.90",
+		"%CHARACTER% sent %AMOUNT%": "Some Pilot sent 12,345,678.90",
+	} {
+		received, err := previewRow(ctx, pattern, now)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %+v", pattern, err)
+		} else if received != expected {
+			t.Errorf("%q: received %q, expected %q", pattern, received, expected)
+		}
+	}
+
+	for _, pattern := range []string{"{{bogus}}", strings.Repeat("x", 101)} {
+		if _, err := previewRow(ctx, pattern, now); err == nil {
+			t.Errorf("%q: expected an error", pattern)
+		} else if ue, ok := err.(db.UserError); !ok || ue.Code != 400 {
+			t.Errorf("%q: expected a 400 user error, received %+v", pattern, err)
+		}
+	}
+}
+
+func TestPreviewPatternRequiresSession(t *testing.T) {
+	w := httptest.NewRecorder()
+	PreviewPattern(testAuthContext())(w, httptest.NewRequest(
+		http.MethodPost,
+		"/api/prefs/preview",
+		strings.NewReader(`{"pattern": "{{name}}"}`),
+	))
+	if w.Code != 403 {
+		t.Errorf("expected 403, received %d", w.Code)
+	}
+}
+
+func TestShortISK(t *testing.T) {
+	for isk, expected := range map[float64]string{
+		0:          "0",
+		999:        "999",
+		1000:       "1K",
+		1250:       "1.2K",
+		12345678.9: "12.3M",
+		1e9:        "1B",
+		2.55e12:    "2.5T",
+	} {
+		if received := shortISK(db.NewISK(isk)); received != expected {
+			t.Errorf("%f: received %q, expected %q", isk, received, expected)
+		}
+	}
+}
+
+func TestTimeAgo(t *testing.T) {
+	now := time.Now()
+	for since, expected := range map[time.Duration]string{
+		0:                 "just now",
+		59 * time.Second:  "just now",
+		time.Minute:       "1 minute ago",
+		150 * time.Minute: "2 hours ago",
+		49 * time.Hour:    "2 days ago",
+	} {
+		if received := timeAgo(now.Add(-since), now); received != expected {
+			t.Errorf("%s: received %q, expected %q", since, received, expected)
+		}
+	}
+}
@@ -1,8 +1,14 @@
 package api
 
 import (
+	"context"
 	"log"
 	"net/http"
+
+	"github.com/a-tal/esi-isk/isk/buildinfo"
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
 )
 
 // Ping returns a simple 200 ok
@@ -11,3 +17,45 @@ func Ping(w http.ResponseWriter, _req *http.Request) {
 		log.Println("failed to write ping response")
 	}
 }
+
+// Ready returns 200 once every statement is prepared and the response cache
+// is warmed, and while the database responds, so the API isn't sent traffic
+// with SQL that can't run, or before it can answer it from the cache
+func Ready(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if warmer, ok := ctx.Value(cx.Warmer).(*Warmer); ok && !warmer.Warmed() {
+			http.Error(w, "warming cache", http.StatusServiceUnavailable)
+			return
+		}
+		if err := db.Ready(ctx); err != nil {
+			log.Printf("not ready: %+v", err)
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		if _, err := w.Write([]byte("ok")); err != nil {
+			log.Println("failed to write ready response")
+		}
+	}
+}
+
+// CacheStats returns the response cache hit and miss counters
+func CacheStats(ctx context.Context) http.HandlerFunc {
+	respCache := ctx.Value(cx.ResponseCache).(*cache.Cache)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+		writeJSONFor(w, respCache.Stats(), 0)
+	}
+}
+
+// Version returns the build info of the running API
+func Version(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		write405(w, r)
+		return
+	}
+	writeJSONFor(w, buildinfo.Get(), 0)
+}
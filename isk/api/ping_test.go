@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/buildinfo"
+)
+
+func TestVersion(t *testing.T) {
+	w := httptest.NewRecorder()
+	Version(w, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, received %d", w.Code)
+	}
+
+	info := &buildinfo.Info{}
+	if err := json.Unmarshal(w.Body.Bytes(), info); err != nil {
+		t.Fatalf("failed to decode version: %+v", err)
+	}
+	if info.Version != buildinfo.Version || info.GoVersion != runtime.Version() {
+		t.Errorf("unexpected version response: %+v", info)
+	}
+
+	w = httptest.NewRecorder()
+	Version(w, httptest.NewRequest(http.MethodPost, "/api/version", nil))
+	if w.Code != 405 {
+		t.Errorf("expected 405, received %d", w.Code)
+	}
+}
+
+func TestReadyUnprepared(t *testing.T) {
+	w := httptest.NewRecorder()
+	Ready(context.Background())(
+		w,
+		httptest.NewRequest(http.MethodGet, "/api/ready", nil),
+	)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, received %d", w.Code)
+	}
+}
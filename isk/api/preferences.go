@@ -5,40 +5,26 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"hash/fnv"
-	"log"
 	"net/http"
-	"net/url"
-	"sort"
 
+	"github.com/a-tal/esi-isk/isk/cache"
 	"github.com/a-tal/esi-isk/isk/cx"
 	"github.com/a-tal/esi-isk/isk/db"
-	sessions "github.com/goincremental/negroni-sessions"
-	cache "github.com/victorspringer/http-cache"
 )
 
 // Preferences handles getting and setting user preferences
 func Preferences(ctx context.Context) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet && r.Method != http.MethodPost {
-			write405(w)
-			return
-		}
+		ctx := requestContext(ctx, r)
 
-		session := sessions.GetSession(r)
-		char := session.Get("c")
-		if char == nil {
-			if r.Method == http.MethodGet {
-				http.Redirect(w, r, "/login", 302)
-			} else {
-				write403(w)
-			}
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			write405(w, r)
 			return
 		}
 
-		charID, ok := char.(int32)
-		if !ok || charID < 1 {
-			write403(w)
+		charID := sessionCharacter(r)
+		if charID == 0 {
+			write403(w, r)
 			return
 		}
 
@@ -56,11 +42,19 @@ func writePreferences(w http.ResponseWriter, r *http.Request, charID int32) {
 		return
 	}
 
+	needsReauth, err := db.NeedsReauth(r.Context(), charID)
+	if err != nil {
+		cx.Logf(r.Context(), "failed to check user token: %+v", err)
+	}
+
 	if p.Contracts != nil && p.Donations != nil {
+		p.NeedsReauth = needsReauth
 		writeJSON(r.Context(), w, p)
 	} else if p.Contracts != nil {
+		p.Contracts.NeedsReauth = needsReauth
 		writeJSON(r.Context(), w, p.Contracts)
 	} else {
+		p.Donations.NeedsReauth = needsReauth
 		writeJSON(r.Context(), w, p.Donations)
 	}
 }
@@ -68,21 +62,29 @@ func writePreferences(w http.ResponseWriter, r *http.Request, charID int32) {
 func updatePreferences(w http.ResponseWriter, r *http.Request, charID int32) {
 	t, err := getPrefType(r)
 	if err != nil {
-		write400(w)
+		write400(w, r, err.Error())
 		return
 	}
 
 	p, readErr := readPreferences(r, t)
 	if readErr != nil {
-		write400(w)
+		if ue, ok := readErr.(db.UserError); ok {
+			writeDBError(w, r, ue)
+		} else {
+			write400(w, r, "invalid preferences")
+		}
 		return
 	}
 
 	ctx := r.Context()
 
 	if err := db.SetPreferences(ctx, charID, p); err != nil {
-		log.Printf("failed to set user preferences: %+v", err)
-		write400(w)
+		cx.Logf(ctx, "failed to set user preferences: %+v", err)
+		if ue, ok := err.(db.UserError); ok {
+			writeDBError(w, r, ue)
+		} else {
+			write400(w, r, "failed to set preferences")
+		}
 	} else {
 		dropCustomAPICache(ctx, charID, p, t)
 		w.WriteHeader(204)
@@ -111,30 +113,7 @@ func dropCustomAPICache(
 }
 
 func dropCache(ctx context.Context, path string) {
-	u, err := url.Parse(path)
-	if err == nil {
-		adapter := ctx.Value(cx.Adapter).(cache.Adapter)
-		sortURLParams(u)
-		adapter.Release(generateKey(u.String()))
-	}
-}
-
-func sortURLParams(URL *url.URL) {
-	params := URL.Query()
-	for _, param := range params {
-		sort.Slice(param, func(i, j int) bool {
-			return param[i] < param[j]
-		})
-	}
-	URL.RawQuery = params.Encode()
-}
-
-func generateKey(URL string) uint64 {
-	hash := fnv.New64a()
-	if _, err := hash.Write([]byte(URL)); err != nil {
-		log.Printf("failed to hash url: %+v", err)
-	}
-	return hash.Sum64()
+	ctx.Value(cx.ResponseCache).(*cache.Cache).Release(path)
 }
 
 func getPrefType(r *http.Request) (string, error) {
@@ -202,20 +181,86 @@ func getPreferences(w http.ResponseWriter, r *http.Request, charID int32) (
 ) {
 	t, err := getPrefType(r)
 	if err != nil {
-		write400(w)
+		write400(w, r, err.Error())
 		return nil, err
 	}
 
 	prefs, err := db.GetPreferences(r.Context(), t, charID)
 	if err != nil {
-		if ue, ok := err.(*db.UserError); ok {
-			write(w, ue.Code, ue.Msg)
-			return nil, err
-		}
-		log.Printf("failed to get user preferences: %+v", err)
-		write500(w)
+		writeDBError(w, r, err)
 		return nil, err
 	}
 
 	return prefs, nil
 }
+
+// ExportPreferences returns the preferences of every view as one document
+func ExportPreferences(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		charID := sessionCharacter(r)
+		if charID == 0 {
+			write403(w, r)
+			return
+		}
+
+		d, err := db.GetPreferenceDocument(ctx, charID)
+		if err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set(
+			"Content-Disposition",
+			fmt.Sprintf(`attachment; filename="esi-isk-preferences-%d.json"`, charID),
+		)
+		writeJSON(ctx, w, d)
+	}
+}
+
+// ImportPreferences replaces the preferences of every view from a document
+// made by ExportPreferences, nothing is changed if any view is invalid
+func ImportPreferences(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodPost {
+			write405(w, r)
+			return
+		}
+
+		charID := sessionCharacter(r)
+		if charID == 0 {
+			write403(w, r)
+			return
+		}
+
+		d := &db.PreferenceDocument{}
+		if err := json.NewDecoder(r.Body).Decode(d); err != nil {
+			write400(w, r, "invalid preferences")
+			return
+		}
+
+		if err := d.Sanity(ctx); err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+
+		if err := db.ImportPreferences(ctx, charID, d); err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		ctx.Value(cx.ResponseCache).(*cache.Cache).Invalidate(
+			cache.CharacterTag(charID),
+		)
+		w.WriteHeader(204)
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestImportPreferencesValidation(t *testing.T) {
+	ctx := context.WithValue(context.Background(), cx.Opts, &cx.Options{
+		MaxPrefRows:   10,
+		MaxPrefLen:    100,
+		MaxPatternLen: 100,
+	})
+	prefs := `{"pattern": "{{name}}", "rows": 5}`
+
+	check := func(name string, charID int32, method, body string, expected int) {
+		r := httptest.NewRequest(method, "/api/prefs/import", strings.NewReader(body))
+		if charID > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), cx.Character, charID))
+		}
+		w := httptest.NewRecorder()
+		ImportPreferences(ctx)(w, r)
+		if w.Code != expected {
+			t.Errorf("%s: expected %d, received %d", name, expected, w.Code)
+		}
+	}
+
+	check("logged out", 0, http.MethodPost, "{}", 403)
+	check("wrong method", 1, http.MethodGet, "", 405)
+	check("not json", 1, http.MethodPost, "prefs", 400)
+	check("missing views", 1, http.MethodPost, `{"donations": `+prefs+`}`, 400)
+	check("bad token", 1, http.MethodPost, `{
+		"donations": `+prefs+`,
+		"contracts": `+prefs+`,
+		"combined": {"donations": `+prefs+`, "contracts": {"pattern": "{{bogus}}"}}
+	}`, 400)
+}
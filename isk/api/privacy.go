@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// Privacy handles getting and setting character wide privacy preferences
+func Privacy(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			write405(w, r)
+			return
+		}
+
+		charID := sessionCharacter(r)
+		if charID == 0 {
+			write403(w, r)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			p, err := db.GetPrivacy(ctx, charID)
+			if err != nil {
+				writeDBError(w, r, err)
+				return
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(ctx, w, p)
+			return
+		}
+
+		p := &db.Privacy{}
+		if err := json.NewDecoder(r.Body).Decode(p); err != nil {
+			write400(w, r, "invalid privacy preferences")
+			return
+		}
+
+		if err := db.SetPrivacy(ctx, charID, p); err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+
+		invalidatePrivacy(ctx, charID)
+		w.WriteHeader(204)
+	}
+}
+
+// invalidatePrivacy drops the cached responses showing the character, on
+// every API server. The character shows on their own page, the pages of
+// everyone they sent to or received from, and the leaderboards
+func invalidatePrivacy(ctx context.Context, charID int32) {
+	respCache := ctx.Value(cx.ResponseCache).(*cache.Cache)
+
+	charIDs, err := db.GetCounterparties(ctx, charID)
+	if err != nil {
+		cx.Logf(ctx, "failed to get counterparties of %d: %+v", charID, err)
+		respCache.Purge()
+		return
+	}
+	charIDs = append(charIDs, charID)
+
+	tags := []string{cache.TopTag}
+	for _, id := range charIDs {
+		tags = append(tags, cache.CharacterTag(id))
+	}
+	respCache.Invalidate(tags...)
+
+	if err := db.NotifyCharacters(ctx, charIDs); err != nil {
+		cx.Logf(ctx, "failed to notify character updates: %+v", err)
+	}
+}
+
+// LoggedIn is true for requests with a session, which bypass the response
+// cache as recipients see the names of their anonymous donators
+func LoggedIn(r *http.Request) bool {
+	return sessionCharacter(r) != 0
+}
+
+// maskAnonymous hides the anonymous donators of the character, unless the
+// character themselves is asking
+func maskAnonymous(ctx context.Context, r *http.Request, c *db.CharDetails) error {
+	if sessionCharacter(r) == c.Character.ID {
+		return nil
+	}
+	return c.MaskAnonymous(ctx)
+}
+
+// hidesTransfers is true if either character is banned or anonymous, and
+// neither of them is asking for the transfers between them
+func hidesTransfers(ctx context.Context, r *http.Request, a, b int32) (
+	bool,
+	error,
+) {
+	if viewer := sessionCharacter(r); viewer == a || viewer == b {
+		return false, nil
+	}
+
+	banned, err := db.GetBanned(ctx, []int32{a, b})
+	if err != nil {
+		return false, err
+	}
+	if len(banned) > 0 {
+		return true, nil
+	}
+
+	anonymous, err := db.GetAnonymous(ctx, []int32{a, b})
+	if err != nil {
+		return false, err
+	}
+	return len(anonymous) > 0, nil
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+func TestPrivacyValidation(t *testing.T) {
+	ctx := testAuthContext()
+
+	check := func(name string, charID int32, method, body string, expected int) {
+		r := httptest.NewRequest(method, "/api/prefs/privacy", strings.NewReader(body))
+		if charID > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), cx.Character, charID))
+		}
+		w := httptest.NewRecorder()
+		Privacy(ctx)(w, r)
+		if w.Code != expected {
+			t.Errorf("%s: expected %d, received %d", name, expected, w.Code)
+		}
+	}
+
+	check("logged out", 0, http.MethodGet, "", 403)
+	check("wrong method", 1, http.MethodDelete, "", 405)
+	check("not json", 1, http.MethodPost, "anonymous", 400)
+}
+
+func TestHidesTransfersFromParticipants(t *testing.T) {
+	// either character may always see the transfers between them, without
+	// any anonymity lookup
+	for _, viewer := range []int32{1, 2} {
+		r := httptest.NewRequest(http.MethodGet, "/api/char/1/from/2", nil)
+		r = r.WithContext(context.WithValue(r.Context(), cx.Character, viewer))
+
+		hidden, err := hidesTransfers(context.Background(), r, 1, 2)
+		if err != nil || hidden {
+			t.Errorf("transfers hidden from character %d: %+v", viewer, err)
+		}
+	}
+}
+
+func TestHiddenFrom(t *testing.T) {
+	check := func(name string, c *db.Character, viewer int32, expected bool) {
+		r := httptest.NewRequest(http.MethodGet, "/api/char?c=1", nil)
+		if viewer > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), cx.Character, viewer))
+		}
+		if received := hiddenFrom(r, c); received != expected {
+			t.Errorf("%s: received %t, expected %t", name, received, expected)
+		}
+	}
+
+	check("visible", &db.Character{ID: 1}, 0, false)
+	check("hidden, logged out", &db.Character{ID: 1, Hidden: true}, 0, true)
+	check("hidden, someone else", &db.Character{ID: 1, Hidden: true}, 2, true)
+	check("hidden, themselves", &db.Character{ID: 1, Hidden: true}, 1, false)
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+var (
+	errBadDonor     = errors.New("donor must be a character ID")
+	errBadRecipient = errors.New("recipient must be a character ID")
+	errBadMinAmount = errors.New("min_amount must be an amount of ISK")
+	errBadAfter     = errors.New("after must be an RFC 3339 timestamp")
+)
+
+// Verify says if the donor sent the recipient at least min_amount ISK after
+// a time, with the matching donation IDs and their total. It has its own,
+// stricter, rate limit per IP, as it can be used to enumerate donations
+func Verify(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	limits := newLimiter()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		if opts.VerifyRateLimit > 0 {
+			allowed, wait := limits.allow(
+				clientIP(r, opts.TrustedProxy),
+				opts.VerifyRateLimit,
+				opts.VerifyRateLimit,
+				time.Now(),
+			)
+			if !allowed {
+				writeRetryAfter(w, r, wait)
+				return
+			}
+		}
+
+		q, err := getProofQuery(r)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		charID := sessionCharacter(r)
+		q.Party = charID > 0 && (charID == q.Donor || charID == q.Recipient)
+
+		proof, err := db.ProveDonated(ctx, q)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSONFor(w, proof, 0)
+	}
+}
+
+// getProofQuery reads the donor, recipient, min_amount and after query args.
+// Without min_amount any donation is enough, without after all are counted
+func getProofQuery(r *http.Request) (*db.ProofQuery, error) {
+	args := r.URL.Query()
+	q := &db.ProofQuery{After: time.Unix(0, 0).UTC()}
+
+	donor, err := strconv.ParseInt(args.Get("donor"), 10, 32)
+	if err != nil || donor < 1 {
+		return nil, errBadDonor
+	}
+	q.Donor = int32(donor)
+
+	recipient, err := strconv.ParseInt(args.Get("recipient"), 10, 32)
+	if err != nil || recipient < 1 {
+		return nil, errBadRecipient
+	}
+	q.Recipient = int32(recipient)
+
+	if raw := args.Get("min_amount"); raw != "" {
+		amount, err := strconv.ParseFloat(raw, 64)
+		if err != nil || amount < 0 || math.IsInf(amount, 0) {
+			return nil, errBadMinAmount
+		}
+		q.MinAmount = db.NewISK(amount)
+	}
+
+	if raw := args.Get("after"); raw != "" {
+		after, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, errBadAfter
+		}
+		q.After = after.UTC()
+	}
+
+	return q, nil
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+func TestGetProofQuery(t *testing.T) {
+	for query, expected := range map[string]*db.ProofQuery{
+		"?donor=2&recipient=1": {
+			Donor:     2,
+			Recipient: 1,
+			After:     time.Unix(0, 0).UTC(),
+		},
+		"?donor=2&recipient=1&min_amount=1.5&after=2019-01-20T12:00:00Z": {
+			Donor:     2,
+			Recipient: 1,
+			MinAmount: 150,
+			After:     time.Date(2019, 1, 20, 12, 0, 0, 0, time.UTC),
+		},
+		"":                                   nil,
+		"?donor=2":                           nil,
+		"?recipient=1":                       nil,
+		"?donor=0&recipient=1":               nil,
+		"?donor=2&recipient=one":             nil,
+		"?donor=2&recipient=1&min_amount=-1": nil,
+		"?donor=2&recipient=1&min_amount=x":  nil,
+		"?donor=2&recipient=1&after=monday":  nil,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/verify"+query, nil)
+		q, err := getProofQuery(r)
+		if expected == nil {
+			if err == nil {
+				t.Errorf("%q: expected an error, read %+v", query, q)
+			}
+		} else if err != nil || *q != *expected {
+			t.Errorf("%q: received %+v (%v), expected %+v", query, q, err, expected)
+		}
+	}
+}
+
+func TestVerifyRateLimit(t *testing.T) {
+	ctx := context.WithValue(context.Background(), cx.Opts, &cx.Options{
+		VerifyRateLimit: 2,
+	})
+	handler := Verify(ctx)
+
+	// invalid queries count too, so the limit is reached before any lookup
+	for i, expected := range []int{400, 400, 429} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/api/verify", nil))
+		if w.Code != expected {
+			t.Errorf("request %d: expected %d, received %d", i, expected, w.Code)
+		}
+		if expected == 429 && w.Header().Get("Retry-After") == "" {
+			t.Error("expected a Retry-After header")
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// bucketIdle is how long an unused bucket is kept before being dropped
+const bucketIdle = 10 * time.Minute
+
+// bucket is a token bucket for a single client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter is a set of token buckets refilling at rate tokens per second
+type limiter struct {
+	lock    *sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newLimiter() *limiter {
+	return &limiter{lock: &sync.Mutex{}, buckets: map[string]*bucket{}}
+}
+
+// allow takes a token from the key's bucket, or returns how long to wait
+// until one is available
+func (l *limiter) allow(
+	key string,
+	perMinute, burst int,
+	now time.Time,
+) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	rate := float64(perMinute) / 60
+	capacity := float64(burst)
+
+	if now.Sub(l.swept) > bucketIdle {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > bucketIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait
+}
+
+// clientIP returns the IP of the client, trusting the last X-Forwarded-For
+// entry (the one added by our proxy) only when behind a trusted proxy
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			if ip := net.ParseIP(strings.TrimSpace(forwarded[i])); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RateLimit limits the requests each client can make to the API
+func RateLimit(ctx context.Context) func(
+	http.ResponseWriter,
+	*http.Request,
+	http.HandlerFunc,
+) {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	limits := newLimiter()
+
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || opts.RateLimit < 1 {
+			next(w, r)
+			return
+		}
+
+		key := "ip:" + clientIP(r, opts.TrustedProxy)
+		perMinute := opts.RateLimit
+		if charID := sessionCharacter(r); charID > 0 {
+			key = fmt.Sprintf("char:%d", charID)
+			perMinute = opts.OwnerRateLimit
+		}
+
+		allowed, wait := limits.allow(key, perMinute, opts.RateBurst, time.Now())
+		if !allowed {
+			writeRetryAfter(w, r, wait)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// writeRetryAfter writes the 429 of a rate limit, saying when to retry
+func writeRetryAfter(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set(
+		"Retry-After",
+		fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))),
+	)
+	write429(w, r, "too many requests")
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter()
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a", 60, 3, now); !ok {
+			t.Fatalf("request %d within the burst was denied", i)
+		}
+	}
+
+	ok, wait := l.allow("a", 60, 3, now)
+	if ok {
+		t.Error("request over the burst was allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("invalid wait: %s", wait)
+	}
+
+	if ok, _ := l.allow("b", 60, 3, now); !ok {
+		t.Error("another client was denied")
+	}
+
+	if ok, _ := l.allow("a", 60, 3, now.Add(time.Second)); !ok {
+		t.Error("request after refill was denied")
+	}
+
+	l.allow("a", 60, 3, now.Add(time.Hour))
+	if _, found := l.buckets["b"]; found {
+		t.Error("idle bucket was not swept")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/top", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+
+	if ip := clientIP(r, false); ip != "10.0.0.1" {
+		t.Errorf("untrusted proxy: received %s", ip)
+	}
+	if ip := clientIP(r, true); ip != "2.2.2.2" {
+		t.Errorf("trusted proxy: received %s", ip)
+	}
+
+	r.Header.Set("X-Forwarded-For", "2.2.2.2, garbage")
+	if ip := clientIP(r, true); ip != "2.2.2.2" {
+		t.Errorf("trailing garbage: received %s", ip)
+	}
+
+	r.Header.Del("X-Forwarded-For")
+	if ip := clientIP(r, true); ip != "10.0.0.1" {
+		t.Errorf("no header: received %s", ip)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	opts := &cx.Options{
+		AppSecret:       "test-secret",
+		SessionLifetime: 3600,
+		RateLimit:       60,
+		OwnerRateLimit:  600,
+		RateBurst:       2,
+	}
+	ctx := context.WithValue(context.Background(), cx.Opts, opts)
+	sessions := Sessions(ctx)
+	limit := RateLimit(ctx)
+
+	request := func(path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		sessions(w, r, func(w http.ResponseWriter, r *http.Request) {
+			limit(w, r, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(200)
+			})
+		})
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := request("/api/top", nil); w.Code != 200 {
+			t.Fatalf("request %d was limited", i)
+		}
+	}
+
+	w := request("/api/top", nil)
+	if w.Code != 429 {
+		t.Errorf("expected 429, received %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("invalid Retry-After: %q", w.Header().Get("Retry-After"))
+	}
+
+	if w := request("/index.html", nil); w.Code != 200 {
+		t.Errorf("static file was limited: %d", w.Code)
+	}
+
+	// logged in owners have their own, larger, bucket
+	if w := request("/api/prefs", sessionCookies(t, opts, 42)); w.Code != 200 {
+		t.Errorf("owner was limited by their IP: %d", w.Code)
+	}
+}
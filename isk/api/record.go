@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// Donation returns the donation with the journal reference ID in the path,
+// with the names of both characters
+func Donation(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil || id < 1 {
+			write400(w, r, "invalid donation ID")
+			return
+		}
+
+		d, err := db.GetDonation(ctx, id)
+		if err == db.ErrDonationNotFound {
+			write404(w, r, "donation not found")
+			return
+		} else if err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+
+		if !checkRecordAccess(ctx, w, r, d.Donator, d.Recipient) {
+			return
+		}
+
+		if !isParty(r, d.Donator, d.Recipient) {
+			if err := d.MaskAnonymous(ctx); err != nil {
+				write500(w, r, err)
+				return
+			}
+		}
+
+		cache.Tag(
+			w,
+			cache.CharacterTag(d.Donator),
+			cache.CharacterTag(d.Recipient),
+		)
+		writeJSON(ctx, w, d)
+	}
+}
+
+// Contract returns the contract with the ID in the path, with its items and
+// the names of both characters
+func Contract(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+		if err != nil || id < 1 {
+			write400(w, r, "invalid contract ID")
+			return
+		}
+
+		k, err := db.GetContract(ctx, int32(id))
+		if err == db.ErrContractNotFound {
+			write404(w, r, "contract not found")
+			return
+		} else if err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+
+		if !checkRecordAccess(ctx, w, r, k.Donator, k.Receiver) {
+			return
+		}
+
+		if !isParty(r, k.Donator, k.Receiver) {
+			if err := k.MaskAnonymous(ctx); err != nil {
+				write500(w, r, err)
+				return
+			}
+		}
+
+		cache.Tag(
+			w,
+			cache.CharacterTag(k.Donator),
+			cache.CharacterTag(k.Receiver),
+		)
+		writeJSON(ctx, w, k)
+	}
+}
+
+// checkRecordAccess checks the receiver's page may be read, as the record is
+// on it. The donator can always read what they sent
+func checkRecordAccess(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	donator, receiver int32,
+) bool {
+	if donator > 0 && sessionCharacter(r) == donator {
+		return true
+	}
+	return checkCharacterAccess(ctx, w, r, receiver)
+}
+
+// isParty is true when the logged in character sent or received the record
+func isParty(r *http.Request, donator, receiver int32) bool {
+	charID := sessionCharacter(r)
+	return charID > 0 && (charID == donator || charID == receiver)
+}
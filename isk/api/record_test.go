@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestRecordValidation(t *testing.T) {
+	ctx := testAuthContext()
+	mux := http.NewServeMux()
+	mux.Handle("/api/donation/{id}", Donation(ctx))
+	mux.Handle("/api/contract/{id}", Contract(ctx))
+
+	for name, tc := range map[string]struct {
+		method, path string
+		expected     int
+	}{
+		"donation method": {http.MethodPost, "/api/donation/1", 405},
+		"donation zero":   {http.MethodGet, "/api/donation/0", 400},
+		"donation text":   {http.MethodGet, "/api/donation/one", 400},
+		"contract method": {http.MethodDelete, "/api/contract/1", 405},
+		"contract zero":   {http.MethodGet, "/api/contract/0", 400},
+		"contract large":  {http.MethodGet, "/api/contract/4294967296", 400},
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.expected {
+			t.Errorf("%s: expected %d, received %d", name, tc.expected, w.Code)
+		}
+	}
+}
+
+func TestIsParty(t *testing.T) {
+	for viewer, expected := range map[int32]bool{
+		0: false,
+		1: true,
+		2: true,
+		3: false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/donation/1", nil)
+		if viewer > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), cx.Character, viewer))
+		}
+		if isParty(r, 1, 2) != expected {
+			t.Errorf("character %d: expected party %v", viewer, expected)
+		}
+	}
+
+	// anonymous donators are masked to 0, which is never the viewer
+	r := httptest.NewRequest(http.MethodGet, "/api/donation/1", nil)
+	if isParty(r, 0, 2) {
+		t.Error("logged out viewer was a party of a masked donation")
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/urfave/negroni"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/metrics"
+)
+
+// panics counts every handler panic we recovered from
+var panics = metrics.NewCounter(
+	"esi_isk_http_panics_total",
+	"Handler panics recovered by the API server",
+)
+
+// Recover turns handler panics into 500 responses instead of empty replies
+func Recover(ctx context.Context) func(
+	http.ResponseWriter,
+	*http.Request,
+	http.HandlerFunc,
+) {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				// deliberate aborts are not errors, let the server drop them
+				panic(rec)
+			}
+
+			panics.Inc()
+			cx.Logf(
+				r.Context(),
+				"panic serving %s %s: %v\n%s",
+				r.Method,
+				r.URL.Path,
+				rec,
+				debug.Stack(),
+			)
+
+			if responseStarted(w) {
+				// part of a (streamed) response is already out, a 500 now would
+				// be appended to it. abort so the client sees it was truncated
+				panic(http.ErrAbortHandler)
+			}
+
+			for _, header := range []string{
+				"Content-Disposition",
+				"Content-Encoding",
+				"Content-Length",
+			} {
+				w.Header().Del(header)
+			}
+			writeError(w, r, 500, ErrCodeInternal, "internal error")
+		}()
+
+		next(w, r)
+	}
+}
+
+// responseStarted returns true if the status has already been written
+func responseStarted(w http.ResponseWriter) bool {
+	if rw, ok := w.(negroni.ResponseWriter); ok {
+		return rw.Written()
+	}
+	return false
+}
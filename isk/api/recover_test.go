@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/urfave/negroni"
+)
+
+func TestRecover(t *testing.T) {
+	handler := Recover(context.Background())
+	before := panics.Value()
+
+	w := httptest.NewRecorder()
+	handler(
+		negroni.NewResponseWriter(w),
+		httptest.NewRequest(http.MethodGet, "/api/char?c=1", nil),
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Disposition", "attachment")
+			panic("oops")
+		},
+	)
+
+	if w.Code != 500 {
+		t.Errorf("expected 500, received %d", w.Code)
+	}
+	if w.Header().Get("Content-Disposition") != "" {
+		t.Error("handler headers were sent with the error")
+	}
+
+	body := map[string]*apiError{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid error body: %+v", err)
+	}
+	if body["error"] == nil || body["error"].Code != ErrCodeInternal {
+		t.Errorf("unexpected error body: %s", w.Body.String())
+	}
+
+	if panics.Value() != before+1 {
+		t.Errorf("panic was not counted")
+	}
+}
+
+func TestRecoverMidStream(t *testing.T) {
+	handler := Recover(context.Background())
+
+	defer func() {
+		if rec := recover(); rec != http.ErrAbortHandler {
+			t.Errorf("expected the handler to abort, recovered %v", rec)
+		}
+	}()
+
+	handler(
+		negroni.NewResponseWriter(httptest.NewRecorder()),
+		httptest.NewRequest(http.MethodGet, "/api/user/export", nil),
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"character":`))
+			panic("oops")
+		},
+	)
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// Refresh queues an immediate poll of the logged in owner's character,
+// responding with the job to check the progress of
+func Refresh(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodPost {
+			write405(w, r)
+			return
+		}
+
+		charID, ok := ownedCharacter(w, r)
+		if !ok {
+			return
+		}
+
+		job, err := db.RequestRefresh(ctx, charID)
+		if err == db.ErrRefreshTooSoon {
+			w.Header().Set(
+				"Retry-After",
+				fmt.Sprintf("%.0f", db.RefreshInterval.Seconds()),
+			)
+			write429(w, r, "characters may only be refreshed once per minute")
+			return
+		} else if err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set(
+			"Location",
+			fmt.Sprintf("/api/char/%d/refresh/%d", charID, job.ID),
+		)
+		writeJSONStatus(w, http.StatusAccepted, job, 0)
+	}
+}
+
+// RefreshStatus returns a refresh job of the logged in owner's character
+func RefreshStatus(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		charID, ok := ownedCharacter(w, r)
+		if !ok {
+			return
+		}
+
+		jobID, err := strconv.ParseInt(r.PathValue("job"), 10, 32)
+		if err != nil {
+			write400(w, r, "invalid job ID")
+			return
+		}
+
+		job, err := db.GetRefresh(ctx, charID, int32(jobID))
+		if err == db.ErrRefreshNotFound {
+			write404(w, r, "refresh job not found")
+			return
+		} else if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(ctx, w, job)
+	}
+}
+
+// ownedCharacter returns the character of the path if it is the logged in
+// character, or writes an error
+func ownedCharacter(w http.ResponseWriter, r *http.Request) (int32, bool) {
+	charID, err := getPathCharID(r)
+	if err != nil {
+		write400(w, r, "invalid character ID")
+		return 0, false
+	}
+
+	if sessionCharacter(r) != charID {
+		write403(w, r)
+		return 0, false
+	}
+	return charID, true
+}
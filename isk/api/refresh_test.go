@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestRefreshValidation(t *testing.T) {
+	ctx := testAuthContext()
+	mux := http.NewServeMux()
+	mux.Handle("/api/char/{id}/refresh", Refresh(ctx))
+	mux.Handle("/api/char/{id}/refresh/{job}", RefreshStatus(ctx))
+
+	cases := []struct {
+		method, target string
+		session        int32
+		expected       int
+	}{
+		{http.MethodGet, "/api/char/1/refresh", 1, 405},
+		{http.MethodPost, "/api/char/1/refresh/2", 1, 405},
+		{http.MethodPost, "/api/char/x/refresh", 1, 400},
+		{http.MethodPost, "/api/char/1/refresh", 0, 403},
+		{http.MethodPost, "/api/char/1/refresh", 2, 403},
+		{http.MethodGet, "/api/char/1/refresh/2", 2, 403},
+		{http.MethodGet, "/api/char/1/refresh/x", 1, 400},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.target, nil)
+		if c.session != 0 {
+			r = r.WithContext(context.WithValue(r.Context(), cx.Character, c.session))
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != c.expected {
+			t.Errorf(
+				"%s %s as %d: expected %d, received %d",
+				c.method,
+				c.target,
+				c.session,
+				c.expected,
+				w.Code,
+			)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// requestIDHeader carries the request ID in both directions
+const requestIDHeader = "X-Request-ID"
+
+// reRequestID matches the incoming request IDs we accept
+var reRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// newRequestID returns a random request ID
+func newRequestID() string {
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		log.Printf("failed to generate request ID: %+v", err)
+	}
+	return hex.EncodeToString(raw)
+}
+
+// RequestIDs assigns each request an ID (or accepts a valid X-Request-ID),
+// storing it in the request context and echoing it in the response
+func RequestIDs(ctx context.Context) func(
+	http.ResponseWriter,
+	*http.Request,
+	http.HandlerFunc,
+) {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		id := r.Header.Get(requestIDHeader)
+		if !reRequestID.MatchString(id) {
+			id = newRequestID()
+			// so the request logger sees it too
+			r.Header.Set(requestIDHeader, id)
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next(w, r.WithContext(context.WithValue(r.Context(), cx.RequestID, id)))
+	}
+}
+
+// requestContext returns the request's context, with ctx's values available
+func requestContext(ctx context.Context, r *http.Request) context.Context {
+	return cx.WithValues(r.Context(), ctx)
+}
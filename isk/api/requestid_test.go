@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestRequestIDs(t *testing.T) {
+	handler := RequestIDs(context.Background())
+
+	var seen string
+	next := func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value(cx.RequestID).(string)
+	}
+
+	for incoming, accepted := range map[string]bool{
+		"":                         false,
+		"abc-123.DEF_4":            true,
+		"has spaces":               false,
+		"<script>":                 false,
+		string(make([]byte, 65)):   false,
+		"0123456789abcdef01234567": true,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/top", nil)
+		if incoming != "" {
+			r.Header.Set(requestIDHeader, incoming)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r, next)
+
+		echoed := w.Header().Get(requestIDHeader)
+		if echoed == "" || echoed != seen {
+			t.Errorf("%q: response ID %q does not match context ID %q", incoming, echoed, seen)
+		}
+		if accepted && seen != incoming {
+			t.Errorf("%q: valid incoming ID was replaced with %q", incoming, seen)
+		} else if !accepted && (seen == incoming || len(seen) != 16) {
+			t.Errorf("%q: invalid incoming ID was not replaced, have %q", incoming, seen)
+		}
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	opts := &cx.Options{}
+	ctx := context.WithValue(context.Background(), cx.Opts, opts)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/top", nil)
+	r = r.WithContext(context.WithValue(r.Context(), cx.RequestID, "abc"))
+
+	combined := requestContext(ctx, r)
+	if combined.Value(cx.RequestID) != "abc" {
+		t.Errorf("request value missing from combined context")
+	}
+	if combined.Value(cx.Opts) != opts {
+		t.Errorf("global value missing from combined context")
+	}
+}
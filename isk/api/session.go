@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+const (
+	// sessionCookie carries the signed session of a logged in character
+	sessionCookie = "esi-isk"
+
+	// displayCookie tells the frontend who is logged in, for display only
+	displayCookie = "charID"
+)
+
+// session is the signed content of the session cookie
+type session struct {
+	CharacterID int32 `json:"c"`
+	Expires     int64 `json:"e"`
+}
+
+// Sessions validates the session cookie and adds the logged in character ID
+// to the request context
+func Sessions(ctx context.Context) func(
+	http.ResponseWriter,
+	*http.Request,
+	http.HandlerFunc,
+) {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if charID := readSession(opts, r, time.Now()); charID > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), cx.Character, charID))
+		}
+		next(w, r)
+	}
+}
+
+// readSession returns the character ID of a valid session cookie, or 0
+func readSession(opts *cx.Options, r *http.Request, now time.Time) int32 {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return 0
+	}
+
+	s := &session{}
+	if err := readSigned(opts.AppSecret, cookie.Value, s); err != nil {
+		return 0
+	}
+
+	if now.Unix() > s.Expires || s.CharacterID < 1 {
+		return 0
+	}
+
+	return s.CharacterID
+}
+
+// sessionCharacter returns the logged in character ID, or 0
+func sessionCharacter(r *http.Request) int32 {
+	charID, ok := r.Context().Value(cx.Character).(int32)
+	if !ok || charID < 1 {
+		return 0
+	}
+	return charID
+}
+
+// setSession logs the character in for the session lifetime
+func setSession(w http.ResponseWriter, opts *cx.Options, charID int32) error {
+	lifetime := time.Duration(opts.SessionLifetime) * time.Second
+
+	value, err := signValue(opts.AppSecret, &session{
+		CharacterID: charID,
+		Expires:     time.Now().Add(lifetime).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   opts.SessionLifetime,
+		HttpOnly: true,
+		Secure:   opts.HTTPS,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     displayCookie,
+		Value:    strconv.Itoa(int(charID)),
+		Path:     "/",
+		MaxAge:   opts.SessionLifetime,
+		Secure:   opts.HTTPS,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// clearSession logs the client out
+func clearSession(w http.ResponseWriter, opts *cx.Options) {
+	for _, name := range []string{sessionCookie, displayCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: name == sessionCookie,
+			Secure:   opts.HTTPS,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// Logout clears the session cookie
+func Logout(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		clearSession(w, opts)
+		http.Redirect(w, r, "/#logout", 302)
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func testSessionOptions() *cx.Options {
+	return &cx.Options{AppSecret: "test-secret", SessionLifetime: 3600}
+}
+
+// sessionCookies logs the character in and returns the resulting cookies
+func sessionCookies(t *testing.T, opts *cx.Options, charID int32) []*http.Cookie {
+	w := httptest.NewRecorder()
+	if err := setSession(w, opts, charID); err != nil {
+		t.Fatalf("failed to set session: %+v", err)
+	}
+	return w.Result().Cookies()
+}
+
+func TestSessionCookie(t *testing.T) {
+	opts := testSessionOptions()
+	opts.HTTPS = true
+
+	cookies := sessionCookies(t, opts, 42)
+	if len(cookies) != 2 {
+		t.Fatalf("expected session and display cookies, received %d", len(cookies))
+	}
+
+	for _, cookie := range cookies {
+		if !cookie.Secure {
+			t.Errorf("%s cookie is not secure with https enabled", cookie.Name)
+		}
+		if cookie.Name == sessionCookie && !cookie.HttpOnly {
+			t.Error("session cookie is not HttpOnly")
+		}
+		if cookie.MaxAge != opts.SessionLifetime {
+			t.Errorf("%s cookie max age %d", cookie.Name, cookie.MaxAge)
+		}
+	}
+}
+
+func TestSessionMiddleware(t *testing.T) {
+	opts := testSessionOptions()
+	ctx := context.WithValue(context.Background(), cx.Opts, opts)
+	middleware := Sessions(ctx)
+
+	check := func(name string, cookies []*http.Cookie, expected int32) {
+		r := httptest.NewRequest(http.MethodGet, "/api/prefs", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+
+		var received int32 = -1
+		middleware(httptest.NewRecorder(), r, func(w http.ResponseWriter, r *http.Request) {
+			received = sessionCharacter(r)
+		})
+
+		if received != expected {
+			t.Errorf("%s: received character %d, expected %d", name, received, expected)
+		}
+	}
+
+	check("no cookie", nil, 0)
+	check("valid", sessionCookies(t, opts, 42), 42)
+
+	forged := sessionCookies(t, &cx.Options{AppSecret: "other", SessionLifetime: 3600}, 42)
+	check("foreign secret", forged, 0)
+
+	expired := sessionCookies(t, &cx.Options{AppSecret: "test-secret", SessionLifetime: -1}, 42)
+	check("expired", expired, 0)
+
+	// the display cookie alone does not log anyone in
+	display := []*http.Cookie{{Name: displayCookie, Value: "42"}}
+	check("display only", display, 0)
+}
+
+func TestReadSessionExpiry(t *testing.T) {
+	opts := testSessionOptions()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range sessionCookies(t, opts, 7) {
+		r.AddCookie(cookie)
+	}
+
+	if charID := readSession(opts, r, time.Now()); charID != 7 {
+		t.Errorf("valid session read as %d", charID)
+	}
+	if charID := readSession(opts, r, time.Now().Add(2*time.Hour)); charID != 0 {
+		t.Errorf("expired session read as %d", charID)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	opts := testSessionOptions()
+	ctx := context.WithValue(context.Background(), cx.Opts, opts)
+
+	w := httptest.NewRecorder()
+	Logout(ctx)(w, httptest.NewRequest(http.MethodGet, "/logout", nil))
+
+	if w.Code != 302 {
+		t.Errorf("expected logout redirect, received %d", w.Code)
+	}
+
+	cleared := map[string]bool{}
+	for _, cookie := range w.Result().Cookies() {
+		cleared[cookie.Name] = cookie.MaxAge < 0
+	}
+	if !cleared[sessionCookie] || !cleared[displayCookie] {
+		t.Errorf("logout did not clear cookies: %+v", cleared)
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// errBadSignature is returned for any value which fails signature checks
+var errBadSignature = errors.New("invalid signed value")
+
+// signValue returns the JSON of v with an HMAC-SHA256 signature appended
+func signValue(secret string, v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + signature(secret, encoded), nil
+}
+
+// readSigned verifies the signed value and decodes it into v
+func readSigned(secret, signed string, v interface{}) error {
+	parts := strings.Split(signed, ".")
+	if len(parts) != 2 {
+		return errBadSignature
+	}
+
+	expected := signature(secret, parts[0])
+	if !hmac.Equal([]byte(parts[1]), []byte(expected)) {
+		return errBadSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return errBadSignature
+	}
+
+	return json.Unmarshal(payload, v)
+}
+
+func signature(secret, encoded string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(encoded)) // never returns an error
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
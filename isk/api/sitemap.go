@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// sitemapNS is the namespace of sitemaps and sitemap indexes
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// sitemapURL is a URL in a sitemap, or a sitemap in a sitemap index
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// urlSet is a sitemap
+type urlSet struct {
+	XMLName xml.Name      `xml:"urlset"`
+	NS      string        `xml:"xmlns,attr"`
+	URLs    []*sitemapURL `xml:"url"`
+}
+
+// sitemapIndex lists the sitemaps of a site too large for one
+type sitemapIndex struct {
+	XMLName  xml.Name      `xml:"sitemapindex"`
+	NS       string        `xml:"xmlns,attr"`
+	Sitemaps []*sitemapURL `xml:"sitemap"`
+}
+
+// Sitemap lists the pages of every visible character. Sites with more than
+// db.SitemapSize characters get a sitemap index of the pages of the sitemap,
+// given by the "page" query arg from 1
+func Sitemap(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		count, err := db.CountSitemap(ctx)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+		pages := sitemapPages(count)
+
+		raw := r.URL.Query().Get("page")
+		if raw == "" && pages > 1 {
+			cache.Tag(w, cache.SitemapTag)
+			writeXML(w, newSitemapIndex(siteURL(opts), pages), opts.SitemapCacheTime)
+			return
+		}
+
+		page := 1
+		if raw != "" {
+			page, err = strconv.Atoi(raw)
+			if err != nil {
+				write400(w, r, "invalid page")
+				return
+			}
+			if page < 1 || page > pages {
+				write404(w, r, "sitemap page not found")
+				return
+			}
+		}
+
+		entries, err := db.GetSitemap(ctx, page-1)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		cache.Tag(w, cache.SitemapTag)
+		writeXML(w, newURLSet(siteURL(opts), entries), opts.SitemapCacheTime)
+	}
+}
+
+// sitemapPages is the number of sitemap pages listing count characters, an
+// empty site still has an empty sitemap
+func sitemapPages(count int) int {
+	if count == 0 {
+		return 1
+	}
+	return (count + db.SitemapSize - 1) / db.SitemapSize
+}
+
+// siteURL is the public URL of the site, without a trailing slash
+func siteURL(opts *cx.Options) string {
+	proto := "http"
+	if opts.HTTPS {
+		proto = "https"
+	}
+	return fmt.Sprintf("%s://%s", proto, opts.Hostname)
+}
+
+// characterPath is the path of the character's page, by current slug if it
+// has one
+func characterPath(e *db.SitemapEntry) string {
+	if e.Slug.Valid {
+		return "/c/" + e.Slug.String
+	}
+	return fmt.Sprintf("/char/%d", e.CharacterID)
+}
+
+func newURLSet(base string, entries []*db.SitemapEntry) *urlSet {
+	set := &urlSet{NS: sitemapNS, URLs: []*sitemapURL{}}
+	for _, e := range entries {
+		u := &sitemapURL{Loc: base + characterPath(e)}
+		if e.LastModified.Valid {
+			u.LastMod = e.LastModified.Time.UTC().Format(time.RFC3339)
+		}
+		set.URLs = append(set.URLs, u)
+	}
+	return set
+}
+
+func newSitemapIndex(base string, pages int) *sitemapIndex {
+	index := &sitemapIndex{NS: sitemapNS, Sitemaps: []*sitemapURL{}}
+	for page := 1; page <= pages; page++ {
+		index.Sitemaps = append(index.Sitemaps, &sitemapURL{
+			Loc: fmt.Sprintf("%s/sitemap.xml?page=%d", base, page),
+		})
+	}
+	return index
+}
+
+// writeXML writes res as an XML document, which clients may cache for seconds
+func writeXML(w http.ResponseWriter, res interface{}, seconds int) {
+	asXML, err := xml.Marshal(res)
+	if err != nil {
+		// this should never happen
+		log.Printf("failed to marshal response: %+v", err)
+		write(w, 500, []byte("internal error"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	writeCacheHeadersFor(w, seconds)
+	write(w, 200, append([]byte(xml.Header), asXML...))
+}
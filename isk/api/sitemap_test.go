@@ -0,0 +1,76 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+func TestSitemapPages(t *testing.T) {
+	for count, expected := range map[int]int{
+		0:                    1,
+		1:                    1,
+		db.SitemapSize:       1,
+		db.SitemapSize + 1:   2,
+		db.SitemapSize*3 - 1: 3,
+	} {
+		if pages := sitemapPages(count); pages != expected {
+			t.Errorf(
+				"%d characters: received %d pages, expected %d",
+				count,
+				pages,
+				expected,
+			)
+		}
+	}
+}
+
+func TestURLSet(t *testing.T) {
+	at := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	set := newURLSet("https://isk.example", []*db.SitemapEntry{
+		{CharacterID: 1},
+		{
+			CharacterID:  2,
+			Slug:         sql.NullString{String: "second", Valid: true},
+			LastModified: pq.NullTime{Time: at, Valid: true},
+		},
+	})
+
+	raw, err := xml.Marshal(set)
+	if err != nil {
+		t.Fatalf("failed to marshal: %+v", err)
+	}
+
+	expected := `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` +
+		`<url><loc>https://isk.example/char/1</loc></url>` +
+		`<url><loc>https://isk.example/c/second</loc>` +
+		`<lastmod>2019-01-02T03:04:05Z</lastmod></url></urlset>`
+	if string(raw) != expected {
+		t.Errorf("received %s, expected %s", raw, expected)
+	}
+}
+
+func TestSitemapIndex(t *testing.T) {
+	raw, err := xml.Marshal(newSitemapIndex("http://localhost", 2))
+	if err != nil {
+		t.Fatalf("failed to marshal: %+v", err)
+	}
+
+	for _, loc := range []string{
+		"<loc>http://localhost/sitemap.xml?page=1</loc>",
+		"<loc>http://localhost/sitemap.xml?page=2</loc>",
+	} {
+		if !strings.Contains(string(raw), loc) {
+			t.Errorf("%s is missing %s", raw, loc)
+		}
+	}
+	if !strings.HasPrefix(string(raw), "<sitemapindex") {
+		t.Errorf("not a sitemap index: %s", raw)
+	}
+}
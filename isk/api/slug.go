@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// slugRequest is the body of a slug claim
+type slugRequest struct {
+	Slug string `json:"slug"`
+}
+
+// SlugPage redirects /c/{slug} to the character page, or to the current slug
+// of the character if the slug has been replaced
+func SlugPage(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		slug := r.PathValue("slug")
+		charID, current, err := resolveSlug(ctx, w, r, slug)
+		if err != nil {
+			// resolveSlug writes any errors
+			return
+		}
+
+		if current != "" && current != slug {
+			http.Redirect(w, r, "/c/"+current, http.StatusMovedPermanently)
+		} else {
+			http.Redirect(w, r, fmt.Sprintf("/#c=%d", charID), http.StatusFound)
+		}
+	}
+}
+
+// CharacterPage redirects /char/{id} to the character page, by the current
+// slug of the character if it has one
+func CharacterPage(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		charID, err := getPathCharID(r)
+		if err != nil {
+			write400(w, r, "invalid character ID")
+			return
+		}
+
+		slug, err := db.GetSlug(ctx, charID)
+		if err == nil {
+			http.Redirect(w, r, "/c/"+slug.Slug, http.StatusMovedPermanently)
+		} else if err == db.ErrSlugNotFound {
+			http.Redirect(w, r, fmt.Sprintf("/#c=%d", charID), http.StatusFound)
+		} else {
+			write500(w, r, err)
+		}
+	}
+}
+
+// Slugs redirects requests naming the character by slug, in the "id" path
+// value or "c" query arg, to the same request by character ID
+func Slugs(ctx context.Context, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if raw := r.PathValue("id"); isSlugArg(raw) {
+			charID, _, err := resolveSlug(ctx, w, r, raw)
+			if err == nil {
+				u := *r.URL
+				u.Path = replaceSegment(u.Path, raw, strconv.Itoa(int(charID)))
+				http.Redirect(w, r, u.String(), http.StatusFound)
+			}
+			return
+		}
+
+		query := r.URL.Query()
+		if raw := query.Get("c"); isSlugArg(raw) {
+			charID, _, err := resolveSlug(ctx, w, r, raw)
+			if err == nil {
+				u := *r.URL
+				query.Set("c", strconv.Itoa(int(charID)))
+				u.RawQuery = query.Encode()
+				http.Redirect(w, r, u.String(), http.StatusFound)
+			}
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// SlugPreference handles getting and claiming the character's slug
+func SlugPreference(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			write405(w, r)
+			return
+		}
+
+		charID := sessionCharacter(r)
+		if charID == 0 {
+			write403(w, r)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			slug, err := db.GetSlug(ctx, charID)
+			if err == db.ErrSlugNotFound {
+				write404(w, r, "no slug claimed")
+			} else if err != nil {
+				write500(w, r, err)
+			} else {
+				w.Header().Set("Cache-Control", "no-store")
+				writeJSON(ctx, w, slug)
+			}
+			return
+		}
+
+		req := &slugRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			write400(w, r, "invalid slug request")
+			return
+		}
+
+		if err := db.ClaimSlug(ctx, charID, req.Slug); err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(204)
+	}
+}
+
+// isSlugArg is true for character arguments given as a slug, not an ID
+func isSlugArg(raw string) bool {
+	if _, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return false
+	}
+	return db.IsSlug(raw)
+}
+
+// resolveSlug returns the character and current slug of the slug, or writes
+// an error
+func resolveSlug(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	slug string,
+) (int32, string, error) {
+	charID, current, err := db.ResolveSlug(ctx, slug)
+	if err == db.ErrSlugNotFound {
+		write404(w, r, "slug not found")
+	} else if err != nil {
+		write500(w, r, err)
+	}
+	return charID, current, err
+}
+
+// replaceSegment replaces the first path segment equal to old
+func replaceSegment(path, old, replacement string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == old {
+			segments[i] = replacement
+			break
+		}
+	}
+	return strings.Join(segments, "/")
+}
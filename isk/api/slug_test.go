@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestSlugsPassesIDs(t *testing.T) {
+	calls := 0
+	mux := http.NewServeMux()
+	handler := Slugs(testAuthContext(), func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	})
+	mux.Handle("/api/char", handler)
+	mux.Handle("/api/char/{id}/histogram", handler)
+
+	// IDs and arguments which can not be slugs never hit the db
+	for _, target := range []string{
+		"/api/char?c=90000001",
+		"/api/char?c=-1",
+		"/api/char?c=",
+		"/api/char?c=NOT_A_SLUG",
+		"/api/char/90000001/histogram",
+		"/api/char/x/histogram",
+	} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	if calls != 6 {
+		t.Errorf("expected 6 requests to pass through, had %d", calls)
+	}
+}
+
+func TestReplaceSegment(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/api/char/mynick/histogram": "/api/char/1/histogram",
+		"/widget/mynick":             "/widget/1",
+		"/api/char/other":            "/api/char/other",
+	} {
+		if received := replaceSegment(path, "mynick", "1"); received != expected {
+			t.Errorf("%s: received %q, expected %q", path, received, expected)
+		}
+	}
+}
+
+func TestSlugPreferenceRequiresSession(t *testing.T) {
+	check := func(charID int32, method string, expected int) {
+		r := httptest.NewRequest(method, "/api/prefs/slug", strings.NewReader("slug"))
+		if charID > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), cx.Character, charID))
+		}
+		w := httptest.NewRecorder()
+		SlugPreference(testAuthContext())(w, r)
+		if w.Code != expected {
+			t.Errorf("%s as %d: expected %d, received %d", method, charID, expected, w.Code)
+		}
+	}
+
+	check(0, http.MethodPost, 403)
+	check(1, http.MethodDelete, 405)
+	check(1, http.MethodPost, 400)
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// recorder is a database/sql driver which records the queries run, each
+// returning no rows
+type recorder struct {
+	lock    sync.Mutex
+	queries []string
+}
+
+var (
+	recorded     = &recorder{}
+	registerOnce sync.Once
+)
+
+func (r *recorder) Open(string) (driver.Conn, error) { return r, nil }
+
+func (r *recorder) Prepare(query string) (driver.Stmt, error) {
+	return &recordedStmt{r, query}, nil
+}
+
+func (r *recorder) Close() error { return nil }
+
+func (r *recorder) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not recorded")
+}
+
+// take returns and forgets the queries recorded
+func (r *recorder) take() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	queries := r.queries
+	r.queries = nil
+	return queries
+}
+
+type recordedStmt struct {
+	r     *recorder
+	query string
+}
+
+func (s *recordedStmt) Close() error  { return nil }
+func (s *recordedStmt) NumInput() int { return -1 }
+
+func (s *recordedStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("statements are not executed")
+}
+
+func (s *recordedStmt) Query([]driver.Value) (driver.Rows, error) {
+	s.r.lock.Lock()
+	defer s.r.lock.Unlock()
+	s.r.queries = append(s.r.queries, s.query)
+	return noRows{}, nil
+}
+
+type noRows struct{}
+
+func (noRows) Columns() []string         { return []string{} }
+func (noRows) Close() error              { return nil }
+func (noRows) Next([]driver.Value) error { return io.EOF }
+
+// recordedContext returns a context of the options with a store recording
+// the queries run
+func recordedContext(t *testing.T, opts *cx.Options) context.Context {
+	registerOnce.Do(func() { sql.Register("recorder", recorded) })
+	conn, err := sql.Open("recorder", "")
+	if err != nil {
+		t.Fatalf("failed to open the recorder: %+v", err)
+	}
+
+	ctx := context.WithValue(context.Background(), cx.Opts, opts)
+	store, err := db.NewStore(ctx, sqlx.NewDb(conn, "recorder"))
+	if err != nil {
+		t.Fatalf("failed to prepare statements: %+v", err)
+	}
+	recorded.take()
+	return store.Context(ctx)
+}
+
+func TestExcludeBadStanding(t *testing.T) {
+	excluded := "NOT IN (SELECT character_id FROM characters " +
+		"WHERE NOT good_standing)"
+	handlers := map[string]func(context.Context) http.HandlerFunc{
+		"/api/leaderboard/trending": Trending,
+		"/api/leaderboard/notable":  NotableContracts,
+	}
+
+	for target, handler := range handlers {
+		for _, exclude := range []bool{false, true} {
+			ctx := recordedContext(t, &cx.Options{ExcludeBadStanding: exclude})
+			w := httptest.NewRecorder()
+			handler(ctx)(w, httptest.NewRequest(http.MethodGet, target, nil))
+			if w.Code != 200 {
+				t.Fatalf("%s: expected 200, received %d", target, w.Code)
+			}
+
+			queries := recorded.take()
+			if len(queries) == 0 {
+				t.Fatalf("%s: no queries were run", target)
+			}
+			// the page is filtered in its query, so its limit is of those left
+			filtered := strings.Contains(queries[0], excluded)
+			if filtered != exclude {
+				t.Errorf(
+					"%s with exclude %t: filtered %t, query:\n%s",
+					target,
+					exclude,
+					filtered,
+					queries[0],
+				)
+			}
+		}
+	}
+}
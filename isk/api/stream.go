@@ -0,0 +1,33 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// Streaming extends the write deadline of requests to the given (streaming)
+// paths from the server's WriteTimeout to the StreamTimeout. The paths are
+// ServeMux patterns. It must wrap the whole middleware stack, as our response
+// writers can't set deadlines
+func Streaming(ctx context.Context, next http.Handler, paths ...string) http.Handler {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	timeout := time.Duration(opts.StreamTimeout) * time.Second
+
+	streaming := http.NewServeMux()
+	for _, path := range paths {
+		streaming.Handle(path, next)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := streaming.Handler(r); pattern != "" {
+			rc := http.NewResponseController(w)
+			if err := rc.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+				cx.Logf(ctx, "failed to extend write deadline of %s: %+v", r.URL.Path, err)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
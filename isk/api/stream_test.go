@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestStreaming(t *testing.T) {
+	ctx := context.WithValue(context.Background(), cx.Opts, &cx.Options{
+		StreamTimeout: 10,
+	})
+
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("first "))
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("second"))
+	})
+
+	server := httptest.NewUnstartedServer(
+		Streaming(ctx, slow, "/stream", "/poll/{id}"),
+	)
+	server.Config.WriteTimeout = 50 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	get := func(path string) (string, error) {
+		res, err := server.Client().Get(server.URL + path)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = res.Body.Close() }()
+		body, err := ioutil.ReadAll(res.Body)
+		return string(body), err
+	}
+
+	if body, err := get("/stream"); err != nil || body != "first second" {
+		t.Errorf("streaming route was cut off: %q, %+v", body, err)
+	}
+
+	if body, err := get("/poll/1"); err != nil || body != "first second" {
+		t.Errorf("streaming pattern was cut off: %q, %+v", body, err)
+	}
+
+	if body, err := get("/other"); err == nil && body == "first second" {
+		t.Error("other routes are not held to the write timeout")
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// supporters is a page of a character's supporters
+type supporters struct {
+	*page
+	Supporters []*db.Supporter `json:"supporters"`
+}
+
+// Supporters returns the character's donators ranked by ISK given
+func Supporters(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		charID, err := getPathCharID(r)
+		if err != nil {
+			write400(w, r, "invalid character ID")
+			return
+		}
+
+		p, err := getPage(r)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		if !checkCharacterAccess(ctx, w, r, charID) {
+			return
+		}
+
+		res, err := db.GetSupporters(ctx, charID, p.Limit, p.Offset)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		if sessionCharacter(r) != charID {
+			if err := db.MaskAnonymousSupporters(ctx, res); err != nil {
+				write500(w, r, err)
+				return
+			}
+		}
+
+		cache.Tag(w, cache.CharacterTag(charID))
+		writeJSON(ctx, w, &supporters{page: p, Supporters: res})
+	}
+}
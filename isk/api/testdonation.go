@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+const (
+	// testDonationsPerMinute is how many test donations each character may
+	// send a minute, after a burst of as many
+	testDonationsPerMinute = 3
+
+	// maxTestNote is the longest note of a test donation
+	maxTestNote = 200
+
+	// testNote is the note of test donations sent without one
+	testNote = "Test donation"
+
+	// testDonationID is the ID of every test donation. No stored donation
+	// has it, and it is never after a client's since_id
+	testDonationID = 0
+)
+
+// testAmount is the ISK of test donations sent without an amount
+var testAmount = db.NewISK(1000000)
+
+// testDonationRequest is the optional body of a test donation
+type testDonationRequest struct {
+	Amount db.ISK `json:"amount"`
+	Note   string `json:"note"`
+}
+
+// testDonation is a donation which was never stored or counted, sent to
+// check overlays show donations
+type testDonation struct {
+	*db.Donation
+	Test bool `json:"test"`
+}
+
+// TestDonation sends a test donation from the logged in owner's character to
+// itself, to the long polls waiting on it. Nothing is stored
+func TestDonation(ctx context.Context) http.HandlerFunc {
+	limits := newLimiter()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodPost {
+			write405(w, r)
+			return
+		}
+
+		charID, ok := ownedCharacter(w, r)
+		if !ok {
+			return
+		}
+
+		allowed, wait := limits.allow(
+			fmt.Sprintf("char:%d", charID),
+			testDonationsPerMinute,
+			testDonationsPerMinute,
+			time.Now(),
+		)
+		if !allowed {
+			writeRetryAfter(w, r, wait)
+			return
+		}
+
+		req := testDonationRequest{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil && err != io.EOF {
+			write400(w, r, "invalid request body")
+			return
+		}
+		if req.Amount < 0 {
+			write400(w, r, "amount must be positive")
+			return
+		}
+		if len(req.Note) > maxTestNote {
+			write400(w, r, fmt.Sprintf(
+				"note must be at most %d bytes",
+				maxTestNote,
+			))
+			return
+		}
+		if req.Amount == 0 {
+			req.Amount = testAmount
+		}
+		if req.Note == "" {
+			req.Note = testNote
+		}
+
+		donation := &db.Donation{
+			Donator:   charID,
+			Recipient: charID,
+			Timestamp: time.Now().UTC(),
+			Note:      req.Note,
+			Amount:    req.Amount,
+		}
+		if err := db.NotifyTestDonation(ctx, donation); err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSONStatus(w, http.StatusAccepted, &testDonation{
+			Donation: donation,
+			Test:     true,
+		}, 0)
+	}
+}
+
+// writeTestDonation writes the test donation as the only new donation of a
+// long poll, with the testDonationID
+func writeTestDonation(w http.ResponseWriter, donation *db.Donation) {
+	copied := *donation
+	copied.ID = testDonationID
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSONFor(w, []*testDonation{{Donation: &copied, Test: true}}, 0)
+}
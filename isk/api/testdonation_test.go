@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+func TestTestDonationValidation(t *testing.T) {
+	ctx := testAuthContext()
+	mux := http.NewServeMux()
+	mux.Handle("/api/char/{id}/test-donation", TestDonation(ctx))
+
+	cases := []struct {
+		method, target, body string
+		session              int32
+		expected             int
+	}{
+		{http.MethodGet, "/api/char/1/test-donation", "", 1, 405},
+		{http.MethodPost, "/api/char/x/test-donation", "", 1, 400},
+		{http.MethodPost, "/api/char/1/test-donation", "", 0, 403},
+		{http.MethodPost, "/api/char/1/test-donation", "", 2, 403},
+		{http.MethodPost, "/api/char/1/test-donation", "{", 1, 400},
+		{http.MethodPost, "/api/char/1/test-donation", `{"amount":-1}`, 1, 400},
+		{
+			http.MethodPost,
+			"/api/char/1/test-donation",
+			`{"note":"` + strings.Repeat("x", maxTestNote+1) + `"}`,
+			1,
+			400,
+		},
+		{http.MethodPost, "/api/char/1/test-donation", "", 1, 429},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		if c.session != 0 {
+			r = r.WithContext(context.WithValue(r.Context(), cx.Character, c.session))
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != c.expected {
+			t.Errorf(
+				"%s %s %q as %d: expected %d, received %d",
+				c.method,
+				c.target,
+				c.body,
+				c.session,
+				c.expected,
+				w.Code,
+			)
+		}
+	}
+}
+
+func TestWriteTestDonation(t *testing.T) {
+	donation := &db.Donation{ID: 42, Donator: 1, Recipient: 1, Note: testNote}
+	w := httptest.NewRecorder()
+	writeTestDonation(w, donation)
+
+	if cache := w.Header().Get("Cache-Control"); cache != "no-store" {
+		t.Errorf("expected Cache-Control no-store, received %q", cache)
+	}
+
+	res := []map[string]interface{}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to decode %s: %+v", w.Body.String(), err)
+	}
+	if len(res) != 1 {
+		t.Fatalf("expected one donation, received %d", len(res))
+	}
+	if res[0]["test"] != true {
+		t.Errorf("expected the donation to be a test, received %v", res[0])
+	}
+	if res[0]["id"] != float64(testDonationID) {
+		t.Errorf("expected the test donation ID, received %v", res[0]["id"])
+	}
+	if donation.ID != 42 {
+		t.Errorf("expected the broadcast donation to be left alone")
+	}
+}
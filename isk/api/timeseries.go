@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// reWindow matches time series windows, like 90d, 12w or 6m
+var reWindow = regexp.MustCompile(`^([1-9][0-9]{0,2})([dwm])$`)
+
+var errBadWindow = errors.New("window must be up to one year, like 90d, 12w or 6m")
+
+// TimeSeries returns the ISK received by the character per day, week or month
+func TimeSeries(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		charID, err := getPathCharID(r)
+		if err != nil {
+			write400(w, r, "invalid character ID")
+			return
+		}
+
+		now := time.Now().UTC()
+		query := r.URL.Query()
+
+		since, err := windowStart(now, query.Get("window"))
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		bucket := query.Get("bucket")
+		switch bucket {
+		case "":
+			bucket = db.BucketDay
+		case db.BucketDay, db.BucketWeek, db.BucketMonth:
+		default:
+			write400(w, r, "bucket must be one of day, week or month")
+			return
+		}
+
+		if !checkCharacterAccess(ctx, w, r, charID) {
+			return
+		}
+
+		points, err := db.ReceivedSeries(ctx, charID, since, bucket)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		cache.Tag(w, cache.CharacterTag(charID))
+		writeJSON(ctx, w, points)
+	}
+}
+
+// windowStart returns the start of the window ending now, defaulting to 90d
+func windowStart(now time.Time, window string) (time.Time, error) {
+	if window == "" {
+		window = "90d"
+	}
+
+	match := reWindow.FindStringSubmatch(window)
+	if match == nil {
+		return time.Time{}, errBadWindow
+	}
+
+	n, _ := strconv.Atoi(match[1]) // the pattern only matches digits
+	var since time.Time
+	switch match[2] {
+	case "d":
+		since = now.AddDate(0, 0, -n)
+	case "w":
+		since = now.AddDate(0, 0, -7*n)
+	default:
+		since = now.AddDate(0, -n, 0)
+	}
+
+	if since.Before(now.AddDate(-1, 0, 0)) {
+		return time.Time{}, errBadWindow
+	}
+	return since, nil
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWindowStart(t *testing.T) {
+	now := time.Date(2018, 11, 22, 12, 0, 0, 0, time.UTC)
+
+	for window, expected := range map[string]string{
+		"":     "2018-08-24",
+		"90d":  "2018-08-24",
+		"1d":   "2018-11-21",
+		"12w":  "2018-08-30",
+		"6m":   "2018-05-22",
+		"12m":  "2017-11-22",
+		"365d": "2017-11-22",
+	} {
+		since, err := windowStart(now, window)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %+v", window, err)
+		} else if since.Format("2006-01-02") != expected {
+			t.Errorf("%q: received %s, expected %s", window, since, expected)
+		}
+	}
+
+	for _, window := range []string{"366d", "13m", "53w", "0d", "1y", "d", "-1d", "90"} {
+		if _, err := windowStart(now, window); err == nil {
+			t.Errorf("%q: expected an error", window)
+		}
+	}
+}
+
+func TestTimeSeriesValidation(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/api/char/{id}/timeseries", TimeSeries(testAuthContext()))
+
+	for target, status := range map[string]int{
+		"/api/char/abc/timeseries":              400,
+		"/api/char/0/timeseries":                400,
+		"/api/char/1/timeseries?window=2y":      400,
+		"/api/char/1/timeseries?bucket=quarter": 400,
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != status {
+			t.Errorf("%s: expected %d, received %d", target, status, w.Code)
+		}
+	}
+}
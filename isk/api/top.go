@@ -2,31 +2,71 @@ package api
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strings"
 
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/cx"
 	"github.com/a-tal/esi-isk/isk/db"
 )
 
+// topCharacters are the current leaderboards
+type topCharacters struct {
+	Recipients []*db.Character `json:"recipients"`
+	Donators   []*db.Character `json:"donators"`
+}
+
 // TopRecipients returns JSON describing the current top donation recipients
 func TopRecipients(ctx context.Context) http.HandlerFunc {
-	return func(w http.ResponseWriter, _req *http.Request) {
-		recipients, err := db.GetTopRecipients(ctx)
+	return topRecipients(ctx, topV1)
+}
+
+// getWindow reads the window query arg, 30 days by default
+func getWindow(r *http.Request) (db.Window, error) {
+	raw := r.URL.Query().Get("window")
+	if raw == "" {
+		return db.WindowMonth, nil
+	}
+	window, err := db.ParseWindow(raw)
+	if err != nil {
+		return 0, errors.New("window must be one of " +
+			strings.Join(db.WindowNames(), ", "))
+	}
+	return window, nil
+}
+
+// topRecipients writes the leaderboards of the window query arg, 30 days by
+// default, in the shape of adapt
+func topRecipients(
+	ctx context.Context,
+	adapt func(*topCharacters) interface{},
+) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		window, err := getWindow(r)
 		if err != nil {
-			write500(w)
+			write400(w, r, err.Error())
 			return
 		}
 
-		donators, err := db.GetTopDonators(ctx)
+		recipients, err := db.GetTopRecipients(ctx, window)
 		if err != nil {
-			write500(w)
+			write500(w, r, err)
 			return
 		}
 
-		res := map[string][]*db.Character{
-			"recipients": recipients,
-			"donators":   donators,
+		donators, err := db.GetTopDonators(ctx, window)
+		if err != nil {
+			write500(w, r, err)
+			return
 		}
 
-		writeJSON(ctx, w, res)
+		res := &topCharacters{Recipients: recipients, Donators: donators}
+
+		cache.Tag(w, cache.TopTag)
+		writeJSONFor(w, adapt(res), opts.TopCacheTime)
 	}
 }
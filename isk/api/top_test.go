@@ -0,0 +1,18 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTopRecipientsWindow(t *testing.T) {
+	for _, window := range []string{"30", "14d", "month", "ALL"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v2/top?window="+window, nil)
+		TopRecipientsV2(testAuthContext())(w, r)
+		if w.Code != 400 {
+			t.Errorf("%s: expected 400, received %d", window, w.Code)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// transfers is a page of the history between two characters
+type transfers struct {
+	*page
+	Transfers []*db.Transfer `json:"transfers"`
+}
+
+// TransfersBetween returns the donations and contracts between the character
+// and another, in both directions
+func TransfersBetween(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		charID, err := getPathCharID(r)
+		if err != nil {
+			write400(w, r, "invalid character ID")
+			return
+		}
+
+		otherID, err := strconv.ParseInt(r.PathValue("donorID"), 10, 32)
+		if err != nil || otherID < 1 || int32(otherID) == charID {
+			write400(w, r, "invalid donor ID")
+			return
+		}
+
+		p, err := getPage(r)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		if !checkCharacterAccess(ctx, w, r, charID) {
+			return
+		}
+
+		hidden, err := hidesTransfers(ctx, r, charID, int32(otherID))
+		if err != nil {
+			write500(w, r, err)
+			return
+		} else if hidden {
+			write403(w, r)
+			return
+		}
+
+		res, err := db.GetTransfersBetween(ctx, charID, int32(otherID), p.Limit, p.Offset)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		// either character saving new totals may change this page
+		cache.Tag(w, cache.CharacterTag(charID), cache.CharacterTag(int32(otherID)))
+		writeJSON(ctx, w, &transfers{page: p, Transfers: res})
+	}
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransfersBetweenValidation(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/api/char/{id}/from/{donorID}", TransfersBetween(testAuthContext()))
+
+	for _, target := range []string{
+		"/api/char/abc/from/1",
+		"/api/char/1/from/abc",
+		"/api/char/1/from/0",
+		"/api/char/1/from/1",
+		"/api/char/1/from/2?limit=0",
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != 400 {
+			t.Errorf("%s: expected 400, received %d", target, w.Code)
+		}
+	}
+}
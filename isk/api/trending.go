@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// trendingHours are the hours of page views trending ranks by, the current
+// one included
+const trendingHours = 24
+
+// trending is a page of the characters with the most page views
+type trending struct {
+	*page
+	Characters []*db.TrendingCharacter `json:"characters"`
+}
+
+// Trending returns the characters with the most page views in the last day,
+// counted once per IP an hour
+func Trending(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodGet {
+			write405(w, r)
+			return
+		}
+
+		p, err := getPage(r)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		since := time.Now().UTC().Truncate(time.Hour).Add(
+			-(trendingHours - 1) * time.Hour,
+		)
+		res, err := db.GetTrending(ctx, since, p.Limit, p.Offset)
+		if err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		cache.Tag(w, cache.TopTag)
+		writeJSONFor(w, &trending{page: p, Characters: res}, opts.TopCacheTime)
+	}
+}
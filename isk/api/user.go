@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+	"github.com/a-tal/esi-isk/isk/tokens"
+)
+
+// RevokeURL is the EVE SSO token revocation endpoint
+const RevokeURL = "https://login.eveonline.com/v2/oauth/revoke"
+
+// User handles removing the logged in user
+func User(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		if r.Method != http.MethodDelete {
+			write405(w, r)
+			return
+		}
+
+		charID := sessionCharacter(r)
+		if charID == 0 {
+			write403(w, r)
+			return
+		}
+
+		purge := r.URL.Query().Get("purge") == "true"
+		if err := RemoveCharacter(
+			ctx,
+			db.CharacterTarget(charID),
+			charID,
+			purge,
+		); err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		for _, t := range []string{"d", "c", "a"} {
+			dropCache(ctx, fmt.Sprintf("/api/custom?c=%d&t=%s", charID, t))
+		}
+		dropCache(ctx, fmt.Sprintf("/api/char?c=%d", charID))
+
+		clearSession(w, opts)
+		w.WriteHeader(204)
+	}
+}
+
+// RemoveCharacter revokes the character's refresh token, if one is stored,
+// then removes the character as db.PurgeCharacter does and audits it as done
+// by the actor
+func RemoveCharacter(
+	ctx context.Context,
+	actor string,
+	charID int32,
+	purge bool,
+) error {
+	revoked := "no stored token"
+	if user, err := db.GetUser(ctx, charID); err == nil {
+		if err := revokeToken(ctx, user); err != nil {
+			cx.Logf(ctx, "failed to revoke token of %d: %+v", charID, err)
+			revoked = "token revocation failed"
+		} else {
+			revoked = "token revoked"
+		}
+	}
+
+	if err := db.PurgeCharacter(ctx, charID, !purge); err != nil {
+		return fmt.Errorf("failed to remove character %d: %+v", charID, err)
+	}
+
+	action := "delete"
+	if purge {
+		action = "purge"
+	}
+	if err := db.WriteAudit(
+		ctx,
+		actor,
+		action,
+		db.CharacterTarget(charID),
+		map[string]string{"token": revoked},
+	); err != nil {
+		cx.Logf(ctx, "failed to audit removal of %d: %+v", charID, err)
+	}
+	return nil
+}
+
+// revokeToken revokes the user's refresh token with EVE SSO
+func revokeToken(ctx context.Context, user *db.User) error {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	if opts.Auth == nil {
+		return errors.New("auth is not configured")
+	}
+
+	refreshToken, err := tokens.Decrypt(opts.TokenKey, user.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Set("token_type_hint", "refresh_token")
+	form.Set("token", refreshToken)
+
+	req, err := http.NewRequest(
+		http.MethodPost,
+		RevokeURL,
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(opts.Auth.ClientID, opts.Auth.ClientSecret)
+
+	client := ctx.Value(cx.SSOClient).(*http.Client)
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	if err := res.Body.Close(); err != nil {
+		cx.Logf(ctx, "failed to close revoke response body: %+v", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to revoke token: %s", res.Status)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"html/template"
 	"log"
 	"net/http"
 	"time"
@@ -20,40 +21,69 @@ func write(w http.ResponseWriter, status int, body []byte) {
 	}
 }
 
-func write500(w http.ResponseWriter) {
-	write(w, 500, []byte("internal error"))
-}
+var errorPage = template.Must(template.New("error").Parse(`<!doctype html>
+<html lang="en">
+ <head>
+  <meta charset="utf-8">
+  <title>ESI ISK - Error</title>
+ </head>
+ <body>
+  <h1>Something went wrong</h1>
+  <p>{{.}}</p>
+  <p><a href="/">Return to ESI ISK</a></p>
+ </body>
+</html>`))
 
-func write400(w http.ResponseWriter) {
-	write(w, 400, []byte("request error"))
+// writeErrorPage writes a human readable HTML error page
+func writeErrorPage(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := errorPage.Execute(w, message); err != nil {
+		log.Printf("failed to write %d error page: %+v", status, err)
+	}
 }
 
-func write403(w http.ResponseWriter) {
-	write(w, 403, []byte("request denied"))
+func writeJSON(ctx context.Context, w http.ResponseWriter, res interface{}) {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	writeJSONFor(w, res, opts.CacheTime)
 }
 
-func write405(w http.ResponseWriter) {
-	write(w, 405, []byte("method not allowed"))
+// writeJSONFor writes res as JSON which clients may cache for seconds
+func writeJSONFor(w http.ResponseWriter, res interface{}, seconds int) {
+	writeJSONStatus(w, 200, res, seconds)
 }
 
-func writeJSON(ctx context.Context, w http.ResponseWriter, res interface{}) {
+// writeJSONStatus writes res as JSON with the status, which clients may cache
+// for seconds
+func writeJSONStatus(
+	w http.ResponseWriter,
+	status int,
+	res interface{},
+	seconds int,
+) {
 	asJSON, err := json.Marshal(res)
 	if err != nil {
-		write500(w)
+		// this should never happen
+		log.Printf("failed to marshal response: %+v", err)
+		write(w, 500, []byte(`{"error":{"code":"internal_error"}}`))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	writeCacheHeaders(ctx, w)
-	write(w, 200, asJSON)
+	writeCacheHeadersFor(w, seconds)
+	write(w, status, asJSON)
 }
 
 func writeCacheHeaders(ctx context.Context, w http.ResponseWriter) {
 	opts := ctx.Value(cx.Opts).(*cx.Options)
+	writeCacheHeadersFor(w, opts.CacheTime)
+}
+
+func writeCacheHeadersFor(w http.ResponseWriter, seconds int) {
 	now := time.Now().UTC()
 	w.Header().Set("Last-Modified", now.Format(RFC1123))
 	w.Header().Set(
 		"Expires",
-		now.Add(time.Duration(opts.CacheTime)*time.Second).Format(RFC1123),
+		now.Add(time.Duration(seconds)*time.Second).Format(RFC1123),
 	)
 }
@@ -0,0 +1,360 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// Routes of /api/v2/ read the same results as their v1 routes, adapting them
+// to the shapes of v2 before they're written. The adapters of v1 return the
+// results as they are
+
+// Activity types and directions of v2 character details
+const (
+	activityDonation = "donation"
+	activityContract = "contract"
+	activityReceived = "received"
+	activitySent     = "sent"
+)
+
+// characterV2 is a character with every field present, zero or not, and
+// null timestamps when they never happened
+type characterV2 struct {
+	ID              int32  `json:"id"`
+	Name            string `json:"name"`
+	CorporationID   int32  `json:"corporation"`
+	CorporationName string `json:"corporation_name"`
+	AllianceID      int32  `json:"alliance"`
+	AllianceName    string `json:"alliance_name"`
+	Portrait        string `json:"portrait"`
+	CorporationLogo string `json:"corporation_logo"`
+	AllianceLogo    string `json:"alliance_logo"`
+
+	Received           int64  `json:"received"`
+	ReceivedISK        db.ISK `json:"received_isk"`
+	ReceivedISKShort   string `json:"received_isk_short"`
+	Received7          int64  `json:"received_7"`
+	ReceivedISK7       db.ISK `json:"received_isk_7"`
+	ReceivedISK7Short  string `json:"received_isk_7_short"`
+	Received30         int64  `json:"received_30"`
+	ReceivedISK30      db.ISK `json:"received_isk_30"`
+	ReceivedISK30Short string `json:"received_isk_30_short"`
+	Received90         int64  `json:"received_90"`
+	ReceivedISK90      db.ISK `json:"received_isk_90"`
+	ReceivedISK90Short string `json:"received_isk_90_short"`
+	Donated            int64  `json:"donated"`
+	DonatedISK         db.ISK `json:"donated_isk"`
+	DonatedISKShort    string `json:"donated_isk_short"`
+	Donated7           int64  `json:"donated_7"`
+	DonatedISK7        db.ISK `json:"donated_isk_7"`
+	DonatedISK7Short   string `json:"donated_isk_7_short"`
+	Donated30          int64  `json:"donated_30"`
+	DonatedISK30       db.ISK `json:"donated_isk_30"`
+	DonatedISK30Short  string `json:"donated_isk_30_short"`
+	Donated90          int64  `json:"donated_90"`
+	DonatedISK90       db.ISK `json:"donated_isk_90"`
+	DonatedISK90Short  string `json:"donated_isk_90_short"`
+
+	LastDonated  *time.Time `json:"last_donated"`
+	LastReceived *time.Time `json:"last_received"`
+	GoodStanding bool       `json:"good_standing"`
+
+	ReceivedRank       int64      `json:"received_rank"`
+	DonatedRank        int64      `json:"donated_rank"`
+	ReceivedPercentile float64    `json:"received_percentile"`
+	DonatedPercentile  float64    `json:"donated_percentile"`
+	RankedAt           *time.Time `json:"ranked_at"`
+
+	LargestDonation *db.Largest `json:"largest_donation"`
+	LargestContract *db.Largest `json:"largest_contract"`
+
+	StreakCurrent int64 `json:"streak_current"`
+	StreakBest    int64 `json:"streak_best"`
+
+	DonorStats *db.DonorStats `json:"donor_stats"`
+
+	Hidden  bool `json:"hidden"`
+	Deleted bool `json:"deleted"`
+}
+
+// activityV2 is a donation or contract, received or sent, of the merged
+// activity of a character
+type activityV2 struct {
+	Type         string    `json:"type"`
+	Direction    string    `json:"direction"`
+	ID           int64     `json:"id"`
+	Timestamp    time.Time `json:"timestamp"`
+	Donator      int32     `json:"donator"`
+	Receiver     int32     `json:"receiver"`
+	Amount       db.ISK    `json:"amount"`
+	AmountShort  string    `json:"amount_short"`
+	Note         string    `json:"note"`
+	Counterparty *db.Party `json:"counterparty"`
+
+	// Contract is null for donations
+	Contract *contractV2 `json:"contract"`
+}
+
+// contractV2 are the fields of contract activity donations don't have
+type contractV2 struct {
+	Location     int64      `json:"location"`
+	LocationName string     `json:"location_name"`
+	Expires      time.Time  `json:"expires"`
+	Accepted     bool       `json:"accepted"`
+	Status       string     `json:"status"`
+	Items        []*db.Item `json:"items"`
+	ContainsPlex bool       `json:"contains_plex"`
+	NotableItems int32      `json:"notable_items"`
+}
+
+// charDetailsV2 is a character with its donations and contracts, received
+// and sent, merged into one activity feed, newest first
+type charDetailsV2 struct {
+	Character    *characterV2            `json:"character"`
+	Activity     []*activityV2           `json:"activity"`
+	Affiliations []*db.AffiliationChange `json:"affiliations"`
+
+	PendingContracts []*db.PendingContract `json:"pending_contracts,omitempty"`
+}
+
+// topCharactersV2 are the current leaderboards
+type topCharactersV2 struct {
+	Recipients []*characterV2 `json:"recipients"`
+	Donators   []*characterV2 `json:"donators"`
+}
+
+// bulkCharactersV2 is the response of a bulk character lookup
+type bulkCharactersV2 struct {
+	Characters []*characterV2 `json:"characters"`
+	Missing    []int32        `json:"missing"`
+}
+
+// nullTime is nil for the zero time
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+func newCharacterV2(c *db.Character) *characterV2 {
+	return &characterV2{
+		ID:              c.ID,
+		Name:            c.Name,
+		CorporationID:   c.CorporationID,
+		CorporationName: c.CorporationName,
+		AllianceID:      c.AllianceID,
+		AllianceName:    c.AllianceName,
+		Portrait:        c.Portrait,
+		CorporationLogo: c.CorporationLogo,
+		AllianceLogo:    c.AllianceLogo,
+
+		Received:           c.Received,
+		ReceivedISK:        c.ReceivedISK,
+		ReceivedISKShort:   c.ReceivedISK.Short(),
+		Received7:          c.Received7,
+		ReceivedISK7:       c.ReceivedISK7,
+		ReceivedISK7Short:  c.ReceivedISK7.Short(),
+		Received30:         c.Received30,
+		ReceivedISK30:      c.ReceivedISK30,
+		ReceivedISK30Short: c.ReceivedISK30.Short(),
+		Received90:         c.Received90,
+		ReceivedISK90:      c.ReceivedISK90,
+		ReceivedISK90Short: c.ReceivedISK90.Short(),
+		Donated:            c.Donated,
+		DonatedISK:         c.DonatedISK,
+		DonatedISKShort:    c.DonatedISK.Short(),
+		Donated7:           c.Donated7,
+		DonatedISK7:        c.DonatedISK7,
+		DonatedISK7Short:   c.DonatedISK7.Short(),
+		Donated30:          c.Donated30,
+		DonatedISK30:       c.DonatedISK30,
+		DonatedISK30Short:  c.DonatedISK30.Short(),
+		Donated90:          c.Donated90,
+		DonatedISK90:       c.DonatedISK90,
+		DonatedISK90Short:  c.DonatedISK90.Short(),
+
+		LastDonated:  nullTime(c.LastDonated),
+		LastReceived: nullTime(c.LastReceived),
+		GoodStanding: c.GoodStanding,
+
+		ReceivedRank:       c.ReceivedRank,
+		DonatedRank:        c.DonatedRank,
+		ReceivedPercentile: c.ReceivedPercentile,
+		DonatedPercentile:  c.DonatedPercentile,
+		RankedAt:           nullTime(c.RankedAt),
+
+		LargestDonation: c.LargestDonation,
+		LargestContract: c.LargestContract,
+
+		StreakCurrent: c.StreakCurrent,
+		StreakBest:    c.StreakBest,
+
+		DonorStats: c.DonorStats,
+
+		Hidden:  c.Hidden,
+		Deleted: c.Deleted,
+	}
+}
+
+func newCharactersV2(characters []*db.Character) []*characterV2 {
+	res := []*characterV2{}
+	for _, c := range characters {
+		res = append(res, newCharacterV2(c))
+	}
+	return res
+}
+
+func donationActivity(d *db.Donation, direction string) *activityV2 {
+	return &activityV2{
+		Type:         activityDonation,
+		Direction:    direction,
+		ID:           d.ID,
+		Timestamp:    d.Timestamp,
+		Donator:      d.Donator,
+		Receiver:     d.Recipient,
+		Amount:       d.Amount,
+		AmountShort:  d.Amount.Short(),
+		Note:         d.Note,
+		Counterparty: d.Counterparty,
+	}
+}
+
+func contractActivity(c *db.Contract, direction string) *activityV2 {
+	items := c.Items
+	if items == nil {
+		items = []*db.Item{}
+	}
+	return &activityV2{
+		Type:         activityContract,
+		Direction:    direction,
+		ID:           int64(c.ID),
+		Timestamp:    c.Issued,
+		Donator:      c.Donator,
+		Receiver:     c.Receiver,
+		Amount:       c.Value,
+		AmountShort:  c.Value.Short(),
+		Note:         c.Note,
+		Counterparty: c.Counterparty,
+		Contract: &contractV2{
+			Location:     c.Location,
+			LocationName: c.LocationName,
+			Expires:      c.Expires,
+			Accepted:     c.Accepted,
+			Status:       c.Status,
+			Items:        items,
+			ContainsPlex: c.ContainsPlex,
+			NotableItems: c.NotableItems,
+		},
+	}
+}
+
+// newActivity merges the donations and contracts of the character, newest
+// first. Ties are broken by ID, so the order is stable
+func newActivity(c *db.CharDetails) []*activityV2 {
+	activity := []*activityV2{}
+	for _, d := range c.Donations {
+		activity = append(activity, donationActivity(d, activityReceived))
+	}
+	for _, d := range c.Donated {
+		activity = append(activity, donationActivity(d, activitySent))
+	}
+	for _, contract := range c.Contracts {
+		activity = append(activity, contractActivity(contract, activityReceived))
+	}
+	for _, contract := range c.Contracted {
+		activity = append(activity, contractActivity(contract, activitySent))
+	}
+
+	sort.SliceStable(activity, func(i, j int) bool {
+		a, b := activity[i], activity[j]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.After(b.Timestamp)
+		}
+		return a.ID > b.ID
+	})
+	return activity
+}
+
+func detailsV1(c *db.CharDetails) interface{} { return c }
+
+func detailsV2(c *db.CharDetails) interface{} {
+	affiliations := c.Affiliations
+	if affiliations == nil {
+		affiliations = []*db.AffiliationChange{}
+	}
+	return &charDetailsV2{
+		Character:        newCharacterV2(c.Character),
+		Activity:         newActivity(c),
+		Affiliations:     affiliations,
+		PendingContracts: c.PendingContracts,
+	}
+}
+
+func topV1(t *topCharacters) interface{} { return t }
+
+func topV2(t *topCharacters) interface{} {
+	return &topCharactersV2{
+		Recipients: newCharactersV2(t.Recipients),
+		Donators:   newCharactersV2(t.Donators),
+	}
+}
+
+func charactersV1(b *bulkCharacters) interface{} { return b }
+
+func charactersV2(b *bulkCharacters) interface{} {
+	return &bulkCharactersV2{
+		Characters: newCharactersV2(b.Characters),
+		Missing:    b.Missing,
+	}
+}
+
+// CharacterDetailsV2 returns JSON describing the character, in the v2 shape
+func CharacterDetailsV2(ctx context.Context) http.HandlerFunc {
+	return characterDetails(ctx, detailsV2)
+}
+
+// TopRecipientsV2 returns the current leaderboards, in the v2 shape
+func TopRecipientsV2(ctx context.Context) http.HandlerFunc {
+	return topRecipients(ctx, topV2)
+}
+
+// CharactersV2 returns the summaries of a JSON array of character IDs, in
+// the v2 shape
+func CharactersV2(ctx context.Context) http.HandlerFunc {
+	return characters(ctx, charactersV2)
+}
+
+// Deprecated adds the deprecation and sunset dates of the v1 API set in the
+// options to responses of next, linking to its v2 successor
+func Deprecated(
+	ctx context.Context,
+	next http.Handler,
+	successor string,
+) http.Handler {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	if opts.V1Deprecation.IsZero() && opts.V1Sunset.IsZero() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !opts.V1Deprecation.IsZero() {
+			w.Header().Set(
+				"Deprecation",
+				"@"+strconv.FormatInt(opts.V1Deprecation.Unix(), 10),
+			)
+		}
+		if !opts.V1Sunset.IsZero() {
+			w.Header().Set("Sunset", opts.V1Sunset.UTC().Format(http.TimeFormat))
+		}
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+func TestDetailsV2Activity(t *testing.T) {
+	at := time.Date(2019, 1, 20, 12, 0, 0, 0, time.UTC)
+	c := &db.CharDetails{
+		Character: &db.Character{ID: 1},
+		Donations: db.Donations{
+			{ID: 3, Donator: 2, Recipient: 1, Timestamp: at},
+			{ID: 1, Donator: 2, Recipient: 1, Timestamp: at.Add(-time.Hour)},
+		},
+		Donated: db.Donations{
+			{ID: 4, Donator: 1, Recipient: 2, Timestamp: at},
+		},
+		Contracts: db.Contracts{
+			{ID: 9, Donator: 2, Receiver: 1, Issued: at.Add(time.Hour)},
+		},
+	}
+
+	details := detailsV2(c).(*charDetailsV2)
+	received := []string{}
+	for _, a := range details.Activity {
+		received = append(received, a.Type+" "+a.Direction)
+	}
+	expected := []string{
+		"contract received",
+		"donation sent",
+		"donation received",
+		"donation received",
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected activity %v, received %v", expected, received)
+	}
+	if details.Activity[1].ID != 4 || details.Activity[3].ID != 1 {
+		t.Errorf("unexpected activity order %+v", details.Activity)
+	}
+	if details.Activity[0].Contract == nil ||
+		details.Activity[1].Contract != nil {
+		t.Error("expected only contracts to have contract fields")
+	}
+}
+
+func TestCharacterV2ZeroFields(t *testing.T) {
+	raw, err := json.Marshal(detailsV2(&db.CharDetails{
+		Character: &db.Character{ID: 1},
+	}))
+	if err != nil {
+		t.Fatalf("failed to marshal: %+v", err)
+	}
+
+	details := struct {
+		Character    map[string]interface{} `json:"character"`
+		Activity     []interface{}          `json:"activity"`
+		Affiliations []interface{}          `json:"affiliations"`
+	}{}
+	if err := json.Unmarshal(raw, &details); err != nil {
+		t.Fatalf("failed to unmarshal: %+v", err)
+	}
+	for field, expected := range map[string]interface{}{
+		"received":           0.0,
+		"received_isk":       0.0,
+		"received_isk_short": "0",
+		"alliance":           0.0,
+		"name":               "",
+		"last_received":      nil,
+		"deleted":            false,
+	} {
+		value, found := details.Character[field]
+		if !found {
+			t.Errorf("expected %s to be present", field)
+		} else if value != expected {
+			t.Errorf("%s: expected %v, received %v", field, expected, value)
+		}
+	}
+
+	if details.Activity == nil || details.Affiliations == nil {
+		t.Errorf("expected empty lists, received %s", raw)
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	opts := &cx.Options{}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	ctx := context.WithValue(context.Background(), cx.Opts, opts)
+
+	w := httptest.NewRecorder()
+	Deprecated(ctx, next, "/api/v2/top").ServeHTTP(
+		w,
+		httptest.NewRequest(http.MethodGet, "/api/top", nil),
+	)
+	if len(w.Header()) != 0 {
+		t.Errorf("expected no headers without dates, received %v", w.Header())
+	}
+
+	if err := opts.V1Deprecation.Set("2026-01-01"); err != nil {
+		t.Fatalf("failed to set deprecation: %+v", err)
+	}
+	if err := opts.V1Sunset.Set("2027-06-30"); err != nil {
+		t.Fatalf("failed to set sunset: %+v", err)
+	}
+
+	w = httptest.NewRecorder()
+	Deprecated(ctx, next, "/api/v2/top").ServeHTTP(
+		w,
+		httptest.NewRequest(http.MethodGet, "/api/top", nil),
+	)
+	for header, expected := range map[string]string{
+		"Deprecation": "@1767225600",
+		"Sunset":      "Wed, 30 Jun 2027 00:00:00 GMT",
+		"Link":        `</api/v2/top>; rel="successor-version"`,
+	} {
+		if received := w.Header().Get(header); received != expected {
+			t.Errorf("%s: expected %q, received %q", header, expected, received)
+		}
+	}
+}
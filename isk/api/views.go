@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+const (
+	// viewFlushInterval is how often the counted page views are written
+	viewFlushInterval = time.Minute
+
+	// maxSeenViews is the most IP and character pairs remembered an hour,
+	// views past it aren't counted until the next hour
+	maxSeenViews = 100000
+)
+
+// viewKey is a character in an hour
+type viewKey struct {
+	charID int32
+	hour   time.Time
+}
+
+// seenKey is a character viewed from an IP
+type seenKey struct {
+	ip     string
+	charID int32
+}
+
+// Views counts character page views in memory, once per IP an hour. Run
+// writes them to the db periodically, so no request waits on a write
+type Views struct {
+	lock   *sync.Mutex
+	counts map[viewKey]int64
+	seen   map[seenKey]bool
+	hour   time.Time
+}
+
+// NewViews returns an empty Views
+func NewViews() *Views {
+	return &Views{
+		lock:   &sync.Mutex{},
+		counts: map[viewKey]int64{},
+		seen:   map[seenKey]bool{},
+	}
+}
+
+// Add counts a view of the character's page from the IP, unless it was
+// already counted this hour
+func (v *Views) Add(charID int32, ip string, now time.Time) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	hour := now.UTC().Truncate(time.Hour)
+	if !hour.Equal(v.hour) {
+		v.hour = hour
+		v.seen = map[seenKey]bool{}
+	}
+
+	seen := seenKey{ip: ip, charID: charID}
+	if v.seen[seen] || len(v.seen) >= maxSeenViews {
+		return
+	}
+	v.seen[seen] = true
+	v.counts[viewKey{charID: charID, hour: hour}]++
+}
+
+// take returns the views counted, starting the count again
+func (v *Views) take() []*db.HourViews {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	views := []*db.HourViews{}
+	for key, n := range v.counts {
+		views = append(views, &db.HourViews{
+			CharacterID: key.charID,
+			Hour:        key.hour,
+			Views:       n,
+		})
+	}
+	v.counts = map[viewKey]int64{}
+	return views
+}
+
+// restore adds views which failed to be written back to the count
+func (v *Views) restore(views []*db.HourViews) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	for _, view := range views {
+		v.counts[viewKey{charID: view.CharacterID, hour: view.Hour}] += view.Views
+	}
+}
+
+// Flush writes the views counted to the db, they are kept to write again if
+// it fails
+func (v *Views) Flush(ctx context.Context) error {
+	views := v.take()
+	if err := db.AddViews(ctx, views); err != nil {
+		v.restore(views)
+		return err
+	}
+	return nil
+}
+
+// Run flushes the views periodically until the context is done
+func (v *Views) Run(ctx context.Context) {
+	ticker := time.NewTicker(viewFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := v.Flush(ctx); err != nil {
+				cx.Logf(ctx, "failed to flush page views: %+v", err)
+			}
+		}
+	}
+}
+
+// CountViews counts a view of the character in the "c" query arg of GET
+// requests, ahead of next, so views served from the cache are counted too
+func CountViews(ctx context.Context, next http.Handler) http.Handler {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	views := ctx.Value(cx.Views).(*Views)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && !isWarming(r) {
+			if charID, err := getCharID(r); err == nil {
+				views.Add(charID, clientIP(r, opts.TrustedProxy), time.Now())
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
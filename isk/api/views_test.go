@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// viewCounts sums the views taken by character
+func viewCounts(views []*db.HourViews) map[int32]int64 {
+	counts := map[int32]int64{}
+	for _, v := range views {
+		counts[v.CharacterID] += v.Views
+	}
+	return counts
+}
+
+func TestCountViews(t *testing.T) {
+	views := NewViews()
+	ctx := context.WithValue(context.Background(), cx.Views, views)
+	ctx = context.WithValue(ctx, cx.Opts, &cx.Options{})
+	h := CountViews(ctx, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	get := func(path, ip string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = ip + ":1234"
+		return r
+	}
+
+	for _, r := range []*http.Request{
+		get("/api/char?c=1", "10.0.0.1"),
+		get("/api/char?c=1", "10.0.0.1"),
+		get("/api/char?c=1", "10.0.0.2"),
+		get("/api/char?c=2", "10.0.0.1"),
+		get("/api/char?c=some-slug", "10.0.0.1"),
+		httptest.NewRequest(http.MethodPost, "/api/char?c=3", nil),
+		get("/api/char?c=4", "10.0.0.1").WithContext(
+			context.WithValue(context.Background(), warmingKey{}, true),
+		),
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	taken := views.take()
+	if counts := viewCounts(taken); len(counts) != 2 ||
+		counts[1] != 2 || counts[2] != 1 {
+		t.Errorf("unexpected views counted %+v", counts)
+	}
+
+	views.Add(1, "10.0.0.3", time.Now())
+	views.restore(taken)
+	if counts := viewCounts(views.take()); counts[1] != 3 || counts[2] != 1 {
+		t.Errorf("views failing to flush were not kept %+v", counts)
+	}
+}
+
+func TestViewsDeduplicatedHourly(t *testing.T) {
+	views := NewViews()
+	hour := time.Date(2019, 1, 20, 12, 0, 0, 0, time.UTC)
+
+	views.Add(1, "10.0.0.1", hour)
+	views.Add(1, "10.0.0.1", hour.Add(59*time.Minute))
+	views.Add(1, "10.0.0.1", hour.Add(time.Hour))
+
+	taken := views.take()
+	if len(taken) != 2 {
+		t.Fatalf("expected a view in each hour, received %d", len(taken))
+	}
+	for _, v := range taken {
+		if v.Views != 1 || v.Hour.Minute() != 0 {
+			t.Errorf("unexpected hour views %+v", v)
+		}
+	}
+}
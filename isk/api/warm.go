@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// warmPaths are the leaderboards, always warmed
+var warmPaths = []string{
+	"/api/top",
+	"/api/v2/top",
+	"/api/leaderboard/trending",
+	"/api/leaderboard/corporations",
+	"/api/leaderboard/alliances",
+}
+
+// warmCharPaths are the pages warmed of each of the most viewed characters
+var warmCharPaths = []string{"/api/char?c=%d", "/api/v2/char?c=%d"}
+
+// warmingKey marks the requests of a Warmer, which aren't page views
+type warmingKey struct{}
+
+func isWarming(r *http.Request) bool {
+	warming, _ := r.Context().Value(warmingKey{}).(bool)
+	return warming
+}
+
+// Warmer fills the response cache with the leaderboards and the pages of the
+// most viewed characters, by requesting them as a logged out client would
+type Warmer struct {
+	lock    *sync.Mutex
+	handler http.Handler
+	pages   int
+	warmed  int32
+}
+
+// NewWarmer returns a Warmer of the pages most viewed characters. It is not
+// warmed until Warm is done, unless warmed is given
+func NewWarmer(pages int, warmed bool) *Warmer {
+	w := &Warmer{lock: &sync.Mutex{}, pages: pages}
+	if warmed {
+		w.warmed = 1
+	}
+	return w
+}
+
+// SetHandler sets the handler pages are requested from, it must be called
+// before Warm
+func (w *Warmer) SetHandler(handler http.Handler) {
+	w.handler = handler
+}
+
+// Warmed is true once the cache has been warmed, even if that failed
+func (w *Warmer) Warmed() bool {
+	return atomic.LoadInt32(&w.warmed) == 1
+}
+
+// Warm requests every page, returning the number of responses cached
+func (w *Warmer) Warm(ctx context.Context) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	defer atomic.StoreInt32(&w.warmed, 1)
+
+	paths := append([]string{}, warmPaths...)
+	if w.pages > 0 {
+		charIDs, err := db.MostViewed(ctx, w.pages)
+		if err != nil {
+			return 0, err
+		}
+		for _, charID := range charIDs {
+			for _, path := range warmCharPaths {
+				paths = append(paths, fmt.Sprintf(path, charID))
+			}
+		}
+	}
+
+	ctx = context.WithValue(ctx, warmingKey{}, true)
+	cached := 0
+	for _, path := range paths {
+		// each encoding is cached apart
+		for _, encoding := range []string{"gzip", ""} {
+			r, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+			if err != nil {
+				return cached, err
+			}
+			if encoding != "" {
+				r.Header.Set("Accept-Encoding", encoding)
+			}
+
+			rec := &warmRecorder{header: http.Header{}, status: http.StatusOK}
+			w.handler.ServeHTTP(rec, r)
+			if rec.status >= 200 && rec.status < 300 {
+				cached++
+			}
+		}
+	}
+	return cached, nil
+}
+
+// WarmCache warms the response cache in the background
+func WarmCache(ctx context.Context) http.HandlerFunc {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	warmer := ctx.Value(cx.Warmer).(*Warmer)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			write405(w, r)
+			return
+		}
+
+		if !isAdmin(opts, r) {
+			write403(w, r)
+			return
+		}
+
+		auditAdmin(
+			requestContext(ctx, r),
+			"warm_cache",
+			cacheTarget,
+			map[string]int{"pages": warmer.pages},
+		)
+
+		// warming takes longer than a response may, so it doesn't wait. It
+		// is done without the admin's request, not to cache it as them
+		go func() {
+			cached, err := warmer.Warm(ctx)
+			if err != nil {
+				cx.Logf(ctx, "failed to warm the cache: %+v", err)
+			}
+			cx.Logf(ctx, "admin warmed %d cached responses", cached)
+		}()
+
+		writeJSONStatus(w, http.StatusAccepted, map[string]string{
+			"status": "warming",
+		}, 0)
+	}
+}
+
+// warmRecorder discards the responses of warming requests
+type warmRecorder struct {
+	header http.Header
+	status int
+	wrote  bool
+}
+
+func (r *warmRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *warmRecorder) WriteHeader(status int) {
+	if !r.wrote {
+		r.status = status
+		r.wrote = true
+	}
+}
+
+func (r *warmRecorder) Write(b []byte) (int, error) {
+	r.wrote = true
+	return len(b), nil
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestWarmLeaderboards(t *testing.T) {
+	requested := map[string]int{}
+	warming := true
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested[r.URL.String()+" "+r.Header.Get("Accept-Encoding")]++
+		warming = warming && isWarming(r)
+		if r.URL.Path != "/api/top" {
+			w.WriteHeader(500)
+		}
+	})
+
+	warmer := NewWarmer(0, false)
+	warmer.SetHandler(handler)
+	if warmer.Warmed() {
+		t.Fatal("warmer was warmed before warming")
+	}
+
+	cached, err := warmer.Warm(context.Background())
+	if err != nil {
+		t.Fatalf("failed to warm: %+v", err)
+	}
+	if cached != 2 {
+		t.Errorf("expected 2 responses cached, received %d", cached)
+	}
+	if !warmer.Warmed() || !warming {
+		t.Errorf("warmed %v, requests flagged as warming %v", warmer.Warmed(), warming)
+	}
+	for _, key := range []string{
+		"/api/top gzip",
+		"/api/top ",
+		"/api/v2/top gzip",
+		"/api/v2/top ",
+		"/api/leaderboard/trending gzip",
+		"/api/leaderboard/trending ",
+	} {
+		if requested[key] != 1 {
+			t.Errorf("%q was requested %d times", key, requested[key])
+		}
+	}
+}
+
+func TestReadyWarming(t *testing.T) {
+	ctx := context.WithValue(context.Background(), cx.Warmer, NewWarmer(0, false))
+	w := httptest.NewRecorder()
+	Ready(ctx)(w, httptest.NewRequest(http.MethodGet, "/api/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while warming, received %d", w.Code)
+	}
+}
+
+func TestWarmCacheAdmin(t *testing.T) {
+	ctx := context.WithValue(context.Background(), cx.Opts, &cx.Options{
+		AppSecret: "secret",
+	})
+	ctx = context.WithValue(ctx, cx.Warmer, NewWarmer(0, true))
+
+	path := "/api/admin/cache/warm"
+	w := httptest.NewRecorder()
+	WarmCache(ctx)(w, httptest.NewRequest(http.MethodPost, path, nil))
+	if w.Code != 403 {
+		t.Errorf("expected 403 without the secret, received %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	WarmCache(ctx)(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code != 405 {
+		t.Errorf("expected 405, received %d", w.Code)
+	}
+}
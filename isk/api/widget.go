@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// widgetPath is the route prefix of the embeddable widget
+const widgetPath = "/widget/"
+
+// widgetCSP only allows the inline styles and the portraits the widget uses
+const widgetCSP = "default-src 'none'; style-src 'unsafe-inline'; " +
+	"img-src https://imageserver.eveonline.com; frame-ancestors *"
+
+// widgetThemes are the allowed theme query args and their colours
+var widgetThemes = map[string]string{
+	"dark":        "color: #eee; background: #1b1d22;",
+	"light":       "color: #222; background: #fafafa;",
+	"transparent": "color: #fff; background: transparent; text-shadow: 0 0 3px #000;",
+}
+
+// widget is everything rendered by the widget template
+type widget struct {
+	Name        string
+	ID          int32
+	Style       template.CSS
+	Header      string
+	Footer      string
+	Rows        []template.HTML
+	Received    string
+	ReceivedISK string
+}
+
+var widgetTemplate = template.Must(template.New("widget").Parse(`<!doctype html>
+<html lang="en">
+ <head>
+  <meta charset="utf-8">
+  <meta http-equiv="refresh" content="60">
+  <title>ESI ISK - {{.Name}}</title>
+  <style>
+   body { margin: 0; padding: 8px; font-family: sans-serif; {{.Style}} }
+   header, footer { font-weight: bold; }
+   article { padding: 2px 0; }
+   .totals { display: flex; align-items: center; gap: 8px; margin: 8px 0; }
+   .totals img { width: 32px; height: 32px; }
+  </style>
+ </head>
+ <body>
+  {{- if .Header}}
+  <header>{{.Header}}</header>
+  {{- end}}
+  <main>
+   <div class="totals">
+    <img src="https://imageserver.eveonline.com/Character/{{.ID}}_64.jpg" alt="">
+    <span>{{.ReceivedISK}} ISK received from {{.Received}} donations</span>
+   </div>
+   {{- range .Rows}}
+   <article>{{.}}</article>
+   {{- end}}
+  </main>
+  {{- if .Footer}}
+  <footer>{{.Footer}}</footer>
+  {{- end}}
+ </body>
+</html>
+`))
+
+// Widget is a self-contained HTML view of the character's recent donations
+func Widget(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(ctx, r)
+
+		charID, err := getPathCharID(r)
+		if err != nil {
+			write400(w, r, "invalid character ID")
+			return
+		}
+
+		style, err := getWidgetTheme(r)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		limit, err := getWidgetLimit(ctx, r)
+		if err != nil {
+			write400(w, r, err.Error())
+			return
+		}
+
+		c, err := db.GetCharDetails(ctx, charID)
+		if err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+		if c.Character.Banned {
+			writeDBError(w, r, db.ErrCharacterNotFound)
+			return
+		}
+
+		p, err := getPreferences(w, r.WithContext(ctx), charID)
+		if err != nil {
+			// getPreferences writes any errors
+			return
+		}
+
+		if pErr := checkPassphrase(r, c, p); pErr != nil {
+			write403(w, r)
+			return
+		}
+
+		if err := maskAnonymous(ctx, r, c); err != nil {
+			write500(w, r, err)
+			return
+		}
+
+		if limit > 0 {
+			for _, prefs := range []*db.Prefs{p.Donations, p.Contracts} {
+				if prefs != nil {
+					prefs.Rows = limit
+				}
+			}
+		}
+
+		cache.Tag(w, cache.CharacterTag(charID))
+		writeCacheHeaders(ctx, w)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		if wErr := widgetTemplate.Execute(w, newWidget(ctx, c, p, style)); wErr != nil {
+			// some of the response may already be written
+			cx.Logf(ctx, "failed to write widget: %+v", wErr)
+		}
+	}
+}
+
+// Embeddable allows the widget to be framed by other sites, such as an OBS
+// browser source, replacing the frame denial and CSP set by secure
+func Embeddable(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if strings.HasPrefix(r.URL.Path, widgetPath) {
+		w.Header().Del("X-Frame-Options")
+		w.Header().Set("Content-Security-Policy", widgetCSP)
+	}
+	next(w, r)
+}
+
+func newWidget(
+	ctx context.Context,
+	c *db.CharDetails,
+	p *db.Preferences,
+	style template.CSS,
+) *widget {
+	prefs := p.Donations
+	if prefs == nil {
+		prefs = p.Contracts
+	}
+
+	rows := []template.HTML{}
+	for _, row := range viewRows(ctx, c, p) {
+		rows = append(rows, row.str)
+	}
+
+	printer := message.NewPrinter(language.English)
+	return &widget{
+		Name:        c.Character.Name,
+		ID:          c.Character.ID,
+		Style:       style,
+		Header:      prefs.Header,
+		Footer:      prefs.Footer,
+		Rows:        rows,
+		Received:    printer.Sprintf("%d", c.Character.Received),
+		ReceivedISK: c.Character.ReceivedISK.Short(),
+	}
+}
+
+// getWidgetTheme reads the "theme" query arg, defaulting to dark
+func getWidgetTheme(r *http.Request) (template.CSS, error) {
+	theme := r.URL.Query().Get("theme")
+	if theme == "" {
+		theme = "dark"
+	}
+	style, ok := widgetThemes[theme]
+	if !ok {
+		return "", errors.New("theme must be dark, light or transparent")
+	}
+	return template.CSS(style), nil
+}
+
+// getWidgetLimit reads the "limit" query arg, 0 uses the preferred rows
+func getWidgetLimit(ctx context.Context, r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return 0, nil
+	}
+
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > opts.MaxPrefRows {
+		return 0, fmt.Errorf("limit must be 1 to %d", opts.MaxPrefRows)
+	}
+	return limit, nil
+}
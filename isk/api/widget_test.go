@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+func TestWidgetValidation(t *testing.T) {
+	ctx := context.WithValue(context.Background(), cx.Opts, &cx.Options{
+		MaxPrefRows: 10,
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/widget/{id}", Widget(ctx))
+
+	for _, target := range []string{
+		"/widget/abc",
+		"/widget/0",
+		"/widget/1?theme=neon",
+		"/widget/1?limit=0",
+		"/widget/1?limit=11",
+		"/widget/1?limit=x",
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != 400 {
+			t.Errorf("%s: expected 400, received %d", target, w.Code)
+		}
+	}
+}
+
+func TestEmbeddable(t *testing.T) {
+	for path, framed := range map[string]bool{
+		"/widget/1": true,
+		"/api/char": false,
+	} {
+		w := httptest.NewRecorder()
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+
+		Embeddable(w, httptest.NewRequest(http.MethodGet, path, nil), func(
+			w http.ResponseWriter,
+			r *http.Request,
+		) {
+		})
+
+		if received := w.Header().Get("X-Frame-Options") == ""; received != framed {
+			t.Errorf("%s: frame options removed %t, expected %t", path, received, framed)
+		}
+		csp := w.Header().Get("Content-Security-Policy")
+		if received := strings.Contains(csp, "frame-ancestors *"); received != framed {
+			t.Errorf("%s: unexpected CSP %q", path, csp)
+		}
+	}
+}
+
+func TestWidgetEscapesRows(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := widgetTemplate.Execute(buf, &widget{
+		Name:   "Some <b>Name</b>",
+		ID:     1,
+		Style:  "color: #fff;",
+		Header: "<img src=x onerror=alert(1)>",
+		Rows: []template.HTML{replaceRow(
+			"%NOTE% just donated 10 ISK!",
+			map[string]string{"%NOTE%": `<script>alert("note")</script>`},
+		)},
+	})
+	if err != nil {
+		t.Fatalf("failed to render widget: %+v", err)
+	}
+
+	page := buf.String()
+	for _, raw := range []string{"<script>", "<b>", "<img src=x"} {
+		if strings.Contains(page, raw) {
+			t.Errorf("widget contains unescaped %q", raw)
+		}
+	}
+	if !strings.Contains(page, "&lt;script&gt;") {
+		t.Errorf("widget row was not rendered escaped:\n%s", page)
+	}
+	if strings.Contains(page, "<footer>") {
+		t.Error("widget rendered an empty footer")
+	}
+}
+
+func TestWidgetShortISK(t *testing.T) {
+	w := newWidget(
+		context.Background(),
+		&db.CharDetails{Character: &db.Character{
+			ID:          1,
+			Received:    1234,
+			ReceivedISK: db.NewISK(45600000),
+		}},
+		&db.Preferences{Donations: &db.Prefs{}},
+		"",
+	)
+	if w.ReceivedISK != "45.6m" || w.Received != "1,234" {
+		t.Errorf("unexpected totals %s from %s", w.ReceivedISK, w.Received)
+	}
+}
@@ -0,0 +1,61 @@
+// Package buildinfo holds the version details set at build time with
+// -ldflags "-X github.com/a-tal/esi-isk/isk/buildinfo.Version=..."
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// homepage is included in our User-Agent so CCP can reach us
+const homepage = "https://github.com/a-tal/esi-isk/"
+
+// set by -ldflags at build time
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build info of the running binary
+func Get() *Info {
+	return &Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// String is the one line description printed by -version
+func (i *Info) String() string {
+	return fmt.Sprintf(
+		"esi-isk %s (commit %s, built %s, %s)",
+		i.Version,
+		i.Commit,
+		i.Date,
+		i.GoVersion,
+	)
+}
+
+// UserAgent is the User-Agent we identify ourselves to ESI with, including
+// any non-empty details such as our hostname and a maintainer contact
+func UserAgent(details ...string) string {
+	comments := []string{}
+	for _, detail := range details {
+		if detail != "" {
+			comments = append(comments, detail)
+		}
+	}
+	comments = append(comments, "+"+homepage)
+	return fmt.Sprintf("esi-isk/%s (%s)", Version, strings.Join(comments, "; "))
+}
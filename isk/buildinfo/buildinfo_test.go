@@ -0,0 +1,38 @@
+package buildinfo
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	Version, Commit, Date = "1.2.3", "abc1234", "2019-01-02T03:04:05Z"
+	defer func() { Version, Commit, Date = "dev", "unknown", "unknown" }()
+
+	info := Get()
+	if info.Version != "1.2.3" || info.Commit != "abc1234" {
+		t.Errorf("unexpected build info: %+v", info)
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("expected go version %s, received %s", runtime.Version(), info.GoVersion)
+	}
+
+	expected := "esi-isk 1.2.3 (commit abc1234, built 2019-01-02T03:04:05Z, "
+	if !strings.HasPrefix(info.String(), expected) {
+		t.Errorf("unexpected version string %q", info.String())
+	}
+
+	for expected, details := range map[string][]string{
+		"esi-isk/1.2.3 (+" + homepage + ")":                  nil,
+		"esi-isk/1.2.3 (isk.example.com; +" + homepage + ")": {"isk.example.com", ""},
+		"esi-isk/1.2.3 (isk.example.com; me@example.com; +" + homepage + ")": {
+			"isk.example.com",
+			"me@example.com",
+		},
+	} {
+		if ua := UserAgent(details...); ua != expected {
+			t.Errorf("expected user agent %q, received %q", expected, ua)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package cache
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/compress"
+)
+
+// TopTag tags the top recipients and donators leaderboards
+const TopTag = "top"
+
+// SitemapTag tags the sitemap of character pages
+const SitemapTag = "sitemap"
+
+// CharacterTag returns the tag of responses showing the character
+func CharacterTag(charID int32) string {
+	return fmt.Sprintf("char:%d", charID)
+}
+
+// Cache is a cache of HTTP responses, held in a Store
+type Cache struct {
+	store  Store
+	ttl    time.Duration
+	bypass func(*http.Request) bool
+
+	hits, misses uint64
+}
+
+// Stats are the cache hit and miss counters
+type Stats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+// encodings are the Accept-Encoding classes responses are cached under
+var encodings = []string{"gzip", "identity"}
+
+// New returns a new Cache holding up to capacity responses in memory for ttl
+func New(capacity int, ttl time.Duration) *Cache {
+	return NewWithStore(NewMemory(capacity), ttl)
+}
+
+// NewWithStore returns a new Cache holding responses in the store for ttl
+func NewWithStore(store Store, ttl time.Duration) *Cache {
+	return &Cache{store: store, ttl: ttl}
+}
+
+// SetBypass sets which requests skip the cache entirely, for responses which
+// depend on who is asking. It must be called before the cache is in use
+func (c *Cache) SetBypass(bypass func(*http.Request) bool) {
+	c.bypass = bypass
+}
+
+// encoding returns the class of the request's Accept-Encoding header
+func encoding(r *http.Request) string {
+	if compress.Accepted(r) {
+		return "gzip"
+	}
+	return "identity"
+}
+
+// normalize returns the path and query with parameters and values sorted
+func normalize(u *url.URL) string {
+	params := u.Query()
+	for _, values := range params {
+		sort.Strings(values)
+	}
+	if len(params) == 0 {
+		return u.Path
+	}
+	return u.Path + "?" + params.Encode() // Encode sorts by key
+}
+
+// Key returns the cache key of the request
+func Key(r *http.Request) string {
+	return encoding(r) + " " + normalize(r.URL)
+}
+
+// Middleware caches successful GET responses of next for ttl, or the cache
+// default ttl when ttl is zero
+func (c *Cache) Middleware(next http.Handler, ttl time.Duration) http.Handler {
+	if ttl == 0 {
+		ttl = c.ttl
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || (c.bypass != nil && c.bypass(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := Key(r)
+		if e := c.get(key, time.Now()); e != nil {
+			atomic.AddUint64(&c.hits, 1)
+			c.write(w, e, "HIT")
+			return
+		}
+		atomic.AddUint64(&c.misses, 1)
+
+		rec := &recorder{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		e := &Entry{
+			Key:     key,
+			Status:  rec.status,
+			Header:  rec.header,
+			Body:    rec.body,
+			Tags:    rec.tags,
+			Expires: time.Now().Add(ttl),
+		}
+
+		// error responses (and anything else unusual) are never cached
+		if e.Status >= 200 && e.Status < 300 {
+			if encoding(r) == "gzip" {
+				c.compress(e)
+			}
+			c.store.Set(e)
+		}
+
+		c.write(w, e, "MISS")
+	})
+}
+
+// compress stores the gzip variant of the entry, so every hit doesn't need to
+// compress it again
+func (c *Cache) compress(e *Entry) {
+	if !compress.Compressible(e.Header, len(e.Body)) {
+		return
+	}
+
+	body, err := compress.Gzip(e.Body)
+	if err != nil {
+		log.Printf("failed to compress cached response: %+v", err)
+		return
+	}
+
+	e.Body = body
+	compress.SetEncoded(e.Header)
+}
+
+func (c *Cache) write(w http.ResponseWriter, e *Entry, result string) {
+	for k, v := range e.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Cache", result)
+	w.WriteHeader(e.Status)
+	if _, err := w.Write(e.Body); err != nil {
+		log.Printf("failed to write cached response: %+v", err)
+	}
+}
+
+func (c *Cache) get(key string, now time.Time) *Entry {
+	return c.store.Get(key, now)
+}
+
+// Tag marks the response being written by a cached handler with the tags,
+// so it can be invalidated by tag later. Other writers are ignored
+func Tag(w http.ResponseWriter, tags ...string) {
+	if rec, ok := w.(*recorder); ok {
+		rec.tags = append(rec.tags, tags...)
+	}
+}
+
+// Invalidate drops every response tagged with any of the tags, returning the
+// number of responses dropped
+func (c *Cache) Invalidate(tags ...string) int {
+	return c.store.Invalidate(tags...)
+}
+
+// Purge drops every cached response, returning the number dropped
+func (c *Cache) Purge() int {
+	return c.store.Purge()
+}
+
+// Release drops the cached responses of the path (with any query string)
+func (c *Cache) Release(path string) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return
+	}
+
+	keys := []string{}
+	for _, enc := range encodings {
+		keys = append(keys, enc+" "+normalize(u))
+	}
+	c.store.Remove(keys...)
+}
+
+// Stats returns the current cache counters, hits and misses are of this
+// process only
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Entries: c.store.Len(),
+	}
+}
+
+// recorder captures a response so it can be cached before being written
+type recorder struct {
+	header http.Header
+	status int
+	body   []byte
+	tags   []string
+	wrote  bool
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wrote {
+		r.status = status
+		r.wrote = true
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wrote = true
+	r.body = append(r.body, b...)
+	return len(b), nil
+}
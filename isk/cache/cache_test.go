@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// counter is a handler counting its calls, responding with status
+type counter struct {
+	calls  int
+	status int
+}
+
+func (c *counter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.calls++
+	w.WriteHeader(c.status)
+	if _, err := w.Write([]byte(r.URL.RawQuery)); err != nil {
+		panic(err)
+	}
+}
+
+func get(h http.Handler, target string, encoding string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if encoding != "" {
+		r.Header.Set("Accept-Encoding", encoding)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCacheQueryAware(t *testing.T) {
+	c := New(10, time.Minute)
+	next := &counter{status: 200}
+	h := c.Middleware(next, 0)
+
+	if w := get(h, "/api/char?c=1&t=d", ""); w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("first request was not a miss")
+	}
+
+	w := get(h, "/api/char?t=d&c=1", "")
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "c=1&t=d" {
+		t.Errorf("reordered query was not a hit: %q", w.Body.String())
+	}
+
+	get(h, "/api/char?c=2&t=d", "")
+	if next.calls != 2 {
+		t.Errorf("different query served from cache, %d calls", next.calls)
+	}
+
+	get(h, "/api/char?c=1&t=d", "gzip, deflate")
+	if next.calls != 3 {
+		t.Errorf("gzip request shared an identity entry, %d calls", next.calls)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Entries != 3 {
+		t.Errorf("invalid stats: %+v", stats)
+	}
+}
+
+func TestCacheSkipsErrors(t *testing.T) {
+	c := New(10, time.Minute)
+	for _, status := range []int{302, 400, 404, 500} {
+		next := &counter{status: status}
+		h := c.Middleware(next, 0)
+		get(h, "/api/char?c=1", "")
+		w := get(h, "/api/char?c=1", "")
+		if next.calls != 2 {
+			t.Errorf("%d response was cached", status)
+		}
+		if w.Code != status {
+			t.Errorf("status %d was returned as %d", status, w.Code)
+		}
+	}
+	if entries := c.Stats().Entries; entries != 0 {
+		t.Errorf("cache has %d entries", entries)
+	}
+}
+
+func TestCacheBypass(t *testing.T) {
+	c := New(10, time.Minute)
+	c.SetBypass(func(r *http.Request) bool { return r.Header.Get("Cookie") != "" })
+	next := &counter{status: 200}
+	h := c.Middleware(next, 0)
+
+	get(h, "/api/char?c=1", "")
+
+	r := httptest.NewRequest(http.MethodGet, "/api/char?c=1", nil)
+	r.Header.Set("Cookie", "session=1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if next.calls != 2 || w.Header().Get("X-Cache") != "" {
+		t.Errorf("bypassed request was served from the cache")
+	}
+	if entries := c.Stats().Entries; entries != 1 {
+		t.Errorf("cache has %d entries", entries)
+	}
+}
+
+func TestCacheTTLAndRelease(t *testing.T) {
+	c := New(10, time.Minute)
+	next := &counter{status: 200}
+	h := c.Middleware(next, time.Hour)
+
+	get(h, "/api/top", "")
+	key := Key(httptest.NewRequest(http.MethodGet, "/api/top", nil))
+	if e := c.get(key, time.Now().Add(59*time.Minute)); e == nil {
+		t.Error("route ttl was not applied")
+	}
+	if e := c.get(key, time.Now().Add(61*time.Minute)); e != nil {
+		t.Error("expired entry was returned")
+	}
+
+	get(h, "/api/top?a=1", "")
+	get(h, "/api/top?a=1", "gzip")
+	c.Release("/api/top?a=1")
+	if entries := c.Stats().Entries; entries != 0 {
+		t.Errorf("release left %d entries", entries)
+	}
+}
+
+func TestCacheCapacity(t *testing.T) {
+	c := New(2, time.Minute)
+	h := c.Middleware(&counter{status: 200}, 0)
+
+	get(h, "/a", "")
+	get(h, "/b", "")
+	get(h, "/a", "") // a is now most recently used
+	get(h, "/c", "")
+
+	if c.get(Key(httptest.NewRequest(http.MethodGet, "/b", nil)), time.Now()) != nil {
+		t.Error("least recently used entry was not evicted")
+	}
+	if c.get(Key(httptest.NewRequest(http.MethodGet, "/a", nil)), time.Now()) == nil {
+		t.Error("recently used entry was evicted")
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	c := New(10, time.Minute)
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Tag(w, CharacterTag(1), r.URL.Query().Get("tag"))
+	}), 0)
+
+	get(h, "/api/char?tag=a", "")
+	get(h, "/api/char?tag=a", "gzip")
+	get(h, "/api/custom?tag=b", "")
+
+	if dropped := c.Invalidate("a"); dropped != 2 {
+		t.Errorf("invalidated %d entries, expected 2", dropped)
+	}
+	if dropped := c.Invalidate("a"); dropped != 0 {
+		t.Errorf("invalidated %d entries twice", dropped)
+	}
+	if dropped := c.Invalidate(CharacterTag(1)); dropped != 1 {
+		t.Errorf("invalidated %d character entries, expected 1", dropped)
+	}
+	if tags := c.store.(*memory).tags; len(tags) != 0 {
+		t.Errorf("tag index was not cleaned up: %+v", tags)
+	}
+
+	get(h, "/api/char?tag=a", "")
+	get(h, "/api/char?tag=b", "")
+	if dropped := c.Purge(); dropped != 2 {
+		t.Errorf("purged %d entries, expected 2", dropped)
+	}
+	if entries := c.Stats().Entries; entries != 0 {
+		t.Errorf("purge left %d entries", entries)
+	}
+}
+
+func TestCacheStoresGzipVariant(t *testing.T) {
+	c := New(10, time.Minute)
+	body := strings.Repeat(`{"donation":1}`, 200)
+	calls := 0
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}), 0)
+
+	for i := 0; i < 2; i++ {
+		w := get(h, "/api/char?c=1", "gzip")
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("gzip variant was not compressed")
+		}
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("invalid gzip body: %+v", err)
+		}
+		plain, err := ioutil.ReadAll(gz)
+		if err != nil || string(plain) != body {
+			t.Errorf("gzip body does not match: %+v", err)
+		}
+	}
+
+	w := get(h, "/api/char?c=1", "")
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+		t.Error("identity variant was compressed")
+	}
+	if calls != 2 {
+		t.Errorf("expected one call per variant, had %d", calls)
+	}
+}
@@ -0,0 +1,375 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// redisPrefix is prepended to every key stored in redis
+	redisPrefix = "esi-isk:"
+
+	// redisTimeout is how long a dial, or the commands sent at once, may take
+	redisTimeout = time.Second
+
+	// redisIdle is the most idle connections kept open
+	redisIdle = 8
+)
+
+// tagScript adds ARGV[1] to the tag set KEYS[1], extending the set's ttl to
+// ARGV[2] milliseconds if that is longer. PEXPIRE's NX and GT options would
+// do in two commands, but need redis 7
+const tagScript = `redis.call('SADD', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[2]) then
+    redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1`
+
+// redisError is an error reply from redis
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redis is a Store in redis, shared by every replica and kept over restarts.
+// Entries expire in redis with their ttl, and tags are sets of their keys.
+// Capacity is left to the redis maxmemory policy. Errors are logged and
+// treated as misses, so the site keeps working without the cache
+type redis struct {
+	addr, password string
+	idle           chan *redisConn
+}
+
+// redisConn is a connection speaking RESP
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewRedis returns a Store in the redis server at addr, using the password
+// if it is set. It returns an error if the server can't be reached
+func NewRedis(addr, password string) (Store, error) {
+	s := &redis{
+		addr:     addr,
+		password: password,
+		idle:     make(chan *redisConn, redisIdle),
+	}
+	if _, err := s.do([]string{"PING"}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func respKey(key string) string {
+	return redisPrefix + "resp:" + key
+}
+
+func tagKey(tag string) string {
+	return redisPrefix + "tag:" + tag
+}
+
+func (s *redis) Get(key string, now time.Time) *Entry {
+	replies, err := s.do([]string{"GET", respKey(key)})
+	if err != nil {
+		log.Printf("failed to read cached response: %+v", err)
+		return nil
+	}
+
+	raw, ok := replies[0].([]byte)
+	if !ok {
+		return nil
+	}
+
+	e := &Entry{}
+	if err := json.Unmarshal(raw, e); err != nil {
+		log.Printf("failed to unmarshal cached response: %+v", err)
+		return nil
+	}
+	if now.After(e.Expires) {
+		return nil
+	}
+	return e
+}
+
+func (s *redis) Set(e *Entry) {
+	ttl := time.Until(e.Expires).Milliseconds()
+	if ttl < 1 {
+		return
+	}
+
+	raw, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("failed to marshal cached response: %+v", err)
+		return
+	}
+
+	px := strconv.FormatInt(ttl, 10)
+	cmds := [][]string{{"SET", respKey(e.Key), string(raw), "PX", px}}
+	for _, tag := range e.Tags {
+		// tags last as long as the longest lived of their entries
+		cmds = append(
+			cmds,
+			[]string{"EVAL", tagScript, "1", tagKey(tag), e.Key, px},
+		)
+	}
+
+	if _, err := s.do(cmds...); err != nil {
+		log.Printf("failed to cache response: %+v", err)
+	}
+}
+
+func (s *redis) Invalidate(tags ...string) int {
+	dropped := 0
+	for _, tag := range tags {
+		replies, err := s.do([]string{"SMEMBERS", tagKey(tag)})
+		if err != nil {
+			log.Printf("failed to read cache tag %s: %+v", tag, err)
+			continue
+		}
+
+		del := []string{"DEL"}
+		members, _ := replies[0].([]interface{})
+		for _, member := range members {
+			if key, ok := member.([]byte); ok {
+				del = append(del, respKey(string(key)))
+			}
+		}
+
+		cmds := [][]string{{"DEL", tagKey(tag)}}
+		if len(del) > 1 {
+			cmds = append(cmds, del)
+		}
+		replies, err = s.do(cmds...)
+		if err != nil {
+			log.Printf("failed to invalidate cache tag %s: %+v", tag, err)
+			continue
+		}
+		if len(replies) > 1 {
+			n, _ := replies[1].(int64)
+			dropped += int(n)
+		}
+	}
+	return dropped
+}
+
+func (s *redis) Purge() int {
+	dropped := 0
+	if err := s.scan(redisPrefix+"*", func(keys []string) error {
+		if _, err := s.do(append([]string{"DEL"}, keys...)); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if strings.HasPrefix(key, respKey("")) {
+				dropped++
+			}
+		}
+		return nil
+	}); err != nil {
+		log.Printf("failed to purge cached responses: %+v", err)
+	}
+	return dropped
+}
+
+func (s *redis) Remove(keys ...string) {
+	del := []string{"DEL"}
+	for _, key := range keys {
+		del = append(del, respKey(key))
+	}
+	if _, err := s.do(del); err != nil {
+		log.Printf("failed to remove cached responses: %+v", err)
+	}
+}
+
+func (s *redis) Len() int {
+	entries := 0
+	if err := s.scan(respKey("*"), func(keys []string) error {
+		entries += len(keys)
+		return nil
+	}); err != nil {
+		log.Printf("failed to count cached responses: %+v", err)
+	}
+	return entries
+}
+
+// scan calls fn with each batch of keys matching the pattern
+func (s *redis) scan(match string, fn func([]string) error) error {
+	cursor := "0"
+	for {
+		replies, err := s.do(
+			[]string{"SCAN", cursor, "MATCH", match, "COUNT", "1000"},
+		)
+		if err != nil {
+			return err
+		}
+
+		reply, _ := replies[0].([]interface{})
+		if len(reply) != 2 {
+			return fmt.Errorf("unexpected scan reply %+v", replies[0])
+		}
+		next, _ := reply[0].([]byte)
+		members, _ := reply[1].([]interface{})
+
+		keys := []string{}
+		for _, member := range members {
+			if key, ok := member.([]byte); ok {
+				keys = append(keys, string(key))
+			}
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// do sends the commands at once and returns their replies. An error reply to
+// any of them is returned as the error
+func (s *redis) do(cmds ...[]string) ([]interface{}, error) {
+	c, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := c.do(cmds...)
+	if err != nil {
+		if _, ok := err.(redisError); !ok {
+			// the connection is in an unknown state
+			_ = c.conn.Close()
+			return nil, err
+		}
+	}
+
+	select {
+	case s.idle <- c:
+	default:
+		_ = c.conn.Close()
+	}
+	return replies, err
+}
+
+// conn returns an idle connection, or a new one
+func (s *redis) conn() (*redisConn, error) {
+	select {
+	case c := <-s.idle:
+		return c, nil
+	default:
+	}
+
+	conn, err := net.DialTimeout("tcp", s.addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	if s.password != "" {
+		if _, err := c.do([]string{"AUTH", s.password}); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) do(cmds ...[]string) ([]interface{}, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(c.conn)
+	for _, cmd := range cmds {
+		writeCommand(w, cmd)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	var replyErr error
+	replies := []interface{}{}
+	for range cmds {
+		reply, err := readReply(c.r)
+		if err != nil {
+			return nil, err
+		}
+		if e, ok := reply.(redisError); ok && replyErr == nil {
+			replyErr = e
+		}
+		replies = append(replies, reply)
+	}
+	return replies, replyErr
+}
+
+// writeCommand writes the command as a RESP array of bulk strings
+func writeCommand(w *bufio.Writer, cmd []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(cmd))
+	for _, arg := range cmd {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// readReply reads a RESP reply. Simple strings are strings, errors are
+// redisErrors, integers are int64s, bulk strings are []byte and arrays are
+// []interface{}. Null bulk strings and arrays are nil
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("invalid redis reply %q", line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return redisError(value), nil
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := readReply(r)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown redis reply %q", line)
+}
@@ -0,0 +1,289 @@
+package cache
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis serves the commands the redis store uses, from memory
+type fakeRedis struct {
+	lock     sync.Mutex
+	password string
+	strings  map[string]string
+	sets     map[string]map[string]bool
+
+	// ttls are the milliseconds the tag sets were last set to expire in
+	ttls map[string]int64
+}
+
+func startFakeRedis(t *testing.T, password string) (string, *fakeRedis) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %+v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	f := &fakeRedis{
+		password: password,
+		strings:  map[string]string{},
+		sets:     map[string]map[string]bool{},
+		ttls:     map[string]int64{},
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return listener.Addr().String(), f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	authed := f.password == ""
+
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		args := []string{}
+		for _, arg := range reply.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+
+		if args[0] == "AUTH" {
+			authed = args[1] == f.password
+		}
+		if !authed {
+			_, _ = w.WriteString("-NOAUTH Authentication required.\r\n")
+		} else {
+			_, _ = w.WriteString(f.exec(args))
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func bulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch args[0] {
+	case "PING":
+		return "+PONG\r\n"
+	case "AUTH", "SET":
+		if args[0] == "SET" {
+			f.strings[args[1]] = args[2]
+		}
+		return "+OK\r\n"
+	case "GET":
+		if value, found := f.strings[args[1]]; found {
+			return bulk(value)
+		}
+		return "$-1\r\n"
+	case "SADD":
+		if f.sets[args[1]] == nil {
+			f.sets[args[1]] = map[string]bool{}
+		}
+		f.sets[args[1]][args[2]] = true
+		return ":1\r\n"
+	case "EVAL":
+		// only the tag script is sent
+		if args[1] != tagScript || args[2] != "1" {
+			return "-ERR unknown script\r\n"
+		}
+		if f.sets[args[3]] == nil {
+			f.sets[args[3]] = map[string]bool{}
+		}
+		f.sets[args[3]][args[4]] = true
+		ttl, _ := strconv.ParseInt(args[5], 10, 64)
+		if ttl > f.ttls[args[3]] {
+			f.ttls[args[3]] = ttl
+		}
+		return ":1\r\n"
+	case "SMEMBERS":
+		reply := "*" + strconv.Itoa(len(f.sets[args[1]])) + "\r\n"
+		for member := range f.sets[args[1]] {
+			reply += bulk(member)
+		}
+		return reply
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, found := f.strings[key]; found {
+				deleted++
+			} else if _, found := f.sets[key]; found {
+				deleted++
+			}
+			delete(f.strings, key)
+			delete(f.sets, key)
+			delete(f.ttls, key)
+		}
+		return ":" + strconv.Itoa(deleted) + "\r\n"
+	case "SCAN":
+		keys := []string{}
+		for key := range f.strings {
+			keys = append(keys, key)
+		}
+		for key := range f.sets {
+			keys = append(keys, key)
+		}
+		reply := ""
+		matched := 0
+		for _, key := range keys {
+			if strings.HasPrefix(key, strings.TrimSuffix(args[3], "*")) {
+				reply += bulk(key)
+				matched++
+			}
+		}
+		return "*2\r\n" + bulk("0") + "*" + strconv.Itoa(matched) + "\r\n" + reply
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestReadReply(t *testing.T) {
+	for raw, expected := range map[string]interface{}{
+		"+OK\r\n":                 "OK",
+		"-ERR bad\r\n":            redisError("ERR bad"),
+		":42\r\n":                 int64(42),
+		"$5\r\nhello\r\n":         []byte("hello"),
+		"$0\r\n\r\n":              []byte{},
+		"$-1\r\n":                 nil,
+		"*-1\r\n":                 nil,
+		"*2\r\n$1\r\na\r\n:1\r\n": []interface{}{[]byte("a"), int64(1)},
+	} {
+		reply, err := readReply(bufio.NewReader(strings.NewReader(raw)))
+		if err != nil || !reflect.DeepEqual(reply, expected) {
+			t.Errorf("%q: received %#v (%v), expected %#v", raw, reply, err, expected)
+		}
+	}
+
+	for _, raw := range []string{"", "OK\r\n", "?1\r\n", "$5\r\nhi\r\n", ":x\r\n"} {
+		if _, err := readReply(bufio.NewReader(strings.NewReader(raw))); err == nil {
+			t.Errorf("%q: expected an error", raw)
+		}
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	addr, _ := startFakeRedis(t, "secret")
+	if _, err := NewRedis(addr, "wrong"); err == nil {
+		t.Error("expected a wrong password to fail")
+	}
+
+	s, err := NewRedis(addr, "secret")
+	if err != nil {
+		t.Fatalf("failed to connect: %+v", err)
+	}
+
+	now := time.Now()
+	e := &Entry{
+		Key:     "identity /api/char?c=1",
+		Status:  200,
+		Header:  http.Header{"Content-Type": {"application/json"}},
+		Body:    []byte(`{"id":1}`),
+		Tags:    []string{CharacterTag(1)},
+		Expires: now.Add(time.Minute),
+	}
+	s.Set(e)
+	s.Set(&Entry{Key: "identity /a", Expires: now.Add(time.Minute)})
+
+	received := s.Get(e.Key, now)
+	if received == nil || received.Status != e.Status ||
+		string(received.Body) != string(e.Body) ||
+		!reflect.DeepEqual(received.Header, e.Header) ||
+		!received.Expires.Equal(e.Expires) {
+		t.Errorf("received %+v, expected %+v", received, e)
+	}
+	if s.Get(e.Key, now.Add(2*time.Minute)) != nil {
+		t.Error("expired entry was returned")
+	}
+	if s.Len() != 2 {
+		t.Errorf("expected 2 entries, received %d", s.Len())
+	}
+
+	if dropped := s.Invalidate(CharacterTag(1)); dropped != 1 {
+		t.Errorf("invalidated %d entries, expected 1", dropped)
+	}
+	if s.Get(e.Key, now) != nil {
+		t.Error("invalidated entry was returned")
+	}
+
+	s.Remove("identity /a")
+	if s.Len() != 0 {
+		t.Errorf("remove left %d entries", s.Len())
+	}
+
+	s.Set(e)
+	s.Set(&Entry{Key: "identity /a", Expires: now.Add(time.Minute)})
+	if dropped := s.Purge(); dropped != 2 {
+		t.Errorf("purged %d entries, expected 2", dropped)
+	}
+}
+
+func TestRedisTagTTL(t *testing.T) {
+	addr, f := startFakeRedis(t, "")
+	s, err := NewRedis(addr, "")
+	if err != nil {
+		t.Fatalf("failed to connect: %+v", err)
+	}
+
+	// the tag lasts as long as the longest lived of its entries
+	now := time.Now()
+	tag := tagKey(TopTag)
+	for i, tc := range []struct {
+		ttl, min, max time.Duration
+	}{
+		{time.Minute, 50 * time.Second, time.Minute},
+		{10 * time.Second, 50 * time.Second, time.Minute},
+		{2 * time.Minute, 110 * time.Second, 2 * time.Minute},
+	} {
+		s.Set(&Entry{
+			Key:     "identity /api/top?" + strconv.Itoa(i),
+			Tags:    []string{TopTag},
+			Expires: now.Add(tc.ttl),
+		})
+		f.lock.Lock()
+		ttl := time.Duration(f.ttls[tag]) * time.Millisecond
+		members := len(f.sets[tag])
+		f.lock.Unlock()
+		if ttl < tc.min || ttl > tc.max {
+			t.Errorf("%d: expected a tag ttl within %s and %s, received %s",
+				i, tc.min, tc.max, ttl)
+		}
+		if members != i+1 {
+			t.Errorf("%d: expected %d tagged keys, received %d", i, i+1, members)
+		}
+	}
+}
+
+func TestNewRedisUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %+v", err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	if _, err := NewRedis(addr, ""); err == nil {
+		t.Error("expected an unreachable redis to fail")
+	}
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Store holds cached responses by key, and which keys have each tag
+type Store interface {
+	// Get returns the entry of the key, unless it is missing or expired
+	Get(key string, now time.Time) *Entry
+
+	// Set stores the entry, replacing any of the same key
+	Set(e *Entry)
+
+	// Invalidate drops the entries with any of the tags, returning how many
+	Invalidate(tags ...string) int
+
+	// Purge drops every entry, returning how many
+	Purge() int
+
+	// Remove drops the entries of the keys
+	Remove(keys ...string)
+
+	// Len is the number of entries stored
+	Len() int
+}
+
+// Entry is a cached response
+type Entry struct {
+	Key     string      `json:"key"`
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+	Body    []byte      `json:"body"`
+	Tags    []string    `json:"tags"`
+	Expires time.Time   `json:"expires"`
+}
+
+// memory is an in-memory LRU Store, which is lost on restart and is not
+// shared between replicas
+type memory struct {
+	lock     *sync.Mutex
+	entries  map[string]*list.Element
+	tags     map[string]map[string]bool // tag -> keys
+	order    *list.List
+	capacity int
+}
+
+// NewMemory returns a Store holding up to capacity entries in memory, the
+// least recently used are dropped first
+func NewMemory(capacity int) Store {
+	return &memory{
+		lock:     &sync.Mutex{},
+		entries:  map[string]*list.Element{},
+		tags:     map[string]map[string]bool{},
+		order:    list.New(),
+		capacity: capacity,
+	}
+}
+
+func (m *memory) Get(key string, now time.Time) *Entry {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	elem, found := m.entries[key]
+	if !found {
+		return nil
+	}
+
+	e := elem.Value.(*Entry)
+	if now.After(e.Expires) {
+		m.remove(elem)
+		return nil
+	}
+
+	m.order.MoveToFront(elem)
+	return e
+}
+
+func (m *memory) Set(e *Entry) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if elem, found := m.entries[e.Key]; found {
+		m.remove(elem)
+	}
+
+	m.entries[e.Key] = m.order.PushFront(e)
+	for _, tag := range e.Tags {
+		if m.tags[tag] == nil {
+			m.tags[tag] = map[string]bool{}
+		}
+		m.tags[tag][e.Key] = true
+	}
+
+	for m.capacity > 0 && m.order.Len() > m.capacity {
+		m.remove(m.order.Back())
+	}
+}
+
+// remove drops the element, the lock must be held
+func (m *memory) remove(elem *list.Element) {
+	e := elem.Value.(*Entry)
+	m.order.Remove(elem)
+	delete(m.entries, e.Key)
+
+	for _, tag := range e.Tags {
+		delete(m.tags[tag], e.Key)
+		if len(m.tags[tag]) == 0 {
+			delete(m.tags, tag)
+		}
+	}
+}
+
+func (m *memory) Invalidate(tags ...string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	dropped := 0
+	for _, tag := range tags {
+		for key := range m.tags[tag] {
+			if elem, found := m.entries[key]; found {
+				m.remove(elem)
+				dropped++
+			}
+		}
+	}
+	return dropped
+}
+
+func (m *memory) Purge() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	dropped := m.order.Len()
+	m.entries = map[string]*list.Element{}
+	m.tags = map[string]map[string]bool{}
+	m.order.Init()
+	return dropped
+}
+
+func (m *memory) Remove(keys ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, key := range keys {
+		if elem, found := m.entries[key]; found {
+			m.remove(elem)
+		}
+	}
+}
+
+func (m *memory) Len() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.order.Len()
+}
@@ -0,0 +1,211 @@
+// Package compress gzips responses worth compressing
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// MinSize is the smallest response body we compress
+const MinSize = 1024
+
+// skipped are content type prefixes which are already compressed, or are
+// streamed and must not be held back by a compressor
+var skipped = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/octet-stream",
+	"text/event-stream",
+}
+
+var writers = sync.Pool{New: func() interface{} {
+	return gzip.NewWriter(nil)
+}}
+
+// Accepted returns true if the request accepts gzip encoded responses
+func Accepted(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+}
+
+// Compressible returns true if a response body of the size with the headers
+// should be compressed
+func Compressible(header http.Header, size int) bool {
+	return size >= MinSize && compressibleType(header)
+}
+
+// compressibleType returns true if the headers are of a response we might
+// compress, if it is large enough
+func compressibleType(header http.Header) bool {
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	contentType := header.Get("Content-Type")
+	for _, prefix := range skipped {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// Gzip returns the body gzip compressed
+func Gzip(body []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	gz := writers.Get().(*gzip.Writer)
+	defer writers.Put(gz)
+
+	gz.Reset(buf)
+	if _, err := gz.Write(body); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SetEncoded marks the headers as those of a gzip encoded body
+func SetEncoded(header http.Header) {
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+}
+
+// Middleware compresses compressible responses for clients accepting gzip
+func Middleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if !Accepted(r) || r.Method == http.MethodHead {
+		next(w, r)
+		return
+	}
+
+	gw := &writer{ResponseWriter: w, status: http.StatusOK}
+	next(gw, r)
+
+	// not deferred, on panic nothing held back may be sent as a success
+	gw.close()
+}
+
+// writer holds back the start of the response until it knows whether it is
+// worth compressing
+type writer struct {
+	http.ResponseWriter
+
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *writer) WriteHeader(status int) {
+	if w.decided {
+		return
+	}
+	w.status = status
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < MinSize {
+			return len(b), nil
+		}
+		if err := w.start(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends what we have so far, streamed responses flush after each event
+func (w *writer) Flush() {
+	if !w.decided {
+		if err := w.start(); err != nil {
+			log.Printf("failed to flush response: %+v", err)
+			return
+		}
+	}
+
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			log.Printf("failed to flush compressed response: %+v", err)
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// start decides how to encode the response and writes out the buffer
+func (w *writer) start() error {
+	w.detectType()
+
+	// flushed responses are compressed no matter how little they've written
+	compress := compressibleType(w.Header())
+	w.decide(compress && w.status != http.StatusPartialContent)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// detectType sets the content type from the body, as net/http would
+func (w *writer) detectType() {
+	if w.Header().Get("Content-Type") == "" && len(w.buf) > 0 {
+		w.Header().Set("Content-Type", http.DetectContentType(w.buf))
+	}
+}
+
+func (w *writer) decide(compress bool) {
+	w.decided = true
+	if compress {
+		SetEncoded(w.Header())
+		w.gz = writers.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// close writes out small responses as they are, or finishes the compression
+func (w *writer) close() {
+	if !w.decided {
+		w.detectType()
+		w.decide(false)
+		if len(w.buf) > 0 {
+			if _, err := w.ResponseWriter.Write(w.buf); err != nil {
+				log.Printf("failed to write response: %+v", err)
+			}
+		}
+		return
+	}
+
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			log.Printf("failed to finish compressed response: %+v", err)
+		}
+		writers.Put(w.gz)
+	}
+}
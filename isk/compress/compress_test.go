@@ -0,0 +1,102 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serve(handler http.HandlerFunc, encoding string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/char?c=1", nil)
+	if encoding != "" {
+		r.Header.Set("Accept-Encoding", encoding)
+	}
+	w := httptest.NewRecorder()
+	Middleware(w, r, handler)
+	return w
+}
+
+func respond(contentType, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(201)
+		for _, part := range strings.SplitAfter(body, ",") {
+			_, _ = w.Write([]byte(part))
+		}
+	}
+}
+
+func gunzip(t *testing.T, w *httptest.ResponseRecorder) string {
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("invalid gzip body: %+v", err)
+	}
+	plain, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("failed to read gzip body: %+v", err)
+	}
+	return string(plain)
+}
+
+func TestMiddlewareCompresses(t *testing.T) {
+	body := strings.Repeat(`{"amount":1000000},`, 100)
+	w := serve(respond("application/json", body), "gzip, deflate")
+
+	if w.Code != 201 {
+		t.Errorf("status was not kept: %d", w.Code)
+	}
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("large JSON response was not compressed")
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("missing vary header: %q", w.Header().Get("Vary"))
+	}
+	if received := gunzip(t, w); received != body {
+		t.Errorf("decompressed body does not match")
+	}
+}
+
+func TestMiddlewareSkips(t *testing.T) {
+	large := strings.Repeat("a,", MinSize)
+
+	for name, c := range map[string]struct {
+		handler  http.HandlerFunc
+		encoding string
+	}{
+		"small":        {respond("application/json", `{"a":1}`), "gzip"},
+		"not accepted": {respond("application/json", large), ""},
+		"image":        {respond("image/png", large), "gzip"},
+		"event stream": {respond("text/event-stream", large), "gzip"},
+		"already encoded": {func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			_, _ = w.Write([]byte(large))
+		}, "gzip"},
+	} {
+		w := serve(c.handler, c.encoding)
+		if enc := w.Header().Get("Content-Encoding"); enc == "gzip" {
+			t.Errorf("%s: response was compressed", name)
+		}
+		if w.Body.Len() == 0 {
+			t.Errorf("%s: response body was lost", name)
+		}
+	}
+}
+
+func TestMiddlewareFlush(t *testing.T) {
+	w := serve(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"a":`))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte(`1}`))
+	}, "gzip")
+
+	if !w.Flushed {
+		t.Error("flush was not passed on")
+	}
+	if received := gunzip(t, w); received != `{"a":1}` {
+		t.Errorf("flushed body does not match: %q", received)
+	}
+}
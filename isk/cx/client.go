@@ -0,0 +1,43 @@
+package cx
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// userAgent sets our User-Agent on every request sent through it
+type userAgent struct {
+	agent string
+	next  http.RoundTripper
+}
+
+func (u *userAgent) RoundTrip(req *http.Request) (*http.Response, error) {
+	// round trippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", u.agent)
+	return u.next.RoundTrip(req)
+}
+
+// NewTransport returns a transport sending agent as the User-Agent of every
+// request over next, or the default transport if next is nil
+func NewTransport(agent string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &userAgent{agent: agent, next: next}
+}
+
+// NewClient returns an http.Client identifying us with the User-Agent of our
+// options. All requests to ESI and EVE SSO must use one of these
+func NewClient(
+	ctx context.Context,
+	next http.RoundTripper,
+	timeout time.Duration,
+) *http.Client {
+	opts := ctx.Value(Opts).(*Options)
+	return &http.Client{
+		Transport: NewTransport(opts.UserAgent, next),
+		Timeout:   timeout,
+	}
+}
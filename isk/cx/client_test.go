@@ -0,0 +1,46 @@
+package cx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewClient(t *testing.T) {
+	received := ""
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		received = r.Header.Get("User-Agent")
+	}))
+	defer server.Close()
+
+	ctx := context.WithValue(context.Background(), Opts, &Options{
+		UserAgent: "esi-isk/test (admin@example.com)",
+	})
+	client := NewClient(ctx, nil, time.Second)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %+v", err)
+	}
+	req.Header.Set("User-Agent", "Go-http-client/1.1")
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %+v", err)
+	}
+	if err := res.Body.Close(); err != nil {
+		t.Fatalf("failed to close response body: %+v", err)
+	}
+
+	if received != "esi-isk/test (admin@example.com)" {
+		t.Errorf("unexpected User-Agent %q", received)
+	}
+	if req.Header.Get("User-Agent") != "Go-http-client/1.1" {
+		t.Error("transport modified the caller's request")
+	}
+}
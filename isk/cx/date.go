@@ -0,0 +1,38 @@
+package cx
+
+import (
+	"fmt"
+	"time"
+)
+
+// dateLayout is the format dates are set in
+const dateLayout = "2006-01-02"
+
+// Date is a day, such as "2027-06-30", midnight UTC of it once set. The zero
+// Date is unset
+type Date struct {
+	time.Time
+}
+
+// String formats the date as it is set
+func (d *Date) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format(dateLayout)
+}
+
+// Set parses a date of "YYYY-MM-DD", an empty value unsets it
+func (d *Date) Set(value string) error {
+	if value == "" {
+		*d = Date{}
+		return nil
+	}
+
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+	}
+	d.Time = t
+	return nil
+}
@@ -0,0 +1,29 @@
+package cx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDateSet(t *testing.T) {
+	d := &Date{}
+	if err := d.Set("2027-06-30"); err != nil {
+		t.Fatalf("failed to set: %+v", err)
+	}
+	if !d.Equal(time.Date(2027, 6, 30, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected date %s", d.Time)
+	}
+	if d.String() != "2027-06-30" {
+		t.Errorf("unexpected string %q", d.String())
+	}
+
+	if err := d.Set(""); err != nil || !d.IsZero() || d.String() != "" {
+		t.Errorf("expected an unset date, received %q %+v", d, err)
+	}
+
+	for _, value := range []string{"2027-6-30", "30/06/2027", "2027-13-01"} {
+		if err := (&Date{}).Set(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
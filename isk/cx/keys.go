@@ -9,32 +9,36 @@ const (
 	// Opts is our global server runtime (*cx.Options)
 	Opts = Key("Opts")
 
-	// Provider holds the oidc provider
-	Provider = Key("Provider")
-
-	// Verifier verifies JWTs
+	// Verifier verifies SSO access token JWTs (*api.JWKS)
 	Verifier = Key("Verifier")
 
-	// DB is our pg connection (*sqlx.DB)
+	// SSOClient is the http.Client used for EVE SSO requests
+	SSOClient = Key("SSOClient")
+
+	// Store is our pg connection and prepared statements (*db.PgStore)
+	Store = Key("Store")
+
+	// DB is our pg connection (*sqlx.DB), replaced by Store
 	DB = Key("DB")
 
 	// Cache is our httpCache object
 	Cache = Key("Cache")
 
-	// Adapter is our http-cache adapter
-	Adapter = Key("Adapter")
+	// ResponseCache is our cache of API responses (*cache.Cache)
+	ResponseCache = Key("ResponseCache")
 
 	// Prices is our in-memory cache of market prices
 	Prices = Key("Prices")
 
-	// Statements is our map of prepared statements (map[Key]sqlx.Stmt)
+	// Statements is our map of prepared statements (map[Key]sqlx.Stmt),
+	// replaced by Store
 	Statements = Key("Statements")
 
-	// StateStore is our in-memory auth state store (*api.StateStore)
-	StateStore = Key("StateStore")
+	// Replica is the read only replica of the db, if any (*db.Replica)
+	Replica = Key("Replica")
 
-	// SSOClient is XXX HACK REMOVE ME
-	SSOClient = Key("SSOClient")
+	// ReadReplica is set on contexts of reads which may go to the replica
+	ReadReplica = Key("ReadReplica")
 
 	// Client is the goesi client
 	Client = Key("Client")
@@ -45,6 +49,26 @@ const (
 	// Authenticator is the global goesi SSO authenticator
 	Authenticator = Key("Authenticator")
 
+	// Breaker is the circuit breaker of worker requests to ESI and SSO
+	Breaker = Key("Breaker")
+
+	// Updates wakes requests waiting on character updates (*pubsub.Broker)
+	Updates = Key("Updates")
+
+	// Views counts character page views until they are flushed (*api.Views)
+	Views = Key("Views")
+
+	// Warmer fills the response cache with the most viewed pages (*api.Warmer)
+	Warmer = Key("Warmer")
+
+	/* -- Request Keys -- */
+
+	// Character is the logged in character ID of a request (int32)
+	Character = Key("Character")
+
+	// RequestID identifies a request in responses and logs (string)
+	RequestID = Key("RequestID")
+
 	/* -- API Statements -- */
 
 	// StmtTopReceived pulls the top character_id and receiver totals
@@ -71,24 +95,30 @@ const (
 	// StmtContractItems pulls the items for a contract
 	StmtContractItems = Key("StmtContractItems")
 
-	// StmtUserChars pulls the character ids for a userID
-	StmtUserChars = Key("StmtUserChars")
-
 	// StmtCreateUser creates a new user with a paired character
 	StmtCreateUser = Key("StmtCreateUser")
 
 	// StmtGetUser pulls a user by ID
 	StmtGetUser = Key("StmtGetUser")
 
-	// StmtGetUsers pulls users needing an update (up to 100)
-	StmtGetUsers = Key("StmtGetUsers")
+	// StmtClaimUsers claims unclaimed users due their next poll (up to 100)
+	StmtClaimUsers = Key("StmtClaimUsers")
 
-	// StmtGetNullUsers pulls users with null last processed timestamps
-	StmtGetNullUsers = Key("StmtGetNullUsers")
+	// StmtClaimNullUsers claims unclaimed users never processed (up to 100)
+	StmtClaimNullUsers = Key("StmtClaimNullUsers")
+
+	// StmtGetAllUsers pulls every user
+	StmtGetAllUsers = Key("StmtGetAllUsers")
+
+	// StmtSetRefreshToken replaces a user's stored refresh token
+	StmtSetRefreshToken = Key("StmtSetRefreshToken")
 
 	// StmtUpdateUser updates a user's character (auth updates)
 	StmtUpdateUser = Key("StmtUpdateUser")
 
+	// StmtRevokeUser flags a user's refresh token as revoked
+	StmtRevokeUser = Key("StmtRevokeUser")
+
 	// StmtDeleteUser deletes a user
 	StmtDeleteUser = Key("StmtDeleteUser")
 
@@ -116,9 +146,6 @@ const (
 	// StmtAddContractItems creates a new contract item row
 	StmtAddContractItems = Key("StmtAddContractItems")
 
-	// StmtCharStandingISK queries donations towards standings
-	StmtCharStandingISK = Key("StmtCharStandingISK")
-
 	// StmtCreatePreferences creates a new preferences row for the user
 	StmtCreatePreferences = Key("StmtCreatePreferences")
 
@@ -131,19 +158,21 @@ const (
 	// StmtSetContractPreferences updates the contract preferences for the user
 	StmtSetContractPreferences = Key("StmtSetContractPreferences")
 
-	// StmtGetOutstandingContracts retrieves the outstanding contracts for a user
-	StmtGetOutstandingContracts = Key("StmtGetOutstandingContracts")
+	// StmtGetTrackedContracts retrieves contracts which may still change status
+	StmtGetTrackedContracts = Key("StmtGetTrackedContracts")
 
-	// StmtAcceptContract updates a contract status to accepted
-	StmtAcceptContract = Key("StmtAcceptContract")
+	// StmtSetContractStatus updates a contract status and accepted flag
+	StmtSetContractStatus = Key("StmtSetContractStatus")
 
 	// StmtSetCombinedPreferences updates the combined preferences
 	StmtSetCombinedPreferences = Key("StmtSetCombinedPreferences")
 
-	// StmtGetStaleContracts returns contracts older than 30 days
+	// StmtGetStaleContracts returns accepted contracts older than :days
+	// which are still in the totals of that window
 	StmtGetStaleContracts = Key("StmtGetStaleContracts")
 
-	// StmtGetStaleDonations returns donations older than 30 days
+	// StmtGetStaleDonations returns donations older than :days which are
+	// still in the totals of that window, archived ones included
 	StmtGetStaleDonations = Key("StmtGetStaleDonations")
 
 	// StmtRemoveContract removes a contract by ID
@@ -152,6 +181,385 @@ const (
 	// StmtRemoveContractItems removes contract items by ID
 	StmtRemoveContractItems = Key("StmtRemoveContractItems")
 
-	// StmtRemoveDonation removes a donation by ID
-	StmtRemoveDonation = Key("StmtRemoveDonation")
+	// StmtArchiveDonations moves a batch of donations older than :days, and
+	// out of the 30 day totals, to the archive
+	StmtArchiveDonations = Key("StmtArchiveDonations")
+
+	// StmtRecalculateTotals recomputes rolling totals from stored rows
+	StmtRecalculateTotals = Key("StmtRecalculateTotals")
+
+	// StmtSaveOwner links a character to its SSO owner hash
+	StmtSaveOwner = Key("StmtSaveOwner")
+
+	// StmtSameOwner counts owner links shared between two characters
+	StmtSameOwner = Key("StmtSameOwner")
+
+	// StmtDeleteOwner removes the owner link of a character
+	StmtDeleteOwner = Key("StmtDeleteOwner")
+
+	// StmtClearOwnerPreferences clears preferences private to the owner
+	StmtClearOwnerPreferences = Key("StmtClearOwnerPreferences")
+
+	// StmtDeletePreferences removes the preferences of a character
+	StmtDeletePreferences = Key("StmtDeletePreferences")
+
+	// StmtAnonymizeDonations zeroes the donator of donations from a character
+	StmtAnonymizeDonations = Key("StmtAnonymizeDonations")
+
+	// StmtAnonymizeContracts zeroes the donator of contracts from a character
+	StmtAnonymizeContracts = Key("StmtAnonymizeContracts")
+
+	// StmtDeleteName removes the name of an ID
+	StmtDeleteName = Key("StmtDeleteName")
+
+	// StmtPurgeDonations removes all donations to or from a character
+	StmtPurgeDonations = Key("StmtPurgeDonations")
+
+	// StmtPurgeContractItems removes the items of all contracts of a character
+	StmtPurgeContractItems = Key("StmtPurgeContractItems")
+
+	// StmtPurgeContracts removes all contracts to or from a character
+	StmtPurgeContracts = Key("StmtPurgeContracts")
+
+	// StmtDeleteCharacter removes a character row
+	StmtDeleteCharacter = Key("StmtDeleteCharacter")
+
+	// StmtWriteAudit records an audit log entry
+	StmtWriteAudit = Key("StmtWriteAudit")
+
+	// StmtNotifyCharacters notifies listeners of updated characters
+	StmtNotifyCharacters = Key("StmtNotifyCharacters")
+
+	// StmtNotifyNewCharacters notifies listeners of new characters
+	StmtNotifyNewCharacters = Key("StmtNotifyNewCharacters")
+
+	// StmtReceivedSeries sums ISK received per time bucket
+	StmtReceivedSeries = Key("StmtReceivedSeries")
+
+	// StmtDonationHistogram counts donations to and from a character by size
+	StmtDonationHistogram = Key("StmtDonationHistogram")
+
+	// StmtSupporters ranks the donators of a character by ISK given
+	StmtSupporters = Key("StmtSupporters")
+
+	// StmtTransfersBetween lists donations and contracts between two characters
+	StmtTransfersBetween = Key("StmtTransfersBetween")
+
+	// StmtTransferTotals sums the transfers each way between two characters
+	StmtTransferTotals = Key("StmtTransferTotals")
+
+	// StmtUpdateRanks ranks every character by 30 day ISK received and donated
+	StmtUpdateRanks = Key("StmtUpdateRanks")
+
+	// StmtSnapshotLeaderboards saves the top characters of a month, once
+	StmtSnapshotLeaderboards = Key("StmtSnapshotLeaderboards")
+
+	// StmtLeaderboardHistory returns the snapshot of a month
+	StmtLeaderboardHistory = Key("StmtLeaderboardHistory")
+
+	// StmtSetPrivacy sets the character wide privacy preferences
+	StmtSetPrivacy = Key("StmtSetPrivacy")
+
+	// StmtSetHidden sets if the character is hidden from public listings
+	StmtSetHidden = Key("StmtSetHidden")
+
+	// StmtRetireSlug starts the redirect period of the character's slug
+	StmtRetireSlug = Key("StmtRetireSlug")
+
+	// StmtClaimSlug claims a free, expired or previously owned slug
+	StmtClaimSlug = Key("StmtClaimSlug")
+
+	// StmtResolveSlug returns the character of a current or redirecting slug
+	StmtResolveSlug = Key("StmtResolveSlug")
+
+	// StmtCurrentSlug returns the character's current slug
+	StmtCurrentSlug = Key("StmtCurrentSlug")
+
+	// StmtDeleteSlugs removes all slugs of the character
+	StmtDeleteSlugs = Key("StmtDeleteSlugs")
+
+	// StmtPollSucceeded records a successful poll of the character
+	StmtPollSucceeded = Key("StmtPollSucceeded")
+
+	// StmtPollFailed records a failed poll of the character
+	StmtPollFailed = Key("StmtPollFailed")
+
+	// StmtSchedulePoll sets the interval until the character's next poll
+	StmtSchedulePoll = Key("StmtSchedulePoll")
+
+	// StmtBumpPoll moves the character back to the base poll interval
+	StmtBumpPoll = Key("StmtBumpPoll")
+
+	// StmtRequestRefresh adds a refresh job, or returns the active one
+	StmtRequestRefresh = Key("StmtRequestRefresh")
+
+	// StmtLatestRefresh pulls the most recent refresh job of a character
+	StmtLatestRefresh = Key("StmtLatestRefresh")
+
+	// StmtGetRefresh pulls a refresh job of a character
+	StmtGetRefresh = Key("StmtGetRefresh")
+
+	// StmtClaimRefreshes marks pending refresh jobs as running (up to 10)
+	StmtClaimRefreshes = Key("StmtClaimRefreshes")
+
+	// StmtFinishRefresh records the result of a refresh job
+	StmtFinishRefresh = Key("StmtFinishRefresh")
+
+	// StmtPruneRefreshes removes old refresh jobs and fails abandoned ones
+	StmtPruneRefreshes = Key("StmtPruneRefreshes")
+
+	// StmtDeleteRefreshes removes all refresh jobs of the character
+	StmtDeleteRefreshes = Key("StmtDeleteRefreshes")
+
+	// StmtClaimUser claims a user if no one else has
+	StmtClaimUser = Key("StmtClaimUser")
+
+	// StmtRequeueRefresh returns a running refresh job to pending
+	StmtRequeueRefresh = Key("StmtRequeueRefresh")
+
+	// StmtAddNewDonation adds a donation unless it is already stored
+	StmtAddNewDonation = Key("StmtAddNewDonation")
+
+	// StmtAddNewContract adds a contract unless it is already stored
+	StmtAddNewContract = Key("StmtAddNewContract")
+
+	// StmtMarkBackfilled records the character's history as backfilled
+	StmtMarkBackfilled = Key("StmtMarkBackfilled")
+
+	// StmtVoidDonation flags a donation as void, returning it
+	StmtVoidDonation = Key("StmtVoidDonation")
+
+	// StmtAddAdjustment adds a manual donation, returning it
+	StmtAddAdjustment = Key("StmtAddAdjustment")
+
+	// StmtCheckTotals pulls stored totals alongside those of the stored rows
+	StmtCheckTotals = Key("StmtCheckTotals")
+
+	// StmtRepairTotals replaces the totals of a character
+	StmtRepairTotals = Key("StmtRepairTotals")
+
+	// StmtAddAffiliation records the current affiliation of a character
+	StmtAddAffiliation = Key("StmtAddAffiliation")
+
+	// StmtAffiliations pulls the affiliation history of a character
+	StmtAffiliations = Key("StmtAffiliations")
+
+	// StmtDeleteAffiliations removes the affiliation history of a character
+	StmtDeleteAffiliations = Key("StmtDeleteAffiliations")
+
+	// StmtUpdateStandings sets good standing of a character, or every one,
+	// returning those which changed
+	StmtUpdateStandings = Key("StmtUpdateStandings")
+
+	// StmtStandingSources pulls the contacts giving a character good standing,
+	// one per standings source
+	StmtStandingSources = Key("StmtStandingSources")
+
+	// StmtDeleteContacts removes the stored contacts of a standings source
+	StmtDeleteContacts = Key("StmtDeleteContacts")
+
+	// StmtAddContact stores a contact of a standings source
+	StmtAddContact = Key("StmtAddContact")
+
+	// StmtReleaseUser releases the claim on a user
+	StmtReleaseUser = Key("StmtReleaseUser")
+
+	// StmtMarkDeleted flags a character as no longer known to ESI
+	StmtMarkDeleted = Key("StmtMarkDeleted")
+
+	// StmtDonationsPage pulls a page of donations to and/or from a character,
+	// after a cursor
+	StmtDonationsPage = Key("StmtDonationsPage")
+
+	// StmtContractsPage pulls a page of contracts to and/or from a character,
+	// after a cursor
+	StmtContractsPage = Key("StmtContractsPage")
+
+	// StmtDonationsSince pulls the donations to a character after an ID
+	StmtDonationsSince = Key("StmtDonationsSince")
+
+	// StmtDonation pulls a donation by its journal reference ID
+	StmtDonation = Key("StmtDonation")
+
+	// StmtContract pulls a contract by its ID
+	StmtContract = Key("StmtContract")
+
+	// StmtProveDonated sums the donations from one character to another after
+	// a time
+	StmtProveDonated = Key("StmtProveDonated")
+
+	// StmtAddViews adds to the page views of a known character
+	StmtAddViews = Key("StmtAddViews")
+
+	// StmtMostViewed pulls the visible characters with the most page views
+	StmtMostViewed = Key("StmtMostViewed")
+
+	// StmtDeleteViews removes the page views of a character
+	StmtDeleteViews = Key("StmtDeleteViews")
+
+	// StmtAddHourViews adds to the page views of a known character in an hour
+	StmtAddHourViews = Key("StmtAddHourViews")
+
+	// StmtPruneHourViews removes the hourly page views before a time
+	StmtPruneHourViews = Key("StmtPruneHourViews")
+
+	// StmtTrending pulls the visible characters with the most page views
+	// since a time
+	StmtTrending = Key("StmtTrending")
+
+	// StmtDeleteHourViews removes the hourly page views of a character
+	StmtDeleteHourViews = Key("StmtDeleteHourViews")
+
+	// StmtSitemap pulls a page of the visible characters with their current
+	// slug and last activity
+	StmtSitemap = Key("StmtSitemap")
+
+	// StmtCountSitemap counts the visible characters
+	StmtCountSitemap = Key("StmtCountSitemap")
+
+	// StmtAuditLog pulls a page of the audit log, optionally of one action and
+	// target
+	StmtAuditLog = Key("StmtAuditLog")
+
+	// StmtTrackedCharacters pulls a page of the characters with a stored
+	// token, most recently active first
+	StmtTrackedCharacters = Key("StmtTrackedCharacters")
+
+	// StmtTrackedCharactersAsc pulls a page of the characters with a stored
+	// token, least recently active first
+	StmtTrackedCharactersAsc = Key("StmtTrackedCharactersAsc")
+
+	// StmtSetBanned sets if the character is banned
+	StmtSetBanned = Key("StmtSetBanned")
+
+	// StmtDetectRings stores the characters sending each other roughly the
+	// same ISK
+	StmtDetectRings = Key("StmtDetectRings")
+
+	// StmtDonationRings pulls a page of the donation rings, newest first
+	StmtDonationRings = Key("StmtDonationRings")
+
+	// StmtCountPendingRings counts the donation rings pending review
+	StmtCountPendingRings = Key("StmtCountPendingRings")
+
+	// StmtReviewRing marks a donation ring reviewed
+	StmtReviewRing = Key("StmtReviewRing")
+
+	// StmtAddRefund stores a refund, unless it already is
+	StmtAddRefund = Key("StmtAddRefund")
+
+	// StmtRefundDonation voids the donation a refund is of
+	StmtRefundDonation = Key("StmtRefundDonation")
+
+	// StmtPendingRefund pulls the refund waiting for a donation
+	StmtPendingRefund = Key("StmtPendingRefund")
+
+	// StmtMarkRefunded voids a donation refunded before it was seen
+	StmtMarkRefunded = Key("StmtMarkRefunded")
+
+	// StmtLinkRefund records the donation a refund is of
+	StmtLinkRefund = Key("StmtLinkRefund")
+
+	// StmtAgeContract marks an accepted contract out of the totals of the
+	// :days window
+	StmtAgeContract = Key("StmtAgeContract")
+
+	// StmtExpireContracts marks outstanding contracts past their expiry
+	StmtExpireContracts = Key("StmtExpireContracts")
+
+	// StmtGetTerminalContracts returns unaccepted contracts which ended more
+	// than 90 days ago
+	StmtGetTerminalContracts = Key("StmtGetTerminalContracts")
+
+	// StmtPendingContracts returns the outstanding contracts of a recipient
+	StmtPendingContracts = Key("StmtPendingContracts")
+
+	// StmtClassifyContract sets the notable items summary of a contract
+	StmtClassifyContract = Key("StmtClassifyContract")
+
+	// StmtNotableContracts pages the biggest notable item contracts
+	StmtNotableContracts = Key("StmtNotableContracts")
+
+	// StmtSaveLocation stores the name of a station or structure
+	StmtSaveLocation = Key("StmtSaveLocation")
+
+	// StmtNameContracts sets the location name of the contracts at it
+	StmtNameContracts = Key("StmtNameContracts")
+
+	// StmtAnonymizeArchive zeroes the donator of archived donations from a
+	// character
+	StmtAnonymizeArchive = Key("StmtAnonymizeArchive")
+
+	// StmtPurgeArchive removes all archived donations to or from a character
+	StmtPurgeArchive = Key("StmtPurgeArchive")
+
+	// StmtArchivedSeries sums ISK received per time bucket, including the
+	// archived donations
+	StmtArchivedSeries = Key("StmtArchivedSeries")
+
+	// StmtAgeDonation marks a donation, stored or archived, out of the
+	// totals of the :days window, returning the rows marked
+	StmtAgeDonation = Key("StmtAgeDonation")
+
+	// StmtRecalculateLargest sets the largest donation and contract received
+	// by every character from the stored rows, or only by :character_id
+	StmtRecalculateLargest = Key("StmtRecalculateLargest")
+
+	// StmtRecordLargestDonation sets a donation as the largest of its
+	// receiver, if it is bigger than the one recorded
+	StmtRecordLargestDonation = Key("StmtRecordLargestDonation")
+
+	// StmtRecordLargestContract sets an accepted contract as the largest of
+	// its receiver, if it is bigger than the one recorded
+	StmtRecordLargestContract = Key("StmtRecordLargestContract")
+
+	// StmtAnonymizeLargest zeroes the donator of the largest donations and
+	// contracts from a character
+	StmtAnonymizeLargest = Key("StmtAnonymizeLargest")
+
+	// StmtRecalculateStreaks sets the streaks of days with a donation received
+	// of every character from the stored rows, or only of :character_id
+	StmtRecalculateStreaks = Key("StmtRecalculateStreaks")
+
+	// StmtExpireStreaks zeroes the current streaks which ended before
+	// yesterday
+	StmtExpireStreaks = Key("StmtExpireStreaks")
+
+	// StmtUpdateDonorStats computes the average donation and donor counts of
+	// every character
+	StmtUpdateDonorStats = Key("StmtUpdateDonorStats")
+
+	// StmtGenerousCorporations pages the corporations by the ISK their
+	// members donated over the :window
+	StmtGenerousCorporations = Key("StmtGenerousCorporations")
+
+	// StmtGenerousAlliances pages the alliances by the ISK their members
+	// donated over the :window
+	StmtGenerousAlliances = Key("StmtGenerousAlliances")
+
+	// StmtSetDonationAffiliations stores the affiliations of the donor and
+	// recipient on a donation, keeping any stored
+	StmtSetDonationAffiliations = Key("StmtSetDonationAffiliations")
+
+	// StmtDonorCorporations sums the donations to a character by the
+	// corporation of the donator
+	StmtDonorCorporations = Key("StmtDonorCorporations")
+
+	// StmtHideRemoved hides a character removed without purging from the
+	// leaderboards and lookups
+	StmtHideRemoved = Key("StmtHideRemoved")
+
+	// StmtNameRemoved names a character removed without purging RemovedName
+	StmtNameRemoved = Key("StmtNameRemoved")
+
+	// StmtAgeStaleRows marks every row older than :days aged out of the
+	// window, without changing any totals
+	StmtAgeStaleRows = Key("StmtAgeStaleRows")
+
+	// StmtNotifyTestDonation sends a test donation to the API servers
+	StmtNotifyTestDonation = Key("StmtNotifyTestDonation")
+
+	// StmtCounterparties pulls the characters a character sent to or
+	// received from
+	StmtCounterparties = Key("StmtCounterparties")
 )
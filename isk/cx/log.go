@@ -0,0 +1,33 @@
+package cx
+
+import (
+	"context"
+	"log"
+)
+
+// Logf logs the message, prefixed with the request ID of ctx if it has one
+func Logf(ctx context.Context, format string, v ...interface{}) {
+	if id, ok := ctx.Value(RequestID).(string); ok && id != "" {
+		format = "[" + id + "] " + format
+	}
+	log.Printf(format, v...)
+}
+
+// valuesContext is a context falling back to another for unset values
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c *valuesContext) Value(key interface{}) interface{} {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	return c.values.Value(key)
+}
+
+// WithValues returns ctx, with any value it does not have read from values.
+// Handlers use this to combine request contexts with our global values
+func WithValues(ctx, values context.Context) context.Context {
+	return &valuesContext{Context: ctx, values: values}
+}
@@ -4,20 +4,51 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
+	"strconv"
+	"strings"
 
 	"golang.org/x/oauth2"
+
+	"github.com/a-tal/esi-isk/isk/buildinfo"
+	"github.com/a-tal/esi-isk/isk/tokens"
 )
 
 // Options describes all runtime options for the API
 type Options struct {
-	Production, Debug, HTTPS                bool
+	Production, Debug, HTTPS, CountSelf     bool
+	TrustedProxy, RepairTotals, WarmCache   bool
+	ExcludeRings, ExcludeBadStanding        bool
 	Port, CacheTime, CacheResp, MaxPrefRows int
+	SessionLifetime, TopCacheTime           int
+	SitemapCacheTime                        int
+	RateLimit, OwnerRateLimit, RateBurst    int
+	VerifyRateLimit                         int
+	ReadHeaderTimeout, ReadTimeout          int
+	WriteTimeout, IdleTimeout               int
+	StreamTimeout, MaxHeaderBytes           int
+	LongPollWait, LongPollWaiters           int
+	HistorySize, WarmPages                  int
+	WorkerConcurrency, WorkerTimeout        int
+	PollInterval, MaxPollInterval           int
+	StandingsInterval, ArchiveAfter         int
+	StandingThreshold                       float64
+	RingThreshold, RingTolerance            float64
+	DowntimeMargin                          int
+	BreakerFailures, BreakerBackoff         int
+	SlowQuery                               int
+	Downtime                                Window
+	V1Deprecation, V1Sunset                 Date
 	CharacterID, MaxPrefLen, MaxPatternLen  int32
-	Hostname, ESI, AppSecret                string
+	Hostname, ESI, AppSecret, UserAgent     string
+	Listen, RedirectListen, MetricsListen   string
+	TLSCert, TLSKey, ImageServer            string
+	CacheBackend, RedisAddr, RedisPassword  string
+	TokenKey                                []byte
+	CharacterIDs                            CharacterIDs
 	DB                                      *DBOptions
 	Auth                                    *oauth2.Config
 }
@@ -25,6 +56,114 @@ type Options struct {
 // DBOptions describes our database connection
 type DBOptions struct {
 	Host, User, Password, Name, Mode string
+
+	// Replica is the connection string of a read only replica, if any
+	Replica string
+}
+
+// charactersEnv lists the standings characters when -character is not given
+const charactersEnv = "ESI_ISK_CHARACTER"
+
+// DefaultAppSecret is the -app-secret of deployments which never set one.
+// Admin routes are refused while it is used, as anyone could send it, and
+// the server only starts with it in -debug
+const DefaultAppSecret = "not-secure"
+
+// CharacterIDs are the standings characters, the first is the owner which
+// donations count towards good standing with
+type CharacterIDs []int32
+
+// String joins the IDs with commas
+func (c *CharacterIDs) String() string {
+	ids := []string{}
+	for _, id := range *c {
+		ids = append(ids, strconv.Itoa(int(id)))
+	}
+	return strings.Join(ids, ",")
+}
+
+// Set replaces the IDs with those of the comma separated list
+func (c *CharacterIDs) Set(value string) error {
+	ids := CharacterIDs{}
+	for _, raw := range strings.Split(value, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 32)
+		if err != nil || id < 1 {
+			return fmt.Errorf("invalid character ID: %q", raw)
+		}
+		if !ids.contains(int32(id)) {
+			ids = append(ids, int32(id))
+		}
+	}
+	*c = ids
+	return nil
+}
+
+func (c CharacterIDs) contains(id int32) bool {
+	for _, i := range c {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Flags are groups of command line flags, each command registers those of
+// the options it uses
+type Flags int
+
+const (
+	// FlagsDB connect to the database and encrypt stored tokens
+	FlagsDB Flags = 1 << iota
+	// FlagsESI reach ESI and EVE SSO
+	FlagsESI
+	// FlagsServer configure the HTTP server
+	FlagsServer
+	// FlagsWorker configure polling and maintenance
+	FlagsWorker
+
+	// FlagsAll are every group of flags
+	FlagsAll = FlagsDB | FlagsESI | FlagsServer | FlagsWorker
+)
+
+// flagGroup registers flags on fs if the group is on, otherwise the flag
+// keeps its default value
+type flagGroup struct {
+	fs *flag.FlagSet
+	on bool
+}
+
+func (g flagGroup) Int(name string, value int, usage string) *int {
+	if g.on {
+		return g.fs.Int(name, value, usage)
+	}
+	return &value
+}
+
+func (g flagGroup) String(name, value, usage string) *string {
+	if g.on {
+		return g.fs.String(name, value, usage)
+	}
+	return &value
+}
+
+func (g flagGroup) Float64(name string, value float64, usage string) *float64 {
+	if g.on {
+		return g.fs.Float64(name, value, usage)
+	}
+	return &value
+}
+
+func (g flagGroup) Var(value flag.Value, name, usage string) {
+	if g.on {
+		g.fs.Var(value, name, usage)
+	}
+}
+
+func (g flagGroup) Bool(name string, value bool, usage string) *bool {
+	if g.on {
+		return g.fs.Bool(name, value, usage)
+	}
+	return &value
 }
 
 func readAuthConf(ctx context.Context, filePath string) *oauth2.Config {
@@ -46,75 +185,421 @@ func readAuthConf(ctx context.Context, filePath string) *oauth2.Config {
 		return nil
 	}
 
-	// HACK: remove once ccpgames/sso-issues#41 is done
-	// provider := ctx.Value(Provider).(*oidc.Provider)
-	// conf.Endpoint = provider.Endpoint()
-
 	return conf
 }
 
 // NewOptions returns a new Options struct from cmd line flags
 func NewOptions(ctx context.Context) context.Context {
-	port := flag.Int("port", 8080, "backend port number")
-	user := flag.String("db-user", "esi-isk", "db user name")
-	host := flag.String("db-host", "postgres", "db host name")
-	passwd := flag.String("db-passwd", "default", "db user password")
-	name := flag.String("db-name", "esi-isk", "db name")
-	sslmode := flag.String("ssl-mode", "disable", "db ssl mode option")
-	debug := flag.Bool("debug", false, "enable debug mode")
-	hostname := flag.String("hostname", "localhost", "hostname exposed as")
-	https := flag.Bool("https", false, "should be addressed via https")
-	production := flag.Bool("production", false, "if this is being run in prod")
-	authConf := flag.String("auth", "/secret/sso.json", "path to auth config")
-	esi := flag.String("esi", "https://esi.evetech.net", "basepath for ESI")
-	characterID := flag.Int("character", 2114454465, "standings char ID")
-	cacheTime := flag.Int("cache-time", 300, "seconds to cache responses for")
-	cacheResp := flag.Int("cache-resp", 10000, "number of responses to cache")
-	appSecret := flag.String("app-secret", "not-secure", "app secret to use")
-	maxPrefLen := flag.Int("max-pref", 1500, "max length header/footer strings")
-	maxPatternLen := flag.Int("max-pattern", 500, "max length row pattern string")
-	maxPrefRows := flag.Int("max-rows", 100, "max number of rows to allow")
-
+	options := RegisterOptions(flag.CommandLine, FlagsAll)
 	flag.Parse()
+	return options(ctx)
+}
 
-	// HACK: remove once ccpgames/sso-issues#41 is done
-	// provider := ctx.Value(Provider).(*oidc.Provider)
-
-	opts := &Options{
-		Production:  *production,
-		Debug:       *debug,
-		HTTPS:       *https,
-		Hostname:    *hostname,
-		Port:        *port,
-		CharacterID: int32(*characterID),
-		CacheTime:   *cacheTime,
-		CacheResp:   *cacheResp,
-		ESI:         *esi,
-		DB: &DBOptions{
-			Host:     *host,
-			User:     *user,
-			Password: *passwd,
-			Name:     *name,
-			Mode:     *sslmode,
-		},
-		Auth:          readAuthConf(ctx, *authConf),
-		AppSecret:     *appSecret,
-		MaxPrefLen:    int32(*maxPrefLen),
-		MaxPatternLen: int32(*maxPatternLen),
-		MaxPrefRows:   *maxPrefRows,
+// RegisterOptions registers the flags of the groups on fs, flags of other
+// groups keep their default. Once fs is parsed, the returned function adds
+// the Options to a context
+func RegisterOptions(
+	fs *flag.FlagSet,
+	groups Flags,
+) func(context.Context) context.Context {
+	common := flagGroup{fs: fs, on: true}
+	dbFlags := flagGroup{fs: fs, on: groups&FlagsDB != 0}
+	esiFlags := flagGroup{fs: fs, on: groups&FlagsESI != 0}
+	server := flagGroup{fs: fs, on: groups&FlagsServer != 0}
+	workerFlags := flagGroup{fs: fs, on: groups&FlagsWorker != 0}
+
+	port := server.Int("port", 8080, "port exposed as, for generated URLs")
+	listen := server.String("listen", ":8080", "address to serve on")
+	tlsCert := server.String("tls-cert", "", "TLS certificate to serve HTTPS with")
+	tlsKey := server.String("tls-key", "", "TLS key to serve HTTPS with")
+	redirectListen := server.String(
+		"redirect-listen",
+		"",
+		"address to redirect plaintext requests to HTTPS from, with -tls-cert",
+	)
+	user := dbFlags.String("db-user", "esi-isk", "db user name")
+	host := dbFlags.String("db-host", "postgres", "db host name")
+	passwd := dbFlags.String("db-passwd", "default", "db user password")
+	name := dbFlags.String("db-name", "esi-isk", "db name")
+	sslmode := dbFlags.String("ssl-mode", "disable", "db ssl mode option")
+	replica := dbFlags.String(
+		"db-replica",
+		"",
+		"connection string of a read only replica for the leaderboards and pages",
+	)
+	debug := common.Bool("debug", false, "enable debug mode")
+	hostname := common.String("hostname", "localhost", "hostname exposed as")
+	https := server.Bool(
+		"https",
+		false,
+		"should be addressed via https, for generated URLs and cookies",
+	)
+	production := common.Bool("production", false, "if this is being run in prod")
+	authConf := esiFlags.String("auth", "/secret/sso.json", "path to auth config")
+	esi := esiFlags.String("esi", "https://esi.evetech.net", "basepath for ESI")
+	imageServer := server.String(
+		"image-server",
+		"https://images.evetech.net",
+		"EVE image server base for portrait and logo URLs",
+	)
+	v1Deprecation := &Date{}
+	server.Var(
+		v1Deprecation,
+		"v1-deprecation",
+		"date the v1 API was deprecated, as YYYY-MM-DD, sent on v1 responses",
+	)
+	v1Sunset := &Date{}
+	server.Var(
+		v1Sunset,
+		"v1-sunset",
+		"date the v1 API will be removed, as YYYY-MM-DD, sent on v1 responses",
+	)
+	characterIDs := &CharacterIDs{2114454465}
+	if env := os.Getenv(charactersEnv); env != "" {
+		if err := characterIDs.Set(env); err != nil {
+			log.Fatalf("invalid %s: %+v", charactersEnv, err)
+		}
+	}
+	esiFlags.Var(
+		characterIDs,
+		"character",
+		"comma separated standings char IDs, the first owns the site, or $"+
+			charactersEnv,
+	)
+	cacheTime := server.Int("cache-time", 300, "seconds to cache responses for")
+	topCacheTime := server.Int(
+		"top-cache-time",
+		900,
+		"seconds to cache the top recipients and donators for",
+	)
+	sitemapCacheTime := server.Int(
+		"sitemap-cache-time",
+		86400,
+		"seconds to cache the sitemap for, it is refreshed for new characters",
+	)
+	cacheResp := server.Int("cache-resp", 10000, "number of responses to cache")
+	warmCache := server.Bool(
+		"warm-cache",
+		true,
+		"cache the leaderboards and most viewed characters before being ready",
+	)
+	warmPages := server.Int(
+		"warm-pages",
+		100,
+		"most viewed characters to cache, with -warm-cache",
+	)
+	cacheBackend := server.String(
+		"cache-backend",
+		"memory",
+		"where to cache responses, memory or redis to share between replicas",
+	)
+	redisAddr := server.String(
+		"redis-addr",
+		"redis:6379",
+		"redis address, with -cache-backend redis",
+	)
+	redisPassword := server.String(
+		"redis-password",
+		"",
+		"redis password, if it requires one",
+	)
+	appSecret := dbFlags.String(
+		"app-secret",
+		DefaultAppSecret,
+		"app secret to use, required to serve outside -debug",
+	)
+	tokenKey := dbFlags.String(
+		"token-key",
+		"",
+		"secret to encrypt refresh tokens with, defaults to the app secret",
+	)
+	maxPrefLen := server.Int("max-pref", 1500, "max length header/footer strings")
+	maxPatternLen := server.Int(
+		"max-pattern",
+		500,
+		"max length row pattern string",
+	)
+	maxPrefRows := server.Int("max-rows", 100, "max number of rows to allow")
+	sessionLifetime := server.Int(
+		"session-lifetime",
+		604800,
+		"seconds a login session lasts for",
+	)
+	trustedProxy := server.Bool(
+		"trusted-proxy",
+		false,
+		"trust X-Forwarded-For, only when behind a proxy setting it",
+	)
+	rateLimit := server.Int("rate-limit", 60, "api requests per minute per IP")
+	ownerRateLimit := server.Int(
+		"owner-rate-limit",
+		240,
+		"api requests per minute for logged in characters",
+	)
+	rateBurst := server.Int("rate-burst", 20, "api requests allowed in a burst")
+	verifyRateLimit := server.Int(
+		"verify-rate-limit",
+		10,
+		"donation verifications per minute per IP, and allowed in a burst",
+	)
+	readHeaderTimeout := server.Int(
+		"read-header-timeout",
+		1,
+		"seconds allowed to read request headers",
+	)
+	readTimeout := server.Int(
+		"read-timeout",
+		1,
+		"seconds allowed to read requests",
+	)
+	writeTimeout := server.Int(
+		"write-timeout",
+		5,
+		"seconds allowed to write responses",
+	)
+	idleTimeout := server.Int(
+		"idle-timeout",
+		60,
+		"seconds to keep idle connections open for",
+	)
+	streamTimeout := server.Int(
+		"stream-timeout",
+		600,
+		"seconds allowed to write streamed responses, such as data exports",
+	)
+	longPollWait := server.Int(
+		"long-poll-wait",
+		30,
+		"most seconds a long poll of new donations is held for",
+	)
+	longPollWaiters := server.Int(
+		"long-poll-waiters",
+		20,
+		"long polls allowed to wait on each character at once",
+	)
+	maxHeaderBytes := server.Int(
+		"max-header-bytes",
+		1<<16,
+		"largest request headers to accept, in bytes",
+	)
+	historySize := workerFlags.Int(
+		"history-size",
+		10,
+		"characters to keep on each monthly leaderboard snapshot",
+	)
+	workerConcurrency := workerFlags.Int(
+		"worker-concurrency",
+		4,
+		"characters the worker polls at once",
+	)
+	workerTimeout := workerFlags.Int(
+		"worker-timeout",
+		120,
+		"seconds allowed to poll each character",
+	)
+	pollInterval := workerFlags.Int(
+		"poll-interval",
+		3600,
+		"seconds between polls of characters recently donated to",
+	)
+	maxPollInterval := workerFlags.Int(
+		"max-poll-interval",
+		21600,
+		"seconds quiet characters back off to between polls",
+	)
+	standingsInterval := workerFlags.Int(
+		"standings-interval",
+		3600,
+		"seconds between syncs of the standings char's contacts, 0 disables",
+	)
+	downtime := &Window{}
+	if err := downtime.Set("11:00-11:15"); err != nil {
+		log.Fatalf("invalid default downtime: %+v", err)
 	}
+	workerFlags.Var(
+		downtime,
+		"downtime",
+		"daily ESI downtime as HH:MM-HH:MM UTC, polling pauses during it",
+	)
+	downtimeMargin := workerFlags.Int(
+		"downtime-margin",
+		120,
+		"seconds before the ESI downtime to pause polling",
+	)
+	breakerFailures := workerFlags.Int(
+		"breaker-failures",
+		10,
+		"consecutive ESI failures pausing requests to it, 0 disables",
+	)
+	breakerBackoff := workerFlags.Int(
+		"breaker-backoff",
+		60,
+		"seconds to pause ESI requests for before probing it again",
+	)
+	archiveAfter := workerFlags.Int(
+		"archive-after",
+		30,
+		"days old donations are moved to the archive at, at least 30",
+	)
+	repairTotals := workerFlags.Bool(
+		"repair-totals",
+		false,
+		"correct inconsistent totals found by the nightly check",
+	)
+	ringThreshold := workerFlags.Float64(
+		"ring-threshold",
+		1e9,
+		"ISK each character of a donation ring sent the next in 30 days",
+	)
+	ringTolerance := workerFlags.Float64(
+		"ring-tolerance",
+		0.2,
+		"most the ISK sent around a donation ring may differ, as a fraction",
+	)
+	metricsListen := workerFlags.String(
+		"metrics-listen",
+		"",
+		"address for the worker to serve /metrics on, disabled if unset",
+	)
+	countSelf := dbFlags.Bool(
+		"count-self-donations",
+		false,
+		"include donations between characters of one account in totals",
+	)
+	excludeRings := dbFlags.Bool(
+		"exclude-rings",
+		false,
+		"leave donation rings pending review out of the leaderboards",
+	)
+	excludeBadStanding := dbFlags.Bool(
+		"exclude-bad-standing",
+		false,
+		"leave characters in bad standing out of trending and notable contracts",
+	)
+	standingThreshold := dbFlags.Float64(
+		"standing-threshold",
+		5,
+		"contact standing of the standings char giving good standing",
+	)
+	slowQuery := dbFlags.Int(
+		"slow-query",
+		500,
+		"milliseconds a statement may take before it is logged, 0 never logs",
+	)
+
+	userAgent := common.String(
+		"user-agent",
+		"",
+		"User-Agent sent to ESI and SSO, composed from -hostname and -contact if unset",
+	)
+	contact := common.String(
+		"contact",
+		"",
+		"maintainer contact for CCP, such as an email or EVE character name",
+	)
+	version := common.Bool("version", false, "print the build info and exit")
+
+	return func(ctx context.Context) context.Context {
+		if *version {
+			fmt.Println(buildinfo.Get())
+			os.Exit(0)
+		}
+
+		if *userAgent == "" {
+			*userAgent = buildinfo.UserAgent(*hostname, *contact)
+		}
+
+		if *tokenKey == "" {
+			tokenKey = appSecret
+		}
 
-	// HACK: remove once ccpgames/sso-issues#41 is done
-	// ctx = context.WithValue(
-	// 	ctx,
-	// 	Verifier,
-	// 	provider.Verifier(&oidc.Config{ClientID: opts.Auth.ClientID}),
-	// )
+		var auth *oauth2.Config
+		if esiFlags.on {
+			auth = readAuthConf(ctx, *authConf)
+		}
 
-	ctx = context.WithValue(ctx, Opts, opts)
+		opts := &Options{
+			Production:  *production,
+			Debug:       *debug,
+			HTTPS:       *https,
+			Hostname:    *hostname,
+			Port:        *port,
+			CharacterID: (*characterIDs)[0],
+			CacheTime:   *cacheTime,
+			CacheResp:   *cacheResp,
+			ESI:         *esi,
 
-	// HACK TEMPORARY UNTIL ccpgames/sso-issues#41
-	ctx = context.WithValue(ctx, SSOClient, &http.Client{})
+			CacheBackend:  *cacheBackend,
+			RedisAddr:     *redisAddr,
+			RedisPassword: *redisPassword,
+			WarmCache:     *warmCache,
+			WarmPages:     *warmPages,
 
-	return ctx
+			DB: &DBOptions{
+				Host:     *host,
+				User:     *user,
+				Password: *passwd,
+				Name:     *name,
+				Mode:     *sslmode,
+				Replica:  *replica,
+			},
+			Auth:          auth,
+			AppSecret:     *appSecret,
+			TokenKey:      tokens.Key(*tokenKey),
+			MaxPrefLen:    int32(*maxPrefLen),
+			MaxPatternLen: int32(*maxPatternLen),
+			MaxPrefRows:   *maxPrefRows,
+			CountSelf:     *countSelf,
+			ExcludeRings:  *excludeRings,
+
+			ExcludeBadStanding: *excludeBadStanding,
+			StandingThreshold:  *standingThreshold,
+			CharacterIDs:       *characterIDs,
+			SlowQuery:          *slowQuery,
+
+			SessionLifetime:  *sessionLifetime,
+			TopCacheTime:     *topCacheTime,
+			SitemapCacheTime: *sitemapCacheTime,
+			TrustedProxy:     *trustedProxy,
+			RateLimit:        *rateLimit,
+			OwnerRateLimit:   *ownerRateLimit,
+			RateBurst:        *rateBurst,
+			VerifyRateLimit:  *verifyRateLimit,
+
+			ReadHeaderTimeout: *readHeaderTimeout,
+			ReadTimeout:       *readTimeout,
+			WriteTimeout:      *writeTimeout,
+			IdleTimeout:       *idleTimeout,
+			StreamTimeout:     *streamTimeout,
+			LongPollWait:      *longPollWait,
+			LongPollWaiters:   *longPollWaiters,
+			MaxHeaderBytes:    *maxHeaderBytes,
+			HistorySize:       *historySize,
+			WorkerConcurrency: *workerConcurrency,
+			WorkerTimeout:     *workerTimeout,
+			PollInterval:      *pollInterval,
+			MaxPollInterval:   *maxPollInterval,
+			StandingsInterval: *standingsInterval,
+			Downtime:          *downtime,
+			DowntimeMargin:    *downtimeMargin,
+			BreakerFailures:   *breakerFailures,
+			BreakerBackoff:    *breakerBackoff,
+			ArchiveAfter:      *archiveAfter,
+			RepairTotals:      *repairTotals,
+			RingThreshold:     *ringThreshold,
+			RingTolerance:     *ringTolerance,
+			MetricsListen:     *metricsListen,
+
+			Listen:         *listen,
+			RedirectListen: *redirectListen,
+			TLSCert:        *tlsCert,
+			TLSKey:         *tlsKey,
+			ImageServer:    strings.TrimRight(*imageServer, "/"),
+			V1Deprecation:  *v1Deprecation,
+			V1Sunset:       *v1Sunset,
+			UserAgent:      *userAgent,
+		}
+
+		return context.WithValue(ctx, Opts, opts)
+	}
 }
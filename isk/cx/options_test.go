@@ -0,0 +1,124 @@
+package cx
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/tokens"
+)
+
+func TestRegisterOptions(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	options := RegisterOptions(fs, FlagsDB)
+
+	for name, registered := range map[string]bool{
+		"db-host":            true,
+		"token-key":          true,
+		"standing-threshold": true,
+		"slow-query":         true,
+		"standings-interval": false,
+		"debug":              true,
+		"listen":             false,
+		"esi":                false,
+		"poll-interval":      false,
+	} {
+		if received := fs.Lookup(name) != nil; received != registered {
+			t.Errorf("%s: registered %t, expected %t", name, received, registered)
+		}
+	}
+
+	if err := fs.Parse([]string{"-db-host=db", "-app-secret=s"}); err != nil {
+		t.Fatalf("failed to parse: %+v", err)
+	}
+
+	opts := options(context.Background()).Value(Opts).(*Options)
+	if opts.DB.Host != "db" {
+		t.Errorf("unexpected db host %q", opts.DB.Host)
+	}
+	if !bytes.Equal(opts.TokenKey, tokens.Key("s")) {
+		t.Error("token key was not derived from the app secret")
+	}
+	if opts.Listen != ":8080" || opts.PollInterval != 3600 {
+		t.Errorf(
+			"unregistered flags lost their defaults: %q %d",
+			opts.Listen,
+			opts.PollInterval,
+		)
+	}
+	if opts.StandingThreshold != 5 || opts.StandingsInterval != 3600 {
+		t.Errorf(
+			"unexpected standings options: %v %d",
+			opts.StandingThreshold,
+			opts.StandingsInterval,
+		)
+	}
+	if opts.SlowQuery != 500 {
+		t.Errorf("unexpected slow query threshold %d", opts.SlowQuery)
+	}
+	if opts.Downtime.String() != "11:00-11:15" {
+		t.Errorf("unexpected default downtime %q", opts.Downtime.String())
+	}
+	if opts.Auth != nil {
+		t.Error("auth config was read without the ESI flags")
+	}
+}
+
+func TestCharacterIDs(t *testing.T) {
+	ids := &CharacterIDs{}
+	if err := ids.Set("3, 1,3,2"); err != nil {
+		t.Fatalf("failed to set: %+v", err)
+	}
+	if ids.String() != "3,1,2" {
+		t.Errorf("unexpected IDs %s", ids)
+	}
+
+	for _, value := range []string{"", "1,", "0", "-1", "a,1"} {
+		if err := (&CharacterIDs{}).Set(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestCharacterIDsOption(t *testing.T) {
+	for _, tc := range []struct {
+		env, flag string
+		expected  CharacterIDs
+	}{
+		{"", "", CharacterIDs{2114454465}},
+		{"1,2", "", CharacterIDs{1, 2}},
+		{"1,2", "3", CharacterIDs{3}},
+	} {
+		t.Setenv(charactersEnv, tc.env)
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		options := RegisterOptions(fs, FlagsESI)
+		args := []string{}
+		if tc.flag != "" {
+			args = append(args, "-character="+tc.flag)
+		}
+		if err := fs.Parse(args); err != nil {
+			t.Fatalf("failed to parse: %+v", err)
+		}
+
+		opts := options(context.Background()).Value(Opts).(*Options)
+		if opts.CharacterIDs.String() != tc.expected.String() ||
+			opts.CharacterID != tc.expected[0] {
+			t.Errorf(
+				"%q %q: received %v %d",
+				tc.env,
+				tc.flag,
+				opts.CharacterIDs,
+				opts.CharacterID,
+			)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	RegisterOptions(fs, FlagsESI)
+	if err := fs.Parse([]string{"-character=1,x"}); err == nil {
+		t.Error("expected an invalid character list to fail parsing")
+	}
+}
@@ -0,0 +1,85 @@
+package cx
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// day is the length of the daily windows
+const day = 24 * time.Hour
+
+// Window is a daily window of UTC time, such as "11:00-11:15". Windows may
+// span midnight, a window starting and ending at the same time is disabled
+type Window struct {
+	// Start and End are offsets from midnight UTC
+	Start, End time.Duration
+}
+
+// String formats the window as it is set
+func (w *Window) String() string {
+	if w.Start == w.End {
+		return ""
+	}
+	return clock(w.Start) + "-" + clock(w.End)
+}
+
+// Set parses a window of "HH:MM-HH:MM", an empty value disables it
+func (w *Window) Set(value string) error {
+	if value == "" {
+		*w = Window{}
+		return nil
+	}
+
+	bounds := strings.Split(value, "-")
+	if len(bounds) != 2 {
+		return fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", value)
+	}
+
+	parsed := [2]time.Duration{}
+	for i, bound := range bounds {
+		t, err := time.Parse("15:04", strings.TrimSpace(bound))
+		if err != nil {
+			return fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", value)
+		}
+		parsed[i] = time.Duration(t.Hour())*time.Hour +
+			time.Duration(t.Minute())*time.Minute
+	}
+
+	w.Start, w.End = parsed[0], parsed[1]
+	return nil
+}
+
+// Remaining returns how long is left of the window at t, 0 outside of it
+func (w Window) Remaining(t time.Time) time.Duration {
+	if w.Start == w.End {
+		return 0
+	}
+
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	offset := t.Sub(midnight)
+
+	// the window started today, or yesterday if it spans midnight
+	length := (w.End - w.Start + day) % day
+	for _, start := range []time.Duration{w.Start, w.Start - day} {
+		if offset >= start && offset < start+length {
+			return start + length - offset
+		}
+	}
+	return 0
+}
+
+// Widen returns the window starting earlier by before
+func (w Window) Widen(before time.Duration) Window {
+	if w.Start == w.End {
+		return w
+	}
+	w.Start = ((w.Start-before)%day + day) % day
+	return w
+}
+
+// clock formats the offset from midnight as HH:MM
+func clock(offset time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(offset.Hours()), int(offset.Minutes())%60)
+}
@@ -0,0 +1,78 @@
+package cx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowSet(t *testing.T) {
+	w := &Window{}
+	if err := w.Set("11:00-11:15"); err != nil {
+		t.Fatalf("failed to set: %+v", err)
+	}
+	if w.Start != 11*time.Hour || w.End != 11*time.Hour+15*time.Minute {
+		t.Errorf("unexpected window %+v", w)
+	}
+	if w.String() != "11:00-11:15" {
+		t.Errorf("unexpected string %q", w.String())
+	}
+
+	if err := w.Set(""); err != nil || w.String() != "" {
+		t.Errorf("expected a disabled window, received %q %+v", w, err)
+	}
+
+	for _, value := range []string{"11:00", "11-12", "25:00-11:00", "a-b"} {
+		if err := (&Window{}).Set(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestWindowRemaining(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2019, 1, 20, hour, min, 0, 0, time.UTC)
+	}
+
+	for _, c := range []struct {
+		window   string
+		t        time.Time
+		expected time.Duration
+	}{
+		{"11:00-11:15", at(10, 59), 0},
+		{"11:00-11:15", at(11, 0), 15 * time.Minute},
+		{"11:00-11:15", at(11, 10), 5 * time.Minute},
+		{"11:00-11:15", at(11, 15), 0},
+		{"23:50-00:10", at(23, 55), 15 * time.Minute},
+		{"23:50-00:10", at(0, 5), 5 * time.Minute},
+		{"23:50-00:10", at(0, 10), 0},
+		{"", at(11, 5), 0},
+	} {
+		w := &Window{}
+		if err := w.Set(c.window); err != nil {
+			t.Fatalf("failed to set %q: %+v", c.window, err)
+		}
+		if received := w.Remaining(c.t); received != c.expected {
+			t.Errorf(
+				"%s at %s: expected %s, received %s",
+				c.window,
+				c.t.Format("15:04"),
+				c.expected,
+				received,
+			)
+		}
+	}
+}
+
+func TestWindowWiden(t *testing.T) {
+	w := &Window{}
+	if err := w.Set("00:01-00:15"); err != nil {
+		t.Fatalf("failed to set: %+v", err)
+	}
+	widened := w.Widen(2 * time.Minute)
+	if widened.String() != "23:59-00:15" {
+		t.Errorf("unexpected widened window %q", widened.String())
+	}
+	if disabled := (Window{}).Widen(time.Minute); disabled.String() != "" {
+		t.Errorf("widened a disabled window to %q", disabled.String())
+	}
+}
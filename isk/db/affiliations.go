@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// AffiliationChange is a corporation and alliance the character was seen in,
+// from ObservedAt until the next change
+type AffiliationChange struct {
+	// CorporationID the character was in
+	CorporationID int32 `db:"corporation_id" json:"corporation"`
+
+	// CorporationName is the last checked name of the corporation
+	CorporationName string `db:"corporation_name" json:"corporation_name,omitempty"`
+
+	// AllianceID the character was in, 0 for none
+	AllianceID int32 `db:"alliance_id" json:"alliance,omitempty"`
+
+	// AllianceName is the last checked name of the alliance
+	AllianceName string `db:"alliance_name" json:"alliance_name,omitempty"`
+
+	// ObservedAt is when the character was first seen with this affiliation
+	ObservedAt time.Time `db:"observed_at" json:"observed_at"`
+}
+
+// GetAffiliations returns the affiliation history of the character, latest
+// first
+func GetAffiliations(
+	ctx context.Context,
+	charID int32,
+) ([]*AffiliationChange, error) {
+	rows, err := queryNamedResult(ctx, cx.StmtAffiliations, map[string]interface{}{
+		"character_id": charID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &AffiliationChange{} })
+	if err != nil {
+		return nil, err
+	}
+	changes := []*AffiliationChange{}
+	for _, i := range res {
+		change := i.(*AffiliationChange)
+		change.ObservedAt = change.ObservedAt.UTC()
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// affiliationChanged is true if the row's affiliation differs from the one
+// stored before, of corp and alliance
+func affiliationChanged(row *CharacterRow, corp, alliance int32) bool {
+	return row.CorporationID != corp || row.AllianceID != alliance
+}
+
+// addAffiliation records the row's affiliation as observed now
+func addAffiliation(ctx context.Context, row *CharacterRow) error {
+	return executeNamed(ctx, cx.StmtAddAffiliation, map[string]interface{}{
+		"character_id":   row.ID,
+		"corporation_id": row.CorporationID,
+		"alliance_id":    row.AllianceID,
+	})
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAffiliationChanged(t *testing.T) {
+	row := &CharacterRow{ID: 1, CorporationID: 10, AllianceID: 20}
+	for _, tc := range []struct {
+		corp, alliance int32
+		changed        bool
+	}{
+		{10, 20, false},
+		{11, 20, true},
+		{10, 0, true},
+		{0, 0, true},
+	} {
+		changed := affiliationChanged(row, tc.corp, tc.alliance)
+		if changed != tc.changed {
+			t.Errorf(
+				"%d/%d: changed %t, expected %t",
+				tc.corp,
+				tc.alliance,
+				changed,
+				tc.changed,
+			)
+		}
+	}
+}
+
+func TestAffiliationsJSON(t *testing.T) {
+	raw, err := json.Marshal(&CharDetails{
+		Character: &Character{ID: 1},
+		Affiliations: []*AffiliationChange{
+			{
+				CorporationID:   10,
+				CorporationName: "Some Corporation",
+				ObservedAt:      time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal details: %+v", err)
+	}
+
+	expected := `"affiliations":[{"corporation":10,` +
+		`"corporation_name":"Some Corporation","observed_at":"2019-01-02T03:04:05Z"}]`
+	if !strings.Contains(string(raw), expected) {
+		t.Errorf("expected %s in %s", expected, raw)
+	}
+}
+
+func TestSetAffiliationLeavesAlliance(t *testing.T) {
+	row := &CharacterRow{ID: 1}
+	corp := &Name{ID: 10, Name: "Some Corporation"}
+
+	for i, step := range []struct {
+		alliance *Name
+		expected int32
+	}{
+		{&Name{ID: 20, Name: "Some Alliance"}, 20},
+		{nil, 0},
+		{&Name{ID: 30, Name: "New Alliance"}, 30},
+	} {
+		alliance := row.AllianceID
+		setAffiliation(row, &Affiliation{
+			Character:   &Name{ID: 1, Name: "Some Pilot"},
+			Corporation: corp,
+			Alliance:    step.alliance,
+		})
+		if row.AllianceID != step.expected {
+			t.Errorf(
+				"save %d: alliance %d, expected %d",
+				i,
+				row.AllianceID,
+				step.expected,
+			)
+		}
+		if !affiliationChanged(row, corp.ID, alliance) {
+			t.Errorf("save %d: alliance change was not seen", i)
+		}
+	}
+}
+
+func TestSetAffiliationKeepsDeleted(t *testing.T) {
+	row := &CharacterRow{ID: 1, CorporationID: 10, AllianceID: 20}
+	setAffiliation(row, &Affiliation{
+		Character: &Name{ID: 1, Name: "Some Pilot"},
+		Deleted:   true,
+	})
+	if row.CorporationID != 10 || row.AllianceID != 20 {
+		t.Errorf(
+			"deleted character moved to %d %d",
+			row.CorporationID,
+			row.AllianceID,
+		)
+	}
+}
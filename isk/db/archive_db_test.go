@@ -0,0 +1,409 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// countArchived returns the number of archived donations
+func countArchived(t *testing.T, ctx context.Context) int {
+	count := 0
+	db := storeFrom(ctx).DB
+	if err := db.Get(&count, "SELECT COUNT(*) FROM donations_archive"); err != nil {
+		t.Fatalf("failed to count archived donations: %+v", err)
+	}
+	return count
+}
+
+// archivedDonation stores a donation from character 2 to 1 two months ago,
+// and archives it
+func archivedDonation(t *testing.T, ctx context.Context) *Donation {
+	donation := testDonation(1, 0, 1000)
+	donation.Timestamp = time.Now().UTC().Add(-60 * 24 * time.Hour)
+	countDonations(t, ctx, donation)
+	if err := AgeDonations(
+		ctx,
+		[]*Donation{donation},
+		testAffiliations(t, ctx),
+		WindowMonth,
+	); err != nil {
+		t.Fatalf("failed to age donation: %+v", err)
+	}
+	if archived, err := ArchiveDonations(ctx, MinArchiveAge); err != nil ||
+		archived != 1 {
+		t.Fatalf("expected 1 donation archived, received %d: %+v", archived, err)
+	}
+	return donation
+}
+
+// seriesCount returns the donations and contracts in the series since
+func seriesCount(t *testing.T, ctx context.Context, since time.Time) int64 {
+	points, err := ReceivedSeries(ctx, 1, since, BucketMonth)
+	if err != nil {
+		t.Fatalf("failed to get series: %+v", err)
+	}
+	count := int64(0)
+	for _, point := range points {
+		count += point.Count
+	}
+	return count
+}
+
+func TestArchiveDonationsDB(t *testing.T) {
+	ctx := testDB(t)
+
+	now := time.Now().UTC()
+	old := testDonation(1, 0, 1000)
+	old.Timestamp = now.Add(-60 * 24 * time.Hour)
+	recent := testDonation(2, 0, 500)
+	recent.Timestamp = now.Add(-24 * time.Hour)
+	countDonations(t, ctx, old, recent)
+
+	stale, err := GetStaleDonations(ctx, WindowMonth)
+	if err != nil {
+		t.Fatalf("failed to get stale donations: %+v", err)
+	}
+	if len(stale) != 1 || stale[0].ID != old.ID {
+		t.Fatalf("expected the old donation stale, received %+v", stale)
+	}
+	// aging twice changes nothing
+	for i := 0; i < 2; i++ {
+		if err := AgeDonations(
+			ctx,
+			stale,
+			testAffiliations(t, ctx),
+			WindowMonth,
+		); err != nil {
+			t.Fatalf("failed to age donation: %+v", err)
+		}
+	}
+
+	// kept for the configured days, and never younger than the 30 day totals
+	for _, c := range []struct {
+		days     int
+		expected int64
+	}{{90, 0}, {7, 1}, {30, 0}} {
+		archived, err := ArchiveDonations(ctx, c.days)
+		if err != nil {
+			t.Fatalf("failed to archive donations: %+v", err)
+		}
+		if archived != c.expected {
+			t.Errorf(
+				"%d days: expected %d archived, received %d",
+				c.days,
+				c.expected,
+				archived,
+			)
+		}
+	}
+
+	if count := countArchived(t, ctx); count != 1 {
+		t.Errorf("expected 1 archived donation, received %d", count)
+	}
+
+	// the archived donation is still in the 90 day totals
+	if err := RecalculateTotals(ctx); err != nil {
+		t.Fatalf("failed to recalculate totals: %+v", err)
+	}
+	recipient := getTestCharacter(t, ctx, 1)
+	if recipient.Received != 2 || recipient.Received7 != 1 ||
+		recipient.Received30 != 1 || recipient.Received90 != 2 ||
+		recipient.ReceivedISK90 != NewISK(1500) {
+		t.Errorf("unexpected recipient totals %+v", recipient)
+	}
+	for _, window := range Windows {
+		if stale, err := GetStaleDonations(ctx, window); err != nil ||
+			len(stale) != 0 {
+			t.Errorf("%s: expected none stale, received %+v: %+v", window, stale, err)
+		}
+	}
+
+	// deep ranges read the archive
+	if count := seriesCount(t, ctx, now.Add(-90*24*time.Hour)); count != 2 {
+		t.Errorf("expected 2 donations in 90 days, received %d", count)
+	}
+	if count := seriesCount(t, ctx, now.Add(-7*24*time.Hour)); count != 1 {
+		t.Errorf("expected 1 donation in 7 days, received %d", count)
+	}
+
+	if err := PurgeCharacter(ctx, 2, false); err != nil {
+		t.Fatalf("failed to purge character: %+v", err)
+	}
+	if count := countArchived(t, ctx); count != 0 {
+		t.Errorf("expected the archived donation purged, received %d", count)
+	}
+}
+
+func TestSnapshotArchivedDonationsDB(t *testing.T) {
+	ctx := testDB(t)
+	loadCharacters(
+		t,
+		ctx,
+		&CharacterRow{ID: 1, GoodStanding: true},
+		&CharacterRow{ID: 2, GoodStanding: true},
+	)
+
+	month := MonthStart(time.Now()).AddDate(0, -2, 0)
+	archived := testDonation(1, 0, 1000)
+	archived.Timestamp = month.Add(time.Hour)
+	stored := testDonation(2, 0, 500)
+	stored.Timestamp = month.Add(48 * time.Hour)
+	countDonations(t, ctx, archived, stored)
+	if err := AgeDonations(
+		ctx,
+		[]*Donation{archived},
+		testAffiliations(t, ctx),
+		WindowMonth,
+	); err != nil {
+		t.Fatalf("failed to age donation: %+v", err)
+	}
+	if archived, err := ArchiveDonations(ctx, MinArchiveAge); err != nil ||
+		archived != 1 {
+		t.Fatalf("expected 1 donation archived, received %d: %+v", archived, err)
+	}
+
+	// the month's donations archived before the snapshot are in it
+	if err := SnapshotLeaderboards(ctx, month, 10); err != nil {
+		t.Fatalf("failed to snapshot leaderboards: %+v", err)
+	}
+	history, err := GetHistory(ctx, month)
+	if err != nil {
+		t.Fatalf("failed to get history: %+v", err)
+	}
+	if len(history.Received) != 1 || history.Received[0].CharacterID != 1 ||
+		history.Received[0].Count != 2 ||
+		history.Received[0].ISK != NewISK(1500) {
+		t.Errorf("unexpected received history %+v", history.Received)
+	}
+	if len(history.Donated) != 1 || history.Donated[0].ISK != NewISK(1500) {
+		t.Errorf("unexpected donated history %+v", history.Donated)
+	}
+}
+
+func TestArchivedCharDonationsDB(t *testing.T) {
+	ctx := testDB(t)
+	archived := archivedDonation(t, ctx)
+
+	received, err := GetCharDonations(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get donations: %+v", err)
+	}
+	if len(received) != 1 || received[0].ID != archived.ID {
+		t.Errorf("expected the archived donation received, got %+v", received)
+	}
+	donated, err := GetCharDonated(ctx, 2)
+	if err != nil {
+		t.Fatalf("failed to get donated: %+v", err)
+	}
+	if len(donated) != 1 || donated[0].ID != archived.ID {
+		t.Errorf("expected the archived donation donated, got %+v", donated)
+	}
+}
+
+func TestExportArchivedDonationsDB(t *testing.T) {
+	ctx := testDB(t)
+	archived := archivedDonation(t, ctx)
+
+	for _, received := range []bool{true, false} {
+		charID := int32(2)
+		if received {
+			charID = 1
+		}
+		exported := []int64{}
+		if err := EachDonation(ctx, charID, received, func(d *Donation) error {
+			exported = append(exported, d.ID)
+			return nil
+		}); err != nil {
+			t.Fatalf("failed to export donations: %+v", err)
+		}
+		if len(exported) != 1 || exported[0] != archived.ID {
+			t.Errorf(
+				"received %t: expected the archived donation, got %v",
+				received,
+				exported,
+			)
+		}
+	}
+}
+
+func TestArchivedDonationsPageDB(t *testing.T) {
+	ctx := testDB(t)
+	archived := archivedDonation(t, ctx)
+
+	page, err := GetCharPage(ctx, 1, &PageQuery{
+		Donations: true,
+		Received:  true,
+		Sent:      true,
+		Limit:     10,
+	})
+	if err != nil {
+		t.Fatalf("failed to get page: %+v", err)
+	}
+	if len(page.Donations) != 1 || page.Donations[0].ID != archived.ID {
+		t.Errorf("expected the archived donation paged, got %+v", page.Donations)
+	}
+}
+
+func TestArchivedDonationPermalinkDB(t *testing.T) {
+	ctx := testDB(t)
+	archived := archivedDonation(t, ctx)
+
+	record, err := GetDonation(ctx, archived.ID)
+	if err != nil {
+		t.Fatalf("failed to get the archived donation: %+v", err)
+	}
+	if record.Amount != archived.Amount || record.Recipient != 1 {
+		t.Errorf("unexpected archived donation %+v", record.Donation)
+	}
+}
+
+func TestProveArchivedDonationDB(t *testing.T) {
+	ctx := testDB(t)
+	archived := archivedDonation(t, ctx)
+
+	proof, err := ProveDonated(ctx, &ProofQuery{
+		Donor:     2,
+		Recipient: 1,
+		MinAmount: archived.Amount,
+		After:     archived.Timestamp.Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("failed to prove donation: %+v", err)
+	}
+	if !proof.Donated || len(proof.DonationIDs) != 1 ||
+		proof.DonationIDs[0] != archived.ID {
+		t.Errorf("expected the archived donation proven, got %+v", proof)
+	}
+}
+
+func TestArchivedDonationHistogramDB(t *testing.T) {
+	ctx := testDB(t)
+	archivedDonation(t, ctx)
+
+	histogram, err := GetHistogram(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get histogram: %+v", err)
+	}
+	if histogram.Received[0].Count != 1 {
+		t.Errorf("expected the archived donation counted, got %+v",
+			histogram.Received[0])
+	}
+}
+
+func TestArchivedSupportersDB(t *testing.T) {
+	ctx := testDB(t)
+	archived := archivedDonation(t, ctx)
+
+	supporters, err := GetSupporters(ctx, 1, 10, 0)
+	if err != nil {
+		t.Fatalf("failed to get supporters: %+v", err)
+	}
+	if len(supporters) != 1 || supporters[0].ID != 2 ||
+		supporters[0].ISK != archived.Amount {
+		t.Errorf("expected the archived donator, got %+v", supporters)
+	}
+}
+
+func TestArchivedTransfersDB(t *testing.T) {
+	ctx := testDB(t)
+	archived := archivedDonation(t, ctx)
+
+	transfers, err := GetTransfersBetween(ctx, 1, 2, 10, 0)
+	if err != nil {
+		t.Fatalf("failed to get transfers: %+v", err)
+	}
+	if len(transfers) != 1 || transfers[0].ID != archived.ID {
+		t.Errorf("expected the archived donation, got %+v", transfers)
+	}
+
+	given, received, err := GetTransferTotals(ctx, 1, 2)
+	if err != nil {
+		t.Fatalf("failed to get transfer totals: %+v", err)
+	}
+	if given.Count != 0 || received.Count != 1 ||
+		received.ISK != archived.Amount {
+		t.Errorf("unexpected transfer totals %+v and %+v", given, received)
+	}
+}
+
+func TestVoidArchivedDonationDB(t *testing.T) {
+	ctx := testDB(t)
+	archived := archivedDonation(t, ctx)
+
+	c := &Correction{Admin: "tester", Reason: "archived"}
+	voided, err := VoidDonation(ctx, archived.ID, c)
+	if err != nil {
+		t.Fatalf("failed to void the archived donation: %+v", err)
+	}
+	if voided.ID != archived.ID {
+		t.Errorf("expected the archived donation voided, got %+v", voided)
+	}
+
+	// only the lifetime and 90 day totals still counted it
+	recipient := getTestCharacter(t, ctx, 1)
+	if recipient.Received != 0 || recipient.Received90 != 0 ||
+		recipient.ReceivedISK != 0 {
+		t.Errorf("unexpected recipient totals %+v", recipient)
+	}
+	if _, err := GetDonation(ctx, archived.ID); err != ErrDonationNotFound {
+		t.Errorf("expected the voided donation hidden, received %+v", err)
+	}
+}
+
+func TestArchivedDonationsNotSavedAgainDB(t *testing.T) {
+	ctx := testDB(t)
+	archived := archivedDonation(t, ctx)
+
+	again := *archived
+	if saved, err := SaveNewDonations(ctx, []*Donation{&again}); err != nil ||
+		len(saved) != 0 {
+		t.Errorf("expected the archived donation skipped, saved %+v: %+v",
+			saved, err)
+	}
+	if copied, err := CopyNewDonations(ctx, []*Donation{&again}); err != nil ||
+		len(copied) != 0 {
+		t.Errorf("expected the archived donation not copied, saved %+v: %+v",
+			copied, err)
+	}
+
+	stored := 0
+	db := storeFrom(ctx).DB
+	if err := db.Get(&stored, "SELECT COUNT(*) FROM donations"); err != nil {
+		t.Fatalf("failed to count donations: %+v", err)
+	}
+	if stored != 0 || countArchived(t, ctx) != 1 {
+		t.Errorf("expected only the archived copy, %d stored", stored)
+	}
+}
+
+func TestArchiveKeepsDuplicatesDB(t *testing.T) {
+	ctx := testDB(t)
+	archivedDonation(t, ctx)
+
+	// a copy of the archived donation left in the donations table, as
+	// before the archive was checked on insert
+	db := storeFrom(ctx).DB
+	if _, err := db.Exec(`INSERT INTO donations
+SELECT * FROM donations_archive`); err != nil {
+		t.Fatalf("failed to copy the archived donation: %+v", err)
+	}
+
+	archived, err := ArchiveDonations(ctx, MinArchiveAge)
+	if err != nil {
+		t.Fatalf("failed to archive donations: %+v", err)
+	}
+	if archived != 0 {
+		t.Errorf("expected nothing archived, received %d", archived)
+	}
+	stored := 0
+	if err := db.Get(&stored, "SELECT COUNT(*) FROM donations"); err != nil {
+		t.Fatalf("failed to count donations: %+v", err)
+	}
+	if stored != 1 {
+		t.Errorf("expected the duplicate kept, %d stored", stored)
+	}
+}
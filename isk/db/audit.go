@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+const (
+	// AdminActor is the actor of requests made with the app secret
+	AdminActor = "admin"
+
+	// CLIActor is the actor of commands run on the server
+	CLIActor = "cli"
+)
+
+// AuditEntry is an action taken by the actor on the target, Detail is JSON
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	RequestID string          `json:"request_id,omitempty"`
+	Detail    json.RawMessage `json:"detail"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// auditRow is an audit_log row, the detail is read as text
+type auditRow struct {
+	ID        int64     `db:"id"`
+	Actor     string    `db:"actor"`
+	Action    string    `db:"action"`
+	Target    string    `db:"target"`
+	RequestID string    `db:"request_id"`
+	Detail    string    `db:"detail"`
+	Timestamp time.Time `db:"timestamp"`
+}
+
+// AuditFilter limits the audit log to an action and target when they are set
+type AuditFilter struct {
+	Action string
+	Target string
+}
+
+// CharacterTarget is the audit target of a character
+func CharacterTarget(charID int32) string {
+	return fmt.Sprintf("character:%d", charID)
+}
+
+// DonationTarget is the audit target of a donation
+func DonationTarget(transactionID int64) string {
+	return fmt.Sprintf("donation:%d", transactionID)
+}
+
+// CorrectionActor is the actor of a correction, the admin it names
+func CorrectionActor(c *Correction) string {
+	return AdminActor + ":" + c.Admin
+}
+
+// WriteAudit records the actor taking the action on the target, with the ID
+// of the request in the context, if any. The detail is stored as JSON
+func WriteAudit(
+	ctx context.Context,
+	actor, action, target string,
+	detail interface{},
+) error {
+	values, err := auditValues(ctx, actor, action, target, detail)
+	if err != nil {
+		return err
+	}
+	return executeNamed(ctx, cx.StmtWriteAudit, values)
+}
+
+// writeAuditTx records the audit entry as WriteAudit does, in the transaction
+func writeAuditTx(
+	ctx context.Context,
+	tx *sqlx.Tx,
+	actor, action, target string,
+	detail interface{},
+) error {
+	values, err := auditValues(ctx, actor, action, target, detail)
+	if err != nil {
+		return err
+	}
+	return executeNamedTx(ctx, tx, cx.StmtWriteAudit, values)
+}
+
+func auditValues(
+	ctx context.Context,
+	actor, action, target string,
+	detail interface{},
+) (map[string]interface{}, error) {
+	raw, err := json.Marshal(detail)
+	if err != nil {
+		return nil, err
+	}
+
+	requestID, _ := ctx.Value(cx.RequestID).(string)
+	return map[string]interface{}{
+		"actor":      actor,
+		"action":     action,
+		"target":     target,
+		"request_id": requestID,
+		"detail":     string(raw),
+	}, nil
+}
+
+// GetAudit returns a page of the audit log matching the filter, newest first
+func GetAudit(
+	ctx context.Context,
+	filter *AuditFilter,
+	limit, offset int,
+) ([]*AuditEntry, error) {
+	rows, err := queryNamedResult(ctx, cx.StmtAuditLog, map[string]interface{}{
+		"action": filter.Action,
+		"target": filter.Target,
+		"limit":  limit,
+		"offset": offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*AuditEntry{}
+	err = each(rows, func() interface{} { return &auditRow{} }, func(
+		i interface{},
+	) error {
+		row := i.(*auditRow)
+		entries = append(entries, &AuditEntry{
+			ID:        row.ID,
+			Actor:     row.Actor,
+			Action:    row.Action,
+			Target:    row.Target,
+			RequestID: row.RequestID,
+			Detail:    json.RawMessage(row.Detail),
+			Timestamp: row.Timestamp,
+		})
+		return nil
+	})
+	return entries, err
+}
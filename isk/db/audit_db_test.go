@@ -0,0 +1,62 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestAuditDB(t *testing.T) {
+	ctx := testDB(t)
+
+	requestCtx := context.WithValue(ctx, cx.RequestID, "abc123")
+	if err := WriteAudit(
+		requestCtx,
+		AdminActor,
+		"purge_cache",
+		"cache",
+		map[string]int{"evicted": 3},
+	); err != nil {
+		t.Fatalf("failed to write audit: %+v", err)
+	}
+	if err := WriteAudit(
+		ctx,
+		CLIActor,
+		"purge",
+		CharacterTarget(1),
+		map[string]string{"token": "no stored token"},
+	); err != nil {
+		t.Fatalf("failed to write audit: %+v", err)
+	}
+
+	entries, err := GetAudit(ctx, &AuditFilter{}, 10, 0)
+	if err != nil {
+		t.Fatalf("failed to get audit: %+v", err)
+	}
+	if len(entries) != 2 || entries[0].Action != "purge" ||
+		entries[1].RequestID != "abc123" ||
+		string(entries[1].Detail) != `{"evicted": 3}` {
+		t.Errorf("unexpected audit log %+v", entries)
+	}
+
+	for filter, expected := range map[AuditFilter]string{
+		{Action: "purge_cache"}:                       "cache",
+		{Target: CharacterTarget(1)}:                  CharacterTarget(1),
+		{Action: "purge", Target: CharacterTarget(1)}: CharacterTarget(1),
+	} {
+		f := filter
+		entries, err := GetAudit(ctx, &f, 10, 0)
+		if err != nil || len(entries) != 1 || entries[0].Target != expected {
+			t.Errorf("%+v: unexpected audit log %+v (%v)", filter, entries, err)
+		}
+	}
+
+	f := &AuditFilter{Action: "purge_cache", Target: CharacterTarget(1)}
+	if entries, err := GetAudit(ctx, f, 10, 0); err != nil || len(entries) != 0 {
+		t.Errorf("expected no entries, received %+v (%v)", entries, err)
+	}
+}
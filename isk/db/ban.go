@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// queryBannedIn pulls which of the characters are banned
+const queryBannedIn = `SELECT character_id FROM characters
+WHERE banned AND character_id IN (?)`
+
+// SetBanned bans the character, or lifts its ban, auditing the correction in
+// the same transaction. Its donations and contracts are stored either way
+func SetBanned(
+	ctx context.Context,
+	charID int32,
+	banned bool,
+	c *Correction,
+) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+
+	action := "ban"
+	if !banned {
+		action = "unban"
+	}
+
+	return transaction(ctx, func(tx *sqlx.Tx) error {
+		updated, err := executeNamedTxCount(
+			ctx,
+			tx,
+			cx.StmtSetBanned,
+			map[string]interface{}{
+				"character_id": charID,
+				"banned":       banned,
+			},
+		)
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return ErrCharacterNotFound
+		}
+
+		return writeAuditTx(
+			ctx,
+			tx,
+			CorrectionActor(c),
+			action,
+			CharacterTarget(charID),
+			map[string]string{"reason": c.Reason},
+		)
+	})
+}
+
+// GetBanned returns which of the characters are banned
+func GetBanned(ctx context.Context, ids []int32) (map[int32]bool, error) {
+	banned := map[int32]bool{}
+	if len(ids) == 0 {
+		return banned, nil
+	}
+
+	rows, err := queryIn(ctx, queryBannedIn, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	err = each(rows, func() interface{} { return &anonymousRow{} }, func(
+		i interface{},
+	) error {
+		banned[i.(*anonymousRow).ID] = true
+		return nil
+	})
+	return banned, err
+}
+
+// maskBanned replaces the banned counterparties of the character's donations
+// and contracts with the 0 ID, without a note, marking them hidden. Banned
+// donators of its largest ones are replaced with the 0 ID
+func (c *CharDetails) maskBanned(ctx context.Context) error {
+	ids := c.counterpartyIDs()
+	for _, l := range c.Character.largest() {
+		ids = append(ids, l.Donator)
+	}
+	banned, err := GetBanned(ctx, ids)
+	if err != nil {
+		return err
+	}
+	if len(banned) == 0 {
+		return nil
+	}
+
+	for _, l := range c.Character.largest() {
+		if banned[l.Donator] {
+			l.Donator = 0
+		}
+	}
+
+	for _, d := range c.Donations {
+		if banned[d.Donator] {
+			d.Donator = 0
+			d.hide()
+		}
+	}
+	for _, d := range c.Donated {
+		if banned[d.Recipient] {
+			d.Recipient = 0
+			d.hide()
+		}
+	}
+	for _, k := range c.Contracts {
+		if banned[k.Donator] {
+			k.Donator = 0
+			k.hide()
+		}
+	}
+	for _, k := range c.Contracted {
+		if banned[k.Receiver] {
+			k.Receiver = 0
+			k.hide()
+		}
+	}
+	return nil
+}
+
+func (d *Donation) hide() {
+	d.Hidden = true
+	d.Note = ""
+	d.Counterparty = nil
+}
+
+func (k *Contract) hide() {
+	k.Hidden = true
+	k.Note = ""
+	k.Counterparty = nil
+}
+
+// maskBanned hides the donation if either character is banned
+func (d *DonationRecord) maskBanned(ctx context.Context) error {
+	banned, err := GetBanned(ctx, []int32{d.Donator, d.Recipient})
+	if err != nil {
+		return err
+	}
+	if banned[d.Donator] {
+		d.Donator = 0
+		d.DonatorParty = nil
+		d.hide()
+	}
+	if banned[d.Recipient] {
+		d.Recipient = 0
+		d.ReceiverParty = nil
+		d.hide()
+	}
+	return nil
+}
+
+// maskBanned hides the contract if either character is banned
+func (k *ContractRecord) maskBanned(ctx context.Context) error {
+	banned, err := GetBanned(ctx, []int32{k.Donator, k.Receiver})
+	if err != nil {
+		return err
+	}
+	if banned[k.Donator] {
+		k.Donator = 0
+		k.DonatorParty = nil
+		k.hide()
+	}
+	if banned[k.Receiver] {
+		k.Receiver = 0
+		k.ReceiverParty = nil
+		k.hide()
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"testing"
+)
+
+func TestSetBannedDB(t *testing.T) {
+	ctx := testDB(t)
+
+	loadCharacters(t, ctx, &CharacterRow{ID: 1}, &CharacterRow{ID: 2})
+	donation := testDonation(1, 1, 1000)
+	donation.Note = "hello"
+	loadDonations(t, ctx, donation)
+
+	c := &Correction{Admin: "tester", Reason: "spam"}
+	if err := SetBanned(ctx, 3, true, c); err != ErrCharacterNotFound {
+		t.Errorf("expected character not found, received %+v", err)
+	}
+	if err := SetBanned(ctx, 2, true, &Correction{}); err == nil {
+		t.Error("expected a ban without a reason to fail")
+	}
+	if err := SetBanned(ctx, 2, true, c); err != nil {
+		t.Fatalf("failed to ban: %+v", err)
+	}
+
+	banned, err := GetBanned(ctx, []int32{1, 2})
+	if err != nil {
+		t.Fatalf("failed to get banned: %+v", err)
+	}
+	if len(banned) != 1 || !banned[2] {
+		t.Errorf("expected only character 2 banned, received %v", banned)
+	}
+
+	chars, err := GetCharacters(ctx, []int32{1, 2})
+	if err != nil {
+		t.Fatalf("failed to get characters: %+v", err)
+	}
+	if len(chars) != 1 || chars[0].ID != 1 {
+		t.Errorf("expected only character 1 listed, received %+v", chars)
+	}
+
+	details, err := GetCharDetails(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get details: %+v", err)
+	}
+	if len(details.Donations) != 1 {
+		t.Fatalf("expected 1 donation, received %d", len(details.Donations))
+	}
+	if d := details.Donations[0]; !d.Hidden || d.Donator != 0 || d.Note != "" {
+		t.Errorf("expected the banned donation hidden, received %+v", d)
+	}
+
+	entries, err := GetAudit(ctx, &AuditFilter{Action: "ban"}, 10, 0)
+	if err != nil {
+		t.Fatalf("failed to get audit log: %+v", err)
+	}
+	if len(entries) != 1 || entries[0].Target != CharacterTarget(2) {
+		t.Errorf("expected the ban audited, received %+v", entries)
+	}
+
+	// lifting the ban shows the donation again
+	if err := SetBanned(ctx, 2, false, c); err != nil {
+		t.Fatalf("failed to unban: %+v", err)
+	}
+	details, err = GetCharDetails(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get details: %+v", err)
+	}
+	if d := details.Donations[0]; d.Hidden || d.Donator != 2 || d.Note != "hello" {
+		t.Errorf("expected the donation shown again, received %+v", d)
+	}
+}
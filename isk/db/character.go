@@ -2,10 +2,11 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"sort"
 	"strings"
 	"time"
 
@@ -19,6 +20,13 @@ type Affiliation struct {
 	Character   *Name
 	Corporation *Name
 	Alliance    *Name
+
+	// Deleted characters keep their stored corporation and alliance
+	Deleted bool
+
+	// AllianceUnresolved is set when the corporation's alliance could not be
+	// looked up, the stored alliance is kept
+	AllianceUnresolved bool
 }
 
 // Character describes the output format of known characters
@@ -41,29 +49,58 @@ type Character struct {
 	// AllianceName is the last checked name of the alliance
 	AllianceName string `json:"alliance_name,omitempty"`
 
+	// Portrait, CorporationLogo and AllianceLogo are image server URLs
+	Portrait        string `json:"portrait,omitempty"`
+	CorporationLogo string `json:"corporation_logo,omitempty"`
+	AllianceLogo    string `json:"alliance_logo,omitempty"`
+
 	// Received donations and/or contracts
 	Received int64 `json:"received,omitempty"`
 
 	// ReceivedISK value of all donations plus contracts
-	ReceivedISK float64 `json:"received_isk,omitempty"`
+	ReceivedISK ISK `json:"received_isk,omitempty"`
+
+	// Received donations and/or contracts in the last 7 days
+	Received7 int64 `json:"received_7,omitempty"`
+
+	// ReceivedISK7 value of all donations plus contracts in the last 7 days
+	ReceivedISK7 ISK `json:"received_isk_7,omitempty"`
 
 	// Received donations and/or contracts in the last 30 days
 	Received30 int64 `json:"received_30,omitempty"`
 
 	// ReceivedISK30 value of all donations plus contracts in the last 30 days
-	ReceivedISK30 float64 `json:"received_isk_30,omitempty"`
+	ReceivedISK30 ISK `json:"received_isk_30,omitempty"`
+
+	// Received donations and/or contracts in the last 90 days
+	Received90 int64 `json:"received_90,omitempty"`
+
+	// ReceivedISK90 value of all donations plus contracts in the last 90 days
+	ReceivedISK90 ISK `json:"received_isk_90,omitempty"`
 
 	// Donated is the number of times this character has donated to someone else
 	Donated int64 `json:"donated,omitempty"`
 
 	// DonatedISK is the value of all ISK donated
-	DonatedISK float64 `json:"donated_isk,omitempty"`
+	DonatedISK ISK `json:"donated_isk,omitempty"`
+
+	// Donated7 is the number of donations in the last 7 days
+	Donated7 int64 `json:"donated_7,omitempty"`
+
+	// DonatedISK7 is the value of all ISK donated in the last 7 days
+	DonatedISK7 ISK `json:"donated_isk_7,omitempty"`
 
 	// Donated30 is the number of donations in the last 30 days
 	Donated30 int64 `json:"donated_30,omitempty"`
 
 	// DonatedISK30 is the value of all ISK donated in the last 30 days
-	DonatedISK30 float64 `json:"donated_isk_30,omitempty"`
+	DonatedISK30 ISK `json:"donated_isk_30,omitempty"`
+
+	// Donated90 is the number of donations in the last 90 days
+	Donated90 int64 `json:"donated_90,omitempty"`
+
+	// DonatedISK90 is the value of all ISK donated in the last 90 days
+	DonatedISK90 ISK `json:"donated_isk_90,omitempty"`
 
 	// LastDonated timestamp
 	LastDonated time.Time `json:"last_donated,omitempty"`
@@ -73,33 +110,161 @@ type Character struct {
 
 	// GoodStanding boolean
 	GoodStanding bool `json:"good_standing"`
+
+	// ReceivedRank by 30 day ISK received, zero when nothing was received
+	ReceivedRank int64 `json:"received_rank,omitempty"`
+
+	// DonatedRank by 30 day ISK donated, zero when nothing was donated
+	DonatedRank int64 `json:"donated_rank,omitempty"`
+
+	// ReceivedPercentile of ranked characters receiving no more than this one
+	ReceivedPercentile float64 `json:"received_percentile,omitempty"`
+
+	// DonatedPercentile of ranked characters donating no more than this one
+	DonatedPercentile float64 `json:"donated_percentile,omitempty"`
+
+	// RankedAt is when the ranks were last updated
+	RankedAt time.Time `json:"ranked_at,omitempty"`
+
+	// LargestDonation is the biggest counted donation received
+	LargestDonation *Largest `json:"largest_donation,omitempty"`
+
+	// LargestContract is the biggest accepted contract received
+	LargestContract *Largest `json:"largest_contract,omitempty"`
+
+	// StreakCurrent is the consecutive days (EVE time) with a donation
+	// received, up to today or yesterday
+	StreakCurrent int64 `json:"streak_current,omitempty"`
+
+	// StreakBest is the longest streak of days with a donation received
+	StreakBest int64 `json:"streak_best,omitempty"`
+
+	// StreakDay is the last day of the latest streak, kept for saving
+	StreakDay time.Time `json:"-"`
+
+	// DonorStats are left out unless they were computed recently
+	DonorStats *DonorStats `json:"donor_stats,omitempty"`
+
+	// Hidden characters are only shown to themselves
+	Hidden bool `json:"hidden,omitempty"`
+
+	// Deleted characters are no longer known to ESI, their last known names
+	// and history are kept
+	Deleted bool `json:"deleted,omitempty"`
+
+	// Banned characters are hidden from everyone by an admin
+	Banned bool `json:"-"`
 }
 
-// MarshalJSON implementation to omit our null timestamps
+// MarshalJSON implementation to omit our null timestamps, and add the short
+// form of each ISK total
 func (c *Character) MarshalJSON() ([]byte, error) {
 	type Alias Character
 
 	var lastDonatedStr string
 	var lastReceivedStr string
+	var rankedAtStr string
 
 	if !c.LastDonated.IsZero() {
-		lastDonatedStr = c.LastDonated.Format(time.RFC3339)
+		lastDonatedStr = c.LastDonated.UTC().Format(time.RFC3339)
 	}
 	if !c.LastReceived.IsZero() {
-		lastReceivedStr = c.LastReceived.Format(time.RFC3339)
+		lastReceivedStr = c.LastReceived.UTC().Format(time.RFC3339)
+	}
+	if !c.RankedAt.IsZero() {
+		rankedAtStr = c.RankedAt.UTC().Format(time.RFC3339)
 	}
 
 	return json.Marshal(&struct {
 		*Alias
 		LastReceived string `json:"last_received,omitempty"`
 		LastDonated  string `json:"last_donated,omitempty"`
+		RankedAt     string `json:"ranked_at,omitempty"`
+
+		ReceivedISKShort   string `json:"received_isk_short,omitempty"`
+		ReceivedISK7Short  string `json:"received_isk_7_short,omitempty"`
+		ReceivedISK30Short string `json:"received_isk_30_short,omitempty"`
+		ReceivedISK90Short string `json:"received_isk_90_short,omitempty"`
+		DonatedISKShort    string `json:"donated_isk_short,omitempty"`
+		DonatedISK7Short   string `json:"donated_isk_7_short,omitempty"`
+		DonatedISK30Short  string `json:"donated_isk_30_short,omitempty"`
+		DonatedISK90Short  string `json:"donated_isk_90_short,omitempty"`
 	}{
 		Alias:        (*Alias)(c),
 		LastReceived: lastReceivedStr,
 		LastDonated:  lastDonatedStr,
+		RankedAt:     rankedAtStr,
+
+		ReceivedISKShort:   c.ReceivedISK.shortOrEmpty(),
+		ReceivedISK7Short:  c.ReceivedISK7.shortOrEmpty(),
+		ReceivedISK30Short: c.ReceivedISK30.shortOrEmpty(),
+		ReceivedISK90Short: c.ReceivedISK90.shortOrEmpty(),
+		DonatedISKShort:    c.DonatedISK.shortOrEmpty(),
+		DonatedISK7Short:   c.DonatedISK7.shortOrEmpty(),
+		DonatedISK30Short:  c.DonatedISK30.shortOrEmpty(),
+		DonatedISK90Short:  c.DonatedISK90.shortOrEmpty(),
 	})
 }
 
+// Largest is the biggest single donation or contract a character received
+type Largest struct {
+	// ID is the transaction ID of the donation, or the contract ID
+	ID int64 `json:"id"`
+
+	// Amount is the ISK donated, or the value of the contract
+	Amount ISK `json:"amount"`
+
+	// Donator is the character who sent it, 0 when anonymous
+	Donator int32 `json:"donator"`
+
+	// Timestamp is when the donation was made or the contract issued
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// largest returns the largest donation and contract of the character, those
+// which are recorded
+func (c *Character) largest() []*Largest {
+	largest := []*Largest{}
+	if c == nil {
+		return largest
+	}
+	for _, l := range []*Largest{c.LargestDonation, c.LargestContract} {
+		if l != nil {
+			largest = append(largest, l)
+		}
+	}
+	return largest
+}
+
+// maskLargest replaces anonymous and banned donators of the largest
+// donations and contracts of the characters with the 0 ID
+func maskLargest(ctx context.Context, characters []*Character) error {
+	ids := []int32{}
+	for _, char := range characters {
+		for _, l := range char.largest() {
+			ids = append(ids, l.Donator)
+		}
+	}
+
+	anonymous, err := GetAnonymous(ctx, ids)
+	if err != nil {
+		return err
+	}
+	banned, err := GetBanned(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, char := range characters {
+		for _, l := range char.largest() {
+			if anonymous[l.Donator] || banned[l.Donator] {
+				l.Donator = 0
+			}
+		}
+	}
+	return nil
+}
+
 // CharacterRow describes Character as stored in the characters table
 type CharacterRow struct {
 	// ID is the characterID of this donator/recipient
@@ -115,25 +280,49 @@ type CharacterRow struct {
 	Received int64 `db:"received"`
 
 	// ReceivedISK value of all donations plus contracts
-	ReceivedISK float64 `db:"received_isk"`
+	ReceivedISK ISK `db:"received_isk"`
+
+	// Received donations and/or contracts in the last 7 days
+	Received7 int64 `db:"received_7"`
+
+	// ReceivedISK7 value of all donations plus contracts in the last 7 days
+	ReceivedISK7 ISK `db:"received_isk_7"`
 
 	// Received donations and/or contracts in the last 30 days
 	Received30 int64 `db:"received_30"`
 
 	// ReceivedISK30 value of all donations plus contracts in the last 30 days
-	ReceivedISK30 float64 `db:"received_isk_30"`
+	ReceivedISK30 ISK `db:"received_isk_30"`
+
+	// Received donations and/or contracts in the last 90 days
+	Received90 int64 `db:"received_90"`
+
+	// ReceivedISK90 value of all donations plus contracts in the last 90 days
+	ReceivedISK90 ISK `db:"received_isk_90"`
 
 	// Donated is the number of times this character has donated to someone else
 	Donated int64 `db:"donated"`
 
 	// DonatedISK is the value of all ISK donated
-	DonatedISK float64 `db:"donated_isk"`
+	DonatedISK ISK `db:"donated_isk"`
+
+	// Donated7 is the number of donations in the last 7 days
+	Donated7 int64 `db:"donated_7"`
+
+	// DonatedISK7 is the value of all ISK donated in the last 7 days
+	DonatedISK7 ISK `db:"donated_isk_7"`
 
 	// Donated30 is the number of donations in the last 30 days
 	Donated30 int64 `db:"donated_30"`
 
 	// DonatedISK30 is the value of all ISK donated in the last 30 days
-	DonatedISK30 float64 `db:"donated_isk_30"`
+	DonatedISK30 ISK `db:"donated_isk_30"`
+
+	// Donated90 is the number of donations in the last 90 days
+	Donated90 int64 `db:"donated_90"`
+
+	// DonatedISK90 is the value of all ISK donated in the last 90 days
+	DonatedISK90 ISK `db:"donated_isk_90"`
 
 	// LastDonated timestamp
 	LastDonated pq.NullTime `db:"last_donated"`
@@ -143,6 +332,66 @@ type CharacterRow struct {
 
 	// GoodStanding boolean
 	GoodStanding bool `db:"good_standing"`
+
+	// ReceivedRank by 30 day ISK received, null when nothing was received
+	ReceivedRank sql.NullInt64 `db:"received_rank"`
+
+	// DonatedRank by 30 day ISK donated, null when nothing was donated
+	DonatedRank sql.NullInt64 `db:"donated_rank"`
+
+	// ReceivedPercentile of ranked characters receiving no more than this one
+	ReceivedPercentile sql.NullFloat64 `db:"received_percentile"`
+
+	// DonatedPercentile of ranked characters donating no more than this one
+	DonatedPercentile sql.NullFloat64 `db:"donated_percentile"`
+
+	// RankedAt is when the ranks were last updated
+	RankedAt pq.NullTime `db:"ranked_at"`
+
+	// LargestDonationID and the other largest donation columns are null until
+	// a donation is counted. Like the ranks, they are never saved with the
+	// totals, only by recordLargest and the recalculation
+	LargestDonationID      sql.NullInt64 `db:"largest_donation_id"`
+	LargestDonation        sql.NullInt64 `db:"largest_donation"`
+	LargestDonationDonator sql.NullInt32 `db:"largest_donation_donator"`
+	LargestDonationAt      sql.NullTime  `db:"largest_donation_at"`
+	LargestContractID      sql.NullInt32 `db:"largest_contract_id"`
+	LargestContract        sql.NullInt64 `db:"largest_contract"`
+	LargestContractDonator sql.NullInt32 `db:"largest_contract_donator"`
+	LargestContractAt      sql.NullTime  `db:"largest_contract_at"`
+
+	// StreakCurrent is the consecutive days (EVE time) with a donation
+	// received, up to today or yesterday. It is zeroed once a day passes
+	// without one
+	StreakCurrent int64 `db:"streak_current"`
+
+	// StreakBest is the longest streak of days with a donation received
+	StreakBest int64 `db:"streak_best"`
+
+	// StreakDay is the last day of the latest streak, null without one
+	StreakDay pq.NullTime `db:"streak_day"`
+
+	// streakStale is set by donations counted before the latest streak, for
+	// the streaks to be recalculated from the stored donations
+	streakStale bool
+
+	// AverageDonation and the other donor stats are computed periodically
+	// by UpdateDonorStats, as of StatsComputedAt, which is null until they
+	// first are. They are never saved with the totals
+	AverageDonation   ISK          `db:"average_donation"`
+	AverageDonation30 ISK          `db:"average_donation_30"`
+	UniqueDonors      int64        `db:"unique_donors"`
+	RepeatDonors      int64        `db:"repeat_donors"`
+	StatsComputedAt   sql.NullTime `db:"stats_computed_at"`
+
+	// Hidden characters are only shown to themselves
+	Hidden bool `db:"hidden"`
+
+	// Deleted characters are no longer known to ESI
+	Deleted bool `db:"deleted"`
+
+	// Banned characters are hidden from everyone by an admin
+	Banned bool `db:"banned"`
 }
 
 // CharDetails is the api return for a character
@@ -156,10 +405,21 @@ type CharDetails struct {
 	// ISK OUT
 	Donated    Donations `json:"donated,omitempty"`
 	Contracted Contracts `json:"contracted,omitempty"`
+
+	// Affiliations are the corporations and alliances the character was in
+	Affiliations []*AffiliationChange `json:"affiliations,omitempty"`
+
+	// PendingContracts wait for the character to accept them, they are only
+	// set when the character is watching
+	PendingContracts []*PendingContract `json:"pending_contracts,omitempty"`
 }
 
+// ErrCharacterNotFound is returned when there is no row for the character
+var ErrCharacterNotFound = errors.New("character not found")
+
 // GetCharDetails returns details for the character from pg
 func GetCharDetails(ctx context.Context, charID int32) (*CharDetails, error) {
+	ctx = replicated(ctx)
 	char, err := GetCharacter(ctx, charID)
 	if err != nil {
 		return nil, err
@@ -185,12 +445,22 @@ func GetCharDetails(ctx context.Context, charID int32) (*CharDetails, error) {
 		return nil, err
 	}
 
+	affiliations, err := GetAffiliations(ctx, charID)
+	if err != nil {
+		return nil, err
+	}
+
 	details := &CharDetails{
-		Character:  char,
-		Donations:  donations,
-		Donated:    donated,
-		Contracts:  contracts,
-		Contracted: contracted,
+		Character:    char,
+		Donations:    donations,
+		Donated:      donated,
+		Contracts:    contracts,
+		Contracted:   contracted,
+		Affiliations: affiliations,
+	}
+
+	if err := details.addCounterparties(ctx); err != nil {
+		return nil, err
 	}
 
 	return details, nil
@@ -206,13 +476,69 @@ func getAffiliation(charID int32, affiliations []*Affiliation) *Affiliation {
 	panic(fmt.Errorf("no affiliation found for character %d", charID))
 }
 
-// SaveCharacterDonations updates all totals in the characters table
+// SaveCharacterDonations adds the donations to all totals in the characters
+// table, recording any which is the largest its receiver has
 func SaveCharacterDonations(
 	ctx context.Context,
 	donations []*Donation,
 	affiliations []*Affiliation,
-	addition bool,
 ) error {
+	// characters polled in parallel must not overwrite each other's totals,
+	// nor a donation voided meanwhile be recorded as the largest
+	unlock, err := LockTotals(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// oldest first, so each extends the streak of its receiver
+	sorted := append(Donations{}, donations...)
+	sort.Sort(sort.Reverse(sorted))
+
+	err = saveDonationTotals(ctx, sorted, affiliations, addToTotals)
+	if err != nil {
+		return err
+	}
+	if err := recordAffiliations(ctx, donations, affiliations); err != nil {
+		return err
+	}
+	return recordLargestDonations(ctx, donations)
+}
+
+// AgeCharacterDonations removes the donations from the totals of the window
+// in the characters table, and of any shorter window they are still in
+func AgeCharacterDonations(
+	ctx context.Context,
+	donations []*Donation,
+	affiliations []*Affiliation,
+	window Window,
+) error {
+	unlock, err := LockTotals(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return saveDonationTotals(
+		ctx,
+		donations,
+		affiliations,
+		func(donation *Donation, characters ...[]*CharacterRow) {
+			ageTotals(donation, window, characters...)
+		},
+	)
+}
+
+// saveDonationTotals applies the totals function for each counted donation.
+// Hold the totals lock, characters polled in parallel must not overwrite
+// each other's totals
+func saveDonationTotals(
+	ctx context.Context,
+	donations []*Donation,
+	affiliations []*Affiliation,
+	apply func(*Donation, ...[]*CharacterRow),
+) error {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
 	newCharacters := []*CharacterRow{}
 	updatedCharacters := []*CharacterRow{}
 	allCharacters := []int32{}
@@ -232,32 +558,100 @@ func SaveCharacterDonations(
 			}
 		}
 
-		if addition {
-			addToTotals(donation, newCharacters, updatedCharacters)
-		} else {
-			removeFromTotals(donation, newCharacters, updatedCharacters)
+		if donation.SelfDonation && !opts.CountSelf {
+			continue
 		}
 
+		apply(donation, newCharacters, updatedCharacters)
 	}
 
-	return saveCharacters(ctx, newCharacters, updatedCharacters)
+	if err := saveCharacters(ctx, newCharacters, updatedCharacters); err != nil {
+		return err
+	}
+	return recalculateStaleStreaks(ctx, newCharacters, updatedCharacters)
 }
 
-// SaveCharacterContracts updates all totals in the characters table
+// SaveCharacterContracts adds the accepted contracts to all totals in the
+// characters table, recording any which is the largest its receiver has
 func SaveCharacterContracts(
 	ctx context.Context,
 	donations Contracts,
 	affiliations []*Affiliation,
-	addition bool,
+) error {
+	accepted := Contracts{}
+	for _, contract := range donations {
+		if contract.Accepted {
+			accepted = append(accepted, contract)
+		}
+	}
+
+	unlock, err := LockTotals(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = saveContractTotals(ctx, accepted, affiliations, addToContractTotals)
+	if err != nil {
+		return err
+	}
+	return recordLargestContracts(ctx, accepted)
+}
+
+// AgeCharacterContracts removes the accepted contracts from the totals of the
+// window in the characters table, and of any shorter window they are still in
+func AgeCharacterContracts(
+	ctx context.Context,
+	contracts Contracts,
+	affiliations []*Affiliation,
+	window Window,
+) error {
+	unlock, err := LockTotals(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return saveContractTotals(
+		ctx,
+		contracts,
+		affiliations,
+		func(contract *Contract, characters ...[]*CharacterRow) {
+			ageContractTotals(contract, window, characters...)
+		},
+	)
+}
+
+// ReverseCharacterContracts removes previously accepted contracts from all
+// totals in the characters table, after they have been deleted or reversed
+func ReverseCharacterContracts(
+	ctx context.Context,
+	contracts Contracts,
+	affiliations []*Affiliation,
+) error {
+	unlock, err := LockTotals(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return saveContractTotals(ctx, contracts, affiliations, reverseContractTotals)
+}
+
+// saveContractTotals applies the totals function for each contract. Hold the
+// totals lock, characters polled in parallel must not overwrite each other's
+// totals
+func saveContractTotals(
+	ctx context.Context,
+	contracts Contracts,
+	affiliations []*Affiliation,
+	apply func(*Contract, ...[]*CharacterRow),
 ) error {
 	newCharacters := []*CharacterRow{}
 	updatedCharacters := []*CharacterRow{}
 	allCharacters := []int32{}
 
-	for _, contract := range donations {
-		if !contract.Accepted {
-			continue
-		}
+	for _, contract := range contracts {
 		for _, charID := range []int32{contract.Donator, contract.Receiver} {
 			if inInt32(charID, allCharacters) {
 				continue
@@ -272,16 +666,130 @@ func SaveCharacterContracts(
 			}
 		}
 
-		if addition {
-			addToContractTotals(contract, newCharacters, updatedCharacters)
-		} else {
-			removeFromContractTotals(contract, newCharacters, updatedCharacters)
-		}
+		apply(contract, newCharacters, updatedCharacters)
 	}
 
 	return saveCharacters(ctx, newCharacters, updatedCharacters)
 }
 
+// recordLargestDonations sets each counted donation as the largest its
+// receiver has, if it is bigger than the one recorded
+func recordLargestDonations(ctx context.Context, donations []*Donation) error {
+	for _, donation := range donations {
+		if err := executeNamed(
+			ctx,
+			cx.StmtRecordLargestDonation,
+			donationValues(donation),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordAffiliations stores the affiliations of the donor and recipient of
+// each donation on it, as of when it was counted
+func recordAffiliations(
+	ctx context.Context,
+	donations []*Donation,
+	affiliations []*Affiliation,
+) error {
+	for _, donation := range donations {
+		donorCorp, donorAlliance := affiliationValues(
+			getAffiliation(donation.Donator, affiliations),
+		)
+		recipientCorp, recipientAlliance := affiliationValues(
+			getAffiliation(donation.Recipient, affiliations),
+		)
+		if err := executeNamed(
+			ctx,
+			cx.StmtSetDonationAffiliations,
+			map[string]interface{}{
+				"transaction_id":           donation.ID,
+				"donor_corporation_id":     donorCorp,
+				"donor_alliance_id":        donorAlliance,
+				"recipient_corporation_id": recipientCorp,
+				"recipient_alliance_id":    recipientAlliance,
+			},
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// affiliationValues are the corporation and alliance of the affiliation as
+// stored on donations, both nil if the corporation isn't known, and the
+// alliance nil if it wasn't resolved
+func affiliationValues(aff *Affiliation) (corporation, alliance interface{}) {
+	if aff.Corporation == nil || aff.Corporation.ID == 0 {
+		return nil, nil
+	}
+	if aff.AllianceUnresolved {
+		return aff.Corporation.ID, nil
+	}
+	if aff.Alliance == nil {
+		return aff.Corporation.ID, int32(0)
+	}
+	return aff.Corporation.ID, aff.Alliance.ID
+}
+
+// recordLargestContracts sets each accepted contract as the largest its
+// receiver has, if it is bigger than the one recorded
+func recordLargestContracts(ctx context.Context, contracts Contracts) error {
+	for _, contract := range contracts {
+		if err := executeNamed(
+			ctx,
+			cx.StmtRecordLargestContract,
+			contractValues(contract),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recalculateLargest sets the largest donation and contract of the character
+// from the stored rows. Call it when either may have been voided or reversed
+func recalculateLargest(ctx context.Context, charID int32) error {
+	return executeNamed(ctx, cx.StmtRecalculateLargest, map[string]interface{}{
+		"character_id": charID,
+	})
+}
+
+// keepReceivedTx records the donation, once saved, as the largest of the
+// receiver if it is bigger, or recalculates the receiver's largest if the
+// donation was and is now voided. The streaks of the receiver are
+// recalculated when voiding, or when marked stale by the donation
+func keepReceivedTx(
+	ctx context.Context,
+	tx *sqlx.Tx,
+	receiver *CharacterRow,
+	donation *Donation,
+) error {
+	if donation.VoidedAt.Valid || receiver.streakStale {
+		if err := recalculateStreaksTx(ctx, tx, receiver.ID); err != nil {
+			return err
+		}
+	}
+
+	if receiver.LargestDonationID.Valid &&
+		receiver.LargestDonationID.Int64 == donation.ID {
+		return executeNamedTx(
+			ctx,
+			tx,
+			cx.StmtRecalculateLargest,
+			map[string]interface{}{"character_id": receiver.ID},
+		)
+	}
+	return executeNamedTx(
+		ctx,
+		tx,
+		cx.StmtRecordLargestDonation,
+		donationValues(donation),
+	)
+}
+
 // SaveCharacter saves a single character
 func SaveCharacter(ctx context.Context, char *Character) error {
 	return updateCharacter(ctx, char.toRow())
@@ -292,20 +800,34 @@ func saveCharacters(
 	newCharacters, updatedCharacters []*CharacterRow,
 ) error {
 	failedChars := []string{}
+	savedChars := []int32{}
+	createdChars := []int32{}
 	for _, char := range newCharacters {
 		if err := NewCharacter(ctx, char); err != nil {
-			log.Printf("failed to save new character %d: %+v", char.ID, err)
+			cx.Logf(ctx, "failed to save new character %d: %+v", char.ID, err)
 			failedChars = append(failedChars, fmt.Sprintf("%d", char.ID))
+		} else {
+			savedChars = append(savedChars, char.ID)
+			createdChars = append(createdChars, char.ID)
 		}
 	}
 
 	for _, char := range updatedCharacters {
 		if err := updateCharacter(ctx, char); err != nil {
-			log.Printf("failed to save updated character %d: %+v", char.ID, err)
+			cx.Logf(ctx, "failed to save updated character %d: %+v", char.ID, err)
 			failedChars = append(failedChars, fmt.Sprintf("%d", char.ID))
+		} else {
+			savedChars = append(savedChars, char.ID)
 		}
 	}
 
+	if err := NotifyCharacters(ctx, savedChars); err != nil {
+		cx.Logf(ctx, "failed to notify character updates: %+v", err)
+	}
+	if err := NotifyNewCharacters(ctx, createdChars); err != nil {
+		cx.Logf(ctx, "failed to notify new characters: %+v", err)
+	}
+
 	if len(failedChars) > 0 {
 		return fmt.Errorf(
 			"failed to save character(s): %s",
@@ -332,88 +854,210 @@ func bindAffiliation(
 		row = char.toRow()
 	}
 
+	corp, alliance := row.CorporationID, row.AllianceID
+	setAffiliation(row, aff)
+
+	// new characters record their first affiliation once created
+	if !new && affiliationChanged(row, corp, alliance) {
+		if err := addAffiliation(ctx, row); err != nil {
+			cx.Logf(ctx, "failed to record affiliation of %d: %+v", charID, err)
+		}
+	}
+
+	// new characters are marked deleted once created
+	if aff.Deleted && !row.Deleted {
+		row.Deleted = true
+		if !new {
+			if err := MarkDeleted(ctx, charID); err != nil {
+				cx.Logf(ctx, "failed to mark %d deleted: %+v", charID, err)
+			}
+		}
+	}
+
+	return row, new
+}
+
+// setAffiliation moves the row to the affiliation's corporation and alliance,
+// clearing the alliance of characters which are no longer in one. Without a
+// corporation, as for deleted characters, or with an unresolved alliance, the
+// stored affiliation is kept. New characters still take the corporation
+func setAffiliation(row *CharacterRow, aff *Affiliation) {
+	if aff.Corporation == nil {
+		return
+	}
+	if aff.AllianceUnresolved {
+		if row.CorporationID == 0 {
+			row.CorporationID = aff.Corporation.ID
+		}
+		return
+	}
 	row.CorporationID = aff.Corporation.ID
+	row.AllianceID = 0
 	if aff.Alliance != nil {
 		row.AllianceID = aff.Alliance.ID
 	}
-
-	return row, new
 }
 
-// addToTotals adds donation/received totals
+// addToTotals adds donation/received totals of every window
 func addToTotals(donation *Donation, characters ...[]*CharacterRow) {
+	countTransfer(
+		donation.Donator,
+		donation.Recipient,
+		donation.Amount,
+		1,
+		allWindows(),
+		characters...,
+	)
 	for _, chars := range characters {
 		for _, char := range chars {
 			if char.ID == donation.Donator {
-				char.DonatedISK += donation.Amount
-				char.Donated++
-				char.DonatedISK30 += donation.Amount
-				char.Donated30++
 				if !char.LastDonated.Valid || char.LastDonated.Time.Before(
 					donation.Timestamp) {
 					char.LastDonated = pq.NullTime{Time: donation.Timestamp, Valid: true}
 					char.LastDonated.Valid = true
 				}
 			} else if char.ID == donation.Recipient {
-				char.ReceivedISK += donation.Amount
-				char.Received++
-				char.ReceivedISK30 += donation.Amount
-				char.Received30++
 				if !char.LastReceived.Valid || char.LastReceived.Time.Before(
 					donation.Timestamp) {
 					char.LastReceived = pq.NullTime{Time: donation.Timestamp, Valid: true}
 					char.LastReceived.Valid = true
 				}
+				char.extendStreak(donation.Timestamp, time.Now())
 			}
 		}
 	}
 }
 
-// removeFromTotals removes donation/received totals (from 30 day)
-func removeFromTotals(donation *Donation, characters ...[]*CharacterRow) {
-	for _, chars := range characters {
-		for _, char := range chars {
-			if char.ID == donation.Donator {
-				char.DonatedISK30 -= donation.Amount
-				char.Donated30--
-			} else if char.ID == donation.Recipient {
-				char.ReceivedISK30 -= donation.Amount
-				char.Received30--
+// ageTotals removes donation/received totals of the window, and of the
+// shorter windows the donation had not aged out of yet
+func ageTotals(
+	donation *Donation,
+	window Window,
+	characters ...[]*CharacterRow,
+) {
+	countTransfer(
+		donation.Donator,
+		donation.Recipient,
+		donation.Amount,
+		-1,
+		agingWindows(donation.AgedOut, window),
+		characters...,
+	)
+}
+
+// reverseTotals removes lifetime totals of a donation, and those of each
+// window it has not aged out of
+func reverseTotals(donation *Donation, characters ...[]*CharacterRow) {
+	countTransfer(
+		donation.Donator,
+		donation.Recipient,
+		donation.Amount,
+		-1,
+		countedWindows(donation.AgedOut),
+		characters...,
+	)
+}
+
+// RecalculateTotals rebuilds the rolling totals of every window of every
+// character, and their largest donation and contract, from the donations
+// and contracts tables, correcting any incremental drift. Rows still waiting
+// to be aged out of a window are aged first, in the same transaction, so
+// each window counts exactly its rows. Hold the totals lock
+func RecalculateTotals(ctx context.Context) error {
+	return WithTx(ctx, func(ctx context.Context) error {
+		for _, w := range Windows {
+			if err := executeNamed(
+				ctx,
+				cx.StmtAgeStaleRows,
+				map[string]interface{}{"days": int(w)},
+			); err != nil {
+				return err
 			}
 		}
-	}
+
+		err := executeNamed(
+			ctx,
+			cx.StmtRecalculateTotals,
+			map[string]interface{}{},
+		)
+		if err != nil {
+			return err
+		}
+		return recalculateLargest(ctx, 0)
+	})
+}
+
+// UpdateRanks recalculates the received and donated ranks of every character
+func UpdateRanks(ctx context.Context) error {
+	return executeNamed(ctx, cx.StmtUpdateRanks, map[string]interface{}{})
 }
 
-// NewCharacter adds a new character to the characters table
+// NewCharacter adds a new character to the characters table, and records
+// its affiliation as the start of its history
 func NewCharacter(ctx context.Context, char *CharacterRow) error {
-	return executeChar(ctx, char, cx.StmtCreateCharacter)
+	if _, err := executeChar(ctx, char, cx.StmtCreateCharacter); err != nil {
+		return err
+	}
+	if err := addAffiliation(ctx, char); err != nil {
+		return err
+	}
+	if char.Deleted {
+		return MarkDeleted(ctx, char.ID)
+	}
+	return nil
 }
 
-// updateCharacter updates a character in the characters table
-func updateCharacter(ctx context.Context, char *CharacterRow) error {
-	return executeChar(ctx, char, cx.StmtUpdateCharacter)
-}
-
-// executeChar is a DRY helper to create or update a character
-func executeChar(ctx context.Context, char *CharacterRow, key cx.Key) error {
-	return executeNamed(ctx, key, map[string]interface{}{
-		"character_id":    char.ID,
-		"corporation_id":  char.CorporationID,
-		"alliance_id":     char.AllianceID,
-		"received":        char.Received,
-		"received_isk":    char.ReceivedISK,
-		"received_30":     char.Received30,
-		"received_isk_30": char.ReceivedISK30,
-		"donated":         char.Donated,
-		"donated_isk":     char.DonatedISK,
-		"donated_30":      char.Donated30,
-		"donated_isk_30":  char.DonatedISK30,
-		"last_donated":    char.LastDonated,
-		"last_received":   char.LastReceived,
-		"good_standing":   char.GoodStanding,
+// MarkDeleted flags the character as no longer known to ESI. It is kept, but
+// no longer polled
+func MarkDeleted(ctx context.Context, charID int32) error {
+	return executeNamed(ctx, cx.StmtMarkDeleted, map[string]interface{}{
+		"character_id": charID,
 	})
 }
 
+// updateCharacter updates a character in the characters table. A character
+// removed since it was read is created again, so its totals aren't lost
+func updateCharacter(ctx context.Context, char *CharacterRow) error {
+	updated, err := executeChar(ctx, char, cx.StmtUpdateCharacter)
+	if err != nil || updated > 0 {
+		return err
+	}
+	cx.Logf(ctx, "character %d was removed before its update, creating it", char.ID)
+	return NewCharacter(ctx, char)
+}
+
+// executeChar is a DRY helper to create or update a character, returning the
+// rows affected
+func executeChar(
+	ctx context.Context,
+	char *CharacterRow,
+	key cx.Key,
+) (int64, error) {
+	return executeNamedCount(ctx, key, characterValues(char))
+}
+
+func characterValues(char *CharacterRow) map[string]interface{} {
+	values := map[string]interface{}{
+		"character_id":   char.ID,
+		"corporation_id": char.CorporationID,
+		"alliance_id":    char.AllianceID,
+		"last_donated":   utcNullTime(char.LastDonated),
+		"last_received":  utcNullTime(char.LastReceived),
+		"good_standing":  char.GoodStanding,
+		"streak_current": char.StreakCurrent,
+		"streak_best":    char.StreakBest,
+		"streak_day":     utcNullTime(char.StreakDay),
+	}
+	for _, w := range allWindows() {
+		totals := char.totals(w)
+		values["received"+w.suffix()] = *totals.received
+		values["received_isk"+w.suffix()] = *totals.receivedISK
+		values["donated"+w.suffix()] = *totals.donated
+		values["donated_isk"+w.suffix()] = *totals.donatedISK
+	}
+	return values
+}
+
 // GetCharacter pulls a single character from the db
 func GetCharacter(ctx context.Context, charID int32) (*Character, error) {
 	rows, err := queryNamedResult(
@@ -448,7 +1092,7 @@ func scanCharacterRow(rows *sqlx.Rows) (*CharacterRow, error) {
 		return i.(*CharacterRow), nil
 	}
 
-	return nil, errors.New("character not found")
+	return nil, ErrCharacterNotFound
 }
 
 // getCharacterNames fills in the character, corporation and alliance names
@@ -471,40 +1115,145 @@ func getCharacterNames(
 	char := row.toCharacter()
 
 	for id, name := range names {
-		if id == char.ID {
-			char.Name = name
-		} else if id == char.CorporationID {
-			char.CorporationName = name
-		} else if id == char.AllianceID {
-			char.AllianceName = name
-		} else {
-			log.Printf("pulled unknown ID: %d, name: %s", id, name)
+		if !setName(char, id, name) {
+			cx.Logf(ctx, "pulled unknown ID: %d, name: %s", id, name)
 		}
 	}
+	char.setImages(ctx)
 
 	return char, nil
 }
 
+// setName sets the character, corporation or alliance name of the ID,
+// returning false if the ID is none of them
+func setName(char *Character, id int32, name string) bool {
+	if id == char.ID {
+		char.Name = name
+	} else if id == char.CorporationID {
+		char.CorporationName = name
+	} else if id == char.AllianceID {
+		char.AllianceName = name
+	} else {
+		return false
+	}
+	return true
+}
+
+// GetCharacters returns the known characters of the IDs, with their names,
+// in one query for the characters and another for the names. Hidden
+// characters are left out, anonymous and banned donators of their largest
+// donations and contracts are masked
+func GetCharacters(ctx context.Context, ids []int32) ([]*Character, error) {
+	ctx = replicated(ctx)
+	if len(ids) == 0 {
+		return []*Character{}, nil
+	}
+
+	rows, err := queryIn(ctx, queryCharactersIn, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &CharacterRow{} })
+	if err != nil {
+		return nil, err
+	}
+
+	characters := []*Character{}
+	nameIDs := []int32{}
+	seen := map[int32]bool{}
+	for _, i := range res {
+		char := i.(*CharacterRow).toCharacter()
+		characters = append(characters, char)
+		for _, id := range []int32{char.ID, char.CorporationID, char.AllianceID} {
+			if id > 0 && !seen[id] {
+				seen[id] = true
+				nameIDs = append(nameIDs, id)
+			}
+		}
+	}
+
+	names, err := getNamesIn(ctx, nameIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, char := range characters {
+		for _, id := range []int32{char.ID, char.CorporationID, char.AllianceID} {
+			if name, found := names[id]; found {
+				setName(char, id, name)
+			}
+		}
+		char.setImages(ctx)
+	}
+
+	// bulk lookups are not of the session character, so mask for everyone
+	if err := maskLargest(ctx, characters); err != nil {
+		return nil, err
+	}
+	return characters, nil
+}
+
 func (c *CharacterRow) toCharacter() *Character {
 	char := &Character{
 		ID:            c.ID,
 		CorporationID: c.CorporationID,
 		AllianceID:    c.AllianceID,
 		Received:      c.Received,
-		ReceivedISK:   round2(c.ReceivedISK),
+		ReceivedISK:   c.ReceivedISK,
+		Received7:     c.Received7,
+		ReceivedISK7:  c.ReceivedISK7,
 		Received30:    c.Received30,
-		ReceivedISK30: round2(c.ReceivedISK30),
+		ReceivedISK30: c.ReceivedISK30,
+		Received90:    c.Received90,
+		ReceivedISK90: c.ReceivedISK90,
 		Donated:       c.Donated,
-		DonatedISK:    round2(c.DonatedISK),
+		DonatedISK:    c.DonatedISK,
+		Donated7:      c.Donated7,
+		DonatedISK7:   c.DonatedISK7,
 		Donated30:     c.Donated30,
-		DonatedISK30:  round2(c.DonatedISK30),
+		DonatedISK30:  c.DonatedISK30,
+		Donated90:     c.Donated90,
+		DonatedISK90:  c.DonatedISK90,
 		GoodStanding:  c.GoodStanding,
+		StreakCurrent: c.StreakCurrent,
+		StreakBest:    c.StreakBest,
+		Hidden:        c.Hidden,
+		Deleted:       c.Deleted,
+		Banned:        c.Banned,
 	}
 	if c.LastDonated.Valid {
-		char.LastDonated = c.LastDonated.Time
+		char.LastDonated = c.LastDonated.Time.UTC()
 	}
 	if c.LastReceived.Valid {
-		char.LastReceived = c.LastReceived.Time
+		char.LastReceived = c.LastReceived.Time.UTC()
+	}
+	if c.RankedAt.Valid {
+		char.RankedAt = c.RankedAt.Time.UTC()
+	}
+	if c.StreakDay.Valid {
+		char.StreakDay = c.StreakDay.Time.UTC()
+	}
+	char.DonorStats = c.donorStats(time.Now())
+	char.ReceivedRank = c.ReceivedRank.Int64
+	char.DonatedRank = c.DonatedRank.Int64
+	char.ReceivedPercentile = c.ReceivedPercentile.Float64
+	char.DonatedPercentile = c.DonatedPercentile.Float64
+	if c.LargestDonationID.Valid {
+		char.LargestDonation = &Largest{
+			ID:        c.LargestDonationID.Int64,
+			Amount:    ISK(c.LargestDonation.Int64),
+			Donator:   c.LargestDonationDonator.Int32,
+			Timestamp: c.LargestDonationAt.Time.UTC(),
+		}
+	}
+	if c.LargestContractID.Valid {
+		char.LargestContract = &Largest{
+			ID:        int64(c.LargestContractID.Int32),
+			Amount:    ISK(c.LargestContract.Int64),
+			Donator:   c.LargestContractDonator.Int32,
+			Timestamp: c.LargestContractAt.Time.UTC(),
+		}
 	}
 	return char
 }
@@ -516,12 +1265,20 @@ func (c *Character) toRow() *CharacterRow {
 		AllianceID:    c.AllianceID,
 		Received:      c.Received,
 		ReceivedISK:   c.ReceivedISK,
+		Received7:     c.Received7,
+		ReceivedISK7:  c.ReceivedISK7,
 		Received30:    c.Received30,
 		ReceivedISK30: c.ReceivedISK30,
+		Received90:    c.Received90,
+		ReceivedISK90: c.ReceivedISK90,
 		Donated:       c.Donated,
 		DonatedISK:    c.DonatedISK,
+		Donated7:      c.Donated7,
+		DonatedISK7:   c.DonatedISK7,
 		Donated30:     c.Donated30,
 		DonatedISK30:  c.DonatedISK30,
+		Donated90:     c.Donated90,
+		DonatedISK90:  c.DonatedISK90,
 		LastDonated: pq.NullTime{
 			Time:  c.LastDonated,
 			Valid: !c.LastDonated.IsZero(),
@@ -530,6 +1287,13 @@ func (c *Character) toRow() *CharacterRow {
 			Time:  c.LastReceived,
 			Valid: !c.LastReceived.IsZero(),
 		},
-		GoodStanding: c.GoodStanding,
+		GoodStanding:  c.GoodStanding,
+		StreakCurrent: c.StreakCurrent,
+		StreakBest:    c.StreakBest,
+		StreakDay: pq.NullTime{
+			Time:  c.StreakDay,
+			Valid: !c.StreakDay.IsZero(),
+		},
+		Deleted: c.Deleted,
 	}
 }
@@ -0,0 +1,429 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// testAt is when the test donations and contracts were made
+var testAt = time.Date(2019, 1, 20, 12, 0, 0, 0, time.UTC)
+
+// testAffiliations are of recipient 1 in corporation 10, and donator 2 in
+// corporation 11 of alliance 20, with their names saved
+func testAffiliations(t *testing.T, ctx context.Context) []*Affiliation {
+	affiliations := []*Affiliation{
+		{
+			Character:   &Name{ID: 1, Name: "Some Recipient"},
+			Corporation: &Name{ID: 10, Name: "Recipient Corporation"},
+		},
+		{
+			Character:   &Name{ID: 2, Name: "Some Donator"},
+			Corporation: &Name{ID: 11, Name: "Donator Corporation"},
+			Alliance:    &Name{ID: 20, Name: "Donator Alliance"},
+		},
+	}
+	if err := SaveNames(ctx, affiliations); err != nil {
+		t.Fatalf("failed to save names: %+v", err)
+	}
+	return affiliations
+}
+
+// testDonation returns a donation from 2 to 1, hours after testAt
+func testDonation(id int64, hours int, amount float64) *Donation {
+	return &Donation{
+		ID:        id,
+		Donator:   2,
+		Recipient: 1,
+		Timestamp: testAt.Add(time.Duration(hours) * time.Hour),
+		Amount:    NewISK(amount),
+	}
+}
+
+func getTestCharacter(
+	t *testing.T,
+	ctx context.Context,
+	charID int32,
+) *Character {
+	char, err := GetCharacter(ctx, charID)
+	if err != nil {
+		t.Fatalf("failed to get character %d: %+v", charID, err)
+	}
+	return char
+}
+
+func TestSaveCharacterDonationsDB(t *testing.T) {
+	ctx := testDB(t)
+	affiliations := testAffiliations(t, ctx)
+
+	first := testDonation(1, 0, 1000)
+	loadDonations(t, ctx, first)
+	if err := SaveCharacterDonations(
+		ctx,
+		[]*Donation{first},
+		affiliations,
+	); err != nil {
+		t.Fatalf("failed to save new characters: %+v", err)
+	}
+
+	// the second donation updates the rows the first created
+	second := testDonation(2, 1, 500)
+	loadDonations(t, ctx, second)
+	if err := SaveCharacterDonations(
+		ctx,
+		[]*Donation{second},
+		affiliations,
+	); err != nil {
+		t.Fatalf("failed to save updated characters: %+v", err)
+	}
+
+	recipient := getTestCharacter(t, ctx, 1)
+	if recipient.Received != 2 || recipient.ReceivedISK != NewISK(1500) ||
+		recipient.Received30 != 2 || recipient.ReceivedISK30 != NewISK(1500) {
+		t.Errorf("unexpected recipient totals %+v", recipient)
+	}
+	if !recipient.LastReceived.Equal(second.Timestamp) {
+		t.Errorf("unexpected last received %s", recipient.LastReceived)
+	}
+	if !recipient.LastDonated.IsZero() {
+		t.Errorf("null last donated was read as %s", recipient.LastDonated)
+	}
+	if recipient.Name != "Some Recipient" ||
+		recipient.CorporationName != "Recipient Corporation" ||
+		recipient.AllianceID != 0 {
+		t.Errorf("unexpected recipient affiliation %+v", recipient)
+	}
+
+	donator := getTestCharacter(t, ctx, 2)
+	if donator.Donated != 2 || donator.DonatedISK != NewISK(1500) ||
+		donator.Received != 0 {
+		t.Errorf("unexpected donator totals %+v", donator)
+	}
+	if donator.AllianceID != 20 || donator.AllianceName != "Donator Alliance" {
+		t.Errorf("unexpected donator alliance %+v", donator)
+	}
+}
+
+func TestSaveCharacterDonationsLeftAllianceDB(t *testing.T) {
+	ctx := testDB(t)
+	affiliations := testAffiliations(t, ctx)
+
+	first := testDonation(1, 0, 1000)
+	loadDonations(t, ctx, first)
+	if err := SaveCharacterDonations(
+		ctx,
+		[]*Donation{first},
+		affiliations,
+	); err != nil {
+		t.Fatalf("failed to save characters: %+v", err)
+	}
+
+	affiliations[1].Alliance = nil
+	second := testDonation(2, 1, 500)
+	loadDonations(t, ctx, second)
+	if err := SaveCharacterDonations(
+		ctx,
+		[]*Donation{second},
+		affiliations,
+	); err != nil {
+		t.Fatalf("failed to save characters: %+v", err)
+	}
+
+	if donator := getTestCharacter(t, ctx, 2); donator.AllianceID != 0 ||
+		donator.AllianceName != "" {
+		t.Errorf("expected the alliance to be cleared, received %+v", donator)
+	}
+}
+
+func TestGetCharacterNullsDB(t *testing.T) {
+	ctx := testDB(t)
+	loadCharacters(t, ctx, &CharacterRow{
+		ID:            1,
+		CorporationID: 10,
+		LastReceived:  pq.NullTime{Time: testAt, Valid: true},
+	})
+
+	char := getTestCharacter(t, ctx, 1)
+	if !char.LastReceived.Equal(testAt) ||
+		char.LastReceived.Location() != time.UTC {
+		t.Errorf("unexpected last received %s", char.LastReceived)
+	}
+	if !char.LastDonated.IsZero() {
+		t.Errorf("null last donated was read as %s", char.LastDonated)
+	}
+	if char.Name != "" || char.Deleted || char.GoodStanding {
+		t.Errorf("unexpected defaults %+v", char)
+	}
+
+	if _, err := GetCharacter(ctx, 2); err != ErrCharacterNotFound {
+		t.Errorf("expected ErrCharacterNotFound, received %+v", err)
+	}
+}
+
+func TestNewCharacterExistsDB(t *testing.T) {
+	ctx := testDB(t)
+	loadCharacters(t, ctx, &CharacterRow{ID: 1, Received: 3})
+
+	if err := NewCharacter(ctx, &CharacterRow{ID: 1}); err == nil {
+		t.Error("expected creating an existing character to fail")
+	}
+	if char := getTestCharacter(t, ctx, 1); char.Received != 3 {
+		t.Errorf("existing character was overwritten %+v", char)
+	}
+}
+
+func TestSaveCharacterContractsDB(t *testing.T) {
+	ctx := testDB(t)
+	affiliations := testAffiliations(t, ctx)
+
+	contracts := Contracts{
+		{
+			ID:       1,
+			Donator:  2,
+			Receiver: 1,
+			Issued:   testAt,
+			Expires:  testAt.Add(24 * time.Hour),
+			Accepted: true,
+			Status:   "finished",
+			Value:    NewISK(2000),
+		},
+		{
+			ID:       2,
+			Donator:  2,
+			Receiver: 1,
+			Issued:   testAt.Add(time.Hour),
+			Expires:  testAt.Add(24 * time.Hour),
+			Status:   "outstanding",
+			Value:    NewISK(5000),
+		},
+	}
+	loadContracts(t, ctx, contracts...)
+	if err := SaveCharacterContracts(
+		ctx,
+		contracts,
+		affiliations,
+	); err != nil {
+		t.Fatalf("failed to save characters: %+v", err)
+	}
+
+	// only accepted contracts count
+	recipient := getTestCharacter(t, ctx, 1)
+	if recipient.Received != 1 || recipient.ReceivedISK != NewISK(2000) {
+		t.Errorf("unexpected recipient totals %+v", recipient)
+	}
+	if !recipient.LastReceived.Equal(testAt) {
+		t.Errorf("unexpected last received %s", recipient.LastReceived)
+	}
+}
+
+// deleteCharacter removes the character row, as a concurrent purge would
+func deleteCharacter(t *testing.T, ctx context.Context, charID int32) {
+	if err := executeNamed(
+		ctx,
+		cx.StmtDeleteCharacter,
+		map[string]interface{}{"character_id": charID},
+	); err != nil {
+		t.Fatalf("failed to delete character %d: %+v", charID, err)
+	}
+}
+
+func TestUpdateCharacterRemovedDB(t *testing.T) {
+	ctx := testDB(t)
+	affiliations := testAffiliations(t, ctx)
+	loadCharacters(t, ctx, &CharacterRow{
+		ID:            1,
+		CorporationID: 10,
+		Received:      3,
+		ReceivedISK:   NewISK(3000),
+	})
+
+	// the row is read, then removed before the totals are written
+	row, new := bindAffiliation(ctx, 1, affiliations)
+	if new {
+		t.Fatal("expected the loaded character to be read")
+	}
+	deleteCharacter(t, ctx, 1)
+
+	row.Received++
+	row.ReceivedISK += NewISK(1000)
+	if err := saveCharacters(ctx, nil, []*CharacterRow{row}); err != nil {
+		t.Fatalf("failed to save removed character: %+v", err)
+	}
+
+	recipient := getTestCharacter(t, ctx, 1)
+	if recipient.Received != 4 || recipient.ReceivedISK != NewISK(4000) {
+		t.Errorf("expected the totals to be kept, received %+v", recipient)
+	}
+	if recipient.CorporationID != 10 {
+		t.Errorf("unexpected corporation %d", recipient.CorporationID)
+	}
+}
+
+func TestUnresolvedAllianceKeptDB(t *testing.T) {
+	ctx := testDB(t)
+	loadCharacters(t, ctx, &CharacterRow{
+		ID:            2,
+		CorporationID: 11,
+		AllianceID:    20,
+	})
+	corp := &Name{ID: 11, Name: "Donator Corporation"}
+
+	// the alliance lookup failing keeps the stored alliance, ESI reporting
+	// none clears it. loading recorded the first affiliation
+	for _, c := range []struct {
+		aff      *Affiliation
+		alliance int32
+		changes  int
+	}{
+		{&Affiliation{Corporation: corp, AllianceUnresolved: true}, 20, 1},
+		{&Affiliation{Corporation: corp, AllianceUnresolved: true}, 20, 1},
+		{&Affiliation{Corporation: corp}, 0, 2},
+	} {
+		c.aff.Character = &Name{ID: 2, Name: "Some Donator"}
+		row, new := bindAffiliation(ctx, 2, []*Affiliation{c.aff})
+		if new {
+			t.Fatal("expected the loaded character to be read")
+		}
+		if err := saveCharacters(ctx, nil, []*CharacterRow{row}); err != nil {
+			t.Fatalf("failed to save character: %+v", err)
+		}
+
+		char := getTestCharacter(t, ctx, 2)
+		if char.AllianceID != c.alliance {
+			t.Errorf(
+				"expected alliance %d, received %d",
+				c.alliance,
+				char.AllianceID,
+			)
+		}
+		changes, err := GetAffiliations(ctx, 2)
+		if err != nil {
+			t.Fatalf("failed to get affiliations: %+v", err)
+		}
+		if len(changes) != c.changes {
+			t.Errorf("expected %d affiliation changes, received %+v",
+				c.changes, changes)
+		}
+	}
+}
+
+func TestGetCharDetailsCounterpartiesDB(t *testing.T) {
+	ctx := testDB(t)
+	affiliations := testAffiliations(t, ctx)
+
+	donation := testDonation(1, 0, 1000)
+	loadDonations(t, ctx, donation)
+	if err := SaveCharacterDonations(
+		ctx,
+		[]*Donation{donation},
+		affiliations,
+	); err != nil {
+		t.Fatalf("failed to save characters: %+v", err)
+	}
+
+	received, err := GetCharDetails(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get character details: %+v", err)
+	}
+	if len(received.Donations) != 1 {
+		t.Fatalf("expected 1 donation, received %d", len(received.Donations))
+	}
+	expected := &Party{
+		Name:            "Some Donator",
+		CorporationID:   11,
+		CorporationName: "Donator Corporation",
+		AllianceID:      20,
+		AllianceName:    "Donator Alliance",
+	}
+	if party := received.Donations[0].Counterparty; party == nil ||
+		*party != *expected {
+		t.Errorf("expected the donator %+v, received %+v", expected, party)
+	}
+
+	sent, err := GetCharDetails(ctx, 2)
+	if err != nil {
+		t.Fatalf("failed to get character details: %+v", err)
+	}
+	if party := sent.Donated[0].Counterparty; party == nil ||
+		party.Name != "Some Recipient" ||
+		party.CorporationName != "Recipient Corporation" {
+		t.Errorf("unexpected recipient %+v", party)
+	}
+}
+
+func TestDonationAffiliationsDB(t *testing.T) {
+	ctx := testDB(t)
+	countDonations(t, ctx, testDonation(1, 0, 1000))
+
+	d, err := GetDonation(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get donation: %+v", err)
+	}
+	for name, tc := range map[string]struct {
+		stored   sql.NullInt32
+		expected int32
+	}{
+		"donor corporation":     {d.DonorCorporationID, 11},
+		"donor alliance":        {d.DonorAllianceID, 20},
+		"recipient corporation": {d.RecipientCorporationID, 10},
+		"recipient alliance":    {d.RecipientAllianceID, 0},
+	} {
+		if !tc.stored.Valid || tc.stored.Int32 != tc.expected {
+			t.Errorf("%s: expected %d, received %+v", name, tc.expected, tc.stored)
+		}
+	}
+
+	// moving corporations doesn't change the donations already counted
+	moved := testAffiliations(t, ctx)
+	moved[1].Corporation = &Name{ID: 12, Name: "Another Corporation"}
+	moved[1].Alliance = nil
+	if err := recordAffiliations(
+		ctx,
+		[]*Donation{testDonation(1, 0, 1000)},
+		moved,
+	); err != nil {
+		t.Fatalf("failed to record affiliations: %+v", err)
+	}
+	if d, err = GetDonation(ctx, 1); err != nil {
+		t.Fatalf("failed to get donation: %+v", err)
+	}
+	if d.DonorCorporationID.Int32 != 11 || d.DonorAllianceID.Int32 != 20 {
+		t.Errorf("expected the affiliation kept, received %+v, %+v",
+			d.DonorCorporationID, d.DonorAllianceID)
+	}
+}
+
+func TestRecalculateStaleTotalsDB(t *testing.T) {
+	ctx := testDB(t)
+
+	// a backlog of the hourly aging, counted but never aged out of 7 days
+	now := time.Now().UTC()
+	stale := testDonation(1, 0, 1000)
+	stale.Timestamp = now.Add(-10 * 24 * time.Hour)
+	recent := testDonation(2, 0, 500)
+	recent.Timestamp = now.Add(-24 * time.Hour)
+	countDonations(t, ctx, stale, recent)
+
+	if err := RecalculateTotals(ctx); err != nil {
+		t.Fatalf("failed to recalculate totals: %+v", err)
+	}
+	recipient := getTestCharacter(t, ctx, 1)
+	if recipient.Received7 != 1 || recipient.ReceivedISK7 != NewISK(500) ||
+		recipient.Received30 != 2 {
+		t.Errorf("unexpected recipient totals %+v", recipient)
+	}
+
+	// aged by the recalculation, so the hourly aging won't remove it again
+	donations, err := GetStaleDonations(ctx, 7)
+	if err != nil || len(donations) != 0 {
+		t.Errorf("expected none stale, received %+v: %+v", donations, err)
+	}
+}
@@ -0,0 +1,243 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestUTCNullTime(t *testing.T) {
+	zone := time.FixedZone("UTC+10", 10*60*60)
+	local := time.Date(2018, 12, 25, 8, 30, 0, 0, zone)
+
+	converted := utcNullTime(pq.NullTime{Time: local, Valid: true})
+	if converted.Time.Location() != time.UTC {
+		t.Errorf("invalid location. received %s", converted.Time.Location())
+	}
+	if !converted.Time.Equal(local) {
+		t.Errorf("time changed. received %s, expected %s", converted.Time, local)
+	}
+	if converted.Time.Hour() != 22 || converted.Time.Day() != 24 {
+		t.Errorf("invalid UTC wall time: %s", converted.Time)
+	}
+
+	if invalid := utcNullTime(pq.NullTime{}); invalid.Valid {
+		t.Error("invalid NullTime became valid")
+	}
+}
+
+func TestCharacterRowUTC(t *testing.T) {
+	zone := time.FixedZone("UTC-5", -5*60*60)
+	local := time.Date(2018, 12, 25, 20, 0, 0, 0, zone)
+
+	row := &CharacterRow{
+		ID:           1,
+		LastReceived: pq.NullTime{Time: local, Valid: true},
+	}
+
+	char := row.toCharacter()
+	if char.LastReceived.Location() != time.UTC {
+		t.Errorf("invalid location. received %s", char.LastReceived.Location())
+	}
+	if !char.LastReceived.Equal(local) {
+		t.Errorf("time changed. received %s, expected %s", char.LastReceived, local)
+	}
+	if !char.LastDonated.IsZero() {
+		t.Errorf("null time became valid: %s", char.LastDonated)
+	}
+
+	out, err := json.Marshal(char)
+	if err != nil {
+		t.Fatalf("failed to marshal character: %+v", err)
+	}
+	if !strings.Contains(string(out), `"last_received":"2018-12-26T01:00:00Z"`) {
+		t.Errorf("last_received not formatted in UTC: %s", out)
+	}
+	if strings.Contains(string(out), "last_donated") {
+		t.Errorf("null last_donated was included: %s", out)
+	}
+
+	back := char.toRow()
+	if !back.LastReceived.Valid || !back.LastReceived.Time.Equal(local) {
+		t.Errorf("round trip changed time: %+v", back.LastReceived)
+	}
+	if back.LastDonated.Valid {
+		t.Error("null time became valid on round trip")
+	}
+}
+
+func TestCharacterRanks(t *testing.T) {
+	ranked := time.Date(2018, 12, 25, 20, 0, 0, 0, time.UTC)
+	row := &CharacterRow{
+		ID:                 1,
+		ReceivedRank:       sql.NullInt64{Int64: 3, Valid: true},
+		ReceivedPercentile: sql.NullFloat64{Float64: 99.5, Valid: true},
+		RankedAt:           pq.NullTime{Time: ranked, Valid: true},
+	}
+
+	out, err := json.Marshal(row.toCharacter())
+	if err != nil {
+		t.Fatalf("failed to marshal character: %+v", err)
+	}
+	for _, expected := range []string{
+		`"received_rank":3`,
+		`"received_percentile":99.5`,
+		`"ranked_at":"2018-12-25T20:00:00Z"`,
+	} {
+		if !strings.Contains(string(out), expected) {
+			t.Errorf("missing %s: %s", expected, out)
+		}
+	}
+	if strings.Contains(string(out), "donated_rank") {
+		t.Errorf("unranked direction has a rank: %s", out)
+	}
+}
+
+func TestCharacterLargest(t *testing.T) {
+	donated := time.Date(2018, 12, 25, 20, 0, 0, 0, time.UTC)
+	row := &CharacterRow{
+		ID:                     1,
+		LargestDonationID:      sql.NullInt64{Int64: 12, Valid: true},
+		LargestDonation:        sql.NullInt64{Int64: int64(NewISK(5000)), Valid: true},
+		LargestDonationDonator: sql.NullInt32{Int32: 2, Valid: true},
+		LargestDonationAt:      sql.NullTime{Time: donated, Valid: true},
+	}
+
+	out, err := json.Marshal(row.toCharacter())
+	if err != nil {
+		t.Fatalf("failed to marshal character: %+v", err)
+	}
+	expected := `"largest_donation":{"id":12,"amount":5000.00,"donator":2,` +
+		`"timestamp":"2018-12-25T20:00:00Z"}`
+	if !strings.Contains(string(out), expected) {
+		t.Errorf("missing %s: %s", expected, out)
+	}
+	if strings.Contains(string(out), "largest_contract") {
+		t.Errorf("character without a contract has a largest one: %s", out)
+	}
+	if largest := row.toCharacter().largest(); len(largest) != 1 {
+		t.Errorf("expected only the largest donation, received %+v", largest)
+	}
+}
+
+func TestReverseTotals(t *testing.T) {
+	donation := &Donation{ID: 1, Donator: 10, Recipient: 20, Amount: 5000}
+	donator := &CharacterRow{ID: 10}
+	receiver := &CharacterRow{ID: 20}
+	chars := []*CharacterRow{donator, receiver}
+
+	addToTotals(donation, chars)
+	reverseTotals(donation, chars)
+
+	for _, char := range chars {
+		if !zeroTotals(char) {
+			t.Errorf("character %d totals not reversed: %+v", char.ID, char)
+		}
+	}
+}
+
+func TestCharacterShortISK(t *testing.T) {
+	out, err := json.Marshal(&Character{
+		ID:            1,
+		ReceivedISK:   NewISK(1236000000),
+		ReceivedISK30: NewISK(999999.99),
+		DonatedISK:    NewISK(-1500),
+		DonatedISK7:   NewISK(25000),
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal character: %+v", err)
+	}
+
+	for _, expected := range []string{
+		`"received_isk":1236000000.00`,
+		`"received_isk_short":"1.24b"`,
+		`"received_isk_30_short":"1m"`,
+		`"donated_isk_short":"-1.5k"`,
+		`"donated_isk_7_short":"25k"`,
+	} {
+		if !strings.Contains(string(out), expected) {
+			t.Errorf("expected %s in %s", expected, out)
+		}
+	}
+	if strings.Contains(string(out), "donated_isk_30_short") {
+		t.Errorf("short form of a 0 total was included: %s", out)
+	}
+}
+
+func TestAffiliationValues(t *testing.T) {
+	corp := &Name{ID: 10}
+	for name, tc := range map[string]struct {
+		aff                   *Affiliation
+		corporation, alliance interface{}
+	}{
+		"unknown":     {&Affiliation{}, nil, nil},
+		"no alliance": {&Affiliation{Corporation: corp}, int32(10), int32(0)},
+		"alliance": {
+			&Affiliation{Corporation: corp, Alliance: &Name{ID: 20}},
+			int32(10),
+			int32(20),
+		},
+		"no corporation": {
+			&Affiliation{Corporation: &Name{}, Alliance: &Name{ID: 20}},
+			nil,
+			nil,
+		},
+		"unresolved alliance": {
+			&Affiliation{Corporation: corp, AllianceUnresolved: true},
+			int32(10),
+			nil,
+		},
+	} {
+		corporation, alliance := affiliationValues(tc.aff)
+		if corporation != tc.corporation || alliance != tc.alliance {
+			t.Errorf("%s: expected %v, %v, received %v, %v",
+				name, tc.corporation, tc.alliance, corporation, alliance)
+		}
+	}
+}
+
+func TestSetAffiliation(t *testing.T) {
+	corp := &Name{ID: 10}
+	for name, tc := range map[string]struct {
+		row                   *CharacterRow
+		aff                   *Affiliation
+		corporation, alliance int32
+	}{
+		"left alliance": {
+			&CharacterRow{CorporationID: 10, AllianceID: 20},
+			&Affiliation{Corporation: corp},
+			10,
+			0,
+		},
+		"unresolved alliance": {
+			&CharacterRow{CorporationID: 11, AllianceID: 20},
+			&Affiliation{Corporation: corp, AllianceUnresolved: true},
+			11,
+			20,
+		},
+		"new with unresolved alliance": {
+			&CharacterRow{},
+			&Affiliation{Corporation: corp, AllianceUnresolved: true},
+			10,
+			0,
+		},
+		"deleted": {
+			&CharacterRow{CorporationID: 11, AllianceID: 20},
+			&Affiliation{Deleted: true},
+			11,
+			20,
+		},
+	} {
+		setAffiliation(tc.row, tc.aff)
+		if tc.row.CorporationID != tc.corporation ||
+			tc.row.AllianceID != tc.alliance {
+			t.Errorf("%s: expected %d, %d, received %d, %d",
+				name, tc.corporation, tc.alliance,
+				tc.row.CorporationID, tc.row.AllianceID)
+		}
+	}
+}
@@ -22,7 +22,10 @@ type Contract struct {
 
 	// Location is the station or structure ID
 	Location int64 `db:"location" json:"location"`
-	// TODO: resolve locationID to a name (or system name)
+
+	// LocationName is the name of the station or structure, empty until it
+	// is resolved
+	LocationName string `db:"location_name" json:"location_name"`
 
 	// Issued timestamp
 	Issued time.Time `db:"issued" json:"issued"`
@@ -33,14 +36,63 @@ type Contract struct {
 	// Accepted boolean
 	Accepted bool `db:"accepted" json:"accepted"`
 
+	// Status is the last seen ESI status of the contract
+	Status string `db:"status" json:"status"`
+
 	// Value is an estimated value of the contract items
-	Value float64 `db:"value" json:"value"`
+	Value ISK `db:"value" json:"value"`
 
 	// Note is the title of the contract
 	Note string `db:"note" json:"note"`
 
 	// Items is an array of items in the contract
 	Items []*Item `json:"items"`
+
+	// ContainsPlex is set when any of the items are PLEX
+	ContainsPlex bool `db:"contains_plex" json:"contains_plex"`
+
+	// NotableItems is the number of PLEX, skill injectors and extractors in
+	// the contract
+	NotableItems int32 `db:"notable_items" json:"notable_items"`
+
+	// Counterparty is the donator of received contracts, and the receiver of
+	// sent ones, on character details
+	Counterparty *Party `db:"-" json:"counterparty,omitempty"`
+
+	// Hidden is set when the counterparty is banned, it is left out along
+	// with the note
+	Hidden bool `db:"-" json:"hidden,omitempty"`
+
+	// TerminalAt is when the contract was first seen ended unaccepted
+	TerminalAt pq.NullTime `db:"terminal_at" json:"-"`
+
+	// AgedOut is the longest window the accepted contract is out of the
+	// totals of, it is still in the totals of longer ones
+	AgedOut Window `db:"aged_days" json:"-"`
+}
+
+// ContractExpired is the status of outstanding contracts past their expiry,
+// which ESI leaves outstanding
+const ContractExpired = "expired"
+
+// IsTerminal is true for the statuses of contracts which can no longer be
+// accepted, unless they were
+func IsTerminal(status string) bool {
+	switch status {
+	case ContractExpired, "rejected", "cancelled", "failed", "deleted",
+		"reversed":
+		return true
+	}
+	return false
+}
+
+// ContractStatus returns the status to store of a contract with the ESI
+// status, expiring outstanding contracts
+func ContractStatus(status string, expires time.Time, now time.Time) string {
+	if status == "outstanding" && expires.Before(now) {
+		return ContractExpired
+	}
+	return status
 }
 
 // Contracts are time sorted
@@ -96,7 +148,8 @@ func getContracts(ctx context.Context, charID int32, key cx.Key) (
 	contracts := Contracts{}
 	for _, i := range res {
 		c := i.(*Contract)
-		c.Value = round2(c.Value)
+		c.Issued = c.Issued.UTC()
+		c.Expires = c.Expires.UTC()
 		contracts = append(contracts, c)
 	}
 
@@ -106,9 +159,60 @@ func getContracts(ctx context.Context, charID int32, key cx.Key) (
 	return contracts, itemErr
 }
 
-// GetStaleContracts returns contracts issued more than 30 days ago
-func GetStaleContracts(ctx context.Context) (Contracts, error) {
-	rows, err := queryNamedResult(ctx, cx.StmtGetStaleContracts, nil)
+// EachContract passes every contract received (or sent) by the character to fn
+func EachContract(
+	ctx context.Context,
+	charID int32,
+	received bool,
+	fn func(*Contract) error,
+) error {
+	key := cx.StmtCharContracted
+	if received {
+		key = cx.StmtCharContracts
+	}
+
+	rows, err := queryNamedResult(ctx, key, map[string]interface{}{
+		"character_id": charID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return each(rows, func() interface{} { return &Contract{} },
+		func(i interface{}) error {
+			c := i.(*Contract)
+			c.Issued = c.Issued.UTC()
+			c.Expires = c.Expires.UTC()
+			if err := GetContractItems(ctx, Contracts{c}); err != nil {
+				return err
+			}
+			return fn(c)
+		},
+	)
+}
+
+// GetStaleContracts returns accepted contracts issued longer ago than the
+// window which are still in its totals
+func GetStaleContracts(ctx context.Context, window Window) (Contracts, error) {
+	return getMaintainedContracts(
+		ctx,
+		cx.StmtGetStaleContracts,
+		map[string]interface{}{"days": int(window)},
+	)
+}
+
+// GetTerminalContracts returns unaccepted contracts which ended, issued more
+// than 90 days ago
+func GetTerminalContracts(ctx context.Context) (Contracts, error) {
+	return getMaintainedContracts(ctx, cx.StmtGetTerminalContracts, nil)
+}
+
+func getMaintainedContracts(
+	ctx context.Context,
+	key cx.Key,
+	values map[string]interface{},
+) (Contracts, error) {
+	rows, err := queryNamedResult(ctx, key, values)
 	if err != nil {
 		return nil, err
 	}
@@ -125,7 +229,57 @@ func GetStaleContracts(ctx context.Context) (Contracts, error) {
 	return contracts, nil
 }
 
-// PruneContract removes a contract and deducts from the 30d totals
+// AgeContracts marks the stale accepted contracts out of the totals of the
+// window, and removes them from the totals of both characters, in one
+// transaction. They are kept for good
+func AgeContracts(
+	ctx context.Context,
+	contracts Contracts,
+	affiliations []*Affiliation,
+	window Window,
+) error {
+	return WithTx(ctx, func(ctx context.Context) error {
+		// before aging, so no totals are read between the two
+		unlock, err := LockTotals(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		aged := Contracts{}
+		for _, contract := range contracts {
+			count, err := executeNamedCount(
+				ctx,
+				cx.StmtAgeContract,
+				map[string]interface{}{
+					"contract_id": contract.ID,
+					"days":        int(window),
+				},
+			)
+			if err != nil {
+				return err
+			}
+			// aged by another run since it was read
+			if count > 0 {
+				aged = append(aged, contract)
+			}
+		}
+
+		return AgeCharacterContracts(ctx, aged, affiliations, window)
+	})
+}
+
+// ExpireContracts marks the outstanding contracts past their expiry as
+// expired, for characters no longer polled
+func ExpireContracts(ctx context.Context) (int64, error) {
+	return executeNamedCount(
+		ctx,
+		cx.StmtExpireContracts,
+		map[string]interface{}{},
+	)
+}
+
+// PruneContract removes a contract with its items
 func PruneContract(ctx context.Context, c *Contract) error {
 	if err := executeContract(ctx, cx.StmtRemoveContract, c); err != nil {
 		return err
@@ -137,17 +291,22 @@ func executeContract(ctx context.Context, key cx.Key, c *Contract) error {
 	return executeNamed(ctx, key, map[string]interface{}{"contract_id": c.ID})
 }
 
-// GetOutstandingContracts returns a list of outstanding contract IDs
-func GetOutstandingContracts(ctx context.Context, c int32) ([]int32, error) {
-	contracts, err := getContracts(ctx, c, cx.StmtGetOutstandingContracts)
+// GetTrackedContracts returns up to 500 contracts received by the character
+// which are outstanding, or were accepted in the last 30 days, newest first,
+// keyed by contract ID
+func GetTrackedContracts(ctx context.Context, c int32) (
+	map[int32]*Contract,
+	error,
+) {
+	contracts, err := getContracts(ctx, c, cx.StmtGetTrackedContracts)
 	if err != nil {
 		return nil, err
 	}
-	outstandingIDs := []int32{}
+	tracked := map[int32]*Contract{}
 	for _, contract := range contracts {
-		outstandingIDs = append(outstandingIDs, contract.ID)
+		tracked[contract.ID] = contract
 	}
-	return outstandingIDs, nil
+	return tracked, nil
 }
 
 // GetContractItems fills in the Items of each Contract passed
@@ -182,42 +341,140 @@ func GetContractItems(
 
 // SaveContract saves the contract and associated items in the db
 func SaveContract(ctx context.Context, contract *Contract) error {
-	err := executeNamed(ctx, cx.StmtAddContract, map[string]interface{}{
+	err := executeNamed(ctx, cx.StmtAddContract, contractValues(contract))
+	if err != nil {
+		return err
+	}
+	if err := saveContractItems(ctx, contract.Items); err != nil {
+		return err
+	}
+	return classifyContract(ctx, contract)
+}
+
+// SaveNewContracts saves the contracts which are not already stored, with
+// their items, and returns them
+func SaveNewContracts(
+	ctx context.Context,
+	contracts []*Contract,
+) ([]*Contract, error) {
+	saved := []*Contract{}
+	for _, contract := range contracts {
+		added, err := executeNamedCount(
+			ctx,
+			cx.StmtAddNewContract,
+			contractValues(contract),
+		)
+		if err != nil {
+			return saved, err
+		}
+		if added == 0 {
+			continue
+		}
+		if err := saveContractItems(ctx, contract.Items); err != nil {
+			return saved, err
+		}
+		if err := classifyContract(ctx, contract); err != nil {
+			return saved, err
+		}
+		saved = append(saved, contract)
+	}
+	return saved, nil
+}
+
+func contractValues(contract *Contract) map[string]interface{} {
+	return map[string]interface{}{
 		"contract_id": contract.ID,
 		"donator":     contract.Donator,
 		"receiver":    contract.Receiver,
 		"location":    contract.Location,
-		"issued":      contract.Issued,
-		"expires":     contract.Expires,
+		"issued":      contract.Issued.UTC(),
+		"expires":     contract.Expires.UTC(),
 		"accepted":    contract.Accepted,
+		"status":      contract.Status,
 		"value":       contract.Value,
 		"note":        contract.Note,
-	})
-	if err != nil {
-		return err
+		"terminal_at": terminalAt(contract, time.Now()),
+	}
+}
+
+// terminalAt is when the contract ended unaccepted, now if it just did
+func terminalAt(contract *Contract, now time.Time) pq.NullTime {
+	if contract.Accepted || !IsTerminal(contract.Status) {
+		return pq.NullTime{}
 	}
-	return saveContractItems(ctx, contract.Items)
+	if contract.TerminalAt.Valid {
+		return contract.TerminalAt
+	}
+	return pq.NullTime{Time: now.UTC(), Valid: true}
 }
 
-// UpdateContracts sets the contract as accepted in the db, if it has been
+// contractTransition returns if a contract is accepted after moving to the
+// status, and if the totals should be added to (1), removed from (-1) or not
+// changed (0). Contracts only count once accepted, and only need removing if
+// they were accepted before being deleted or reversed
+func contractTransition(accepted bool, status string) (bool, int) {
+	switch status {
+	case "finished":
+		if !accepted {
+			return true, 1
+		}
+	case "deleted", "reversed":
+		if accepted {
+			return false, -1
+		}
+		return false, 0
+	}
+	return accepted, 0
+}
+
+// UpdateContracts applies ESI status changes to stored contracts. Each
+// contract passed should be as stored, with Status set to the new status
 func UpdateContracts(
 	ctx context.Context,
 	contracts []*Contract,
 	aff []*Affiliation,
 ) error {
-	if err := SaveCharacterContracts(ctx, contracts, aff, true); err != nil {
+	added := Contracts{}
+	removed := Contracts{}
+
+	for _, contract := range contracts {
+		accepted, change := contractTransition(contract.Accepted, contract.Status)
+		contract.Accepted = accepted
+		if change > 0 {
+			added = append(added, contract)
+		} else if change < 0 {
+			removed = append(removed, contract)
+		}
+	}
+
+	if err := SaveCharacterContracts(ctx, added, aff); err != nil {
 		return err
 	}
 
+	if err := ReverseCharacterContracts(ctx, removed, aff); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
 	for _, contract := range contracts {
-		if err := executeNamed(ctx, cx.StmtAcceptContract, map[string]interface{}{
+		if err := executeNamed(ctx, cx.StmtSetContractStatus, map[string]interface{}{
 			"contract_id":  contract.ID,
 			"character_id": contract.Receiver,
+			"accepted":     contract.Accepted,
+			"status":       contract.Status,
+			"terminal":     terminalAt(contract, now).Valid,
+			"now":          now,
 		}); err != nil {
 			return err
 		}
 	}
 
+	// a contract no longer accepted may have been its receiver's largest
+	for _, contract := range removed {
+		if err := recalculateLargest(ctx, contract.Receiver); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -237,25 +494,26 @@ func saveContractItems(ctx context.Context, items []*Item) error {
 	return nil
 }
 
-// addToContractTotals adds donation/received totals from contracts
+// addToContractTotals adds donation/received totals of every window from
+// contracts
 func addToContractTotals(contract *Contract, characters ...[]*CharacterRow) {
+	countTransfer(
+		contract.Donator,
+		contract.Receiver,
+		contract.Value,
+		1,
+		allWindows(),
+		characters...,
+	)
 	for _, chars := range characters {
 		for _, char := range chars {
 			if char.ID == contract.Donator {
-				char.DonatedISK += contract.Value
-				char.Donated++
-				char.DonatedISK30 += contract.Value
-				char.Donated30++
 				if !char.LastDonated.Valid || char.LastDonated.Time.Before(
 					contract.Issued) {
 					char.LastDonated = pq.NullTime{Time: contract.Issued, Valid: true}
 					char.LastDonated.Valid = true
 				}
 			} else if char.ID == contract.Receiver {
-				char.ReceivedISK += contract.Value
-				char.Received++
-				char.ReceivedISK30 += contract.Value
-				char.Received30++
 				if !char.LastReceived.Valid || char.LastReceived.Time.Before(
 					contract.Issued) {
 					char.LastReceived = pq.NullTime{Time: contract.Issued, Valid: true}
@@ -266,17 +524,32 @@ func addToContractTotals(contract *Contract, characters ...[]*CharacterRow) {
 	}
 }
 
-// removeFromContractTotals removes donation/received totals from contracts
-func removeFromContractTotals(contract *Contract, chars ...[]*CharacterRow) {
-	for _, characters := range chars {
-		for _, char := range characters {
-			if char.ID == contract.Donator {
-				char.DonatedISK30 -= contract.Value
-				char.Donated30--
-			} else if char.ID == contract.Receiver {
-				char.ReceivedISK30 -= contract.Value
-				char.Received30--
-			}
-		}
-	}
+// reverseContractTotals removes lifetime totals from contracts, and those of
+// each window the contract has not aged out of
+func reverseContractTotals(contract *Contract, chars ...[]*CharacterRow) {
+	countTransfer(
+		contract.Donator,
+		contract.Receiver,
+		contract.Value,
+		-1,
+		countedWindows(contract.AgedOut),
+		chars...,
+	)
+}
+
+// ageContractTotals removes donation/received totals of the window from
+// contracts, and of the shorter windows the contract had not aged out of yet
+func ageContractTotals(
+	contract *Contract,
+	window Window,
+	chars ...[]*CharacterRow,
+) {
+	countTransfer(
+		contract.Donator,
+		contract.Receiver,
+		contract.Value,
+		-1,
+		agingWindows(contract.AgedOut, window),
+		chars...,
+	)
 }
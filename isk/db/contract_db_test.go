@@ -0,0 +1,244 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testSharedContract returns a contract from 2 to 1, with the status either
+// party's ESI view has of it
+func testSharedContract(status string) *Contract {
+	return &Contract{
+		ID:       1,
+		Donator:  2,
+		Receiver: 1,
+		Issued:   testAt,
+		Expires:  testAt.Add(24 * time.Hour),
+		Accepted: status == "finished",
+		Status:   status,
+		Value:    NewISK(2000),
+	}
+}
+
+// ingestContract saves the contract as the viewer's contract run does,
+// adding it to the totals if it is new, or updating its status if the viewer
+// received it and it is stored already
+func ingestContract(
+	t *testing.T,
+	ctx context.Context,
+	viewer int32,
+	contract *Contract,
+) {
+	affiliations := testAffiliations(t, ctx)
+
+	tracked, err := GetTrackedContracts(ctx, viewer)
+	if err != nil {
+		t.Fatalf("failed to get tracked contracts: %+v", err)
+	}
+	if stored, found := tracked[contract.ID]; found {
+		stored.Status = contract.Status
+		err := UpdateContracts(ctx, Contracts{stored}, affiliations)
+		if err != nil {
+			t.Fatalf("failed to update contract: %+v", err)
+		}
+		return
+	}
+
+	saved, err := SaveNewContracts(ctx, Contracts{contract})
+	if err != nil {
+		t.Fatalf("failed to save contract: %+v", err)
+	}
+	if err := SaveCharacterContracts(ctx, saved, affiliations); err != nil {
+		t.Fatalf("failed to count contract: %+v", err)
+	}
+}
+
+func TestContractFromBothSidesDB(t *testing.T) {
+	// the status each of the donator and recipient sees, in the order seen
+	for name, views := range map[string][]struct {
+		viewer int32
+		status string
+	}{
+		"donator first":            {{2, "outstanding"}, {1, "finished"}},
+		"recipient first":          {{1, "finished"}, {2, "finished"}},
+		"donator sees it accepted": {{2, "finished"}, {1, "finished"}},
+		"recipient sees it pending": {
+			{1, "outstanding"},
+			{2, "finished"},
+			{1, "finished"},
+		},
+	} {
+		ctx := testDB(t)
+		for _, view := range views {
+			ingestContract(t, ctx, view.viewer, testSharedContract(view.status))
+		}
+
+		recipient := getTestCharacter(t, ctx, 1)
+		if recipient.Received != 1 || recipient.ReceivedISK != NewISK(2000) {
+			t.Errorf("%s: unexpected recipient totals %+v", name, recipient)
+		}
+		donator := getTestCharacter(t, ctx, 2)
+		if donator.Donated != 1 || donator.DonatedISK != NewISK(2000) {
+			t.Errorf("%s: unexpected donator totals %+v", name, donator)
+		}
+	}
+}
+
+func TestContractCleanupDB(t *testing.T) {
+	ctx := testDB(t)
+	affiliations := testAffiliations(t, ctx)
+
+	now := time.Now().UTC()
+	contract := func(id int32, days int, status string) *Contract {
+		issued := now.AddDate(0, 0, -days)
+		return &Contract{
+			ID:       id,
+			Donator:  2,
+			Receiver: 1,
+			Issued:   issued,
+			Expires:  issued.Add(24 * time.Hour),
+			Accepted: status == "finished",
+			Status:   status,
+			Value:    NewISK(1000),
+		}
+	}
+	contracts := Contracts{
+		contract(1, 100, "finished"),
+		contract(2, 100, "rejected"),
+		// left outstanding past its expiry
+		contract(3, 100, "outstanding"),
+		contract(4, 40, "rejected"),
+		contract(5, 10, "finished"),
+	}
+	loadContracts(t, ctx, contracts...)
+	if err := SaveCharacterContracts(
+		ctx,
+		contracts,
+		affiliations,
+	); err != nil {
+		t.Fatalf("failed to count contracts: %+v", err)
+	}
+
+	expired, err := ExpireContracts(ctx)
+	if err != nil || expired != 1 {
+		t.Fatalf("expected 1 contract expired, received %d: %+v", expired, err)
+	}
+
+	ids := func(contracts Contracts) []int32 {
+		found := []int32{}
+		for _, contract := range contracts {
+			found = append(found, contract.ID)
+		}
+		sort.Slice(found, func(i, j int) bool { return found[i] < found[j] })
+		return found
+	}
+
+	terminal, err := GetTerminalContracts(ctx)
+	if err != nil {
+		t.Fatalf("failed to get ended contracts: %+v", err)
+	}
+	if found := ids(terminal); !reflect.DeepEqual(found, []int32{2, 3}) {
+		t.Fatalf("expected contracts 2 and 3 ended, received %v", found)
+	}
+
+	stale, err := GetStaleContracts(ctx, WindowMonth)
+	if err != nil {
+		t.Fatalf("failed to get stale contracts: %+v", err)
+	}
+	if found := ids(stale); !reflect.DeepEqual(found, []int32{1}) {
+		t.Fatalf("expected contract 1 stale, received %v", found)
+	}
+	// aging twice changes nothing
+	for i := 0; i < 2; i++ {
+		if err := AgeContracts(ctx, stale, affiliations, WindowMonth); err != nil {
+			t.Fatalf("failed to age contract: %+v", err)
+		}
+	}
+
+	// aged contracts stay out of the 30 day totals, not the 90 day ones
+	if err := RecalculateTotals(ctx); err != nil {
+		t.Fatalf("failed to recalculate totals: %+v", err)
+	}
+	recipient := getTestCharacter(t, ctx, 1)
+	if recipient.Received != 2 || recipient.Received30 != 1 ||
+		recipient.Received90 != 2 {
+		t.Errorf("unexpected recipient totals %+v", recipient)
+	}
+	if stale, err := GetStaleContracts(ctx, WindowMonth); err != nil ||
+		len(stale) != 0 {
+		t.Errorf("expected the aged contract kept, received %+v: %+v", stale, err)
+	}
+
+	stale, err = GetStaleContracts(ctx, 90)
+	if err != nil {
+		t.Fatalf("failed to get stale contracts: %+v", err)
+	}
+	if found := ids(stale); !reflect.DeepEqual(found, []int32{1}) {
+		t.Fatalf("expected contract 1 stale of 90 days, received %v", found)
+	}
+	if err := AgeContracts(ctx, stale, affiliations, 90); err != nil {
+		t.Fatalf("failed to age contract: %+v", err)
+	}
+	recipient = getTestCharacter(t, ctx, 1)
+	if recipient.Received != 2 || recipient.Received30 != 1 ||
+		recipient.Received90 != 1 {
+		t.Errorf("unexpected recipient totals after 90 days %+v", recipient)
+	}
+	if _, err := GetContract(ctx, 1); err != nil {
+		t.Errorf("expected the aged contract kept, received %+v", err)
+	}
+
+	// only unaccepted contracts which ended are no longer listed
+	received, err := getCharContracts(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get contracts: %+v", err)
+	}
+	if found := ids(received); !reflect.DeepEqual(found, []int32{1, 5}) {
+		t.Errorf("expected contracts 1 and 5 listed, received %v", found)
+	}
+}
+
+func TestGetTrackedContractsDB(t *testing.T) {
+	ctx := testDB(t)
+
+	now := time.Now().UTC()
+	contract := func(id int32, days int, status string) *Contract {
+		issued := now.AddDate(0, 0, -days)
+		return &Contract{
+			ID:       id,
+			Donator:  2,
+			Receiver: 1,
+			Issued:   issued,
+			Expires:  issued.Add(90 * 24 * time.Hour),
+			Accepted: status == "finished",
+			Status:   status,
+			Value:    NewISK(1000),
+		}
+	}
+	loadContracts(
+		t,
+		ctx,
+		// no longer listed by ESI
+		contract(1, 60, "finished"),
+		contract(2, 10, "finished"),
+		// listed while outstanding, however old
+		contract(3, 60, "outstanding"),
+		contract(4, 1, "in_progress"),
+		contract(5, 1, "rejected"),
+	)
+
+	tracked, err := GetTrackedContracts(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get tracked contracts: %+v", err)
+	}
+	if len(tracked) != 3 || tracked[2] == nil || tracked[3] == nil ||
+		tracked[4] == nil {
+		t.Errorf("expected contracts 2, 3 and 4 tracked, received %+v", tracked)
+	}
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestContractTransition(t *testing.T) {
+	cases := []struct {
+		accepted bool
+		status   string
+		expected bool
+		change   int
+	}{
+		{false, "finished", true, 1},
+		{true, "finished", true, 0},
+		{false, "outstanding", false, 0},
+		{false, "rejected", false, 0},
+		{false, "expired", false, 0},
+		// accepted then deleted
+		{true, "deleted", false, -1},
+		{true, "reversed", false, -1},
+		// deleted before we ever saw the accepted state
+		{false, "deleted", false, 0},
+		{false, "reversed", false, 0},
+	}
+
+	for _, c := range cases {
+		accepted, change := contractTransition(c.accepted, c.status)
+		if accepted != c.expected || change != c.change {
+			t.Errorf(
+				"%v -> %s: received (%v, %d), expected (%v, %d)",
+				c.accepted,
+				c.status,
+				accepted,
+				change,
+				c.expected,
+				c.change,
+			)
+		}
+	}
+}
+
+func TestReverseContractTotals(t *testing.T) {
+	contract := &Contract{ID: 1, Donator: 10, Receiver: 20, Value: 5000}
+	donator := &CharacterRow{ID: 10}
+	receiver := &CharacterRow{ID: 20}
+	chars := []*CharacterRow{donator, receiver}
+
+	addToContractTotals(contract, chars)
+	reverseContractTotals(contract, chars)
+
+	for _, char := range chars {
+		if !zeroTotals(char) {
+			t.Errorf("character %d totals not reversed: %+v", char.ID, char)
+		}
+	}
+}
+
+func TestReverseAgedContractTotals(t *testing.T) {
+	contract := &Contract{ID: 1, Donator: 10, Receiver: 20, Value: 5000}
+	donator := &CharacterRow{ID: 10}
+	chars := []*CharacterRow{donator}
+
+	// aged out of the 7 then 30 day totals before it was reversed
+	addToContractTotals(contract, chars)
+	for _, window := range []Window{7, 30} {
+		ageContractTotals(contract, window, chars)
+		contract.AgedOut = window
+	}
+	if donator.Donated30 != 0 || donator.Donated90 != 1 {
+		t.Errorf("unexpected totals of the aged contract: %+v", donator)
+	}
+	reverseContractTotals(contract, chars)
+
+	if !zeroTotals(donator) {
+		t.Errorf("aged contract not reversed once: %+v", donator)
+	}
+}
+
+func TestContractStatus(t *testing.T) {
+	now := time.Date(2019, 1, 20, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		status   string
+		expires  time.Time
+		expected string
+	}{
+		{"outstanding", now.Add(time.Hour), "outstanding"},
+		{"outstanding", now.Add(-time.Hour), ContractExpired},
+		{"finished", now.Add(-time.Hour), "finished"},
+		{"rejected", now.Add(-time.Hour), "rejected"},
+	}
+
+	for _, c := range cases {
+		status := ContractStatus(c.status, c.expires, now)
+		if status != c.expected {
+			t.Errorf(
+				"%s expiring %s: received %s, expected %s",
+				c.status,
+				c.expires,
+				status,
+				c.expected,
+			)
+		}
+	}
+}
+
+func TestTerminalAt(t *testing.T) {
+	now := time.Date(2019, 1, 20, 12, 0, 0, 0, time.UTC)
+	earlier := pq.NullTime{Time: now.Add(-time.Hour), Valid: true}
+
+	cases := map[*Contract]pq.NullTime{
+		{Status: "outstanding"}:                  {},
+		{Status: "finished", Accepted: true}:     {},
+		{Status: "reversed", Accepted: true}:     {},
+		{Status: "rejected"}:                     {Time: now, Valid: true},
+		{Status: ContractExpired}:                {Time: now, Valid: true},
+		{Status: "deleted", TerminalAt: earlier}: earlier,
+	}
+
+	for contract, expected := range cases {
+		if at := terminalAt(contract, now); at != expected {
+			t.Errorf("%+v: received %+v, expected %+v", contract, at, expected)
+		}
+	}
+}
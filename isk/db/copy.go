@@ -0,0 +1,192 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// CopyNewDonations stores the donations which are new, as SaveNewDonations
+// does, with a single COPY. Backfills use it for the thousands of donations
+// of a whole journal
+func CopyNewDonations(
+	ctx context.Context,
+	donations []*Donation,
+) ([]*Donation, error) {
+	rows := [][]interface{}{}
+	for _, donation := range donations {
+		rows = append(rows, []interface{}{
+			donation.ID,
+			donation.Donator,
+			donation.Recipient,
+			donation.Timestamp.UTC(),
+			donation.Note,
+			donation.Amount,
+			donation.SelfDonation,
+		})
+	}
+
+	columns := []string{
+		"transaction_id",
+		"donator",
+		"receiver",
+		"timestamp",
+		"note",
+		"amount",
+		"self_donation",
+	}
+	var copied map[int64]bool
+	err := transaction(ctx, func(tx *sqlx.Tx) error {
+		var err error
+		copied, err = copyNew(
+			ctx,
+			tx,
+			"donations",
+			"transaction_id",
+			"donations_archive",
+			columns,
+			rows,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	saved := []*Donation{}
+	for _, donation := range donations {
+		if copied[donation.ID] {
+			saved = append(saved, donation)
+		}
+	}
+	return saved, nil
+}
+
+// CopyNewContracts stores the contracts which are new, as SaveNewContracts
+// does, copying the items of all of them with a single COPY
+func CopyNewContracts(
+	ctx context.Context,
+	contracts []*Contract,
+) ([]*Contract, error) {
+	saved := []*Contract{}
+	rows := [][]interface{}{}
+	for _, contract := range contracts {
+		added, err := executeNamedCount(
+			ctx,
+			cx.StmtAddNewContract,
+			contractValues(contract),
+		)
+		if err != nil {
+			return saved, err
+		}
+		if added == 0 {
+			continue
+		}
+		saved = append(saved, contract)
+		for _, item := range contract.Items {
+			rows = append(rows, []interface{}{
+				item.ID,
+				item.ContractID,
+				item.TypeID,
+				0, // XXX replace once item IDs are in all contract endpoints
+				item.Quantity,
+			})
+		}
+	}
+
+	if err := transaction(ctx, func(tx *sqlx.Tx) error {
+		_, err := copyNew(ctx, tx, "contractitems", "id", "", []string{
+			"id",
+			"contract_id",
+			"type_id",
+			"item_id",
+			"quantity",
+		}, rows)
+		return err
+	}); err != nil {
+		return saved, err
+	}
+
+	for _, contract := range saved {
+		if err := classifyContract(ctx, contract); err != nil {
+			return saved, err
+		}
+	}
+	return saved, nil
+}
+
+// copyNew copies the rows of the columns into the table, skipping those of
+// a key already stored, or in the archive table when there is one, returning
+// the keys of those inserted
+func copyNew(
+	ctx context.Context,
+	tx *sqlx.Tx,
+	table, key, archive string,
+	columns []string,
+	rows [][]interface{},
+) (map[int64]bool, error) {
+	copied := map[int64]bool{}
+	if len(rows) == 0 {
+		return copied, nil
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		fmt.Sprintf(queryCopyTable, table),
+	); err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("copy_"+table, columns...))
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			stmt.Close()
+			return nil, err
+		}
+	}
+	// flushes the copied rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return nil, err
+	}
+	if err := stmt.Close(); err != nil {
+		return nil, err
+	}
+
+	quoted := []string{}
+	for _, column := range columns {
+		quoted = append(quoted, pq.QuoteIdentifier(column))
+	}
+	unarchived := ""
+	if archive != "" {
+		unarchived = fmt.Sprintf(queryCopiedUnarchived, table, archive, key)
+	}
+	inserted, err := tx.QueryxContext(ctx, fmt.Sprintf(
+		queryInsertCopied,
+		table,
+		strings.Join(quoted, ", "),
+		key,
+		unarchived,
+	))
+	if err != nil {
+		return nil, err
+	}
+	defer inserted.Close()
+
+	for inserted.Next() {
+		id := int64(0)
+		if err := inserted.Scan(&id); err != nil {
+			return nil, err
+		}
+		copied[id] = true
+	}
+	return copied, inserted.Err()
+}
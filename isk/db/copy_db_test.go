@@ -0,0 +1,129 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// testJournal returns count donations from 2 to 1, an hour apart from testAt
+func testJournal(first int64, count int) []*Donation {
+	donations := []*Donation{}
+	for i := 0; i < count; i++ {
+		donation := testDonation(first+int64(i), i, 1000)
+		donation.Note = "thanks :)"
+		donations = append(donations, donation)
+	}
+	return donations
+}
+
+func TestCopyNewDonationsDB(t *testing.T) {
+	ctx := testDB(t)
+
+	donations := testJournal(1, 10)
+	loadDonations(t, ctx, donations[:4]...)
+
+	saved, err := CopyNewDonations(ctx, donations)
+	if err != nil {
+		t.Fatalf("failed to copy donations: %+v", err)
+	}
+	if len(saved) != 6 || saved[0].ID != 5 || saved[5].ID != 10 {
+		t.Fatalf("expected donations 5 to 10 copied, received %+v", saved)
+	}
+
+	copied, err := GetDonation(ctx, 7)
+	if err != nil {
+		t.Fatalf("failed to get copied donation: %+v", err)
+	}
+	if !copied.Timestamp.Equal(donations[6].Timestamp) ||
+		copied.Amount != donations[6].Amount || copied.Note != "thanks :)" ||
+		copied.VoidedAt.Valid || copied.RefundID.Valid {
+		t.Errorf("unexpected copied donation %+v", copied.Donation)
+	}
+
+	if saved, err := CopyNewDonations(ctx, donations); err != nil ||
+		len(saved) != 0 {
+		t.Errorf("expected nothing copied again, received %+v: %+v", saved, err)
+	}
+}
+
+func TestCopyNewContractsDB(t *testing.T) {
+	ctx := testDB(t)
+
+	contract := func(id int32, items ...*Item) *Contract {
+		for i, item := range items {
+			item.ID = int64(id)*10 + int64(i)
+			item.ContractID = id
+		}
+		return &Contract{
+			ID:       id,
+			Donator:  2,
+			Receiver: 1,
+			Issued:   testAt,
+			Expires:  testAt.Add(time.Hour),
+			Accepted: true,
+			Status:   "finished",
+			Items:    items,
+		}
+	}
+	stored := contract(1, &Item{TypeID: 34, Quantity: 1})
+	loadContracts(t, ctx, stored)
+
+	saved, err := CopyNewContracts(ctx, []*Contract{
+		contract(1, &Item{TypeID: 34, Quantity: 1}),
+		contract(2, &Item{TypeID: PLEXTypeID, Quantity: 500}),
+		contract(3),
+	})
+	if err != nil {
+		t.Fatalf("failed to copy contracts: %+v", err)
+	}
+	if len(saved) != 2 || saved[0].ID != 2 || saved[1].ID != 3 {
+		t.Fatalf("expected contracts 2 and 3 copied, received %+v", saved)
+	}
+
+	copied, err := GetContract(ctx, 2)
+	if err != nil {
+		t.Fatalf("failed to get copied contract: %+v", err)
+	}
+	if len(copied.Items) != 1 || copied.Items[0].Quantity != 500 ||
+		!copied.ContainsPlex || copied.NotableItems != 500 {
+		t.Errorf("unexpected copied contract %+v", copied.Contract)
+	}
+}
+
+// TestCopySpeedupDB logs how much faster a backfill sized journal is copied
+// than saved row by row
+func TestCopySpeedupDB(t *testing.T) {
+	const count = 10000
+
+	rowCtx := testDB(t)
+	start := time.Now()
+	if _, err := SaveNewDonations(rowCtx, testJournal(1, count)); err != nil {
+		t.Fatalf("failed to save donations: %+v", err)
+	}
+	rowByRow := time.Since(start)
+
+	copyCtx := testDB(t)
+	start = time.Now()
+	saved, err := CopyNewDonations(copyCtx, testJournal(1, count))
+	if err != nil {
+		t.Fatalf("failed to copy donations: %+v", err)
+	}
+	copied := time.Since(start)
+	if len(saved) != count {
+		t.Fatalf("expected %d donations copied, received %d", count, len(saved))
+	}
+
+	t.Logf(
+		"%d donations saved row by row in %s, copied in %s, %.1fx faster",
+		count,
+		rowByRow.Round(time.Millisecond),
+		copied.Round(time.Millisecond),
+		rowByRow.Seconds()/copied.Seconds(),
+	)
+	if copied > rowByRow {
+		t.Errorf("copying took %s, longer than row by row %s", copied, rowByRow)
+	}
+}
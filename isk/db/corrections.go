@@ -0,0 +1,199 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// ErrDonationNotFound is returned when reading or voiding a donation which is
+// not stored, or is already void
+var ErrDonationNotFound = errors.New("donation not found")
+
+// Correction describes who corrected a donation and why, for the audit log
+type Correction struct {
+	Admin  string `json:"admin"`
+	Reason string `json:"reason"`
+}
+
+// check ensures the correction says who made it and why
+func (c *Correction) check() error {
+	if c.Admin == "" || c.Reason == "" {
+		return UserError{
+			Msg:  []byte("Corrections require an admin and a reason"),
+			Code: 400,
+		}
+	}
+	return nil
+}
+
+// VoidDonation flags the donation as void and removes it from the totals of
+// both characters. Voided donations are kept, but no longer shown or counted
+func VoidDonation(
+	ctx context.Context,
+	transactionID int64,
+	c *Correction,
+) (*Donation, error) {
+	return correctDonation(ctx, c, "void_donation", reverseTotals,
+		func(tx *sqlx.Tx) (*Donation, error) {
+			return txDonation(ctx, tx, cx.StmtVoidDonation, map[string]interface{}{
+				"transaction_id": transactionID,
+			})
+		},
+	)
+}
+
+// AdjustDonation stores a donation entered by an admin, such as the correct
+// amount of one which was voided, and adds it to the totals of both
+// characters. Both characters must already be known
+func AdjustDonation(
+	ctx context.Context,
+	donation *Donation,
+	c *Correction,
+) (*Donation, error) {
+	if donation.Amount <= 0 {
+		return nil, UserError{Msg: []byte("Amount must be positive"), Code: 400}
+	}
+	if donation.Donator == donation.Recipient {
+		return nil, UserError{
+			Msg:  []byte("Donator and receiver must differ"),
+			Code: 400,
+		}
+	}
+
+	return correctDonation(ctx, c, "adjust_donation", addToTotals,
+		func(tx *sqlx.Tx) (*Donation, error) {
+			return txDonation(ctx, tx, cx.StmtAddAdjustment, map[string]interface{}{
+				"donator":  donation.Donator,
+				"receiver": donation.Recipient,
+				"note":     donation.Note,
+				"amount":   donation.Amount,
+			})
+		},
+	)
+}
+
+// correctDonation saves the donation and applies it to the totals of both
+// characters, auditing the correction in the same transaction
+func correctDonation(
+	ctx context.Context,
+	c *Correction,
+	action string,
+	apply func(*Donation, ...[]*CharacterRow),
+	save func(*sqlx.Tx) (*Donation, error),
+) (*Donation, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	// serialized with the totals saved by workers
+	unlock, err := LockTotals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	var donation *Donation
+	charIDs := []int32{}
+
+	err = transaction(ctx, func(tx *sqlx.Tx) error {
+		var err error
+		if donation, err = save(tx); err != nil {
+			return err
+		}
+
+		for _, charID := range []int32{donation.Donator, donation.Recipient} {
+			// anonymized donators have no totals
+			if charID == 0 {
+				continue
+			}
+			char, err := txCharacterRow(ctx, tx, charID)
+			if err != nil {
+				return err
+			}
+
+			if !donation.SelfDonation || opts.CountSelf {
+				apply(donation, []*CharacterRow{char})
+			}
+			if err := executeNamedTx(
+				ctx,
+				tx,
+				cx.StmtUpdateCharacter,
+				characterValues(char),
+			); err != nil {
+				return err
+			}
+			if charID == donation.Recipient {
+				if err := keepReceivedTx(ctx, tx, char, donation); err != nil {
+					return err
+				}
+			}
+			charIDs = append(charIDs, charID)
+		}
+
+		return writeAuditTx(
+			ctx,
+			tx,
+			CorrectionActor(c),
+			action,
+			DonationTarget(donation.ID),
+			map[string]interface{}{
+				"donator":  donation.Donator,
+				"receiver": donation.Recipient,
+				"amount":   donation.Amount,
+				"reason":   c.Reason,
+			},
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := NotifyCharacters(ctx, charIDs); err != nil {
+		cx.Logf(ctx, "failed to notify character updates: %+v", err)
+	}
+	return donation, nil
+}
+
+// txDonation returns the donation returned by the statement
+func txDonation(
+	ctx context.Context,
+	tx *sqlx.Tx,
+	stmt cx.Key,
+	values map[string]interface{},
+) (*Donation, error) {
+	rows, err := queryNamedTx(ctx, tx, stmt, values)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &Donation{} })
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range res {
+		d := i.(*Donation)
+		d.Timestamp = d.Timestamp.UTC()
+		return d, nil
+	}
+	return nil, ErrDonationNotFound
+}
+
+// txCharacterRow pulls a single character within the transaction
+func txCharacterRow(
+	ctx context.Context,
+	tx *sqlx.Tx,
+	charID int32,
+) (*CharacterRow, error) {
+	rows, err := queryNamedTx(ctx, tx, cx.StmtCharDetails, map[string]interface{}{
+		"character_id": charID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scanCharacterRow(rows)
+}
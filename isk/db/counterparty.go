@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+)
+
+// Party is the name and affiliation of the other character of a donation or
+// contract, as last checked
+type Party struct {
+	Name            string `json:"name,omitempty"`
+	CorporationID   int32  `json:"corporation,omitempty"`
+	CorporationName string `json:"corporation_name,omitempty"`
+	AllianceID      int32  `json:"alliance,omitempty"`
+	AllianceName    string `json:"alliance_name,omitempty"`
+}
+
+// addCounterparties sets the counterparty of every donation and contract of
+// the character, with one query for the characters and one for their names.
+// Banned counterparties are masked
+func (c *CharDetails) addCounterparties(ctx context.Context) error {
+	parties, err := getParties(ctx, c.counterpartyIDs())
+	if err != nil {
+		return err
+	}
+	c.setCounterparties(parties)
+	return c.maskBanned(ctx)
+}
+
+// getParties returns the parties of the known characters by ID, those unknown
+// or hidden are left out
+func getParties(ctx context.Context, charIDs []int32) (map[int32]*Party, error) {
+	ids := []int32{}
+	seen := map[int32]bool{}
+	for _, id := range charIDs {
+		if id > 0 && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	characters, err := GetCharacters(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	parties := map[int32]*Party{}
+	for _, char := range characters {
+		parties[char.ID] = &Party{
+			Name:            char.Name,
+			CorporationID:   char.CorporationID,
+			CorporationName: char.CorporationName,
+			AllianceID:      char.AllianceID,
+			AllianceName:    char.AllianceName,
+		}
+	}
+	return parties, nil
+}
+
+// counterpartyIDs returns the donators of everything the character received,
+// and the receivers of everything it sent
+func (c *CharDetails) counterpartyIDs() []int32 {
+	ids := []int32{}
+	for _, d := range c.Donations {
+		ids = append(ids, d.Donator)
+	}
+	for _, d := range c.Donated {
+		ids = append(ids, d.Recipient)
+	}
+	for _, k := range c.Contracts {
+		ids = append(ids, k.Donator)
+	}
+	for _, k := range c.Contracted {
+		ids = append(ids, k.Receiver)
+	}
+	return ids
+}
+
+// setCounterparties sets the parties of their characters, those unknown or
+// hidden are left without one
+func (c *CharDetails) setCounterparties(parties map[int32]*Party) {
+	for _, d := range c.Donations {
+		d.Counterparty = parties[d.Donator]
+	}
+	for _, d := range c.Donated {
+		d.Counterparty = parties[d.Recipient]
+	}
+	for _, k := range c.Contracts {
+		k.Counterparty = parties[k.Donator]
+	}
+	for _, k := range c.Contracted {
+		k.Counterparty = parties[k.Receiver]
+	}
+}
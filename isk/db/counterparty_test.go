@@ -0,0 +1,47 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testCharDetails() *CharDetails {
+	return &CharDetails{
+		Character: &Character{ID: 1},
+		Donations: Donations{
+			{Donator: 2, Recipient: 1},
+			{Donator: 0, Recipient: 1},
+		},
+		Donated:    Donations{{Donator: 1, Recipient: 3}},
+		Contracts:  Contracts{{Donator: 2, Receiver: 1}},
+		Contracted: Contracts{{Donator: 1, Receiver: 4}},
+	}
+}
+
+func TestCounterpartyIDs(t *testing.T) {
+	ids := testCharDetails().counterpartyIDs()
+	if expected := []int32{2, 0, 3, 2, 4}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected %v, received %v", expected, ids)
+	}
+}
+
+func TestSetCounterparties(t *testing.T) {
+	c := testCharDetails()
+	donator := &Party{Name: "Some Donator", CorporationID: 11}
+	recipient := &Party{Name: "Some Recipient", AllianceID: 20}
+	c.setCounterparties(map[int32]*Party{2: donator, 3: recipient})
+
+	if c.Donations[0].Counterparty != donator ||
+		c.Contracts[0].Counterparty != donator {
+		t.Errorf("expected the donator as the counterparty of received isk")
+	}
+	if c.Donated[0].Counterparty != recipient {
+		t.Errorf("expected the recipient as the counterparty of sent isk")
+	}
+
+	// anonymous, unknown and hidden characters have none
+	if c.Donations[1].Counterparty != nil ||
+		c.Contracted[0].Counterparty != nil {
+		t.Errorf("expected no counterparty of unknown characters")
+	}
+}
@@ -2,9 +2,12 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"sort"
 	"time"
 
+	"github.com/lib/pq"
+
 	"github.com/a-tal/esi-isk/isk/cx"
 )
 
@@ -26,7 +29,43 @@ type Donation struct {
 	Note string `db:"note" json:"note,omitempty"`
 
 	// Amount of ISK transferred
-	Amount float64 `db:"amount" json:"amount"`
+	Amount ISK `db:"amount" json:"amount"`
+
+	// SelfDonation is set when both characters are owned by the same account
+	SelfDonation bool `db:"self_donation" json:"self,omitempty"`
+
+	// Adjustment is set for donations entered by an admin, not from ESI
+	Adjustment bool `db:"adjustment" json:"adjustment,omitempty"`
+
+	// VoidedAt is when an admin voided the donation, or it was refunded, it
+	// is no longer counted
+	VoidedAt pq.NullTime `db:"voided_at" json:"-"`
+
+	// RefundID is the journal ref ID of the refund of the donation
+	RefundID sql.NullInt64 `db:"refund_id" json:"-"`
+
+	// AgedOut is the longest window the donation is out of the totals of, it
+	// is still in the totals of longer ones
+	AgedOut Window `db:"aged_days" json:"-"`
+
+	// DonorCorporationID and DonorAllianceID are the affiliation of the
+	// donator when the donation was counted, null if unknown. The alliance
+	// is 0 outside of one
+	DonorCorporationID sql.NullInt32 `db:"donor_corporation_id" json:"-"`
+	DonorAllianceID    sql.NullInt32 `db:"donor_alliance_id" json:"-"`
+
+	// RecipientCorporationID and RecipientAllianceID are the affiliation of
+	// the recipient when the donation was counted
+	RecipientCorporationID sql.NullInt32 `db:"recipient_corporation_id" json:"-"`
+	RecipientAllianceID    sql.NullInt32 `db:"recipient_alliance_id" json:"-"`
+
+	// Counterparty is the donator of received donations, and the recipient
+	// of sent ones, on character details
+	Counterparty *Party `db:"-" json:"counterparty,omitempty"`
+
+	// Hidden is set when the counterparty is banned, it is left out along
+	// with the note
+	Hidden bool `db:"-" json:"hidden,omitempty"`
 }
 
 // Donations are time sorted
@@ -48,23 +87,42 @@ func GetCharDonated(ctx context.Context, charID int32) (Donations, error) {
 	return getDonations(ctx, charID, cx.StmtCharDonated)
 }
 
-// GetCharStandingISK returns the amount donated towards improving standing
-func GetCharStandingISK(ctx context.Context, charID int32) (float64, error) {
-	donations, err := getDonations(ctx, charID, cx.StmtCharStandingISK)
+// GetDonationsSince returns up to limit donations to the character with IDs
+// after sinceID, oldest first
+func GetDonationsSince(
+	ctx context.Context,
+	charID int32,
+	sinceID int64,
+	limit int,
+) (Donations, error) {
+	rows, err := queryNamedResult(ctx, cx.StmtDonationsSince, map[string]interface{}{
+		"character_id": charID,
+		"since_id":     sinceID,
+		"limit":        limit,
+	})
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	total := float64(0)
-	for _, d := range donations {
-		total += d.Amount
+	res, err := scan(rows, func() interface{} { return &Donation{} })
+	if err != nil {
+		return nil, err
 	}
-	return total, nil
+	donations := Donations{}
+	for _, i := range res {
+		d := i.(*Donation)
+		d.Timestamp = d.Timestamp.UTC()
+		donations = append(donations, d)
+	}
+	return donations, nil
 }
 
-// GetStaleDonations returns donations from more than 30 days ago
-func GetStaleDonations(ctx context.Context) (Donations, error) {
-	rows, err := queryNamedResult(ctx, cx.StmtGetStaleDonations, nil)
+// GetStaleDonations returns donations older than the window which are still
+// in its totals, archived donations included
+func GetStaleDonations(ctx context.Context, window Window) (Donations, error) {
+	rows, err := queryNamedResult(ctx, cx.StmtGetStaleDonations, map[string]interface{}{
+		"days": int(window),
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -81,6 +139,47 @@ func GetStaleDonations(ctx context.Context) (Donations, error) {
 	return donations, nil
 }
 
+// AgeDonations marks the stale donations out of the totals of the window,
+// and removes them from the totals of both characters, in one transaction
+func AgeDonations(
+	ctx context.Context,
+	donations Donations,
+	affiliations []*Affiliation,
+	window Window,
+) error {
+	return WithTx(ctx, func(ctx context.Context) error {
+		// before aging, so no totals are read between the two
+		unlock, err := LockTotals(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		aged := Donations{}
+		for _, donation := range donations {
+			count := 0
+			if err := getNamedResult(
+				ctx,
+				cx.StmtAgeDonation,
+				&count,
+				map[string]interface{}{
+					"transaction_id": donation.ID,
+					"days":           int(window),
+				},
+			); err != nil {
+				return err
+			}
+			// aged by another run since it was read
+			if count == 0 {
+				continue
+			}
+			aged = append(aged, donation)
+		}
+
+		return AgeCharacterDonations(ctx, aged, affiliations, window)
+	})
+}
+
 func getDonations(ctx context.Context, charID int32, key cx.Key) (
 	Donations,
 	error,
@@ -100,7 +199,7 @@ func getDonations(ctx context.Context, charID int32, key cx.Key) (
 	donations := Donations{}
 	for _, i := range res {
 		d := i.(*Donation)
-		d.Amount = round2(d.Amount)
+		d.Timestamp = d.Timestamp.UTC()
 		donations = append(donations, d)
 	}
 
@@ -108,21 +207,112 @@ func getDonations(ctx context.Context, charID int32, key cx.Key) (
 	return donations, nil
 }
 
+// EachDonation passes every donation received (or sent) by the character to fn
+func EachDonation(
+	ctx context.Context,
+	charID int32,
+	received bool,
+	fn func(*Donation) error,
+) error {
+	key := cx.StmtCharDonated
+	if received {
+		key = cx.StmtCharDonations
+	}
+
+	rows, err := queryNamedResult(ctx, key, map[string]interface{}{
+		"character_id": charID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return each(rows, func() interface{} { return &Donation{} },
+		func(i interface{}) error {
+			d := i.(*Donation)
+			d.Timestamp = d.Timestamp.UTC()
+			return fn(d)
+		},
+	)
+}
+
 // SaveDonation stores a donation in the database
 func SaveDonation(ctx context.Context, donation *Donation) error {
-	return executeNamed(ctx, cx.StmtAddDonation, map[string]interface{}{
+	return executeNamed(ctx, cx.StmtAddDonation, donationValues(donation))
+}
+
+// SaveNewDonations saves the donations which are not already stored or
+// archived, and returns them
+func SaveNewDonations(
+	ctx context.Context,
+	donations []*Donation,
+) ([]*Donation, error) {
+	saved := []*Donation{}
+	for _, donation := range donations {
+		added, err := executeNamedCount(
+			ctx,
+			cx.StmtAddNewDonation,
+			donationValues(donation),
+		)
+		if err != nil {
+			return saved, err
+		}
+		if added > 0 {
+			saved = append(saved, donation)
+		}
+	}
+	return saved, nil
+}
+
+func donationValues(donation *Donation) map[string]interface{} {
+	return map[string]interface{}{
 		"transaction_id": donation.ID,
 		"donator":        donation.Donator,
 		"receiver":       donation.Recipient,
-		"timestamp":      donation.Timestamp,
+		"timestamp":      donation.Timestamp.UTC(),
 		"note":           donation.Note,
 		"amount":         donation.Amount,
-	})
+		"self_donation":  donation.SelfDonation,
+	}
 }
 
-// PruneDonation removes a donation by ID
-func PruneDonation(ctx context.Context, donation *Donation) error {
-	return executeNamed(ctx, cx.StmtRemoveDonation, map[string]interface{}{
-		"transaction_id": donation.ID,
-	})
+// MarkSelfDonations flags donations sent between characters of one account
+func MarkSelfDonations(ctx context.Context, donations []*Donation) error {
+	for _, donation := range donations {
+		count := 0
+		if err := getNamedResult(
+			ctx,
+			cx.StmtSameOwner,
+			&count,
+			map[string]interface{}{
+				"donator":  donation.Donator,
+				"receiver": donation.Recipient,
+			},
+		); err != nil {
+			return err
+		}
+		donation.SelfDonation = count > 0
+	}
+	return nil
+}
+
+// MinArchiveAge is the youngest donations may be archived at, in days. The
+// totals of windows up to it only read the donations table
+const MinArchiveAge = int(WindowMonth)
+
+// ArchiveDonations moves up to 1000 donations older than the days, and out of
+// the 30 day totals, to the archive. Lists of a character's donations read
+// both, the 7 and 30 day totals only the donations table. It returns how
+// many were archived
+func ArchiveDonations(ctx context.Context, days int) (int64, error) {
+	if days < MinArchiveAge {
+		days = MinArchiveAge
+	}
+	archived := int64(0)
+	err := getNamedResult(
+		ctx,
+		cx.StmtArchiveDonations,
+		&archived,
+		map[string]interface{}{"days": days},
+	)
+	return archived, err
 }
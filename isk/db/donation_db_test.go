@@ -0,0 +1,40 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetDonationsSinceDB(t *testing.T) {
+	ctx := testDB(t)
+
+	sent := testDonation(4, 3, 100)
+	sent.Donator, sent.Recipient = 1, 2
+	loadDonations(
+		t,
+		ctx,
+		testDonation(1, 0, 100),
+		testDonation(2, 1, 100),
+		testDonation(3, 2, 100),
+		sent,
+	)
+
+	donations, err := GetDonationsSince(ctx, 1, 1, 10)
+	if err != nil {
+		t.Fatalf("failed to get donations: %+v", err)
+	}
+	if ids := donationIDs(donations); !reflect.DeepEqual(ids, []int64{2, 3}) {
+		t.Errorf("expected the later donations oldest first, received %v", ids)
+	}
+
+	donations, err = GetDonationsSince(ctx, 1, 3, 10)
+	if err != nil {
+		t.Fatalf("failed to get donations: %+v", err)
+	}
+	if len(donations) != 0 {
+		t.Errorf("expected no donations, received %+v", donations)
+	}
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// donorCorporationsShown are the corporations shown of a breakdown, the
+// others are summed together
+const donorCorporationsShown = 20
+
+// DonorCorporation is the ISK donated to a character by the members of one
+// corporation
+type DonorCorporation struct {
+	ID        int32  `db:"corporation_id" json:"id"`
+	Name      string `db:"-" json:"name,omitempty"`
+	Logo      string `db:"-" json:"logo,omitempty"`
+	Donations int64  `db:"donations" json:"donations"`
+	ISK       ISK    `db:"isk" json:"isk"`
+}
+
+// OtherCorporations sums the donations of the corporations not shown, and
+// of donators of an unknown corporation
+type OtherCorporations struct {
+	// Corporations is how many known corporations are summed
+	Corporations int64 `json:"corporations"`
+	Donations    int64 `json:"donations"`
+	ISK          ISK   `json:"isk"`
+}
+
+// DonorCorporations is a character's received ISK by donator corporation
+type DonorCorporations struct {
+	Corporations []*DonorCorporation `json:"corporations"`
+	Other        *OtherCorporations  `json:"other"`
+}
+
+// GetDonorCorporations returns the ISK the character received by the
+// corporation of the donators, the top corporations and the rest summed
+func GetDonorCorporations(
+	ctx context.Context,
+	charID int32,
+) (*DonorCorporations, error) {
+	ctx = replicated(ctx)
+	rows, err := queryNamedResult(
+		ctx,
+		cx.StmtDonorCorporations,
+		map[string]interface{}{"character_id": charID},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &DonorCorporation{} })
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown := splitDonorCorporations(res)
+
+	ids := []int32{}
+	for _, corp := range breakdown.Corporations {
+		ids = append(ids, corp.ID)
+	}
+	names, err := getNamesIn(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	base := ctx.Value(cx.Opts).(*cx.Options).ImageServer
+	for _, corp := range breakdown.Corporations {
+		corp.Name = names[corp.ID]
+		corp.Logo = imageURL(base, "corporations", corp.ID, "logo")
+	}
+	return breakdown, nil
+}
+
+// splitDonorCorporations keeps the first known corporations of the rows, as
+// ranked, and sums the rest and the unknown corporation 0 as the other
+func splitDonorCorporations(rows []interface{}) *DonorCorporations {
+	breakdown := &DonorCorporations{
+		Corporations: []*DonorCorporation{},
+		Other:        &OtherCorporations{},
+	}
+	for _, i := range rows {
+		corp := i.(*DonorCorporation)
+		if corp.ID != 0 && len(breakdown.Corporations) < donorCorporationsShown {
+			breakdown.Corporations = append(breakdown.Corporations, corp)
+			continue
+		}
+		if corp.ID != 0 {
+			breakdown.Other.Corporations++
+		}
+		breakdown.Other.Donations += corp.Donations
+		breakdown.Other.ISK += corp.ISK
+	}
+	return breakdown
+}
@@ -0,0 +1,57 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"testing"
+)
+
+func TestDonorCorporationsDB(t *testing.T) {
+	ctx := testDB(t)
+	countDonations(t, ctx, testDonation(1, 0, 1000), testDonation(2, 1, 500))
+
+	// stored before the corporation was recorded
+	unknown := testDonation(3, 2, 200)
+	loadDonations(t, ctx, unknown)
+	if err := SaveCharacterDonations(
+		ctx,
+		[]*Donation{unknown},
+		[]*Affiliation{
+			{Character: &Name{ID: 1}},
+			{Character: &Name{ID: 2}},
+		},
+	); err != nil {
+		t.Fatalf("failed to count donation: %+v", err)
+	}
+
+	breakdown, err := GetDonorCorporations(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get donor corporations: %+v", err)
+	}
+	if len(breakdown.Corporations) != 1 {
+		t.Fatalf("expected 1 corporation, received %+v", breakdown.Corporations)
+	}
+	corp := breakdown.Corporations[0]
+	if corp.ID != 11 || corp.Name != "Donator Corporation" || corp.Logo == "" ||
+		corp.Donations != 2 || corp.ISK != NewISK(1500) {
+		t.Errorf("unexpected corporation %+v", corp)
+	}
+	if other := breakdown.Other; other.Corporations != 0 ||
+		other.Donations != 1 || other.ISK != NewISK(200) {
+		t.Errorf("unexpected other corporations %+v", other)
+	}
+
+	// anonymous donators aren't attributed
+	loadPreferences(t, ctx, 2)
+	if err := SetPrivacy(ctx, 2, &Privacy{Anonymous: true}); err != nil {
+		t.Fatalf("failed to set privacy: %+v", err)
+	}
+	breakdown, err = GetDonorCorporations(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get donor corporations: %+v", err)
+	}
+	if len(breakdown.Corporations) != 0 || breakdown.Other.Donations != 3 {
+		t.Errorf("expected every donation in other, received %+v", breakdown)
+	}
+}
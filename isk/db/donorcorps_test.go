@@ -0,0 +1,47 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestSplitDonorCorporations(t *testing.T) {
+	rows := []interface{}{}
+	for id := int32(1); id <= donorCorporationsShown+2; id++ {
+		rows = append(rows, &DonorCorporation{
+			ID:        id + 100,
+			Donations: 2,
+			ISK:       ISK(1000 - id),
+		})
+		if id == 3 {
+			// donators of an unknown corporation, ranked among the others
+			rows = append(rows, &DonorCorporation{Donations: 5, ISK: 990})
+		}
+	}
+
+	breakdown := splitDonorCorporations(rows)
+	if len(breakdown.Corporations) != donorCorporationsShown {
+		t.Fatalf("expected %d corporations, received %d",
+			donorCorporationsShown, len(breakdown.Corporations))
+	}
+	for i, corp := range breakdown.Corporations {
+		if corp.ID != int32(i+101) {
+			t.Errorf("%d: expected corporation %d, received %d",
+				i, i+101, corp.ID)
+		}
+	}
+
+	other := breakdown.Other
+	expected := ISK(990 + 1000 - donorCorporationsShown - 1 +
+		1000 - donorCorporationsShown - 2)
+	if other.Corporations != 2 || other.Donations != 9 || other.ISK != expected {
+		t.Errorf("unexpected other corporations %+v", other)
+	}
+}
+
+func TestSplitDonorCorporationsEmpty(t *testing.T) {
+	breakdown := splitDonorCorporations(nil)
+	if len(breakdown.Corporations) != 0 ||
+		*breakdown.Other != (OtherCorporations{}) {
+		t.Errorf("unexpected breakdown of no donations %+v", breakdown)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+// TestDB is testDB, for the handler tests of package db_test, which run the
+// api handlers against a test database
+var TestDB = testDB
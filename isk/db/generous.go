@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// Group is a kind of group characters are affiliated with
+type Group string
+
+// groups characters are ranked by
+const (
+	GroupCorporation = Group("corporation")
+	GroupAlliance    = Group("alliance")
+)
+
+// groupStatements are the statements ranking each group
+var groupStatements = map[Group]cx.Key{
+	GroupCorporation: cx.StmtGenerousCorporations,
+	GroupAlliance:    cx.StmtGenerousAlliances,
+}
+
+// groupImages are the image server categories of each group
+var groupImages = map[Group]string{
+	GroupCorporation: "corporations",
+	GroupAlliance:    "alliances",
+}
+
+// GenerousGroup is a corporation or alliance with the ISK its members donated
+type GenerousGroup struct {
+	ID   int32  `json:"id"`
+	Name string `json:"name"`
+	Logo string `json:"logo,omitempty"`
+
+	// DonatedISK is the total donated by the members
+	DonatedISK ISK `json:"donated_isk"`
+
+	// Donors is how many members donated
+	Donors int64 `json:"donors"`
+
+	// TopDonor is the member who donated the most, anonymous ones aside
+	TopDonor *Character `json:"top_donor,omitempty"`
+}
+
+type generousRow struct {
+	ID         int32         `db:"group_id"`
+	DonatedISK ISK           `db:"donated_isk"`
+	Donors     int64         `db:"donors"`
+	TopDonor   sql.NullInt64 `db:"top_donor"`
+}
+
+// GetGenerous returns a page of the groups whose current members donated the
+// most over the window, most first
+func GetGenerous(
+	ctx context.Context,
+	group Group,
+	window Window,
+	limit, offset int,
+) ([]*GenerousGroup, error) {
+	ctx = replicated(ctx)
+	rows, err := queryNamedResult(
+		ctx,
+		groupStatements[group],
+		map[string]interface{}{
+			"window": int(window),
+			"limit":  limit,
+			"offset": offset,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &generousRow{} })
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int32{}
+	donorIDs := []int32{}
+	for _, i := range res {
+		row := i.(*generousRow)
+		ids = append(ids, row.ID)
+		if row.TopDonor.Valid {
+			donorIDs = append(donorIDs, int32(row.TopDonor.Int64))
+		}
+	}
+
+	names, err := getNamesIn(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	donors, err := GetCharacters(ctx, donorIDs)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[int32]*Character{}
+	for _, c := range donors {
+		byID[c.ID] = c
+	}
+
+	base := ctx.Value(cx.Opts).(*cx.Options).ImageServer
+	generous := []*GenerousGroup{}
+	for _, i := range res {
+		row := i.(*generousRow)
+		generous = append(generous, &GenerousGroup{
+			ID:         row.ID,
+			Name:       names[row.ID],
+			Logo:       imageURL(base, groupImages[group], row.ID, "logo"),
+			DonatedISK: row.DonatedISK,
+			Donors:     row.Donors,
+			TopDonor:   byID[int32(row.TopDonor.Int64)],
+		})
+	}
+	return generous, nil
+}
@@ -0,0 +1,75 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"testing"
+)
+
+func TestGetGenerousDB(t *testing.T) {
+	ctx := testDB(t)
+	testAffiliations(t, ctx)
+
+	member := func(id, corpID, allianceID int32, isk float64) *CharacterRow {
+		return &CharacterRow{
+			ID:            id,
+			CorporationID: corpID,
+			AllianceID:    allianceID,
+			DonatedISK:    NewISK(isk),
+			GoodStanding:  true,
+		}
+	}
+	loadCharacters(
+		t,
+		ctx,
+		member(1, 10, 0, 1000),
+		member(2, 11, 20, 3000),
+		member(3, 11, 20, 5000),
+		member(4, 11, 20, 0),
+	)
+	loadPreferences(t, ctx, 3)
+	if err := SetPrivacy(ctx, 3, &Privacy{Anonymous: true}); err != nil {
+		t.Fatalf("failed to set privacy: %+v", err)
+	}
+
+	corps, err := GetGenerous(ctx, GroupCorporation, WindowAll, 10, 0)
+	if err != nil {
+		t.Fatalf("failed to get corporations: %+v", err)
+	}
+	if len(corps) != 2 {
+		t.Fatalf("expected 2 corporations, received %d", len(corps))
+	}
+	top := corps[0]
+	if top.ID != 11 || top.Name != "Donator Corporation" || top.Logo == "" ||
+		top.DonatedISK != NewISK(8000) || top.Donors != 2 {
+		t.Errorf("unexpected top corporation %+v", top)
+	}
+	// the anonymous member is left out of the top donor
+	if top.TopDonor == nil || top.TopDonor.ID != 2 {
+		t.Errorf("unexpected top donor %+v", top.TopDonor)
+	}
+	if corps[1].ID != 10 || corps[1].TopDonor == nil ||
+		corps[1].TopDonor.ID != 1 {
+		t.Errorf("unexpected second corporation %+v", corps[1])
+	}
+
+	alliances, err := GetGenerous(ctx, GroupAlliance, WindowAll, 10, 0)
+	if err != nil {
+		t.Fatalf("failed to get alliances: %+v", err)
+	}
+	if len(alliances) != 1 || alliances[0].ID != 20 ||
+		alliances[0].Name != "Donator Alliance" ||
+		alliances[0].DonatedISK != NewISK(8000) {
+		t.Errorf("unexpected alliances %+v", alliances)
+	}
+
+	// nothing was donated in the month
+	corps, err = GetGenerous(ctx, GroupCorporation, WindowMonth, 10, 0)
+	if err != nil {
+		t.Fatalf("failed to get corporations: %+v", err)
+	}
+	if len(corps) != 0 {
+		t.Errorf("expected no corporations of the month, received %+v", corps)
+	}
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// Bucket counts donations between two ISK amounts
+type Bucket struct {
+	Label string `json:"label"`
+	Min   ISK    `json:"min"`
+	Max   ISK    `json:"max,omitempty"`
+	Count int64  `json:"count"`
+}
+
+// Histogram counts the donations received and sent by a character by size
+type Histogram struct {
+	Received []*Bucket `json:"received"`
+	Sent     []*Bucket `json:"sent"`
+}
+
+// histogramRow is a row of StmtDonationHistogram
+type histogramRow struct {
+	Received bool  `db:"received"`
+	Bucket   int   `db:"bucket"`
+	Count    int64 `db:"count"`
+}
+
+// histogramBuckets are the donation sizes we count, these must match the CASE
+// expression of StmtDonationHistogram. The last has no maximum
+var histogramBuckets = []*Bucket{
+	{Label: "<1M", Min: 0, Max: 1e6 * 100},
+	{Label: "1M-10M", Min: 1e6 * 100, Max: 1e7 * 100},
+	{Label: "10M-100M", Min: 1e7 * 100, Max: 1e8 * 100},
+	{Label: "100M-1B", Min: 1e8 * 100, Max: 1e9 * 100},
+	{Label: ">1B", Min: 1e9 * 100},
+}
+
+// newBuckets returns a zeroed copy of the histogram buckets
+func newBuckets() []*Bucket {
+	buckets := []*Bucket{}
+	for _, b := range histogramBuckets {
+		copied := *b
+		buckets = append(buckets, &copied)
+	}
+	return buckets
+}
+
+// GetHistogram returns the histogram of donations to and from the character
+func GetHistogram(ctx context.Context, charID int32) (*Histogram, error) {
+	ctx = replicated(ctx)
+	rows, err := queryNamedResult(
+		ctx,
+		cx.StmtDonationHistogram,
+		map[string]interface{}{"character_id": charID},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	histogram := &Histogram{Received: newBuckets(), Sent: newBuckets()}
+	err = each(rows, func() interface{} { return &histogramRow{} }, func(i interface{}) error {
+		row := i.(*histogramRow)
+		if row.Bucket < 0 || row.Bucket >= len(histogramBuckets) {
+			return nil
+		}
+		if row.Received {
+			histogram.Received[row.Bucket].Count += row.Count
+		} else {
+			histogram.Sent[row.Bucket].Count += row.Count
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return histogram, nil
+}
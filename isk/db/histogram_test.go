@@ -0,0 +1,28 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	for i, bucket := range histogramBuckets[1:] {
+		if bucket.Min != histogramBuckets[i].Max {
+			t.Errorf("%s does not start where %s ends", bucket.Label, histogramBuckets[i].Label)
+		}
+	}
+
+	buckets := newBuckets()
+	buckets[0].Count = 5
+	if histogramBuckets[0].Count != 0 {
+		t.Error("new buckets share counts with the template")
+	}
+
+	encoded, err := json.Marshal(buckets[4])
+	if err != nil {
+		t.Fatalf("failed to marshal bucket: %+v", err)
+	}
+	if string(encoded) != `{"label":"\u003e1B","min":1000000000.00,"count":0}` {
+		t.Errorf("unexpected bucket JSON: %s", encoded)
+	}
+}
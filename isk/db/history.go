@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// ErrHistoryNotFound is returned for months without a leaderboard snapshot
+var ErrHistoryNotFound = errors.New("no leaderboard snapshot for the month")
+
+// Leaderboards of the history
+const (
+	BoardReceived = "received"
+	BoardDonated  = "donated"
+)
+
+// HistoryEntry is a character's place on a leaderboard of a past month
+type HistoryEntry struct {
+	Board       string `db:"board" json:"-"`
+	Position    int    `db:"position" json:"position"`
+	CharacterID int32  `db:"character_id" json:"id"`
+	Name        string `db:"-" json:"name,omitempty"`
+	Count       int64  `db:"count" json:"count"`
+	ISK         ISK    `db:"isk" json:"isk"`
+}
+
+// History is the snapshot of the leaderboards of a month
+type History struct {
+	Month    string          `json:"month"`
+	Received []*HistoryEntry `json:"received"`
+	Donated  []*HistoryEntry `json:"donated"`
+}
+
+// MonthStart returns the start of the month (in EVE time) holding t
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// SnapshotLeaderboards saves the top characters of the month starting at
+// month, unless that month already has a snapshot
+func SnapshotLeaderboards(ctx context.Context, month time.Time, limit int) error {
+	month = MonthStart(month)
+	return executeNamed(ctx, cx.StmtSnapshotLeaderboards, map[string]interface{}{
+		"since": month,
+		"until": month.AddDate(0, 1, 0),
+		"limit": limit,
+	})
+}
+
+// GetHistory returns the leaderboard snapshot of the month, with names.
+// Months without a snapshot return ErrHistoryNotFound
+func GetHistory(ctx context.Context, month time.Time) (*History, error) {
+	ctx = replicated(ctx)
+	month = MonthStart(month)
+	rows, err := queryNamedResult(ctx, cx.StmtLeaderboardHistory, map[string]interface{}{
+		"month": month,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &HistoryEntry{} })
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, ErrHistoryNotFound
+	}
+
+	history := &History{
+		Month:    month.Format("2006-01"),
+		Received: []*HistoryEntry{},
+		Donated:  []*HistoryEntry{},
+	}
+	ids := []int32{}
+	for _, i := range res {
+		entry := i.(*HistoryEntry)
+		ids = append(ids, entry.CharacterID)
+		if entry.Board == BoardReceived {
+			history.Received = append(history.Received, entry)
+		} else {
+			history.Donated = append(history.Donated, entry)
+		}
+	}
+
+	names, err := getNamesIn(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, entries := range [][]*HistoryEntry{history.Received, history.Donated} {
+		for _, entry := range entries {
+			entry.Name = names[entry.CharacterID]
+		}
+	}
+
+	return history, nil
+}
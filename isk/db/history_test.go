@@ -0,0 +1,21 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonthStart(t *testing.T) {
+	zone := time.FixedZone("UTC+10", 10*60*60)
+
+	for at, expected := range map[time.Time]string{
+		time.Date(2018, 11, 22, 15, 0, 0, 0, time.UTC): "2018-11-01T00:00:00Z",
+		time.Date(2018, 12, 1, 0, 0, 0, 0, time.UTC):   "2018-12-01T00:00:00Z",
+		// still november in EVE time
+		time.Date(2018, 12, 1, 8, 0, 0, 0, zone): "2018-11-01T00:00:00Z",
+	} {
+		if received := MonthStart(at).Format(time.RFC3339); received != expected {
+			t.Errorf("%s: received %s, expected %s", at, received, expected)
+		}
+	}
+}
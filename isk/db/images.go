@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// imageSize is the width of the portraits and logos linked to, in pixels
+const imageSize = 128
+
+// silhouetteID is served the image server's generic portrait, as it is of
+// no character
+const silhouetteID = 1
+
+// setImages sets the portrait and logo URLs of the character from the image
+// server base of the options. Deleted characters get the generic silhouette
+func (c *Character) setImages(ctx context.Context) {
+	base := ctx.Value(cx.Opts).(*cx.Options).ImageServer
+
+	portraitID := c.ID
+	if c.Deleted {
+		portraitID = silhouetteID
+	}
+	c.Portrait = imageURL(base, "characters", portraitID, "portrait")
+	c.CorporationLogo = imageURL(base, "corporations", c.CorporationID, "logo")
+	c.AllianceLogo = imageURL(base, "alliances", c.AllianceID, "logo")
+}
+
+// imageURL returns the URL of the image of the ID, empty for the 0 ID
+func imageURL(base, category string, id int32, variation string) string {
+	if id == 0 {
+		return ""
+	}
+	return fmt.Sprintf(
+		"%s/%s/%d/%s?size=%d",
+		base,
+		category,
+		id,
+		variation,
+		imageSize,
+	)
+}
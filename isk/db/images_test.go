@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func imagesContext() context.Context {
+	return context.WithValue(context.Background(), cx.Opts, &cx.Options{
+		ImageServer: "https://images.example.com",
+	})
+}
+
+func TestSetImages(t *testing.T) {
+	char := &Character{ID: 1001, CorporationID: 2001, AllianceID: 3001}
+	char.setImages(imagesContext())
+
+	base := "https://images.example.com"
+	cases := map[string]string{
+		char.Portrait:        base + "/characters/1001/portrait?size=128",
+		char.CorporationLogo: base + "/corporations/2001/logo?size=128",
+		char.AllianceLogo:    base + "/alliances/3001/logo?size=128",
+	}
+	for received, expected := range cases {
+		if received != expected {
+			t.Errorf("expected %s, received %s", expected, received)
+		}
+	}
+}
+
+func TestSetImagesOmitted(t *testing.T) {
+	char := &Character{ID: 90000001, CorporationID: 98000001}
+	char.setImages(imagesContext())
+
+	out, err := json.Marshal(char)
+	if err != nil {
+		t.Fatalf("failed to marshal character: %+v", err)
+	}
+	if strings.Contains(string(out), "alliance_logo") {
+		t.Errorf("alliance logo without an alliance: %s", out)
+	}
+	if !strings.Contains(string(out), `"corporation_logo":`) {
+		t.Errorf("expected a corporation logo: %s", out)
+	}
+}
+
+func TestSetImagesDeleted(t *testing.T) {
+	char := &Character{ID: 90000001, CorporationID: 1000001, Deleted: true}
+	char.setImages(imagesContext())
+
+	expected := "https://images.example.com/characters/1/portrait?size=128"
+	if char.Portrait != expected {
+		t.Errorf("expected the silhouette, received %s", char.Portrait)
+	}
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+
+	"github.com/a-tal/esi-isk/isk/format"
+)
+
+// ISK is an amount of ISK stored as integer hundredths (cents)
+type ISK int64
+
+// NewISK converts a float ISK value (as returned by ESI) to ISK cents
+func NewISK(f float64) ISK {
+	return ISK(math.Round(f * 100))
+}
+
+// Float64 returns the ISK amount as a float, for display purposes only
+func (i ISK) Float64() float64 {
+	return float64(i) / 100
+}
+
+// String formats the ISK amount with two decimal places
+func (i ISK) String() string {
+	sign := ""
+	cents := int64(i)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	whole := strconv.FormatInt(cents/100, 10)
+	frac := strconv.FormatInt(100+cents%100, 10)[1:]
+	return sign + whole + "." + frac
+}
+
+// Short formats the amount for people to read, such as 1.24b
+func (i ISK) Short() string {
+	return format.ISK(i.Float64())
+}
+
+// shortOrEmpty is the short amount, or empty for 0 so it can be omitted
+func (i ISK) shortOrEmpty() string {
+	if i == 0 {
+		return ""
+	}
+	return i.Short()
+}
+
+// MarshalJSON writes the amount as a JSON number with two decimal places
+func (i ISK) MarshalJSON() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number of ISK into ISK cents
+func (i *ISK) UnmarshalJSON(b []byte) error {
+	f, err := strconv.ParseFloat(string(bytes.Trim(b, `"`)), 64)
+	if err != nil {
+		return err
+	}
+	*i = NewISK(f)
+	return nil
+}
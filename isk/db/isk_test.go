@@ -0,0 +1,62 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNewISK(t *testing.T) {
+	cases := map[float64]ISK{
+		0:             0,
+		0.01:          1,
+		0.005:         1,
+		1234.56:       123456,
+		-10.5:         -1050,
+		1e12 + 0.1:    100000000000010,
+		9999999.99999: 1000000000,
+	}
+
+	for f, expected := range cases {
+		if i := NewISK(f); i != expected {
+			t.Errorf("NewISK(%f): received %d, expected %d", f, i, expected)
+		}
+	}
+}
+
+func TestISKMarshalJSON(t *testing.T) {
+	cases := map[ISK]string{
+		0:       "0.00",
+		5:       "0.05",
+		100:     "1.00",
+		123456:  "1234.56",
+		-1050:   "-10.50",
+		-5:      "-0.05",
+		9999999: "99999.99",
+	}
+
+	for i, expected := range cases {
+		b, err := json.Marshal(i)
+		if err != nil {
+			t.Errorf("failed to marshal %d: %+v", i, err)
+		}
+		if string(b) != expected {
+			t.Errorf("invalid json. received %s, expected %s", b, expected)
+		}
+	}
+}
+
+func TestISKRoundTrip(t *testing.T) {
+	for _, i := range []ISK{0, 1, 99, 100, 123456, -1050, 100000000000010} {
+		b, err := json.Marshal(i)
+		if err != nil {
+			t.Errorf("failed to marshal %d: %+v", i, err)
+		}
+		var out ISK
+		if err := json.Unmarshal(b, &out); err != nil {
+			t.Errorf("failed to unmarshal %s: %+v", b, err)
+		}
+		if out != i {
+			t.Errorf("round trip failed. received %d, expected %d", out, i)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLargestDonationDB(t *testing.T) {
+	ctx := testDB(t)
+	countDonations(
+		t,
+		ctx,
+		testDonation(1, 0, 1000),
+		testDonation(2, 1, 5000),
+		testDonation(3, 2, 2000),
+	)
+
+	largest := getTestCharacter(t, ctx, 1).LargestDonation
+	if largest == nil || largest.ID != 2 || largest.Amount != NewISK(5000) ||
+		largest.Donator != 2 || !largest.Timestamp.Equal(testAt.Add(time.Hour)) {
+		t.Fatalf("unexpected largest donation %+v", largest)
+	}
+
+	// voiding it falls back to the next largest
+	c := &Correction{Admin: "admin", Reason: "test"}
+	if _, err := VoidDonation(ctx, 2, c); err != nil {
+		t.Fatalf("failed to void donation: %+v", err)
+	}
+	largest = getTestCharacter(t, ctx, 1).LargestDonation
+	if largest == nil || largest.ID != 3 {
+		t.Fatalf("expected the largest after voiding, received %+v", largest)
+	}
+
+	// as does refunding it
+	if err := SaveRefunds(ctx, []*Refund{testRefund(4, 3, 2000)}); err != nil {
+		t.Fatalf("failed to save refund: %+v", err)
+	}
+	largest = getTestCharacter(t, ctx, 1).LargestDonation
+	if largest == nil || largest.ID != 1 {
+		t.Fatalf("expected the largest after refunding, received %+v", largest)
+	}
+
+	// a voided donation is never recorded
+	countDonations(t, ctx, testDonation(5, 4, 9000))
+	if _, err := VoidDonation(ctx, 5, c); err != nil {
+		t.Fatalf("failed to void donation: %+v", err)
+	}
+	if err := SaveCharacterDonations(
+		ctx,
+		[]*Donation{testDonation(5, 4, 9000)},
+		testAffiliations(t, ctx),
+	); err != nil {
+		t.Fatalf("failed to count donation: %+v", err)
+	}
+	if largest = getTestCharacter(t, ctx, 1).LargestDonation; largest.ID != 1 {
+		t.Errorf("expected the voided donation skipped, received %+v", largest)
+	}
+
+	// the recalculation agrees
+	if err := RecalculateTotals(ctx); err != nil {
+		t.Fatalf("failed to recalculate totals: %+v", err)
+	}
+	if largest = getTestCharacter(t, ctx, 1).LargestDonation; largest.ID != 1 {
+		t.Errorf("unexpected recalculated largest %+v", largest)
+	}
+	if largest := getTestCharacter(t, ctx, 2).LargestDonation; largest != nil {
+		t.Errorf("donator received nothing, has largest %+v", largest)
+	}
+}
+
+func TestLargestContractDB(t *testing.T) {
+	ctx := testDB(t)
+	affiliations := testAffiliations(t, ctx)
+
+	contracts := Contracts{}
+	for i, value := range []float64{3000, 8000} {
+		contracts = append(contracts, &Contract{
+			ID:       int32(i + 1),
+			Donator:  2,
+			Receiver: 1,
+			Issued:   testAt.Add(time.Duration(i) * time.Hour),
+			Expires:  testAt.Add(24 * time.Hour),
+			Accepted: true,
+			Status:   "finished",
+			Value:    NewISK(value),
+		})
+	}
+	loadContracts(t, ctx, contracts...)
+	if err := SaveCharacterContracts(ctx, contracts, affiliations); err != nil {
+		t.Fatalf("failed to save characters: %+v", err)
+	}
+
+	largest := getTestCharacter(t, ctx, 1).LargestContract
+	if largest == nil || largest.ID != 2 || largest.Amount != NewISK(8000) {
+		t.Fatalf("unexpected largest contract %+v", largest)
+	}
+
+	// reversing it falls back to the other
+	contracts[1].Status = "reversed"
+	if err := UpdateContracts(ctx, contracts[1:], affiliations); err != nil {
+		t.Fatalf("failed to update contracts: %+v", err)
+	}
+	largest = getTestCharacter(t, ctx, 1).LargestContract
+	if largest == nil || largest.ID != 1 || largest.Amount != NewISK(3000) {
+		t.Errorf("expected the largest after reversing, received %+v", largest)
+	}
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// UnknownStructure is the name of structures the characters may not access
+const UnknownStructure = "Unknown Structure"
+
+// LocationRetry is how long until structures which were denied are resolved
+// again
+const LocationRetry = 7 * 24 * time.Hour
+
+// Location is the name of a station or structure
+type Location struct {
+	ID   int64  `db:"location_id"`
+	Name string `db:"name"`
+
+	// Denied is set for structures the character resolving it may not access
+	Denied bool `db:"denied"`
+
+	ResolvedAt time.Time `db:"resolved_at"`
+}
+
+// Stale is true if the location should be resolved again, it was denied
+// more than LocationRetry ago
+func (l *Location) Stale(now time.Time) bool {
+	return l.Denied && l.ResolvedAt.Add(LocationRetry).Before(now)
+}
+
+// GetLocations returns the stored locations of the IDs, by ID. Unknown IDs
+// are left out
+func GetLocations(
+	ctx context.Context,
+	ids []int64,
+) (map[int64]*Location, error) {
+	locations := map[int64]*Location{}
+	if len(ids) == 0 {
+		return locations, nil
+	}
+
+	rows, err := queryIn(ctx, queryLocationsIn, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	err = each(rows, func() interface{} { return &Location{} }, func(
+		i interface{},
+	) error {
+		location := i.(*Location)
+		location.ResolvedAt = location.ResolvedAt.UTC()
+		locations[location.ID] = location
+		return nil
+	})
+	return locations, err
+}
+
+// SaveLocation stores the location, and names the contracts at it
+func SaveLocation(ctx context.Context, location *Location) error {
+	values := map[string]interface{}{
+		"location_id": location.ID,
+		"name":        location.Name,
+		"denied":      location.Denied,
+		"resolved_at": location.ResolvedAt.UTC(),
+	}
+	if err := executeNamed(ctx, cx.StmtSaveLocation, values); err != nil {
+		return err
+	}
+	return executeNamed(ctx, cx.StmtNameContracts, values)
+}
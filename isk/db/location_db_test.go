@@ -0,0 +1,90 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContractLocationsDB(t *testing.T) {
+	ctx := testDB(t)
+
+	now := time.Now().UTC()
+	contract := func(id int32, location int64) *Contract {
+		return &Contract{
+			ID:       id,
+			Donator:  2,
+			Receiver: 1,
+			Location: location,
+			Issued:   now,
+			Expires:  now.Add(time.Hour),
+			Status:   "outstanding",
+		}
+	}
+	locationName := func(id int32) string {
+		record, err := GetContract(ctx, id)
+		if err != nil {
+			t.Fatalf("failed to get contract %d: %+v", id, err)
+		}
+		return record.LocationName
+	}
+
+	// contracts saved before their location is resolved are named after
+	loadContracts(t, ctx, contract(1, 60003760))
+	if name := locationName(1); name != "" {
+		t.Errorf("expected no location name, received %q", name)
+	}
+
+	station := &Location{
+		ID:         60003760,
+		Name:       "Jita IV - Moon 4 - Caldari Navy Assembly Plant",
+		ResolvedAt: now,
+	}
+	if err := SaveLocation(ctx, station); err != nil {
+		t.Fatalf("failed to save location: %+v", err)
+	}
+	if name := locationName(1); name != station.Name {
+		t.Errorf("expected location %q, received %q", station.Name, name)
+	}
+
+	// contracts saved after are named when they are added
+	loadContracts(t, ctx, contract(2, 60003760))
+	if name := locationName(2); name != station.Name {
+		t.Errorf("expected location %q, received %q", station.Name, name)
+	}
+
+	// denied structures are renamed once they are resolved
+	denied := &Location{
+		ID:         1022734985679,
+		Name:       UnknownStructure,
+		Denied:     true,
+		ResolvedAt: now.Add(-LocationRetry - time.Hour),
+	}
+	if err := SaveLocation(ctx, denied); err != nil {
+		t.Fatalf("failed to save denied location: %+v", err)
+	}
+	loadContracts(t, ctx, contract(3, denied.ID))
+
+	locations, err := GetLocations(ctx, []int64{station.ID, denied.ID, 1})
+	if err != nil {
+		t.Fatalf("failed to get locations: %+v", err)
+	}
+	if len(locations) != 2 || locations[station.ID].Stale(now) ||
+		!locations[denied.ID].Stale(now) {
+		t.Fatalf("unexpected locations %+v", locations)
+	}
+
+	resolved := &Location{
+		ID:         denied.ID,
+		Name:       "Perimeter - Tranquility Trading Tower",
+		ResolvedAt: now,
+	}
+	if err := SaveLocation(ctx, resolved); err != nil {
+		t.Fatalf("failed to save resolved location: %+v", err)
+	}
+	if name := locationName(3); name != resolved.Name {
+		t.Errorf("expected location %q, received %q", resolved.Name, name)
+	}
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// advisory lock keys, shared by every replica using the database
+const (
+	// lockTotals is held while reading and saving character totals
+	lockTotals = int64(0x6973_6b01)
+
+	// lockMaintenance is held by the one worker running maintenance
+	lockMaintenance = int64(0x6973_6b02)
+
+	// lockMigrations is held while applying each migration
+	lockMigrations = int64(0x6973_6b03)
+)
+
+// LockTotals blocks until no other worker, in this or another replica, is
+// saving character totals. Call the returned function to unlock. Within
+// WithTx the lock is held until the transaction ends, so no other worker
+// reads the totals before they are committed
+func LockTotals(ctx context.Context) (func(), error) {
+	if tx := storeFrom(ctx).tx; tx != nil {
+		_, err := tx.ExecContext(ctx, queryAdvisoryTxLock, lockTotals)
+		return func() {}, err
+	}
+	unlock, _, err := advisoryLock(ctx, lockTotals, false)
+	return unlock, err
+}
+
+// TryLockMaintenance returns true if this worker should run maintenance, and
+// the function to release it with afterwards
+func TryLockMaintenance(ctx context.Context) (func(), bool, error) {
+	return advisoryLock(ctx, lockMaintenance, true)
+}
+
+// advisoryLock takes a session advisory lock, on its own connection so it is
+// held until unlocked. With try it returns false instead of waiting
+func advisoryLock(
+	ctx context.Context,
+	key int64,
+	try bool,
+) (func(), bool, error) {
+	conn, err := storeFrom(ctx).DB.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	locked := true
+	if try {
+		err = conn.QueryRowContext(ctx, queryTryAdvisoryLock, key).Scan(&locked)
+	} else {
+		_, err = conn.ExecContext(ctx, queryAdvisoryLock, key)
+	}
+	if err != nil || !locked {
+		if cErr := conn.Close(); cErr != nil {
+			cx.Logf(ctx, "failed to release lock connection: %+v", cErr)
+		}
+		return nil, false, err
+	}
+
+	return func() {
+		// a connection still holding the lock must never be reused
+		if _, err := conn.ExecContext(
+			context.Background(),
+			queryAdvisoryUnlock,
+			key,
+		); err != nil {
+			cx.Logf(ctx, "failed to unlock %d, dropping connection: %+v", key, err)
+			if rErr := conn.Raw(func(interface{}) error {
+				return driver.ErrBadConn
+			}); rErr != driver.ErrBadConn {
+				cx.Logf(ctx, "failed to drop lock connection: %+v", rErr)
+			}
+		}
+		if err := conn.Close(); err != nil {
+			cx.Logf(ctx, "failed to release lock connection: %+v", err)
+		}
+	}, true, nil
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Migrate applies the .sql files of dir not yet recorded in
+// schema_migrations, in name order, returning the names of those applied.
+// Each file is applied and recorded in a transaction of its own. Files which
+// were applied before migrations were recorded, by the postgres image's
+// initdb, are applied again, so every file must be safe to re-run
+func Migrate(ctx context.Context, dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no migrations found in %s", dir)
+	}
+	sort.Strings(files)
+
+	db := storeFrom(ctx).DB
+	if _, err := db.ExecContext(ctx, queryCreateMigrations); err != nil {
+		return nil, err
+	}
+
+	applied := []string{}
+	for _, file := range files {
+		name := filepath.Base(file)
+		ok, err := migrate(ctx, name, file)
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply %s: %+v", name, err)
+		}
+		if ok {
+			applied = append(applied, name)
+		}
+	}
+	return applied, nil
+}
+
+// migrate applies the file unless it has been already, returning true if
+// it was applied now
+func migrate(ctx context.Context, name, file string) (bool, error) {
+	raw, err := ioutil.ReadFile(file) // #nosec
+	if err != nil {
+		return false, err
+	}
+
+	applied := false
+	err = transaction(ctx, func(tx *sqlx.Tx) error {
+		// concurrent runs wait here, then find the file applied
+		_, err := tx.ExecContext(ctx, queryLockMigrations, lockMigrations)
+		if err != nil {
+			return err
+		}
+
+		var exists bool
+		err = tx.QueryRowContext(ctx, queryMigrationApplied, name).Scan(&exists)
+		if err != nil || exists {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, string(raw)); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, querySaveMigration, name); err != nil {
+			return err
+		}
+
+		applied = true
+		return nil
+	})
+	return applied, err
+}
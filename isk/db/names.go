@@ -17,7 +17,8 @@ func SaveNames(ctx context.Context, affiliations []*Affiliation) error {
 	names := map[int32]string{}
 	for _, aff := range affiliations {
 		for _, name := range []*Name{aff.Character, aff.Corporation, aff.Alliance} {
-			if name == nil {
+			// deleted characters may have no name to save
+			if name == nil || name.Name == "" {
 				continue
 			}
 			if _, found := names[name.ID]; found {
@@ -95,8 +96,36 @@ func GetNames(ctx context.Context, ids ...int32) (map[int32]string, error) {
 	return names, nil
 }
 
-// GetName returns the name for a single character ID from the DB
+// getNamesIn returns the known names of the IDs in one query, unknown IDs are
+// left out of the result
+func getNamesIn(ctx context.Context, ids []int32) (map[int32]string, error) {
+	names := map[int32]string{}
+	if len(ids) == 0 {
+		return names, nil
+	}
+
+	rows, err := queryIn(ctx, queryNamesIn, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	err = each(rows, func() interface{} { return &Name{} }, func(i interface{}) error {
+		name := i.(*Name)
+		names[name.ID] = name.Name
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// GetName returns the name for a single character ID from the DB, the 0 ID of
+// anonymous and removed donators is AnonymousName
 func GetName(ctx context.Context, id int32) (string, error) {
+	if id == 0 {
+		return AnonymousName, nil
+	}
 	name := &Name{}
 	values := map[string]interface{}{"id": id}
 	if err := getNamedResult(ctx, cx.StmtGetName, name, values); err != nil {
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// PLEXTypeID is the type ID of PLEX
+const PLEXTypeID = 44992
+
+// classifyContract sets the notable items summary of the stored contract from
+// its stored items, see the notable_types table
+func classifyContract(ctx context.Context, k *Contract) error {
+	return executeContract(ctx, cx.StmtClassifyContract, k)
+}
+
+// GetNotableContracts returns a page of the accepted contracts with notable
+// items in the 30 day window, the highest value first. Contracts of hidden
+// or banned characters are left out, and anonymous donators are masked
+func GetNotableContracts(
+	ctx context.Context,
+	limit, offset int,
+) ([]*ContractRecord, error) {
+	ctx = replicated(ctx)
+	rows, err := queryNamedResult(
+		ctx,
+		cx.StmtNotableContracts,
+		map[string]interface{}{"limit": limit, "offset": offset},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	contracts := Contracts{}
+	err = each(rows, func() interface{} { return &Contract{} }, func(
+		i interface{},
+	) error {
+		k := i.(*Contract)
+		k.Issued = k.Issued.UTC()
+		k.Expires = k.Expires.UTC()
+		contracts = append(contracts, k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := GetContractItems(ctx, contracts); err != nil {
+		return nil, err
+	}
+
+	ids := []int32{}
+	donators := []int32{}
+	for _, k := range contracts {
+		ids = append(ids, k.Donator, k.Receiver)
+		donators = append(donators, k.Donator)
+	}
+	parties, err := getParties(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	anonymous, err := GetAnonymous(ctx, donators)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*ContractRecord{}
+	for _, k := range contracts {
+		record := &ContractRecord{
+			Contract:      k,
+			DonatorParty:  parties[k.Donator],
+			ReceiverParty: parties[k.Receiver],
+		}
+		if anonymous[k.Donator] {
+			record.Donator = 0
+			record.DonatorParty = nil
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
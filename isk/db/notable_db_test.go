@@ -0,0 +1,75 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotableContractsDB(t *testing.T) {
+	ctx := testDB(t)
+	testAffiliations(t, ctx)
+
+	now := time.Now().UTC()
+	contract := func(id int32, value float64, items ...*Item) *Contract {
+		for i, item := range items {
+			item.ID = int64(id)*10 + int64(i)
+			item.ContractID = id
+		}
+		return &Contract{
+			ID:       id,
+			Donator:  2,
+			Receiver: 1,
+			Issued:   now.Add(-time.Duration(id) * time.Hour),
+			Expires:  now.Add(time.Hour),
+			Accepted: true,
+			Status:   "finished",
+			Value:    NewISK(value),
+			Items:    items,
+		}
+	}
+	pending := contract(4, 9000, &Item{TypeID: PLEXTypeID, Quantity: 500})
+	pending.Accepted = false
+	pending.Status = "outstanding"
+
+	loadContracts(
+		t,
+		ctx,
+		contract(1, 1000, &Item{TypeID: 40520, Quantity: 2}),
+		contract(
+			2,
+			5000,
+			&Item{TypeID: PLEXTypeID, Quantity: 100},
+			&Item{TypeID: 34, Quantity: 1000},
+			&Item{TypeID: 40519, Quantity: 1},
+		),
+		contract(3, 8000, &Item{TypeID: 34, Quantity: 1000}),
+		pending,
+	)
+
+	notable, err := GetNotableContracts(ctx, 10, 0)
+	if err != nil {
+		t.Fatalf("failed to get notable contracts: %+v", err)
+	}
+	if len(notable) != 2 || notable[0].ID != 2 || notable[1].ID != 1 {
+		t.Fatalf("expected contracts 2 and 1, received %+v", notable)
+	}
+
+	if k := notable[0]; !k.ContainsPlex || k.NotableItems != 101 ||
+		len(k.Items) != 3 {
+		t.Errorf("unexpected PLEX contract %+v", k)
+	}
+	if k := notable[1]; k.ContainsPlex || k.NotableItems != 2 {
+		t.Errorf("unexpected injector contract %+v", k)
+	}
+
+	record, err := GetContract(ctx, 3)
+	if err != nil {
+		t.Fatalf("failed to get contract: %+v", err)
+	}
+	if record.ContainsPlex || record.NotableItems != 0 {
+		t.Errorf("expected nothing notable, received %+v", record)
+	}
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+const (
+	// updateChannel is the postgres channel updated character IDs are sent on
+	updateChannel = "esi_isk_characters"
+
+	// createChannel is the postgres channel new character IDs are sent on, they
+	// are sent on updateChannel too
+	createChannel = "esi_isk_new_characters"
+
+	// testChannel is the postgres channel test donations are sent on, as JSON
+	testChannel = "esi_isk_test_donations"
+)
+
+// maxNotifyIDs keeps each notification payload well under the 8000 byte limit
+const maxNotifyIDs = 500
+
+// NotifyCharacters tells any listeners the characters have been updated
+func NotifyCharacters(ctx context.Context, charIDs []int32) error {
+	return notify(ctx, cx.StmtNotifyCharacters, charIDs)
+}
+
+// NotifyNewCharacters tells any listeners the characters have been created
+func NotifyNewCharacters(ctx context.Context, charIDs []int32) error {
+	return notify(ctx, cx.StmtNotifyNewCharacters, charIDs)
+}
+
+// NotifyTestDonation sends the test donation to any listeners, without
+// storing it
+func NotifyTestDonation(ctx context.Context, donation *Donation) error {
+	payload, err := json.Marshal(donation)
+	if err != nil {
+		return err
+	}
+	return executeNamed(ctx, cx.StmtNotifyTestDonation, map[string]interface{}{
+		"payload": string(payload),
+	})
+}
+
+// notify sends the character IDs with the notify statement, in batches
+func notify(ctx context.Context, stmt cx.Key, charIDs []int32) error {
+	for start := 0; start < len(charIDs); start += maxNotifyIDs {
+		end := start + maxNotifyIDs
+		if end > len(charIDs) {
+			end = len(charIDs)
+		}
+
+		ids := []string{}
+		for _, charID := range charIDs[start:end] {
+			ids = append(ids, strconv.Itoa(int(charID)))
+		}
+
+		if err := executeNamed(ctx, stmt, map[string]interface{}{
+			"payload": strings.Join(ids, ","),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseNotification returns the character IDs of a notification payload
+func parseNotification(payload string) []int32 {
+	charIDs := []int32{}
+	for _, id := range strings.Split(payload, ",") {
+		charID, err := strconv.ParseInt(id, 10, 32)
+		if err == nil {
+			charIDs = append(charIDs, int32(charID))
+		}
+	}
+	return charIDs
+}
+
+// ListenForUpdates calls updated with the IDs of characters updated by any
+// process, or with nil whenever notifications may have been missed, created
+// with the IDs of new characters and tested with each test donation. This
+// function does not return
+func ListenForUpdates(
+	ctx context.Context,
+	updated, created func([]int32),
+	tested func(*Donation),
+) {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+
+	listener := pq.NewListener(
+		dsn(opts),
+		10*time.Second,
+		time.Minute,
+		func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("character update listener: %+v", err)
+			}
+		},
+	)
+
+	for _, channel := range []string{
+		updateChannel,
+		createChannel,
+		testChannel,
+	} {
+		if err := listener.Listen(channel); err != nil {
+			log.Printf("failed to listen on %s: %+v", channel, err)
+		}
+	}
+
+	for {
+		select {
+		case n := <-listener.Notify:
+			if n == nil {
+				// the connection was re-established, we may have missed some
+				updated(nil)
+			} else if n.Channel == createChannel {
+				created(parseNotification(n.Extra))
+			} else if n.Channel == testChannel {
+				donation := &Donation{}
+				if err := json.Unmarshal([]byte(n.Extra), donation); err != nil {
+					log.Printf("invalid test donation %q: %+v", n.Extra, err)
+				} else {
+					tested(donation)
+				}
+			} else {
+				updated(parseNotification(n.Extra))
+			}
+		case <-time.After(90 * time.Second):
+			go func() {
+				if err := listener.Ping(); err != nil {
+					log.Printf("character update listener ping: %+v", err)
+				}
+			}()
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package db
+
+import "testing"
+
+func TestParseNotification(t *testing.T) {
+	charIDs := parseNotification("1,2114454465,bad,,3")
+	expected := []int32{1, 2114454465, 3}
+
+	if len(charIDs) != len(expected) {
+		t.Fatalf("received %+v, expected %+v", charIDs, expected)
+	}
+	for i, charID := range charIDs {
+		if charID != expected[i] {
+			t.Errorf("received %d at %d, expected %d", charID, i, expected[i])
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// Cursor is the timestamp and ID of the last row of a page, newest first.
+// The next page has the rows ordered after it
+type Cursor struct {
+	Timestamp time.Time
+	ID        int64
+}
+
+// PageQuery selects a page of a character's donations and/or contracts
+type PageQuery struct {
+	// Donations and Contracts are the lists to read
+	Donations, Contracts bool
+
+	// Received and Sent are the directions to read
+	Received, Sent bool
+
+	// After is the cursor of the previous page, nil for the first
+	After *Cursor
+
+	// Limit is the most rows read of each list
+	Limit int
+}
+
+// args are the named args of the page statements
+func (q *PageQuery) args(charID int32) map[string]interface{} {
+	after := &Cursor{Timestamp: time.Unix(0, 0).UTC()}
+	if q.After != nil {
+		after = q.After
+	}
+	return map[string]interface{}{
+		"character_id": charID,
+		"received":     q.Received,
+		"sent":         q.Sent,
+		"first":        q.After == nil,
+		"after_time":   after.Timestamp,
+		"after_id":     after.ID,
+		"limit":        q.Limit,
+	}
+}
+
+// GetCharPage returns up to the limit of each list the query reads, after
+// its cursor, newest first. Rows it didn't read are left empty
+func GetCharPage(
+	ctx context.Context,
+	charID int32,
+	q *PageQuery,
+) (*CharDetails, error) {
+	c := &CharDetails{
+		Donations:  Donations{},
+		Donated:    Donations{},
+		Contracts:  Contracts{},
+		Contracted: Contracts{},
+	}
+
+	if q.Donations {
+		donations, err := getDonationsPage(ctx, q.args(charID))
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range donations {
+			if d.Recipient == charID {
+				c.Donations = append(c.Donations, d)
+			} else {
+				c.Donated = append(c.Donated, d)
+			}
+		}
+	}
+
+	if q.Contracts {
+		contracts, err := getContractsPage(ctx, q.args(charID))
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range contracts {
+			if k.Receiver == charID {
+				c.Contracts = append(c.Contracts, k)
+			} else {
+				c.Contracted = append(c.Contracted, k)
+			}
+		}
+	}
+
+	if err := c.addCounterparties(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func getDonationsPage(ctx context.Context, args map[string]interface{}) (
+	Donations,
+	error,
+) {
+	rows, err := queryNamedResult(ctx, cx.StmtDonationsPage, args)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &Donation{} })
+	if err != nil {
+		return nil, err
+	}
+	donations := Donations{}
+	for _, i := range res {
+		d := i.(*Donation)
+		d.Timestamp = d.Timestamp.UTC()
+		donations = append(donations, d)
+	}
+	return donations, nil
+}
+
+func getContractsPage(ctx context.Context, args map[string]interface{}) (
+	Contracts,
+	error,
+) {
+	rows, err := queryNamedResult(ctx, cx.StmtContractsPage, args)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &Contract{} })
+	if err != nil {
+		return nil, err
+	}
+	contracts := Contracts{}
+	for _, i := range res {
+		k := i.(*Contract)
+		k.Issued = k.Issued.UTC()
+		k.Expires = k.Expires.UTC()
+		contracts = append(contracts, k)
+	}
+
+	return contracts, GetContractItems(ctx, contracts)
+}
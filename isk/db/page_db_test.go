@@ -0,0 +1,105 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func donationIDs(donations Donations) []int64 {
+	ids := []int64{}
+	for _, d := range donations {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+func TestGetCharPageNewRowsDB(t *testing.T) {
+	ctx := testDB(t)
+
+	// 2 and 3 are at the same time, ordered by ID
+	loadDonations(
+		t,
+		ctx,
+		testDonation(1, 0, 100),
+		testDonation(2, 1, 100),
+		testDonation(3, 1, 100),
+		testDonation(4, 2, 100),
+	)
+
+	q := &PageQuery{Donations: true, Received: true, Limit: 2}
+	first, err := GetCharPage(ctx, 1, q)
+	if err != nil {
+		t.Fatalf("failed to get first page: %+v", err)
+	}
+	ids := donationIDs(first.Donations)
+	if !reflect.DeepEqual(ids, []int64{4, 3}) {
+		t.Fatalf("unexpected first page %v", ids)
+	}
+
+	// a new donation arriving between pages doesn't shift the next one
+	loadDonations(t, ctx, testDonation(5, 3, 100))
+
+	last := first.Donations[1]
+	q.After = &Cursor{Timestamp: last.Timestamp, ID: last.ID}
+	second, err := GetCharPage(ctx, 1, q)
+	if err != nil {
+		t.Fatalf("failed to get second page: %+v", err)
+	}
+	ids = donationIDs(second.Donations)
+	if !reflect.DeepEqual(ids, []int64{2, 1}) {
+		t.Errorf("unexpected second page %v", ids)
+	}
+	if len(second.Donated) != 0 || len(second.Contracts) != 0 {
+		t.Errorf("expected only donations received, received %+v", second)
+	}
+}
+
+func TestGetCharPageDirectionsDB(t *testing.T) {
+	ctx := testDB(t)
+
+	sent := testDonation(2, 1, 100)
+	sent.Donator, sent.Recipient = 1, 2
+	loadDonations(t, ctx, testDonation(1, 0, 100), sent)
+	loadContracts(t, ctx, &Contract{
+		ID:       7,
+		Donator:  2,
+		Receiver: 1,
+		Issued:   testAt.Add(2 * time.Hour),
+		Expires:  testAt.Add(24 * time.Hour),
+		Status:   "outstanding",
+	})
+
+	c, err := GetCharPage(ctx, 1, &PageQuery{
+		Donations: true,
+		Contracts: true,
+		Received:  true,
+		Sent:      true,
+		Limit:     10,
+	})
+	if err != nil {
+		t.Fatalf("failed to get page: %+v", err)
+	}
+	if len(c.Donations) != 1 || len(c.Donated) != 1 || len(c.Contracts) != 1 {
+		t.Errorf("expected a row of each list, received %+v", c)
+	}
+	if c.Contracts[0].Items == nil {
+		t.Error("expected the contract items to be read")
+	}
+
+	c, err = GetCharPage(ctx, 1, &PageQuery{
+		Donations: true,
+		Sent:      true,
+		Limit:     10,
+	})
+	if err != nil {
+		t.Fatalf("failed to get page: %+v", err)
+	}
+	if len(c.Donations) != 0 ||
+		!reflect.DeepEqual(donationIDs(c.Donated), []int64{2}) {
+		t.Errorf("expected only the sent donation, received %+v", c)
+	}
+}
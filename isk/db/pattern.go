@@ -0,0 +1,120 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+	ttemplate "text/template"
+	"text/template/parse"
+)
+
+// PatternTokens are the {{token}}s available to row pattern templates
+var PatternTokens = []string{
+	"amount",
+	"amount_short",
+	"corp",
+	"name",
+	"note",
+	"time_ago",
+}
+
+// reUndefined matches text/template's error for an unknown function
+var reUndefined = regexp.MustCompile(`function "([^"]*)" not defined`)
+
+// IsTemplate is true for {{token}} patterns, other patterns use %KEYWORD%s
+func IsTemplate(pattern string) bool {
+	return strings.Contains(pattern, "{{")
+}
+
+// CheckPattern ensures a template pattern only uses bare, known tokens
+func CheckPattern(pattern string) error {
+	_, err := parsePattern(pattern, patternFuncs(nil))
+	return err
+}
+
+// RenderPattern renders the template pattern as HTML, the pattern text and
+// token values are both escaped
+func RenderPattern(pattern string, values map[string]string) (string, error) {
+	if err := CheckPattern(pattern); err != nil {
+		return "", err
+	}
+
+	// tokens contain nothing to escape so this only touches the pattern text
+	t, err := parsePattern(template.HTMLEscapeString(pattern), patternFuncs(values))
+	if err != nil {
+		return "", err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, nil); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// patternFuncs is the restricted function map, one function per token
+func patternFuncs(values map[string]string) ttemplate.FuncMap {
+	funcs := ttemplate.FuncMap{}
+	for _, token := range PatternTokens {
+		value := template.HTMLEscapeString(values[token])
+		funcs[token] = func() string { return value }
+	}
+	return funcs
+}
+
+func parsePattern(pattern string, funcs ttemplate.FuncMap) (
+	*ttemplate.Template,
+	error,
+) {
+	t, err := ttemplate.New("pattern").Funcs(funcs).Parse(pattern)
+	if err != nil {
+		if match := reUndefined.FindStringSubmatch(err.Error()); match != nil {
+			return nil, patternError(fmt.Sprintf("unknown token %q", match[1]))
+		}
+		return nil, patternError(strings.TrimPrefix(err.Error(), "template: "))
+	}
+
+	for _, node := range t.Tree.Root.Nodes {
+		if _, text := node.(*parse.TextNode); text {
+			continue
+		}
+		if action, ok := node.(*parse.ActionNode); ok && actionToken(action) != "" {
+			continue
+		}
+		return nil, patternError(fmt.Sprintf("unsupported token %s", node))
+	}
+
+	return t, nil
+}
+
+// actionToken returns the token of a {{token}} action, or "" for anything else
+func actionToken(n *parse.ActionNode) string {
+	if len(n.Pipe.Decl) > 0 || len(n.Pipe.Cmds) != 1 {
+		return ""
+	}
+	args := n.Pipe.Cmds[0].Args
+	if len(args) != 1 {
+		return ""
+	}
+	if ident, ok := args[0].(*parse.IdentifierNode); ok {
+		for _, token := range PatternTokens {
+			if ident.Ident == token {
+				return token
+			}
+		}
+	}
+	return ""
+}
+
+func patternError(reason string) error {
+	return UserError{
+		Msg: []byte(fmt.Sprintf(
+			"invalid row pattern: %s, valid tokens are: {{%s}}",
+			reason,
+			strings.Join(PatternTokens, "}}, {{"),
+		)),
+		Code: 400,
+	}
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckPattern(t *testing.T) {
+	for pattern, valid := range map[string]bool{
+		"{{name}} donated {{amount}} ISK":                     true,
+		"{{ name }} ({{corp}}) {{amount_short}} {{time_ago}}": true,
+		"{{note}}":                   true,
+		"{{nmae}} donated":           false,
+		"{{.}}":                      false,
+		"{{printf \"%s\" name}}":     false,
+		"{{name | printf \"%s\"}}":   false,
+		"{{if note}}{{note}}{{end}}": false,
+		"{{$x := name}}":             false,
+		"{{template \"pattern\"}}":   false,
+		"{{name":                     false,
+	} {
+		err := CheckPattern(pattern)
+		if valid && err != nil {
+			t.Errorf("%q: unexpected error: %+v", pattern, err)
+		} else if !valid {
+			ue, ok := err.(UserError)
+			if !ok || ue.Code != 400 {
+				t.Errorf("%q: expected a 400 user error, received %+v", pattern, err)
+			} else if !strings.Contains(string(ue.Msg), "{{amount_short}}") {
+				t.Errorf("%q: error does not list valid tokens: %s", pattern, ue.Msg)
+			}
+		}
+	}
+
+	err := CheckPattern("{{nmae}}").(UserError)
+	if !strings.Contains(string(err.Msg), `unknown token "nmae"`) {
+		t.Errorf("unknown token was not named: %s", err.Msg)
+	}
+}
+
+func TestRenderPattern(t *testing.T) {
+	row, err := RenderPattern(
+		"<b>{{name}}</b> says {{ note }}",
+		map[string]string{"name": "A & B", "note": `<script>alert("x")</script>`},
+	)
+	if err != nil {
+		t.Fatalf("failed to render pattern: %+v", err)
+	}
+
+	expected := "&lt;b&gt;A &amp; B&lt;/b&gt; says " +
+		"&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;"
+	if row != expected {
+		t.Errorf("received %q, expected %q", row, expected)
+	}
+
+	if _, err := RenderPattern("{{call name}}", nil); err == nil {
+		t.Error("rendered a pattern using a builtin")
+	}
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// PendingContract is an outstanding contract waiting for its recipient to
+// accept it, as they see it on their own character
+type PendingContract struct {
+	ID         int32     `json:"id"`
+	Issuer     int32     `json:"issuer"`
+	IssuerName string    `json:"issuer_name"`
+	Title      string    `json:"title"`
+	Issued     time.Time `json:"issued"`
+	Expires    time.Time `json:"expires"`
+
+	// Value is an estimated value of the items
+	Value ISK `json:"value"`
+
+	// Quantity is the number of items in the contract, of every type
+	Quantity int32   `json:"quantity"`
+	Items    []*Item `json:"items"`
+}
+
+// GetPendingContracts returns the outstanding contracts the character has
+// not accepted yet, newest first. Only show them to the character
+func GetPendingContracts(
+	ctx context.Context,
+	charID int32,
+) ([]*PendingContract, error) {
+	contracts, err := getContracts(ctx, charID, cx.StmtPendingContracts)
+	if err != nil {
+		return nil, err
+	}
+
+	issuers := []int32{}
+	for _, k := range contracts {
+		issuers = append(issuers, k.Donator)
+	}
+	names, err := getNamesIn(ctx, issuers)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []*PendingContract{}
+	for _, k := range contracts {
+		pending = append(pending, newPendingContract(k, names))
+	}
+	return pending, nil
+}
+
+// newPendingContract summarizes the contract, with the issuer's name if it
+// is known
+func newPendingContract(k *Contract, names map[int32]string) *PendingContract {
+	p := &PendingContract{
+		ID:         k.ID,
+		Issuer:     k.Donator,
+		IssuerName: names[k.Donator],
+		Title:      k.Note,
+		Issued:     k.Issued,
+		Expires:    k.Expires,
+		Value:      k.Value,
+		Items:      []*Item{},
+	}
+	for _, item := range k.Items {
+		p.Quantity += item.Quantity
+		p.Items = append(p.Items, item)
+	}
+	return p
+}
@@ -0,0 +1,59 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetPendingContractsDB(t *testing.T) {
+	ctx := testDB(t)
+	testAffiliations(t, ctx)
+
+	now := time.Now().UTC()
+	contract := func(
+		id int32,
+		receiver int32,
+		status string,
+		expires time.Time,
+	) *Contract {
+		return &Contract{
+			ID:       id,
+			Donator:  2,
+			Receiver: receiver,
+			Issued:   now.Add(time.Duration(id) * time.Minute),
+			Expires:  expires,
+			Accepted: status == "finished",
+			Status:   status,
+			Note:     "for you",
+			Items: []*Item{
+				{ID: int64(id), ContractID: id, TypeID: 34, Quantity: 10},
+			},
+		}
+	}
+	loadContracts(
+		t,
+		ctx,
+		contract(1, 1, "outstanding", now.Add(time.Hour)),
+		contract(2, 1, "outstanding", now.Add(time.Hour)),
+		// past its expiry, not yet seen expired
+		contract(3, 1, "outstanding", now.Add(-time.Hour)),
+		contract(4, 1, "finished", now.Add(time.Hour)),
+		contract(5, 1, "rejected", now.Add(time.Hour)),
+		contract(6, 3, "outstanding", now.Add(time.Hour)),
+	)
+
+	pending, err := GetPendingContracts(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get pending contracts: %+v", err)
+	}
+	if len(pending) != 2 || pending[0].ID != 2 || pending[1].ID != 1 {
+		t.Fatalf("expected contracts 2 and 1 pending, received %+v", pending)
+	}
+	if p := pending[0]; p.IssuerName != "Some Donator" ||
+		p.Title != "for you" || p.Quantity != 10 || len(p.Items) != 1 {
+		t.Errorf("unexpected pending contract %+v", p)
+	}
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewPendingContract(t *testing.T) {
+	items := []*Item{
+		{ID: 1, ContractID: 3, TypeID: 34, Quantity: 100},
+		{ID: 2, ContractID: 3, TypeID: 35, Quantity: 20},
+	}
+	k := &Contract{ID: 3, Donator: 2, Receiver: 1, Note: "thanks", Items: items}
+
+	p := newPendingContract(k, map[int32]string{2: "Some Donator"})
+	if p.ID != 3 || p.Issuer != 2 || p.IssuerName != "Some Donator" ||
+		p.Title != "thanks" || p.Quantity != 120 {
+		t.Errorf("unexpected pending contract %+v", p)
+	}
+	if !reflect.DeepEqual(p.Items, items) {
+		t.Errorf("expected the items %+v, received %+v", items, p.Items)
+	}
+
+	// the issuer's name is not always known
+	p = newPendingContract(&Contract{ID: 4, Donator: 5}, map[int32]string{})
+	if p.IssuerName != "" || p.Quantity != 0 || len(p.Items) != 0 ||
+		p.Items == nil {
+		t.Errorf("unexpected pending contract %+v", p)
+	}
+}
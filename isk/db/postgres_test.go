@@ -0,0 +1,218 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+// The tests built with the dbtest tag run against a throwaway Postgres in
+// Docker, each in a fresh database with every migration applied:
+//
+//	go test -tags dbtest ./isk/db/
+//
+// They are skipped without Docker, and with -short
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ory/dockertest"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// pgTemplate is the migrated database each test's database is copied from
+const pgTemplate = "esi_isk"
+
+// errNoDocker skips the database tests
+var errNoDocker = errors.New("docker is unavailable")
+
+var (
+	pgOnce     sync.Once
+	pgErr      error
+	pgPool     *dockertest.Pool
+	pgResource *dockertest.Resource
+
+	// pgAdmin connects to the postgres database, to create test databases
+	pgAdmin *sqlx.DB
+	pgOpts  *cx.Options
+	pgCount int32
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if pgResource != nil {
+		if err := pgPool.Purge(pgResource); err != nil {
+			log.Printf("failed to remove postgres container: %+v", err)
+		}
+	}
+	os.Exit(code)
+}
+
+// testDB returns a context with a new database of the latest schema, and
+// its statements prepared with the options as configured
+func testDB(t *testing.T, configure ...func(*cx.Options)) context.Context {
+	if testing.Short() {
+		t.Skip("database tests are skipped with -short")
+	}
+
+	pgOnce.Do(func() { pgErr = startPostgres() })
+	if errors.Is(pgErr, errNoDocker) {
+		t.Skipf("skipping database tests: %+v", pgErr)
+	} else if pgErr != nil {
+		t.Fatalf("failed to start postgres: %+v", pgErr)
+	}
+
+	name := fmt.Sprintf("test_%d", atomic.AddInt32(&pgCount, 1))
+	if _, err := pgAdmin.Exec(fmt.Sprintf(
+		"CREATE DATABASE %s TEMPLATE %s",
+		name,
+		pgTemplate,
+	)); err != nil {
+		t.Fatalf("failed to create database: %+v", err)
+	}
+
+	opts := *pgOpts
+	dbOpts := *pgOpts.DB
+	dbOpts.Name = name
+	opts.DB = &dbOpts
+	for _, c := range configure {
+		c(&opts)
+	}
+
+	ctx := Open(context.WithValue(context.Background(), cx.Opts, &opts))
+	t.Cleanup(func() {
+		if err := storeFrom(ctx).Close(); err != nil {
+			t.Errorf("failed to close database: %+v", err)
+		}
+	})
+	return ctx
+}
+
+// startPostgres runs the postgres container, and migrates the template
+func startPostgres() error {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		return fmt.Errorf("%w: %+v", errNoDocker, err)
+	}
+	if err := pool.Client.Ping(); err != nil {
+		return fmt.Errorf("%w: %+v", errNoDocker, err)
+	}
+
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "postgres",
+		Tag:        "alpine",
+		Env: []string{
+			"POSTGRES_USER=esi-isk",
+			"POSTGRES_PASSWORD=esi-isk",
+			"POSTGRES_DB=" + pgTemplate,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	pgPool, pgResource = pool, resource
+
+	// removed by docker if the tests never get to purge it
+	if err := resource.Expire(600); err != nil {
+		return err
+	}
+
+	pgOpts, err = defaultOptions()
+	if err != nil {
+		return err
+	}
+	pgOpts.DB = &cx.DBOptions{
+		Host:     "localhost:" + resource.GetPort("5432/tcp"),
+		User:     "esi-isk",
+		Password: "esi-isk",
+		Name:     "postgres",
+		Mode:     "disable",
+	}
+
+	if err := pool.Retry(func() error {
+		db, err := sqlx.Open("postgres", dsn(pgOpts))
+		if err != nil {
+			return err
+		}
+		if err := db.Ping(); err != nil {
+			_ = db.Close()
+			return err
+		}
+		pgAdmin = db
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return migrateTemplate()
+}
+
+// migrateTemplate applies every migration to the template database, which
+// must have no connections left to be copied
+func migrateTemplate() error {
+	opts := *pgOpts
+	dbOpts := *pgOpts.DB
+	dbOpts.Name = pgTemplate
+	opts.DB = &dbOpts
+
+	db, err := sqlx.Open("postgres", dsn(&opts))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("failed to close template database: %+v", err)
+		}
+	}()
+
+	ctx := context.WithValue(context.Background(), cx.Opts, &opts)
+	ctx = context.WithValue(ctx, cx.DB, db)
+	_, err = Migrate(ctx, filepath.Join("..", "..", "sql"))
+	return err
+}
+
+// loadCharacters saves the characters as new
+func loadCharacters(
+	t *testing.T,
+	ctx context.Context,
+	chars ...*CharacterRow,
+) {
+	for _, char := range chars {
+		if err := NewCharacter(ctx, char); err != nil {
+			t.Fatalf("failed to load character %d: %+v", char.ID, err)
+		}
+	}
+}
+
+// loadDonations saves the donations, without adding them to any totals
+func loadDonations(
+	t *testing.T,
+	ctx context.Context,
+	donations ...*Donation,
+) {
+	for _, donation := range donations {
+		if err := SaveDonation(ctx, donation); err != nil {
+			t.Fatalf("failed to load donation %d: %+v", donation.ID, err)
+		}
+	}
+}
+
+// loadContracts saves the contracts, without adding them to any totals
+func loadContracts(
+	t *testing.T,
+	ctx context.Context,
+	contracts ...*Contract,
+) {
+	for _, contract := range contracts {
+		if err := SaveContract(ctx, contract); err != nil {
+			t.Fatalf("failed to load contract %d: %+v", contract.ID, err)
+		}
+	}
+}
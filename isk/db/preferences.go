@@ -7,6 +7,8 @@ import (
 	"math"
 	"regexp"
 
+	"github.com/jmoiron/sqlx"
+
 	"github.com/a-tal/esi-isk/isk/cx"
 )
 
@@ -22,13 +24,20 @@ const (
 var (
 	// RePreferences ensures the row pattern has at least some content
 	RePreferences = regexp.MustCompile(`[^( \t)]+`)
+
+	// errUnknownPreferences is returned for characters without preferences
+	errUnknownPreferences = UserError{
+		Msg:  []byte("Unknown character ID"),
+		Code: 404,
+	}
 )
 
 // Preferences exports Prefs for donations, contracts, or both
 // NB: the JSON form of this is only used for the combined view
 type Preferences struct {
-	Donations *Prefs `json:"donations"`
-	Contracts *Prefs `json:"contracts"`
+	Donations   *Prefs `json:"donations"`
+	Contracts   *Prefs `json:"contracts"`
+	NeedsReauth bool   `json:"needs_reauth"`
 }
 
 // Prefs exports preferences for either donations or contracts
@@ -40,6 +49,9 @@ type Prefs struct {
 	Rows       int     `json:"rows"`
 	MaxAge     int     `json:"max_age,omitempty"` // seconds
 	Minimum    float64 `json:"minimum"`
+
+	// NeedsReauth is only set when returning the owner's preferences
+	NeedsReauth bool `json:"needs_reauth"`
 }
 
 type dbPreferences struct {
@@ -67,6 +79,7 @@ type dbPreferences struct {
 	DonationPassphrase      sql.NullString `db:"donation_passphrase"`
 	ContractPassphrase      sql.NullString `db:"contract_passphrase"`
 	CombinedPassphrase      sql.NullString `db:"combined_passphrase"`
+	Anonymous               bool           `db:"anonymous"`
 }
 
 // UserError can bubble up http errors to the api package
@@ -198,10 +211,7 @@ func dbPrefs(ctx context.Context, charID int32) (*dbPreferences, error) {
 		return i.(*dbPreferences), nil
 	}
 
-	return nil, UserError{
-		Msg:  []byte("Unknown character ID"),
-		Code: 500,
-	}
+	return nil, errUnknownPreferences
 }
 
 // SetPreferences sets the Preferences for the logged in user
@@ -217,39 +227,137 @@ func SetPreferences(ctx context.Context, charID int32, p *Preferences) error {
 
 // setPreferences stores combined preferences
 func setPreferences(ctx context.Context, charID int32, p *Preferences) error {
-	return executeNamed(
+	return updatePreferences(
 		ctx,
 		cx.StmtSetCombinedPreferences,
-		map[string]interface{}{
-			"character_id":     charID,
-			"header":           p.Donations.Header,
-			"footer":           p.Donations.Footer,
-			"rows":             p.Donations.Rows,
-			"max_age":          p.Donations.MaxAge,
-			"donation_pattern": p.Donations.Pattern,
-			"contract_pattern": p.Contracts.Pattern,
-			"donation_minimum": p.Donations.Minimum,
-			"contract_minimum": p.Contracts.Minimum,
-			"passphrase":       p.Donations.Passphrase,
-		},
+		combinedValues(charID, p),
 	)
 }
 
 func setPrefs(ctx context.Context, charID int32, p *Prefs, key cx.Key) error {
-	return executeNamed(
-		ctx,
-		key,
-		map[string]interface{}{
-			"character_id": charID,
-			"header":       p.Header,
-			"footer":       p.Footer,
-			"pattern":      p.Pattern,
-			"rows":         p.Rows,
-			"minimum":      p.Minimum,
-			"max_age":      p.MaxAge,
-			"passphrase":   p.Passphrase,
-		},
-	)
+	return updatePreferences(ctx, key, prefsValues(charID, p))
+}
+
+// updatePreferences runs the update, returning errUnknownPreferences when
+// the character has no preferences, such as once it is purged
+func updatePreferences(
+	ctx context.Context,
+	key cx.Key,
+	values map[string]interface{},
+) error {
+	updated, err := executeNamedCount(ctx, key, values)
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return errUnknownPreferences
+	}
+	return nil
+}
+
+func combinedValues(charID int32, p *Preferences) map[string]interface{} {
+	return map[string]interface{}{
+		"character_id":     charID,
+		"header":           p.Donations.Header,
+		"footer":           p.Donations.Footer,
+		"rows":             p.Donations.Rows,
+		"max_age":          p.Donations.MaxAge,
+		"donation_pattern": p.Donations.Pattern,
+		"contract_pattern": p.Contracts.Pattern,
+		"donation_minimum": p.Donations.Minimum,
+		"contract_minimum": p.Contracts.Minimum,
+		"passphrase":       p.Donations.Passphrase,
+	}
+}
+
+func prefsValues(charID int32, p *Prefs) map[string]interface{} {
+	return map[string]interface{}{
+		"character_id": charID,
+		"header":       p.Header,
+		"footer":       p.Footer,
+		"pattern":      p.Pattern,
+		"rows":         p.Rows,
+		"minimum":      p.Minimum,
+		"max_age":      p.MaxAge,
+		"passphrase":   p.Passphrase,
+	}
+}
+
+// PreferenceDocument holds the preferences of every view, for backups or
+// copying preferences between characters
+type PreferenceDocument struct {
+	Donations *Prefs       `json:"donations"`
+	Contracts *Prefs       `json:"contracts"`
+	Combined  *Preferences `json:"combined"`
+}
+
+// GetPreferenceDocument returns the preferences of all views of the character
+func GetPreferenceDocument(ctx context.Context, charID int32) (
+	*PreferenceDocument,
+	error,
+) {
+	dbp, err := dbPrefs(ctx, charID)
+	if err != nil {
+		return nil, err
+	}
+
+	views := map[string]*Preferences{}
+	for _, t := range []string{"d", "c", "a"} {
+		if views[t], err = dbp.toPreferences(ctx, t); err != nil {
+			return nil, err
+		}
+	}
+
+	return &PreferenceDocument{
+		Donations: views["d"].Donations,
+		Contracts: views["c"].Contracts,
+		Combined:  views["a"],
+	}, nil
+}
+
+// Sanity ensures every view is present and acceptable
+func (d *PreferenceDocument) Sanity(ctx context.Context) error {
+	if d.Donations == nil || d.Contracts == nil || d.Combined == nil ||
+		d.Combined.Donations == nil || d.Combined.Contracts == nil {
+		return UserError{
+			Msg:  []byte("Preferences for every view are required"),
+			Code: 400,
+		}
+	}
+
+	for _, p := range []*Prefs{
+		d.Donations,
+		d.Contracts,
+		d.Combined.Donations,
+		d.Combined.Contracts,
+	} {
+		if err := p.Sanity(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ImportPreferences replaces the preferences of every view of the character,
+// either all of the views are updated or none are
+func ImportPreferences(
+	ctx context.Context,
+	charID int32,
+	d *PreferenceDocument,
+) error {
+	return transaction(ctx, func(tx *sqlx.Tx) error {
+		for key, values := range map[cx.Key]map[string]interface{}{
+			cx.StmtSetDonationPreferences: prefsValues(charID, d.Donations),
+			cx.StmtSetContractPreferences: prefsValues(charID, d.Contracts),
+			cx.StmtSetCombinedPreferences: combinedValues(charID, d.Combined),
+		} {
+			if err := executeNamedTx(ctx, tx, key, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 // Sanity ensures our attribute lengths are acceptable
@@ -266,6 +374,10 @@ func (p *Prefs) Sanity(ctx context.Context) error {
 		}
 	}
 
+	if IsTemplate(p.Pattern) {
+		return CheckPattern(p.Pattern)
+	}
+
 	return nil
 }
 
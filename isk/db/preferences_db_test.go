@@ -0,0 +1,75 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// loadPreferences creates the default preferences of the character
+func loadPreferences(t *testing.T, ctx context.Context, charID int32) {
+	if err := executeNamed(
+		ctx,
+		cx.StmtCreatePreferences,
+		map[string]interface{}{"character_id": charID},
+	); err != nil {
+		t.Fatalf("failed to load preferences of %d: %+v", charID, err)
+	}
+}
+
+// deletePreferences removes the preferences, as a concurrent purge would
+func deletePreferences(t *testing.T, ctx context.Context, charID int32) {
+	if err := executeNamed(
+		ctx,
+		cx.StmtDeletePreferences,
+		map[string]interface{}{"character_id": charID},
+	); err != nil {
+		t.Fatalf("failed to delete preferences of %d: %+v", charID, err)
+	}
+}
+
+func TestSetPreferencesDB(t *testing.T) {
+	ctx := testDB(t)
+	loadCharacters(t, ctx, &CharacterRow{ID: 1})
+	loadPreferences(t, ctx, 1)
+
+	donations := &Prefs{Pattern: "%CHARACTER% sent %AMOUNT%", Rows: 3}
+	p := &Preferences{Donations: donations}
+	if err := SetPreferences(ctx, 1, p); err != nil {
+		t.Fatalf("failed to set preferences: %+v", err)
+	}
+
+	saved, err := GetPreferences(ctx, "d", 1)
+	if err != nil {
+		t.Fatalf("failed to get preferences: %+v", err)
+	}
+	if saved.Donations.Pattern != donations.Pattern ||
+		saved.Donations.Rows != 3 {
+		t.Errorf("unexpected preferences %+v", saved.Donations)
+	}
+}
+
+func TestSetPreferencesRemovedDB(t *testing.T) {
+	ctx := testDB(t)
+	loadCharacters(t, ctx, &CharacterRow{ID: 1})
+	loadPreferences(t, ctx, 1)
+
+	if _, err := GetPreferences(ctx, "c", 1); err != nil {
+		t.Fatalf("failed to get preferences: %+v", err)
+	}
+	deletePreferences(t, ctx, 1)
+
+	err := SetPreferences(ctx, 1, &Preferences{Contracts: &Prefs{Rows: 1}})
+	if ue, ok := err.(UserError); !ok || ue.Code != 404 {
+		t.Errorf("expected a 404 for removed preferences, received %+v", err)
+	}
+
+	err = SetPrivacy(ctx, 1, &Privacy{Anonymous: true})
+	if ue, ok := err.(UserError); !ok || ue.Code != 404 {
+		t.Errorf("expected a 404 for removed privacy, received %+v", err)
+	}
+}
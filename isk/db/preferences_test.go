@@ -1,8 +1,11 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
 )
 
 func TestGetPattern(t *testing.T) {
@@ -53,3 +56,46 @@ func TestGetPattern(t *testing.T) {
 		t.Errorf("invalid pattern. received %q, expected %q", p4, s5.String)
 	}
 }
+
+func TestPreferenceDocumentSanity(t *testing.T) {
+	ctx := context.WithValue(context.Background(), cx.Opts, &cx.Options{
+		MaxPrefRows:   10,
+		MaxPrefLen:    10,
+		MaxPatternLen: 20,
+	})
+	valid := func() *PreferenceDocument {
+		return &PreferenceDocument{
+			Donations: &Prefs{Pattern: "%CHARACTER%", Rows: 50},
+			Contracts: &Prefs{Pattern: "{{name}}"},
+			Combined: &Preferences{
+				Donations: &Prefs{Pattern: "{{amount}}"},
+				Contracts: &Prefs{Pattern: "{{corp}}"},
+			},
+		}
+	}
+
+	d := valid()
+	if err := d.Sanity(ctx); err != nil {
+		t.Fatalf("valid document was rejected: %+v", err)
+	}
+	if d.Donations.Rows != 10 || d.Contracts.Rows != 1 {
+		t.Errorf("rows were not limited: %d, %d", d.Donations.Rows, d.Contracts.Rows)
+	}
+
+	for name, mutate := range map[string]func(*PreferenceDocument){
+		"no donations":          func(d *PreferenceDocument) { d.Donations = nil },
+		"no combined":           func(d *PreferenceDocument) { d.Combined = nil },
+		"no combined donations": func(d *PreferenceDocument) { d.Combined.Donations = nil },
+		"long header":           func(d *PreferenceDocument) { d.Contracts.Header = "header text" },
+		"long pattern":          func(d *PreferenceDocument) { d.Donations.Pattern = "%CHARACTER% %AMOUNT% ISK!" },
+		"unknown token":         func(d *PreferenceDocument) { d.Combined.Contracts.Pattern = "{{items}}" },
+	} {
+		d := valid()
+		mutate(d)
+		if err := d.Sanity(ctx); err == nil {
+			t.Errorf("%s: expected an error", name)
+		} else if ue, ok := err.(UserError); !ok || ue.Code != 400 {
+			t.Errorf("%s: expected a 400 user error, received %+v", name, err)
+		}
+	}
+}
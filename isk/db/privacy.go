@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// AnonymousName is shown in place of donators who are anonymous
+const AnonymousName = "Anonymous"
+
+// RemovedName is the name kept of characters removed without purging
+const RemovedName = "Deleted Character"
+
+// Privacy holds the character wide privacy preferences
+type Privacy struct {
+	// Anonymous donators are shown as AnonymousName to everyone other than
+	// who they donated to
+	Anonymous bool `json:"anonymous"`
+
+	// Hidden characters are left out of the leaderboards and lookups, and
+	// their character details are only shown to themselves
+	Hidden bool `json:"hidden"`
+}
+
+// GetPrivacy returns the privacy preferences of the character
+func GetPrivacy(ctx context.Context, charID int32) (*Privacy, error) {
+	dbp, err := dbPrefs(ctx, charID)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := GetCharacter(ctx, charID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Privacy{Anonymous: dbp.Anonymous, Hidden: c.Hidden}, nil
+}
+
+// SetPrivacy stores the privacy preferences of the character
+func SetPrivacy(ctx context.Context, charID int32, p *Privacy) error {
+	return transaction(ctx, func(tx *sqlx.Tx) error {
+		updated, err := executeNamedTxCount(
+			ctx,
+			tx,
+			cx.StmtSetPrivacy,
+			map[string]interface{}{
+				"character_id": charID,
+				"anonymous":    p.Anonymous,
+			},
+		)
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return errUnknownPreferences
+		}
+
+		return executeNamedTx(ctx, tx, cx.StmtSetHidden, map[string]interface{}{
+			"character_id": charID,
+			"hidden":       p.Hidden,
+		})
+	})
+}
+
+// counterpartyRow is a row of StmtCounterparties
+type counterpartyRow struct {
+	ID int32 `db:"character_id"`
+}
+
+// GetCounterparties returns the characters the character sent donations or
+// contracts to, or received them from, whose pages show the character
+func GetCounterparties(ctx context.Context, charID int32) ([]int32, error) {
+	rows, err := queryNamedResult(ctx, cx.StmtCounterparties, map[string]interface{}{
+		"character_id": charID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int32{}
+	err = each(rows, func() interface{} { return &counterpartyRow{} }, func(
+		i interface{},
+	) error {
+		ids = append(ids, i.(*counterpartyRow).ID)
+		return nil
+	})
+	return ids, err
+}
+
+// GetAnonymous returns which of the characters are anonymous donators
+func GetAnonymous(ctx context.Context, ids []int32) (map[int32]bool, error) {
+	anonymous := map[int32]bool{}
+	if len(ids) == 0 {
+		return anonymous, nil
+	}
+
+	rows, err := queryIn(ctx, queryAnonymousIn, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	err = each(rows, func() interface{} { return &anonymousRow{} }, func(
+		i interface{},
+	) error {
+		anonymous[i.(*anonymousRow).ID] = true
+		return nil
+	})
+	return anonymous, err
+}
+
+type anonymousRow struct {
+	ID int32 `db:"character_id"`
+}
+
+// MaskAnonymous replaces anonymous donators of the character's received
+// donations and contracts, and of its largest ones, with the 0 ID, without
+// a counterparty
+func (c *CharDetails) MaskAnonymous(ctx context.Context) error {
+	ids := []int32{}
+	for _, d := range c.Donations {
+		ids = append(ids, d.Donator)
+	}
+	for _, k := range c.Contracts {
+		ids = append(ids, k.Donator)
+	}
+	for _, l := range c.Character.largest() {
+		ids = append(ids, l.Donator)
+	}
+
+	anonymous, err := GetAnonymous(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range c.Donations {
+		if anonymous[d.Donator] {
+			d.Donator = 0
+			d.Counterparty = nil
+		}
+	}
+	for _, k := range c.Contracts {
+		if anonymous[k.Donator] {
+			k.Donator = 0
+			k.Counterparty = nil
+		}
+	}
+	for _, l := range c.Character.largest() {
+		if anonymous[l.Donator] {
+			l.Donator = 0
+		}
+	}
+	return nil
+}
+
+// MaskAnonymous replaces an anonymous donator of the donation with the 0 ID,
+// without a party
+func (d *DonationRecord) MaskAnonymous(ctx context.Context) error {
+	return maskDonator(ctx, &d.Donator, &d.DonatorParty)
+}
+
+// MaskAnonymous replaces an anonymous donator of the contract with the 0 ID,
+// without a party
+func (k *ContractRecord) MaskAnonymous(ctx context.Context) error {
+	return maskDonator(ctx, &k.Donator, &k.DonatorParty)
+}
+
+func maskDonator(ctx context.Context, donator *int32, party **Party) error {
+	anonymous, err := GetAnonymous(ctx, []int32{*donator})
+	if err != nil {
+		return err
+	}
+	if anonymous[*donator] {
+		*donator = 0
+		*party = nil
+	}
+	return nil
+}
+
+// MaskAnonymousSupporters replaces anonymous supporters with the 0 ID and
+// AnonymousName
+func MaskAnonymousSupporters(ctx context.Context, supporters []*Supporter) error {
+	ids := []int32{}
+	for _, s := range supporters {
+		ids = append(ids, s.ID)
+	}
+
+	anonymous, err := GetAnonymous(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range supporters {
+		if anonymous[s.ID] {
+			s.ID = 0
+			s.Name = AnonymousName
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetCounterpartiesDB(t *testing.T) {
+	ctx := testDB(t)
+
+	received := testDonation(2, 0, 500)
+	received.Donator, received.Recipient = 3, 2
+	anonymized := testDonation(3, 0, 500)
+	anonymized.Donator, anonymized.Recipient = 0, 2
+	self := testDonation(4, 0, 500)
+	self.Donator, self.Recipient = 2, 2
+	loadDonations(t, ctx, testDonation(1, 0, 1000), received, anonymized, self)
+
+	contract := testSharedContract("outstanding")
+	contract.Receiver = 4
+	loadContracts(t, ctx, contract)
+
+	charIDs, err := GetCounterparties(ctx, 2)
+	if err != nil {
+		t.Fatalf("failed to get counterparties: %+v", err)
+	}
+	if expected := []int32{1, 3, 4}; !reflect.DeepEqual(charIDs, expected) {
+		t.Errorf("expected counterparties %v, received %v", expected, charIDs)
+	}
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// ProofQuery asks if the donor sent the recipient at least MinAmount ISK
+// after a time
+type ProofQuery struct {
+	Donor, Recipient int32
+	MinAmount        ISK
+	After            time.Time
+
+	// Party is set when the donor or recipient is asking, who may see what
+	// anonymous and hidden characters sent
+	Party bool
+}
+
+// Proof is the answer to a ProofQuery
+type Proof struct {
+	Donated     bool    `json:"donated"`
+	DonationIDs []int64 `json:"donation_ids"`
+	Total       ISK     `json:"total"`
+}
+
+type proofRow struct {
+	IDs   pq.Int64Array `db:"ids"`
+	Total ISK           `db:"total"`
+}
+
+// ProveDonated returns the donations matching the query and their total.
+// Donated is set when there are any, totalling at least the MinAmount
+func ProveDonated(ctx context.Context, q *ProofQuery) (*Proof, error) {
+	rows, err := queryNamedResult(ctx, cx.StmtProveDonated, map[string]interface{}{
+		"donor":     q.Donor,
+		"recipient": q.Recipient,
+		"after":     q.After,
+		"party":     q.Party,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &proofRow{} })
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Proof{DonationIDs: []int64{}}
+	if len(res) > 0 {
+		row := res[0].(*proofRow)
+		p.DonationIDs = append(p.DonationIDs, row.IDs...)
+		p.Total = row.Total
+	}
+	p.Donated = len(p.DonationIDs) > 0 && p.Total >= q.MinAmount
+	return p, nil
+}
@@ -0,0 +1,44 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProveDonatedDB(t *testing.T) {
+	ctx := testDB(t)
+
+	loadDonations(
+		t,
+		ctx,
+		testDonation(1, 0, 100),
+		testDonation(2, 2, 50),
+		testDonation(3, 4, 25),
+	)
+
+	q := &ProofQuery{Donor: 2, Recipient: 1, MinAmount: NewISK(100)}
+	q.After = testAt.Add(-1)
+	p, err := ProveDonated(ctx, q)
+	if err != nil {
+		t.Fatalf("failed to prove donations: %+v", err)
+	}
+	if !p.Donated || p.Total != NewISK(175) ||
+		!reflect.DeepEqual(p.DonationIDs, []int64{1, 2, 3}) {
+		t.Errorf("unexpected proof %+v", p)
+	}
+
+	q.After = testAt.Add(1)
+	if p, err = ProveDonated(ctx, q); err != nil || p.Donated ||
+		p.Total != NewISK(75) {
+		t.Errorf("expected the total after to be short, received %+v", p)
+	}
+
+	q.Donor, q.Recipient = 1, 2
+	if p, err = ProveDonated(ctx, q); err != nil || p.Donated ||
+		len(p.DonationIDs) != 0 {
+		t.Errorf("expected no donations the other way, received %+v", p)
+	}
+}
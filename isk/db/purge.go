@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// PurgeCharacter removes the token, owner link, preferences, slugs, refresh
+// jobs, name, affiliation history and page views of the character, in one
+// transaction.
+// With anonymize, the character is replaced by 0 as the donator of everything
+// it sent, and keeps its totals and everything it received, hidden and named
+// RemovedName so it is left out of everything public. Otherwise the
+// character row and every donation and contract to or from it are deleted,
+// and removed from the totals of the other characters
+func PurgeCharacter(ctx context.Context, charID int32, anonymize bool) error {
+	// serialized with the totals saved by workers
+	unlock, err := LockTotals(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	charIDs := []int32{charID}
+
+	err = transaction(ctx, func(tx *sqlx.Tx) error {
+		keys := []cx.Key{
+			cx.StmtDeleteUser,
+			cx.StmtDeleteOwner,
+			cx.StmtDeletePreferences,
+			cx.StmtDeleteSlugs,
+			cx.StmtDeleteRefreshes,
+			cx.StmtDeleteName,
+			cx.StmtDeleteAffiliations,
+			cx.StmtDeleteViews,
+			cx.StmtDeleteHourViews,
+		}
+
+		if anonymize {
+			keys = append(
+				keys,
+				cx.StmtAnonymizeDonations,
+				cx.StmtAnonymizeArchive,
+				cx.StmtAnonymizeContracts,
+				cx.StmtAnonymizeLargest,
+				cx.StmtHideRemoved,
+				cx.StmtNameRemoved,
+			)
+		} else {
+			others, err := purgeCounterparties(ctx, tx, charID, opts.CountSelf)
+			if err != nil {
+				return err
+			}
+			for _, other := range others {
+				if err := executeNamedTx(
+					ctx,
+					tx,
+					cx.StmtUpdateCharacter,
+					characterValues(other),
+				); err != nil {
+					return err
+				}
+				charIDs = append(charIDs, other.ID)
+			}
+
+			keys = append(
+				keys,
+				cx.StmtPurgeDonations,
+				cx.StmtPurgeArchive,
+				cx.StmtPurgeContractItems,
+				cx.StmtPurgeContracts,
+				cx.StmtDeleteCharacter,
+			)
+		}
+
+		values := map[string]interface{}{"character_id": charID}
+		for _, key := range keys {
+			if err := executeNamedTx(ctx, tx, key, values); err != nil {
+				return err
+			}
+		}
+
+		// the largest received by the others, and the days of their streaks,
+		// may have been from the character
+		for _, otherID := range charIDs[1:] {
+			if err := executeNamedTx(
+				ctx,
+				tx,
+				cx.StmtRecalculateLargest,
+				map[string]interface{}{"character_id": otherID},
+			); err != nil {
+				return err
+			}
+			if err := recalculateStreaksTx(ctx, tx, otherID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := NotifyCharacters(ctx, charIDs); err != nil {
+		cx.Logf(ctx, "failed to notify character updates: %+v", err)
+	}
+	return nil
+}
+
+// purgeCounterparties returns the characters the character sent to or
+// received from, with those donations and contracts removed from their totals
+func purgeCounterparties(
+	ctx context.Context,
+	tx *sqlx.Tx,
+	charID int32,
+	countSelf bool,
+) ([]*CharacterRow, error) {
+	values := map[string]interface{}{"character_id": charID}
+
+	donations := Donations{}
+	for _, key := range []cx.Key{cx.StmtCharDonations, cx.StmtCharDonated} {
+		rows, err := queryNamedTx(ctx, tx, key, values)
+		if err != nil {
+			return nil, err
+		}
+		res, err := scan(rows, func() interface{} { return &Donation{} })
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range res {
+			donations = append(donations, i.(*Donation))
+		}
+	}
+
+	contracts := Contracts{}
+	for _, key := range []cx.Key{cx.StmtCharContracts, cx.StmtCharContracted} {
+		rows, err := queryNamedTx(ctx, tx, key, values)
+		if err != nil {
+			return nil, err
+		}
+		res, err := scan(rows, func() interface{} { return &Contract{} })
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range res {
+			contracts = append(contracts, i.(*Contract))
+		}
+	}
+
+	others := map[int32]*CharacterRow{}
+	for _, otherID := range counterparties(charID, donations, contracts) {
+		other, err := txCharacterRow(ctx, tx, otherID)
+		if err == ErrCharacterNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		others[otherID] = other
+	}
+
+	purgeTotals(charID, donations, contracts, others, countSelf)
+
+	rows := []*CharacterRow{}
+	for _, other := range others {
+		rows = append(rows, other)
+	}
+	return rows, nil
+}
+
+// counterparties returns the characters on the other side of the donations
+// and contracts of the character, other than anonymous donators
+func counterparties(
+	charID int32,
+	donations Donations,
+	contracts Contracts,
+) []int32 {
+	charIDs := []int32{}
+	add := func(donator, receiver int32) {
+		other := counterparty(charID, donator, receiver)
+		if other != 0 && !inInt32(other, charIDs) {
+			charIDs = append(charIDs, other)
+		}
+	}
+	for _, donation := range donations {
+		add(donation.Donator, donation.Recipient)
+	}
+	for _, contract := range contracts {
+		add(contract.Donator, contract.Receiver)
+	}
+	return charIDs
+}
+
+// counterparty returns the other side of a transfer of the character
+func counterparty(charID, donator, receiver int32) int32 {
+	if donator == charID {
+		return receiver
+	}
+	return donator
+}
+
+// purgeTotals removes the counted donations and accepted contracts of the
+// character from the totals of the others it sent to or received from
+func purgeTotals(
+	charID int32,
+	donations Donations,
+	contracts Contracts,
+	others map[int32]*CharacterRow,
+	countSelf bool,
+) {
+	for _, donation := range donations {
+		if donation.SelfDonation && !countSelf {
+			continue
+		}
+		other := others[counterparty(charID, donation.Donator, donation.Recipient)]
+		if other != nil {
+			reverseTotals(donation, []*CharacterRow{other})
+		}
+	}
+
+	for _, contract := range contracts {
+		if !contract.Accepted {
+			continue
+		}
+		other := others[counterparty(charID, contract.Donator, contract.Receiver)]
+		if other != nil {
+			reverseContractTotals(contract, []*CharacterRow{other})
+		}
+	}
+}
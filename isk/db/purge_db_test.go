@@ -0,0 +1,46 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"testing"
+)
+
+func TestPurgeCharacterAnonymizeDB(t *testing.T) {
+	ctx := testDB(t)
+	testAffiliations(t, ctx)
+	loadCharacters(t, ctx, &CharacterRow{
+		ID:           1,
+		Received:     1,
+		ReceivedISK:  NewISK(1000),
+		GoodStanding: true,
+	})
+
+	top, err := GetTopRecipients(ctx, WindowAll)
+	if err != nil {
+		t.Fatalf("failed to get top recipients: %+v", err)
+	}
+	if len(top) != 1 {
+		t.Fatalf("expected the recipient listed, received %+v", top)
+	}
+
+	if err := PurgeCharacter(ctx, 1, true); err != nil {
+		t.Fatalf("failed to remove character: %+v", err)
+	}
+
+	// kept, but left out of everything public
+	char := getTestCharacter(t, ctx, 1)
+	if !char.Hidden || char.ReceivedISK != NewISK(1000) {
+		t.Errorf("unexpected removed character %+v", char)
+	}
+	if name, err := GetName(ctx, 1); err != nil || name != RemovedName {
+		t.Errorf("expected the name %q, received %q: %+v", RemovedName, name, err)
+	}
+	if top, err = GetTopRecipients(ctx, WindowAll); err != nil {
+		t.Fatalf("failed to get top recipients: %+v", err)
+	}
+	if len(top) != 0 {
+		t.Errorf("expected the removed character unlisted, received %+v", top)
+	}
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestPurgeTotals(t *testing.T) {
+	purged, donor, recipient := int32(1), int32(2), int32(3)
+
+	// everything of the purged character, and a donation between the others
+	donations := Donations{
+		{ID: 1, Donator: donor, Recipient: purged, Amount: 10000},
+		{ID: 2, Donator: purged, Recipient: recipient, Amount: 5000},
+		{ID: 3, Donator: 0, Recipient: purged, Amount: 700},
+		{ID: 4, Donator: donor, Recipient: purged, Amount: 900, SelfDonation: true},
+	}
+	contracts := Contracts{
+		{ID: 5, Donator: donor, Receiver: purged, Value: 2000, Accepted: true},
+		{ID: 6, Donator: purged, Receiver: recipient, Value: 300},
+	}
+	unrelated := &Donation{ID: 7, Donator: donor, Recipient: recipient, Amount: 40}
+
+	others := map[int32]*CharacterRow{
+		donor:     {ID: donor},
+		recipient: {ID: recipient},
+	}
+	chars := []*CharacterRow{others[donor], others[recipient]}
+	for _, donation := range append(donations[:3:3], unrelated) {
+		addToTotals(donation, chars)
+	}
+	addToContractTotals(contracts[0], chars)
+
+	if ids := counterparties(purged, donations, contracts); !reflect.DeepEqual(
+		ids,
+		[]int32{donor, recipient},
+	) {
+		t.Errorf("unexpected counterparties: %v", ids)
+	}
+
+	purgeTotals(purged, donations, contracts, others, false)
+
+	expected := map[int32]*CharacterRow{
+		donor: {
+			ID:           donor,
+			Donated:      1,
+			DonatedISK:   40,
+			Donated7:     1,
+			DonatedISK7:  40,
+			Donated30:    1,
+			DonatedISK30: 40,
+			Donated90:    1,
+			DonatedISK90: 40,
+		},
+		recipient: {
+			ID:            recipient,
+			Received:      1,
+			ReceivedISK:   40,
+			Received7:     1,
+			ReceivedISK7:  40,
+			Received30:    1,
+			ReceivedISK30: 40,
+			Received90:    1,
+			ReceivedISK90: 40,
+		},
+	}
+	for charID, char := range others {
+		// only the unrelated donation is left, last seen times are kept and
+		// streaks are recalculated from the stored donations
+		char.LastDonated, char.LastReceived = pq.NullTime{}, pq.NullTime{}
+		char.streakStale = false
+		if !reflect.DeepEqual(char, expected[charID]) {
+			t.Errorf(
+				"character %d totals: %+v, expected %+v",
+				charID,
+				char,
+				expected[charID],
+			)
+		}
+	}
+}
@@ -1,42 +1,791 @@
 package db
 
 import (
-	"context"
 	"fmt"
-	"log"
+	"sort"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
 
 	"github.com/a-tal/esi-isk/isk/cx"
 )
 
-// GetStatements prepares all queries for the global context
-func GetStatements(ctx context.Context) map[cx.Key]*sqlx.NamedStmt {
-	db := ctx.Value(cx.DB).(*sqlx.DB)
-	opts := ctx.Value(cx.Opts).(*cx.Options)
+// queries with IN clauses, expanded per call by queryIn
+const (
+	queryCharactersIn = `SELECT * FROM characters
+WHERE NOT hidden AND NOT banned AND character_id IN (?)`
+	queryNamesIn     = `SELECT * FROM names WHERE id IN (?)`
+	queryLocationsIn = `SELECT * FROM locations WHERE location_id IN (?)`
+	queryAnonymousIn = `SELECT character_id FROM preferences
+WHERE anonymous AND character_id IN (?)`
+)
+
+// advisory locks are taken on a connection of their own, or within the
+// transaction of WithTx, not prepared
+const (
+	queryAdvisoryLock    = `SELECT pg_advisory_lock($1)`
+	queryTryAdvisoryLock = `SELECT pg_try_advisory_lock($1)`
+	queryAdvisoryUnlock  = `SELECT pg_advisory_unlock($1)`
+	queryAdvisoryTxLock  = `SELECT pg_advisory_xact_lock($1)`
+)
+
+// bulk inserts COPY into a temporary table of the same columns, then insert
+// the rows whose key is not stored (or archived) yet, see copyNew
+const (
+	queryCopyTable = `CREATE TEMPORARY TABLE copy_%[1]s
+(LIKE %[1]s INCLUDING DEFAULTS) ON COMMIT DROP`
+	queryInsertCopied = `INSERT INTO %[1]s (%[2]s)
+SELECT %[2]s FROM copy_%[1]s%[4]s
+ON CONFLICT (%[3]s) DO NOTHING RETURNING %[3]s`
+	queryCopiedUnarchived = `
+WHERE NOT EXISTS (
+    SELECT 1 FROM %[2]s WHERE %[2]s.%[3]s = copy_%[1]s.%[3]s
+)`
+)
+
+// migrations are applied before our statements can be prepared
+const (
+	queryCreateMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    name       TEXT      NOT NULL,
+    applied_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    PRIMARY KEY (name)
+)`
+	queryLockMigrations   = `SELECT pg_advisory_xact_lock($1)`
+	queryMigrationApplied = `SELECT EXISTS (
+    SELECT 1 FROM schema_migrations WHERE name = $1
+)`
+	querySaveMigration = `INSERT INTO schema_migrations (name) VALUES ($1)`
+)
+
+// storedDonations are the donations of both the donations table and the
+// archive, which has the same columns in the same order
+const storedDonations = `(
+    SELECT * FROM donations
+    UNION ALL
+    SELECT * FROM donations_archive
+) AS donations`
+
+// transfers are all donations and contracts, as db.Transfer rows
+const transfers = `(
+    SELECT
+        'donation' AS type,
+        transaction_id AS id,
+        donator,
+        receiver,
+        "timestamp",
+        amount AS isk,
+        note,
+        '' AS status,
+        true AS accepted
+    FROM ` + storedDonations + `
+    WHERE voided_at IS NULL
+    UNION ALL
+    SELECT
+        'contract' AS type,
+        contract_id AS id,
+        donator,
+        receiver,
+        issued AS "timestamp",
+        value AS isk,
+        note,
+        status,
+        accepted
+    FROM contracts
+) AS transfers`
+
+// monthTop is the top :limit visible characters in good standing by ISK received (or
+// donated) between :since and :until, for the leaderboard history. Donations
+// of the month have partly been archived by its end, so both are read
+func monthTop(column, board, counted, unflagged string) string {
+	return fmt.Sprintf(`(
+    SELECT
+        '%[2]s' AS board,
+        ROW_NUMBER() OVER (ORDER BY SUM(isk) DESC, %[1]s) AS position,
+        %[1]s AS character_id,
+        COUNT(*) AS count,
+        SUM(isk) AS isk
+    FROM (
+        SELECT %[1]s, amount AS isk FROM donations
+        WHERE %[3]s"timestamp" >= :since AND "timestamp" < :until
+        UNION ALL
+        SELECT %[1]s, amount AS isk FROM donations_archive
+        WHERE %[3]s"timestamp" >= :since AND "timestamp" < :until
+        UNION ALL
+        SELECT %[1]s, value AS isk FROM contracts
+        WHERE accepted AND issued >= :since AND issued < :until
+    ) AS month
+    JOIN characters ON characters.character_id = month.%[1]s
+    WHERE characters.good_standing
+    AND NOT characters.hidden AND NOT characters.banned%[4]s
+    GROUP BY %[1]s
+    ORDER BY position
+    LIMIT :limit
+)`, column, board, counted, unflagged)
+}
+
+// unflagged leaves the members of donation rings pending review out of the
+// leaderboards, if the options exclude them
+func unflagged(opts *cx.Options, column string) string {
+	if !opts.ExcludeRings {
+		return ""
+	}
+	return fmt.Sprintf(`
+AND %s NOT IN (
+    SELECT unnest(members) FROM donation_rings WHERE reviewed_at IS NULL
+)`, column)
+}
+
+// inStanding leaves characters in bad standing out of the lists which
+// don't always, if the options exclude them
+func inStanding(opts *cx.Options, column string) string {
+	if !opts.ExcludeBadStanding {
+		return ""
+	}
+	return fmt.Sprintf(`
+AND %s NOT IN (SELECT character_id FROM characters WHERE NOT good_standing)`,
+		column,
+	)
+}
+
+// detectRings stores and returns the pairs, and rings of three, of characters
+// each sending the next at least :threshold ISK since :since, with the least
+// any of them sent within :tolerance of the most. Rings already stored are
+// updated, keeping their review
+func detectRings(counted string) string {
+	return `WITH flows AS (
+    SELECT donator, receiver, SUM(isk) AS isk FROM (
+        SELECT donator, receiver, amount AS isk FROM donations
+        WHERE ` + counted + `"timestamp" >= :since
+        UNION ALL
+        SELECT donator, receiver, value AS isk FROM contracts
+        WHERE accepted AND issued >= :since
+    ) AS sent
+    WHERE donator <> receiver
+    GROUP BY donator, receiver
+    HAVING SUM(isk) >= :threshold
+), rings AS (
+    SELECT
+        ARRAY[a.donator, a.receiver] AS members,
+        LEAST(a.isk, b.isk) AS low,
+        GREATEST(a.isk, b.isk) AS high
+    FROM flows AS a
+    JOIN flows AS b ON b.donator = a.receiver AND b.receiver = a.donator
+    WHERE a.donator < a.receiver
+    UNION ALL
+    SELECT
+        ARRAY[a.donator, a.receiver, b.receiver],
+        LEAST(a.isk, b.isk, c.isk),
+        GREATEST(a.isk, b.isk, c.isk)
+    FROM flows AS a
+    JOIN flows AS b ON b.donator = a.receiver
+    JOIN flows AS c ON c.donator = b.receiver AND c.receiver = a.donator
+    WHERE a.donator < a.receiver AND a.donator < b.receiver
+)
+INSERT INTO donation_rings (members, low, high, detected_at, last_seen)
+SELECT members, low, high, :now, :now FROM rings
+WHERE low >= high * (1 - CAST(:tolerance AS DOUBLE PRECISION))
+ON CONFLICT (members) DO UPDATE SET
+    low = EXCLUDED.low,
+    high = EXCLUDED.high,
+    last_seen = EXCLUDED.last_seen
+RETURNING ` + ringColumns
+}
+
+// ringColumns are the columns of a DonationRing
+const ringColumns = `id,
+    members,
+    low,
+    high,
+    detected_at,
+    last_seen,
+    reviewed_at,
+    reviewed_by`
+
+// sourceTotal counts, or sums the ISK of, the counted donations and accepted
+// contracts received (or donated) by characters.character_id which have not
+// aged out of the window, so the character's total over it. Donations are
+// archived as they age out of the 30 day window, so only longer windows, and
+// the lifetime totals, read the archive
+func sourceTotal(column string, isk bool, counted string, window Window) string {
+	donations, contracts := "COUNT(*)", "COUNT(*)"
+	if isk {
+		donations, contracts = "COALESCE(SUM(amount), 0)", "COALESCE(SUM(value), 0)"
+	}
+	aged := ""
+	if window != WindowAll {
+		aged = fmt.Sprintf("aged_days < %d AND ", int(window))
+	}
+	archived := ""
+	if window == WindowAll || window > WindowMonth {
+		archived = fmt.Sprintf(` + (
+        SELECT %[1]s FROM donations_archive
+        WHERE %[2]s%[3]s%[4]s = characters.character_id
+    )`, donations, counted, aged, column)
+	}
+	return fmt.Sprintf(`(
+        SELECT %[1]s FROM donations
+        WHERE %[3]s%[5]s%[4]s = characters.character_id
+    )%[6]s + (
+        SELECT %[2]s FROM contracts
+        WHERE accepted AND %[5]s%[4]s = characters.character_id
+    )`, donations, contracts, counted, column, aged, archived)
+}
+
+// recalculateTotals sets the totals of every window from the stored rows
+func recalculateTotals(counted string) string {
+	sets := []string{}
+	for _, w := range Windows {
+		for _, column := range []string{"receiver", "donator"} {
+			prefix := "received"
+			if column == "donator" {
+				prefix = "donated"
+			}
+			sets = append(
+				sets,
+				prefix+w.suffix()+" = "+sourceTotal(column, false, counted, w),
+				prefix+"_isk"+w.suffix()+" = "+sourceTotal(column, true, counted, w),
+			)
+		}
+	}
+	return "UPDATE characters SET\n    " + strings.Join(sets, ",\n    ")
+}
+
+// checkTotalsQuery selects the totals of every window of every character, or
+// only of :character_id if it is not 0, with those counted from the stored
+// rows as source
+func checkTotalsQuery(counted string) string {
+	columns := []string{"character_id"}
+	for _, w := range allWindows() {
+		for _, column := range []string{"receiver", "donator"} {
+			prefix := "received"
+			if column == "donator" {
+				prefix = "donated"
+			}
+			for _, isk := range []bool{false, true} {
+				name := prefix + w.suffix()
+				if isk {
+					name = prefix + "_isk" + w.suffix()
+				}
+				columns = append(
+					columns,
+					name,
+					sourceTotal(column, isk, counted, w)+` AS "source.`+name+`"`,
+				)
+			}
+		}
+	}
+	return "SELECT\n    " + strings.Join(columns, ",\n    ") + `
+FROM characters
+WHERE CAST(:character_id AS INTEGER) = 0 OR character_id = :character_id
+ORDER BY character_id`
+}
+
+// repairTotalsQuery sets the totals of every window of :character_id
+func repairTotalsQuery() string {
+	sets := []string{}
+	for _, column := range totalColumns() {
+		sets = append(sets, column+" = :"+column)
+	}
+	return "UPDATE characters SET\n    " + strings.Join(sets, ",\n    ") +
+		"\nWHERE character_id = :character_id"
+}
+
+// recalculateLargestQuery sets the largest counted donation and accepted
+// contract received by every character, or only :character_id if it is not
+// 0. Those who have none are set null
+func recalculateLargestQuery(counted string) string {
+	received := func(table string) string {
+		return `SELECT transaction_id, amount, donator, "timestamp"
+        FROM ` + table + `
+        WHERE ` + counted + `receiver = characters.character_id`
+	}
+	return `UPDATE characters SET (
+    largest_donation_id,
+    largest_donation,
+    largest_donation_donator,
+    largest_donation_at
+) = (
+    SELECT * FROM (
+        ` + received("donations") + `
+        UNION ALL
+        ` + received("donations_archive") + `
+    ) AS received
+    ORDER BY amount DESC, "timestamp", transaction_id
+    LIMIT 1
+), (
+    largest_contract_id,
+    largest_contract,
+    largest_contract_donator,
+    largest_contract_at
+) = (
+    SELECT contract_id, value, donator, issued FROM contracts
+    WHERE accepted AND receiver = characters.character_id
+    ORDER BY value DESC, issued, contract_id
+    LIMIT 1
+)
+WHERE CAST(:character_id AS INTEGER) = 0 OR character_id = :character_id`
+}
+
+// recalculateStreaksQuery sets the current and best streaks of days (EVE
+// time) with a counted donation received of every character, or only of
+// :character_id if it is not 0. Only a streak ending :today or the day before
+// is current
+func recalculateStreaksQuery(counted string) string {
+	received := func(table string) string {
+		return `SELECT receiver, "timestamp" FROM ` + table + `
+        WHERE ` + counted + `(
+            CAST(:character_id AS INTEGER) = 0 OR receiver = :character_id
+        )`
+	}
+	return `WITH days AS (
+    SELECT DISTINCT receiver, CAST("timestamp" AS DATE) AS day FROM (
+        ` + received("donations") + `
+        UNION ALL
+        ` + received("donations_archive") + `
+    ) AS received
+), streaks AS (
+    SELECT receiver, MAX(day) AS last_day, COUNT(*) AS days FROM (
+        SELECT receiver, day, day - CAST(ROW_NUMBER() OVER (
+            PARTITION BY receiver ORDER BY day
+        ) AS INTEGER) AS island
+        FROM days
+    ) AS islands
+    GROUP BY receiver, island
+)
+UPDATE characters SET (streak_current, streak_best, streak_day) = (
+    SELECT
+        COALESCE(MAX(days) FILTER (
+            WHERE last_day >= CAST(:today AS DATE) - 1
+        ), 0),
+        COALESCE(MAX(days), 0),
+        MAX(last_day)
+    FROM streaks WHERE receiver = characters.character_id
+)
+WHERE CAST(:character_id AS INTEGER) = 0 OR character_id = :character_id`
+}
+
+// updateDonorStatsQuery sets the donor stats of every character as of :now,
+// from the counted donations received. Anonymized donators, all of ID 0,
+// are left out of the donor counts
+func updateDonorStatsQuery(counted string) string {
+	received := func(table string) string {
+		return `SELECT receiver, donator, amount, aged_days FROM ` + table + `
+        WHERE ` + counted + `TRUE`
+	}
+	return `WITH donors AS (
+    SELECT
+        receiver,
+        donator,
+        COUNT(*) AS donations,
+        SUM(amount) AS amount,
+        COUNT(*) FILTER (WHERE aged_days < 30) AS donations_30,
+        SUM(amount) FILTER (WHERE aged_days < 30) AS amount_30
+    FROM (
+        ` + received("donations") + `
+        UNION ALL
+        ` + received("donations_archive") + `
+    ) AS received
+    GROUP BY receiver, donator
+), stats AS (
+    SELECT
+        receiver,
+        SUM(amount) / SUM(donations) AS average,
+        SUM(amount_30) / NULLIF(SUM(donations_30), 0) AS average_30,
+        COUNT(*) FILTER (WHERE donator <> 0) AS unique_donors,
+        COUNT(*) FILTER (WHERE donator <> 0 AND donations > 1) AS repeat_donors
+    FROM donors
+    GROUP BY receiver
+)
+UPDATE characters SET
+    average_donation = COALESCE(stats.average, 0),
+    average_donation_30 = COALESCE(stats.average_30, 0),
+    unique_donors = COALESCE(stats.unique_donors, 0),
+    repeat_donors = COALESCE(stats.repeat_donors, 0),
+    stats_computed_at = :now
+FROM characters AS c
+LEFT JOIN stats ON stats.receiver = c.character_id
+WHERE c.character_id = characters.character_id`
+}
+
+// donorCorporationsQuery sums the counted donations to :character_id by the
+// corporation of their donator, most ISK first. Donations of anonymous
+// donators, or of an unknown corporation, are summed as corporation 0
+func donorCorporationsQuery(counted string) string {
+	received := func(table string) string {
+		return `SELECT donator, donor_corporation_id, amount FROM ` + table + `
+        WHERE ` + counted + `receiver = :character_id`
+	}
+	return `SELECT
+    corporation_id,
+    COUNT(*) AS donations,
+    CAST(SUM(amount) AS BIGINT) AS isk
+FROM (
+    SELECT
+        CASE WHEN donator IN (
+            SELECT character_id FROM preferences WHERE anonymous
+        ) THEN 0 ELSE COALESCE(donor_corporation_id, 0) END AS corporation_id,
+        amount
+    FROM (
+        ` + received("donations") + `
+        UNION ALL
+        ` + received("donations_archive") + `
+    ) AS received
+) AS attributed
+GROUP BY corporation_id
+ORDER BY isk DESC, corporation_id`
+}
+
+// characterColumns are the columns saved of a CharacterRow
+func characterColumns() []string {
+	columns := []string{"character_id", "corporation_id", "alliance_id"}
+	columns = append(columns, totalColumns()...)
+	return append(
+		columns,
+		"last_donated",
+		"last_received",
+		"good_standing",
+		"streak_current",
+		"streak_best",
+		"streak_day",
+	)
+}
+
+// createCharacterQuery inserts a character of characterValues
+func createCharacterQuery() string {
+	columns := characterColumns()
+	return "INSERT INTO characters (\n    " +
+		strings.Join(columns, ",\n    ") +
+		"\n) VALUES (\n    :" +
+		strings.Join(columns, ",\n    :") +
+		"\n)"
+}
+
+// updateCharacterQuery updates a character of characterValues
+func updateCharacterQuery() string {
+	sets := []string{}
+	for _, column := range characterColumns()[1:] {
+		sets = append(sets, column+" = :"+column)
+	}
+	return "UPDATE characters SET\n    " +
+		strings.Join(sets, ",\n    ") +
+		"\nWHERE character_id = :character_id"
+}
+
+// windowOrder orders characters by their totals of the column over the
+// :window, in days or 0 for the lifetime totals
+func windowOrder(column string) string {
+	return "ORDER BY " + windowColumn(column) + " DESC"
+}
+
+// windowColumn selects the totals of the column over the :window
+func windowColumn(column string) string {
+	cases := ""
+	for _, w := range allWindows() {
+		cases += fmt.Sprintf("\n    WHEN %d THEN %s%s", int(w), column, w.suffix())
+	}
+	return "CASE CAST(:window AS INTEGER)" + cases + "\nEND"
+}
+
+// generousQuery pages the corporations or alliances by the ISK their members
+// donated over the :window, with how many of them donated and the one who
+// donated the most. Members are left out as they are of the top donators,
+// anonymous ones are only left out of the top donor
+func generousQuery(opts *cx.Options, column string) string {
+	return `WITH members AS (
+    SELECT character_id, ` + column + ` AS group_id, ` +
+		windowColumn("donated_isk") + ` AS donated_isk
+    FROM characters
+    WHERE ` + column + ` <> 0
+    AND good_standing AND NOT hidden AND NOT banned` +
+		unflagged(opts, "character_id") + `
+), given AS (
+    SELECT
+        group_id,
+        CAST(SUM(donated_isk) AS BIGINT) AS donated_isk,
+        COUNT(*) AS donors
+    FROM members
+    WHERE donated_isk > 0
+    GROUP BY group_id
+), top_donors AS (
+    SELECT DISTINCT ON (group_id) group_id, character_id AS top_donor
+    FROM members
+    WHERE donated_isk > 0 AND character_id NOT IN (
+        SELECT character_id FROM preferences WHERE anonymous
+    )
+    ORDER BY group_id, donated_isk DESC, character_id
+)
+SELECT given.group_id, given.donated_isk, given.donors, top_donors.top_donor
+FROM given
+LEFT JOIN top_donors ON top_donors.group_id = given.group_id
+ORDER BY given.donated_isk DESC, given.group_id
+LIMIT :limit OFFSET :offset`
+}
+
+// trackedCharacters pages the characters with a stored token by their last
+// activity, in the direction, matching any filter given. Characters which
+// never sent or received ISK are the least active
+func trackedCharacters(direction, compare string) string {
+	return fmt.Sprintf(`SELECT
+    users.character_id,
+    COALESCE(names.name, '') AS name,
+    users.revoked,
+    COALESCE(characters.hidden, false) AS hidden,
+    COALESCE(characters.deleted, false) AS deleted,
+    COALESCE(characters.banned, false) AS banned,
+    GREATEST(characters.last_received, characters.last_donated)
+        AS last_activity,
+    users.last_success,
+    users.last_error,
+    users.next_poll_at
+FROM users
+LEFT JOIN characters ON characters.character_id = users.character_id
+LEFT JOIN names ON names.id = users.character_id
+WHERE (:all
+    OR (:revoked AND users.revoked)
+    OR (:hidden AND characters.hidden)
+    OR (:deleted AND characters.deleted)
+    OR (:banned AND characters.banned)
+    OR (:stale AND NOT users.revoked
+        AND NOT COALESCE(characters.deleted, false)
+        AND (users.last_success IS NULL OR users.last_success < :stale_before)))
+AND (:first OR (%[3]s, users.character_id) %[2]s (:after_time, :after_id))
+ORDER BY %[3]s %[1]s, users.character_id %[1]s
+LIMIT :limit`, direction, compare, lastActivity)
+}
+
+// lastActivity is the last time users.character_id sent or received ISK, or
+// the epoch if it never has
+const lastActivity = `COALESCE(
+    GREATEST(characters.last_received, characters.last_donated),
+    TIMESTAMP 'epoch'
+)`
+
+// closestContacts is the closest contact of characters.character_id in the
+// contacts of each standings source: the character, else its corporation,
+// else its alliance
+func closestContacts(sources cx.CharacterIDs) string {
+	return fmt.Sprintf(`(
+    SELECT DISTINCT ON (source_id)
+        source_id,
+        contact_id,
+        contact_type,
+        standing
+    FROM standings_contacts
+    WHERE source_id IN (%s) AND ((
+        contact_type = 'character' AND
+        contact_id = characters.character_id
+    ) OR (
+        contact_type = 'corporation' AND
+        contact_id = characters.corporation_id
+    ) OR (
+        contact_type = 'alliance' AND
+        contact_id = characters.alliance_id
+    ))
+    ORDER BY source_id, CASE contact_type
+        WHEN 'character' THEN 0
+        WHEN 'corporation' THEN 1
+        ELSE 2
+    END
+)`, sources.String())
+}
+
+// receivedSeries sums the donations and accepted contracts the character
+// received since :since per :bucket, and the archived donations if archived
+func receivedSeries(counted string, archived bool) string {
+	archive := ""
+	if archived {
+		archive = fmt.Sprintf(`
+    UNION ALL
+    SELECT "timestamp" AS at, amount AS isk FROM donations_archive
+    WHERE %sreceiver = :character_id AND "timestamp" >= :since`, counted)
+	}
+	return fmt.Sprintf(`SELECT
+    date_trunc(CAST(:bucket AS TEXT), received.at) AS bucket,
+    COUNT(*) AS count,
+    COALESCE(SUM(received.isk), 0) AS isk
+FROM (
+    SELECT "timestamp" AS at, amount AS isk FROM donations
+    WHERE %[1]sreceiver = :character_id AND "timestamp" >= :since%[2]s
+    UNION ALL
+    SELECT issued AS at, value AS isk FROM contracts
+    WHERE accepted AND receiver = :character_id AND issued >= :since
+) AS received
+GROUP BY 1 ORDER BY 1`, counted, archive)
+}
+
+// goodStanding is true for characters who have donated 1+% of their 30 day
+// received ISK to the owner over those 30 days, or whose closest contact of
+// any standings source is at or above the threshold
+func goodStanding(opts *cx.Options) string {
+	return fmt.Sprintf(`(
+    COALESCE((
+        SELECT SUM(amount) FROM donations
+        WHERE receiver = %[1]d AND donator = characters.character_id
+        AND voided_at IS NULL AND aged_days < %[4]d
+    ), 0) * 100 > characters.received_isk_30
+) OR EXISTS (
+    SELECT 1 FROM %[2]s AS closest
+    WHERE standing >= %[3]v
+)`,
+		opts.CharacterID,
+		closestContacts(opts.CharacterIDs),
+		opts.StandingThreshold,
+		int(WindowMonth),
+	)
+}
+
+// prepareStatements prepares the queries in name order, returning an error
+// naming the first which fails
+func prepareStatements(
+	db *sqlx.DB,
+	queries map[cx.Key]string,
+) (map[cx.Key]*sqlx.NamedStmt, error) {
+	keys := []string{}
+	for key := range queries {
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+
 	statements := map[cx.Key]*sqlx.NamedStmt{}
+	for _, key := range keys {
+		s, err := db.PrepareNamed(queries[cx.Key(key)])
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare statement %s: %+v", key, err)
+		}
+		statements[cx.Key(key)] = s
+	}
+	return statements, nil
+}
+
+// queries returns the SQL of every statement
+func queries(opts *cx.Options) map[cx.Key]string {
+	// voided donations are never counted, donations between characters of
+	// one account normally aren't either
+	counted := "voided_at IS NULL AND NOT self_donation AND "
+	if opts.CountSelf {
+		counted = "voided_at IS NULL AND "
+	}
+	// contracts which ended unaccepted are no longer pending, or listed
+	listed := "(accepted OR terminal_at IS NULL)"
 
 	queries := map[cx.Key]string{
-		cx.StmtTopReceived: `SELECT * FROM characters WHERE good_standing
-ORDER BY received_isk_30 DESC LIMIT 6`,
+		cx.StmtTopReceived: `SELECT * FROM characters
+WHERE good_standing AND NOT hidden AND NOT banned` +
+			unflagged(opts, "character_id") + `
+` + windowOrder("received_isk") + ` LIMIT 6`,
 
-		cx.StmtTopDonated: `SELECT * FROM characters WHERE good_standing
-ORDER BY donated_isk_30 DESC LIMIT 6`,
+		cx.StmtTopDonated: `SELECT * FROM characters
+WHERE good_standing AND NOT hidden AND NOT banned` +
+			unflagged(opts, "character_id") + `
+` + windowOrder("donated_isk") + ` LIMIT 6`,
 
 		cx.StmtCharDetails: `SELECT * FROM characters
 WHERE character_id = :character_id LIMIT 1`,
 
 		// ISK IN
-		cx.StmtCharDonations: `SELECT * FROM donations
-WHERE receiver = :character_id`,
+		cx.StmtCharDonations: `SELECT * FROM ` + storedDonations + `
+WHERE receiver = :character_id AND voided_at IS NULL`,
 		cx.StmtCharContracts: `SELECT * FROM contracts
-WHERE receiver = :character_id`,
+WHERE receiver = :character_id AND ` + listed,
 
 		// ISK OUT
-		cx.StmtCharDonated: `SELECT * FROM donations
-WHERE donator = :character_id`,
+		cx.StmtCharDonated: `SELECT * FROM ` + storedDonations + `
+WHERE donator = :character_id AND voided_at IS NULL`,
 		cx.StmtCharContracted: `SELECT * FROM contracts
-WHERE donator = :character_id`,
+WHERE donator = :character_id AND ` + listed,
+
+		// pages are ordered by (timestamp, id), newest first, so rows added
+		// between pages don't shift the rows of the next one
+		cx.StmtDonationsPage: `SELECT * FROM ` + storedDonations + `
+WHERE voided_at IS NULL
+AND ((:received AND receiver = :character_id)
+    OR (:sent AND donator = :character_id))
+AND (:first OR ("timestamp", transaction_id) < (:after_time, :after_id))
+ORDER BY "timestamp" DESC, transaction_id DESC
+LIMIT :limit`,
+		cx.StmtContractsPage: `SELECT * FROM contracts
+WHERE ` + listed + ` AND ((:received AND receiver = :character_id)
+    OR (:sent AND donator = :character_id))
+AND (:first OR (issued, contract_id) < (:after_time, :after_id))
+ORDER BY issued DESC, contract_id DESC
+LIMIT :limit`,
+
+		cx.StmtDonationsSince: `SELECT * FROM donations
+WHERE receiver = :character_id AND voided_at IS NULL
+AND transaction_id > :since_id
+ORDER BY transaction_id
+LIMIT :limit`,
+
+		cx.StmtDonation: `SELECT * FROM ` + storedDonations + `
+WHERE transaction_id = :id AND voided_at IS NULL`,
+
+		cx.StmtContract: `SELECT * FROM contracts WHERE contract_id = :id`,
+
+		// donations of anonymous, hidden or banned characters are only
+		// proven to the characters themselves
+		cx.StmtProveDonated: `SELECT
+    COALESCE(array_agg(transaction_id ORDER BY transaction_id), '{}') AS ids,
+    COALESCE(SUM(amount), 0)::bigint AS total
+FROM ` + storedDonations + `
+WHERE donator = :donor AND receiver = :recipient AND voided_at IS NULL
+AND "timestamp" > :after
+AND (:party OR NOT EXISTS (
+    SELECT 1 FROM preferences WHERE character_id = :donor AND anonymous
+))
+AND (:party OR NOT EXISTS (
+    SELECT 1 FROM characters
+    WHERE character_id IN (:donor, :recipient) AND (hidden OR banned)
+))`,
+
+		cx.StmtAddViews: `INSERT INTO character_views (character_id, views)
+SELECT character_id, :views FROM characters
+WHERE character_id = :character_id
+ON CONFLICT (character_id) DO UPDATE
+SET views = character_views.views + EXCLUDED.views`,
+
+		cx.StmtMostViewed: `SELECT views.character_id FROM character_views AS views
+JOIN characters ON characters.character_id = views.character_id
+WHERE NOT characters.hidden AND NOT characters.banned
+ORDER BY views.views DESC, views.character_id
+LIMIT :limit`,
+
+		cx.StmtAddHourViews: `INSERT INTO character_views_hourly (
+    character_id,
+    hour,
+    views
+)
+SELECT character_id, :hour, :views FROM characters
+WHERE character_id = :character_id
+ON CONFLICT (character_id, hour) DO UPDATE
+SET views = character_views_hourly.views + EXCLUDED.views`,
+
+		cx.StmtPruneHourViews: `DELETE FROM character_views_hourly
+WHERE hour < :before`,
+
+		cx.StmtTrending: `SELECT
+    hourly.character_id,
+    SUM(hourly.views)::bigint AS views
+FROM character_views_hourly AS hourly
+JOIN characters ON characters.character_id = hourly.character_id
+WHERE NOT characters.hidden AND NOT characters.banned
+AND hourly.hour >= :since` + inStanding(opts, "hourly.character_id") + `
+GROUP BY hourly.character_id
+ORDER BY views DESC, hourly.character_id
+LIMIT :limit OFFSET :offset`,
+
+		cx.StmtSitemap: `SELECT
+    characters.character_id,
+    slugs.slug,
+    GREATEST(characters.last_received, characters.last_donated) AS last_modified
+FROM characters
+LEFT JOIN slugs ON slugs.character_id = characters.character_id
+    AND slugs.replaced_at IS NULL
+WHERE NOT characters.hidden AND NOT characters.banned
+ORDER BY characters.character_id
+LIMIT :limit OFFSET :offset`,
+
+		cx.StmtCountSitemap: `SELECT COUNT(*) FROM characters
+WHERE NOT hidden AND NOT banned`,
 
 		cx.StmtContractItems: `SELECT * FROM contractItems
 WHERE contract_id = :contract_id LIMIT 1`,
@@ -47,23 +796,64 @@ WHERE contract_id = :contract_id LIMIT 1`,
     refresh_token,
     access_token,
     access_expires,
-    owner_hash
+    owner_hash,
+    scopes
 ) VALUES (
     :character_id,
     :refresh_token,
     :access_token,
     :access_expires,
-    :owner_hash
+    :owner_hash,
+    COALESCE(CAST(:scopes AS TEXT[]), '{}')
 )`,
 
 		cx.StmtGetUser: `SELECT * FROM users
 WHERE character_id = :character_id LIMIT 1`,
 
-		cx.StmtGetUsers: `SELECT * FROM users
-WHERE last_processed < NOW() - INTERVAL '1 hour' LIMIT 100`,
+		// SKIP LOCKED lets replicas claim at once, each getting other users
+		cx.StmtClaimUsers: `UPDATE users
+SET claimed_until = NOW() + CAST(:claim_seconds AS INTEGER) * INTERVAL '1 second'
+WHERE character_id IN (
+    SELECT character_id FROM users
+    WHERE COALESCE(next_poll_at, last_processed + INTERVAL '1 hour') <= NOW()
+    AND NOT revoked
+    AND character_id NOT IN (SELECT character_id FROM characters WHERE deleted)
+    AND (claimed_until IS NULL OR claimed_until < NOW())
+    ORDER BY COALESCE(next_poll_at, last_processed + INTERVAL '1 hour')
+    LIMIT 100
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *`,
+
+		cx.StmtClaimNullUsers: `UPDATE users
+SET claimed_until = NOW() + CAST(:claim_seconds AS INTEGER) * INTERVAL '1 second'
+WHERE character_id IN (
+    SELECT character_id FROM users
+    WHERE last_processed IS NULL
+    AND NOT revoked
+    AND character_id NOT IN (SELECT character_id FROM characters WHERE deleted)
+    AND (claimed_until IS NULL OR claimed_until < NOW())
+    LIMIT 100
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *`,
+
+		cx.StmtClaimUser: `UPDATE users
+SET claimed_until = NOW() + CAST(:claim_seconds AS INTEGER) * INTERVAL '1 second'
+WHERE character_id = :character_id
+AND (claimed_until IS NULL OR claimed_until < NOW())
+RETURNING *`,
+
+		cx.StmtReleaseUser: `UPDATE users SET claimed_until = NULL
+WHERE character_id = :character_id`,
+
+		cx.StmtGetAllUsers: `SELECT * FROM users`,
+
+		cx.StmtTrackedCharacters:    trackedCharacters("DESC", "<"),
+		cx.StmtTrackedCharactersAsc: trackedCharacters("ASC", ">"),
 
-		cx.StmtGetNullUsers: `SELECT * FROM users
-WHERE last_processed IS NULL LIMIT 100`,
+		cx.StmtSetRefreshToken: `UPDATE users SET refresh_token = :new_token
+WHERE character_id = :character_id AND refresh_token = :refresh_token`,
 
 		cx.StmtUpdateUser: `UPDATE users SET
     refresh_token = :refresh_token,
@@ -73,7 +863,38 @@ WHERE last_processed IS NULL LIMIT 100`,
     owner_hash = :owner_hash,
     last_journal_id = :last_journal_id,
     last_contract_id = :last_contract_id,
-    last_processed = NOW()
+    scopes = COALESCE(CAST(:scopes AS TEXT[]), '{}'),
+    last_processed = NOW(),
+    revoked = false
+WHERE character_id = :character_id`,
+
+		cx.StmtRevokeUser: `UPDATE users SET revoked = true
+WHERE character_id = :character_id`,
+
+		cx.StmtMarkBackfilled: `UPDATE users
+SET backfilled_at = NOW() AT TIME ZONE 'UTC'
+WHERE character_id = :character_id`,
+
+		cx.StmtPollSucceeded: `UPDATE users
+SET last_success = NOW() AT TIME ZONE 'UTC', claimed_until = NULL
+WHERE character_id = :character_id`,
+
+		cx.StmtPollFailed: `UPDATE users
+SET last_error = NOW() AT TIME ZONE 'UTC', claimed_until = NULL
+WHERE character_id = :character_id`,
+
+		// next_poll_at is compared with NOW(), as last_processed is set
+		cx.StmtSchedulePoll: `UPDATE users SET
+    poll_interval = :poll_interval,
+    next_poll_at = NOW() + CAST(:poll_interval AS INTEGER) * INTERVAL '1 second'
+WHERE character_id = :character_id`,
+
+		cx.StmtBumpPoll: `UPDATE users SET
+    poll_interval = 0,
+    next_poll_at = LEAST(
+        next_poll_at,
+        last_processed + CAST(:base_interval AS INTEGER) * INTERVAL '1 second'
+    )
 WHERE character_id = :character_id`,
 
 		cx.StmtDeleteUser: `DELETE FROM users WHERE character_id = :character_id`,
@@ -84,70 +905,103 @@ WHERE character_id = :character_id`,
     receiver,
     "timestamp",
     note,
-    amount
+    amount,
+    self_donation
 ) VALUES (
     :transaction_id,
     :donator,
     :receiver,
     :timestamp,
     :note,
-    :amount
+    :amount,
+    :self_donation
 )`,
 
+		cx.StmtAddRefund: `INSERT INTO donation_refunds (
+    refund_id,
+    donator,
+    receiver,
+    "timestamp",
+    amount
+) VALUES (
+    :refund_id,
+    :donator,
+    :receiver,
+    :timestamp,
+    :amount
+) ON CONFLICT (refund_id) DO NOTHING`,
+
+		// the donation of the refund's ref ID, or else the latest of its
+		// amount before it
+		cx.StmtRefundDonation: `UPDATE donations SET
+    voided_at = :timestamp,
+    refund_id = :refund_id
+WHERE transaction_id = (
+    SELECT transaction_id FROM donations
+    WHERE donator = :donator AND receiver = :receiver AND voided_at IS NULL
+    AND (
+        transaction_id = :refund_id
+        OR (amount = :amount AND "timestamp" <= :timestamp)
+    )
+    ORDER BY transaction_id = :refund_id DESC, "timestamp" DESC
+    LIMIT 1
+)
+RETURNING *`,
+
+		// the refund of the donation's ref ID, or else the first of its
+		// amount after it
+		cx.StmtPendingRefund: `SELECT * FROM donation_refunds
+WHERE transaction_id IS NULL AND donator = :donator AND receiver = :receiver
+AND (
+    refund_id = :transaction_id
+    OR (amount = :amount AND "timestamp" >= :timestamp)
+)
+ORDER BY refund_id = :transaction_id DESC, "timestamp"
+LIMIT 1
+FOR UPDATE`,
+
+		cx.StmtMarkRefunded: `UPDATE donations SET
+    voided_at = :timestamp,
+    refund_id = :refund_id
+WHERE transaction_id = :transaction_id`,
+
+		cx.StmtLinkRefund: `UPDATE donation_refunds
+SET transaction_id = :transaction_id
+WHERE refund_id = :refund_id`,
+
 		cx.StmtNewName: `INSERT INTO names (id, name) VALUES (:id, :name)`,
 
 		cx.StmtUpdateName: `UPDATE names SET name = :name WHERE id = :id`,
 
 		cx.StmtGetName: `SELECT * FROM names WHERE id = :id LIMIT 1`,
 
-		cx.StmtCreateCharacter: `INSERT INTO characters (
-    character_id,
-    corporation_id,
-    alliance_id,
-    received,
-    received_isk,
-    received_30,
-    received_isk_30,
-    donated,
-    donated_isk,
-    donated_30,
-    donated_isk_30,
-    last_donated,
-    last_received,
-    good_standing
-) VALUES (
-    :character_id,
-    :corporation_id,
-    :alliance_id,
-    :received,
-    :received_isk,
-    :received_30,
-    :received_isk_30,
-    :donated,
-    :donated_isk,
-    :donated_30,
-    :donated_isk_30,
-    :last_donated,
-    :last_received,
-    :good_standing
-)`,
+		cx.StmtCreateCharacter: createCharacterQuery(),
 
-		cx.StmtUpdateCharacter: `UPDATE characters SET
-    corporation_id = :corporation_id,
-    alliance_id = :alliance_id,
-    received = :received,
-    received_isk = :received_isk,
-    received_30 = :received_30,
-    received_isk_30 = :received_isk_30,
-    donated = :donated,
-    donated_isk = :donated_isk,
-    donated_30 = :donated_30,
-    donated_isk_30 = :donated_isk_30,
-    last_donated = :last_donated,
-    last_received = :last_received,
-    good_standing = :good_standing
+		cx.StmtMarkDeleted: `UPDATE characters SET deleted = true
 WHERE character_id = :character_id`,
 
+		cx.StmtSetBanned: `UPDATE characters SET banned = :banned
+WHERE character_id = :character_id`,
+
+		cx.StmtDetectRings: detectRings(counted),
+
+		cx.StmtDonationRings: `SELECT
+    ` + ringColumns + `
+FROM donation_rings
+WHERE :all OR (reviewed_at IS NOT NULL) = :reviewed
+ORDER BY id DESC
+LIMIT :limit OFFSET :offset`,
+
+		cx.StmtCountPendingRings: `SELECT COUNT(*) FROM donation_rings
+WHERE reviewed_at IS NULL`,
+
+		cx.StmtReviewRing: `UPDATE donation_rings SET
+    reviewed_at = :reviewed_at,
+    reviewed_by = :reviewed_by
+WHERE id = :id`,
+
+		cx.StmtUpdateCharacter: updateCharacterQuery(),
+
 		cx.StmtAddContract: `INSERT INTO contracts (
     contract_id,
     donator,
@@ -156,8 +1010,11 @@ WHERE character_id = :character_id`,
     issued,
     expires,
     accepted,
+    status,
     value,
-    note
+    note,
+    terminal_at,
+    location_name
 ) VALUES (
     :contract_id,
     :donator,
@@ -166,8 +1023,14 @@ WHERE character_id = :character_id`,
     :issued,
     :expires,
     :accepted,
+    :status,
     :value,
-    :note
+    :note,
+    :terminal_at,
+    COALESCE(
+        (SELECT name FROM locations WHERE location_id = :location),
+        ''
+    )
 )`,
 
 		cx.StmtAddContractItems: `INSERT INTO contractItems (
@@ -184,16 +1047,11 @@ WHERE character_id = :character_id`,
     :quantity
 )`,
 
-		cx.StmtCharStandingISK: fmt.Sprintf(
-			"SELECT * FROM donations WHERE receiver = %d AND donator = :character_id",
-			opts.CharacterID,
-		),
-
 		cx.StmtCreatePreferences: `INSERT INTO preferences (
     character_id
 ) VALUES (
     :character_id
-)`,
+) ON CONFLICT (character_id) DO NOTHING`,
 
 		cx.StmtGetPreferences: `SELECT * FROM preferences
 WHERE character_id = :character_id LIMIT 1`,
@@ -218,11 +1076,55 @@ WHERE character_id = :character_id`,
     contract_passphrase = :passphrase
 WHERE character_id = :character_id`,
 
-		cx.StmtGetOutstandingContracts: `SELECT * FROM contracts
-WHERE accepted = false AND receiver = :character_id LIMIT 100`,
+		cx.StmtSetPrivacy: `UPDATE preferences SET
+    anonymous = :anonymous
+WHERE character_id = :character_id`,
+
+		cx.StmtSetHidden: `UPDATE characters SET
+    hidden = :hidden
+WHERE character_id = :character_id`,
+
+		cx.StmtRetireSlug: `UPDATE slugs SET
+    replaced_at = NOW() AT TIME ZONE 'UTC'
+WHERE character_id = :character_id AND replaced_at IS NULL
+AND slug <> :slug`,
+
+		// slugs owned by someone else are only free once their redirect
+		// period is over
+		cx.StmtClaimSlug: `INSERT INTO slugs (
+    slug,
+    character_id
+) VALUES (
+    :slug,
+    :character_id
+) ON CONFLICT (slug) DO UPDATE SET
+    character_id = EXCLUDED.character_id,
+    claimed_at = EXCLUDED.claimed_at,
+    replaced_at = NULL
+WHERE slugs.character_id = EXCLUDED.character_id
+OR slugs.replaced_at < NOW() AT TIME ZONE 'UTC' - INTERVAL '30 days'`,
+
+		cx.StmtResolveSlug: `SELECT * FROM slugs WHERE slug = :slug
+AND (replaced_at IS NULL
+    OR replaced_at >= NOW() AT TIME ZONE 'UTC' - INTERVAL '30 days')
+LIMIT 1`,
+
+		cx.StmtCurrentSlug: `SELECT * FROM slugs
+WHERE character_id = :character_id AND replaced_at IS NULL LIMIT 1`,
 
-		cx.StmtAcceptContract: `UPDATE contracts SET
-    accepted = true
+		// ESI lists contracts issued in the last 30 days, and those still
+		// outstanding. finished contracts are only tracked while listed
+		cx.StmtGetTrackedContracts: `SELECT * FROM contracts
+WHERE receiver = :character_id
+AND (status IN ('outstanding', 'in_progress')
+    OR (status = 'finished' AND issued > NOW() - INTERVAL '30 days'))
+ORDER BY issued DESC, contract_id DESC
+LIMIT 500`,
+
+		cx.StmtSetContractStatus: `UPDATE contracts SET
+    accepted = :accepted,
+    status = :status,
+    terminal_at = CASE WHEN :terminal THEN COALESCE(terminal_at, :now) END
 WHERE contract_id = :contract_id AND receiver = :character_id`,
 
 		cx.StmtSetCombinedPreferences: `UPDATE preferences SET
@@ -238,10 +1140,84 @@ WHERE contract_id = :contract_id AND receiver = :character_id`,
 WHERE character_id = :character_id`,
 
 		cx.StmtGetStaleContracts: `SELECT * FROM contracts
-WHERE issued < NOW() - INTERVAL '30 days' LIMIT 100`,
+WHERE accepted AND aged_days < :days
+AND issued < NOW() - make_interval(days => :days)
+LIMIT 100`,
+
+		cx.StmtAgeContract: `UPDATE contracts SET aged_days = :days
+WHERE contract_id = :contract_id AND aged_days < :days`,
+
+		cx.StmtExpireContracts: `UPDATE contracts SET
+    status = 'expired',
+    terminal_at = NOW() AT TIME ZONE 'UTC'
+WHERE status = 'outstanding' AND expires < NOW() AT TIME ZONE 'UTC'`,
+
+		cx.StmtPendingContracts: `SELECT * FROM contracts
+WHERE receiver = :character_id AND NOT accepted AND status = 'outstanding'
+AND expires > NOW() AT TIME ZONE 'UTC'
+ORDER BY issued DESC LIMIT 100`,
+
+		cx.StmtClassifyContract: `UPDATE contracts SET
+    contains_plex = COALESCE(notable.plex, false),
+    notable_items = COALESCE(notable.quantity, 0)
+FROM (
+    SELECT bool_or(plex) AS plex, SUM(quantity) AS quantity
+    FROM contractItems JOIN notable_types USING (type_id)
+    WHERE contract_id = :contract_id
+) AS notable
+WHERE contract_id = :contract_id`,
+
+		// of the 30 day window, which accepted contracts age out of
+		cx.StmtNotableContracts: `SELECT * FROM contracts
+WHERE accepted AND aged_days < 30 AND notable_items > 0
+AND donator NOT IN (SELECT character_id FROM characters WHERE hidden OR banned)
+AND receiver NOT IN (SELECT character_id FROM characters WHERE hidden OR banned)` +
+			inStanding(opts, "donator") + inStanding(opts, "receiver") + `
+ORDER BY value DESC, contract_id DESC
+LIMIT :limit OFFSET :offset`,
+
+		cx.StmtSaveLocation: `INSERT INTO locations (
+    location_id,
+    name,
+    denied,
+    resolved_at
+) VALUES (
+    :location_id,
+    :name,
+    :denied,
+    :resolved_at
+) ON CONFLICT (location_id) DO UPDATE SET
+    name = EXCLUDED.name,
+    denied = EXCLUDED.denied,
+    resolved_at = EXCLUDED.resolved_at`,
+
+		cx.StmtNameContracts: `UPDATE contracts SET location_name = :name
+WHERE location = :location_id AND location_name <> :name`,
 
-		cx.StmtGetStaleDonations: `SELECT * FROM donations
-WHERE "timestamp" < NOW() - INTERVAL '30 days' LIMIT 100`,
+		cx.StmtGetTerminalContracts: `SELECT * FROM contracts
+WHERE NOT accepted AND terminal_at IS NOT NULL
+AND issued < NOW() - INTERVAL '90 days' LIMIT 100`,
+
+		// the archive has the columns of donations, in the same order
+		cx.StmtGetStaleDonations: `SELECT * FROM (
+    SELECT * FROM donations
+    UNION ALL
+    SELECT * FROM donations_archive
+) AS stored
+WHERE voided_at IS NULL AND aged_days < :days
+AND "timestamp" < NOW() - make_interval(days => :days)
+LIMIT 100`,
+
+		cx.StmtAgeDonation: `WITH stored AS (
+    UPDATE donations SET aged_days = :days
+    WHERE transaction_id = :transaction_id AND aged_days < :days
+    RETURNING transaction_id
+), archived AS (
+    UPDATE donations_archive SET aged_days = :days
+    WHERE transaction_id = :transaction_id AND aged_days < :days
+    RETURNING transaction_id
+)
+SELECT (SELECT COUNT(*) FROM stored) + (SELECT COUNT(*) FROM archived)`,
 
 		cx.StmtRemoveContract: `DELETE FROM contracts
 WHERE contract_id = :contract_id`,
@@ -249,17 +1225,527 @@ WHERE contract_id = :contract_id`,
 		cx.StmtRemoveContractItems: `DELETE FROM contractItems
 WHERE contract_id = :contract_id`,
 
-		cx.StmtRemoveDonation: `DELETE FROM donations
+		// the archive has the columns of donations, in the same order. only
+		// donations out of the 30 day totals are archived, those are the
+		// totals read from the donations table alone. donations already in
+		// the archive are left in the donations table, only those inserted
+		// are deleted
+		cx.StmtArchiveDonations: fmt.Sprintf(`WITH archived AS (
+    INSERT INTO donations_archive
+    SELECT * FROM donations
+    WHERE aged_days >= %d
+    AND "timestamp" < NOW() - make_interval(days => :days)
+    AND NOT EXISTS (
+        SELECT 1 FROM donations_archive
+        WHERE donations_archive.transaction_id = donations.transaction_id
+    )
+    LIMIT 1000
+    ON CONFLICT (transaction_id) DO NOTHING
+    RETURNING transaction_id
+), deleted AS (
+    DELETE FROM donations
+    WHERE transaction_id IN (SELECT transaction_id FROM archived)
+    RETURNING transaction_id
+)
+SELECT COUNT(*) FROM deleted`, int(WindowMonth)),
+
+		// archived donations are voided in the archive
+		cx.StmtVoidDonation: `WITH stored AS (
+    UPDATE donations SET voided_at = NOW() AT TIME ZONE 'UTC'
+    WHERE transaction_id = :transaction_id AND voided_at IS NULL
+    RETURNING *
+), archived AS (
+    UPDATE donations_archive SET voided_at = NOW() AT TIME ZONE 'UTC'
+    WHERE transaction_id = :transaction_id AND voided_at IS NULL
+    RETURNING *
+)
+SELECT * FROM stored
+UNION ALL
+SELECT * FROM archived`,
+
+		cx.StmtAddAdjustment: `INSERT INTO donations (
+    transaction_id,
+    donator,
+    receiver,
+    "timestamp",
+    note,
+    amount,
+    adjustment
+) VALUES (
+    -nextval('donation_adjustments'),
+    :donator,
+    :receiver,
+    NOW() AT TIME ZONE 'UTC',
+    :note,
+    :amount,
+    true
+) RETURNING *`,
+
+		// stored donations and contracts are counted in each window until
+		// they are aged out of it. the hourly aging is batched, so the
+		// recalculation ages every stale row first with StmtAgeStaleRows.
+		// voided donations were removed from the totals when voided
+		cx.StmtRecalculateTotals: recalculateTotals(counted),
+
+		// the rows the stale queries return, in one pass
+		cx.StmtAgeStaleRows: `WITH stored AS (
+    UPDATE donations SET aged_days = :days
+    WHERE voided_at IS NULL AND aged_days < :days
+    AND "timestamp" < NOW() - make_interval(days => :days)
+), archived AS (
+    UPDATE donations_archive SET aged_days = :days
+    WHERE voided_at IS NULL AND aged_days < :days
+    AND "timestamp" < NOW() - make_interval(days => :days)
+), accepted AS (
+    UPDATE contracts SET aged_days = :days
+    WHERE accepted AND aged_days < :days
+    AND issued < NOW() - make_interval(days => :days)
+)
+SELECT 1`,
+
+		cx.StmtRecalculateLargest: recalculateLargestQuery(counted),
+
+		cx.StmtRecalculateStreaks: recalculateStreaksQuery(counted),
+
+		cx.StmtUpdateDonorStats: updateDonorStatsQuery(counted),
+
+		cx.StmtGenerousCorporations: generousQuery(opts, "corporation_id"),
+		cx.StmtGenerousAlliances:    generousQuery(opts, "alliance_id"),
+
+		cx.StmtSetDonationAffiliations: `UPDATE donations SET
+    donor_corporation_id = COALESCE(
+        donor_corporation_id,
+        CAST(:donor_corporation_id AS INTEGER)
+    ),
+    donor_alliance_id = COALESCE(
+        donor_alliance_id,
+        CAST(:donor_alliance_id AS INTEGER)
+    ),
+    recipient_corporation_id = COALESCE(
+        recipient_corporation_id,
+        CAST(:recipient_corporation_id AS INTEGER)
+    ),
+    recipient_alliance_id = COALESCE(
+        recipient_alliance_id,
+        CAST(:recipient_alliance_id AS INTEGER)
+    )
 WHERE transaction_id = :transaction_id`,
-	}
 
-	for key, query := range queries {
-		s, err := db.PrepareNamed(query)
-		if err != nil {
-			log.Fatalf("failed to prepare statement: %+v", err)
-		}
-		statements[key] = s
+		cx.StmtDonorCorporations: donorCorporationsQuery(counted),
+
+		// a streak continues until a day passes without a donation
+		cx.StmtExpireStreaks: `UPDATE characters SET streak_current = 0
+WHERE streak_current > 0 AND streak_day < CAST(:today AS DATE) - 1`,
+
+		// only replaces a smaller largest donation, once the donation is
+		// stored and counted
+		cx.StmtRecordLargestDonation: `UPDATE characters SET
+    largest_donation_id = :transaction_id,
+    largest_donation = :amount,
+    largest_donation_donator = :donator,
+    largest_donation_at = :timestamp
+WHERE character_id = :receiver
+AND (largest_donation IS NULL OR largest_donation < :amount)
+AND EXISTS (
+    SELECT 1 FROM donations
+    WHERE ` + counted + `transaction_id = :transaction_id
+)`,
+
+		// contracts are recorded once accepted, which is before their status
+		// is stored
+		cx.StmtRecordLargestContract: `UPDATE characters SET
+    largest_contract_id = :contract_id,
+    largest_contract = :value,
+    largest_contract_donator = :donator,
+    largest_contract_at = :issued
+WHERE character_id = :receiver
+AND (largest_contract IS NULL OR largest_contract < :value)`,
+
+		cx.StmtCheckTotals: checkTotalsQuery(counted),
+
+		cx.StmtRepairTotals: repairTotalsQuery(),
+
+		cx.StmtSaveOwner: `INSERT INTO owners (
+    character_id,
+    owner_hash
+) VALUES (
+    :character_id,
+    :owner_hash
+) ON CONFLICT (character_id) DO UPDATE SET owner_hash = EXCLUDED.owner_hash`,
+
+		cx.StmtDeleteOwner: `DELETE FROM owners WHERE character_id = :character_id`,
+
+		// passphrases protect the owner's history, they don't transfer with
+		// the character to a new account
+		cx.StmtClearOwnerPreferences: `UPDATE preferences SET
+    donation_passphrase = NULL,
+    contract_passphrase = NULL,
+    combined_passphrase = NULL
+WHERE character_id = :character_id`,
+
+		cx.StmtDeletePreferences: `DELETE FROM preferences
+WHERE character_id = :character_id`,
+
+		cx.StmtDeleteSlugs: `DELETE FROM slugs
+WHERE character_id = :character_id`,
+
+		cx.StmtRequestRefresh: `INSERT INTO refresh_jobs (
+    character_id,
+    requested_at
+) VALUES (
+    :character_id,
+    NOW() AT TIME ZONE 'UTC'
+) ON CONFLICT (character_id) WHERE status IN ('pending', 'running')
+DO UPDATE SET requested_at = refresh_jobs.requested_at
+RETURNING *`,
+
+		cx.StmtLatestRefresh: `SELECT * FROM refresh_jobs
+WHERE character_id = :character_id
+ORDER BY requested_at DESC LIMIT 1`,
+
+		cx.StmtGetRefresh: `SELECT * FROM refresh_jobs
+WHERE job_id = :job_id AND character_id = :character_id`,
+
+		cx.StmtClaimRefreshes: `UPDATE refresh_jobs SET
+    status = 'running',
+    started_at = NOW() AT TIME ZONE 'UTC'
+WHERE job_id IN (
+    SELECT job_id FROM refresh_jobs WHERE status = 'pending'
+    ORDER BY requested_at LIMIT 10
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *`,
+
+		cx.StmtRequeueRefresh: `UPDATE refresh_jobs
+SET status = 'pending', started_at = NULL
+WHERE job_id = :job_id`,
+
+		cx.StmtFinishRefresh: `UPDATE refresh_jobs SET
+    status = :status,
+    finished_at = NOW() AT TIME ZONE 'UTC'
+WHERE job_id = :job_id`,
+
+		// jobs still running after an hour were lost with their worker
+		cx.StmtPruneRefreshes: `WITH abandoned AS (
+    UPDATE refresh_jobs SET
+        status = 'failed',
+        finished_at = NOW() AT TIME ZONE 'UTC'
+    WHERE status = 'running'
+    AND started_at < NOW() AT TIME ZONE 'UTC' - INTERVAL '1 hour'
+)
+DELETE FROM refresh_jobs
+WHERE finished_at < NOW() AT TIME ZONE 'UTC' - INTERVAL '1 day'`,
+
+		cx.StmtDeleteRefreshes: `DELETE FROM refresh_jobs
+WHERE character_id = :character_id`,
+
+		// there is no character 0, it stands in for an anonymous donator
+		cx.StmtAnonymizeDonations: `UPDATE donations SET
+    donator = 0,
+    donor_corporation_id = NULL,
+    donor_alliance_id = NULL
+WHERE donator = :character_id`,
+
+		cx.StmtAnonymizeArchive: `UPDATE donations_archive SET
+    donator = 0,
+    donor_corporation_id = NULL,
+    donor_alliance_id = NULL
+WHERE donator = :character_id`,
+
+		cx.StmtAnonymizeContracts: `UPDATE contracts SET donator = 0
+WHERE donator = :character_id`,
+
+		cx.StmtAnonymizeLargest: `UPDATE characters SET
+    largest_donation_donator = CASE
+        WHEN largest_donation_donator = :character_id THEN 0
+        ELSE largest_donation_donator
+    END,
+    largest_contract_donator = CASE
+        WHEN largest_contract_donator = :character_id THEN 0
+        ELSE largest_contract_donator
+    END
+WHERE largest_donation_donator = :character_id
+OR largest_contract_donator = :character_id`,
+
+		cx.StmtDeleteName: `DELETE FROM names WHERE id = :character_id`,
+
+		cx.StmtHideRemoved: `UPDATE characters SET hidden = true
+WHERE character_id = :character_id`,
+
+		cx.StmtNameRemoved: `INSERT INTO names (id, name)
+VALUES (:character_id, '` + RemovedName + `')`,
+
+		cx.StmtAddAffiliation: `INSERT INTO character_affiliation_history (
+    character_id,
+    corporation_id,
+    alliance_id
+) VALUES (
+    :character_id,
+    :corporation_id,
+    :alliance_id
+)`,
+
+		cx.StmtAffiliations: `SELECT
+    history.corporation_id,
+    COALESCE(corporation.name, '') AS corporation_name,
+    history.alliance_id,
+    COALESCE(alliance.name, '') AS alliance_name,
+    history.observed_at
+FROM character_affiliation_history AS history
+LEFT JOIN names AS corporation ON corporation.id = history.corporation_id
+LEFT JOIN names AS alliance ON alliance.id = history.alliance_id
+WHERE history.character_id = :character_id
+ORDER BY history.observed_at DESC`,
+
+		cx.StmtDeleteAffiliations: `DELETE FROM character_affiliation_history
+WHERE character_id = :character_id`,
+
+		cx.StmtDeleteViews: `DELETE FROM character_views
+WHERE character_id = :character_id`,
+
+		cx.StmtDeleteHourViews: `DELETE FROM character_views_hourly
+WHERE character_id = :character_id`,
+
+		cx.StmtPurgeDonations: `DELETE FROM donations
+WHERE receiver = :character_id OR donator = :character_id`,
+
+		cx.StmtPurgeArchive: `DELETE FROM donations_archive
+WHERE receiver = :character_id OR donator = :character_id`,
+
+		cx.StmtPurgeContractItems: `DELETE FROM contractItems
+WHERE contract_id IN (
+    SELECT contract_id FROM contracts
+    WHERE receiver = :character_id OR donator = :character_id
+)`,
+
+		cx.StmtPurgeContracts: `DELETE FROM contracts
+WHERE receiver = :character_id OR donator = :character_id`,
+
+		cx.StmtDeleteCharacter: `DELETE FROM characters
+WHERE character_id = :character_id`,
+
+		cx.StmtWriteAudit: `INSERT INTO audit_log (
+    actor,
+    action,
+    target,
+    request_id,
+    detail
+) VALUES (
+    :actor,
+    :action,
+    :target,
+    :request_id,
+    CAST(:detail AS JSONB)
+)`,
+
+		cx.StmtAuditLog: `SELECT
+    id,
+    actor,
+    action,
+    target,
+    request_id,
+    detail::text AS detail,
+    "timestamp"
+FROM audit_log
+WHERE (CAST(:action AS TEXT) = '' OR action = :action)
+AND (CAST(:target AS TEXT) = '' OR target = :target)
+ORDER BY id DESC
+LIMIT :limit OFFSET :offset`,
+
+		cx.StmtNotifyCharacters: `SELECT pg_notify('` + updateChannel + `', :payload)`,
+
+		cx.StmtNotifyNewCharacters: `SELECT pg_notify('` + createChannel + `', :payload)`,
+
+		cx.StmtNotifyTestDonation: `SELECT pg_notify('` + testChannel + `', :payload)`,
+
+		cx.StmtReceivedSeries: receivedSeries(counted, false),
+		cx.StmtArchivedSeries: receivedSeries(counted, true),
+
+		// buckets are powers of ten ISK, as cents, see histogramBuckets
+		cx.StmtDonationHistogram: fmt.Sprintf(`SELECT
+    receiver = :character_id AS received,
+    CASE
+        WHEN amount < 100000000 THEN 0
+        WHEN amount < 1000000000 THEN 1
+        WHEN amount < 10000000000 THEN 2
+        WHEN amount < 100000000000 THEN 3
+        ELSE 4
+    END AS bucket,
+    COUNT(*) AS count
+FROM %s
+WHERE %s(receiver = :character_id OR donator = :character_id)
+GROUP BY 1, 2`, storedDonations, counted),
+
+		// anonymized donations (donator 0) are no one's to rank
+		cx.StmtSupporters: fmt.Sprintf(`SELECT
+    donator,
+    COUNT(*) AS count,
+    SUM(isk) AS isk,
+    MIN(at) AS first,
+    MAX(at) AS last
+FROM (
+    SELECT donator, amount AS isk, "timestamp" AS at
+    FROM %s
+    WHERE %sreceiver = :character_id AND donator <> 0
+    UNION ALL
+    SELECT donator, value AS isk, issued AS at FROM contracts
+    WHERE accepted AND value > 0 AND receiver = :character_id AND donator <> 0
+) AS given
+WHERE donator NOT IN (SELECT character_id FROM characters WHERE banned)
+GROUP BY donator
+ORDER BY isk DESC, donator
+LIMIT :limit OFFSET :offset`, storedDonations, counted),
+
+		cx.StmtTransfersBetween: `SELECT * FROM ` + transfers + `
+WHERE (donator = :character_id AND receiver = :other_id)
+OR (donator = :other_id AND receiver = :character_id)
+ORDER BY "timestamp" DESC, id DESC
+LIMIT :limit OFFSET :offset`,
+
+		// anonymized donations (donator 0) are no one's
+		cx.StmtCounterparties: `SELECT DISTINCT CASE
+    WHEN donator = :character_id THEN receiver ELSE donator
+END AS character_id
+FROM ` + transfers + `
+WHERE (donator = :character_id OR receiver = :character_id)
+AND donator <> 0 AND donator <> receiver
+ORDER BY character_id`,
+
+		cx.StmtTransferTotals: `SELECT
+    donator,
+    COUNT(*) AS count,
+    COALESCE(SUM(isk), 0) AS isk
+FROM ` + transfers + `
+WHERE accepted AND (
+    (donator = :character_id AND receiver = :other_id)
+    OR (donator = :other_id AND receiver = :character_id)
+)
+GROUP BY donator`,
+
+		// percentiles are the share of ranked characters at or below each
+		cx.StmtUpdateRanks: `UPDATE characters SET
+    received_rank = ranked.received_rank,
+    donated_rank = ranked.donated_rank,
+    received_percentile = ranked.received_percentile,
+    donated_percentile = ranked.donated_percentile,
+    ranked_at = NOW() AT TIME ZONE 'UTC'
+FROM (
+    SELECT
+        character_id,
+        CASE WHEN received_isk_30 > 0 THEN RANK() OVER (
+            PARTITION BY received_isk_30 > 0 ORDER BY received_isk_30 DESC
+        ) END AS received_rank,
+        CASE WHEN donated_isk_30 > 0 THEN RANK() OVER (
+            PARTITION BY donated_isk_30 > 0 ORDER BY donated_isk_30 DESC
+        ) END AS donated_rank,
+        CASE WHEN received_isk_30 > 0 THEN ROUND(CAST(100 * CUME_DIST() OVER (
+            PARTITION BY received_isk_30 > 0 ORDER BY received_isk_30
+        ) AS NUMERIC), 2) END AS received_percentile,
+        CASE WHEN donated_isk_30 > 0 THEN ROUND(CAST(100 * CUME_DIST() OVER (
+            PARTITION BY donated_isk_30 > 0 ORDER BY donated_isk_30
+        ) AS NUMERIC), 2) END AS donated_percentile
+    FROM characters
+) AS ranked
+WHERE characters.character_id = ranked.character_id`,
+
+		// runs as one statement, so a crash leaves no partial snapshot, and
+		// a month is never snapshot twice
+		cx.StmtSnapshotLeaderboards: `INSERT INTO leaderboard_history (
+    month,
+    board,
+    position,
+    character_id,
+    count,
+    isk
+) SELECT CAST(:since AS DATE), * FROM (
+    ` + monthTop(
+			"receiver",
+			"received",
+			counted,
+			unflagged(opts, "characters.character_id"),
+		) + `
+    UNION ALL
+    ` + monthTop(
+			"donator",
+			"donated",
+			counted,
+			unflagged(opts, "characters.character_id"),
+		) + `
+) AS boards
+WHERE NOT EXISTS (
+    SELECT 1 FROM leaderboard_history WHERE month = CAST(:since AS DATE)
+)
+ON CONFLICT DO NOTHING`,
+
+		cx.StmtLeaderboardHistory: `SELECT board, position, character_id, count, isk
+FROM leaderboard_history
+WHERE month = CAST(:month AS DATE)
+ORDER BY board, position`,
+
+		cx.StmtSameOwner: `SELECT COUNT(*) FROM owners AS donator
+JOIN owners AS receiver ON donator.owner_hash = receiver.owner_hash
+WHERE donator.character_id = :donator AND receiver.character_id = :receiver`,
+
+		cx.StmtUpdateStandings: fmt.Sprintf(`UPDATE characters SET
+    good_standing = %[1]s
+WHERE character_id <> %[2]d
+AND (CAST(:character_id AS INTEGER) = 0 OR character_id = :character_id)
+AND good_standing IS DISTINCT FROM (%[1]s)
+RETURNING character_id`,
+			goodStanding(opts),
+			opts.CharacterID,
+		),
+
+		cx.StmtStandingSources: fmt.Sprintf(`SELECT closest.*
+FROM characters, LATERAL %s AS closest
+WHERE characters.character_id = :character_id AND closest.standing >= %v
+ORDER BY closest.source_id`,
+			closestContacts(opts.CharacterIDs),
+			opts.StandingThreshold,
+		),
+
+		cx.StmtDeleteContacts: `DELETE FROM standings_contacts
+WHERE source_id = :source_id`,
+
+		cx.StmtAddContact: `INSERT INTO standings_contacts (
+    source_id,
+    contact_id,
+    contact_type,
+    standing
+) VALUES (
+    :source_id,
+    :contact_id,
+    :contact_type,
+    :standing
+) ON CONFLICT (source_id, contact_id) DO UPDATE SET
+    contact_type = EXCLUDED.contact_type,
+    standing = EXCLUDED.standing`,
 	}
 
-	return statements
+	// backfills may find donations and contracts which are already stored,
+	// or donations already archived
+	queries[cx.StmtAddNewDonation] = `INSERT INTO donations (
+    transaction_id,
+    donator,
+    receiver,
+    "timestamp",
+    note,
+    amount,
+    self_donation
+) SELECT
+    :transaction_id,
+    :donator,
+    :receiver,
+    :timestamp,
+    :note,
+    :amount,
+    :self_donation
+WHERE NOT EXISTS (
+    SELECT 1 FROM donations_archive WHERE transaction_id = :transaction_id
+)
+ON CONFLICT (transaction_id) DO NOTHING`
+	queries[cx.StmtAddNewContract] = queries[cx.StmtAddContract] +
+		"\nON CONFLICT (contract_id) DO NOTHING"
+
+	return queries
 }
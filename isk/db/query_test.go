@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// stmtKeys returns the value of every Stmt constant declared in cx
+func stmtKeys(t *testing.T) map[string]cx.Key {
+	file, err := parser.ParseFile(
+		token.NewFileSet(),
+		"../cx/keys.go",
+		nil,
+		0,
+	)
+	if err != nil {
+		t.Fatalf("failed to parse keys: %+v", err)
+	}
+
+	keys := map[string]cx.Key{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		spec, ok := node.(*ast.ValueSpec)
+		if !ok || len(spec.Names) != 1 || len(spec.Values) != 1 ||
+			!strings.HasPrefix(spec.Names[0].Name, "Stmt") {
+			return true
+		}
+		call, ok := spec.Values[0].(*ast.CallExpr)
+		if !ok || len(call.Args) != 1 {
+			t.Fatalf("unexpected declaration of %s", spec.Names[0].Name)
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok {
+			t.Fatalf("unexpected value of %s", spec.Names[0].Name)
+		}
+		value, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			t.Fatalf("failed to unquote %s: %+v", spec.Names[0].Name, err)
+		}
+		keys[spec.Names[0].Name] = cx.Key(value)
+		return true
+	})
+	return keys
+}
+
+// defaultOptions returns the options of every flag left at its default
+func defaultOptions() (*cx.Options, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	options := cx.RegisterOptions(fs, cx.FlagsAll)
+	if err := fs.Parse(nil); err != nil {
+		return nil, err
+	}
+	return options(context.Background()).Value(cx.Opts).(*cx.Options), nil
+}
+
+func TestQueriesCoverStatements(t *testing.T) {
+	keys := stmtKeys(t)
+	if len(keys) == 0 {
+		t.Fatal("no statement keys were found")
+	}
+
+	opts, err := defaultOptions()
+	if err != nil {
+		t.Fatalf("failed to parse options: %+v", err)
+	}
+	sql := queries(opts)
+	for name, key := range keys {
+		if strings.TrimSpace(sql[key]) == "" {
+			t.Errorf("statement %s has no query", name)
+		}
+	}
+	if len(sql) != len(keys) {
+		t.Errorf("expected %d queries, have %d", len(keys), len(sql))
+	}
+}
+
+func TestReadyUnprepared(t *testing.T) {
+	if err := Ready(context.Background()); !errors.Is(err, ErrNotReady) {
+		t.Errorf("expected ErrNotReady, received %+v", err)
+	}
+}
+
+func TestExcludeRings(t *testing.T) {
+	opts, err := defaultOptions()
+	if err != nil {
+		t.Fatalf("failed to parse options: %+v", err)
+	}
+
+	boards := []cx.Key{
+		cx.StmtTopReceived,
+		cx.StmtTopDonated,
+		cx.StmtSnapshotLeaderboards,
+	}
+	for _, exclude := range []bool{false, true} {
+		opts.ExcludeRings = exclude
+		sql := queries(opts)
+		for _, key := range boards {
+			if strings.Contains(sql[key], "donation_rings") != exclude {
+				t.Errorf("%s: expected excluding rings %t", key, exclude)
+			}
+		}
+	}
+}
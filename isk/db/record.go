@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// ErrContractNotFound is returned when reading a contract which is not stored
+var ErrContractNotFound = errors.New("contract not found")
+
+// DonationRecord is a single donation with the parties of both characters
+type DonationRecord struct {
+	*Donation
+	DonatorParty  *Party `json:"donator_party,omitempty"`
+	ReceiverParty *Party `json:"receiver_party,omitempty"`
+}
+
+// ContractRecord is a single contract with the parties of both characters
+type ContractRecord struct {
+	*Contract
+	DonatorParty  *Party `json:"donator_party,omitempty"`
+	ReceiverParty *Party `json:"receiver_party,omitempty"`
+}
+
+// GetDonation returns the donation with the journal reference ID, unless
+// it is void. Banned characters are masked
+func GetDonation(ctx context.Context, id int64) (*DonationRecord, error) {
+	rows, err := queryNamedResult(ctx, cx.StmtDonation, map[string]interface{}{
+		"id": id,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &Donation{} })
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, ErrDonationNotFound
+	}
+
+	d := res[0].(*Donation)
+	d.Timestamp = d.Timestamp.UTC()
+
+	parties, err := getParties(ctx, []int32{d.Donator, d.Recipient})
+	if err != nil {
+		return nil, err
+	}
+	record := &DonationRecord{
+		Donation:      d,
+		DonatorParty:  parties[d.Donator],
+		ReceiverParty: parties[d.Recipient],
+	}
+	return record, record.maskBanned(ctx)
+}
+
+// GetContract returns the contract with the ID, and its items. Banned
+// characters are masked
+func GetContract(ctx context.Context, id int32) (*ContractRecord, error) {
+	rows, err := queryNamedResult(ctx, cx.StmtContract, map[string]interface{}{
+		"id": id,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &Contract{} })
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, ErrContractNotFound
+	}
+
+	k := res[0].(*Contract)
+	k.Issued = k.Issued.UTC()
+	k.Expires = k.Expires.UTC()
+	if err := GetContractItems(ctx, Contracts{k}); err != nil {
+		return nil, err
+	}
+
+	parties, err := getParties(ctx, []int32{k.Donator, k.Receiver})
+	if err != nil {
+		return nil, err
+	}
+	record := &ContractRecord{
+		Contract:      k,
+		DonatorParty:  parties[k.Donator],
+		ReceiverParty: parties[k.Receiver],
+	}
+	return record, record.maskBanned(ctx)
+}
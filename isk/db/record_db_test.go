@@ -0,0 +1,58 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetDonationDB(t *testing.T) {
+	ctx := testDB(t)
+
+	loadCharacters(t, ctx, &CharacterRow{ID: 1}, &CharacterRow{ID: 2})
+	loadDonations(t, ctx, testDonation(1, 0, 100))
+
+	d, err := GetDonation(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get donation: %+v", err)
+	}
+	if d.Donator != 2 || d.Recipient != 1 ||
+		d.DonatorParty == nil || d.ReceiverParty == nil {
+		t.Errorf("expected the donation with both parties, received %+v", d)
+	}
+
+	if _, err := GetDonation(ctx, 2); err != ErrDonationNotFound {
+		t.Errorf("expected an unknown donation not to be found, got %+v", err)
+	}
+}
+
+func TestGetContractDB(t *testing.T) {
+	ctx := testDB(t)
+
+	loadCharacters(t, ctx, &CharacterRow{ID: 1})
+	loadContracts(t, ctx, &Contract{
+		ID:       7,
+		Donator:  2,
+		Receiver: 1,
+		Issued:   testAt,
+		Expires:  testAt.Add(24 * time.Hour),
+		Status:   "outstanding",
+	})
+
+	k, err := GetContract(ctx, 7)
+	if err != nil {
+		t.Fatalf("failed to get contract: %+v", err)
+	}
+	if k.Items == nil || k.ReceiverParty == nil {
+		t.Errorf("expected the contract items and receiver, received %+v", k)
+	}
+	if k.DonatorParty != nil {
+		t.Errorf("expected no party of an unknown donator, received %+v", k)
+	}
+
+	if _, err := GetContract(ctx, 8); err != ErrContractNotFound {
+		t.Errorf("expected an unknown contract not to be found, got %+v", err)
+	}
+}
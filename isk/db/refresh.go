@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// RefreshInterval is how often each character may request a refresh
+const RefreshInterval = 1 * time.Minute
+
+// refresh job statuses
+const (
+	RefreshPending = "pending"
+	RefreshRunning = "running"
+	RefreshDone    = "done"
+	RefreshFailed  = "failed"
+)
+
+// ErrRefreshNotFound is returned for unknown refresh jobs
+var ErrRefreshNotFound = errors.New("refresh job not found")
+
+// ErrRefreshTooSoon is returned when the character refreshed too recently
+var ErrRefreshTooSoon = errors.New("refreshed too recently")
+
+// RefreshJob is a poll of a character requested by its owner
+type RefreshJob struct {
+	ID          int32       `db:"job_id" json:"job_id"`
+	CharacterID int32       `db:"character_id" json:"character_id"`
+	Status      string      `db:"status" json:"status"`
+	RequestedAt time.Time   `db:"requested_at" json:"requested_at"`
+	StartedAt   pq.NullTime `db:"started_at" json:"-"`
+	FinishedAt  pq.NullTime `db:"finished_at" json:"-"`
+}
+
+// Active is true for refresh jobs which have not finished
+func (j *RefreshJob) Active() bool {
+	return j.Status == RefreshPending || j.Status == RefreshRunning
+}
+
+// MarshalJSON implementation to write our timestamps in UTC, omitting the
+// start and finish until they are known
+func (j *RefreshJob) MarshalJSON() ([]byte, error) {
+	type Alias RefreshJob
+
+	var startedAtStr string
+	var finishedAtStr string
+
+	if j.StartedAt.Valid {
+		startedAtStr = j.StartedAt.Time.UTC().Format(time.RFC3339)
+	}
+	if j.FinishedAt.Valid {
+		finishedAtStr = j.FinishedAt.Time.UTC().Format(time.RFC3339)
+	}
+
+	return json.Marshal(&struct {
+		*Alias
+		RequestedAt string `json:"requested_at"`
+		StartedAt   string `json:"started_at,omitempty"`
+		FinishedAt  string `json:"finished_at,omitempty"`
+	}{
+		Alias:       (*Alias)(j),
+		RequestedAt: j.RequestedAt.UTC().Format(time.RFC3339),
+		StartedAt:   startedAtStr,
+		FinishedAt:  finishedAtStr,
+	})
+}
+
+// RequestRefresh adds a refresh job for the character, or returns their
+// active job. Characters may only request a refresh once each interval
+func RequestRefresh(ctx context.Context, charID int32) (*RefreshJob, error) {
+	reauth, err := NeedsReauth(ctx, charID)
+	if err != nil {
+		return nil, err
+	}
+	if reauth {
+		return nil, UserError{
+			Msg:  []byte("Log in again to allow your character to be polled"),
+			Code: 409,
+		}
+	}
+
+	values := map[string]interface{}{"character_id": charID}
+	latest, err := getRefresh(ctx, cx.StmtLatestRefresh, values)
+	if err == nil && !latest.Active() &&
+		time.Now().UTC().Sub(latest.RequestedAt) < RefreshInterval {
+		return nil, ErrRefreshTooSoon
+	} else if err != nil && err != ErrRefreshNotFound {
+		return nil, err
+	}
+
+	return getRefresh(ctx, cx.StmtRequestRefresh, values)
+}
+
+// GetRefresh returns the refresh job of the character
+func GetRefresh(ctx context.Context, charID, jobID int32) (*RefreshJob, error) {
+	return getRefresh(ctx, cx.StmtGetRefresh, map[string]interface{}{
+		"character_id": charID,
+		"job_id":       jobID,
+	})
+}
+
+// ClaimRefreshes marks pending refresh jobs as running and returns them
+func ClaimRefreshes(ctx context.Context) ([]*RefreshJob, error) {
+	rows, err := queryNamedResult(
+		ctx,
+		cx.StmtClaimRefreshes,
+		map[string]interface{}{},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanRefreshes(rows)
+}
+
+// FinishRefresh records the refresh job as done, or failed if pollErr is
+// not nil
+func FinishRefresh(ctx context.Context, jobID int32, pollErr error) error {
+	status := RefreshDone
+	if pollErr != nil {
+		status = RefreshFailed
+	}
+	return executeNamed(ctx, cx.StmtFinishRefresh, map[string]interface{}{
+		"job_id": jobID,
+		"status": status,
+	})
+}
+
+// RequeueRefresh returns the refresh job to pending, for a later attempt
+func RequeueRefresh(ctx context.Context, jobID int32) error {
+	return executeNamed(ctx, cx.StmtRequeueRefresh, map[string]interface{}{
+		"job_id": jobID,
+	})
+}
+
+// PruneRefreshes removes finished refresh jobs after a day
+func PruneRefreshes(ctx context.Context) error {
+	return executeNamed(ctx, cx.StmtPruneRefreshes, map[string]interface{}{})
+}
+
+func getRefresh(
+	ctx context.Context,
+	stmt cx.Key,
+	values map[string]interface{},
+) (*RefreshJob, error) {
+	rows, err := queryNamedResult(ctx, stmt, values)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := scanRefreshes(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) != 1 {
+		return nil, ErrRefreshNotFound
+	}
+	return jobs[0], nil
+}
+
+func scanRefreshes(rows *sqlx.Rows) ([]*RefreshJob, error) {
+	res, err := scan(rows, func() interface{} { return &RefreshJob{} })
+	if err != nil {
+		return nil, err
+	}
+	jobs := []*RefreshJob{}
+	for _, i := range res {
+		job := i.(*RefreshJob)
+		job.RequestedAt = job.RequestedAt.UTC()
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
@@ -0,0 +1,39 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestRefreshJobJSON(t *testing.T) {
+	requested := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	job := &RefreshJob{
+		ID:          7,
+		CharacterID: 1,
+		Status:      RefreshRunning,
+		RequestedAt: requested,
+		StartedAt:   pq.NullTime{Time: requested.Add(time.Second), Valid: true},
+	}
+
+	raw, err := json.Marshal(job)
+	if err != nil {
+		t.Fatalf("failed to marshal job: %+v", err)
+	}
+
+	expected := `{"job_id":7,"character_id":1,"status":"running",` +
+		`"requested_at":"2019-01-02T03:04:05Z","started_at":"2019-01-02T03:04:06Z"}`
+	if string(raw) != expected {
+		t.Errorf("expected %s, received %s", expected, raw)
+	}
+
+	if !job.Active() {
+		t.Error("running job is not active")
+	}
+	job.Status = RefreshFailed
+	if job.Active() {
+		t.Error("failed job is still active")
+	}
+}
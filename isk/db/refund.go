@@ -0,0 +1,201 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// Refund is a donation sent back, a player_donation journal entry of a
+// negative amount. It refunds the donation of the same journal ref ID, or
+// else the latest of the same amount between the characters before it
+type Refund struct {
+	// ID is the journal ref ID of the refund
+	ID int64 `db:"refund_id"`
+
+	// Donator of the donation refunded
+	Donator int32 `db:"donator"`
+
+	// Recipient of the donation refunded, who sent the ISK back
+	Recipient int32 `db:"receiver"`
+
+	// Timestamp of the refund
+	Timestamp time.Time `db:"timestamp"`
+
+	// Amount refunded, positive
+	Amount ISK `db:"amount"`
+
+	// TransactionID is the donation refunded, unset until it is seen
+	TransactionID sql.NullInt64 `db:"transaction_id"`
+}
+
+func refundValues(refund *Refund) map[string]interface{} {
+	return map[string]interface{}{
+		"refund_id": refund.ID,
+		"donator":   refund.Donator,
+		"receiver":  refund.Recipient,
+		"timestamp": refund.Timestamp.UTC(),
+		"amount":    refund.Amount,
+	}
+}
+
+// SaveRefunds stores the refunds which are new, voiding the donations they
+// refund and removing them from the totals of both characters. Refunds of
+// donations not stored yet wait for them, see MatchRefunds
+func SaveRefunds(ctx context.Context, refunds []*Refund) error {
+	for _, refund := range refunds {
+		added, err := executeNamedCount(
+			ctx,
+			cx.StmtAddRefund,
+			refundValues(refund),
+		)
+		if err != nil {
+			return err
+		}
+		if added == 0 {
+			continue
+		}
+
+		if err := applyRefund(ctx, refund); err == ErrDonationNotFound {
+			cx.Logf(ctx, "refund %d waits for its donation", refund.ID)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyRefund voids the donation the refund is of, and removes it from the
+// totals of both characters
+func applyRefund(ctx context.Context, refund *Refund) error {
+	// serialized with the totals saved by workers
+	unlock, err := LockTotals(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	charIDs := []int32{}
+
+	err = transaction(ctx, func(tx *sqlx.Tx) error {
+		donation, err := txDonation(
+			ctx,
+			tx,
+			cx.StmtRefundDonation,
+			refundValues(refund),
+		)
+		if err != nil {
+			return err
+		}
+
+		if err := linkRefund(ctx, tx, refund.ID, donation.ID); err != nil {
+			return err
+		}
+
+		for _, charID := range []int32{donation.Donator, donation.Recipient} {
+			char, err := txCharacterRow(ctx, tx, charID)
+			if err == ErrCharacterNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			if !donation.SelfDonation || opts.CountSelf {
+				reverseTotals(donation, []*CharacterRow{char})
+			}
+			if err := executeNamedTx(
+				ctx,
+				tx,
+				cx.StmtUpdateCharacter,
+				characterValues(char),
+			); err != nil {
+				return err
+			}
+			if charID == donation.Recipient {
+				if err := keepReceivedTx(ctx, tx, char, donation); err != nil {
+					return err
+				}
+			}
+			charIDs = append(charIDs, charID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := NotifyCharacters(ctx, charIDs); err != nil {
+		cx.Logf(ctx, "failed to notify character updates: %+v", err)
+	}
+	return nil
+}
+
+// MatchRefunds voids the stored donations which were refunded before they
+// were seen, returning the others. Call it before adding the donations to
+// any totals
+func MatchRefunds(
+	ctx context.Context,
+	donations []*Donation,
+) ([]*Donation, error) {
+	unrefunded := []*Donation{}
+	for _, donation := range donations {
+		refunded := false
+		err := transaction(ctx, func(tx *sqlx.Tx) error {
+			rows, err := queryNamedTx(
+				ctx,
+				tx,
+				cx.StmtPendingRefund,
+				donationValues(donation),
+			)
+			if err != nil {
+				return err
+			}
+
+			res, err := scan(rows, func() interface{} { return &Refund{} })
+			if err != nil || len(res) == 0 {
+				return err
+			}
+			refund := res[0].(*Refund)
+
+			if err := executeNamedTx(
+				ctx,
+				tx,
+				cx.StmtMarkRefunded,
+				map[string]interface{}{
+					"transaction_id": donation.ID,
+					"refund_id":      refund.ID,
+					"timestamp":      refund.Timestamp.UTC(),
+				},
+			); err != nil {
+				return err
+			}
+			refunded = true
+			return linkRefund(ctx, tx, refund.ID, donation.ID)
+		})
+		if err != nil {
+			return unrefunded, err
+		}
+		if !refunded {
+			unrefunded = append(unrefunded, donation)
+		}
+	}
+	return unrefunded, nil
+}
+
+// linkRefund records the donation the refund is of
+func linkRefund(
+	ctx context.Context,
+	tx *sqlx.Tx,
+	refundID int64,
+	transactionID int64,
+) error {
+	return executeNamedTx(ctx, tx, cx.StmtLinkRefund, map[string]interface{}{
+		"refund_id":      refundID,
+		"transaction_id": transactionID,
+	})
+}
@@ -0,0 +1,117 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testRefund returns a refund of amount from 1 back to 2, hours after testAt
+func testRefund(id int64, hours int, amount float64) *Refund {
+	return &Refund{
+		ID:        id,
+		Donator:   2,
+		Recipient: 1,
+		Timestamp: testAt.Add(time.Duration(hours) * time.Hour),
+		Amount:    NewISK(amount),
+	}
+}
+
+// countDonations saves the donations and adds them to the totals
+func countDonations(t *testing.T, ctx context.Context, donations ...*Donation) {
+	loadDonations(t, ctx, donations...)
+	if err := SaveCharacterDonations(
+		ctx,
+		donations,
+		testAffiliations(t, ctx),
+	); err != nil {
+		t.Fatalf("failed to count donations: %+v", err)
+	}
+}
+
+func TestRefundAfterDonationDB(t *testing.T) {
+	ctx := testDB(t)
+	countDonations(t, ctx, testDonation(1, 0, 1000), testDonation(2, 1, 500))
+
+	// refunds the latest donation of its amount before it
+	if err := SaveRefunds(ctx, []*Refund{testRefund(3, 2, 1000)}); err != nil {
+		t.Fatalf("failed to save refund: %+v", err)
+	}
+	// seeing it again changes nothing
+	if err := SaveRefunds(ctx, []*Refund{testRefund(3, 2, 1000)}); err != nil {
+		t.Fatalf("failed to save refund again: %+v", err)
+	}
+
+	recipient := getTestCharacter(t, ctx, 1)
+	if recipient.Received != 1 || recipient.ReceivedISK != NewISK(500) ||
+		recipient.Received30 != 1 || recipient.ReceivedISK30 != NewISK(500) {
+		t.Errorf("unexpected recipient totals %+v", recipient)
+	}
+	donator := getTestCharacter(t, ctx, 2)
+	if donator.Donated != 1 || donator.DonatedISK != NewISK(500) {
+		t.Errorf("unexpected donator totals %+v", donator)
+	}
+
+	if _, err := GetDonation(ctx, 1); err != ErrDonationNotFound {
+		t.Errorf("expected the refunded donation gone, received %+v", err)
+	}
+	if _, err := GetDonation(ctx, 2); err != nil {
+		t.Errorf("expected the other donation kept, received %+v", err)
+	}
+}
+
+func TestRefundOfSameRefDB(t *testing.T) {
+	ctx := testDB(t)
+	countDonations(t, ctx, testDonation(1, 0, 1000), testDonation(2, 1, 1000))
+
+	// the same ref ID wins over the latest donation of the amount
+	if err := SaveRefunds(ctx, []*Refund{testRefund(1, 2, 1000)}); err != nil {
+		t.Fatalf("failed to save refund: %+v", err)
+	}
+
+	if _, err := GetDonation(ctx, 1); err != ErrDonationNotFound {
+		t.Errorf("expected the refunded donation gone, received %+v", err)
+	}
+	if _, err := GetDonation(ctx, 2); err != nil {
+		t.Errorf("expected the later donation kept, received %+v", err)
+	}
+}
+
+func TestRefundBeforeDonationDB(t *testing.T) {
+	ctx := testDB(t)
+	countDonations(t, ctx, testDonation(1, 0, 500))
+
+	// nothing of its amount is stored, so it waits
+	if err := SaveRefunds(ctx, []*Refund{testRefund(3, 2, 1000)}); err != nil {
+		t.Fatalf("failed to save refund: %+v", err)
+	}
+	if recipient := getTestCharacter(t, ctx, 1); recipient.Received != 1 {
+		t.Errorf("expected the other donation counted, received %+v", recipient)
+	}
+
+	// a later donation of the amount is not what it refunds
+	later := testDonation(4, 3, 1000)
+	refunded := testDonation(2, 1, 1000)
+	loadDonations(t, ctx, later, refunded)
+	counted, err := MatchRefunds(ctx, []*Donation{later, refunded})
+	if err != nil {
+		t.Fatalf("failed to match refunds: %+v", err)
+	}
+	if len(counted) != 1 || counted[0].ID != later.ID {
+		t.Fatalf("expected only the later donation counted, received %+v", counted)
+	}
+	if _, err := GetDonation(ctx, refunded.ID); err != ErrDonationNotFound {
+		t.Errorf("expected the refunded donation gone, received %+v", err)
+	}
+
+	// the refund is used up
+	again := testDonation(5, 1, 1000)
+	loadDonations(t, ctx, again)
+	counted, err = MatchRefunds(ctx, []*Donation{again})
+	if err != nil || len(counted) != 1 {
+		t.Errorf("expected the donation counted, received %+v: %+v", counted, err)
+	}
+}
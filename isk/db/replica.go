@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/metrics"
+)
+
+// replicaBackoff is how long reads stay on the primary once the replica fails
+const replicaBackoff = 30 * time.Second
+
+// replicaFallbacks counts the reads which were for the replica
+var replicaFallbacks = metrics.NewCounter(
+	"esi_isk_db_replica_fallbacks_total",
+	"Reads sent to the primary db while the replica is down",
+)
+
+// Replica is a read only copy of the db, for the leaderboards and character
+// pages. Its reads may lag behind the primary, so only read only methods,
+// marked by replicated, use it. Its statements are not prepared, so it may
+// be down when the server starts
+type Replica struct {
+	db      *sqlx.DB
+	queries map[cx.Key]string
+
+	lock      sync.Mutex
+	downUntil time.Time
+}
+
+// OpenReplica connects to the -db-replica, if set, adding it to the context
+func OpenReplica(ctx context.Context) context.Context {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	if opts.DB.Replica == "" {
+		return ctx
+	}
+
+	db, err := sqlx.Open("postgres", opts.DB.Replica)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	replica := &Replica{db: db, queries: queries(opts)}
+	if err := db.Ping(); err != nil {
+		replica.fallback(err)
+	} else {
+		log.Println("db replica connection ok")
+	}
+	return context.WithValue(ctx, cx.Replica, replica)
+}
+
+// replicated returns the context of a read only method, which reads from
+// the replica if there is one
+func replicated(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cx.ReadReplica, true)
+}
+
+// replicaFor returns the replica the read should use, nil for the primary
+func replicaFor(ctx context.Context) *Replica {
+	marked, _ := ctx.Value(cx.ReadReplica).(bool)
+	replica, _ := ctx.Value(cx.Replica).(*Replica)
+	if !marked || replica == nil {
+		return nil
+	}
+
+	replica.lock.Lock()
+	down := time.Now().Before(replica.downUntil)
+	replica.lock.Unlock()
+	if down {
+		replicaFallbacks.Inc()
+		return nil
+	}
+	return replica
+}
+
+// fallback is true if the error is of the replica being unavailable, the
+// read should then use the primary. Reads stay on the primary for
+// replicaBackoff
+func (r *Replica) fallback(err error) bool {
+	if !isUnavailable(err) {
+		return false
+	}
+
+	r.lock.Lock()
+	r.downUntil = time.Now().Add(replicaBackoff)
+	r.lock.Unlock()
+
+	replicaFallbacks.Inc()
+	log.Printf("db replica is down, reading from the primary: %+v", err)
+	return true
+}
+
+// isUnavailable is true for errors of connecting to the db, rather than of
+// the statement
+func isUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// connection exceptions, and the server shutting down or starting up
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03":
+			return true
+		}
+		return pqErr.Code.Class() == "08"
+	}
+	return false
+}
+
+func (r *Replica) query(
+	ctx context.Context,
+	stmt cx.Key,
+	values map[string]interface{},
+) (*sqlx.Rows, error) {
+	return r.db.NamedQueryContext(ctx, r.queries[stmt], values)
+}
+
+func (r *Replica) get(
+	ctx context.Context,
+	stmt cx.Key,
+	dest interface{},
+	values map[string]interface{},
+) error {
+	query, args, err := sqlx.Named(r.queries[stmt], values)
+	if err != nil {
+		return err
+	}
+	return r.db.GetContext(ctx, dest, r.db.Rebind(query), args...)
+}
+
+func (r *Replica) queryIn(
+	ctx context.Context,
+	query string,
+	args []interface{},
+) (*sqlx.Rows, error) {
+	return r.db.QueryxContext(ctx, r.db.Rebind(query), args...)
+}
@@ -0,0 +1,83 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// testReplica returns the context with a replica of the connection string
+func testReplica(
+	t *testing.T,
+	ctx context.Context,
+	replica string,
+) context.Context {
+	opts := *ctx.Value(cx.Opts).(*cx.Options)
+	dbOpts := *opts.DB
+	dbOpts.Replica = replica
+	opts.DB = &dbOpts
+
+	ctx = OpenReplica(context.WithValue(ctx, cx.Opts, &opts))
+	t.Cleanup(func() {
+		if err := ctx.Value(cx.Replica).(*Replica).db.Close(); err != nil {
+			t.Errorf("failed to close replica: %+v", err)
+		}
+	})
+	return ctx
+}
+
+func TestReplicaReadsDB(t *testing.T) {
+	ctx := testDB(t)
+	countDonations(t, ctx, testDonation(1, 0, 1000))
+
+	// the primary is its own replica
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	ctx = testReplica(t, ctx, dsn(opts))
+
+	before := replicaFallbacks.Value()
+	details, err := GetCharDetails(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get character from the replica: %+v", err)
+	}
+	if details.Character.Received != 1 || len(details.Donations) != 1 {
+		t.Errorf("unexpected character %+v", details)
+	}
+	if _, err := CountSitemap(ctx); err != nil {
+		t.Errorf("failed to count the sitemap on the replica: %+v", err)
+	}
+	if fallbacks := replicaFallbacks.Value() - before; fallbacks != 0 {
+		t.Errorf("expected no fallbacks, received %d", fallbacks)
+	}
+}
+
+func TestReplicaDownDB(t *testing.T) {
+	ctx := testDB(t)
+	countDonations(t, ctx, testDonation(1, 0, 1000))
+
+	before := replicaFallbacks.Value()
+	ctx = testReplica(
+		t,
+		ctx,
+		"postgres://esi-isk@127.0.0.1:1/esi-isk?sslmode=disable",
+	)
+
+	top, err := GetTopRecipients(ctx, WindowMonth)
+	if err != nil {
+		t.Fatalf("failed to fall back to the primary: %+v", err)
+	}
+	if len(top) != 1 || top[0].ID != 1 {
+		t.Errorf("unexpected top recipients %+v", top)
+	}
+	if replicaFallbacks.Value() == before {
+		t.Error("fallback to the primary was not counted")
+	}
+
+	// writes never use the replica
+	if err := UpdateRanks(ctx); err != nil {
+		t.Errorf("failed to write to the primary: %+v", err)
+	}
+}
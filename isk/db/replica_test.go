@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestIsUnavailable(t *testing.T) {
+	cases := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"nil":           {nil, false},
+		"refused":       {&net.OpError{Op: "dial", Err: errors.New("refused")}, true},
+		"bad conn":      {driver.ErrBadConn, true},
+		"eof":           {fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		"cannot accept": {&pq.Error{Code: "08004"}, true},
+		"shutting down": {&pq.Error{Code: "57P01"}, true},
+		"starting up":   {&pq.Error{Code: "57P03"}, true},
+		"syntax":        {&pq.Error{Code: "42601"}, false},
+		"other":         {errors.New("no rows"), false},
+	}
+
+	for name, c := range cases {
+		if received := isUnavailable(c.err); received != c.expected {
+			t.Errorf("%s: received %t, expected %t", name, received, c.expected)
+		}
+	}
+}
+
+func TestReplicaFor(t *testing.T) {
+	replica := &Replica{}
+	ctx := context.WithValue(context.Background(), cx.Replica, replica)
+
+	if replicaFor(context.Background()) != nil {
+		t.Error("read from a replica which is not set")
+	}
+	if replicaFor(replicated(context.Background())) != nil {
+		t.Error("read from a replica which is not set, when replicated")
+	}
+	if replicaFor(ctx) != nil {
+		t.Error("read from the replica when not replicated")
+	}
+	if replicaFor(replicated(ctx)) != replica {
+		t.Error("replicated read did not use the replica")
+	}
+
+	before := replicaFallbacks.Value()
+	if replica.fallback(errors.New("no rows")) {
+		t.Error("fell back on a statement error")
+	}
+	if !replica.fallback(driver.ErrBadConn) {
+		t.Error("did not fall back when the replica is down")
+	}
+	if replicaFor(replicated(ctx)) != nil {
+		t.Error("read from the replica while it is down")
+	}
+	if fallbacks := replicaFallbacks.Value() - before; fallbacks != 2 {
+		t.Errorf("expected 2 fallbacks, received %d", fallbacks)
+	}
+
+	replica.downUntil = time.Now().Add(-time.Second)
+	if replicaFor(replicated(ctx)) != replica {
+		t.Error("did not read from the replica once it is back")
+	}
+}
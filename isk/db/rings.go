@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// RingWindow is how far back donations count towards a donation ring
+const RingWindow = 30 * 24 * time.Hour
+
+// ErrRingNotFound is returned when reviewing an unknown donation ring
+var ErrRingNotFound = errors.New("donation ring not found")
+
+// Filters of the donation rings report
+const (
+	RingsPending  = "pending"
+	RingsReviewed = "reviewed"
+	RingsAll      = "all"
+)
+
+// DonationRing is a pair, or ring of three, of characters sending each next
+// one roughly the same ISK. Members are in the order the ISK flows, from the
+// lowest ID. Low and High are the least and most ISK any of them sent in the
+// 30 days up to LastSeen
+type DonationRing struct {
+	ID         int64      `db:"id" json:"id"`
+	Members    []int32    `db:"-" json:"members"`
+	Low        ISK        `db:"low" json:"low"`
+	High       ISK        `db:"high" json:"high"`
+	DetectedAt time.Time  `db:"detected_at" json:"detected_at"`
+	LastSeen   time.Time  `db:"last_seen" json:"last_seen"`
+	ReviewedAt *time.Time `db:"reviewed_at" json:"reviewed_at"`
+	ReviewedBy string     `db:"reviewed_by" json:"reviewed_by"`
+}
+
+// ringRow is a DonationRing as stored
+type ringRow struct {
+	DonationRing
+	Members pq.Int64Array `db:"members"`
+}
+
+// RingTarget is the audit log target of a donation ring
+func RingTarget(ringID int64) string {
+	return fmt.Sprintf("ring:%d", ringID)
+}
+
+// IsRingsFilter is true for the filters of GetDonationRings
+func IsRingsFilter(filter string) bool {
+	switch filter {
+	case RingsPending, RingsReviewed, RingsAll:
+		return true
+	}
+	return false
+}
+
+// DetectRings stores and returns the donation rings of the 30 days up to now
+// with each character sending at least threshold, and the least sent within
+// tolerance of the most. Rings found before keep their review
+func DetectRings(
+	ctx context.Context,
+	now time.Time,
+	threshold ISK,
+	tolerance float64,
+) ([]*DonationRing, error) {
+	rows, err := queryNamedResult(
+		ctx,
+		cx.StmtDetectRings,
+		map[string]interface{}{
+			"since":     now.UTC().Add(-RingWindow),
+			"now":       now.UTC(),
+			"threshold": threshold,
+			"tolerance": tolerance,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRings(rows)
+}
+
+// CountPendingRings returns the number of donation rings pending review
+func CountPendingRings(ctx context.Context) (int, error) {
+	count := 0
+	err := getNamedResult(
+		ctx,
+		cx.StmtCountPendingRings,
+		&count,
+		map[string]interface{}{},
+	)
+	return count, err
+}
+
+// GetDonationRings returns a page of the donation rings matching the filter,
+// newest first
+func GetDonationRings(
+	ctx context.Context,
+	filter string,
+	limit, offset int,
+) ([]*DonationRing, error) {
+	rows, err := queryNamedResult(
+		ctx,
+		cx.StmtDonationRings,
+		map[string]interface{}{
+			"all":      filter == RingsAll,
+			"reviewed": filter == RingsReviewed,
+			"limit":    limit,
+			"offset":   offset,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanRings(rows)
+}
+
+func scanRings(rows *sqlx.Rows) ([]*DonationRing, error) {
+	rings := []*DonationRing{}
+	err := each(rows, func() interface{} { return &ringRow{} }, func(
+		i interface{},
+	) error {
+		row := i.(*ringRow)
+		ring := row.DonationRing
+		ring.Members = []int32{}
+		for _, member := range row.Members {
+			ring.Members = append(ring.Members, int32(member))
+		}
+		rings = append(rings, &ring)
+		return nil
+	})
+	return rings, err
+}
+
+// ReviewRing marks the donation ring reviewed, so it no longer counts as
+// flagged, auditing the correction in the same transaction
+func ReviewRing(ctx context.Context, ringID int64, c *Correction) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+
+	return transaction(ctx, func(tx *sqlx.Tx) error {
+		updated, err := executeNamedTxCount(
+			ctx,
+			tx,
+			cx.StmtReviewRing,
+			map[string]interface{}{
+				"id":          ringID,
+				"reviewed_at": time.Now().UTC(),
+				"reviewed_by": c.Admin,
+			},
+		)
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return ErrRingNotFound
+		}
+
+		return writeAuditTx(
+			ctx,
+			tx,
+			CorrectionActor(c),
+			"review_ring",
+			RingTarget(ringID),
+			map[string]string{"reason": c.Reason},
+		)
+	})
+}
@@ -0,0 +1,100 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestDonationRingsDB(t *testing.T) {
+	ctx := testDB(t)
+
+	now := testAt.Add(48 * time.Hour)
+	id := int64(0)
+	send := func(donator, receiver int32, amount float64, at time.Time) {
+		id++
+		loadDonations(t, ctx, &Donation{
+			ID:        id,
+			Donator:   donator,
+			Recipient: receiver,
+			Timestamp: at,
+			Amount:    NewISK(amount),
+		})
+	}
+
+	// a pair within the tolerance
+	send(1, 2, 1000, testAt)
+	send(2, 1, 900, testAt)
+	// too far apart
+	send(3, 4, 1000, testAt)
+	send(4, 3, 100, testAt)
+	// a ring of three
+	send(5, 6, 1000, testAt)
+	send(6, 7, 1000, testAt)
+	send(7, 5, 950, testAt)
+	// below the threshold
+	send(8, 9, 10, testAt)
+	send(9, 8, 10, testAt)
+	// outside the window
+	send(10, 11, 1000, now.Add(-RingWindow-time.Hour))
+	send(11, 10, 1000, testAt)
+
+	members := func(rings []*DonationRing) [][]int32 {
+		sort.Slice(rings, func(i, j int) bool {
+			return rings[i].Members[0] < rings[j].Members[0]
+		})
+		all := [][]int32{}
+		for _, ring := range rings {
+			all = append(all, ring.Members)
+		}
+		return all
+	}
+
+	rings, err := DetectRings(ctx, now, NewISK(500), 0.2)
+	if err != nil {
+		t.Fatalf("failed to detect rings: %+v", err)
+	}
+	expected := [][]int32{{1, 2}, {5, 6, 7}}
+	if found := members(rings); !reflect.DeepEqual(found, expected) {
+		t.Fatalf("expected rings %v, received %v", expected, found)
+	}
+	if rings[0].Low != NewISK(900) || rings[0].High != NewISK(1000) {
+		t.Errorf("expected the pair to send 900 to 1000, received %+v", rings[0])
+	}
+
+	c := &Correction{Admin: "tester", Reason: "alts"}
+	if err := ReviewRing(ctx, -1, c); err != ErrRingNotFound {
+		t.Errorf("expected ring not found, received %+v", err)
+	}
+	if err := ReviewRing(ctx, rings[0].ID, c); err != nil {
+		t.Fatalf("failed to review ring: %+v", err)
+	}
+
+	// detecting again keeps the review
+	if _, err := DetectRings(ctx, now, NewISK(500), 0.2); err != nil {
+		t.Fatalf("failed to detect rings again: %+v", err)
+	}
+
+	for filter, expected := range map[string][][]int32{
+		RingsPending:  {{5, 6, 7}},
+		RingsReviewed: {{1, 2}},
+		RingsAll:      {{1, 2}, {5, 6, 7}},
+	} {
+		rings, err := GetDonationRings(ctx, filter, 10, 0)
+		if err != nil {
+			t.Fatalf("failed to get %s rings: %+v", filter, err)
+		}
+		if found := members(rings); !reflect.DeepEqual(found, expected) {
+			t.Errorf("%s: expected %v, received %v", filter, expected, found)
+		}
+	}
+
+	pending, err := CountPendingRings(ctx)
+	if err != nil || pending != 1 {
+		t.Errorf("expected 1 pending ring, received %d: %+v", pending, err)
+	}
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// SitemapSize is the most URLs listed in one sitemap
+const SitemapSize = 50000
+
+// SitemapEntry is a visible character, with its current slug if it has one
+// and the time it last sent or received ISK
+type SitemapEntry struct {
+	CharacterID  int32          `db:"character_id"`
+	Slug         sql.NullString `db:"slug"`
+	LastModified pq.NullTime    `db:"last_modified"`
+}
+
+// CountSitemap returns the number of visible characters
+func CountSitemap(ctx context.Context) (int, error) {
+	ctx = replicated(ctx)
+	count := 0
+	err := getNamedResult(
+		ctx,
+		cx.StmtCountSitemap,
+		&count,
+		map[string]interface{}{},
+	)
+	return count, err
+}
+
+// GetSitemap returns the page, from 0, of SitemapSize visible characters in
+// character ID order
+func GetSitemap(ctx context.Context, page int) ([]*SitemapEntry, error) {
+	ctx = replicated(ctx)
+	rows, err := queryNamedResult(ctx, cx.StmtSitemap, map[string]interface{}{
+		"limit":  SitemapSize,
+		"offset": page * SitemapSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*SitemapEntry{}
+	err = each(rows, func() interface{} { return &SitemapEntry{} }, func(
+		i interface{},
+	) error {
+		entries = append(entries, i.(*SitemapEntry))
+		return nil
+	})
+	return entries, err
+}
@@ -0,0 +1,36 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import "testing"
+
+func TestSitemapDB(t *testing.T) {
+	ctx := testDB(t)
+
+	loadCharacters(t, ctx, &CharacterRow{ID: 1}, &CharacterRow{ID: 2})
+	if err := ClaimSlug(ctx, 2, "second"); err != nil {
+		t.Fatalf("failed to claim slug: %+v", err)
+	}
+
+	count, err := CountSitemap(ctx)
+	if err != nil || count != 2 {
+		t.Errorf("counted %d characters (%v), expected 2", count, err)
+	}
+
+	entries, err := GetSitemap(ctx, 0)
+	if err != nil {
+		t.Fatalf("failed to get sitemap: %+v", err)
+	}
+	if len(entries) != 2 || entries[0].CharacterID != 1 ||
+		entries[0].Slug.Valid || entries[1].Slug.String != "second" {
+		t.Errorf("unexpected sitemap %+v", entries)
+	}
+	if entries[0].LastModified.Valid {
+		t.Errorf("inactive character has a last modified time")
+	}
+
+	if entries, err = GetSitemap(ctx, 1); err != nil || len(entries) != 0 {
+		t.Errorf("expected an empty second page, received %+v (%v)", entries, err)
+	}
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// ErrSlugNotFound is returned for slugs which are unclaimed or expired
+var ErrSlugNotFound = errors.New("slug not found")
+
+// reSlug is the form of a valid slug
+var reSlug = regexp.MustCompile(`^[a-z0-9-]{3,30}$`)
+
+// reservedSlugs could be confused with our own pages or staff
+var reservedSlugs = map[string]bool{
+	"admin":    true,
+	"api":      true,
+	"callback": true,
+	"char":     true,
+	"chars":    true,
+	"esi-isk":  true,
+	"help":     true,
+	"login":    true,
+	"logout":   true,
+	"metrics":  true,
+	"prefs":    true,
+	"root":     true,
+	"signup":   true,
+	"static":   true,
+	"support":  true,
+	"top":      true,
+	"widget":   true,
+}
+
+// Slug is a vanity URL name of a character
+type Slug struct {
+	Slug        string      `db:"slug" json:"slug"`
+	CharacterID int32       `db:"character_id" json:"character_id"`
+	ClaimedAt   time.Time   `db:"claimed_at" json:"claimed_at"`
+	ReplacedAt  pq.NullTime `db:"replaced_at" json:"-"`
+}
+
+// IsSlug is true if s is in the form of a slug, it may not be claimed
+func IsSlug(s string) bool {
+	return reSlug.MatchString(s)
+}
+
+// CheckSlug ensures the slug is valid and not reserved
+func CheckSlug(slug string) error {
+	if !IsSlug(slug) {
+		return UserError{
+			Msg:  []byte("Slugs must be 3 to 30 of a-z, 0-9 and -"),
+			Code: 400,
+		}
+	}
+	if reservedSlugs[slug] {
+		return UserError{Msg: []byte("Slug is reserved"), Code: 400}
+	}
+	return nil
+}
+
+// ClaimSlug makes slug the character's current slug, their previous slug
+// redirects for 30 days. Slugs owned by others can not be claimed until their
+// redirect period is over
+func ClaimSlug(ctx context.Context, charID int32, slug string) error {
+	if err := CheckSlug(slug); err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{"character_id": charID, "slug": slug}
+	return transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := executeNamedTx(ctx, tx, cx.StmtRetireSlug, values); err != nil {
+			return err
+		}
+
+		claimed, err := executeNamedTxCount(ctx, tx, cx.StmtClaimSlug, values)
+		if err != nil {
+			return err
+		}
+		if claimed == 0 {
+			return UserError{Msg: []byte("Slug is already taken"), Code: 409}
+		}
+		return nil
+	})
+}
+
+// ResolveSlug returns the character of a current or redirecting slug, and the
+// character's current slug, which differs for redirecting slugs
+func ResolveSlug(ctx context.Context, slug string) (int32, string, error) {
+	found, err := getSlug(ctx, cx.StmtResolveSlug, map[string]interface{}{
+		"slug": slug,
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	if !found.ReplacedAt.Valid {
+		return found.CharacterID, found.Slug, nil
+	}
+
+	current, err := GetSlug(ctx, found.CharacterID)
+	if err == ErrSlugNotFound {
+		return found.CharacterID, "", nil
+	} else if err != nil {
+		return 0, "", err
+	}
+	return found.CharacterID, current.Slug, nil
+}
+
+// GetSlug returns the current slug of the character
+func GetSlug(ctx context.Context, charID int32) (*Slug, error) {
+	return getSlug(ctx, cx.StmtCurrentSlug, map[string]interface{}{
+		"character_id": charID,
+	})
+}
+
+func getSlug(
+	ctx context.Context,
+	stmt cx.Key,
+	values map[string]interface{},
+) (*Slug, error) {
+	rows, err := queryNamedResult(ctx, stmt, values)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &Slug{} })
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range res {
+		slug := i.(*Slug)
+		slug.ClaimedAt = slug.ClaimedAt.UTC()
+		return slug, nil
+	}
+	return nil, ErrSlugNotFound
+}
@@ -0,0 +1,26 @@
+package db
+
+import "testing"
+
+func TestCheckSlug(t *testing.T) {
+	for slug, valid := range map[string]bool{
+		"mynickname":                      true,
+		"a-b-c":                           true,
+		"007":                             true,
+		"ab":                              false,
+		"MyNickname":                      false,
+		"my_nickname":                     false,
+		"my nickname":                     false,
+		"api":                             false,
+		"admin":                           false,
+		"widget":                          false,
+		"abcdefghijklmnopqrstuvwxyz0123":  true,
+		"abcdefghijklmnopqrstuvwxyz01234": false,
+	} {
+		if err := CheckSlug(slug); valid && err != nil {
+			t.Errorf("%q: unexpected error: %+v", slug, err)
+		} else if !valid && err == nil {
+			t.Errorf("%q: expected an error", slug)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+//go:build dbtest
+// +build dbtest
+
+package db_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/api"
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// standingDB returns a test database with the options excluding characters
+// in bad standing or not. Character 1 is in good standing, 2 is not
+func standingDB(t *testing.T, exclude bool) context.Context {
+	ctx := db.TestDB(t, func(opts *cx.Options) {
+		opts.ExcludeBadStanding = exclude
+	})
+	for _, char := range []*db.CharacterRow{
+		{ID: 1, GoodStanding: true},
+		{ID: 2},
+	} {
+		if err := db.NewCharacter(ctx, char); err != nil {
+			t.Fatalf("failed to load character %d: %+v", char.ID, err)
+		}
+	}
+	return ctx
+}
+
+// getJSON runs the handler and decodes its response into res
+func getJSON(t *testing.T, h http.HandlerFunc, target string, res interface{}) {
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code != 200 {
+		t.Fatalf("%s: expected 200, received %d", target, w.Code)
+	}
+	if err := json.NewDecoder(w.Body).Decode(res); err != nil {
+		t.Fatalf("%s: failed to decode response: %+v", target, err)
+	}
+}
+
+func TestTrendingStandingDB(t *testing.T) {
+	for exclude, expected := range map[bool]int32{false: 2, true: 1} {
+		ctx := standingDB(t, exclude)
+		hour := time.Now().UTC().Truncate(time.Hour)
+		if err := db.AddViews(ctx, []*db.HourViews{
+			{CharacterID: 1, Hour: hour, Views: 5},
+			{CharacterID: 2, Hour: hour, Views: 10},
+		}); err != nil {
+			t.Fatalf("failed to add views: %+v", err)
+		}
+
+		res := struct {
+			Characters []struct {
+				Character struct {
+					ID int32 `json:"id"`
+				} `json:"character"`
+			} `json:"characters"`
+		}{}
+		// the limit is of the characters left
+		getJSON(t, api.Trending(ctx), "/api/leaderboard/trending?limit=1", &res)
+		if len(res.Characters) != 1 ||
+			res.Characters[0].Character.ID != expected {
+			t.Errorf("exclude %t: expected character %d, received %+v",
+				exclude, expected, res.Characters)
+		}
+	}
+}
+
+func TestNotableContractsStandingDB(t *testing.T) {
+	for exclude, expected := range map[bool]int32{false: 2, true: 1} {
+		ctx := standingDB(t, exclude)
+		now := time.Now().UTC()
+		// the bigger contract is received by character 2
+		for _, receiver := range []int32{1, 2} {
+			if err := db.SaveContract(ctx, &db.Contract{
+				ID:       receiver,
+				Donator:  1,
+				Receiver: receiver,
+				Issued:   now.Add(-time.Hour),
+				Expires:  now.Add(time.Hour),
+				Accepted: true,
+				Status:   "finished",
+				Value:    db.NewISK(float64(receiver) * 1000),
+				Items: []*db.Item{{
+					ID:         int64(receiver),
+					ContractID: receiver,
+					TypeID:     db.PLEXTypeID,
+					Quantity:   100,
+				}},
+			}); err != nil {
+				t.Fatalf("failed to save contract: %+v", err)
+			}
+		}
+
+		res := struct {
+			Contracts []struct {
+				ID int32 `json:"id"`
+			} `json:"contracts"`
+		}{}
+		getJSON(
+			t,
+			api.NotableContracts(ctx),
+			"/api/leaderboard/notable?limit=1",
+			&res,
+		)
+		if len(res.Contracts) != 1 || res.Contracts[0].ID != expected {
+			t.Errorf("exclude %t: expected contract %d, received %+v",
+				exclude, expected, res.Contracts)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// Contact is a contact of a standings source character
+type Contact struct {
+	// SourceID is the standings character with the contact
+	SourceID int32 `db:"source_id" json:"source"`
+
+	// ContactID is the character, corporation or alliance ID
+	ContactID int32 `db:"contact_id" json:"contact_id"`
+
+	// ContactType is character, corporation or alliance
+	ContactType string `db:"contact_type" json:"contact_type"`
+
+	// Standing set for the contact, from -10 to 10
+	Standing float32 `db:"standing" json:"standing"`
+}
+
+// UpdateStandings sets the good standing of the characters, from their
+// donations to the owner and the contacts of every source, returning those
+// which changed. Hold LockTotals, so saves of the characters don't revert it
+func UpdateStandings(ctx context.Context, charIDs []int32) ([]int32, error) {
+	changed := []int32{}
+	err := transaction(ctx, func(tx *sqlx.Tx) error {
+		for _, charID := range charIDs {
+			ids, err := updateStandings(ctx, tx, charID)
+			if err != nil {
+				return err
+			}
+			changed = append(changed, ids...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	notifyStandings(ctx, changed)
+	return changed, nil
+}
+
+// SyncStandings replaces the stored contacts of the standings source, then
+// updates the standing of every character, returning those which changed.
+// Removed contacts no longer count towards good standing. Hold LockTotals,
+// so saves of the characters don't revert it
+func SyncStandings(
+	ctx context.Context,
+	sourceID int32,
+	contacts []*Contact,
+) ([]int32, error) {
+	var changed []int32
+	err := transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := executeNamedTx(
+			ctx,
+			tx,
+			cx.StmtDeleteContacts,
+			map[string]interface{}{"source_id": sourceID},
+		); err != nil {
+			return err
+		}
+
+		for _, contact := range contacts {
+			if err := executeNamedTx(ctx, tx, cx.StmtAddContact, map[string]interface{}{
+				"source_id":    sourceID,
+				"contact_id":   contact.ContactID,
+				"contact_type": contact.ContactType,
+				"standing":     contact.Standing,
+			}); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		changed, err = updateStandings(ctx, tx, 0)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	notifyStandings(ctx, changed)
+	return changed, nil
+}
+
+// GetStandingSources returns the contacts giving the character good
+// standing, the closest of each standings source at or above the threshold
+func GetStandingSources(ctx context.Context, charID int32) ([]*Contact, error) {
+	rows, err := queryNamedResult(
+		ctx,
+		cx.StmtStandingSources,
+		map[string]interface{}{"character_id": charID},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &Contact{} })
+	if err != nil {
+		return nil, err
+	}
+	contacts := []*Contact{}
+	for _, i := range res {
+		contacts = append(contacts, i.(*Contact))
+	}
+	return contacts, nil
+}
+
+// updateStandings updates the character, or every character for 0, within
+// the transaction
+func updateStandings(
+	ctx context.Context,
+	tx *sqlx.Tx,
+	charID int32,
+) ([]int32, error) {
+	rows, err := queryNamedTx(
+		ctx,
+		tx,
+		cx.StmtUpdateStandings,
+		map[string]interface{}{"character_id": charID},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &CharacterRow{} })
+	if err != nil {
+		return nil, err
+	}
+	changed := []int32{}
+	for _, i := range res {
+		changed = append(changed, i.(*CharacterRow).ID)
+	}
+	return changed, nil
+}
+
+// notifyStandings notifies listeners of the characters whose standing changed
+func notifyStandings(ctx context.Context, charIDs []int32) {
+	if err := NotifyCharacters(ctx, charIDs); err != nil {
+		cx.Logf(ctx, "failed to notify character updates: %+v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// donorStatsMaxAge is how long the donor stats of a character are shown
+// after they were computed. They are computed by the hourly maintenance, so
+// this allows for a couple of missed runs
+const donorStatsMaxAge = 3 * time.Hour
+
+// DonorStats summarize the counted donations a character received. Unique
+// donors can't be counted as donations are, so they are all computed
+// periodically from the stored donations
+type DonorStats struct {
+	// AverageDonation is the average ISK of every donation received
+	AverageDonation ISK `json:"average_donation"`
+
+	// AverageDonation30 is the average ISK of those in the last 30 days
+	AverageDonation30 ISK `json:"average_donation_30"`
+
+	// UniqueDonors is how many characters donated, anonymized ones aside
+	UniqueDonors int64 `json:"unique_donors"`
+
+	// RepeatDonorPercent of the unique donors who donated more than once
+	RepeatDonorPercent float64 `json:"repeat_donor_percent"`
+
+	// ComputedAt is when the stats were computed
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// donorStats returns the donor stats of the row, nil unless computed within
+// donorStatsMaxAge of now
+func (c *CharacterRow) donorStats(now time.Time) *DonorStats {
+	if !c.StatsComputedAt.Valid ||
+		now.Sub(c.StatsComputedAt.Time) > donorStatsMaxAge {
+		return nil
+	}
+
+	stats := &DonorStats{
+		AverageDonation:   c.AverageDonation,
+		AverageDonation30: c.AverageDonation30,
+		UniqueDonors:      c.UniqueDonors,
+		ComputedAt:        c.StatsComputedAt.Time.UTC(),
+	}
+	if c.UniqueDonors > 0 {
+		stats.RepeatDonorPercent = 100 * float64(c.RepeatDonors) /
+			float64(c.UniqueDonors)
+	}
+	return stats
+}
+
+// UpdateDonorStats computes the donor stats of every character from the
+// stored donations
+func UpdateDonorStats(ctx context.Context, now time.Time) error {
+	return executeNamed(ctx, cx.StmtUpdateDonorStats, map[string]interface{}{
+		"now": now.UTC(),
+	})
+}
@@ -0,0 +1,45 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpdateDonorStatsDB(t *testing.T) {
+	ctx := testDB(t)
+
+	countDonations(t, ctx, testDonation(1, 0, 1000), testDonation(2, 1, 3000))
+	// as left by an anonymized purge
+	anonymous := testDonation(3, 2, 5000)
+	anonymous.Donator = 0
+	loadDonations(t, ctx, anonymous)
+
+	if err := UpdateDonorStats(ctx, time.Now()); err != nil {
+		t.Fatalf("failed to update donor stats: %+v", err)
+	}
+
+	stats := getTestCharacter(t, ctx, 1).DonorStats
+	if stats == nil || stats.AverageDonation != NewISK(3000) ||
+		stats.AverageDonation30 != stats.AverageDonation ||
+		stats.UniqueDonors != 1 || stats.RepeatDonorPercent != 100 {
+		t.Errorf("unexpected recipient stats %+v", stats)
+	}
+
+	// the donator received nothing
+	stats = getTestCharacter(t, ctx, 2).DonorStats
+	if stats == nil || stats.AverageDonation != 0 || stats.UniqueDonors != 0 {
+		t.Errorf("unexpected donator stats %+v", stats)
+	}
+
+	// stale stats are left out
+	stale := time.Now().Add(-2 * donorStatsMaxAge)
+	if err := UpdateDonorStats(ctx, stale); err != nil {
+		t.Fatalf("failed to update donor stats: %+v", err)
+	}
+	if stats := getTestCharacter(t, ctx, 1).DonorStats; stats != nil {
+		t.Errorf("expected stale stats left out, received %+v", stats)
+	}
+}
@@ -0,0 +1,32 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestDonorStats(t *testing.T) {
+	now := time.Date(2018, 12, 25, 20, 0, 0, 0, time.UTC)
+	row := &CharacterRow{
+		ID:                1,
+		AverageDonation:   NewISK(1500),
+		AverageDonation30: NewISK(2000),
+		UniqueDonors:      4,
+		RepeatDonors:      1,
+		StatsComputedAt:   sql.NullTime{Time: now.Add(-time.Hour), Valid: true},
+	}
+
+	stats := row.donorStats(now)
+	if stats == nil || stats.AverageDonation != NewISK(1500) ||
+		stats.UniqueDonors != 4 || stats.RepeatDonorPercent != 25 {
+		t.Errorf("unexpected donor stats %+v", stats)
+	}
+
+	if stats := row.donorStats(now.Add(donorStatsMaxAge)); stats != nil {
+		t.Errorf("expected stale stats left out, received %+v", stats)
+	}
+	if stats := (&CharacterRow{ID: 1}).donorStats(now); stats != nil {
+		t.Errorf("expected no stats before they are computed, received %+v", stats)
+	}
+}
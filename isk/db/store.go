@@ -0,0 +1,250 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// ErrNotReady is returned by Ready before every statement is prepared
+var ErrNotReady = errors.New("statements are not prepared")
+
+// PgStore is a connection to the postgres db with our prepared statements.
+// Construct it once in main with NewPgStore, and pass it down in the context
+// with Context. The functions of this package use the store of their context
+type PgStore struct {
+	DB         *sqlx.DB
+	Statements map[cx.Key]*sqlx.NamedStmt
+
+	// tx is the transaction of a store bound by WithTx
+	tx *sqlx.Tx
+}
+
+// NewPgStore connects to the postgres db and prepares our statements. Every
+// statement is prepared against the live schema before anything is served,
+// exiting with the name of any which is invalid
+func NewPgStore(ctx context.Context) *PgStore {
+	store, err := NewStore(ctx, Connect(ctx))
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	return store
+}
+
+// NewStore prepares our statements on the connection, with the options of
+// the context
+func NewStore(ctx context.Context, db *sqlx.DB) (*PgStore, error) {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	statements, err := prepareStatements(db, queries(opts))
+	if err != nil {
+		return nil, err
+	}
+	return &PgStore{DB: db, Statements: statements}, nil
+}
+
+// Context returns the context with the store added
+func (s *PgStore) Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cx.Store, s)
+}
+
+// Close closes the connection to the db
+func (s *PgStore) Close() error {
+	return s.DB.Close()
+}
+
+// storeFrom returns the store of the context. Contexts still holding the
+// connection and statements as separate values are given a store of them
+func storeFrom(ctx context.Context) *PgStore {
+	if store, ok := ctx.Value(cx.Store).(*PgStore); ok {
+		return store
+	}
+	db, _ := ctx.Value(cx.DB).(*sqlx.DB)
+	statements, _ := ctx.Value(cx.Statements).(map[cx.Key]*sqlx.NamedStmt)
+	return &PgStore{DB: db, Statements: statements}
+}
+
+// Ready returns an error unless every statement is prepared and the db
+// responds to a ping
+func (s *PgStore) Ready(ctx context.Context) error {
+	opts, _ := ctx.Value(cx.Opts).(*cx.Options)
+	if opts == nil || s.DB == nil {
+		return ErrNotReady
+	}
+	for key := range queries(opts) {
+		if s.Statements[key] == nil {
+			return fmt.Errorf("%w: missing %s", ErrNotReady, key)
+		}
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+	return s.DB.PingContext(pingCtx)
+}
+
+// WithTx runs fn with the context of a store bound to a transaction, which
+// is committed once fn returns, or rolled back if it errors. Everything run
+// with that context joins the transaction, nested WithTx calls included
+func (s *PgStore) WithTx(
+	ctx context.Context,
+	fn func(ctx context.Context) error,
+) error {
+	if s.tx != nil {
+		return fn(s.Context(ctx))
+	}
+	return s.Transaction(ctx, func(tx *sqlx.Tx) error {
+		bound := &PgStore{DB: s.DB, Statements: s.Statements, tx: tx}
+		return fn(bound.Context(ctx))
+	})
+}
+
+// Statement returns the prepared statement, bound to the transaction of the
+// store if it has one, logging its use when debugging
+func (s *PgStore) Statement(ctx context.Context, stmt cx.Key) *sqlx.NamedStmt {
+	logQuery(ctx, stmt)
+	if s.tx != nil {
+		return s.tx.NamedStmtContext(ctx, s.Statements[stmt])
+	}
+	return s.Statements[stmt]
+}
+
+// replicaFor returns the replica the read should use, reads within a
+// transaction never do
+func (s *PgStore) replicaFor(ctx context.Context) *Replica {
+	if s.tx != nil {
+		return nil
+	}
+	return replicaFor(ctx)
+}
+
+// Query runs the prepared statement, on the replica for reads marked by
+// replicated
+func (s *PgStore) Query(
+	ctx context.Context,
+	stmt cx.Key,
+	values map[string]interface{},
+) (*sqlx.Rows, error) {
+	defer timeQuery(ctx, stmt)()
+	if replica := s.replicaFor(ctx); replica != nil {
+		rows, err := replica.query(ctx, stmt, values)
+		if !replica.fallback(err) {
+			return rows, err
+		}
+	}
+	return s.Statement(ctx, stmt).Queryx(values)
+}
+
+// Get scans the single row of the prepared statement into dest, on the
+// replica for reads marked by replicated
+func (s *PgStore) Get(
+	ctx context.Context,
+	stmt cx.Key,
+	dest interface{},
+	values map[string]interface{},
+) error {
+	defer timeQuery(ctx, stmt)()
+	if replica := s.replicaFor(ctx); replica != nil {
+		err := replica.get(ctx, stmt, dest, values)
+		if !replica.fallback(err) {
+			return err
+		}
+	}
+	return s.Statement(ctx, stmt).Get(dest, values)
+}
+
+// QueryIn runs the query with any slice arguments expanded by sqlx.In. These
+// queries differ by argument count, so are not prepared
+func (s *PgStore) QueryIn(
+	ctx context.Context,
+	query string,
+	args ...interface{},
+) (*sqlx.Rows, error) {
+	expanded, inArgs, err := sqlx.In(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	logQuery(ctx, expanded)
+	if replica := s.replicaFor(ctx); replica != nil {
+		rows, err := replica.queryIn(ctx, expanded, inArgs)
+		if !replica.fallback(err) {
+			return rows, err
+		}
+	}
+	if s.tx != nil {
+		return s.tx.QueryxContext(ctx, s.DB.Rebind(expanded), inArgs...)
+	}
+	return s.DB.Queryx(s.DB.Rebind(expanded), inArgs...)
+}
+
+// ExecCount runs the prepared statement, returning the rows affected
+func (s *PgStore) ExecCount(
+	ctx context.Context,
+	stmt cx.Key,
+	values map[string]interface{},
+) (int64, error) {
+	defer timeQuery(ctx, stmt)()
+	res, err := s.Statement(ctx, stmt).Exec(values)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Transaction runs fn in a transaction, which is rolled back if fn errors.
+// A store bound by WithTx runs fn in its transaction
+func (s *PgStore) Transaction(
+	ctx context.Context,
+	fn func(tx *sqlx.Tx) error,
+) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			cx.Logf(ctx, "failed to roll back transaction: %+v", rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// QueryTx queries the prepared statement within the transaction
+func (s *PgStore) QueryTx(
+	ctx context.Context,
+	tx *sqlx.Tx,
+	stmt cx.Key,
+	values map[string]interface{},
+) (*sqlx.Rows, error) {
+	defer timeQuery(ctx, stmt)()
+	logQuery(ctx, stmt)
+	return tx.NamedStmtContext(ctx, s.Statements[stmt]).Queryx(values)
+}
+
+// ExecTxCount runs the prepared statement in the transaction, returning the
+// rows affected
+func (s *PgStore) ExecTxCount(
+	ctx context.Context,
+	tx *sqlx.Tx,
+	stmt cx.Key,
+	values map[string]interface{},
+) (int64, error) {
+	defer timeQuery(ctx, stmt)()
+	logQuery(ctx, stmt)
+	res, err := tx.NamedStmtContext(ctx, s.Statements[stmt]).Exec(values)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
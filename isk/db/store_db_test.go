@@ -0,0 +1,64 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWithTxDB(t *testing.T) {
+	ctx := testDB(t)
+	errFailed := errors.New("failed")
+
+	err := WithTx(ctx, func(ctx context.Context) error {
+		if err := SaveDonation(ctx, testDonation(1, 0, 1000)); err != nil {
+			return err
+		}
+		// nested use joins the outer transaction
+		if err := WithTx(ctx, func(ctx context.Context) error {
+			return SaveDonation(ctx, testDonation(2, 1, 500))
+		}); err != nil {
+			return err
+		}
+
+		// the totals stay locked until the transaction ends
+		unlock, err := LockTotals(ctx)
+		if err != nil {
+			return err
+		}
+		unlock()
+		if _, locked, err := advisoryLock(ctx, lockTotals, true); err != nil {
+			return err
+		} else if locked {
+			t.Errorf("expected the totals locked by the transaction")
+		}
+		return errFailed
+	})
+	if err != errFailed {
+		t.Fatalf("expected the error of fn, received %+v", err)
+	}
+
+	for _, id := range []int64{1, 2} {
+		if _, err := GetDonation(ctx, id); err != ErrDonationNotFound {
+			t.Errorf("expected donation %d rolled back, received %+v", id, err)
+		}
+	}
+
+	if err := WithTx(ctx, func(ctx context.Context) error {
+		return SaveDonation(ctx, testDonation(1, 0, 1000))
+	}); err != nil {
+		t.Fatalf("failed to save in a transaction: %+v", err)
+	}
+	if _, err := GetDonation(ctx, 1); err != nil {
+		t.Errorf("expected the donation committed, received %+v", err)
+	}
+
+	unlock, locked, err := advisoryLock(ctx, lockTotals, true)
+	if err != nil || !locked {
+		t.Fatalf("expected the totals unlocked after commit: %+v", err)
+	}
+	unlock()
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestStoreFrom(t *testing.T) {
+	store := &PgStore{DB: &sqlx.DB{}}
+	ctx := store.Context(context.Background())
+	if storeFrom(ctx) != store {
+		t.Errorf("expected the store of the context")
+	}
+
+	// opening again keeps the store constructed in main
+	if storeFrom(Open(ctx)) != store {
+		t.Errorf("expected Open to keep the store of the context")
+	}
+
+	db := &sqlx.DB{}
+	statements := map[cx.Key]*sqlx.NamedStmt{}
+	ctx = context.WithValue(context.Background(), cx.DB, db)
+	ctx = context.WithValue(ctx, cx.Statements, statements)
+	if legacy := storeFrom(ctx); legacy.DB != db || legacy.Statements == nil {
+		t.Errorf("expected a store of the context values, received %+v", legacy)
+	}
+}
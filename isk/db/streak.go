@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// eveDay returns the start of the day (in EVE time) holding t
+func eveDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// extendStreak counts the day of a donation received in the streaks of the
+// character. Donations before its last streak day, or from before yesterday
+// as backfilled ones are, mark the streaks to be recalculated instead
+func (c *CharacterRow) extendStreak(at, now time.Time) {
+	day := eveDay(at)
+	last := eveDay(c.StreakDay.Time)
+	if day.Before(eveDay(now).AddDate(0, 0, -1)) ||
+		c.StreakDay.Valid && day.Before(last) {
+		c.streakStale = true
+		return
+	}
+
+	if c.StreakDay.Valid && day.Equal(last) {
+		// another donation of the same day
+		return
+	}
+	if c.StreakDay.Valid && c.StreakCurrent > 0 &&
+		day.Equal(last.AddDate(0, 0, 1)) {
+		c.StreakCurrent++
+	} else {
+		c.StreakCurrent = 1
+	}
+	c.StreakDay = pq.NullTime{Time: day, Valid: true}
+	if c.StreakCurrent > c.StreakBest {
+		c.StreakBest = c.StreakCurrent
+	}
+}
+
+// streakValues are the values of the streak statements run at now
+func streakValues(charID int32, now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"character_id": charID,
+		"today":        eveDay(now),
+	}
+}
+
+// recalculateStreaks sets the streaks of the character, or of every
+// character if 0, from the stored donations
+func recalculateStreaks(ctx context.Context, charID int32) error {
+	return executeNamed(
+		ctx,
+		cx.StmtRecalculateStreaks,
+		streakValues(charID, time.Now()),
+	)
+}
+
+// recalculateStreaksTx sets the streaks of the character from the stored
+// donations within the transaction
+func recalculateStreaksTx(ctx context.Context, tx *sqlx.Tx, charID int32) error {
+	return executeNamedTx(
+		ctx,
+		tx,
+		cx.StmtRecalculateStreaks,
+		streakValues(charID, time.Now()),
+	)
+}
+
+// recalculateStaleStreaks recalculates the streaks of the characters which
+// were marked stale while counting their donations
+func recalculateStaleStreaks(
+	ctx context.Context,
+	characters ...[]*CharacterRow,
+) error {
+	for _, chars := range characters {
+		for _, char := range chars {
+			if !char.streakStale {
+				continue
+			}
+			if err := recalculateStreaks(ctx, char.ID); err != nil {
+				return err
+			}
+			char.streakStale = false
+		}
+	}
+	return nil
+}
+
+// ExpireStreaks zeroes the current streaks of the characters who received no
+// donation yesterday or today (EVE time), returning how many were
+func ExpireStreaks(ctx context.Context, now time.Time) (int64, error) {
+	return executeNamedCount(ctx, cx.StmtExpireStreaks, streakValues(0, now))
+}
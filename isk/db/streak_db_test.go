@@ -0,0 +1,61 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreaksDB(t *testing.T) {
+	ctx := testDB(t)
+
+	// backfilled days, twice on the first
+	countDonations(
+		t,
+		ctx,
+		testDonation(1, 0, 1000),
+		testDonation(2, 1, 1000),
+		testDonation(3, 24, 1000),
+		testDonation(4, 96, 1000),
+	)
+	recipient := getTestCharacter(t, ctx, 1)
+	if recipient.StreakCurrent != 0 || recipient.StreakBest != 2 {
+		t.Errorf("unexpected backfilled streaks %+v", recipient)
+	}
+
+	// then the donations of yesterday and today, newest first as polled
+	now := time.Now().UTC()
+	today, yesterday := testDonation(5, 0, 500), testDonation(6, 0, 500)
+	today.Timestamp, yesterday.Timestamp = now, now.AddDate(0, 0, -1)
+	countDonations(t, ctx, today, yesterday)
+	recipient = getTestCharacter(t, ctx, 1)
+	if recipient.StreakCurrent != 2 || recipient.StreakBest != 2 {
+		t.Errorf("unexpected current streaks %+v", recipient)
+	}
+
+	// voiding yesterday's breaks the streak
+	c := &Correction{Admin: "admin", Reason: "test"}
+	if _, err := VoidDonation(ctx, 6, c); err != nil {
+		t.Fatalf("failed to void donation: %+v", err)
+	}
+	recipient = getTestCharacter(t, ctx, 1)
+	if recipient.StreakCurrent != 1 || recipient.StreakBest != 2 {
+		t.Errorf("unexpected streaks after voiding %+v", recipient)
+	}
+
+	// until a day passes without a donation
+	if expired, err := ExpireStreaks(ctx, now.AddDate(0, 0, 1)); err != nil ||
+		expired != 0 {
+		t.Errorf("expected no streak expired, received %d (%v)", expired, err)
+	}
+	if expired, err := ExpireStreaks(ctx, now.AddDate(0, 0, 2)); err != nil ||
+		expired != 1 {
+		t.Errorf("expected the streak expired, received %d (%v)", expired, err)
+	}
+	recipient = getTestCharacter(t, ctx, 1)
+	if recipient.StreakCurrent != 0 || recipient.StreakBest != 2 {
+		t.Errorf("unexpected expired streaks %+v", recipient)
+	}
+}
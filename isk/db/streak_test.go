@@ -0,0 +1,60 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestEveDay(t *testing.T) {
+	// still the 24th in EVE time
+	zone := time.FixedZone("UTC+10", 10*60*60)
+	local := time.Date(2018, 12, 25, 8, 30, 0, 0, zone)
+	expected := time.Date(2018, 12, 24, 0, 0, 0, 0, time.UTC)
+	if day := eveDay(local); !day.Equal(expected) || day.Location() != time.UTC {
+		t.Errorf("expected %s, received %s", expected, day)
+	}
+}
+
+func TestExtendStreak(t *testing.T) {
+	now := time.Date(2018, 12, 25, 20, 0, 0, 0, time.UTC)
+	yesterday := now.AddDate(0, 0, -1)
+	char := &CharacterRow{ID: 1}
+
+	char.extendStreak(yesterday, now)
+	if char.StreakCurrent != 1 || char.StreakBest != 1 || char.streakStale {
+		t.Fatalf("first donation did not start a streak: %+v", char)
+	}
+
+	// once a day, however many are received
+	char.extendStreak(now.Add(-time.Hour), now)
+	char.extendStreak(now, now)
+	if char.StreakCurrent != 2 || char.StreakBest != 2 ||
+		!char.StreakDay.Time.Equal(eveDay(now)) {
+		t.Errorf("expected a streak of 2 days, received %+v", char)
+	}
+
+	// a day missed starts again, keeping the best
+	char.StreakCurrent, char.StreakDay.Time = 2, eveDay(now).AddDate(0, 0, -3)
+	char.extendStreak(now, now)
+	if char.StreakCurrent != 1 || char.StreakBest != 2 {
+		t.Errorf("expected a new streak, received %+v", char)
+	}
+
+	for name, at := range map[string]time.Time{
+		"backfilled":        now.AddDate(0, 0, -10),
+		"before the latest": yesterday,
+	} {
+		char := &CharacterRow{
+			ID:            1,
+			StreakCurrent: 3,
+			StreakBest:    3,
+			StreakDay:     pq.NullTime{Time: eveDay(now), Valid: true},
+		}
+		if char.extendStreak(at, now); !char.streakStale ||
+			char.StreakCurrent != 3 {
+			t.Errorf("%s: expected a stale streak, received %+v", name, char)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// Supporter is a donator with their totals given to one character
+type Supporter struct {
+	ID    int32     `db:"donator" json:"id"`
+	Name  string    `db:"-" json:"name,omitempty"`
+	Count int64     `db:"count" json:"count"`
+	ISK   ISK       `db:"isk" json:"isk"`
+	First time.Time `db:"first" json:"first"`
+	Last  time.Time `db:"last" json:"last"`
+}
+
+// MarshalJSON implementation to write our timestamps in UTC
+func (s *Supporter) MarshalJSON() ([]byte, error) {
+	type Alias Supporter
+	return json.Marshal(&struct {
+		*Alias
+		First string `json:"first"`
+		Last  string `json:"last"`
+	}{
+		Alias: (*Alias)(s),
+		First: s.First.UTC().Format(time.RFC3339),
+		Last:  s.Last.UTC().Format(time.RFC3339),
+	})
+}
+
+// GetSupporters returns a page of the character's donators, ranked by the
+// ISK of their donations and valued, accepted contracts
+func GetSupporters(
+	ctx context.Context,
+	charID int32,
+	limit, offset int,
+) ([]*Supporter, error) {
+	ctx = replicated(ctx)
+	rows, err := queryNamedResult(ctx, cx.StmtSupporters, map[string]interface{}{
+		"character_id": charID,
+		"limit":        limit,
+		"offset":       offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &Supporter{} })
+	if err != nil {
+		return nil, err
+	}
+
+	supporters := []*Supporter{}
+	ids := []int32{}
+	for _, i := range res {
+		supporter := i.(*Supporter)
+		supporters = append(supporters, supporter)
+		ids = append(ids, supporter.ID)
+	}
+
+	names, err := getNamesIn(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, supporter := range supporters {
+		supporter.Name = names[supporter.ID]
+	}
+
+	return supporters, nil
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// Buckets the time series can be grouped by, as named by date_trunc
+const (
+	BucketDay   = "day"
+	BucketWeek  = "week"
+	BucketMonth = "month"
+)
+
+// Point is the ISK received in one time bucket
+type Point struct {
+	Date  time.Time `db:"bucket" json:"-"`
+	Count int64     `db:"count" json:"count"`
+	ISK   ISK       `db:"isk" json:"isk"`
+}
+
+// MarshalJSON implementation to write the bucket as a plain date
+func (p *Point) MarshalJSON() ([]byte, error) {
+	type Alias Point
+	return json.Marshal(&struct {
+		Date string `json:"date"`
+		*Alias
+	}{
+		Date:  p.Date.Format("2006-01-02"),
+		Alias: (*Alias)(p),
+	})
+}
+
+// ArchiveAge is the youngest archived donations may be, whichever
+// -archive-after the worker runs with
+const ArchiveAge = time.Duration(MinArchiveAge) * 24 * time.Hour
+
+// ReceivedSeries returns the donations and accepted contracts received by the
+// character since the time, with every bucket up to now present. Series from
+// before ArchiveAge ago include the archived donations
+func ReceivedSeries(
+	ctx context.Context,
+	charID int32,
+	since time.Time,
+	bucket string,
+) ([]*Point, error) {
+	ctx = replicated(ctx)
+	since = truncate(since.UTC(), bucket)
+
+	key := cx.StmtReceivedSeries
+	if since.Before(time.Now().UTC().Add(-ArchiveAge)) {
+		key = cx.StmtArchivedSeries
+	}
+
+	rows, err := queryNamedResult(ctx, key, map[string]interface{}{
+		"character_id": charID,
+		"since":        since,
+		"bucket":       bucket,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &Point{} })
+	if err != nil {
+		return nil, err
+	}
+
+	points := []*Point{}
+	for _, i := range res {
+		points = append(points, i.(*Point))
+	}
+
+	return fillBuckets(points, since, time.Now().UTC(), bucket), nil
+}
+
+// truncate returns the start of the bucket holding t, as date_trunc does
+func truncate(t time.Time, bucket string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch bucket {
+	case BucketWeek:
+		// weeks start on monday
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case BucketMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	default:
+		return day
+	}
+}
+
+// next returns the start of the bucket after the one starting at t
+func next(t time.Time, bucket string) time.Time {
+	switch bucket {
+	case BucketWeek:
+		return t.AddDate(0, 0, 7)
+	case BucketMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// fillBuckets returns a point for every bucket from since until, using the
+// matching point when there is one
+func fillBuckets(points []*Point, since, until time.Time, bucket string) []*Point {
+	found := map[time.Time]*Point{}
+	for _, point := range points {
+		found[point.Date.UTC()] = point
+	}
+
+	filled := []*Point{}
+	for t := truncate(since, bucket); !t.After(until); t = next(t, bucket) {
+		point, ok := found[t]
+		if !ok {
+			point = &Point{Date: t}
+		}
+		point.Date = t
+		filled = append(filled, point)
+	}
+	return filled
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestTruncate(t *testing.T) {
+	at := time.Date(2018, 11, 22, 15, 4, 5, 0, time.UTC) // a thursday
+
+	for bucket, expected := range map[string]string{
+		BucketDay:   "2018-11-22",
+		BucketWeek:  "2018-11-19",
+		BucketMonth: "2018-11-01",
+	} {
+		if received := truncate(at, bucket); !received.Equal(date(expected)) {
+			t.Errorf("%s: received %s, expected %s", bucket, received, expected)
+		}
+	}
+
+	sunday := date("2018-11-25")
+	if received := truncate(sunday, BucketWeek); !received.Equal(date("2018-11-19")) {
+		t.Errorf("sunday is not in the week starting monday: %s", received)
+	}
+}
+
+func TestFillBuckets(t *testing.T) {
+	points := []*Point{
+		{Date: date("2018-11-20"), Count: 2, ISK: 150},
+		{Date: date("2018-11-22"), Count: 1, ISK: 100},
+	}
+
+	filled := fillBuckets(
+		points,
+		date("2018-11-19"),
+		date("2018-11-22").Add(12*time.Hour),
+		BucketDay,
+	)
+	if len(filled) != 4 {
+		t.Fatalf("expected 4 buckets, received %d", len(filled))
+	}
+	for i, expected := range []int64{0, 2, 0, 1} {
+		if filled[i].Count != expected {
+			t.Errorf("bucket %d: count %d, expected %d", i, filled[i].Count, expected)
+		}
+	}
+
+	months := fillBuckets(nil, date("2018-01-31"), date("2018-04-02"), BucketMonth)
+	if len(months) != 4 || !months[1].Date.Equal(date("2018-02-01")) {
+		t.Errorf("invalid month buckets: %+v", months)
+	}
+
+	encoded, err := json.Marshal(filled[1])
+	if err != nil {
+		t.Fatalf("failed to marshal point: %+v", err)
+	}
+	if string(encoded) != `{"date":"2018-11-20","count":2,"isk":1.50}` {
+		t.Errorf("unexpected point JSON: %s", encoded)
+	}
+}
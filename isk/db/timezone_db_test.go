@@ -0,0 +1,82 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// inTimeZone sets the session time zone of the connections to the test
+// database, as on a server configured with another zone
+func inTimeZone(t *testing.T, ctx context.Context, zone string) {
+	db := storeFrom(ctx).DB
+	name := ""
+	if err := db.Get(&name, "SELECT current_database()"); err != nil {
+		t.Fatalf("failed to get the database name: %+v", err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(
+		"ALTER DATABASE %s SET TIME ZONE '%s'",
+		name,
+		zone,
+	)); err != nil {
+		t.Fatalf("failed to set the time zone: %+v", err)
+	}
+
+	// the idle connections were opened in UTC, new ones use the zone
+	db.SetMaxIdleConns(0)
+	db.SetMaxIdleConns(2)
+
+	session := ""
+	if err := db.Get(&session, "SHOW TIME ZONE"); err != nil {
+		t.Fatalf("failed to show the time zone: %+v", err)
+	} else if session != zone {
+		t.Fatalf("expected the session in %s, received %s", zone, session)
+	}
+}
+
+func TestTimeZoneRoundTripDB(t *testing.T) {
+	ctx := testDB(t)
+	inTimeZone(t, ctx, "Australia/Sydney")
+
+	// the same instants as testAt, given in another zone
+	local := testAt.In(time.FixedZone("AEDT", 11*60*60))
+
+	donation := testDonation(1, 0, 1000)
+	donation.Timestamp = local
+	countDonations(t, ctx, donation)
+
+	contract := testSharedContract("finished")
+	contract.Issued = local
+	contract.Expires = local.Add(24 * time.Hour)
+	loadContracts(t, ctx, contract)
+
+	storedDonation, err := GetDonation(ctx, donation.ID)
+	if err != nil {
+		t.Fatalf("failed to get donation: %+v", err)
+	}
+	storedContract, err := GetContract(ctx, contract.ID)
+	if err != nil {
+		t.Fatalf("failed to get contract: %+v", err)
+	}
+	recipient := getTestCharacter(t, ctx, 1)
+
+	for name, tc := range map[string]struct {
+		stored, expected time.Time
+	}{
+		"donation timestamp": {storedDonation.Timestamp, testAt},
+		"contract issued":    {storedContract.Issued, testAt},
+		"contract expires":   {storedContract.Expires, testAt.Add(24 * time.Hour)},
+		"last received":      {recipient.LastReceived, testAt},
+	} {
+		if !tc.stored.Equal(tc.expected) {
+			t.Errorf("%s: expected %s, received %s", name, tc.expected, tc.stored)
+		}
+		if tc.stored.Location() != time.UTC {
+			t.Errorf("%s: expected UTC, received %s", name, tc.stored.Location())
+		}
+	}
+}
@@ -2,60 +2,71 @@ package db
 
 import (
 	"context"
-	"log"
 
 	"github.com/a-tal/esi-isk/isk/cx"
 	"github.com/jmoiron/sqlx"
 )
 
-// GetTopRecipients returns the top character IDs and isk values
-func GetTopRecipients(ctx context.Context) ([]*Character, error) {
+// GetTopRecipients returns the top character IDs and isk values, by their
+// totals received over the window
+func GetTopRecipients(ctx context.Context, window Window) ([]*Character, error) {
+	ctx = replicated(ctx)
 	return getTop(
 		ctx,
 		cx.StmtTopReceived,
+		window,
 		func(c *CharacterRow) *Character {
-			if c.ReceivedISK <= 0 {
+			isk := *c.totals(window).receivedISK
+			if isk <= 0 {
 				return nil
 			}
-			char := &Character{ID: c.ID, ReceivedISK: c.ReceivedISK}
-			addValidTime(c, char)
-			return char
+			row := &CharacterRow{
+				ID:           c.ID,
+				ReceivedISK:  c.ReceivedISK,
+				LastDonated:  c.LastDonated,
+				LastReceived: c.LastReceived,
+				Deleted:      c.Deleted,
+			}
+			*row.totals(window).receivedISK = isk
+			return row.toCharacter()
 		},
 	)
 }
 
-// GetTopDonators returns the top character IDs and isk values
-func GetTopDonators(ctx context.Context) ([]*Character, error) {
+// GetTopDonators returns the top character IDs and isk values, by their
+// totals donated over the window
+func GetTopDonators(ctx context.Context, window Window) ([]*Character, error) {
+	ctx = replicated(ctx)
 	return getTop(
 		ctx,
 		cx.StmtTopDonated,
+		window,
 		func(c *CharacterRow) *Character {
-			if c.DonatedISK <= 0 {
+			isk := *c.totals(window).donatedISK
+			if isk <= 0 {
 				return nil
 			}
-			char := &Character{ID: c.ID, DonatedISK: c.DonatedISK}
-			addValidTime(c, char)
-			return char
+			row := &CharacterRow{
+				ID:           c.ID,
+				DonatedISK:   c.DonatedISK,
+				LastDonated:  c.LastDonated,
+				LastReceived: c.LastReceived,
+				Deleted:      c.Deleted,
+			}
+			*row.totals(window).donatedISK = isk
+			return row.toCharacter()
 		},
 	)
 }
 
-func addValidTime(c *CharacterRow, char *Character) {
-	if c.LastDonated.Valid {
-		char.LastDonated = c.LastDonated.Time
-	}
-	if c.LastReceived.Valid {
-		char.LastReceived = c.LastReceived.Time
-	}
-}
-
 // getTop is a DRY helper for getting top donators and recipients
 func getTop(
 	ctx context.Context,
 	key cx.Key,
+	window Window,
 	transform func(c *CharacterRow) *Character,
 ) ([]*Character, error) {
-	chars, err := queryCharISK(ctx, key)
+	chars, err := queryCharISK(ctx, key, window)
 	if err != nil {
 		return nil, err
 	}
@@ -68,27 +79,32 @@ func getTop(
 		}
 		name, err := GetName(ctx, char.ID)
 		if err != nil {
-			log.Printf("failed to lookup name for: %d", char.ID)
+			cx.Logf(ctx, "failed to lookup name for: %d", char.ID)
 		} else {
 			char.Name = name
 		}
+		char.setImages(ctx)
 		characters = append(characters, char)
 	}
 
 	return characters, nil
 }
 
-func queryCharISK(ctx context.Context, q cx.Key) ([]*CharacterRow, error) {
-	statements := ctx.Value(cx.Statements).(map[cx.Key]*sqlx.NamedStmt)
-
-	res, err := statements[q].Queryx(map[string]interface{}{})
+func queryCharISK(
+	ctx context.Context,
+	q cx.Key,
+	window Window,
+) ([]*CharacterRow, error) {
+	res, err := queryNamedResult(ctx, q, map[string]interface{}{
+		"window": int(window),
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	defer func() {
 		if err := res.Close(); err != nil {
-			log.Printf("failed to close results: %+v", err)
+			cx.Logf(ctx, "failed to close results: %+v", err)
 		}
 	}()
 
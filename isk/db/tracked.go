@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// Filters of the tracked characters listing
+const (
+	FilterRevoked = "revoked"
+	FilterHidden  = "hidden"
+	FilterDeleted = "deleted"
+	FilterStale   = "stale"
+	FilterBanned  = "banned"
+)
+
+// TrackedQuery selects a page of the characters with a stored token
+type TrackedQuery struct {
+	// Filter is one of the Filter constants, or empty for every character
+	Filter string
+
+	// StaleBefore is the time stale characters last polled successfully
+	// before, or never did. Revoked and deleted characters are not stale
+	StaleBefore time.Time
+
+	// Ascending lists the least recently active characters first
+	Ascending bool
+
+	// After is the cursor of the previous page, nil for the first
+	After *Cursor
+
+	// Limit is the most characters read
+	Limit int
+}
+
+// TrackedCharacter is a character with a stored token, and how polling it
+// is going
+type TrackedCharacter struct {
+	CharacterID  int32      `db:"character_id" json:"character_id"`
+	Name         string     `db:"name" json:"name"`
+	Revoked      bool       `db:"revoked" json:"revoked"`
+	Hidden       bool       `db:"hidden" json:"hidden"`
+	Deleted      bool       `db:"deleted" json:"deleted"`
+	Banned       bool       `db:"banned" json:"banned"`
+	LastActivity *time.Time `db:"last_activity" json:"last_activity"`
+	LastPollAt   *time.Time `db:"last_success" json:"last_poll_at"`
+	LastError    *time.Time `db:"last_error" json:"last_error"`
+	NextPollAt   *time.Time `db:"next_poll_at" json:"next_poll_at"`
+}
+
+// Cursor returns the cursor of the page after the character
+func (c *TrackedCharacter) Cursor() *Cursor {
+	after := &Cursor{
+		Timestamp: time.Unix(0, 0).UTC(),
+		ID:        int64(c.CharacterID),
+	}
+	if c.LastActivity != nil {
+		after.Timestamp = *c.LastActivity
+	}
+	return after
+}
+
+// IsTrackedFilter is true for the filters of TrackedQuery, empty included
+func IsTrackedFilter(filter string) bool {
+	switch filter {
+	case "", FilterRevoked, FilterHidden, FilterDeleted, FilterStale,
+		FilterBanned:
+		return true
+	}
+	return false
+}
+
+// args are the named args of the tracked characters statements
+func (q *TrackedQuery) args() map[string]interface{} {
+	after := &Cursor{Timestamp: time.Unix(0, 0).UTC()}
+	if q.After != nil {
+		after = q.After
+	}
+	return map[string]interface{}{
+		"all":          q.Filter == "",
+		"revoked":      q.Filter == FilterRevoked,
+		"hidden":       q.Filter == FilterHidden,
+		"deleted":      q.Filter == FilterDeleted,
+		"stale":        q.Filter == FilterStale,
+		"banned":       q.Filter == FilterBanned,
+		"stale_before": q.StaleBefore.UTC(),
+		"first":        q.After == nil,
+		"after_time":   after.Timestamp,
+		"after_id":     after.ID,
+		"limit":        q.Limit,
+	}
+}
+
+// GetTrackedCharacters returns a page of the characters with a stored token
+// matching the query, ordered by their last activity
+func GetTrackedCharacters(
+	ctx context.Context,
+	q *TrackedQuery,
+) ([]*TrackedCharacter, error) {
+	stmt := cx.StmtTrackedCharacters
+	if q.Ascending {
+		stmt = cx.StmtTrackedCharactersAsc
+	}
+
+	rows, err := queryNamedResult(ctx, stmt, q.args())
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := []*TrackedCharacter{}
+	err = each(rows, func() interface{} { return &TrackedCharacter{} }, func(
+		i interface{},
+	) error {
+		tracked = append(tracked, i.(*TrackedCharacter))
+		return nil
+	})
+	return tracked, err
+}
@@ -0,0 +1,79 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrackedCharactersDB(t *testing.T) {
+	ctx := testDB(t)
+
+	loadCharacters(t, ctx, &CharacterRow{ID: 1}, &CharacterRow{ID: 2})
+	for _, charID := range []int32{1, 2, 3} {
+		if err := SaveUser(ctx, &User{
+			RefreshToken:  "token",
+			AccessToken:   "access",
+			OwnerHash:     "owner",
+			CharacterID:   charID,
+			AccessExpires: time.Now(),
+		}); err != nil {
+			t.Fatalf("failed to save user %d: %+v", charID, err)
+		}
+	}
+	if err := RevokeUser(ctx, 2); err != nil {
+		t.Fatalf("failed to revoke user: %+v", err)
+	}
+	if err := MarkDeleted(ctx, 1); err != nil {
+		t.Fatalf("failed to mark deleted: %+v", err)
+	}
+	if err := SavePollResult(ctx, 3, nil); err != nil {
+		t.Fatalf("failed to save poll result: %+v", err)
+	}
+
+	ids := func(q *TrackedQuery) []int32 {
+		tracked, err := GetTrackedCharacters(ctx, q)
+		if err != nil {
+			t.Fatalf("failed to get tracked characters: %+v", err)
+		}
+		charIDs := []int32{}
+		for _, c := range tracked {
+			charIDs = append(charIDs, c.CharacterID)
+		}
+		return charIDs
+	}
+
+	now := time.Now()
+	for name, tc := range map[string]struct {
+		q        *TrackedQuery
+		expected []int32
+	}{
+		"all":     {&TrackedQuery{Limit: 10}, []int32{3, 2, 1}},
+		"asc":     {&TrackedQuery{Limit: 10, Ascending: true}, []int32{1, 2, 3}},
+		"limit":   {&TrackedQuery{Limit: 1}, []int32{3}},
+		"revoked": {&TrackedQuery{Filter: FilterRevoked, Limit: 10}, []int32{2}},
+		"deleted": {&TrackedQuery{Filter: FilterDeleted, Limit: 10}, []int32{1}},
+		"hidden":  {&TrackedQuery{Filter: FilterHidden, Limit: 10}, []int32{}},
+		"stale": {&TrackedQuery{
+			Filter:      FilterStale,
+			StaleBefore: now.Add(time.Hour),
+			Limit:       10,
+		}, []int32{3}},
+		"fresh": {&TrackedQuery{
+			Filter:      FilterStale,
+			StaleBefore: now.Add(-time.Hour),
+			Limit:       10,
+		}, []int32{}},
+		"after": {&TrackedQuery{
+			After: (&TrackedCharacter{CharacterID: 3}).Cursor(),
+			Limit: 10,
+		}, []int32{2, 1}},
+	} {
+		if charIDs := ids(tc.q); !reflect.DeepEqual(charIDs, tc.expected) {
+			t.Errorf("%s: received %v, expected %v", name, charIDs, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// Transfer types
+const (
+	TransferDonation = "donation"
+	TransferContract = "contract"
+)
+
+// Transfer is a donation or contract between two characters
+type Transfer struct {
+	// Type is either a donation or a contract
+	Type string `db:"type" json:"type"`
+
+	// ID is the transaction or contract ID
+	ID int64 `db:"id" json:"id"`
+
+	// Donator who sent the ISK or contract
+	Donator int32 `db:"donator" json:"donator"`
+
+	// Receiver is who received it
+	Receiver int32 `db:"receiver" json:"receiver"`
+
+	// Timestamp of the donation, or when the contract was issued
+	Timestamp time.Time `db:"timestamp" json:"timestamp"`
+
+	// ISK donated, or the estimated value of the contract
+	ISK ISK `db:"isk" json:"isk"`
+
+	// Note of the donation, or the title of the contract
+	Note string `db:"note" json:"note,omitempty"`
+
+	// Status is the last seen ESI status of contracts
+	Status string `db:"status" json:"status,omitempty"`
+
+	// Accepted is always true for donations
+	Accepted bool `db:"accepted" json:"accepted"`
+
+	// Items of contracts
+	Items []*Item `db:"-" json:"items,omitempty"`
+}
+
+// GetTransfersBetween returns a page of the donations and contracts between
+// the characters in both directions, most recent first
+func GetTransfersBetween(
+	ctx context.Context,
+	charID, otherID int32,
+	limit, offset int,
+) ([]*Transfer, error) {
+	rows, err := queryNamedResult(ctx, cx.StmtTransfersBetween, map[string]interface{}{
+		"character_id": charID,
+		"other_id":     otherID,
+		"limit":        limit,
+		"offset":       offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &Transfer{} })
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := []*Transfer{}
+	for _, i := range res {
+		t := i.(*Transfer)
+		t.Timestamp = t.Timestamp.UTC()
+
+		if t.Type == TransferContract {
+			contract := &Contract{ID: int32(t.ID)}
+			if err := GetContractItems(ctx, Contracts{contract}); err != nil {
+				return nil, err
+			}
+			t.Items = contract.Items
+		}
+
+		transfers = append(transfers, t)
+	}
+
+	return transfers, nil
+}
+
+// TransferTotal is the number and ISK of accepted transfers one way
+type TransferTotal struct {
+	Donator int32 `db:"donator" json:"-"`
+	Count   int64 `db:"count" json:"count"`
+	ISK     ISK   `db:"isk" json:"isk"`
+}
+
+// GetTransferTotals returns the totals given by the character to the other,
+// and by the other to the character
+func GetTransferTotals(ctx context.Context, charID, otherID int32) (
+	given *TransferTotal,
+	received *TransferTotal,
+	err error,
+) {
+	ctx = replicated(ctx)
+	rows, err := queryNamedResult(ctx, cx.StmtTransferTotals, map[string]interface{}{
+		"character_id": charID,
+		"other_id":     otherID,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	given = &TransferTotal{Donator: charID}
+	received = &TransferTotal{Donator: otherID}
+	err = each(rows, func() interface{} { return &TransferTotal{} }, func(i interface{}) error {
+		total := i.(*TransferTotal)
+		if total.Donator == charID {
+			given = total
+		} else {
+			received = total
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return given, received, nil
+}
@@ -8,10 +8,13 @@ import (
 	"time"
 
 	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/tokens"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
-// User describes a mapping between a user and a character
+// User describes a mapping between a user and a character. The RefreshToken
+// is always stored encrypted, see tokens.Encrypt
 type User struct {
 	RefreshToken   string        `db:"refresh_token"`
 	AccessToken    string        `db:"access_token"`
@@ -21,19 +24,44 @@ type User struct {
 	LastContractID sql.NullInt64 `db:"last_contract_id"`
 	AccessExpires  time.Time     `db:"access_expires"`
 	LastProcessed  *time.Time    `db:"last_processed"`
+	LastSuccess    *time.Time    `db:"last_success"`
+	LastError      *time.Time    `db:"last_error"`
+	NextPollAt     *time.Time    `db:"next_poll_at"`
+	PollInterval   int32         `db:"poll_interval"`
+	ClaimedUntil   *time.Time    `db:"claimed_until"`
+	BackfilledAt   *time.Time    `db:"backfilled_at"`
+	Revoked        bool          `db:"revoked"`
+
+	// Scopes are those granted with the refresh token, empty if it was
+	// stored before they were kept
+	Scopes pq.StringArray `db:"scopes"`
 }
 
-// GetUsersToProcess returns all characters needing to be processed
-func GetUsersToProcess(ctx context.Context) ([]*User, error) {
+// errUserNotFound is returned by getUser when there is no such user
+var errUserNotFound = errors.New("User not found")
+
+// ErrUserClaimed is returned when another worker is polling the user, or the
+// user no longer exists
+var ErrUserClaimed = errors.New("User is claimed")
+
+// ClaimDuration is how long a worker may hold a user before others assume it
+// crashed. It covers every poll of a batch, not only the user's own
+const ClaimDuration = 1 * time.Hour
+
+// ClaimUsersToProcess claims and returns characters needing to be processed.
+// Users claimed by other workers are skipped, claims are released when the
+// poll result is saved or after ClaimDuration
+func ClaimUsersToProcess(ctx context.Context) ([]*User, error) {
 	users := []*User{}
+	values := map[string]interface{}{"claim_seconds": claimSeconds()}
 
-	nullUsers, err := queryUsers(ctx, cx.StmtGetNullUsers)
+	nullUsers, err := queryUsers(ctx, cx.StmtClaimNullUsers, values)
 	if err != nil {
 		return nil, err
 	}
 	users = append(users, nullUsers...)
 
-	updateUsers, err := queryUsers(ctx, cx.StmtGetUsers)
+	updateUsers, err := queryUsers(ctx, cx.StmtClaimUsers, values)
 	if err != nil {
 		return nil, err
 	}
@@ -42,11 +70,43 @@ func GetUsersToProcess(ctx context.Context) ([]*User, error) {
 	return users, nil
 }
 
+// ClaimUser claims the character's user, returning ErrUserClaimed if another
+// worker has already
+func ClaimUser(ctx context.Context, charID int32) (*User, error) {
+	users, err := queryUsers(ctx, cx.StmtClaimUser, map[string]interface{}{
+		"character_id":  charID,
+		"claim_seconds": claimSeconds(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(users) != 1 {
+		return nil, ErrUserClaimed
+	}
+	return users[0], nil
+}
+
+// ReleaseUser releases the claim on the character's user without recording a
+// poll, for work other than polling it
+func ReleaseUser(ctx context.Context, charID int32) error {
+	return executeNamed(
+		ctx,
+		cx.StmtReleaseUser,
+		map[string]interface{}{"character_id": charID},
+	)
+}
+
+// claimSeconds is ClaimDuration in seconds
+func claimSeconds() int {
+	return int(ClaimDuration.Seconds())
+}
+
 func queryUsers(
 	ctx context.Context,
 	key cx.Key,
+	values map[string]interface{},
 ) ([]*User, error) {
-	rows, err := queryNamedResult(ctx, key, map[string]interface{}{})
+	rows, err := queryNamedResult(ctx, key, values)
 	if err != nil {
 		return nil, err
 	}
@@ -67,8 +127,8 @@ func SaveUser(ctx context.Context, user *User) error {
 		return updateUser(ctx, user)
 	}
 
-	if err := DeleteUser(ctx, user.CharacterID); err != nil {
-		log.Printf("failed to delete previous user: %+v", err)
+	if err := InvalidateOwner(ctx, user.CharacterID); err != nil {
+		cx.Logf(ctx, "failed to delete previous user: %+v", err)
 		return err
 	}
 
@@ -81,10 +141,11 @@ func updateUser(ctx context.Context, user *User) error {
 		"character_id":     user.CharacterID,
 		"refresh_token":    user.RefreshToken,
 		"access_token":     user.AccessToken,
-		"access_expires":   user.AccessExpires,
+		"access_expires":   user.AccessExpires.UTC(),
 		"owner_hash":       user.OwnerHash,
 		"last_journal_id":  user.LastJournalID,
 		"last_contract_id": user.LastContractID,
+		"scopes":           user.Scopes,
 	})
 }
 
@@ -94,8 +155,9 @@ func saveNewUser(ctx context.Context, user *User) error {
 		"character_id":   user.CharacterID,
 		"refresh_token":  user.RefreshToken,
 		"access_token":   user.AccessToken,
-		"access_expires": user.AccessExpires,
+		"access_expires": user.AccessExpires.UTC(),
 		"owner_hash":     user.OwnerHash,
+		"scopes":         user.Scopes,
 	}); err != nil {
 		return err
 	}
@@ -104,6 +166,19 @@ func saveNewUser(ctx context.Context, user *User) error {
 	})
 }
 
+// SaveOwner links the user's character to the account owning it
+func SaveOwner(ctx context.Context, user *User) error {
+	return executeNamed(ctx, cx.StmtSaveOwner, map[string]interface{}{
+		"character_id": user.CharacterID,
+		"owner_hash":   user.OwnerHash,
+	})
+}
+
+// GetUser returns the stored user for the character
+func GetUser(ctx context.Context, characterID int32) (*User, error) {
+	return getUser(ctx, characterID)
+}
+
 // pull the known user for this characterID
 func getUser(
 	ctx context.Context,
@@ -123,7 +198,7 @@ func getUser(
 	if err != nil {
 		return nil, err
 	} else if len(users) != 1 {
-		return nil, errors.New("User not found")
+		return nil, errUserNotFound
 	}
 
 	return users[0], nil
@@ -141,6 +216,89 @@ func scanUsers(rows *sqlx.Rows) ([]*User, error) {
 	return users, nil
 }
 
+// RevokeUser stops polling a character until its owner logs in again
+func RevokeUser(ctx context.Context, charID int32) error {
+	return executeNamed(
+		ctx,
+		cx.StmtRevokeUser,
+		map[string]interface{}{"character_id": charID},
+	)
+}
+
+// SavePollResult records when the character was last polled successfully, or
+// last failed to be polled if pollErr is not nil
+func SavePollResult(ctx context.Context, charID int32, pollErr error) error {
+	key := cx.StmtPollSucceeded
+	if pollErr != nil {
+		key = cx.StmtPollFailed
+	}
+	return executeNamed(
+		ctx,
+		key,
+		map[string]interface{}{"character_id": charID},
+	)
+}
+
+// MarkBackfilled records that the character's history has been backfilled
+func MarkBackfilled(ctx context.Context, charID int32) error {
+	return executeNamed(
+		ctx,
+		cx.StmtMarkBackfilled,
+		map[string]interface{}{"character_id": charID},
+	)
+}
+
+// SchedulePoll sets the seconds until the character is next polled, 0 for
+// the base interval
+func SchedulePoll(ctx context.Context, charID int32, interval int32) error {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	if interval == 0 {
+		interval = int32(opts.PollInterval)
+	}
+	return executeNamed(ctx, cx.StmtSchedulePoll, map[string]interface{}{
+		"character_id":  charID,
+		"poll_interval": interval,
+	})
+}
+
+// BumpPoll moves the character back to the base poll interval, bringing
+// their next poll forward if it has backed off
+func BumpPoll(ctx context.Context, charID int32) error {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	return executeNamed(ctx, cx.StmtBumpPoll, map[string]interface{}{
+		"character_id":  charID,
+		"base_interval": opts.PollInterval,
+	})
+}
+
+// NeedsReauth returns true if the character's owner must log in again
+// before the character can be polled
+func NeedsReauth(ctx context.Context, charID int32) (bool, error) {
+	user, err := getUser(ctx, charID)
+	if err == errUserNotFound {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return user.Revoked, nil
+}
+
+// InvalidateOwner removes the stored token, owner link and owner private
+// preferences of a character which has been transferred to a new account
+func InvalidateOwner(ctx context.Context, charID int32) error {
+	values := map[string]interface{}{"character_id": charID}
+	for _, key := range []cx.Key{
+		cx.StmtDeleteUser,
+		cx.StmtDeleteOwner,
+		cx.StmtClearOwnerPreferences,
+	} {
+		if err := executeNamed(ctx, key, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // DeleteUser removes a user (auth/tracked character)
 func DeleteUser(ctx context.Context, charID int32) error {
 	return executeNamed(
@@ -149,3 +307,37 @@ func DeleteUser(ctx context.Context, charID int32) error {
 		map[string]interface{}{"character_id": charID},
 	)
 }
+
+// EncryptTokens encrypts any refresh tokens still stored as plaintext,
+// returning the number of tokens encrypted
+func EncryptTokens(ctx context.Context) (int, error) {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+
+	users, err := queryUsers(ctx, cx.StmtGetAllUsers, map[string]interface{}{})
+	if err != nil {
+		return 0, err
+	}
+
+	encrypted := 0
+	for _, user := range users {
+		if tokens.IsEncrypted(user.RefreshToken) {
+			continue
+		}
+
+		token, err := tokens.Encrypt(opts.TokenKey, user.RefreshToken)
+		if err != nil {
+			return encrypted, err
+		}
+
+		if err := executeNamed(ctx, cx.StmtSetRefreshToken, map[string]interface{}{
+			"character_id":  user.CharacterID,
+			"refresh_token": user.RefreshToken,
+			"new_token":     token,
+		}); err != nil {
+			return encrypted, err
+		}
+		encrypted++
+	}
+
+	return encrypted, nil
+}
@@ -0,0 +1,112 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestUserScopesDB(t *testing.T) {
+	ctx := testDB(t)
+
+	scopes := func() []string {
+		user, err := GetUser(ctx, 1)
+		if err != nil {
+			t.Fatalf("failed to get user: %+v", err)
+		}
+		return user.Scopes
+	}
+
+	user := &User{
+		RefreshToken:  "token",
+		AccessToken:   "access",
+		OwnerHash:     "owner",
+		CharacterID:   1,
+		AccessExpires: time.Now(),
+	}
+	if err := SaveUser(ctx, user); err != nil {
+		t.Fatalf("failed to save user: %+v", err)
+	}
+	if s := scopes(); len(s) != 0 {
+		t.Errorf("expected no scopes of a user saved without, received %v", s)
+	}
+
+	user.Scopes = pq.StringArray{
+		"esi-wallet.read_character_wallet.v1",
+		"esi-contracts.read_character_contracts.v1",
+	}
+	if err := SaveUser(ctx, user); err != nil {
+		t.Fatalf("failed to update user: %+v", err)
+	}
+	if s := scopes(); !reflect.DeepEqual(s, []string(user.Scopes)) {
+		t.Errorf("expected scopes %v, received %v", user.Scopes, s)
+	}
+}
+
+func TestClaimUsersConcurrentlyDB(t *testing.T) {
+	ctx := testDB(t)
+
+	// each statement claims at most 100 users a call, so neither worker can
+	// take every user needing a poll
+	const users = 300
+	for i := int32(1); i <= users; i++ {
+		if err := SaveUser(ctx, &User{
+			RefreshToken:  "token",
+			AccessToken:   "access",
+			OwnerHash:     "owner",
+			CharacterID:   i,
+			AccessExpires: time.Now(),
+		}); err != nil {
+			t.Fatalf("failed to save user %d: %+v", i, err)
+		}
+	}
+	if _, err := storeFrom(ctx).DB.Exec(
+		"UPDATE users SET last_processed = NOW() - INTERVAL '2 hours' "+
+			"WHERE character_id > $1",
+		users/2,
+	); err != nil {
+		t.Fatalf("failed to age users: %+v", err)
+	}
+
+	start := make(chan struct{})
+	claimed := make([][]*User, 2)
+	errs := make([]error, 2)
+	wg := sync.WaitGroup{}
+	for i := range claimed {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			claimed[i], errs[i] = ClaimUsersToProcess(ctx)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	seen := map[int32]int{}
+	for i, worker := range claimed {
+		if errs[i] != nil {
+			t.Fatalf("worker %d failed to claim users: %+v", i, errs[i])
+		}
+		for _, user := range worker {
+			if prev, ok := seen[user.CharacterID]; ok {
+				t.Errorf(
+					"user %d was claimed by workers %d and %d",
+					user.CharacterID,
+					prev,
+					i,
+				)
+			}
+			seen[user.CharacterID] = i
+		}
+	}
+	if len(seen) != users {
+		t.Errorf("expected all %d users claimed, received %d", users, len(seen))
+	}
+}
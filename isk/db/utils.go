@@ -4,25 +4,43 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"math"
+	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq" // adds the "postgres" driver to sql
+	"github.com/lib/pq" // adds the "postgres" driver to sql
 
 	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/metrics"
 )
 
-// Connect returns a new connection to the postgres db
-func Connect(ctx context.Context) *sqlx.DB {
-	opts := ctx.Value(cx.Opts).(*cx.Options)
-	db, err := sqlx.Open("postgres", fmt.Sprintf(
-		"postgres://%s:%s@%s/%s?sslmode=%s",
+// queryDuration times each prepared statement, by its key
+var queryDuration = metrics.NewHistogramVec(
+	"esi_isk_db_query_duration_seconds",
+	"Seconds taken by db statements",
+	"statement",
+	metrics.DefaultBuckets,
+)
+
+// readyTimeout is how long Ready waits for the db to respond
+const readyTimeout = 2 * time.Second
+
+// dsn returns the connection string for the postgres db
+func dsn(opts *cx.Options) string {
+	return fmt.Sprintf(
+		// timestamp columns are stored without a zone, force the session to UTC
+		"postgres://%s:%s@%s/%s?sslmode=%s&timezone=UTC",
 		opts.DB.User,
 		opts.DB.Password,
 		opts.DB.Host,
 		opts.DB.Name,
 		opts.DB.Mode,
-	))
+	)
+}
+
+// Connect returns a new connection to the postgres db
+func Connect(ctx context.Context) *sqlx.DB {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	db, err := sqlx.Open("postgres", dsn(opts))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -36,13 +54,71 @@ func Connect(ctx context.Context) *sqlx.DB {
 	return db
 }
 
+// Open adds a new PgStore to the context, unless it already has one
+func Open(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(cx.Store).(*PgStore); ok {
+		return ctx
+	}
+	return NewPgStore(ctx).Context(ctx)
+}
+
+// Ready returns an error unless the store of the context is ready
+func Ready(ctx context.Context) error {
+	return storeFrom(ctx).Ready(ctx)
+}
+
+// logQuery logs the use of the query when debugging
+func logQuery(ctx context.Context, query interface{}) {
+	if opts, ok := ctx.Value(cx.Opts).(*cx.Options); ok && opts.Debug {
+		cx.Logf(ctx, "query %s", query)
+	}
+}
+
+// timeQuery starts timing the statement, call the returned func once it
+// completes
+func timeQuery(ctx context.Context, stmt cx.Key) func() {
+	start := time.Now()
+	return func() {
+		observeQuery(ctx, stmt, time.Since(start))
+	}
+}
+
+// observeQuery records the time the statement took. Statements taking
+// -slow-query milliseconds or more are logged by their key alone, their
+// values may hold user text
+func observeQuery(ctx context.Context, stmt cx.Key, elapsed time.Duration) {
+	queryDuration.Observe(string(stmt), elapsed.Seconds())
+
+	opts, ok := ctx.Value(cx.Opts).(*cx.Options)
+	if !ok || opts.SlowQuery <= 0 {
+		return
+	}
+	if elapsed >= time.Duration(opts.SlowQuery)*time.Millisecond {
+		cx.Logf(
+			ctx,
+			"slow query %s took %s",
+			stmt,
+			elapsed.Round(time.Millisecond),
+		)
+	}
+}
+
+// The functions below run on the store of the context, see PgStore
+
+func queryIn(
+	ctx context.Context,
+	query string,
+	args ...interface{},
+) (*sqlx.Rows, error) {
+	return storeFrom(ctx).QueryIn(ctx, query, args...)
+}
+
 func queryNamedResult(
 	ctx context.Context,
 	stmt cx.Key,
 	values map[string]interface{},
 ) (*sqlx.Rows, error) {
-	statements := ctx.Value(cx.Statements).(map[cx.Key]*sqlx.NamedStmt)
-	return statements[stmt].Queryx(values)
+	return storeFrom(ctx).Query(ctx, stmt, values)
 }
 
 func getNamedResult(
@@ -51,8 +127,7 @@ func getNamedResult(
 	dest interface{},
 	values map[string]interface{},
 ) error {
-	statements := ctx.Value(cx.Statements).(map[cx.Key]*sqlx.NamedStmt)
-	return statements[stmt].Get(dest, values)
+	return storeFrom(ctx).Get(ctx, stmt, dest, values)
 }
 
 func executeNamed(
@@ -60,11 +135,64 @@ func executeNamed(
 	stmt cx.Key,
 	values map[string]interface{},
 ) error {
-	statements := ctx.Value(cx.Statements).(map[cx.Key]*sqlx.NamedStmt)
-	_, err := statements[stmt].Exec(values)
+	_, err := storeFrom(ctx).ExecCount(ctx, stmt, values)
 	return err
 }
 
+func executeNamedCount(
+	ctx context.Context,
+	stmt cx.Key,
+	values map[string]interface{},
+) (int64, error) {
+	return storeFrom(ctx).ExecCount(ctx, stmt, values)
+}
+
+func transaction(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	return storeFrom(ctx).Transaction(ctx, fn)
+}
+
+// WithTx runs fn in a transaction of the store of the context, see
+// PgStore.WithTx
+func WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return storeFrom(ctx).WithTx(ctx, fn)
+}
+
+func queryNamedTx(
+	ctx context.Context,
+	tx *sqlx.Tx,
+	stmt cx.Key,
+	values map[string]interface{},
+) (*sqlx.Rows, error) {
+	return storeFrom(ctx).QueryTx(ctx, tx, stmt, values)
+}
+
+func executeNamedTx(
+	ctx context.Context,
+	tx *sqlx.Tx,
+	stmt cx.Key,
+	values map[string]interface{},
+) error {
+	_, err := storeFrom(ctx).ExecTxCount(ctx, tx, stmt, values)
+	return err
+}
+
+func executeNamedTxCount(
+	ctx context.Context,
+	tx *sqlx.Tx,
+	stmt cx.Key,
+	values map[string]interface{},
+) (int64, error) {
+	return storeFrom(ctx).ExecTxCount(ctx, tx, stmt, values)
+}
+
+// utcNullTime returns the NullTime with any valid time converted to UTC
+func utcNullTime(t pq.NullTime) pq.NullTime {
+	if t.Valid {
+		t.Time = t.Time.UTC()
+	}
+	return t
+}
+
 func inInt32(i int32, l []int32) bool {
 	for _, j := range l {
 		if i == j {
@@ -76,7 +204,22 @@ func inInt32(i int32, l []int32) bool {
 
 func scan(rows *sqlx.Rows, newItem func() interface{}) ([]interface{}, error) {
 	items := []interface{}{}
+	err := each(rows, newItem, func(item interface{}) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
 
+// each scans and passes every row to fn without holding them all in memory
+func each(
+	rows *sqlx.Rows,
+	newItem func() interface{},
+	fn func(interface{}) error,
+) error {
 	defer func() {
 		if err := rows.Close(); err != nil {
 			log.Printf("failed to close rows: %+v", err)
@@ -86,14 +229,12 @@ func scan(rows *sqlx.Rows, newItem func() interface{}) ([]interface{}, error) {
 	for rows.Next() {
 		item := newItem()
 		if err := rows.StructScan(item); err != nil {
-			return nil, err
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
 		}
-		items = append(items, item)
 	}
 
-	return items, nil
-}
-
-func round2(n float64) float64 {
-	return math.Round(n*100) / 100
+	return rows.Err()
 }
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestObserveQuery(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	stmt := cx.Key("StmtObserveQueryTest")
+	cases := map[string]struct {
+		slowQuery int
+		elapsed   time.Duration
+		logged    bool
+	}{
+		"disabled": {0, time.Minute, false},
+		"fast":     {500, 499 * time.Millisecond, false},
+		"slow":     {500, 500 * time.Millisecond, true},
+	}
+
+	for name, c := range cases {
+		buf.Reset()
+		opts := &cx.Options{SlowQuery: c.slowQuery}
+		ctx := context.WithValue(context.Background(), cx.Opts, opts)
+		observeQuery(ctx, stmt, c.elapsed)
+
+		logged := buf.String()
+		if strings.Contains(logged, "slow query "+string(stmt)) != c.logged {
+			t.Errorf("%s: unexpected log %q", name, logged)
+		}
+	}
+
+	if count := queryDuration.Count(string(stmt)); count != int64(len(cases)) {
+		t.Errorf("expected %d timed queries, received %d", len(cases), count)
+	}
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// TotalsCheck is a character's stored totals, alongside the totals of each
+// window counted from the donations, archived donations and contracts stored
+// for it. Donations pruned before the archive existed stay in the lifetime
+// totals, so those can only be checked to be no less than the ones counted
+type TotalsCheck struct {
+	CharacterRow
+	Source CharacterRow `db:"source"`
+}
+
+// Diff describes each stored total which disagrees with the stored rows, it
+// is empty for consistent totals
+func (c *TotalsCheck) Diff() []string {
+	diff := []string{}
+	for _, w := range allWindows() {
+		stored, source := c.totals(w), c.Source.totals(w)
+		for _, total := range []struct {
+			name           string
+			stored, source int64
+		}{
+			{"received" + w.suffix(), *stored.received, *source.received},
+			{
+				"received_isk" + w.suffix(),
+				int64(*stored.receivedISK),
+				int64(*source.receivedISK),
+			},
+			{"donated" + w.suffix(), *stored.donated, *source.donated},
+			{
+				"donated_isk" + w.suffix(),
+				int64(*stored.donatedISK),
+				int64(*source.donatedISK),
+			},
+		} {
+			if w == WindowAll {
+				if total.stored < total.source {
+					diff = append(diff, fmt.Sprintf(
+						"%s is %d, below the %d counted",
+						total.name,
+						total.stored,
+						total.source,
+					))
+				}
+			} else if total.stored != total.source {
+				diff = append(diff, fmt.Sprintf(
+					"%s is %d, counted %d",
+					total.name,
+					total.stored,
+					total.source,
+				))
+			}
+		}
+	}
+	return diff
+}
+
+// repair sets the totals of each window to those counted, and raises any
+// lifetime totals below them
+func (c *TotalsCheck) repair() {
+	for _, w := range Windows {
+		stored, source := c.totals(w), c.Source.totals(w)
+		*stored.received, *stored.receivedISK =
+			*source.received, *source.receivedISK
+		*stored.donated, *stored.donatedISK = *source.donated, *source.donatedISK
+	}
+
+	stored, source := c.totals(WindowAll), c.Source.totals(WindowAll)
+	if *stored.received < *source.received {
+		*stored.received = *source.received
+	}
+	if *stored.receivedISK < *source.receivedISK {
+		*stored.receivedISK = *source.receivedISK
+	}
+	if *stored.donated < *source.donated {
+		*stored.donated = *source.donated
+	}
+	if *stored.donatedISK < *source.donatedISK {
+		*stored.donatedISK = *source.donatedISK
+	}
+}
+
+// VerifyTotals checks the character's totals against its stored donations
+// and contracts. With repair, inconsistent totals are corrected, and the
+// returned check is of the totals before repair
+func VerifyTotals(
+	ctx context.Context,
+	charID int32,
+	repair bool,
+) (*TotalsCheck, error) {
+	checks, err := verifyTotals(ctx, charID, repair)
+	if err != nil {
+		return nil, err
+	}
+	for _, check := range checks {
+		return check, nil
+	}
+	return nil, ErrCharacterNotFound
+}
+
+// VerifyAllTotals checks the totals of every character, returning those which
+// are inconsistent. With repair, they are corrected
+func VerifyAllTotals(ctx context.Context, repair bool) ([]*TotalsCheck, error) {
+	checks, err := verifyTotals(ctx, 0, repair)
+	if err != nil {
+		return nil, err
+	}
+
+	inconsistent := []*TotalsCheck{}
+	for _, check := range checks {
+		if len(check.Diff()) > 0 {
+			inconsistent = append(inconsistent, check)
+		}
+	}
+	return inconsistent, nil
+}
+
+// verifyTotals checks the character, or every character for 0
+func verifyTotals(
+	ctx context.Context,
+	charID int32,
+	repair bool,
+) ([]*TotalsCheck, error) {
+	// totals saved during a repair would be overwritten
+	if repair {
+		unlock, err := LockTotals(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	rows, err := queryNamedResult(ctx, cx.StmtCheckTotals, map[string]interface{}{
+		"character_id": charID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &TotalsCheck{} })
+	if err != nil {
+		return nil, err
+	}
+
+	checks := []*TotalsCheck{}
+	repaired := []int32{}
+	for _, i := range res {
+		check := i.(*TotalsCheck)
+		checks = append(checks, check)
+
+		if !repair || len(check.Diff()) == 0 {
+			continue
+		}
+
+		fixed := *check
+		fixed.repair()
+		err := executeNamed(
+			ctx,
+			cx.StmtRepairTotals,
+			characterValues(&fixed.CharacterRow),
+		)
+		if err != nil {
+			return checks, err
+		}
+		repaired = append(repaired, fixed.ID)
+	}
+
+	if err := NotifyCharacters(ctx, repaired); err != nil {
+		cx.Logf(ctx, "failed to notify character updates: %+v", err)
+	}
+	return checks, nil
+}
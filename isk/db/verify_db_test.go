@@ -0,0 +1,72 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyTotalsDB(t *testing.T) {
+	ctx := testDB(t)
+
+	now := time.Now().UTC()
+	old := testDonation(1, 0, 1000)
+	old.Timestamp = now.Add(-60 * 24 * time.Hour)
+	recent := testDonation(2, 0, 500)
+	recent.Timestamp = now.Add(-24 * time.Hour)
+	countDonations(t, ctx, old, recent)
+
+	stale, err := GetStaleDonations(ctx, WindowMonth)
+	if err != nil {
+		t.Fatalf("failed to get stale donations: %+v", err)
+	}
+	if err := AgeDonations(
+		ctx,
+		stale,
+		testAffiliations(t, ctx),
+		WindowMonth,
+	); err != nil {
+		t.Fatalf("failed to age donations: %+v", err)
+	}
+	if _, err := ArchiveDonations(ctx, MinArchiveAge); err != nil {
+		t.Fatalf("failed to archive donations: %+v", err)
+	}
+
+	check, err := VerifyTotals(ctx, 1, false)
+	if err != nil {
+		t.Fatalf("failed to verify totals: %+v", err)
+	}
+	if diff := check.Diff(); len(diff) != 0 {
+		t.Errorf("expected consistent totals, received %v", diff)
+	}
+	if check.Source.Received != 2 || check.Source.Received90 != 2 ||
+		check.Source.Received30 != 1 {
+		t.Errorf("archived donation miscounted: %+v", check.Source)
+	}
+
+	// the 90 day totals drift, and the lifetime ones fall below the archive
+	if _, err := storeFrom(ctx).DB.Exec(
+		"UPDATE characters SET received_90 = 5, received_isk = 0 " +
+			"WHERE character_id = 1",
+	); err != nil {
+		t.Fatalf("failed to corrupt totals: %+v", err)
+	}
+	checks, err := VerifyAllTotals(ctx, true)
+	if err != nil {
+		t.Fatalf("failed to repair totals: %+v", err)
+	}
+	if len(checks) != 1 || len(checks[0].Diff()) != 2 {
+		t.Fatalf("expected two inconsistent totals, received %+v", checks)
+	}
+
+	recipient := getTestCharacter(t, ctx, 1)
+	if recipient.Received90 != 2 || recipient.ReceivedISK != NewISK(1500) {
+		t.Errorf("totals were not repaired: %+v", recipient)
+	}
+	if check, err := VerifyTotals(ctx, 1, false); err != nil ||
+		len(check.Diff()) != 0 {
+		t.Errorf("repaired totals are inconsistent: %+v (%v)", check, err)
+	}
+}
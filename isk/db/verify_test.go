@@ -0,0 +1,69 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTotalsCheck(t *testing.T) {
+	check := &TotalsCheck{
+		CharacterRow: CharacterRow{
+			ID:            1,
+			Received:      5,
+			ReceivedISK:   900,
+			Received30:    3,
+			ReceivedISK30: 500,
+			Received90:    3,
+			ReceivedISK90: 600,
+			Donated:       1,
+			DonatedISK:    100,
+			Donated30:     1,
+			DonatedISK30:  100,
+			Donated90:     1,
+			DonatedISK90:  100,
+		},
+		Source: CharacterRow{
+			Received:      4,
+			ReceivedISK:   800,
+			Received30:    3,
+			ReceivedISK30: 600,
+			Received90:    4,
+			ReceivedISK90: 800,
+			Donated:       2,
+			DonatedISK:    200,
+			Donated30:     2,
+			DonatedISK30:  200,
+			Donated90:     2,
+			DonatedISK90:  200,
+		},
+	}
+
+	expected := []string{
+		"donated is 1, below the 2 counted",
+		"donated_isk is 100, below the 200 counted",
+		"received_isk_30 is 500, counted 600",
+		"donated_30 is 1, counted 2",
+		"donated_isk_30 is 100, counted 200",
+		"received_90 is 3, counted 4",
+		"received_isk_90 is 600, counted 800",
+		"donated_90 is 1, counted 2",
+		"donated_isk_90 is 100, counted 200",
+	}
+	if diff := check.Diff(); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("unexpected diff:\n%v\nexpected:\n%v", diff, expected)
+	}
+
+	check.repair()
+	if diff := check.Diff(); len(diff) != 0 {
+		t.Errorf("repaired totals are inconsistent: %v", diff)
+	}
+	if check.Received != 5 || check.ReceivedISK != 900 {
+		t.Errorf("lifetime totals above those counted changed: %+v", check)
+	}
+	if check.Donated != 2 || check.DonatedISK != 200 {
+		t.Errorf("lifetime totals were not raised: %+v", check)
+	}
+	if check.Received90 != 4 || check.ReceivedISK90 != 800 {
+		t.Errorf("90 day totals were not repaired: %+v", check)
+	}
+}
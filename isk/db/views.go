@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// hourViewsKept is how long hourly page views are kept, trending only ranks
+// the last day of them
+const hourViewsKept = 48 * time.Hour
+
+// HourViews are the page views of a character in the hour starting at Hour
+type HourViews struct {
+	CharacterID int32
+	Hour        time.Time
+	Views       int64
+}
+
+// TrendingCharacter is a character with its page views of the last day
+type TrendingCharacter struct {
+	Character *Character `json:"character"`
+	Views     int64      `json:"views"`
+}
+
+type viewedRow struct {
+	ID    int32 `db:"character_id"`
+	Views int64 `db:"views"`
+}
+
+// AddViews adds the page views to the totals and hours of each character, in
+// one transaction, and drops hours which are no longer kept. Views of unknown
+// characters are dropped
+func AddViews(ctx context.Context, views []*HourViews) error {
+	if len(views) == 0 {
+		return nil
+	}
+
+	return transaction(ctx, func(tx *sqlx.Tx) error {
+		for _, v := range views {
+			values := map[string]interface{}{
+				"character_id": v.CharacterID,
+				"hour":         v.Hour.UTC(),
+				"views":        v.Views,
+			}
+			for _, key := range []cx.Key{cx.StmtAddViews, cx.StmtAddHourViews} {
+				if err := executeNamedTx(ctx, tx, key, values); err != nil {
+					return err
+				}
+			}
+		}
+
+		prune := map[string]interface{}{
+			"before": time.Now().UTC().Add(-hourViewsKept),
+		}
+		return executeNamedTx(ctx, tx, cx.StmtPruneHourViews, prune)
+	})
+}
+
+// MostViewed returns the IDs of up to limit characters with the most page
+// views, most viewed first. Hidden characters are left out
+func MostViewed(ctx context.Context, limit int) ([]int32, error) {
+	rows, err := queryNamedResult(ctx, cx.StmtMostViewed, map[string]interface{}{
+		"limit": limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int32{}
+	err = each(rows, func() interface{} { return &viewedRow{} }, func(
+		i interface{},
+	) error {
+		ids = append(ids, i.(*viewedRow).ID)
+		return nil
+	})
+	return ids, err
+}
+
+// GetTrending returns a page of the characters with the most page views in
+// the hours since the time, most viewed first. Hidden characters are left out
+func GetTrending(
+	ctx context.Context,
+	since time.Time,
+	limit, offset int,
+) ([]*TrendingCharacter, error) {
+	ctx = replicated(ctx)
+	rows, err := queryNamedResult(ctx, cx.StmtTrending, map[string]interface{}{
+		"since":  since.UTC(),
+		"limit":  limit,
+		"offset": offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scan(rows, func() interface{} { return &viewedRow{} })
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int32{}
+	for _, i := range res {
+		ids = append(ids, i.(*viewedRow).ID)
+	}
+	characters, err := GetCharacters(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[int32]*Character{}
+	for _, c := range characters {
+		byID[c.ID] = c
+	}
+
+	trending := []*TrendingCharacter{}
+	for _, i := range res {
+		row := i.(*viewedRow)
+		if c, found := byID[row.ID]; found {
+			trending = append(trending, &TrendingCharacter{
+				Character: c,
+				Views:     row.Views,
+			})
+		}
+	}
+	return trending, nil
+}
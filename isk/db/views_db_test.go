@@ -0,0 +1,49 @@
+//go:build dbtest
+// +build dbtest
+
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestViewsDB(t *testing.T) {
+	ctx := testDB(t)
+
+	loadCharacters(t, ctx, &CharacterRow{ID: 1}, &CharacterRow{ID: 2})
+
+	hour := time.Now().UTC().Truncate(time.Hour)
+	if err := AddViews(ctx, []*HourViews{
+		{CharacterID: 1, Hour: hour, Views: 2},
+		{CharacterID: 2, Hour: hour, Views: 3},
+		{CharacterID: 1, Hour: hour.Add(-30 * time.Hour), Views: 5},
+		{CharacterID: 4, Hour: hour, Views: 20},
+	}); err != nil {
+		t.Fatalf("failed to add views: %+v", err)
+	}
+
+	// unknown characters are left out
+	ids, err := MostViewed(ctx, 10)
+	if err != nil {
+		t.Fatalf("failed to get most viewed: %+v", err)
+	}
+	if !reflect.DeepEqual(ids, []int32{1, 2}) {
+		t.Errorf("unexpected most viewed %v", ids)
+	}
+
+	if ids, err = MostViewed(ctx, 1); err != nil || len(ids) != 1 {
+		t.Errorf("expected the limit to apply, received %v (%v)", ids, err)
+	}
+
+	// only the last day is trending
+	trending, err := GetTrending(ctx, hour.Add(-23*time.Hour), 10, 0)
+	if err != nil {
+		t.Fatalf("failed to get trending: %+v", err)
+	}
+	if len(trending) != 2 || trending[0].Character.ID != 2 ||
+		trending[0].Views != 3 || trending[1].Views != 2 {
+		t.Errorf("unexpected trending %+v", trending)
+	}
+}
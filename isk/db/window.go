@@ -0,0 +1,167 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Window is the number of days of rolling totals, or WindowAll
+type Window int
+
+// WindowAll is the lifetime totals
+const WindowAll = Window(0)
+
+// WindowMonth is the window of the leaderboards by default, of ranks and of
+// good standing. Donations are archived once out of it
+const WindowMonth = Window(30)
+
+// Windows are the rolling totals kept of every character, shortest first.
+// Each has its received_N, received_isk_N, donated_N and donated_isk_N
+// columns, and fields of CharacterRow returned by totals
+var Windows = []Window{7, WindowMonth, 90}
+
+// ErrUnknownWindow is returned when parsing a window we keep no totals of
+var ErrUnknownWindow = errors.New("unknown window")
+
+// ParseWindow parses a window as formatted by String, 7d or all
+func ParseWindow(s string) (Window, error) {
+	if s == WindowAll.String() {
+		return WindowAll, nil
+	}
+	days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+	if err == nil && strings.HasSuffix(s, "d") {
+		for _, w := range Windows {
+			if w == Window(days) {
+				return w, nil
+			}
+		}
+	}
+	return WindowAll, fmt.Errorf("%w: %q", ErrUnknownWindow, s)
+}
+
+// String formats the window as 7d, or all
+func (w Window) String() string {
+	if w == WindowAll {
+		return "all"
+	}
+	return fmt.Sprintf("%dd", w)
+}
+
+// WindowNames are the windows as formatted by String, lifetime first
+func WindowNames() []string {
+	names := []string{}
+	for _, w := range allWindows() {
+		names = append(names, w.String())
+	}
+	return names
+}
+
+// suffix is the suffix of the total columns of the window
+func (w Window) suffix() string {
+	if w == WindowAll {
+		return ""
+	}
+	return fmt.Sprintf("_%d", w)
+}
+
+// allWindows are the lifetime totals followed by every rolling window
+func allWindows() []Window {
+	return append([]Window{WindowAll}, Windows...)
+}
+
+// countedWindows are the totals a transfer aged out of the agedOut window is
+// still counted in, lifetime included
+func countedWindows(agedOut Window) []Window {
+	windows := []Window{WindowAll}
+	for _, w := range Windows {
+		if w > agedOut {
+			windows = append(windows, w)
+		}
+	}
+	return windows
+}
+
+// agingWindows are the totals a transfer aged out of the agedOut window
+// leaves when aging out of window
+func agingWindows(agedOut, window Window) []Window {
+	windows := []Window{}
+	for _, w := range Windows {
+		if w > agedOut && w <= window {
+			windows = append(windows, w)
+		}
+	}
+	return windows
+}
+
+// totalColumns are the columns of the totals of every window, received then
+// donated
+func totalColumns() []string {
+	columns := []string{}
+	for _, prefix := range []string{"received", "donated"} {
+		for _, w := range allWindows() {
+			columns = append(columns, prefix+w.suffix(), prefix+"_isk"+w.suffix())
+		}
+	}
+	return columns
+}
+
+// windowTotals point at the totals of a character over one window
+type windowTotals struct {
+	received    *int64
+	receivedISK *ISK
+	donated     *int64
+	donatedISK  *ISK
+}
+
+// totals returns the totals of the row over the window
+func (c *CharacterRow) totals(w Window) windowTotals {
+	switch w {
+	case WindowAll:
+		return windowTotals{&c.Received, &c.ReceivedISK, &c.Donated, &c.DonatedISK}
+	case 7:
+		return windowTotals{&c.Received7, &c.ReceivedISK7, &c.Donated7, &c.DonatedISK7}
+	case 30:
+		return windowTotals{
+			&c.Received30,
+			&c.ReceivedISK30,
+			&c.Donated30,
+			&c.DonatedISK30,
+		}
+	case 90:
+		return windowTotals{
+			&c.Received90,
+			&c.ReceivedISK90,
+			&c.Donated90,
+			&c.DonatedISK90,
+		}
+	}
+	// this should never happen, every window has its fields
+	panic(fmt.Errorf("no totals of window %s", w))
+}
+
+// countTransfer adds n of the transfer of isk to the totals of each window of
+// its donator and receiver, n is negative to remove it
+func countTransfer(
+	donator, receiver int32,
+	isk ISK,
+	n int64,
+	windows []Window,
+	characters ...[]*CharacterRow,
+) {
+	for _, chars := range characters {
+		for _, char := range chars {
+			for _, w := range windows {
+				totals := char.totals(w)
+				if char.ID == donator {
+					*totals.donated += n
+					*totals.donatedISK += ISK(n) * isk
+				} else if char.ID == receiver {
+					*totals.received += n
+					*totals.receivedISK += ISK(n) * isk
+				}
+			}
+		}
+	}
+}
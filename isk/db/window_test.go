@@ -0,0 +1,107 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// zeroTotals is true when every total of the character is 0
+func zeroTotals(char *CharacterRow) bool {
+	for _, w := range allWindows() {
+		totals := char.totals(w)
+		if *totals.received != 0 || *totals.receivedISK != 0 ||
+			*totals.donated != 0 || *totals.donatedISK != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseWindow(t *testing.T) {
+	cases := map[string]Window{
+		"7d":  7,
+		"30d": WindowMonth,
+		"90d": 90,
+		"all": WindowAll,
+	}
+	for s, expected := range cases {
+		w, err := ParseWindow(s)
+		if err != nil || w != expected {
+			t.Errorf("%s: expected %d, received %d: %+v", s, expected, w, err)
+		}
+		if w.String() != s {
+			t.Errorf("%s: formatted as %s", s, w)
+		}
+	}
+
+	for _, s := range []string{"", "0d", "14d", "30", "d", "month"} {
+		if _, err := ParseWindow(s); !errors.Is(err, ErrUnknownWindow) {
+			t.Errorf("%q: expected ErrUnknownWindow, received %+v", s, err)
+		}
+	}
+}
+
+func TestWindowColumns(t *testing.T) {
+	expected := []string{
+		"received", "received_isk",
+		"received_7", "received_isk_7",
+		"received_30", "received_isk_30",
+		"received_90", "received_isk_90",
+		"donated", "donated_isk",
+		"donated_7", "donated_isk_7",
+		"donated_30", "donated_isk_30",
+		"donated_90", "donated_isk_90",
+	}
+	if columns := totalColumns(); !reflect.DeepEqual(columns, expected) {
+		t.Errorf("expected columns %v, received %v", expected, columns)
+	}
+
+	// every column has its value
+	values := characterValues(&CharacterRow{})
+	for _, column := range characterColumns() {
+		if _, ok := values[column]; !ok {
+			t.Errorf("no value of column %s", column)
+		}
+	}
+	if len(values) != len(characterColumns()) {
+		t.Errorf("expected %d values, have %d", len(characterColumns()), len(values))
+	}
+}
+
+func TestAgeTotals(t *testing.T) {
+	donation := &Donation{ID: 1, Donator: 10, Recipient: 20, Amount: 5000}
+	receiver := &CharacterRow{ID: 20}
+	chars := []*CharacterRow{receiver}
+
+	addToTotals(donation, chars)
+	for _, w := range allWindows() {
+		if totals := receiver.totals(w); *totals.received != 1 ||
+			*totals.receivedISK != 5000 {
+			t.Errorf("%s: donation not added to totals: %+v", w, receiver)
+		}
+	}
+
+	// aging straight out of the 30 day window leaves the 7 day one too
+	ageTotals(donation, WindowMonth, chars)
+	donation.AgedOut = WindowMonth
+	if receiver.Received7 != 0 || receiver.ReceivedISK7 != 0 ||
+		receiver.Received30 != 0 || receiver.ReceivedISK30 != 0 {
+		t.Errorf("donation not aged out of the 7 and 30 day totals: %+v", receiver)
+	}
+	if receiver.Received != 1 || receiver.Received90 != 1 ||
+		receiver.ReceivedISK90 != 5000 {
+		t.Errorf("donation aged out of the longer totals: %+v", receiver)
+	}
+
+	// aging again, as by another run, changes nothing
+	ageTotals(donation, WindowMonth, chars)
+	if receiver.Received30 != 0 || receiver.Received90 != 1 {
+		t.Errorf("donation aged twice: %+v", receiver)
+	}
+
+	reverseTotals(donation, chars)
+	if !zeroTotals(receiver) {
+		t.Errorf("aged donation not reversed once: %+v", receiver)
+	}
+}
@@ -0,0 +1,75 @@
+// Package format writes ISK amounts for people to read
+package format
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// suffixes of each power of a thousand ISK
+var suffixes = []string{"", "k", "m", "b", "t"}
+
+// ISK formats the amount to three significant digits with a k, m, b or t
+// suffix, such as 1.24b. Trailing zeros are dropped, so a billion is 1b
+func ISK(amount float64) string {
+	if amount == 0 {
+		return "0"
+	}
+	if math.IsInf(amount, 0) || math.IsNaN(amount) {
+		return strconv.FormatFloat(amount, 'f', -1, 64)
+	}
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	// rounding is left to strconv, so 999,999 becomes 1.00e+06 and moves up
+	// to the next suffix
+	mantissa, exponent := splitExponent(
+		strconv.FormatFloat(amount, 'e', 2, 64),
+	)
+	digits := strings.Replace(mantissa, ".", "", 1)
+
+	suffix := 0
+	if exponent > 0 {
+		suffix = exponent / 3
+	}
+	if suffix >= len(suffixes) {
+		suffix = len(suffixes) - 1
+	}
+
+	return sign + placePoint(digits, 1+exponent-3*suffix) + suffixes[suffix]
+}
+
+// splitExponent splits a number formatted with 'e' into its mantissa and
+// exponent
+func splitExponent(f string) (string, int) {
+	i := strings.IndexByte(f, 'e')
+	exponent, err := strconv.Atoi(f[i+1:])
+	if err != nil {
+		// strconv always writes a valid exponent
+		panic(err)
+	}
+	return f[:i], exponent
+}
+
+// placePoint puts the decimal point after the number of digits, padding with
+// zeros either side, then drops any trailing fractional zeros
+func placePoint(digits string, point int) string {
+	if point <= 0 {
+		digits = strings.Repeat("0", 1-point) + digits
+		point = 1
+	}
+	if point >= len(digits) {
+		return digits + strings.Repeat("0", point-len(digits))
+	}
+
+	fraction := strings.TrimRight(digits[point:], "0")
+	if fraction == "" {
+		return digits[:point]
+	}
+	return digits[:point] + "." + fraction
+}
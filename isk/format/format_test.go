@@ -0,0 +1,125 @@
+package format
+
+import (
+	"math"
+	"testing"
+)
+
+func TestISK(t *testing.T) {
+	cases := map[float64]string{
+		0:    "0",
+		0.01: "0.01",
+		0.1:  "0.1",
+		0.5:  "0.5",
+		1:    "1",
+		1.5:  "1.5",
+		12.3: "12.3",
+		100:  "100",
+		999:  "999",
+
+		// three significant digits, not decimal places
+		1.234:  "1.23",
+		12.345: "12.3",
+		123.45: "123",
+
+		// halves round up
+		1.235: "1.24",
+		1.245: "1.25",
+
+		1000:   "1k",
+		1234:   "1.23k",
+		1500:   "1.5k",
+		12345:  "12.3k",
+		123456: "123k",
+		100000: "100k",
+
+		1e6:            "1m",
+		1236000:        "1.24m",
+		45600000:       "45.6m",
+		1e9:            "1b",
+		1240000000:     "1.24b",
+		1244999999.99:  "1.24b",
+		1245000000.01:  "1.25b",
+		999000000000:   "999b",
+		1e12:           "1t",
+		2500000000000:  "2.5t",
+		999e12:         "999t",
+		1e15:           "1000t",
+		1234567e12:     "1230000t",
+		9223372036e3:   "9.22t",
+		123456789.5:    "123m",
+		987654321987.6: "988b",
+	}
+
+	for amount, expected := range cases {
+		if s := ISK(amount); s != expected {
+			t.Errorf("ISK(%f): received %s, expected %s", amount, s, expected)
+		}
+	}
+}
+
+func TestISKBelowBoundaries(t *testing.T) {
+	// just below each suffix, amounts round up into it
+	cases := map[float64]string{
+		998.4:          "998",
+		999.4:          "999",
+		999.5:          "1k",
+		999.99:         "1k",
+		998499:         "998k",
+		999499:         "999k",
+		999500:         "1m",
+		999999.99:      "1m",
+		999499999:      "999m",
+		999500000:      "1b",
+		999999999.99:   "1b",
+		999499999999:   "999b",
+		999500000000:   "1t",
+		999999999999.9: "1t",
+		999.4e12:       "999t",
+		999.5e12:       "1000t",
+	}
+
+	for amount, expected := range cases {
+		if s := ISK(amount); s != expected {
+			t.Errorf("ISK(%f): received %s, expected %s", amount, s, expected)
+		}
+	}
+}
+
+func TestISKNegative(t *testing.T) {
+	// admin adjustments can take ISK away
+	cases := map[float64]string{
+		-0.01:          "-0.01",
+		-1:             "-1",
+		-999.5:         "-1k",
+		-1234:          "-1.23k",
+		-999999.99:     "-1m",
+		-1240000000:    "-1.24b",
+		-2500000000000: "-2.5t",
+	}
+
+	for amount, expected := range cases {
+		if s := ISK(amount); s != expected {
+			t.Errorf("ISK(%f): received %s, expected %s", amount, s, expected)
+		}
+	}
+
+	if s := ISK(math.Copysign(0, -1)); s != "0" {
+		t.Errorf("negative zero: received %s, expected 0", s)
+	}
+}
+
+func TestISKNotFinite(t *testing.T) {
+	cases := map[float64]string{
+		math.Inf(1):  "+Inf",
+		math.Inf(-1): "-Inf",
+	}
+	for amount, expected := range cases {
+		if s := ISK(amount); s != expected {
+			t.Errorf("ISK(%f): received %s, expected %s", amount, s, expected)
+		}
+	}
+	if s := ISK(math.NaN()); s != "NaN" {
+		t.Errorf("ISK(NaN): received %s, expected NaN", s)
+	}
+}
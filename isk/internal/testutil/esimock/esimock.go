@@ -0,0 +1,244 @@
+// Package esimock serves the ESI and EVE SSO endpoints the worker uses from
+// canned fixtures, with injected faults, so tests can poll characters
+// without reaching the real ESI
+package esimock
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antihax/goesi/esi"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// ClientID is the SSO client the issued access tokens are for
+const ClientID = "esimock-client"
+
+// versionPrefix is stripped from request paths, so fixtures and faults are
+// set without the version of each route
+var versionPrefix = regexp.MustCompile(`^/(v\d+|latest|legacy|dev)/`)
+
+// Fault changes the responses to requests of a path
+type Fault struct {
+	// Status responds with the error status instead, 0 responds as usual
+	Status int
+
+	// Delay is how long to wait before responding
+	Delay time.Duration
+
+	// Page only affects requests of the page, 0 for every page
+	Page int
+
+	// Times is how many requests are affected, 0 for every one
+	Times int
+}
+
+// Server is a mock ESI and EVE SSO. Its URL is the ESI base path, TokenURL
+// and JWKSURL are the SSO endpoints
+type Server struct {
+	URL string
+
+	// JournalPageSize and ContractPageSize are the entries per page
+	JournalPageSize, ContractPageSize int
+
+	server *httptest.Server
+	key    jose.JSONWebKey
+	routes []route
+
+	lock         sync.Mutex
+	journals     map[int32][]esi.GetCharactersCharacterIdWalletJournal200Ok
+	contracts    map[int32][]esi.GetCharactersCharacterIdContracts200Ok
+	items        map[int32][]contractItem
+	characters   map[int32]esi.GetCharactersCharacterIdOk
+	corporations map[int32]esi.GetCorporationsCorporationIdOk
+	names        map[int32]esi.PostUniverseNames200Ok
+	structures   map[int64]esi.GetUniverseStructuresStructureIdOk
+	tokens       map[string]*token
+	faults       map[string][]*injected
+	requests     map[string]int
+}
+
+// contractItem is an item of a contract
+type contractItem = esi.GetCharactersCharacterIdContractsContractIdItems200Ok
+
+// injected is a fault, counting the requests it affected
+type injected struct {
+	Fault
+	used int
+}
+
+// route handles requests matching the method and pattern, passing the
+// integer parameters of the pattern
+type route struct {
+	method  string
+	pattern *regexp.Regexp
+	handle  func(http.ResponseWriter, *http.Request, []int32)
+}
+
+// New starts a mock server without fixtures, closed when the test ends
+func New(t testing.TB) *Server {
+	s := &Server{
+		JournalPageSize:  2500,
+		ContractPageSize: 1000,
+		key:              newKey(t),
+		journals:         map[int32][]esi.GetCharactersCharacterIdWalletJournal200Ok{},
+		contracts:        map[int32][]esi.GetCharactersCharacterIdContracts200Ok{},
+		items:            map[int32][]contractItem{},
+		characters:       map[int32]esi.GetCharactersCharacterIdOk{},
+		corporations:     map[int32]esi.GetCorporationsCorporationIdOk{},
+		names:            map[int32]esi.PostUniverseNames200Ok{},
+		structures:       map[int64]esi.GetUniverseStructuresStructureIdOk{},
+		tokens:           map[string]*token{},
+		faults:           map[string][]*injected{},
+		requests:         map[string]int{},
+	}
+	s.routes = []route{
+		s.route("GET", `/characters/(\d+)/wallet/journal/`, s.journal),
+		s.route("GET", `/characters/(\d+)/contracts/`, s.contractsPage),
+		s.route("GET", `/characters/(\d+)/contracts/(\d+)/items/`, s.contractItems),
+		s.route("GET", `/characters/(\d+)/`, s.character),
+		s.route("GET", `/corporations/(\d+)/`, s.corporation),
+		s.route("POST", `/characters/affiliation/`, s.affiliation),
+		s.route("POST", `/universe/names/`, s.universeNames),
+		// structure IDs are beyond the integer parameters of routes
+		s.route("GET", `/universe/structures/\d+/`, s.structure),
+		s.route("POST", `/oauth/token`, s.refresh),
+		s.route("GET", `/oauth/jwks`, s.jwks),
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = s.server.URL
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// TokenURL is the SSO token endpoint
+func (s *Server) TokenURL() string {
+	return s.URL + "/v2/oauth/token"
+}
+
+// JWKSURL is where the keys signing access tokens are published
+func (s *Server) JWKSURL() string {
+	return s.URL + "/oauth/jwks"
+}
+
+// Inject changes the responses to requests of the path, which is given
+// without its version, such as /characters/1/wallet/journal/
+func (s *Server) Inject(path string, fault Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults[path] = append(s.faults[path], &injected{Fault: fault})
+}
+
+// Requests returns how many requests of the path were received, including
+// those failed by faults
+func (s *Server) Requests(path string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests[path]
+}
+
+func (s *Server) route(
+	method string,
+	pattern string,
+	handle func(http.ResponseWriter, *http.Request, []int32),
+) route {
+	return route{
+		method:  method,
+		pattern: regexp.MustCompile("^" + pattern + "$"),
+		handle:  handle,
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	path := versionPrefix.ReplaceAllString(r.URL.Path, "/")
+
+	s.lock.Lock()
+	s.requests[path]++
+	fault := s.fault(path, page(r))
+	s.lock.Unlock()
+
+	if fault != nil && fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if fault != nil && fault.Status != 0 {
+		writeError(w, fault.Status)
+		return
+	}
+
+	for _, route := range s.routes {
+		match := route.pattern.FindStringSubmatch(path)
+		if match == nil || r.Method != route.method {
+			continue
+		}
+		params := []int32{}
+		for _, raw := range match[1:] {
+			param, err := strconv.ParseInt(raw, 10, 32)
+			if err != nil {
+				writeError(w, http.StatusBadRequest)
+				return
+			}
+			params = append(params, int32(param))
+		}
+		route.handle(w, r, params)
+		return
+	}
+	writeError(w, http.StatusNotFound)
+}
+
+// fault returns the first fault of the path affecting the page, using up
+// one of its times. Hold the lock
+func (s *Server) fault(path string, page int) *Fault {
+	for _, fault := range s.faults[path] {
+		if fault.Page != 0 && fault.Page != page {
+			continue
+		}
+		if fault.Times > 0 && fault.used >= fault.Times {
+			continue
+		}
+		fault.used++
+		return &fault.Fault
+	}
+	return nil
+}
+
+// page returns the requested page, 1 if none is
+func page(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
+
+// writeJSON responds with the body as JSON
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("failed to write mock response: %+v", err)
+	}
+}
+
+// writeError responds with an ESI error body. Error limited responses also
+// have the limit headers
+func writeError(w http.ResponseWriter, status int) {
+	message := http.StatusText(status)
+	if status == 420 {
+		message = "This software has exceeded the error limit for ESI."
+		w.Header().Set("X-Esi-Error-Limit-Remain", "0")
+		w.Header().Set("X-Esi-Error-Limit-Reset", "60")
+	}
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,69 @@
+package esimock
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/antihax/goesi/esi"
+)
+
+func get(t *testing.T, s *Server, path string) *http.Response {
+	res, err := http.Get(s.URL + path)
+	if err != nil {
+		t.Fatalf("request failed: %+v", err)
+	}
+	if err := res.Body.Close(); err != nil {
+		t.Fatalf("failed to close response body: %+v", err)
+	}
+	return res
+}
+
+func TestJournalPages(t *testing.T) {
+	s := New(t)
+	s.JournalPageSize = 2
+	s.Journal(1,
+		esi.GetCharactersCharacterIdWalletJournal200Ok{Id: 3},
+		esi.GetCharactersCharacterIdWalletJournal200Ok{Id: 2},
+		esi.GetCharactersCharacterIdWalletJournal200Ok{Id: 1},
+	)
+
+	res := get(t, s, "/v4/characters/1/wallet/journal/?page=2")
+	if res.StatusCode != http.StatusOK || res.Header.Get("X-Pages") != "2" {
+		t.Errorf("unexpected page %d of %s", res.StatusCode, res.Header.Get("X-Pages"))
+	}
+	if requests := s.Requests("/characters/1/wallet/journal/"); requests != 1 {
+		t.Errorf("expected 1 request, received %d", requests)
+	}
+}
+
+func TestInject(t *testing.T) {
+	s := New(t)
+	path := "/characters/1/contracts/"
+	s.Inject(path, Fault{Status: 420, Times: 1})
+	s.Inject(path, Fault{Status: 500, Page: 2})
+
+	expected := []struct {
+		query  string
+		status int
+	}{
+		{"", 420},
+		{"", http.StatusOK},
+		{"?page=2", http.StatusInternalServerError},
+		{"?page=2", http.StatusInternalServerError},
+	}
+	for i, e := range expected {
+		if res := get(t, s, "/v1"+path+e.query); res.StatusCode != e.status {
+			t.Errorf("%d: expected %d, received %d", i, e.status, res.StatusCode)
+		}
+	}
+}
+
+func TestUnknownNames(t *testing.T) {
+	s := New(t)
+	s.Name(1, "Some Pilot", "character")
+
+	res := get(t, s, "/v3/characters/1/")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a name without a character, received %d", res.StatusCode)
+	}
+}
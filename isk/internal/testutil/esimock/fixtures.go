@@ -0,0 +1,262 @@
+package esimock
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/antihax/goesi/esi"
+)
+
+// Journal sets the character's wallet journal, newest entry first as ESI
+// returns it
+func (s *Server) Journal(
+	charID int32,
+	entries ...esi.GetCharactersCharacterIdWalletJournal200Ok,
+) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.journals[charID] = entries
+}
+
+// Contracts sets the character's contracts, newest first
+func (s *Server) Contracts(
+	charID int32,
+	contracts ...esi.GetCharactersCharacterIdContracts200Ok,
+) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.contracts[charID] = contracts
+}
+
+// ContractItems sets the items of the contract, for any of its characters
+func (s *Server) ContractItems(contractID int32, items ...contractItem) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.items[contractID] = items
+}
+
+// Character adds the character in the corporation, and its name
+func (s *Server) Character(charID int32, name string, corpID int32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.characters[charID] = esi.GetCharactersCharacterIdOk{
+		Name:          name,
+		CorporationId: corpID,
+	}
+	s.setName(charID, name, "character")
+}
+
+// Corporation adds the corporation in the alliance, 0 for none, and its name
+func (s *Server) Corporation(corpID int32, name string, allianceID int32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.corporations[corpID] = esi.GetCorporationsCorporationIdOk{
+		Name:       name,
+		AllianceId: allianceID,
+	}
+	s.setName(corpID, name, "corporation")
+}
+
+// Name adds only the name of the ID, such as an alliance or a deleted
+// character still known to the names endpoint
+func (s *Server) Name(id int32, name string, category string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.setName(id, name, category)
+}
+
+// Structure adds a structure the characters may access, others are denied
+func (s *Server) Structure(structureID int64, name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.structures[structureID] = esi.GetUniverseStructuresStructureIdOk{
+		Name: name,
+	}
+}
+
+// setName adds the name. Hold the lock
+func (s *Server) setName(id int32, name string, category string) {
+	s.names[id] = esi.PostUniverseNames200Ok{
+		Id:       id,
+		Name:     name,
+		Category: category,
+	}
+}
+
+// pages returns the entries of the page out of length, and sets X-Pages
+func pages(w http.ResponseWriter, r *http.Request, length, size int) (
+	int,
+	int,
+) {
+	total := (length + size - 1) / size
+	if total < 1 {
+		total = 1
+	}
+	w.Header().Set("X-Pages", strconv.Itoa(total))
+
+	start := (page(r) - 1) * size
+	if start > length {
+		start = length
+	}
+	end := start + size
+	if end > length {
+		end = length
+	}
+	return start, end
+}
+
+func (s *Server) journal(
+	w http.ResponseWriter,
+	r *http.Request,
+	params []int32,
+) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries := s.journals[params[0]]
+	start, end := pages(w, r, len(entries), s.JournalPageSize)
+	writeJSON(w, http.StatusOK, entries[start:end])
+}
+
+func (s *Server) contractsPage(
+	w http.ResponseWriter,
+	r *http.Request,
+	params []int32,
+) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	contracts := s.contracts[params[0]]
+	start, end := pages(w, r, len(contracts), s.ContractPageSize)
+	writeJSON(w, http.StatusOK, contracts[start:end])
+}
+
+func (s *Server) contractItems(
+	w http.ResponseWriter,
+	r *http.Request,
+	params []int32,
+) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	items, found := s.items[params[1]]
+	if !found {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (s *Server) character(
+	w http.ResponseWriter,
+	r *http.Request,
+	params []int32,
+) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	char, found := s.characters[params[0]]
+	if !found {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	char.AllianceId = s.corporations[char.CorporationId].AllianceId
+	writeJSON(w, http.StatusOK, char)
+}
+
+func (s *Server) corporation(
+	w http.ResponseWriter,
+	r *http.Request,
+	params []int32,
+) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	corp, found := s.corporations[params[0]]
+	if !found {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, corp)
+}
+
+// affiliation returns the affiliations of the known characters requested
+func (s *Server) affiliation(
+	w http.ResponseWriter,
+	r *http.Request,
+	params []int32,
+) {
+	ids := []int32{}
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	affiliations := []esi.PostCharactersAffiliation200Ok{}
+	for _, id := range ids {
+		char, found := s.characters[id]
+		if !found {
+			continue
+		}
+		affiliations = append(affiliations, esi.PostCharactersAffiliation200Ok{
+			CharacterId:   id,
+			CorporationId: char.CorporationId,
+			AllianceId:    s.corporations[char.CorporationId].AllianceId,
+		})
+	}
+	writeJSON(w, http.StatusOK, affiliations)
+}
+
+// universeNames returns the names of the IDs, 404 if any is unknown as ESI
+// does
+func (s *Server) universeNames(
+	w http.ResponseWriter,
+	r *http.Request,
+	params []int32,
+) {
+	ids := []int32{}
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	names := []esi.PostUniverseNames200Ok{}
+	for _, id := range ids {
+		name, found := s.names[id]
+		if !found {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		names = append(names, name)
+	}
+	writeJSON(w, http.StatusOK, names)
+}
+
+// structure returns the structure of the path, 403 if it is not added as
+// ESI responds to characters without docking access
+func (s *Server) structure(
+	w http.ResponseWriter,
+	r *http.Request,
+	params []int32,
+) {
+	path := versionPrefix.ReplaceAllString(r.URL.Path, "/")
+	structureID, err := strconv.ParseInt(
+		strings.Trim(strings.TrimPrefix(path, "/universe/structures/"), "/"),
+		10,
+		64,
+	)
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	structure, found := s.structures[structureID]
+	if !found {
+		writeError(w, http.StatusForbidden)
+		return
+	}
+	writeJSON(w, http.StatusOK, structure)
+}
@@ -0,0 +1,129 @@
+package esimock
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// tokenLifetime is how long issued access tokens are valid for, as SSO's
+const tokenLifetime = 20 * time.Minute
+
+// token is the character a refresh token was issued to
+type token struct {
+	charID int32
+	owner  string
+	scopes []string
+}
+
+// Token lets the refresh token be exchanged for access tokens of the
+// character, with the owner hash and scopes
+func (s *Server) Token(
+	charID int32,
+	owner string,
+	refreshToken string,
+	scopes ...string,
+) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tokens[refreshToken] = &token{charID: charID, owner: owner, scopes: scopes}
+}
+
+// newKey generates the key signing access tokens
+func newKey(t testing.TB) jose.JSONWebKey {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %+v", err)
+	}
+	return jose.JSONWebKey{
+		Key:       priv,
+		KeyID:     "JWT-Signature-Key",
+		Algorithm: string(jose.RS256),
+	}
+}
+
+// refresh exchanges a refresh token for a signed access token, responding
+// invalid_grant as SSO does for unknown refresh tokens
+func (s *Server) refresh(
+	w http.ResponseWriter,
+	r *http.Request,
+	params []int32,
+) {
+	if err := r.ParseForm(); err != nil ||
+		r.PostForm.Get("grant_type") != "refresh_token" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "unsupported_grant_type",
+		})
+		return
+	}
+
+	refreshToken := r.PostForm.Get("refresh_token")
+	s.lock.Lock()
+	tok, found := s.tokens[refreshToken]
+	s.lock.Unlock()
+	if !found {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":             "invalid_grant",
+			"error_description": "Invalid refresh token. Token missing/expired.",
+		})
+		return
+	}
+
+	accessToken, err := s.sign(tok)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(tokenLifetime.Seconds()),
+		"refresh_token": refreshToken,
+	})
+}
+
+// sign returns an access token of the character
+func (s *Server) sign(tok *token) (string, error) {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: s.key},
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"iss":   "login.eveonline.com",
+		"sub":   fmt.Sprintf("CHARACTER:EVE:%d", tok.charID),
+		"owner": tok.owner,
+		"aud":   []string{ClientID, "EVE Online"},
+		"exp":   time.Now().Add(tokenLifetime).Unix(),
+		"scp":   tok.scopes,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return jws.CompactSerialize()
+}
+
+// jwks publishes the public key signing access tokens
+func (s *Server) jwks(
+	w http.ResponseWriter,
+	r *http.Request,
+	params []int32,
+) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{s.key.Public()},
+	})
+}
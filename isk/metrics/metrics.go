@@ -0,0 +1,224 @@
+// Package metrics keeps process wide counters, gauges and histograms, served
+// in the
+// Prometheus text exposition format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is anything we can write out
+type metric interface {
+	write(w io.Writer, name string)
+}
+
+// entry is a registered metric with its type and help text
+type entry struct {
+	kind, help string
+	metric     metric
+}
+
+// registered holds every metric by name
+var (
+	lock       = &sync.Mutex{}
+	registered = map[string]*entry{}
+)
+
+// register adds the metric, names must be unique
+func register(name, kind, description string, m metric) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, found := registered[name]; found {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+
+	registered[name] = &entry{kind: kind, help: description, metric: m}
+}
+
+// Counter is a value which only goes up
+type Counter struct {
+	value int64
+}
+
+// NewCounter registers a new counter
+func NewCounter(name, description string) *Counter {
+	c := &Counter{}
+	register(name, "counter", description, c)
+	return c
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds n to the counter
+func (c *Counter) Add(n int64) {
+	atomic.AddInt64(&c.value, n)
+}
+
+// Value returns the current count
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.value)
+}
+
+func (c *Counter) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %d\n", name, c.Value())
+}
+
+// Gauge is a value which can go up and down
+type Gauge struct {
+	bits uint64
+}
+
+// NewGauge registers a new gauge
+func NewGauge(name, description string) *Gauge {
+	g := &Gauge{}
+	register(name, "gauge", description, g)
+	return g
+}
+
+// Set replaces the value of the gauge
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+func (g *Gauge) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %g\n", name, g.Value())
+}
+
+// DefaultBuckets are the upper bounds of histogram buckets in seconds, from
+// 1ms to 10s
+var DefaultBuckets = []float64{
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// HistogramVec counts observations into buckets, by the value of one label
+type HistogramVec struct {
+	label   string
+	buckets []float64
+
+	lock   sync.Mutex
+	series map[string]*histogram
+}
+
+// histogram is the observations of one label value
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+// NewHistogramVec registers a new histogram of the label, with the sorted
+// upper bounds of its buckets
+func NewHistogramVec(
+	name, description, label string,
+	buckets []float64,
+) *HistogramVec {
+	h := &HistogramVec{
+		label:   label,
+		buckets: buckets,
+		series:  map[string]*histogram{},
+	}
+	register(name, "histogram", description, h)
+	return h
+}
+
+// Observe adds the value to the histogram of the label value
+func (h *HistogramVec) Observe(value string, v float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	series, found := h.series[value]
+	if !found {
+		series = &histogram{counts: make([]int64, len(h.buckets))}
+		h.series[value] = series
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += v
+}
+
+// Count returns the number of observations of the label value
+func (h *HistogramVec) Count(value string) int64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if series, found := h.series[value]; found {
+		return series.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w io.Writer, name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	values := []string{}
+	for value := range h.series {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	for _, value := range values {
+		series := h.series[value]
+		label := fmt.Sprintf("%s=%q", h.label, value)
+		for i, bound := range h.buckets {
+			fmt.Fprintf(
+				w,
+				"%s_bucket{%s,le=\"%g\"} %d\n",
+				name,
+				label,
+				bound,
+				series.counts[i],
+			)
+		}
+		fmt.Fprintf(
+			w,
+			"%s_bucket{%s,le=\"+Inf\"} %d\n",
+			name,
+			label,
+			series.count,
+		)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", name, label, series.sum)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, label, series.count)
+	}
+}
+
+// Handler writes every registered metric
+func Handler(w http.ResponseWriter, r *http.Request) {
+	lock.Lock()
+	names := []string{}
+	entries := map[string]*entry{}
+	for name, e := range registered {
+		names = append(names, name)
+		entries[name] = e
+	}
+	lock.Unlock()
+
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Header().Set("Cache-Control", "no-store")
+
+	for _, name := range names {
+		e := entries[name]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, e.help, name, e.kind)
+		e.metric.write(w, name)
+	}
+}
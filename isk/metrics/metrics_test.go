@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	c := NewCounter("test_requests_total", "Test requests")
+	g := NewGauge("test_temperature", "Test temperature")
+
+	c.Inc()
+	c.Add(2)
+	g.Set(1.5)
+
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := w.Body.String()
+	for _, expected := range []string{
+		"# HELP test_requests_total Test requests\n",
+		"# TYPE test_requests_total counter\n",
+		"test_requests_total 3\n",
+		"# TYPE test_temperature gauge\n",
+		"test_temperature 1.5\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("metrics missing %q:\n%s", expected, body)
+		}
+	}
+
+	if strings.Index(body, "test_requests_total") > strings.Index(body, "test_temperature") {
+		t.Error("metrics are not sorted by name")
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	NewCounter("test_duplicate", "Duplicate")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a duplicate metric did not panic")
+		}
+	}()
+	NewCounter("test_duplicate", "Duplicate")
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := NewHistogramVec(
+		"test_duration_seconds",
+		"Test durations",
+		"name",
+		[]float64{0.1, 1},
+	)
+
+	h.Observe("b", 0.05)
+	h.Observe("b", 0.5)
+	h.Observe("b", 2)
+	h.Observe("a", 1)
+
+	if count := h.Count("b"); count != 3 {
+		t.Errorf("expected 3 observations, received %d", count)
+	}
+
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := w.Body.String()
+	for _, expected := range []string{
+		"# TYPE test_duration_seconds histogram\n",
+		`test_duration_seconds_bucket{name="b",le="0.1"} 1` + "\n",
+		`test_duration_seconds_bucket{name="b",le="1"} 2` + "\n",
+		`test_duration_seconds_bucket{name="b",le="+Inf"} 3` + "\n",
+		`test_duration_seconds_sum{name="b"} 2.55` + "\n",
+		`test_duration_seconds_count{name="b"} 3` + "\n",
+		`test_duration_seconds_bucket{name="a",le="0.1"} 0` + "\n",
+		`test_duration_seconds_bucket{name="a",le="1"} 1` + "\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("metrics missing %q:\n%s", expected, body)
+		}
+	}
+
+	if strings.Index(body, `name="a"`) > strings.Index(body, `name="b"`) {
+		t.Error("histogram labels are not sorted")
+	}
+}
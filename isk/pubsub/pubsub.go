@@ -0,0 +1,134 @@
+// Package pubsub wakes requests waiting on characters when they're updated,
+// such as long polls of their donations, and passes them events which are
+// never stored
+package pubsub
+
+import (
+	"errors"
+	"sync"
+)
+
+// maxEvents is how many events a subscriber may have waiting, further ones
+// are dropped until it receives them
+const maxEvents = 4
+
+// ErrTooManySubscribers is returned when the character already has as many
+// subscribers as are allowed
+var ErrTooManySubscribers = errors.New("too many subscribers")
+
+// Broker passes character updates to their subscribers
+type Broker struct {
+	// max subscribers of each character
+	max int
+
+	lock sync.Mutex
+	subs map[int32]map[*Subscription]bool
+}
+
+// Subscription receives on C after each update of its character. Updates
+// arriving before the last was received are merged into one. Events sent to
+// the character are received on Events, each one of them
+type Subscription struct {
+	C      <-chan struct{}
+	Events <-chan interface{}
+
+	charID int32
+	c      chan struct{}
+	events chan interface{}
+}
+
+// New returns a broker allowing up to max subscribers of each character
+func New(max int) *Broker {
+	return &Broker{max: max, subs: map[int32]map[*Subscription]bool{}}
+}
+
+// Subscribe to updates of the character, Unsubscribe when done
+func (b *Broker) Subscribe(charID int32) (*Subscription, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	subs, found := b.subs[charID]
+	if !found {
+		subs = map[*Subscription]bool{}
+		b.subs[charID] = subs
+	}
+	if len(subs) >= b.max {
+		return nil, ErrTooManySubscribers
+	}
+
+	c := make(chan struct{}, 1)
+	events := make(chan interface{}, maxEvents)
+	s := &Subscription{
+		C:      c,
+		Events: events,
+		charID: charID,
+		c:      c,
+		events: events,
+	}
+	subs[s] = true
+	return s, nil
+}
+
+// Unsubscribe stops updates to the subscription
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	subs := b.subs[s.charID]
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(b.subs, s.charID)
+	}
+}
+
+// Subscribers returns how many subscribers the character has
+func (b *Broker) Subscribers(charID int32) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.subs[charID])
+}
+
+// Publish an update of the characters to their subscribers
+func (b *Broker) Publish(charIDs ...int32) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, charID := range charIDs {
+		for s := range b.subs[charID] {
+			s.notify()
+		}
+	}
+}
+
+// Send the event to the subscribers of the character
+func (b *Broker) Send(charID int32, event interface{}) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for s := range b.subs[charID] {
+		select {
+		case s.events <- event:
+		default:
+		}
+	}
+}
+
+// PublishAll updates every subscriber, for when updates may have been missed
+func (b *Broker) PublishAll() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, subs := range b.subs {
+		for s := range subs {
+			s.notify()
+		}
+	}
+}
+
+// notify wakes the subscriber, unless it's already due to wake
+func (s *Subscription) notify() {
+	select {
+	case s.c <- struct{}{}:
+	default:
+	}
+}
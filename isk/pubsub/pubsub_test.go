@@ -0,0 +1,106 @@
+package pubsub
+
+import "testing"
+
+// woken is true if the subscription received an update
+func woken(s *Subscription) bool {
+	select {
+	case <-s.C:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestPublish(t *testing.T) {
+	b := New(10)
+	one, err := b.Subscribe(1)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %+v", err)
+	}
+	two, err := b.Subscribe(2)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %+v", err)
+	}
+
+	// updates before the subscriber wakes are merged
+	b.Publish(1, 3)
+	b.Publish(1)
+	if !woken(one) || woken(one) {
+		t.Error("expected the subscriber of 1 to wake once")
+	}
+	if woken(two) {
+		t.Error("expected the subscriber of 2 to sleep")
+	}
+
+	b.PublishAll()
+	if !woken(one) || !woken(two) {
+		t.Error("expected every subscriber to wake")
+	}
+
+	b.Unsubscribe(one)
+	b.Publish(1)
+	if woken(one) {
+		t.Error("expected no updates after unsubscribing")
+	}
+	if b.Subscribers(1) != 0 || b.Subscribers(2) != 1 {
+		t.Errorf("unexpected subscribers %+v", b.subs)
+	}
+}
+
+func TestSubscribeLimit(t *testing.T) {
+	b := New(2)
+	subs := []*Subscription{}
+	for i := 0; i < 2; i++ {
+		s, err := b.Subscribe(1)
+		if err != nil {
+			t.Fatalf("failed to subscribe: %+v", err)
+		}
+		subs = append(subs, s)
+	}
+
+	if _, err := b.Subscribe(1); err != ErrTooManySubscribers {
+		t.Errorf("expected ErrTooManySubscribers, received %+v", err)
+	}
+	if _, err := b.Subscribe(2); err != nil {
+		t.Errorf("expected other characters to subscribe, received %+v", err)
+	}
+
+	b.Unsubscribe(subs[0])
+	if _, err := b.Subscribe(1); err != nil {
+		t.Errorf("expected a freed subscription, received %+v", err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	b := New(10)
+	one, err := b.Subscribe(1)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %+v", err)
+	}
+	two, err := b.Subscribe(2)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %+v", err)
+	}
+
+	// events are each received, until too many are waiting
+	for i := 0; i < maxEvents+1; i++ {
+		b.Send(1, i)
+	}
+	for i := 0; i < maxEvents; i++ {
+		if event := <-one.Events; event != i {
+			t.Errorf("expected event %d, received %v", i, event)
+		}
+	}
+	select {
+	case event := <-one.Events:
+		t.Errorf("expected the last event dropped, received %v", event)
+	default:
+	}
+	if woken(one) {
+		t.Error("expected events not to wake the subscriber")
+	}
+	if len(two.Events) != 0 {
+		t.Error("expected no events sent to the subscriber of 2")
+	}
+}
@@ -0,0 +1,77 @@
+package isk
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// tlsConfig loads the certificate with modern protocol and cipher defaults
+func tlsConfig(opts *cx.Options) (*tls.Config, error) {
+	if opts.TLSCert == "" || opts.TLSKey == "" {
+		return nil, errors.New("both -tls-cert and -tls-key are required")
+	}
+
+	cert, err := tls.LoadX509KeyPair(opts.TLSCert, opts.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{
+			tls.X25519,
+			tls.CurveP256,
+		},
+		// only used by TLS 1.2, every TLS 1.3 suite is acceptable
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+	}, nil
+}
+
+// redirectPlaintext serves redirects to HTTPS on the redirect address
+func redirectPlaintext(opts *cx.Options) {
+	server := &http.Server{
+		Addr:              opts.RedirectListen,
+		Handler:           redirectHandler(opts.Listen),
+		ReadHeaderTimeout: seconds(opts.ReadHeaderTimeout),
+		ReadTimeout:       seconds(opts.ReadTimeout),
+		WriteTimeout:      seconds(opts.WriteTimeout),
+		IdleTimeout:       seconds(opts.IdleTimeout),
+		MaxHeaderBytes:    opts.MaxHeaderBytes,
+	}
+	log.Fatal(server.ListenAndServe())
+}
+
+// redirectHandler redirects to the same host and path, over HTTPS on the
+// port of the listen address
+func redirectHandler(listen string) http.HandlerFunc {
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil || port == "443" || port == "https" {
+		port = ""
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	}
+}
@@ -0,0 +1,44 @@
+package isk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	for _, c := range []struct {
+		listen, host, target, expected string
+	}{
+		{":443", "example.com", "/api/top", "https://example.com/api/top"},
+		{":443", "example.com:80", "/?a=1", "https://example.com/?a=1"},
+		{":8443", "example.com:8080", "/c/nick", "https://example.com:8443/c/nick"},
+		{"0.0.0.0:8443", "[::1]:8080", "/", "https://[::1]:8443/"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, c.target, nil)
+		r.Host = c.host
+		w := httptest.NewRecorder()
+		redirectHandler(c.listen)(w, r)
+
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("%s%s: expected 301, received %d", c.host, c.target, w.Code)
+		}
+		if location := w.Header().Get("Location"); location != c.expected {
+			t.Errorf("%s%s: received %q, expected %q", c.host, c.target, location, c.expected)
+		}
+	}
+}
+
+func TestTLSConfigRequiresPair(t *testing.T) {
+	for _, opts := range []*cx.Options{
+		{TLSCert: "cert.pem"},
+		{TLSKey: "key.pem"},
+		{TLSCert: "missing.pem", TLSKey: "missing.pem"},
+	} {
+		if _, err := tlsConfig(opts); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+}
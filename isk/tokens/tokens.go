@@ -0,0 +1,86 @@
+package tokens
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// prefix marks encrypted values, followed by base64 of nonce+ciphertext
+const prefix = "v1:"
+
+var (
+	// ErrWrongKey is returned when a token fails decryption with our key
+	ErrWrongKey = errors.New("failed to decrypt token, is the token key correct?")
+
+	// ErrNotEncrypted is returned when decrypting a plaintext token
+	ErrNotEncrypted = errors.New("token is not encrypted, run encrypt-tokens")
+)
+
+// Key derives a 256 bit AES key from the secret
+func Key(secret string) []byte {
+	sum := sha256.Sum256([]byte("esi-isk refresh token key:" + secret))
+	return sum[:]
+}
+
+// IsEncrypted returns true if the value was returned from Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt seals the token with AES-GCM under the key
+func Encrypt(key []byte, token string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(token), nil)
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a token sealed by Encrypt under the same key
+func Decrypt(key []byte, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return "", ErrNotEncrypted
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(value[len(prefix):])
+	if err != nil {
+		return "", ErrWrongKey
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", ErrWrongKey
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	token, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrWrongKey
+	}
+
+	return string(token), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
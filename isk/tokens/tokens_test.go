@@ -0,0 +1,56 @@
+package tokens
+
+import "testing"
+
+func TestRoundTrip(t *testing.T) {
+	key := Key("secret")
+	sealed, err := Encrypt(key, "refresh-token")
+	if err != nil {
+		t.Fatalf("failed to encrypt: %+v", err)
+	}
+
+	if !IsEncrypted(sealed) {
+		t.Errorf("encrypted value not marked as encrypted: %s", sealed)
+	}
+
+	again, err := Encrypt(key, "refresh-token")
+	if err != nil {
+		t.Fatalf("failed to encrypt: %+v", err)
+	}
+	if again == sealed {
+		t.Error("nonce was reused between encryptions")
+	}
+
+	token, err := Decrypt(key, sealed)
+	if err != nil {
+		t.Fatalf("failed to decrypt: %+v", err)
+	}
+	if token != "refresh-token" {
+		t.Errorf("invalid token. received %q, expected %q", token, "refresh-token")
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	sealed, err := Encrypt(Key("secret"), "refresh-token")
+	if err != nil {
+		t.Fatalf("failed to encrypt: %+v", err)
+	}
+
+	token, err := Decrypt(Key("not-the-secret"), sealed)
+	if err != ErrWrongKey {
+		t.Errorf("expected ErrWrongKey, received %+v", err)
+	}
+	if token != "" {
+		t.Errorf("wrong key produced a token: %q", token)
+	}
+
+	if _, err := Decrypt(Key("secret"), sealed[:len(sealed)-4]); err != ErrWrongKey {
+		t.Errorf("truncated value: expected ErrWrongKey, received %+v", err)
+	}
+}
+
+func TestNotEncrypted(t *testing.T) {
+	if _, err := Decrypt(Key("secret"), "plaintext-token"); err != ErrNotEncrypted {
+		t.Errorf("expected ErrNotEncrypted, received %+v", err)
+	}
+}
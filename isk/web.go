@@ -7,19 +7,18 @@ import (
 	"net/http"
 	"time"
 
-	sessions "github.com/goincremental/negroni-sessions"
-	"github.com/goincremental/negroni-sessions/cookiestore"
-	"github.com/phyber/negroni-gzip/gzip"
 	"github.com/rs/cors"
 	"github.com/unrolled/secure"
 	"github.com/urfave/negroni"
-	cache "github.com/victorspringer/http-cache"
-	"github.com/victorspringer/http-cache/adapter/memory"
 	"gopkg.in/tylerb/graceful.v1"
 
 	"github.com/a-tal/esi-isk/isk/api"
+	"github.com/a-tal/esi-isk/isk/cache"
+	"github.com/a-tal/esi-isk/isk/compress"
 	"github.com/a-tal/esi-isk/isk/cx"
 	"github.com/a-tal/esi-isk/isk/db"
+	"github.com/a-tal/esi-isk/isk/metrics"
+	"github.com/a-tal/esi-isk/isk/pubsub"
 	"github.com/a-tal/esi-isk/isk/worker"
 )
 
@@ -35,36 +34,12 @@ func getAllowed(options *cx.Options) []string {
 	}
 }
 
-func getCache(ctx context.Context) (*cache.Client, cache.Adapter) {
-	opts := ctx.Value(cx.Opts).(*cx.Options)
-
-	adapter, err := memory.NewAdapter(
-		memory.AdapterWithAlgorithm(memory.LRU),
-		memory.AdapterWithCapacity(opts.CacheResp),
-	)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	client, err := cache.NewClient(
-		cache.ClientWithAdapter(adapter),
-		cache.ClientWithTTL(time.Duration(opts.CacheTime)*time.Second),
-	)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return client, adapter
-}
-
 // RunServer creates and runs the backend API server
 func RunServer(ctx context.Context) {
 
 	opts := ctx.Value(cx.Opts).(*cx.Options)
 
-	ctx = context.WithValue(ctx, cx.DB, db.Connect(ctx))
-	ctx = context.WithValue(ctx, cx.Statements, db.GetStatements(ctx))
-	ctx = context.WithValue(ctx, cx.StateStore, api.NewStateStore())
+	ctx = db.OpenReplica(db.Open(ctx))
 
 	if err := InitialSetup(ctx); err != nil {
 		log.Fatalf("failed to initialize db: %+v", err)
@@ -72,21 +47,176 @@ func RunServer(ctx context.Context) {
 
 	mux := http.NewServeMux()
 
-	respCache, adapter := getCache(ctx)
-	ctx = context.WithValue(ctx, cx.Adapter, adapter)
+	respCache := cache.NewWithStore(
+		responseStore(opts),
+		time.Duration(opts.CacheTime)*time.Second,
+	)
+	respCache.SetBypass(api.LoggedIn)
+	ctx = context.WithValue(ctx, cx.ResponseCache, respCache)
+
+	updates := pubsub.New(opts.LongPollWaiters)
+	ctx = context.WithValue(ctx, cx.Updates, updates)
+
+	views := api.NewViews()
+	ctx = context.WithValue(ctx, cx.Views, views)
+	go views.Run(ctx)
+
+	warmer := api.NewWarmer(opts.WarmPages, !opts.WarmCache)
+	ctx = context.WithValue(ctx, cx.Warmer, warmer)
+
+	go db.ListenForUpdates(ctx, func(charIDs []int32) {
+		if charIDs == nil {
+			respCache.Purge()
+			updates.PublishAll()
+			return
+		}
+		updates.Publish(charIDs...)
+
+		tags := []string{cache.TopTag}
+		for _, charID := range charIDs {
+			tags = append(tags, cache.CharacterTag(charID))
+		}
+		respCache.Invalidate(tags...)
+	}, func(charIDs []int32) {
+		respCache.Invalidate(cache.SitemapTag)
+	}, func(donation *db.Donation) {
+		updates.Send(donation.Recipient, donation)
+	})
 
 	mux.HandleFunc("/api/ping", api.Ping)
+	mux.HandleFunc("/api/ready", api.Ready(ctx))
+	mux.HandleFunc("/api/version", api.Version)
+	mux.HandleFunc("/api/openapi.json", api.OpenAPI)
+	if opts.Debug {
+		mux.HandleFunc("/api/docs", api.Docs)
+	}
 	mux.Handle("/api/prefs", api.Preferences(ctx))
-	mux.Handle("/api/top", respCache.Middleware(api.TopRecipients(ctx)))
-	mux.Handle("/api/char", respCache.Middleware(api.CharacterDetails(ctx)))
-	mux.Handle("/api/custom", respCache.Middleware(api.Custom(ctx)))
+	mux.Handle("/api/prefs/preview", api.PreviewPattern(ctx))
+	mux.Handle("/api/prefs/export", api.ExportPreferences(ctx))
+	mux.Handle("/api/prefs/import", api.ImportPreferences(ctx))
+	mux.Handle("/api/prefs/privacy", api.Privacy(ctx))
+	mux.Handle("/api/prefs/slug", api.SlugPreference(ctx))
+	mux.Handle("/api/user", api.User(ctx))
+	mux.Handle("/api/user/export", api.Export(ctx))
+	mux.Handle("/api/cache", api.CacheStats(ctx))
+	mux.Handle("/api/admin/cache/purge", api.PurgeCache(ctx))
+	mux.Handle("/api/admin/cache/warm", api.WarmCache(ctx))
+	mux.Handle("/api/admin/donations/{id}/void", api.VoidDonation(ctx))
+	mux.Handle("/api/admin/donations/adjust", api.AdjustDonation(ctx))
+	mux.Handle("/api/admin/standings/{id}", api.Standing(ctx))
+	mux.Handle("/api/admin/audit", api.AuditLog(ctx))
+	mux.Handle("/api/admin/characters", api.TrackedCharacters(ctx))
+	mux.Handle("/api/admin/characters/{id}/ban", api.BanCharacter(ctx))
+	mux.Handle("/api/admin/rings", api.DonationRings(ctx))
+	mux.Handle("/api/admin/rings/{id}/review", api.ReviewRing(ctx))
+	mux.Handle("/api/top", api.Deprecated(ctx, respCache.Middleware(
+		api.TopRecipients(ctx),
+		time.Duration(opts.TopCacheTime)*time.Second,
+	), "/api/v2/top"))
+	mux.Handle("/api/v2/top", respCache.Middleware(
+		api.TopRecipientsV2(ctx),
+		time.Duration(opts.TopCacheTime)*time.Second,
+	))
+	mux.Handle("/api/leaderboard/history", respCache.Middleware(
+		api.LeaderboardHistory(ctx),
+		time.Duration(opts.TopCacheTime)*time.Second,
+	))
+	mux.Handle("/api/leaderboard/trending", respCache.Middleware(
+		api.Trending(ctx),
+		time.Duration(opts.TopCacheTime)*time.Second,
+	))
+	mux.Handle("/api/leaderboard/notable", respCache.Middleware(
+		api.NotableContracts(ctx),
+		time.Duration(opts.TopCacheTime)*time.Second,
+	))
+	mux.Handle("/api/leaderboard/corporations", respCache.Middleware(
+		api.GenerousCorporations(ctx),
+		time.Duration(opts.TopCacheTime)*time.Second,
+	))
+	mux.Handle("/api/leaderboard/alliances", respCache.Middleware(
+		api.GenerousAlliances(ctx),
+		time.Duration(opts.TopCacheTime)*time.Second,
+	))
+	mux.Handle("/api/chars", api.Deprecated(
+		ctx,
+		api.Characters(ctx),
+		"/api/v2/chars",
+	))
+	mux.Handle("/api/v2/chars", api.CharactersV2(ctx))
+	mux.Handle("/api/char", api.Deprecated(ctx, api.CountViews(
+		ctx,
+		respCache.Middleware(api.Slugs(ctx, api.CharacterDetails(ctx)), 0),
+	), "/api/v2/char"))
+	mux.Handle("/api/v2/char", api.CountViews(
+		ctx,
+		respCache.Middleware(api.Slugs(ctx, api.CharacterDetailsV2(ctx)), 0),
+	))
+	for route, handler := range map[string]http.HandlerFunc{
+		"/api/char/{id}/timeseries":     api.TimeSeries(ctx),
+		"/api/char/{id}/histogram":      api.Histogram(ctx),
+		"/api/char/{id}/supporters":     api.Supporters(ctx),
+		"/api/char/{id}/donor-corps":    api.DonorCorporations(ctx),
+		"/api/char/{id}/from/{donorID}": api.TransfersBetween(ctx),
+		"/api/v2/char/{id}/donations":   api.DonationsV2(ctx),
+		"/api/v2/char/{id}/contracts":   api.ContractsV2(ctx),
+		"/api/v2/char/{id}/activity":    api.ActivityV2(ctx),
+	} {
+		mux.Handle(route, respCache.Middleware(api.Slugs(ctx, handler), 0))
+	}
+	// long polls are held until there's something new, they aren't cached
+	mux.Handle(
+		"/api/char/{id}/donations",
+		api.Slugs(ctx, api.NewDonations(ctx)),
+	)
+	mux.Handle("/api/char/{id}/refresh", api.Refresh(ctx))
+	mux.Handle("/api/char/{id}/test-donation", api.TestDonation(ctx))
+	mux.Handle("/api/char/{id}/refresh/{job}", api.RefreshStatus(ctx))
+	mux.Handle("/api/donation/{id}", respCache.Middleware(api.Donation(ctx), 0))
+	mux.Handle("/api/contract/{id}", respCache.Middleware(api.Contract(ctx), 0))
+	mux.Handle("/api/verify", api.Verify(ctx))
+	mux.Handle("/api/compare", respCache.Middleware(api.Compare(ctx), 0))
+	mux.Handle("/api/custom", respCache.Middleware(
+		api.Slugs(ctx, api.Custom(ctx)),
+		0,
+	))
+	mux.Handle("/widget/{id}", respCache.Middleware(
+		api.Slugs(ctx, api.Widget(ctx)),
+		0,
+	))
+	mux.HandleFunc("/c/{slug}", api.SlugPage(ctx))
+	mux.HandleFunc("/char/{id}", api.CharacterPage(ctx))
+	mux.Handle("/sitemap.xml", respCache.Middleware(
+		api.Sitemap(ctx),
+		time.Duration(opts.SitemapCacheTime)*time.Second,
+	))
+
+	mux.HandleFunc("/metrics", metrics.Handler)
 
 	mux.HandleFunc("/signup", api.NewLogin(ctx))
 	mux.HandleFunc("/callback", api.Callback(ctx))
+	mux.HandleFunc("/logout", api.Logout(ctx))
+
+	warmer.SetHandler(mux)
+	if opts.WarmCache {
+		go func() {
+			cached, err := warmer.Warm(ctx)
+			if err != nil {
+				log.Printf("failed to warm the cache: %+v", err)
+			}
+			log.Printf("warmed %d cached responses", cached)
+		}()
+	}
+
+	logger := negroni.NewLogger()
+	logger.SetFormat(
+		`{{.StartTime}} | {{.Request.Header.Get "X-Request-ID"}} | {{.Status}} | ` +
+			`{{.Duration}} | {{.Hostname}} | {{.Method}} {{.Path}}`,
+	)
 
 	middleware := negroni.New(
-		negroni.NewRecovery(),
-		negroni.NewLogger(),
+		negroni.HandlerFunc(api.RequestIDs(ctx)),
+		logger,
+		negroni.HandlerFunc(api.Recover(ctx)),
 
 		negroni.HandlerFunc(secure.New(secure.Options{
 			FrameDeny:       true,
@@ -94,8 +224,9 @@ func RunServer(ctx context.Context) {
 			STSSeconds:      315360000,
 			IsDevelopment:   opts.Debug,
 			ContentSecurityPolicy: "default-src 'self' script-src 'unsafe-inline' " +
-				"img-src 'self' imageserver.eveonline.com",
+				"img-src 'self' imageserver.eveonline.com " + opts.ImageServer,
 		}).HandlerFuncWithNext),
+		negroni.HandlerFunc(api.Embeddable),
 
 		cors.New(cors.Options{
 			AllowedOrigins:         getAllowed(opts),
@@ -104,29 +235,74 @@ func RunServer(ctx context.Context) {
 			Debug:                  opts.Debug,
 		}),
 
-		gzip.Gzip(gzip.DefaultCompression),
+		negroni.HandlerFunc(compress.Middleware),
 
 		negroni.NewStatic(http.Dir("public")),
 	)
 
-	store := cookiestore.New([]byte(opts.AppSecret))
-	middleware.Use(sessions.Sessions("esi-isk", store))
+	middleware.Use(negroni.HandlerFunc(api.Sessions(ctx)))
+	middleware.Use(negroni.HandlerFunc(api.RateLimit(ctx)))
 
 	middleware.UseHandler(mux)
 
 	server := &graceful.Server{
 		Timeout: 10 * time.Second,
 		Server: &http.Server{
-			Addr:              fmt.Sprintf(":%d", opts.Port),
-			Handler:           middleware,
-			ReadTimeout:       1 * time.Second,
-			WriteTimeout:      5 * time.Second,
-			ReadHeaderTimeout: 1 * time.Second,
-			MaxHeaderBytes:    1 << 20,
+			Addr: opts.Listen,
+			Handler: api.Streaming(
+				ctx,
+				middleware,
+				"/api/user/export",
+				"/api/char/{id}/donations",
+			),
+			ReadHeaderTimeout: seconds(opts.ReadHeaderTimeout),
+			ReadTimeout:       seconds(opts.ReadTimeout),
+			WriteTimeout:      seconds(opts.WriteTimeout),
+			IdleTimeout:       seconds(opts.IdleTimeout),
+			MaxHeaderBytes:    opts.MaxHeaderBytes,
 		},
 	}
 
-	log.Fatal(server.ListenAndServe())
+	if opts.TLSCert == "" && opts.TLSKey == "" {
+		log.Fatal(server.ListenAndServe())
+	}
+
+	config, err := tlsConfig(opts)
+	if err != nil {
+		log.Fatalf("failed to load TLS certificate: %+v", err)
+	}
+
+	if opts.RedirectListen != "" {
+		go redirectPlaintext(opts)
+	}
+
+	log.Fatal(server.ListenAndServeTLSConfig(config))
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// responseStore returns the store of the -cache-backend, falling back to
+// memory if redis can't be reached
+func responseStore(opts *cx.Options) cache.Store {
+	switch opts.CacheBackend {
+	case "memory":
+		return cache.NewMemory(opts.CacheResp)
+	case "redis":
+		store, err := cache.NewRedis(opts.RedisAddr, opts.RedisPassword)
+		if err == nil {
+			return store
+		}
+		log.Printf(
+			"Warning: redis at %s is unreachable, caching responses in memory: %+v",
+			opts.RedisAddr,
+			err,
+		)
+		return cache.NewMemory(opts.CacheResp)
+	}
+	log.Fatalf("unknown -cache-backend %q", opts.CacheBackend)
+	return nil
 }
 
 // InitialSetup ensures the owning character exists in the db
@@ -166,7 +342,10 @@ func InitialSetup(ctx context.Context) error {
 		Corporation: &db.Name{ID: corp, Name: corpName},
 	}
 
-	alliance, allianceName := worker.ResolveCorporation(ctx, corp)
+	alliance, allianceName, err := worker.ResolveCorporation(ctx, corp)
+	if err != nil {
+		return err
+	}
 	if alliance > 0 {
 		aff.Alliance = &db.Name{ID: alliance, Name: allianceName}
 	}
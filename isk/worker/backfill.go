@@ -0,0 +1,258 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/antihax/goesi"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// backfillTimeout is how long the backfill subcommand may take
+const backfillTimeout = 30 * time.Minute
+
+// RunBackfill re-runs the backfill of the character
+func RunBackfill(ctx context.Context, charID int32) error {
+	ctx = Context(ctx)
+
+	// claimed, so no worker polls the character during the backfill
+	user, err := db.ClaimUser(ctx, charID)
+	if err != nil {
+		return err
+	}
+
+	backfillCtx, cancel := context.WithTimeout(ctx, backfillTimeout)
+	defer cancel()
+
+	authCtx, err := addCharacterAuth(backfillCtx, user)
+	if err == nil {
+		var charIDs []int32
+		charIDs, err = backfillCharacter(authCtx, user)
+		updateStandings(ctx, charIDs)
+	}
+
+	if sErr := db.SavePollResult(ctx, user.CharacterID, err); sErr != nil {
+		log.Printf("failed to release character %d: %+v", user.CharacterID, sErr)
+	}
+	return err
+}
+
+// backfillCharacter saves every donation and contract of the character ESI
+// still has, oldest first, skipping those already stored
+func backfillCharacter(ctx context.Context, user *db.User) ([]int32, error) {
+	log.Printf("backfilling character: %d", user.CharacterID)
+
+	saveWallet, err := backfillWallet(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("pulled character wallet history: %d", user.CharacterID)
+
+	saveContracts, err := backfillContracts(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("pulled character contract history: %d", user.CharacterID)
+
+	// the backfill is saved whole with the user's last IDs, or not at all and
+	// run again by the next poll. Rows stored by a partial backfill would be
+	// skipped by the next one, and never counted
+	charIDs := []int32{}
+	saved := []*db.Contract{}
+	if err := db.WithTx(ctx, func(ctx context.Context) error {
+		// held until commit, as the regular poll does
+		unlock, err := db.LockTotals(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		walletCharIDs, err := saveWallet(ctx)
+		if err != nil {
+			return err
+		}
+		contractCharIDs, contracts, err := saveContracts(ctx)
+		if err != nil {
+			return err
+		}
+		if err := db.SaveUser(ctx, user); err != nil {
+			return err
+		}
+		if err := db.MarkBackfilled(ctx, user.CharacterID); err != nil {
+			return err
+		}
+
+		charIDs = append(walletCharIDs, contractCharIDs...)
+		saved = contracts
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	log.Printf("backfilled character: %d", user.CharacterID)
+
+	resolveLocations(ctx, saved)
+	return charIDs, nil
+}
+
+// backfillWallet pulls the character's whole wallet journal, returning the
+// function saving the donations and refunds not already stored, and the
+// characters involved in them
+func backfillWallet(
+	ctx context.Context,
+	user *db.User,
+) (func(context.Context) ([]int32, error), error) {
+	entries, err := getFullWalletJournal(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(entries)
+
+	// every donation and refund, not only those since the last journal ID
+	everything := &db.User{CharacterID: user.CharacterID}
+	donations := parseForDonations(entries, everything)
+	refunds := parseForRefunds(entries, everything)
+	setLastJournalID(entries, user)
+
+	// resolved before saving, which stores only those not already stored
+	affiliations := getNames(ctx, donations)
+
+	return func(ctx context.Context) ([]int32, error) {
+		if err := db.MarkSelfDonations(ctx, donations); err != nil {
+			return nil, err
+		}
+		saved, err := db.CopyNewDonations(ctx, donations)
+		if err != nil {
+			return nil, err
+		}
+		if len(saved) == 0 && len(refunds) == 0 {
+			return nil, nil
+		}
+
+		charIDs := []int32{user.CharacterID}
+		for _, donation := range saved {
+			charIDs = append(charIDs, donation.Donator)
+		}
+		for _, refund := range refunds {
+			charIDs = append(charIDs, refund.Donator)
+		}
+
+		// donations refunded before they were seen are never counted
+		counted, err := db.MatchRefunds(ctx, saved)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := db.SaveNames(ctx, affiliations); err != nil {
+			return nil, err
+		}
+		if err := db.SaveCharacterDonations(
+			ctx,
+			counted,
+			affiliations,
+		); err != nil {
+			return nil, err
+		}
+
+		// refunds already stored are skipped
+		return charIDs, db.SaveRefunds(ctx, refunds)
+	}, nil
+}
+
+// backfillContracts pulls every contract of the character, returning the
+// function saving the zero ISK ones not already stored, and the characters
+// involved in them
+func backfillContracts(
+	ctx context.Context,
+	user *db.User,
+) (func(context.Context) ([]int32, []*db.Contract, error), error) {
+	contracts, err := getAllContracts(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(contracts)
+	markExpired(contracts, time.Now())
+
+	// every zero ISK contract is new to a backfill, status updates of tracked
+	// contracts are left to the next poll
+	all, _ := parseForZeroISK(contracts, 0, map[int32]*db.Contract{})
+	setLastContractID(contracts, user)
+
+	zeroISK := db.Contracts(asDbContracts(ctx, all))
+	affiliations := getContractNames(ctx, zeroISK)
+
+	return func(ctx context.Context) ([]int32, []*db.Contract, error) {
+		saved, err := db.CopyNewContracts(ctx, zeroISK)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(saved) == 0 {
+			return nil, nil, nil
+		}
+
+		charIDs := []int32{user.CharacterID}
+		involved := db.Contracts{}
+		for _, contract := range saved {
+			charIDs = append(charIDs, contract.Donator)
+			involved = append(involved, contract)
+		}
+
+		if err := db.SaveNames(ctx, affiliations); err != nil {
+			return nil, nil, err
+		}
+		return charIDs, saved, db.SaveCharacterContracts(
+			ctx,
+			involved,
+			affiliations,
+		)
+	}, nil
+}
+
+// getFullWalletJournal returns every page of the character's wallet journal
+func getFullWalletJournal(
+	ctx context.Context,
+	user *db.User,
+) (walletDonationEntries, error) {
+	client := ctx.Value(cx.Client).(*goesi.APIClient)
+	entries, r, err := client.ESI.WalletApi.GetCharactersCharacterIdWalletJournal(
+		ctx,
+		user.CharacterID,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	additional, err := expandWalletJournal(ctx, user, r)
+	if err != nil {
+		return nil, err
+	}
+	return append(entries, additional...), nil
+}
+
+// getAllContracts returns every page of the character's contracts
+func getAllContracts(ctx context.Context, user *db.User) (
+	zeroISKContracts,
+	error,
+) {
+	client := ctx.Value(cx.Client).(*goesi.APIClient)
+	entries, r, err := client.ESI.ContractsApi.GetCharactersCharacterIdContracts(
+		ctx,
+		user.CharacterID,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	additional, err := expandContracts(ctx, user, r)
+	if err != nil {
+		return nil, err
+	}
+	return append(entries, additional...), nil
+}
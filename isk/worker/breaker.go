@@ -0,0 +1,138 @@
+package worker
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/metrics"
+)
+
+// errCircuitOpen is returned for requests refused while ESI is failing
+var errCircuitOpen = errors.New("ESI circuit is open")
+
+// states of the breaker, as reported by esiCircuitState
+const (
+	circuitClosed = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+// esiCircuitState is the state of the breaker around ESI
+var esiCircuitState = metrics.NewGauge(
+	"esi_isk_esi_circuit_state",
+	"State of the ESI circuit breaker, 0 closed, 1 half open, 2 open",
+)
+
+// breaker stops requests to ESI after consecutive failures across every
+// character, so a down ESI isn't sent a request per poll. Once the backoff
+// has passed, a single probe request is let through, closing the circuit if
+// it succeeds. Error limited (420) and other client error responses are
+// not failures, they are left to the caller
+type breaker struct {
+	next     http.RoundTripper
+	failures int
+	backoff  time.Duration
+	now      func() time.Time
+
+	lock     sync.Mutex
+	state    int
+	failed   int
+	openedAt time.Time
+}
+
+// newBreaker wraps next, opening after failures in a row for backoff. It
+// never opens without failures
+func newBreaker(
+	next http.RoundTripper,
+	failures int,
+	backoff time.Duration,
+) *breaker {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &breaker{
+		next:     next,
+		failures: failures,
+		backoff:  backoff,
+		now:      time.Now,
+	}
+}
+
+func (b *breaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !b.allow() {
+		return nil, errCircuitOpen
+	}
+
+	res, err := b.next.RoundTrip(req)
+	b.record(err != nil || res.StatusCode >= 500)
+	return res, err
+}
+
+// allow is true if a request may be sent, moving an open circuit past its
+// backoff to half open for this one probe request
+func (b *breaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < b.backoff {
+			return false
+		}
+		b.setState(circuitHalfOpen)
+		return true
+	case circuitHalfOpen:
+		// the probe is in flight
+		return false
+	}
+	return true
+}
+
+// record counts the result of a request
+func (b *breaker) record(failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !failed {
+		if b.state != circuitClosed {
+			log.Println("ESI is responding, closing the circuit")
+		}
+		b.failed = 0
+		b.setState(circuitClosed)
+		return
+	}
+
+	b.failed++
+	if b.state == circuitHalfOpen ||
+		(b.failures > 0 && b.failed >= b.failures && b.state == circuitClosed) {
+		log.Printf(
+			"ESI failed %d requests in a row, opening the circuit for %s",
+			b.failed,
+			b.backoff,
+		)
+		b.openedAt = b.now()
+		b.setState(circuitOpen)
+	}
+}
+
+// open is true while requests are refused, before the backoff has passed
+func (b *breaker) open() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state == circuitOpen && b.now().Sub(b.openedAt) < b.backoff
+}
+
+// current returns the state of the circuit
+func (b *breaker) current() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+func (b *breaker) setState(state int) {
+	b.state = state
+	esiCircuitState.Set(float64(state))
+}
@@ -0,0 +1,160 @@
+package worker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// roundTripFunc is a fake transport
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// testBreaker returns a breaker opening after 3 failures for a minute, with
+// a clock moved by the returned pointer and responses from status
+func testBreaker(status *int) (*breaker, *time.Time) {
+	sent := func(req *http.Request) (*http.Response, error) {
+		if *status == 0 {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: *status, Body: http.NoBody}, nil
+	}
+	b := newBreaker(roundTripFunc(sent), 3, time.Minute)
+	now := time.Date(2019, 1, 20, 12, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func breakerRequest(t *testing.T, b *breaker) error {
+	req, err := http.NewRequest("GET", "https://esi.example/latest/", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %+v", err)
+	}
+	res, err := b.RoundTrip(req)
+	if err == nil {
+		res.Body.Close()
+	}
+	return err
+}
+
+func TestBreakerOpens(t *testing.T) {
+	status := 502
+	b, now := testBreaker(&status)
+
+	for i := 0; i < 3; i++ {
+		if err := breakerRequest(t, b); err != nil {
+			t.Fatalf("request %d was refused: %+v", i, err)
+		}
+	}
+	if !b.open() {
+		t.Fatal("expected the circuit to open after 3 failures")
+	}
+	if err := breakerRequest(t, b); err != errCircuitOpen {
+		t.Errorf("expected the open circuit to refuse, received %+v", err)
+	}
+
+	// the probe after the backoff fails, opening the circuit again
+	*now = now.Add(time.Minute)
+	status = 0
+	if err := breakerRequest(t, b); err == nil || err == errCircuitOpen {
+		t.Errorf("expected the probe to be sent, received %+v", err)
+	}
+	if !b.open() {
+		t.Fatal("expected a failed probe to open the circuit")
+	}
+
+	// a successful probe closes it
+	*now = now.Add(time.Minute)
+	status = 200
+	if err := breakerRequest(t, b); err != nil {
+		t.Errorf("expected the probe to be sent, received %+v", err)
+	}
+	if state := b.current(); state != circuitClosed {
+		t.Errorf("expected the circuit to close, state is %d", state)
+	}
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	status := 500
+	b, now := testBreaker(&status)
+	for i := 0; i < 3; i++ {
+		_ = breakerRequest(t, b)
+	}
+
+	*now = now.Add(time.Minute)
+	if !b.allow() {
+		t.Fatal("expected a probe after the backoff")
+	}
+	if b.allow() {
+		t.Error("expected requests to wait for the probe")
+	}
+}
+
+func TestBreakerIgnoresClientErrors(t *testing.T) {
+	for _, status := range []int{404, 420} {
+		b, _ := testBreaker(&status)
+		for i := 0; i < 5; i++ {
+			if err := breakerRequest(t, b); err != nil {
+				t.Fatalf("%d: request %d was refused: %+v", status, i, err)
+			}
+		}
+		if state := b.current(); state != circuitClosed {
+			t.Errorf("%d: expected the circuit to stay closed", status)
+		}
+	}
+}
+
+func TestBreakerSuccessResets(t *testing.T) {
+	status := 500
+	b, _ := testBreaker(&status)
+	_ = breakerRequest(t, b)
+	_ = breakerRequest(t, b)
+	status = 200
+	_ = breakerRequest(t, b)
+	status = 500
+	_ = breakerRequest(t, b)
+	_ = breakerRequest(t, b)
+	if b.open() {
+		t.Error("expected failures to be counted in a row")
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	}), 0, time.Minute)
+	for i := 0; i < 20; i++ {
+		if err := breakerRequest(t, b); err == errCircuitOpen {
+			t.Fatalf("request %d was refused by a disabled breaker", i)
+		}
+	}
+	if b.open() {
+		t.Error("expected a disabled breaker to never open")
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	status := 500
+	b, _ := testBreaker(&status)
+
+	ready := func() int {
+		w := httptest.NewRecorder()
+		readyz(b)(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code
+	}
+
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("expected ready while closed, received %d", code)
+	}
+	for i := 0; i < 3; i++ {
+		_ = breakerRequest(t, b)
+	}
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected unready while open, received %d", code)
+	}
+}
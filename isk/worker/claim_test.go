@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// fakeUsers claims users like StmtClaimUsers, skipping claimed users
+type fakeUsers struct {
+	lock    *sync.Mutex
+	due     []int32
+	claimed map[int32]bool
+	polled  map[int32]int
+	batch   int
+}
+
+func (f *fakeUsers) claim(ctx context.Context) ([]*db.User, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	users := []*db.User{}
+	for _, charID := range f.due {
+		if len(users) == f.batch {
+			break
+		}
+		if !f.claimed[charID] {
+			f.claimed[charID] = true
+			users = append(users, &db.User{CharacterID: charID})
+		}
+	}
+	return users, nil
+}
+
+// poll records the poll, polled users stay claimed as they are no longer due
+func (f *fakeUsers) poll(user *db.User) []int32 {
+	time.Sleep(time.Millisecond)
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.polled[user.CharacterID]++
+	return []int32{user.CharacterID}
+}
+
+func TestPollCycleReplicas(t *testing.T) {
+	users := &fakeUsers{
+		lock:    &sync.Mutex{},
+		claimed: map[int32]bool{},
+		polled:  map[int32]int{},
+		batch:   4,
+	}
+	for i := int32(1); i <= 40; i++ {
+		users.due = append(users.due, i)
+	}
+
+	ctx := context.WithValue(context.Background(), cx.Opts, &cx.Options{
+		WorkerConcurrency: 2,
+	})
+
+	// two replicas run cycles until there is nothing left to claim
+	processed := make([][]int32, 2)
+	wg := &sync.WaitGroup{}
+	for replica := range processed {
+		wg.Add(1)
+		go func(replica int) {
+			defer wg.Done()
+			for {
+				charIDs := pollCycle(ctx, users.claim, users.poll)
+				if len(charIDs) == 0 {
+					return
+				}
+				processed[replica] = append(processed[replica], charIDs...)
+			}
+		}(replica)
+	}
+	wg.Wait()
+
+	for _, charID := range users.due {
+		if users.polled[charID] != 1 {
+			t.Errorf("character %d polled %d times", charID, users.polled[charID])
+		}
+	}
+	if total := len(processed[0]) + len(processed[1]); total != len(users.due) {
+		t.Errorf("expected %d characters processed, received %d", len(users.due), total)
+	}
+}
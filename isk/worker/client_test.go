@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/antihax/goesi"
+	"github.com/gregjones/httpcache"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestClientUserAgent(t *testing.T) {
+	agents := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		agents = append(agents, r.Header.Get("User-Agent"))
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(`{}`)); err != nil {
+			t.Errorf("failed to write response: %+v", err)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.WithValue(context.Background(), cx.Opts, &cx.Options{
+		ESI:       server.URL,
+		UserAgent: "esi-isk/test",
+	})
+	ctx = context.WithValue(ctx, cx.Cache, httpcache.NewMemoryCache())
+	ctx = addClient(ctx)
+
+	// the SSO authenticator shares this client
+	res, err := ctx.Value(cx.HTTPClient).(*http.Client).Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %+v", err)
+	}
+	if err := res.Body.Close(); err != nil {
+		t.Fatalf("failed to close response body: %+v", err)
+	}
+
+	// worker polls and name lookups
+	client := ctx.Value(cx.Client).(*goesi.APIClient)
+	if _, _, err := client.ESI.CharacterApi.GetCharactersCharacterId(
+		context.Background(),
+		1,
+		nil,
+	); err != nil {
+		t.Fatalf("ESI request failed: %+v", err)
+	}
+
+	if len(agents) != 2 {
+		t.Fatalf("expected 2 requests, received %d", len(agents))
+	}
+	for _, agent := range agents {
+		if agent != "esi-isk/test" {
+			t.Errorf("unexpected User-Agent %q", agent)
+		}
+	}
+}
@@ -41,14 +41,14 @@ func newPrices(ctx context.Context) (*marketPrices, error) {
 	return m, nil
 }
 
-func (m *marketPrices) value(items map[int32]int32) float64 {
+func (m *marketPrices) value(items map[int32]int32) db.ISK {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	sum := float64(0)
 	for typeID, quantity := range items {
 		sum += (m.prices[typeID] * float64(quantity))
 	}
-	return sum
+	return db.NewISK(sum)
 }
 
 func (m *marketPrices) updater(ctx context.Context) {
@@ -97,9 +97,44 @@ func getPrices(ctx context.Context) (
 		prices[i.TypeId] = i.AdjustedPrice
 	}
 
+	// PLEX has no adjusted price, it is only traded on its own market
+	plex, err := getPLEXPrice(ctx)
+	if err != nil {
+		log.Printf("failed to get the PLEX price: %+v", err)
+	} else if plex > 0 {
+		prices[db.PLEXTypeID] = plex
+	}
+
 	return prices, expires, nil
 }
 
+// plexRegion is the region ID of the global PLEX market
+const plexRegion = 19000001
+
+// getPLEXPrice returns the lowest sell order of PLEX, or 0 without any
+func getPLEXPrice(ctx context.Context) (float64, error) {
+	client := ctx.Value(cx.Client).(*goesi.APIClient)
+	orders, _, err := client.ESI.MarketApi.GetMarketsRegionIdOrders(
+		ctx,
+		"sell",
+		plexRegion,
+		&esi.GetMarketsRegionIdOrdersOpts{
+			TypeId: optional.NewInt32(db.PLEXTypeID),
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	lowest := float64(0)
+	for _, order := range orders {
+		if lowest == 0 || order.Price < lowest {
+			lowest = order.Price
+		}
+	}
+	return lowest, nil
+}
+
 // pull the next update time from the response headers
 func getExpires(r *http.Response) (expires time.Time, err error) {
 	expires, err = time.Parse(api.RFC1123, r.Header.Get("Expires"))
@@ -110,68 +145,76 @@ func getExpires(r *http.Response) (expires time.Time, err error) {
 	return expires.Add(1 * time.Second), nil
 }
 
-func characterContracts(ctx context.Context, user *db.User) ([]int32, error) {
+// characterContracts pulls the character's contracts, returning the IDs of
+// the characters involved and the func to save them with, which returns the
+// contracts saved
+func characterContracts(
+	ctx context.Context,
+	user *db.User,
+) ([]int32, func(context.Context) ([]*db.Contract, error), error) {
 	charIDs := []int32{}
 
 	contracts, err := getContracts(ctx, user)
 	if err != nil {
-		return charIDs, err
+		return charIDs, nil, err
 	}
 
 	sort.Sort(contracts)
+	markExpired(contracts, time.Now())
 
 	prevID := int32(user.LastContractID.Int64)
 
 	setLastContractID(contracts, user)
 
-	outstanding, err := db.GetOutstandingContracts(ctx, user.CharacterID)
+	tracked, err := db.GetTrackedContracts(ctx, user.CharacterID)
 	if err != nil {
-		return charIDs, err
+		return charIDs, nil, err
 	}
 
-	new, updated := parseForZeroISK(contracts, user, prevID, outstanding)
-	donations, updates := asDbContracts(ctx, new, updated)
+	new, updates := parseForZeroISK(contracts, prevID, tracked)
+	donations := asDbContracts(ctx, new)
 
-	if len(donations) > 0 {
+	if len(donations) > 0 || len(updates) > 0 {
 		charIDs = append(charIDs, user.CharacterID)
 	}
 
-	for _, donation := range donations {
+	involved := db.Contracts{}
+	for _, donation := range append(donations, updates...) {
 		charIDs = append(charIDs, donation.Donator)
+		involved = append(involved, donation)
 	}
 
-	return charIDs, saveContractRun(
-		ctx,
-		donations,
-		updates,
-		getContractNames(ctx, donations),
-	)
+	affiliations := getContractNames(ctx, involved)
+	return charIDs, func(ctx context.Context) ([]*db.Contract, error) {
+		return saveContractRun(ctx, donations, updates, affiliations)
+	}, nil
 }
 
+// saveContractRun saves the new and updated contracts, returning those saved
 func saveContractRun(
 	ctx context.Context,
 	contracts []*db.Contract,
 	updates []*db.Contract,
 	affiliations []*db.Affiliation,
-) error {
-	for _, contract := range contracts {
-		if err := db.SaveContract(ctx, contract); err != nil {
-			return err
-		}
+) ([]*db.Contract, error) {
+	// both parties see the contract when both are users, it counts once
+	saved, err := db.SaveNewContracts(ctx, contracts)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := db.UpdateContracts(ctx, updates, affiliations); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := db.SaveNames(ctx, affiliations); err != nil {
-		return err
+		return nil, err
 	}
 
-	return db.SaveCharacterContracts(ctx, contracts, affiliations, true)
+	return saved, db.SaveCharacterContracts(ctx, saved, affiliations)
 }
 
-func getContractValue(ctx context.Context, items []*db.Item) float64 {
+func getContractValue(ctx context.Context, items []*db.Item) db.ISK {
 	itemQuantities := map[int32]int32{}
 	for _, item := range items {
 		itemQuantities[item.TypeID] += item.Quantity
@@ -255,43 +298,58 @@ func getLastContractID(user *db.User) (bool, int32) {
 	return user.LastContractID.Valid, int32(user.LastContractID.Int64)
 }
 
-// parseForZeroISK finds contracts that are zero ISK item exchanges
+// parseForZeroISK finds contracts that are zero ISK item exchanges. Known
+// contracts whose status has changed are returned as stored, with the new
+// status set. This includes new contracts already stored from the donator's
+// side
 func parseForZeroISK(
 	contracts []esi.GetCharactersCharacterIdContracts200Ok,
-	user *db.User,
 	prevID int32,
-	outstanding []int32,
+	tracked map[int32]*db.Contract,
 ) (
 	new []esi.GetCharactersCharacterIdContracts200Ok,
-	updated []esi.GetCharactersCharacterIdContracts200Ok,
+	updated []*db.Contract,
 ) {
 	new = []esi.GetCharactersCharacterIdContracts200Ok{}
-	updated = []esi.GetCharactersCharacterIdContracts200Ok{}
+	updated = []*db.Contract{}
 	newContracts := true
 	for _, contract := range contracts {
 		if contract.ContractId == prevID {
 			newContracts = false
 		}
-		if contract.Type_ == "item_exchange" && contract.Price == 0 {
-			if newContracts {
-				new = append(new, contract)
-			} else {
-				for i := 0; i < len(outstanding); i++ {
-					if outstanding[i] == contract.ContractId {
-						if contract.Status != "outstanding" {
-							log.Printf("outstanding contract %d has updated", contract.ContractId)
-							updated = append(updated, contract)
-						} else {
-							log.Printf("contract %d is still outstanding", contract.ContractId)
-						}
-					}
-				}
+		if contract.Type_ != "item_exchange" || contract.Price != 0 {
+			continue
+		}
+		if stored, found := tracked[contract.ContractId]; found {
+			if stored.Status != contract.Status {
+				log.Printf(
+					"contract %d has updated from %s to %s",
+					contract.ContractId,
+					stored.Status,
+					contract.Status,
+				)
+				stored.Status = contract.Status
+				updated = append(updated, stored)
 			}
+		} else if newContracts {
+			new = append(new, contract)
 		}
 	}
 	return new, updated
 }
 
+// markExpired sets the status of outstanding contracts past their expiry to
+// expired, which ESI doesn't
+func markExpired(contracts zeroISKContracts, now time.Time) {
+	for i, contract := range contracts {
+		contracts[i].Status = db.ContractStatus(
+			contract.Status,
+			contract.DateExpired,
+			now,
+		)
+	}
+}
+
 func setLastContractID(contracts zeroISKContracts, user *db.User) {
 	if len(contracts) < 1 {
 		return
@@ -302,12 +360,14 @@ func setLastContractID(contracts zeroISKContracts, user *db.User) {
 	}
 }
 
+// zeroISKContracts sort newest first, so parsing finds the new contracts
+// before the last contract ID seen
 type zeroISKContracts []esi.GetCharactersCharacterIdContracts200Ok
 
 func (z zeroISKContracts) Len() int      { return len(z) }
 func (z zeroISKContracts) Swap(i, j int) { z[i], z[j] = z[j], z[i] }
 func (z zeroISKContracts) Less(i, j int) bool {
-	return z[i].DateIssued.Before(z[j].DateIssued)
+	return z[i].DateIssued.After(z[j].DateIssued)
 }
 
 func expandContracts(
@@ -327,11 +387,9 @@ func expandContracts(
 	}
 	xPages := int(xPages64)
 
-	more := make(chan zeroISKContracts)
-	errs := make(chan error)
-
-	defer close(more)
-	defer close(errs)
+	// buffered, so pages finishing after another fails don't block
+	more := make(chan zeroISKContracts, xPages)
+	errs := make(chan error, xPages)
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -389,6 +447,7 @@ func toDbContract(c esi.GetCharactersCharacterIdContracts200Ok) *db.Contract {
 		Issued:   c.DateIssued,
 		Expires:  c.DateExpired,
 		Accepted: c.Status == "finished",
+		Status:   c.Status,
 		Note:     c.Title,
 	}
 }
@@ -397,8 +456,7 @@ func toDbContract(c esi.GetCharactersCharacterIdContracts200Ok) *db.Contract {
 func asDbContracts(
 	ctx context.Context,
 	contracts zeroISKContracts,
-	updates zeroISKContracts,
-) ([]*db.Contract, []*db.Contract) {
+) []*db.Contract {
 	zeroISK := []*db.Contract{}
 
 	for _, contract := range contracts {
@@ -419,10 +477,5 @@ func asDbContracts(
 		zeroISK = append(zeroISK, c)
 	}
 
-	updateContracts := []*db.Contract{}
-	for _, update := range updates {
-		updateContracts = append(updateContracts, toDbContract(update))
-	}
-
-	return zeroISK, updateContracts
+	return zeroISK
 }
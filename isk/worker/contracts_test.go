@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/antihax/goesi/esi"
+
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// testContract returns a zero ISK item exchange to character 1, newer as its
+// ID goes up
+func testContract(
+	id int32,
+	status string,
+) esi.GetCharactersCharacterIdContracts200Ok {
+	issued := time.Date(2019, 1, 20, 12, 0, 0, 0, time.UTC)
+	return esi.GetCharactersCharacterIdContracts200Ok{
+		ContractId: id,
+		Type_:      "item_exchange",
+		IssuerId:   2,
+		AssigneeId: 1,
+		DateIssued: issued.Add(time.Duration(id) * time.Hour),
+		Status:     status,
+	}
+}
+
+func TestParseForZeroISK(t *testing.T) {
+	priced := testContract(4, "outstanding")
+	priced.Price = 100
+	contracts := []esi.GetCharactersCharacterIdContracts200Ok{
+		priced,
+		testContract(3, "finished"),
+		testContract(2, "finished"),
+		testContract(1, "finished"),
+	}
+	tracked := map[int32]*db.Contract{
+		// stored from the donator's side before the recipient saw it
+		3: {ID: 3, Donator: 2, Receiver: 1, Status: "outstanding"},
+		1: {ID: 1, Donator: 2, Receiver: 1, Status: "outstanding"},
+	}
+
+	new, updated := parseForZeroISK(contracts, 2, tracked)
+
+	if len(new) != 0 {
+		t.Errorf("expected the stored contract not new, received %+v", new)
+	}
+	if len(updated) != 2 || updated[0].ID != 3 || updated[1].ID != 1 {
+		t.Fatalf("expected contracts 3 and 1 updated, received %+v", updated)
+	}
+	for _, contract := range updated {
+		if contract.Status != "finished" {
+			t.Errorf("expected contract %d finished", contract.ID)
+		}
+	}
+
+	new, _ = parseForZeroISK(contracts, 2, map[int32]*db.Contract{})
+	if len(new) != 1 || new[0].ContractId != 3 {
+		t.Errorf("expected contract 3 new, received %+v", new)
+	}
+}
+
+func TestMarkExpired(t *testing.T) {
+	contracts := zeroISKContracts{
+		testContract(3, "outstanding"),
+		testContract(2, "outstanding"),
+		testContract(1, "finished"),
+	}
+	for i := range contracts {
+		contracts[i].DateExpired = contracts[i].DateIssued.Add(time.Hour)
+	}
+
+	// contract 2 expired half an hour ago
+	markExpired(contracts, contracts[1].DateExpired.Add(30*time.Minute))
+
+	expected := []string{"outstanding", db.ContractExpired, "finished"}
+	for i, contract := range contracts {
+		if contract.Status != expected[i] {
+			t.Errorf(
+				"contract %d: expected %s, received %s",
+				contract.ContractId,
+				expected[i],
+				contract.Status,
+			)
+		}
+	}
+}
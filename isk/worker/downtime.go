@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+// downtimeLeft returns how long is left of the daily ESI downtime, from the
+// margin before it, or 0 outside of it
+func downtimeLeft(opts *cx.Options, now time.Time) time.Duration {
+	margin := time.Duration(opts.DowntimeMargin) * time.Second
+	return opts.Downtime.Widen(margin).Remaining(now)
+}
+
+// inDowntime is true during the daily ESI downtime, when errors are expected
+func inDowntime(ctx context.Context) bool {
+	return downtimeLeft(ctx.Value(cx.Opts).(*cx.Options), time.Now()) > 0
+}
+
+// waitDowntime pauses polling until the daily ESI downtime is over, returning
+// true if it did
+func waitDowntime(ctx context.Context) bool {
+	left := downtimeLeft(ctx.Value(cx.Opts).(*cx.Options), time.Now())
+	if left <= 0 {
+		return false
+	}
+	log.Printf("ESI downtime, pausing polling for %s", left.Round(time.Second))
+	time.Sleep(left)
+	log.Println("ESI downtime is over, resuming polling")
+	return true
+}
+
+// expectedError is true for errors of polls during the ESI downtime, or of
+// requests refused while the ESI circuit is open
+func expectedError(ctx context.Context, err error) bool {
+	return inDowntime(ctx) || errors.Is(err, errCircuitOpen)
+}
+
+// logPollf logs the error of a poll, expected errors are only logged when
+// debugging
+func logPollf(
+	ctx context.Context,
+	err error,
+	format string,
+	v ...interface{},
+) {
+	if expectedError(ctx, err) && !ctx.Value(cx.Opts).(*cx.Options).Debug {
+		return
+	}
+	log.Printf(format, v...)
+}
@@ -0,0 +1,34 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestDowntimeLeft(t *testing.T) {
+	opts := &cx.Options{DowntimeMargin: 120}
+	if err := opts.Downtime.Set("11:00-11:15"); err != nil {
+		t.Fatalf("failed to set downtime: %+v", err)
+	}
+	at := func(hour, min int) time.Time {
+		return time.Date(2019, 1, 20, hour, min, 0, 0, time.UTC)
+	}
+
+	cases := map[string]struct {
+		now      time.Time
+		expected time.Duration
+	}{
+		"before the margin": {at(10, 57), 0},
+		"in the margin":     {at(10, 59), 16 * time.Minute},
+		"during downtime":   {at(11, 5), 10 * time.Minute},
+		"after downtime":    {at(11, 15), 0},
+	}
+
+	for name, c := range cases {
+		if received := downtimeLeft(opts, c.now); received != c.expected {
+			t.Errorf("%s: expected %s, received %s", name, c.expected, received)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
@@ -14,33 +15,42 @@ import (
 	"github.com/a-tal/esi-isk/isk/api"
 	"github.com/a-tal/esi-isk/isk/cx"
 	"github.com/a-tal/esi-isk/isk/db"
+	"github.com/a-tal/esi-isk/isk/metrics"
+	"github.com/a-tal/esi-isk/isk/tokens"
 )
 
+// errOwnerChanged is returned when a character's owner hash has changed
+var errOwnerChanged = errors.New("characterID or owner hash mismatch")
+
 // addClient adds an http client and goesi client to context
 func addClient(ctx context.Context) context.Context {
 	cache := ctx.Value(cx.Cache).(httpcache.Cache)
 	opts := ctx.Value(cx.Opts).(*cx.Options)
 
+	// cached responses don't reach ESI, so never trip the breaker
 	transport := httpcache.NewTransport(cache)
+	esiBreaker := newBreaker(
+		http.DefaultTransport,
+		opts.BreakerFailures,
+		time.Duration(opts.BreakerBackoff)*time.Second,
+	)
+	transport.Transport = esiBreaker
 
-	httpClient := &http.Client{Transport: transport}
+	httpClient := cx.NewClient(ctx, transport, 0)
 
-	client := goesi.NewAPIClient(
-		httpClient,
-		// XXX version lookup here
-		"esi-isk/0.0.1 <https://github.com/a-tal/esi-isk/>",
-	)
+	client := goesi.NewAPIClient(httpClient, opts.UserAgent)
 	client.ChangeBasePath(opts.ESI)
 
 	ctx = context.WithValue(ctx, cx.HTTPClient, httpClient)
 	ctx = context.WithValue(ctx, cx.Client, client)
+	ctx = context.WithValue(ctx, cx.Breaker, esiBreaker)
 	return ctx
 }
 
-// Context adds the goesi client and auth to context
+// Context adds the goesi client and auth to context, and a PgStore unless
+// it already has one
 func Context(ctx context.Context) context.Context {
-	ctx = context.WithValue(ctx, cx.DB, db.Connect(ctx))
-	ctx = context.WithValue(ctx, cx.Statements, db.GetStatements(ctx))
+	ctx = db.Open(ctx)
 
 	cache := httpcache.NewMemoryCache()
 	ctx = context.WithValue(ctx, cx.Cache, cache)
@@ -71,81 +81,187 @@ func Context(ctx context.Context) context.Context {
 func Run(ctx context.Context) {
 	ctx = Context(ctx)
 
+	if opts := ctx.Value(cx.Opts).(*cx.Options); opts.MetricsListen != "" {
+		go serveMetrics(ctx, opts.MetricsListen)
+	}
+
 	loop := 0
+	nextSync := map[int32]time.Time{}
 	for {
-		updateStandings(ctx, processUsers(ctx))
-		time.Sleep(1 * time.Minute)
+		if waitDowntime(ctx) {
+			continue
+		}
+		// the circuit closes once a poll after the backoff succeeds
+		if !esiOpen(ctx) {
+			updateStandings(ctx, processUsers(ctx))
+			syncStandingsAt(ctx, nextSync, time.Now())
+		}
+		refreshUntil(ctx, time.Now().Add(1*time.Minute))
 		loop++
 		if loop%60 == 0 {
-			pruneContracts(ctx)
-			pruneDonations(ctx)
+			maintain(ctx)
 			loop = 0
 		}
 	}
 }
 
-func updateStandings(ctx context.Context, charIDs []int32) {
-	opts := ctx.Value(cx.Opts).(*cx.Options)
-	for _, charID := range charIDs {
-		if charID == opts.CharacterID {
-			continue
-		}
-
-		char, err := db.GetCharacter(ctx, charID)
-		if err != nil {
-			continue
-		}
+// serveMetrics serves the worker's metrics and readiness. If it fails, the
+// worker keeps polling without them
+func serveMetrics(ctx context.Context, listen string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metrics.Handler)
+	mux.HandleFunc("/readyz", readyz(ctx.Value(cx.Breaker).(*breaker)))
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	log.Printf("failed to serve metrics: %+v", server.ListenAndServe())
+}
 
-		standingISK, err := db.GetCharStandingISK(ctx, charID)
-		if err != nil {
-			continue
+// readyz reports the worker unready while the ESI circuit is open
+func readyz(b *breaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if b.current() == circuitOpen {
+			http.Error(w, "ESI circuit open", http.StatusServiceUnavailable)
+			return
 		}
-
-		char.GoodStanding = standingISK > (char.ReceivedISK30 * float64(0.01))
-
-		if err := db.SaveCharacter(ctx, char); err != nil {
-			log.Printf("failed to save character %d: %+v", charID, err)
+		if _, err := w.Write([]byte("ok\n")); err != nil {
+			log.Printf("failed to write readiness: %+v", err)
 		}
 	}
 }
 
+// esiOpen is true while requests to ESI are refused
+func esiOpen(ctx context.Context) bool {
+	b, ok := ctx.Value(cx.Breaker).(*breaker)
+	return ok && b.open()
+}
+
+// processUsers polls every user due an update in parallel, returning the
+// IDs of all characters seen
 func processUsers(ctx context.Context) []int32 {
-	processed := []int32{}
-	users, err := db.GetUsersToProcess(ctx)
+	return pollCycle(ctx, db.ClaimUsersToProcess, func(user *db.User) []int32 {
+		charIDs, _ := processUser(ctx, user)
+		return charIDs
+	})
+}
+
+// pollCycle polls the users claimed for this worker. Other workers, in this
+// or another replica, claim other users
+func pollCycle(
+	ctx context.Context,
+	claim func(context.Context) ([]*db.User, error),
+	poll func(*db.User) []int32,
+) []int32 {
+	users, err := claim(ctx)
 	if err != nil {
-		log.Printf("could not pull users to process: %+v", err)
-		return processed
+		log.Printf("could not claim users to process: %+v", err)
+		return []int32{}
 	}
 
-	for _, user := range users {
-		// TODO: make this parallel
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	return pool(users, opts.WorkerConcurrency, poll)
+}
 
-		ctx, err = addCharacterAuth(ctx, user)
-		if err != nil {
-			log.Printf("failed to get character auth: %+v", err)
-			// delete the character? or track failures then delete
-			continue
+// processUser pulls and saves the user's character within the worker
+// timeout, recording the result of the poll and scheduling the next
+func processUser(ctx context.Context, user *db.User) ([]int32, error) {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	pollCtx, cancel := context.WithTimeout(
+		ctx,
+		time.Duration(opts.WorkerTimeout)*time.Second,
+	)
+	defer cancel()
+
+	charIDs, err := pollUser(pollCtx, user)
+	if err == errOwnerChanged {
+		// the user has been removed
+		return charIDs, err
+	}
+
+	if err != nil && expectedError(ctx, err) {
+		// polls failing during the ESI downtime, or while ESI is failing, are
+		// retried after it, and not recorded as failures
+		if rErr := db.ReleaseUser(ctx, user.CharacterID); rErr != nil {
+			log.Printf("failed to release %d: %+v", user.CharacterID, rErr)
+		}
+		return charIDs, err
+	}
+
+	if sErr := db.SavePollResult(ctx, user.CharacterID, err); sErr != nil {
+		log.Printf(
+			"failed to save poll result of %d: %+v",
+			user.CharacterID,
+			sErr,
+		)
+	}
+	if err == nil {
+		schedulePoll(ctx, user, charIDs)
+	}
+	return charIDs, err
+}
+
+// pollUser pulls the user's character, or removes or revokes the user if
+// its token is no longer valid
+func pollUser(ctx context.Context, user *db.User) ([]int32, error) {
+	authCtx, err := addCharacterAuth(ctx, user)
+	if err == errOwnerChanged {
+		log.Printf("character %d has a new owner, removing", user.CharacterID)
+		if err := db.InvalidateOwner(ctx, user.CharacterID); err != nil {
+			log.Printf("failed to invalidate character owner: %+v", err)
+		}
+		return nil, errOwnerChanged
+	} else if isInvalidGrant(err) && !expectedError(ctx, err) {
+		// SSO may reject valid tokens during the ESI downtime
+		log.Printf("character %d token was revoked", user.CharacterID)
+		if err := db.RevokeUser(ctx, user.CharacterID); err != nil {
+			log.Printf("failed to revoke character token: %+v", err)
 		}
+		return nil, err
+	} else if err != nil {
+		logPollf(ctx, err, "failed to get character auth: %+v", err)
+		return nil, err
+	}
 
-		charIDs, err := pullCharacter(ctx, user)
-		if err != nil {
-			log.Printf("error pulling character %d: %+v", user.CharacterID, err)
-		} else {
-			for _, charID := range charIDs {
-				isKnown := false
-				for _, known := range processed {
-					if known == charID {
-						isKnown = true
-					}
-				}
-				if !isKnown {
-					processed = append(processed, charID)
-				}
-			}
+	charIDs, err := pullCharacter(authCtx, user)
+	if err != nil {
+		logPollf(ctx, err, "error pulling character %d: %+v", user.CharacterID, err)
+		if !expectedError(ctx, err) {
+			markIfDeleted(ctx, user.CharacterID)
 		}
+		return nil, err
+	}
+	return charIDs, nil
+}
+
+// markIfDeleted marks the character deleted if ESI no longer knows it, so it
+// is no longer polled
+func markIfDeleted(ctx context.Context, charID int32) {
+	if _, _, err := resolveCharacter(ctx, charID); err != errCharacterDeleted {
+		return
+	}
+	log.Printf("character %d has been deleted, no longer polling", charID)
+	if err := db.MarkDeleted(ctx, charID); err != nil {
+		log.Printf("failed to mark character %d deleted: %+v", charID, err)
+	}
+}
+
+// isInvalidGrant returns true if SSO rejected the refresh token outright
+func isInvalidGrant(err error) bool {
+	rErr, ok := err.(*oauth2.RetrieveError)
+	if !ok {
+		return false
 	}
 
-	return processed
+	body := struct {
+		Error string `json:"error"`
+	}{}
+	if jsonErr := json.Unmarshal(rErr.Body, &body); jsonErr != nil {
+		return false
+	}
+	return body.Error == "invalid_grant"
 }
 
 func getCharacterToken(
@@ -153,11 +269,18 @@ func getCharacterToken(
 	user *db.User,
 ) (oauth2.TokenSource, error) {
 	auth := ctx.Value(cx.Authenticator).(*goesi.SSOAuthenticator)
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+
+	refreshToken, err := tokens.Decrypt(opts.TokenKey, user.RefreshToken)
+	if err != nil {
+		log.Printf("refusing to use refresh token of %d: %+v", user.CharacterID, err)
+		return nil, err
+	}
 
 	token := &oauth2.Token{
 		AccessToken:  user.AccessToken,
 		TokenType:    "Bearer",
-		RefreshToken: user.RefreshToken,
+		RefreshToken: refreshToken,
 		Expiry:       user.AccessExpires,
 	}
 
@@ -167,14 +290,26 @@ func getCharacterToken(
 		return nil, err
 	}
 
-	charID, owner, err := api.VerifyTokenHack(ctx, tok.AccessToken)
+	// SSO may rotate the refresh token, which must be stored encrypted again
+	if tok.RefreshToken != refreshToken {
+		if user.RefreshToken, err = tokens.Encrypt(
+			opts.TokenKey,
+			tok.RefreshToken,
+		); err != nil {
+			return nil, err
+		}
+	}
+	user.AccessToken = tok.AccessToken
+	user.AccessExpires = tok.Expiry
+
+	claims, err := api.VerifyToken(ctx, tok.AccessToken)
 	if err != nil {
 		return nil, err
 	}
 
-	if charID != user.CharacterID || owner != user.OwnerHash {
-		// TODO: delete these characters
-		return nil, errors.New("characterID or owner hash mismatch")
+	if claims.CharacterID != user.CharacterID ||
+		claims.OwnerHash != user.OwnerHash {
+		return nil, errOwnerChanged
 	}
 
 	return tokSrc, nil
@@ -191,21 +326,52 @@ func addCharacterAuth(
 	return context.WithValue(ctx, goesi.ContextOAuth2, token), nil
 }
 
-// pullCharacter is the top level function to pull a character's details
+// pullCharacter is the top level function to pull a character's details,
+// saving them in a single transaction
 func pullCharacter(ctx context.Context, user *db.User) ([]int32, error) {
+	if user.BackfilledAt == nil {
+		// the first poll saves all the history ESI has
+		return backfillCharacter(ctx, user)
+	}
+
 	log.Printf("pulling character: %d", user.CharacterID)
 
-	charIDs, err := characterWallet(ctx, user)
+	charIDs, saveWallet, err := characterWallet(ctx, user)
 	if err != nil {
 		return charIDs, err
 	}
 	log.Printf("pulled character wallet: %d", user.CharacterID)
 
-	contractCharIDs, err := characterContracts(ctx, user)
+	contractCharIDs, saveContracts, err := characterContracts(ctx, user)
 	if err != nil {
 		return charIDs, err
 	}
 	log.Printf("pulled character contracts: %d", user.CharacterID)
+	charIDs = append(charIDs, contractCharIDs...)
+
+	// the poll is saved whole with the user's last IDs, or not at all and
+	// pulled again by the next poll
+	saved := []*db.Contract{}
+	if err := db.WithTx(ctx, func(ctx context.Context) error {
+		// held until commit, taken before the first write so the poll never
+		// holds rows another worker saving totals waits on
+		unlock, err := db.LockTotals(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if err := saveWallet(ctx); err != nil {
+			return err
+		}
+		if saved, err = saveContracts(ctx); err != nil {
+			return err
+		}
+		return db.SaveUser(ctx, user)
+	}); err != nil {
+		return charIDs, err
+	}
 
-	return append(charIDs, contractCharIDs...), db.SaveUser(ctx, user)
+	resolveLocations(ctx, saved)
+	return charIDs, nil
 }
@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/antihax/goesi"
+	"github.com/gregjones/httpcache"
+	"golang.org/x/oauth2"
+
+	"github.com/a-tal/esi-isk/isk/api"
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+	"github.com/a-tal/esi-isk/isk/internal/testutil/esimock"
+	"github.com/a-tal/esi-isk/isk/tokens"
+)
+
+func TestIsInvalidGrant(t *testing.T) {
+	cases := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"invalid grant": {
+			&oauth2.RetrieveError{Body: []byte(
+				`{"error":"invalid_grant","error_description":"Invalid refresh token."}`,
+			)},
+			true,
+		},
+		"other oauth error": {
+			&oauth2.RetrieveError{Body: []byte(`{"error":"invalid_client"}`)},
+			false,
+		},
+		"server error": {
+			&oauth2.RetrieveError{Body: []byte(`<html>502 Bad Gateway</html>`)},
+			false,
+		},
+		"network error": {errors.New("connection reset by peer"), false},
+	}
+
+	for name, c := range cases {
+		if received := isInvalidGrant(c.err); received != c.expected {
+			t.Errorf("%s: received %t, expected %t", name, received, c.expected)
+		}
+	}
+}
+
+// testESI returns a mock ESI and SSO, and a worker context using them
+func testESI(t *testing.T) (*esimock.Server, context.Context) {
+	mock := esimock.New(t)
+
+	opts := &cx.Options{
+		ESI:             mock.URL,
+		TokenKey:        tokens.Key("test-token-key"),
+		Auth:            &oauth2.Config{ClientID: esimock.ClientID},
+		BreakerFailures: 3,
+		BreakerBackoff:  60,
+	}
+	ctx := context.WithValue(context.Background(), cx.Opts, opts)
+	ctx = context.WithValue(ctx, cx.Cache, httpcache.NewMemoryCache())
+	ctx = addClient(ctx)
+
+	client := ctx.Value(cx.HTTPClient).(*http.Client)
+	auth := goesi.NewSSOAuthenticatorV2(client, esimock.ClientID, "", "", nil)
+	auth.ChangeTokenURL(mock.TokenURL())
+	ctx = context.WithValue(ctx, cx.Authenticator, auth)
+	ctx = context.WithValue(ctx, cx.Verifier, api.NewJWKS(client, mock.JWKSURL()))
+	return mock, ctx
+}
+
+// testUser returns a user of the character signed in to the mock SSO
+func testUser(
+	t *testing.T,
+	ctx context.Context,
+	mock *esimock.Server,
+	charID int32,
+) *db.User {
+	mock.Token(charID, "owner-hash", "refresh-token")
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	refreshToken, err := tokens.Encrypt(opts.TokenKey, "refresh-token")
+	if err != nil {
+		t.Fatalf("failed to encrypt refresh token: %+v", err)
+	}
+	return &db.User{
+		CharacterID:  charID,
+		OwnerHash:    "owner-hash",
+		RefreshToken: refreshToken,
+	}
+}
+
+func TestAddCharacterAuth(t *testing.T) {
+	mock, ctx := testESI(t)
+	user := testUser(t, ctx, mock, 1)
+
+	if _, err := addCharacterAuth(ctx, user); err != nil {
+		t.Fatalf("failed to refresh token: %+v", err)
+	}
+	if user.AccessToken == "" || !user.AccessExpires.After(time.Now()) {
+		t.Errorf("expected a new access token, received %+v", user)
+	}
+
+	user.OwnerHash = "new-owner"
+	user.AccessToken = ""
+	if _, err := addCharacterAuth(ctx, user); err != errOwnerChanged {
+		t.Errorf("expected errOwnerChanged, received %+v", err)
+	}
+}
+
+func TestAddCharacterAuthRevoked(t *testing.T) {
+	mock, ctx := testESI(t)
+	user := testUser(t, ctx, mock, 1)
+
+	// SSO doesn't know the refresh token
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	refreshToken, err := tokens.Encrypt(opts.TokenKey, "revoked-token")
+	if err != nil {
+		t.Fatalf("failed to encrypt refresh token: %+v", err)
+	}
+	user.RefreshToken = refreshToken
+
+	if _, err := addCharacterAuth(ctx, user); !isInvalidGrant(err) {
+		t.Errorf("expected invalid_grant, received %+v", err)
+	}
+}
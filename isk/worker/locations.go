@@ -0,0 +1,112 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/antihax/goesi"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// resolveLocations names the stations and structures of the contracts which
+// are unknown, or were denied more than db.LocationRetry ago. Structures are
+// resolved with the token of the character in the context. Failures are
+// logged, and retried by the next poll
+func resolveLocations(ctx context.Context, contracts []*db.Contract) {
+	ids := []int64{}
+	seen := map[int64]bool{}
+	for _, contract := range contracts {
+		if contract.Location == 0 || seen[contract.Location] {
+			continue
+		}
+		seen[contract.Location] = true
+		ids = append(ids, contract.Location)
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	known, err := db.GetLocations(ctx, ids)
+	if err != nil {
+		log.Printf("failed to get locations: %+v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, id := range unresolvedLocations(ids, known, now) {
+		location, err := resolveLocation(ctx, id, now)
+		if err != nil {
+			log.Printf("failed to resolve location %d: %+v", id, err)
+			continue
+		}
+		if err := db.SaveLocation(ctx, location); err != nil {
+			log.Printf("failed to save location %d: %+v", id, err)
+		}
+	}
+}
+
+// unresolvedLocations returns the IDs to resolve, in order
+func unresolvedLocations(
+	ids []int64,
+	known map[int64]*db.Location,
+	now time.Time,
+) []int64 {
+	unresolved := []int64{}
+	for _, id := range ids {
+		if location, ok := known[id]; !ok || location.Stale(now) {
+			unresolved = append(unresolved, id)
+		}
+	}
+	return unresolved
+}
+
+// resolveLocation returns the name of the station or structure. Stations
+// have 32 bit IDs and public names, structures are only named to characters
+// with docking access, they are denied and named db.UnknownStructure
+func resolveLocation(
+	ctx context.Context,
+	id int64,
+	now time.Time,
+) (*db.Location, error) {
+	location := &db.Location{ID: id, ResolvedAt: now.UTC()}
+
+	if id <= math.MaxInt32 {
+		names, err := ResolveName(ctx, int32(id))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			location.Name = name.Name
+		}
+		return location, nil
+	}
+
+	client := ctx.Value(cx.Client).(*goesi.APIClient)
+	structure, res, err := client.ESI.UniverseApi.GetUniverseStructuresStructureId(
+		ctx,
+		id,
+		nil,
+	)
+	if isDenied(res) {
+		location.Name = db.UnknownStructure
+		location.Denied = true
+		return location, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	location.Name = structure.Name
+	return location, nil
+}
+
+// isDenied is true for ESI responses refusing the character access
+func isDenied(res *http.Response) bool {
+	return res != nil && (res.StatusCode == http.StatusUnauthorized ||
+		res.StatusCode == http.StatusForbidden)
+}
@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+func TestUnresolvedLocations(t *testing.T) {
+	now := time.Now()
+	known := map[int64]*db.Location{
+		1: {ID: 1, Name: "Some Station", ResolvedAt: now.Add(-30 * 24 * time.Hour)},
+		2: {ID: 2, Name: db.UnknownStructure, Denied: true, ResolvedAt: now},
+		3: {
+			ID:         3,
+			Name:       db.UnknownStructure,
+			Denied:     true,
+			ResolvedAt: now.Add(-db.LocationRetry - time.Hour),
+		},
+	}
+
+	received := unresolvedLocations([]int64{1, 2, 3, 4}, known, now)
+	if expected := []int64{3, 4}; !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected to resolve %v, received %v", expected, received)
+	}
+}
+
+func TestResolveLocation(t *testing.T) {
+	mock, ctx := testESI(t)
+	station := "Jita IV - Moon 4 - Caldari Navy Assembly Plant"
+	mock.Name(60003760, station, "station")
+	mock.Structure(1022734985679, "Perimeter - Tranquility Trading Tower")
+	now := time.Now()
+
+	cases := map[int64]db.Location{
+		60003760:      {Name: station},
+		1022734985679: {Name: "Perimeter - Tranquility Trading Tower"},
+		1022734985680: {Name: db.UnknownStructure, Denied: true},
+	}
+
+	for id, expected := range cases {
+		location, err := resolveLocation(ctx, id, now)
+		if err != nil {
+			t.Errorf("%d: failed to resolve location: %+v", id, err)
+			continue
+		}
+		if location.ID != id || location.Name != expected.Name ||
+			location.Denied != expected.Denied {
+			t.Errorf("%d: expected %+v, received %+v", id, expected, location)
+		}
+	}
+}
@@ -3,50 +3,259 @@ package worker
 import (
 	"context"
 	"log"
+	"strings"
+	"time"
 
+	"github.com/a-tal/esi-isk/isk/cx"
 	"github.com/a-tal/esi-isk/isk/db"
+	"github.com/a-tal/esi-isk/isk/metrics"
 )
 
+// inconsistentTotals is the number of characters found by the last nightly
+// check with totals disagreeing with their stored donations and contracts
+var inconsistentTotals = metrics.NewGauge(
+	"esi_isk_inconsistent_totals",
+	"Characters with totals inconsistent with their stored rows",
+)
+
+// pendingRings is the number of donation rings pending review after the last
+// nightly detection
+var pendingRings = metrics.NewGauge(
+	"esi_isk_pending_rings",
+	"Donation rings pending review",
+)
+
+// maintain runs the hourly maintenance, unless another replica is already
+func maintain(ctx context.Context) {
+	unlock, locked, err := db.TryLockMaintenance(ctx)
+	if err != nil {
+		log.Printf("failed to lock maintenance: %+v", err)
+		return
+	} else if !locked {
+		log.Println("maintenance is running on another worker")
+		return
+	}
+	defer unlock()
+
+	snapshotLeaderboards(ctx, time.Now())
+	ageContracts(ctx)
+	pruneContracts(ctx)
+	pruneDonations(ctx)
+	archiveDonations(ctx)
+	pruneRefreshes(ctx)
+	expireStreaks(ctx, time.Now())
+	// before recalculating, which would hide any drift of the rolling totals
+	verifyTotals(ctx, time.Now())
+	recalculateTotals(ctx)
+	updateDonorStats(ctx)
+	detectRings(ctx, time.Now())
+}
+
+// ageContracts removes the accepted contracts issued longer ago than each
+// window from its totals, shortest first. They are kept for good
+func ageContracts(ctx context.Context) {
+	for _, window := range db.Windows {
+		contracts, err := db.GetStaleContracts(ctx, window)
+		if err != nil {
+			log.Printf("failed to get stale %s contracts: %+v", window, err)
+			continue
+		}
+		if len(contracts) == 0 {
+			continue
+		}
+
+		aff := getContractNames(ctx, contracts)
+		if err := db.AgeContracts(ctx, contracts, aff, window); err != nil {
+			log.Printf("failed to age stale %s contracts: %+v", window, err)
+			continue
+		}
+		log.Printf("aged %d contracts out of %s", len(contracts), window)
+	}
+}
+
+// pruneContracts expires the outstanding contracts past their expiry, then
+// removes the unaccepted contracts which ended, issued more than 90 days ago
 func pruneContracts(ctx context.Context) {
-	contracts, err := db.GetStaleContracts(ctx)
+	expired, err := db.ExpireContracts(ctx)
+	if err != nil {
+		log.Printf("failed to expire contracts: %+v", err)
+	} else if expired > 0 {
+		log.Printf("expired %d contracts", expired)
+	}
+
+	contracts, err := db.GetTerminalContracts(ctx)
 	if err != nil {
-		log.Printf("failed to get stale contracts: %+v", err)
+		log.Printf("failed to get ended contracts: %+v", err)
 		return
 	}
 
 	for _, contract := range contracts {
 		if err := db.PruneContract(ctx, contract); err != nil {
-			log.Printf("failed to prune stale contract: %+v", err)
+			log.Printf("failed to prune ended contract: %+v", err)
 		}
 	}
 
 	if len(contracts) > 0 {
-		aff := getContractNames(ctx, contracts)
-		if err := db.SaveCharacterContracts(ctx, contracts, aff, false); err != nil {
-			log.Printf("failed to save contracts after pruning: %+v", err)
-		}
 		log.Printf("pruned %d contracts", len(contracts))
 	}
 }
 
+// pruneDonations removes the donations older than each window from its
+// totals, shortest first
 func pruneDonations(ctx context.Context) {
-	donations, err := db.GetStaleDonations(ctx)
+	for _, window := range db.Windows {
+		donations, err := db.GetStaleDonations(ctx, window)
+		if err != nil {
+			log.Printf("failed to get stale %s donations: %+v", window, err)
+			continue
+		}
+		if len(donations) == 0 {
+			continue
+		}
+
+		aff := getNames(ctx, donations)
+		if err := db.AgeDonations(ctx, donations, aff, window); err != nil {
+			log.Printf("failed to age stale %s donations: %+v", window, err)
+			continue
+		}
+		log.Printf("aged %d donations out of %s", len(donations), window)
+	}
+}
+
+// archiveDonations moves the donations older than -archive-after days, and
+// out of the 30 day totals, to the archive
+func archiveDonations(ctx context.Context) {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	archived, err := db.ArchiveDonations(ctx, opts.ArchiveAfter)
 	if err != nil {
-		log.Printf("failed to get stale donations: %+v", err)
+		log.Printf("failed to archive donations: %+v", err)
+	} else if archived > 0 {
+		log.Printf("archived %d donations", archived)
+	}
+}
+
+// expireStreaks zeroes the current streaks which no donation continued
+// yesterday, once a night (EVE time) as the day ends
+func expireStreaks(ctx context.Context, now time.Time) {
+	if now.UTC().Hour() != 0 {
 		return
 	}
 
-	for _, donation := range donations {
-		if err := db.PruneDonation(ctx, donation); err != nil {
-			log.Printf("failed to prune stale donation: %+v", err)
-		}
+	expired, err := db.ExpireStreaks(ctx, now)
+	if err != nil {
+		log.Printf("failed to expire streaks: %+v", err)
+	} else if expired > 0 {
+		log.Printf("expired %d streaks", expired)
 	}
+}
 
-	if len(donations) > 0 {
-		aff := getNames(ctx, donations)
-		if err := db.SaveCharacterDonations(ctx, donations, aff, false); err != nil {
-			log.Printf("failed to save donations after pruning: %+v", err)
+// verifyTotals checks the totals of every character against the stored
+// donations and contracts once a night (EVE time)
+func verifyTotals(ctx context.Context, now time.Time) {
+	if now.UTC().Hour() != 0 {
+		return
+	}
+
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	checks, err := db.VerifyAllTotals(ctx, opts.RepairTotals)
+	if err != nil {
+		log.Printf("failed to verify totals: %+v", err)
+		return
+	}
+
+	inconsistentTotals.Set(float64(len(checks)))
+	for _, check := range checks {
+		log.Printf(
+			"inconsistent totals of %d: %s",
+			check.ID,
+			strings.Join(check.Diff(), ", "),
+		)
+	}
+	if len(checks) > 0 && opts.RepairTotals {
+		log.Printf("repaired the totals of %d characters", len(checks))
+	}
+}
+
+// detectRings flags the characters sending each other roughly the same ISK
+// for review once a night (EVE time)
+func detectRings(ctx context.Context, now time.Time) {
+	if now.UTC().Hour() != 0 {
+		return
+	}
+
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	rings, err := db.DetectRings(
+		ctx,
+		now,
+		db.NewISK(opts.RingThreshold),
+		opts.RingTolerance,
+	)
+	if err != nil {
+		log.Printf("failed to detect donation rings: %+v", err)
+		return
+	}
+
+	for _, ring := range rings {
+		if ring.ReviewedAt == nil {
+			log.Printf(
+				"donation ring %d of %v sent %s to %s ISK",
+				ring.ID,
+				ring.Members,
+				ring.Low,
+				ring.High,
+			)
 		}
-		log.Printf("pruned %d donations", len(donations))
+	}
+
+	pending, err := db.CountPendingRings(ctx)
+	if err != nil {
+		log.Printf("failed to count donation rings: %+v", err)
+		return
+	}
+	pendingRings.Set(float64(pending))
+}
+
+// updateDonorStats computes the average donation and donor counts of every
+// character, which can't be kept as donations are counted
+func updateDonorStats(ctx context.Context) {
+	if err := db.UpdateDonorStats(ctx, time.Now()); err != nil {
+		log.Printf("failed to update donor stats: %+v", err)
+	}
+}
+
+func recalculateTotals(ctx context.Context) {
+	if err := RecalculateTotals(ctx); err != nil {
+		log.Printf("failed to recalculate totals: %+v", err)
+	}
+}
+
+// RecalculateTotals recalculates every character's totals from the stored
+// donations and contracts, then their ranks
+func RecalculateTotals(ctx context.Context) error {
+	unlock, err := db.LockTotals(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := db.RecalculateTotals(ctx); err != nil {
+		return err
+	}
+
+	// ranks are of the fresh totals
+	return db.UpdateRanks(ctx)
+}
+
+// snapshotLeaderboards saves last month's leaderboards on the first day of
+// the month (EVE time), from the stored and archived donations
+func snapshotLeaderboards(ctx context.Context, now time.Time) {
+	if now.UTC().Day() != 1 {
+		return
+	}
+
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	lastMonth := db.MonthStart(now).AddDate(0, -1, 0)
+	if err := db.SnapshotLeaderboards(ctx, lastMonth, opts.HistorySize); err != nil {
+		log.Printf("failed to snapshot leaderboards: %+v", err)
 	}
 }
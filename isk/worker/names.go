@@ -2,7 +2,10 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
 
 	"github.com/antihax/goesi"
 	"github.com/antihax/goesi/esi"
@@ -11,6 +14,10 @@ import (
 	"github.com/a-tal/esi-isk/isk/db"
 )
 
+// errCharacterDeleted is returned for characters ESI no longer knows, such
+// as biomassed ones
+var errCharacterDeleted = errors.New("character has been deleted")
+
 // getNames for all characters involved in the donations
 func getNames(
 	ctx context.Context,
@@ -29,7 +36,7 @@ func getNames(
 				continue
 			}
 
-			resolved, err := resolveNames(ctx, charID)
+			resolved, err := resolveKnownNames(ctx, charID)
 			if err != nil {
 				log.Printf("failed to resolve names: %+v", err)
 			} else {
@@ -58,7 +65,7 @@ func getContractNames(
 				continue
 			}
 
-			resolved, err := resolveNames(ctx, charID)
+			resolved, err := resolveKnownNames(ctx, charID)
 			if err != nil {
 				log.Printf("failed to resolve names: %+v", err)
 			} else {
@@ -69,6 +76,27 @@ func getContractNames(
 	return affiliations
 }
 
+// resolveKnownNames resolves the names of the charID, unless it is already
+// known to be deleted
+func resolveKnownNames(
+	ctx context.Context,
+	charID int32,
+) (*db.Affiliation, error) {
+	if char, err := db.GetCharacter(ctx, charID); err == nil && char.Deleted {
+		return deletedAffiliation(charID, ""), nil
+	}
+	return resolveNames(ctx, charID)
+}
+
+// deletedAffiliation keeps the stored affiliation of the deleted character,
+// and its stored name unless name is given
+func deletedAffiliation(charID int32, name string) *db.Affiliation {
+	return &db.Affiliation{
+		Character: &db.Name{ID: charID, Name: name},
+		Deleted:   true,
+	}
+}
+
 // resolveNames gets the name of the charID,
 // which might be a corp or allianceID. it will
 // also resolve upwards, so corp+alliance in case
@@ -77,8 +105,11 @@ func resolveNames(ctx context.Context, charID int32) (*db.Affiliation, error) {
 
 	aff := &db.Affiliation{}
 
-	ret, err := ResolveName(ctx, charID)
-	if err != nil {
+	ret, httpRes, err := resolveName(ctx, charID)
+	if isNotFound(httpRes) {
+		log.Printf("names of %d not found, it has been deleted", charID)
+		return deletedAffiliation(charID, ""), nil
+	} else if err != nil {
 		return nil, err
 	}
 
@@ -86,22 +117,19 @@ func resolveNames(ctx context.Context, charID int32) (*db.Affiliation, error) {
 
 		if res.Category == "corporation" {
 			aff.Corporation = &db.Name{ID: res.Id, Name: res.Name}
-
-			allianceID, allianceName := ResolveCorporation(ctx, res.Id)
-			if allianceID > 0 {
-				aff.Alliance = &db.Name{ID: allianceID, Name: allianceName}
-			}
+			resolveAlliance(ctx, aff, res.Id)
 
 		} else if res.Category == "character" {
 			aff.Character = &db.Name{ID: res.Id, Name: res.Name}
 
-			corpID, corpName := ResolveCharacter(ctx, res.Id)
+			corpID, corpName, err := resolveCharacter(ctx, res.Id)
+			if err == errCharacterDeleted {
+				log.Printf("character %d has been deleted", res.Id)
+				return deletedAffiliation(res.Id, res.Name), nil
+			}
 			if corpID > 0 { // 0 == error in lookup
 				aff.Corporation = &db.Name{ID: corpID, Name: corpName}
-				allianceID, allianceName := ResolveCorporation(ctx, corpID)
-				if allianceID > 0 { // 0 == error or not in alliance
-					aff.Alliance = &db.Name{ID: allianceID, Name: allianceName}
-				}
+				resolveAlliance(ctx, aff, corpID)
 			}
 
 		} else {
@@ -117,8 +145,24 @@ func resolveNames(ctx context.Context, charID int32) (*db.Affiliation, error) {
 	return aff, nil
 }
 
-// ResolveCorporation returns the ID and name of the corporation's alliance
-func ResolveCorporation(ctx context.Context, corpID int32) (int32, string) {
+// resolveAlliance sets the alliance of the affiliation's corporation, or
+// marks it unresolved so the stored alliance is kept
+func resolveAlliance(ctx context.Context, aff *db.Affiliation, corpID int32) {
+	allianceID, allianceName, err := ResolveCorporation(ctx, corpID)
+	if err != nil {
+		log.Printf("failed to resolve the alliance of %d: %+v", corpID, err)
+		aff.AllianceUnresolved = true
+	} else if allianceID > 0 {
+		aff.Alliance = &db.Name{ID: allianceID, Name: allianceName}
+	}
+}
+
+// ResolveCorporation returns the ID and name of the corporation's alliance,
+// 0 when it is not in one
+func ResolveCorporation(
+	ctx context.Context,
+	corpID int32,
+) (int32, string, error) {
 	client := ctx.Value(cx.Client).(*goesi.APIClient)
 
 	ret, _, err := client.ESI.CorporationApi.GetCorporationsCorporationId(
@@ -127,54 +171,68 @@ func ResolveCorporation(ctx context.Context, corpID int32) (int32, string) {
 		nil,
 	)
 	if err != nil {
-		log.Printf("failed to get corporation by ID: %+v", err)
-		return 0, ""
+		return 0, "", err
+	}
+	if ret.AllianceId == 0 {
+		return 0, "", nil
 	}
 
-	if ret.AllianceId > 0 {
-		allianceNameRes, err := ResolveName(ctx, ret.AllianceId)
-		if err != nil {
-			log.Printf("failed to resolve the alliance of corp: %d", corpID)
-			return 0, ""
-		}
-		for _, res := range allianceNameRes {
-			if res.Category == "alliance" && res.Id == ret.AllianceId {
-				return ret.AllianceId, res.Name
-			}
+	allianceNameRes, err := ResolveName(ctx, ret.AllianceId)
+	if err != nil {
+		return 0, "", err
+	}
+	for _, res := range allianceNameRes {
+		if res.Category == "alliance" && res.Id == ret.AllianceId {
+			return ret.AllianceId, res.Name, nil
 		}
 	}
-
-	return 0, ""
+	return 0, "", fmt.Errorf(
+		"no name of alliance %d of corporation %d",
+		ret.AllianceId,
+		corpID,
+	)
 }
 
 // ResolveCharacter returns the ID and name of the character's corporation
 func ResolveCharacter(ctx context.Context, charID int32) (int32, string) {
+	corpID, corpName, _ := resolveCharacter(ctx, charID)
+	return corpID, corpName
+}
+
+// resolveCharacter returns the ID and name of the character's corporation,
+// or errCharacterDeleted if ESI no longer knows the character
+func resolveCharacter(
+	ctx context.Context,
+	charID int32,
+) (int32, string, error) {
 	client := ctx.Value(cx.Client).(*goesi.APIClient)
 
-	ret, _, err := client.ESI.CharacterApi.GetCharactersCharacterId(
+	ret, httpRes, err := client.ESI.CharacterApi.GetCharactersCharacterId(
 		ctx,
 		charID,
 		nil,
 	)
 
-	if err != nil {
+	if isNotFound(httpRes) {
+		return 0, "", errCharacterDeleted
+	} else if err != nil {
 		log.Printf("failed to lookup character %d by ID: %+v", charID, err)
-		return 0, ""
+		return 0, "", err
 	}
 
 	corpRes, err := ResolveName(ctx, ret.CorporationId)
 	if err != nil {
 		log.Printf("failed to resolve name of corp %d: %+v", ret.CorporationId, err)
-		return 0, ""
+		return 0, "", err
 	}
 
 	for _, res := range corpRes {
 		if res.Category == "corporation" {
-			return res.Id, res.Name
+			return res.Id, res.Name, nil
 		}
 	}
 
-	return 0, ""
+	return 0, "", nil
 }
 
 // ResolveName returns the post universe names return
@@ -182,7 +240,20 @@ func ResolveName(ctx context.Context, charID ...int32) (
 	[]esi.PostUniverseNames200Ok,
 	error,
 ) {
-	client := ctx.Value(cx.Client).(*goesi.APIClient)
-	ret, _, err := client.ESI.UniverseApi.PostUniverseNames(ctx, charID, nil)
+	ret, _, err := resolveName(ctx, charID...)
 	return ret, err
 }
+
+func resolveName(ctx context.Context, charID ...int32) (
+	[]esi.PostUniverseNames200Ok,
+	*http.Response,
+	error,
+) {
+	client := ctx.Value(cx.Client).(*goesi.APIClient)
+	return client.ESI.UniverseApi.PostUniverseNames(ctx, charID, nil)
+}
+
+// isNotFound is true for ESI responses about IDs which no longer exist
+func isNotFound(res *http.Response) bool {
+	return res != nil && res.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,82 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/internal/testutil/esimock"
+)
+
+func TestResolveNames(t *testing.T) {
+	mock, ctx := testESI(t)
+	mock.Character(1, "Some Pilot", 10)
+	mock.Corporation(10, "Some Corporation", 0)
+
+	aff, err := resolveNames(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to resolve names: %+v", err)
+	}
+	if aff.Deleted || aff.Character.Name != "Some Pilot" {
+		t.Errorf("unexpected character %+v, deleted %t", aff.Character, aff.Deleted)
+	}
+	if aff.Corporation == nil || aff.Corporation.ID != 10 {
+		t.Errorf("unexpected corporation %+v", aff.Corporation)
+	}
+	if aff.Alliance != nil || aff.AllianceUnresolved {
+		t.Errorf("expected no alliance, received %+v", aff)
+	}
+}
+
+func TestResolveNamesAllianceUnresolved(t *testing.T) {
+	mock, ctx := testESI(t)
+	mock.Character(1, "Some Pilot", 10)
+	mock.Corporation(10, "Some Corporation", 20)
+	mock.Name(20, "Some Alliance", "alliance")
+	mock.Inject("/corporations/10/", esimock.Fault{Status: 503})
+
+	aff, err := resolveNames(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to resolve names: %+v", err)
+	}
+	if aff.Corporation == nil || aff.Corporation.ID != 10 {
+		t.Errorf("unexpected corporation %+v", aff.Corporation)
+	}
+	if aff.Alliance != nil || !aff.AllianceUnresolved {
+		t.Errorf("expected the alliance unresolved, received %+v", aff)
+	}
+}
+
+func TestResolveNamesNotFound(t *testing.T) {
+	_, ctx := testESI(t)
+
+	aff, err := resolveNames(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to resolve names: %+v", err)
+	}
+	if !aff.Deleted || aff.Character.ID != 1 || aff.Corporation != nil {
+		t.Errorf("expected a deleted character, received %+v", aff)
+	}
+	if aff.Character.Name != "" {
+		t.Errorf("deleted character was renamed to %q", aff.Character.Name)
+	}
+}
+
+func TestResolveNamesDeletedCharacter(t *testing.T) {
+	// biomassed characters keep their name for a while
+	mock, ctx := testESI(t)
+	mock.Name(1, "Some Pilot", "character")
+
+	aff, err := resolveNames(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to resolve names: %+v", err)
+	}
+	if !aff.Deleted || aff.Corporation != nil {
+		t.Errorf("expected a deleted character, received %+v", aff)
+	}
+	if aff.Character.Name != "Some Pilot" {
+		t.Errorf("unexpected character name %q", aff.Character.Name)
+	}
+
+	if _, _, err := resolveCharacter(ctx, 1); err != errCharacterDeleted {
+		t.Errorf("expected errCharacterDeleted, received %+v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// pool runs poll for each user, with up to concurrency running at once. It
+// returns every character ID the polls returned, once each
+func pool(
+	users []*db.User,
+	concurrency int,
+	poll func(*db.User) []int32,
+) []int32 {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan *db.User)
+	results := make(chan []int32)
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for user := range jobs {
+				results <- isolated(user, poll)
+			}
+		}()
+	}
+
+	go func() {
+		for _, user := range users {
+			jobs <- user
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	processed := []int32{}
+	seen := map[int32]bool{}
+	for charIDs := range results {
+		for _, charID := range charIDs {
+			if !seen[charID] {
+				seen[charID] = true
+				processed = append(processed, charID)
+			}
+		}
+	}
+	return processed
+}
+
+// isolated runs poll for the user, a panic only fails this user's poll
+func isolated(user *db.User, poll func(*db.User) []int32) (charIDs []int32) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf(
+				"panic polling character %d: %v\n%s",
+				user.CharacterID,
+				err,
+				debug.Stack(),
+			)
+			charIDs = nil
+		}
+	}()
+	return poll(user)
+}
@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+func TestPool(t *testing.T) {
+	users := []*db.User{}
+	for i := int32(1); i <= 10; i++ {
+		users = append(users, &db.User{CharacterID: i})
+	}
+
+	lock := &sync.Mutex{}
+	running, most := 0, 0
+	release := make(chan struct{})
+
+	go func() {
+		for i := 0; i < len(users); i++ {
+			release <- struct{}{}
+		}
+	}()
+
+	processed := pool(users, 3, func(user *db.User) []int32 {
+		lock.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		lock.Unlock()
+
+		<-release
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+
+		switch user.CharacterID {
+		case 2:
+			panic("bad character")
+		case 3:
+			return nil
+		}
+		// every poll also returns a shared donator
+		return []int32{user.CharacterID, 100}
+	})
+
+	if most > 3 {
+		t.Errorf("expected at most 3 polls at once, received %d", most)
+	}
+
+	sort.Slice(processed, func(i, j int) bool { return processed[i] < processed[j] })
+	expected := []int32{1, 4, 5, 6, 7, 8, 9, 10, 100}
+	if len(processed) != len(expected) {
+		t.Fatalf("expected %v, received %v", expected, processed)
+	}
+	for i, charID := range expected {
+		if processed[i] != charID {
+			t.Errorf("expected %v, received %v", expected, processed)
+			break
+		}
+	}
+}
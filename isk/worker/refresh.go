@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// refreshCheck is how often the worker looks for requested refreshes
+const refreshCheck = 5 * time.Second
+
+// refreshUntil runs the refresh jobs requested by owners until deadline.
+// Refreshes run between this worker's polling cycles, and claim their user
+// so no other replica polls it at the same time
+func refreshUntil(ctx context.Context, deadline time.Time) {
+	for time.Now().Before(deadline) {
+		processRefreshes(ctx)
+		time.Sleep(refreshCheck)
+	}
+}
+
+// processRefreshes polls the characters of all pending refresh jobs
+func processRefreshes(ctx context.Context) {
+	// pending jobs wait for ESI to respond again
+	if esiOpen(ctx) {
+		return
+	}
+
+	jobs, err := db.ClaimRefreshes(ctx)
+	if err != nil {
+		log.Printf("could not claim refresh jobs: %+v", err)
+		return
+	}
+	if len(jobs) == 0 {
+		return
+	}
+
+	users := []*db.User{}
+	byCharacter := map[int32]*db.RefreshJob{}
+	for _, job := range jobs {
+		user, err := claimRefresh(ctx, job)
+		if err != nil {
+			continue
+		}
+		users = append(users, user)
+		byCharacter[job.CharacterID] = job
+	}
+
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	updateStandings(ctx, pool(users, opts.WorkerConcurrency, func(
+		user *db.User,
+	) []int32 {
+		job := byCharacter[user.CharacterID]
+		charIDs, pollErr := processUser(ctx, user)
+		if err := db.FinishRefresh(ctx, job.ID, pollErr); err != nil {
+			log.Printf("failed to finish refresh job %d: %+v", job.ID, err)
+		}
+		return charIDs
+	}))
+}
+
+// claimRefresh claims the user of the job. Jobs of users another worker is
+// polling are returned to pending, jobs of removed users fail
+func claimRefresh(ctx context.Context, job *db.RefreshJob) (*db.User, error) {
+	user, err := db.ClaimUser(ctx, job.CharacterID)
+	if err == nil {
+		return user, nil
+	}
+
+	if err == db.ErrUserClaimed {
+		if _, uErr := db.GetUser(ctx, job.CharacterID); uErr == nil {
+			if rErr := db.RequeueRefresh(ctx, job.ID); rErr != nil {
+				log.Printf("failed to requeue refresh job %d: %+v", job.ID, rErr)
+			}
+			return nil, err
+		}
+	}
+
+	log.Printf("failed to claim user of refresh job %d: %+v", job.ID, err)
+	if fErr := db.FinishRefresh(ctx, job.ID, err); fErr != nil {
+		log.Printf("failed to finish refresh job %d: %+v", job.ID, fErr)
+	}
+	return nil, err
+}
+
+func pruneRefreshes(ctx context.Context) {
+	if err := db.PruneRefreshes(ctx); err != nil {
+		log.Printf("failed to prune refresh jobs: %+v", err)
+	}
+}
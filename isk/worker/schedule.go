@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// activeWindow is how recently a character must have received a donation or
+// contract to be polled at the base interval
+const activeWindow = 7 * 24 * time.Hour
+
+// schedulePoll sets when the user is next polled after a successful poll
+func schedulePoll(ctx context.Context, user *db.User, charIDs []int32) {
+	lastReceived := time.Time{}
+	if c, err := db.GetCharacter(ctx, user.CharacterID); err == nil {
+		lastReceived = c.LastReceived
+	}
+
+	interval := nextInterval(
+		ctx.Value(cx.Opts).(*cx.Options),
+		user.PollInterval,
+		inInt32(user.CharacterID, charIDs),
+		lastReceived,
+		time.Now(),
+	)
+
+	if err := db.SchedulePoll(ctx, user.CharacterID, interval); err != nil {
+		log.Printf("failed to schedule poll of %d: %+v", user.CharacterID, err)
+	}
+}
+
+// nextInterval returns the seconds until a character's next poll. Characters
+// with anything new, or recently received, use the base interval (0), quiet
+// characters double their previous interval up to the max
+func nextInterval(
+	opts *cx.Options,
+	previous int32,
+	found bool,
+	lastReceived time.Time,
+	now time.Time,
+) int32 {
+	if found || now.Sub(lastReceived) < activeWindow {
+		return 0
+	}
+
+	if previous < int32(opts.PollInterval) {
+		previous = int32(opts.PollInterval)
+	}
+	if interval := previous * 2; interval < int32(opts.MaxPollInterval) {
+		return interval
+	}
+	return int32(opts.MaxPollInterval)
+}
+
+// inInt32 returns true if i is in s
+func inInt32(i int32, s []int32) bool {
+	for _, j := range s {
+		if i == j {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestNextInterval(t *testing.T) {
+	opts := &cx.Options{PollInterval: 3600, MaxPollInterval: 21600}
+	now := time.Date(2019, 1, 20, 0, 0, 0, 0, time.UTC)
+	recent := now.Add(-2 * 24 * time.Hour)
+	quiet := now.Add(-60 * 24 * time.Hour)
+
+	cases := map[string]struct {
+		previous     int32
+		found        bool
+		lastReceived time.Time
+		expected     int32
+	}{
+		"recently received":    {14400, false, recent, 0},
+		"new donation":         {21600, true, quiet, 0},
+		"first quiet poll":     {0, false, quiet, 7200},
+		"backing off":          {7200, false, quiet, 14400},
+		"capped":               {14400, false, quiet, 21600},
+		"at max":               {21600, false, quiet, 21600},
+		"never received":       {0, false, time.Time{}, 7200},
+		"before active window": {0, false, now.Add(-activeWindow), 7200},
+	}
+
+	for name, c := range cases {
+		received := nextInterval(opts, c.previous, c.found, c.lastReceived, now)
+		if received != c.expected {
+			t.Errorf("%s: expected %d, received %d", name, c.expected, received)
+		}
+	}
+}
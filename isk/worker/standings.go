@@ -0,0 +1,170 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/antihax/goesi"
+	"github.com/antihax/goesi/esi"
+	"github.com/antihax/goesi/optional"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+	"github.com/a-tal/esi-isk/isk/db"
+)
+
+// standingContactTypes are the types of contacts giving good standing to
+// characters, factions have no members here
+var standingContactTypes = []string{"character", "corporation", "alliance"}
+
+// updateStandings updates the good standing of the characters, from their
+// donations to the owner and the synced contacts of every standings source
+func updateStandings(ctx context.Context, charIDs []int32) {
+	unlock, err := db.LockTotals(ctx)
+	if err != nil {
+		log.Printf("failed to lock totals to update standings: %+v", err)
+		return
+	}
+	defer unlock()
+
+	if _, err := db.UpdateStandings(ctx, charIDs); err != nil {
+		log.Printf("failed to update standings: %+v", err)
+	}
+}
+
+// syncStandingsAt syncs the contacts of each standings source due by now,
+// keeping when each is next due in next. A sync skipped because the source is
+// being polled is retried on the next loop
+func syncStandingsAt(
+	ctx context.Context,
+	next map[int32]time.Time,
+	now time.Time,
+) {
+	opts := ctx.Value(cx.Opts).(*cx.Options)
+	if opts.StandingsInterval <= 0 {
+		return
+	}
+
+	for _, sourceID := range opts.CharacterIDs {
+		if now.Before(next[sourceID]) {
+			continue
+		}
+
+		if err := syncStandings(ctx, sourceID); err == db.ErrUserClaimed {
+			continue
+		} else if err != nil {
+			log.Printf("failed to sync standings of %d: %+v", sourceID, err)
+		}
+		next[sourceID] = now.Add(time.Duration(opts.StandingsInterval) * time.Second)
+	}
+}
+
+// syncStandings pulls the contacts of the standings source, which must have
+// signed in with the esi-characters.read_contacts.v1 scope, then sets the
+// standing of every character from them. The source is claimed, so its
+// token is not refreshed by a poll at the same time
+func syncStandings(ctx context.Context, sourceID int32) error {
+	user, err := db.ClaimUser(ctx, sourceID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.ReleaseUser(ctx, user.CharacterID); err != nil {
+			log.Printf("failed to release standings source: %+v", err)
+		}
+	}()
+
+	authCtx, err := addCharacterAuth(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	contacts, err := getContacts(authCtx, user.CharacterID)
+	if err != nil {
+		return err
+	}
+
+	if err := db.SaveUser(ctx, user); err != nil {
+		return err
+	}
+
+	unlock, err := db.LockTotals(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	changed, err := db.SyncStandings(ctx, sourceID, contacts)
+	if err != nil {
+		return err
+	}
+	log.Printf(
+		"synced %d contacts of %d, %d characters changed standing",
+		len(contacts),
+		sourceID,
+		len(changed),
+	)
+	return nil
+}
+
+// getContacts pulls every page of the character's contacts
+func getContacts(ctx context.Context, charID int32) ([]*db.Contact, error) {
+	contacts := []*db.Contact{}
+	for page, pages := int32(1), int32(1); page <= pages; page++ {
+		entries, res, err := contactsPage(ctx, charID, page)
+		if err != nil {
+			return nil, err
+		}
+		if pages, err = xPages(res); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, standingContacts(entries)...)
+	}
+	return contacts, nil
+}
+
+func contactsPage(
+	ctx context.Context,
+	charID int32,
+	page int32,
+) ([]esi.GetCharactersCharacterIdContacts200Ok, *http.Response, error) {
+	client := ctx.Value(cx.Client).(*goesi.APIClient)
+	return client.ESI.ContactsApi.GetCharactersCharacterIdContacts(
+		ctx,
+		charID,
+		&esi.GetCharactersCharacterIdContactsOpts{
+			Page: optional.NewInt32(page),
+		},
+	)
+}
+
+// xPages returns the number of pages of the response, 1 if unpaginated
+func xPages(res *http.Response) (int32, error) {
+	raw := res.Header.Get("X-Pages")
+	if raw == "" {
+		return 1, nil
+	}
+	pages, err := strconv.ParseInt(raw, 10, 32)
+	return int32(pages), err
+}
+
+// standingContacts returns the contacts of types giving good standing
+func standingContacts(
+	entries []esi.GetCharactersCharacterIdContacts200Ok,
+) []*db.Contact {
+	contacts := []*db.Contact{}
+	for _, entry := range entries {
+		for _, contactType := range standingContactTypes {
+			if entry.ContactType == contactType {
+				contacts = append(contacts, &db.Contact{
+					ContactID:   entry.ContactId,
+					ContactType: entry.ContactType,
+					Standing:    entry.Standing,
+				})
+			}
+		}
+	}
+	return contacts
+}
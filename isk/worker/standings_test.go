@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/antihax/goesi/esi"
+
+	"github.com/a-tal/esi-isk/isk/cx"
+)
+
+func TestStandingContacts(t *testing.T) {
+	contacts := standingContacts([]esi.GetCharactersCharacterIdContacts200Ok{
+		{ContactId: 1, ContactType: "character", Standing: 10},
+		{ContactId: 2, ContactType: "corporation", Standing: -5},
+		{ContactId: 3, ContactType: "alliance", Standing: 5},
+		{ContactId: 500001, ContactType: "faction", Standing: 10},
+	})
+
+	if len(contacts) != 3 {
+		t.Fatalf("expected 3 contacts, received %d", len(contacts))
+	}
+	for i, contact := range contacts {
+		if contact.ContactID != int32(i+1) {
+			t.Errorf("unexpected contact %d at %d", contact.ContactID, i)
+		}
+	}
+	if contacts[1].ContactType != "corporation" || contacts[1].Standing != -5 {
+		t.Errorf("unexpected corporation contact %+v", contacts[1])
+	}
+}
+
+func TestSyncStandingsAtNotDue(t *testing.T) {
+	now := time.Date(2019, 1, 20, 0, 0, 0, 0, time.UTC)
+	later := now.Add(time.Minute)
+
+	for name, c := range map[string]struct {
+		interval int
+		next     time.Time
+	}{
+		"disabled": {0, now},
+		"not due":  {3600, later},
+	} {
+		ctx := context.WithValue(context.Background(), cx.Opts, &cx.Options{
+			CharacterIDs:      cx.CharacterIDs{1, 2},
+			StandingsInterval: c.interval,
+		})
+		next := map[int32]time.Time{1: c.next, 2: c.next}
+		syncStandingsAt(ctx, next, now)
+		for sourceID, at := range next {
+			if !at.Equal(c.next) {
+				t.Errorf("%s: %d is next due %s, expected %s", name, sourceID, at, c.next)
+			}
+		}
+	}
+}
+
+func TestXPages(t *testing.T) {
+	for raw, expected := range map[string]int32{"": 1, "1": 1, "4": 4} {
+		res := &http.Response{Header: http.Header{}}
+		if raw != "" {
+			res.Header.Set("X-Pages", raw)
+		}
+		if pages, err := xPages(res); err != nil || pages != expected {
+			t.Errorf("%q: expected %d, received %d %+v", raw, expected, pages, err)
+		}
+	}
+
+	res := &http.Response{Header: http.Header{"X-Pages": {"many"}}}
+	if _, err := xPages(res); err == nil {
+		t.Error("expected an error for invalid X-Pages")
+	}
+}
@@ -3,6 +3,7 @@ package worker
 import (
 	"context"
 	"database/sql"
+	"log"
 	"net/http"
 	"sort"
 	"strconv"
@@ -13,31 +14,103 @@ import (
 
 	"github.com/a-tal/esi-isk/isk/cx"
 	"github.com/a-tal/esi-isk/isk/db"
+	"github.com/a-tal/esi-isk/isk/metrics"
 )
 
-func characterWallet(ctx context.Context, user *db.User) ([]int32, error) {
-	charIDs := []int32{}
+// donationRefTypes are the wallet journal ref types counted as donations
+var donationRefTypes = map[string]bool{
+	"player_donation": true,
+}
 
-	entries, err := getWalletJournal(ctx, user)
-	if err != nil {
-		return charIDs, err
+// characterIDs are the ranges of player character IDs, from and to
+// inclusive. The rest are NPCs, corporations, alliances and other items
+var characterIDs = [][2]int32{
+	// created since November 2010
+	{90000000, 97999999},
+	// created before November 2010, sharing the range with corporations and
+	// alliances of the time
+	{100000000, 2099999999},
+	// created since May 2016
+	{2100000000, 2129999999},
+}
+
+// skippedEntries counts the wallet journal entries to characters which were
+// not counted as donations
+var skippedEntries = metrics.NewCounter(
+	"esi_isk_skipped_journal_entries",
+	"Wallet journal entries to characters not counted as donations",
+)
+
+// isCharacterID is true if the ID is in a range of player characters
+func isCharacterID(id int32) bool {
+	for _, r := range characterIDs {
+		if id >= r[0] && id <= r[1] {
+			return true
+		}
 	}
+	return false
+}
 
-	sort.Sort(entries)
+// skipReason is why the journal entry to the character is not a donation,
+// or empty if it is one
+func skipReason(entry esi.GetCharactersCharacterIdWalletJournal200Ok) string {
+	if !donationRefTypes[entry.RefType] {
+		return "not a donation"
+	}
+	if !isCharacterID(entry.FirstPartyId) {
+		return "not from a character"
+	}
+	return ""
+}
 
-	donations := parseForDonations(entries, user)
+// characterWallet pulls the character's wallet, returning the IDs of the
+// characters involved and the func to save it with
+func characterWallet(
+	ctx context.Context,
+	user *db.User,
+) ([]int32, func(context.Context) error, error) {
+	charIDs := []int32{}
+
+	donations, refunds, err := pullWallet(ctx, user)
+	if err != nil {
+		return charIDs, nil, err
+	}
 
-	if len(donations) > 0 {
+	if len(donations) > 0 || len(refunds) > 0 {
 		charIDs = append(charIDs, user.CharacterID)
 	}
 
 	for _, donation := range donations {
 		charIDs = append(charIDs, donation.Donator)
 	}
+	for _, refund := range refunds {
+		charIDs = append(charIDs, refund.Donator)
+	}
 
-	setLastJournalID(entries, user)
+	affiliations := getNames(ctx, donations)
+	return charIDs, func(ctx context.Context) error {
+		return saveWalletRun(ctx, donations, refunds, affiliations)
+	}, nil
+}
+
+// pullWallet returns the donations to the character, and refunds of them,
+// since its last journal ID, which is moved to the newest entry
+func pullWallet(ctx context.Context, user *db.User) (
+	db.Donations,
+	[]*db.Refund,
+	error,
+) {
+	entries, err := getWalletJournal(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return charIDs, saveWalletRun(ctx, donations, getNames(ctx, donations))
+	sort.Sort(entries)
+
+	donations := parseForDonations(entries, user)
+	refunds := parseForRefunds(entries, user)
+	setLastJournalID(entries, user)
+	return donations, refunds, nil
 }
 
 func getWalletJournal(
@@ -74,52 +147,113 @@ func parseForDonations(
 	user *db.User,
 ) []*db.Donation {
 	donations := []*db.Donation{}
+	skipped := map[string]int{}
 	hasLastID, lastID := getLastJournalID(user)
 	for _, entry := range entries {
 		if hasLastID && entry.Id == lastID {
 			break
 		}
-		if entry.RefType == "player_donation" &&
-			entry.SecondPartyId == user.CharacterID {
-			donations = append(donations, &db.Donation{
-				ID:        entry.Id,
-				Donator:   entry.FirstPartyId,
-				Recipient: user.CharacterID,
-				Timestamp: entry.Date,
-				Note:      entry.Reason,
-				Amount:    entry.Amount,
-			})
+		// refunds are parsed by parseForRefunds
+		if entry.SecondPartyId != user.CharacterID || entry.Amount < 0 {
+			continue
 		}
+		if reason := skipReason(entry); reason != "" {
+			skipped[entry.RefType+", "+reason]++
+			continue
+		}
+		donations = append(donations, &db.Donation{
+			ID:        entry.Id,
+			Donator:   entry.FirstPartyId,
+			Recipient: user.CharacterID,
+			Timestamp: entry.Date,
+			Note:      entry.Reason,
+			Amount:    db.NewISK(entry.Amount),
+		})
+	}
+
+	for kind, n := range skipped {
+		skippedEntries.Add(int64(n))
+		log.Printf(
+			"skipped %d journal entries of %d: %s",
+			n,
+			user.CharacterID,
+			kind,
+		)
 	}
 	return donations
 }
 
+// parseForRefunds returns the refunds of donations to the character, the
+// donation entries of a negative amount
+func parseForRefunds(
+	entries walletDonationEntries,
+	user *db.User,
+) []*db.Refund {
+	refunds := []*db.Refund{}
+	hasLastID, lastID := getLastJournalID(user)
+	for _, entry := range entries {
+		if hasLastID && entry.Id == lastID {
+			break
+		}
+		if entry.SecondPartyId != user.CharacterID || entry.Amount >= 0 ||
+			skipReason(entry) != "" {
+			continue
+		}
+		refunds = append(refunds, &db.Refund{
+			ID:        entry.Id,
+			Donator:   entry.FirstPartyId,
+			Recipient: user.CharacterID,
+			Timestamp: entry.Date,
+			Amount:    db.NewISK(-entry.Amount),
+		})
+	}
+	return refunds
+}
+
 func saveWalletRun(
 	ctx context.Context,
 	donations []*db.Donation,
+	refunds []*db.Refund,
 	affiliations []*db.Affiliation,
 ) error {
 	// NB: user is saved at a higher level
 
+	if err := db.MarkSelfDonations(ctx, donations); err != nil {
+		return err
+	}
+
 	for _, donation := range donations {
 		if err := db.SaveDonation(ctx, donation); err != nil {
 			return err
 		}
 	}
 
+	// donations refunded before they were seen are never counted
+	counted, err := db.MatchRefunds(ctx, donations)
+	if err != nil {
+		return err
+	}
+
 	if err := db.SaveNames(ctx, affiliations); err != nil {
 		return err
 	}
 
-	return db.SaveCharacterDonations(ctx, donations, affiliations, true)
+	if err := db.SaveCharacterDonations(ctx, counted, affiliations); err != nil {
+		return err
+	}
+
+	// after the donations, which they may refund
+	return db.SaveRefunds(ctx, refunds)
 }
 
+// walletDonationEntries sort newest first, as ESI returns them, so parsing
+// stops at the last journal ID seen
 type walletDonationEntries []esi.GetCharactersCharacterIdWalletJournal200Ok
 
 func (w walletDonationEntries) Len() int      { return len(w) }
 func (w walletDonationEntries) Swap(i, j int) { w[i], w[j] = w[j], w[i] }
 func (w walletDonationEntries) Less(i, j int) bool {
-	return w[i].Date.Before(w[j].Date)
+	return w[i].Date.After(w[j].Date)
 }
 
 func setLastJournalID(entries walletDonationEntries, user *db.User) {
@@ -163,11 +297,9 @@ func expandWalletJournal(
 	}
 	xPages := int(xPages64)
 
-	more := make(chan walletDonationEntries)
-	errs := make(chan error)
-
-	defer close(more)
-	defer close(errs)
+	// buffered, so pages finishing after another fails don't block
+	more := make(chan walletDonationEntries, xPages)
+	errs := make(chan error, xPages)
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -0,0 +1,285 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/antihax/goesi/esi"
+
+	"github.com/a-tal/esi-isk/isk/db"
+	"github.com/a-tal/esi-isk/isk/internal/testutil/esimock"
+)
+
+// journalPath is the wallet journal of character 1 on the mock
+const journalPath = "/characters/1/wallet/journal/"
+
+// testDonator is the first ID of the characters donating in testJournal
+const testDonator = 90000000
+
+// testJournal returns the entries from and to the IDs of character 1's
+// journal, newest first. Every third entry is a donation from testDonator
+// plus its ID
+func testJournal(
+	from int64,
+	to int64,
+) []esi.GetCharactersCharacterIdWalletJournal200Ok {
+	start := time.Date(2019, 1, 20, 12, 0, 0, 0, time.UTC)
+	entries := []esi.GetCharactersCharacterIdWalletJournal200Ok{}
+	for id := to; id >= from; id-- {
+		entry := esi.GetCharactersCharacterIdWalletJournal200Ok{
+			Id:            id,
+			Date:          start.Add(time.Duration(id) * time.Minute),
+			RefType:       "market_transaction",
+			FirstPartyId:  1,
+			SecondPartyId: 2,
+			Amount:        1000,
+		}
+		if id%3 == 0 {
+			entry.RefType = "player_donation"
+			entry.FirstPartyId = testDonator + int32(id)
+			entry.SecondPartyId = 1
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// testWallet returns a mock with 10 entries of character 1's journal, 4 per
+// page, and the signed in user
+func testWallet(t *testing.T) (*esimock.Server, context.Context, *db.User) {
+	mock, ctx := testESI(t)
+	mock.JournalPageSize = 4
+	mock.Journal(1, testJournal(1, 10)...)
+	return mock, ctx, testUser(t, ctx, mock, 1)
+}
+
+// pollWallet refreshes the user's token and pulls its wallet, as polls do,
+// returning the donations
+func pollWallet(ctx context.Context, user *db.User) (db.Donations, error) {
+	authCtx, err := addCharacterAuth(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	donations, _, err := pullWallet(authCtx, user)
+	return donations, err
+}
+
+func donators(donations db.Donations) []int32 {
+	ids := []int32{}
+	for _, donation := range donations {
+		ids = append(ids, donation.Donator)
+	}
+	return ids
+}
+
+func TestPullWallet(t *testing.T) {
+	mock, ctx, user := testWallet(t)
+
+	donations, err := pollWallet(ctx, user)
+	if err != nil {
+		t.Fatalf("failed to pull wallet: %+v", err)
+	}
+	expected := []int32{testDonator + 9, testDonator + 6, testDonator + 3}
+	if received := donators(donations); !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected donations from %v, received %v", expected, received)
+	}
+	for _, donation := range donations {
+		if donation.Recipient != 1 || donation.Amount != db.NewISK(1000) {
+			t.Errorf("unexpected donation %+v", donation)
+		}
+	}
+	if !user.LastJournalID.Valid || user.LastJournalID.Int64 != 10 {
+		t.Errorf("expected last journal ID 10, received %+v", user.LastJournalID)
+	}
+	if requests := mock.Requests(journalPath); requests != 3 {
+		t.Errorf("expected every page to be pulled, received %d", requests)
+	}
+
+	// only the newest page is pulled once it has a known entry
+	mock.Journal(1, testJournal(1, 13)...)
+	donations, err = pollWallet(ctx, user)
+	if err != nil {
+		t.Fatalf("failed to pull wallet again: %+v", err)
+	}
+	expected = []int32{testDonator + 12}
+	if received := donators(donations); !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected only the new donation, received %v", received)
+	}
+	if user.LastJournalID.Int64 != 13 {
+		t.Errorf("expected last journal ID 13, received %+v", user.LastJournalID)
+	}
+	if requests := mock.Requests(journalPath); requests != 4 {
+		t.Errorf("expected one more request, received %d", requests)
+	}
+}
+
+func TestPullWalletPageFails(t *testing.T) {
+	mock, ctx, user := testWallet(t)
+	mock.Inject(journalPath, esimock.Fault{Status: 500, Page: 2, Times: 1})
+
+	if _, err := pollWallet(ctx, user); err == nil {
+		t.Fatal("expected the failed page to fail the pull")
+	}
+	if user.LastJournalID.Valid {
+		t.Errorf("last journal ID moved to %d", user.LastJournalID.Int64)
+	}
+
+	donations, err := pollWallet(ctx, user)
+	if err != nil {
+		t.Fatalf("failed to pull wallet after the fault: %+v", err)
+	}
+	if len(donations) != 3 {
+		t.Errorf("expected 3 donations, received %d", len(donations))
+	}
+}
+
+func TestPullWalletSlow(t *testing.T) {
+	mock, ctx, user := testWallet(t)
+	mock.Inject(journalPath, esimock.Fault{Delay: 5 * time.Second, Page: 3})
+
+	pollCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+	if _, err := pollWallet(pollCtx, user); err == nil {
+		t.Fatal("expected the slow page to time out the pull")
+	}
+}
+
+func TestPullWalletErrorLimited(t *testing.T) {
+	mock, ctx, user := testWallet(t)
+	mock.Inject(journalPath, esimock.Fault{Status: 420, Times: 5})
+
+	for i := 0; i < 5; i++ {
+		if _, err := pollWallet(ctx, user); err == nil {
+			t.Fatalf("expected poll %d to be error limited", i)
+		} else if errors.Is(err, errCircuitOpen) {
+			t.Fatalf("error limited poll %d opened the circuit", i)
+		}
+	}
+	if _, err := pollWallet(ctx, user); err != nil {
+		t.Errorf("failed to pull wallet after the error limit: %+v", err)
+	}
+}
+
+func TestPullWalletOpensCircuit(t *testing.T) {
+	mock, ctx, user := testWallet(t)
+	mock.Inject(journalPath, esimock.Fault{Status: 503})
+
+	for i := 0; i < 3; i++ {
+		if _, err := pollWallet(ctx, user); err == nil ||
+			errors.Is(err, errCircuitOpen) {
+			t.Fatalf("expected poll %d to reach ESI and fail, received %+v", i, err)
+		}
+	}
+
+	if _, err := pollWallet(ctx, user); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("expected the circuit to open, received %+v", err)
+	}
+	if requests := mock.Requests(journalPath); requests != 3 {
+		t.Errorf("expected the open circuit to refuse, received %d", requests)
+	}
+	if !esiOpen(ctx) {
+		t.Error("expected polling to pause while the circuit is open")
+	}
+}
+
+func TestParseForDonationsFilters(t *testing.T) {
+	entry := func(
+		id int64,
+		refType string,
+		from int32,
+	) esi.GetCharactersCharacterIdWalletJournal200Ok {
+		return esi.GetCharactersCharacterIdWalletJournal200Ok{
+			Id:            id,
+			RefType:       refType,
+			FirstPartyId:  from,
+			SecondPartyId: 1,
+			Amount:        1000,
+		}
+	}
+
+	skipped := skippedEntries.Value()
+	donations := parseForDonations(walletDonationEntries{
+		entry(1, "player_donation", 90000001),
+		entry(2, "player_donation", 2112000000),
+		entry(3, "player_donation", 1000125),
+		entry(4, "player_donation", 3008416),
+		entry(5, "player_donation", 98000001),
+		entry(6, "insurance", 1000132),
+		entry(7, "player_trading", 90000002),
+	}, &db.User{CharacterID: 1})
+
+	expected := []int32{90000001, 2112000000}
+	if received := donators(donations); !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected donations from %v, received %v", expected, received)
+	}
+	if n := skippedEntries.Value() - skipped; n != 5 {
+		t.Errorf("expected 5 skipped entries, received %d", n)
+	}
+}
+
+func TestIsCharacterID(t *testing.T) {
+	for id, expected := range map[int32]bool{
+		0:          false,
+		500001:     false,
+		1000125:    false,
+		3008416:    false,
+		90000000:   true,
+		97999999:   true,
+		98000000:   false,
+		99000001:   false,
+		100000000:  true,
+		2100000000: true,
+		2129999999: true,
+		2130000000: false,
+	} {
+		if isCharacterID(id) != expected {
+			t.Errorf("%d: expected %t", id, expected)
+		}
+	}
+}
+
+func TestParseForRefunds(t *testing.T) {
+	entries := walletDonationEntries{
+		{
+			Id:            3,
+			RefType:       "player_donation",
+			FirstPartyId:  testDonator,
+			SecondPartyId: 1,
+			Amount:        -1000,
+		},
+		{
+			Id:            2,
+			RefType:       "insurance",
+			FirstPartyId:  1000132,
+			SecondPartyId: 1,
+			Amount:        -500,
+		},
+		{
+			Id:            1,
+			RefType:       "player_donation",
+			FirstPartyId:  testDonator,
+			SecondPartyId: 1,
+			Amount:        1000,
+		},
+	}
+	user := &db.User{CharacterID: 1}
+
+	refunds := parseForRefunds(entries, user)
+	if len(refunds) != 1 {
+		t.Fatalf("expected 1 refund, received %d", len(refunds))
+	}
+	if r := refunds[0]; r.ID != 3 || r.Donator != testDonator ||
+		r.Recipient != 1 || r.Amount != db.NewISK(1000) {
+		t.Errorf("unexpected refund %+v", r)
+	}
+
+	// refunds are not donations
+	donations := parseForDonations(entries, user)
+	expected := []int32{testDonator}
+	if received := donators(donations); !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected donations from %v, received %v", expected, received)
+	}
+}
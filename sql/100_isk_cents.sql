@@ -0,0 +1,58 @@
+-- ISK amounts are stored as BIGINT hundredths of an ISK (cents).
+-- Each column is only converted while it is still floating point, so this
+-- file is safe to run more than once.
+DO $$
+DECLARE
+    col RECORD;
+BEGIN
+    FOR col IN
+        SELECT table_name, column_name FROM information_schema.columns
+        WHERE data_type = 'double precision' AND (table_name, column_name) IN (
+            ('characters', 'received_isk'),
+            ('characters', 'received_isk_30'),
+            ('characters', 'donated_isk'),
+            ('characters', 'donated_isk_30'),
+            ('contracts', 'value'),
+            ('donations', 'amount')
+        )
+    LOOP
+        EXECUTE format(
+            'ALTER TABLE %I ALTER COLUMN %I TYPE BIGINT USING round(%I * 100)',
+            col.table_name,
+            col.column_name,
+            col.column_name
+        );
+    END LOOP;
+END
+$$;
+
+-- recalculate the 30 day totals from source rows to drop any float drift
+UPDATE characters SET
+    received_30 = (
+        SELECT COUNT(*) FROM donations
+        WHERE receiver = characters.character_id
+    ) + (
+        SELECT COUNT(*) FROM contracts
+        WHERE accepted AND receiver = characters.character_id
+    ),
+    received_isk_30 = (
+        SELECT COALESCE(SUM(amount), 0) FROM donations
+        WHERE receiver = characters.character_id
+    ) + (
+        SELECT COALESCE(SUM(value), 0) FROM contracts
+        WHERE accepted AND receiver = characters.character_id
+    ),
+    donated_30 = (
+        SELECT COUNT(*) FROM donations
+        WHERE donator = characters.character_id
+    ) + (
+        SELECT COUNT(*) FROM contracts
+        WHERE accepted AND donator = characters.character_id
+    ),
+    donated_isk_30 = (
+        SELECT COALESCE(SUM(amount), 0) FROM donations
+        WHERE donator = characters.character_id
+    ) + (
+        SELECT COALESCE(SUM(value), 0) FROM contracts
+        WHERE accepted AND donator = characters.character_id
+    );
@@ -0,0 +1,8 @@
+-- contracts keep their last seen ESI status, so reversals can be tracked
+ALTER TABLE contracts ADD COLUMN IF NOT EXISTS status TEXT;
+
+UPDATE contracts SET status = CASE WHEN accepted THEN 'finished'
+ELSE 'outstanding' END WHERE status IS NULL;
+
+ALTER TABLE contracts ALTER COLUMN status SET DEFAULT 'outstanding';
+ALTER TABLE contracts ALTER COLUMN status SET NOT NULL;
@@ -0,0 +1,16 @@
+-- characters are linked to the SSO account owning them at login
+CREATE TABLE IF NOT EXISTS owners (
+    character_id INTEGER NOT NULL,
+    owner_hash   TEXT    NOT NULL,
+    PRIMARY KEY (character_id)
+);
+
+CREATE INDEX IF NOT EXISTS owners_owner_hash ON owners (owner_hash);
+
+INSERT INTO owners (character_id, owner_hash)
+SELECT character_id, owner_hash FROM users
+ON CONFLICT (character_id) DO NOTHING;
+
+-- donations between characters of one account are flagged and not counted
+ALTER TABLE donations ADD COLUMN IF NOT EXISTS
+    self_donation BOOLEAN NOT NULL DEFAULT false;
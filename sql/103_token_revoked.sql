@@ -0,0 +1,3 @@
+-- revoked refresh tokens are kept until the owner logs in again
+ALTER TABLE users ADD COLUMN IF NOT EXISTS
+    revoked BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,11 @@
+-- records account level actions taken by or for a character
+CREATE TABLE IF NOT EXISTS audit (
+    id           SERIAL    NOT NULL,
+    character_id INTEGER   NOT NULL,
+    action       TEXT      NOT NULL,
+    detail       TEXT      NOT NULL,
+    "timestamp"  TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS audit_character_id ON audit (character_id);
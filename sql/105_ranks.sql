@@ -0,0 +1,9 @@
+-- ranks are maintained by the worker, characters without any 30 day activity
+-- in a direction have no rank in it
+ALTER TABLE characters ADD COLUMN IF NOT EXISTS received_rank INTEGER;
+ALTER TABLE characters ADD COLUMN IF NOT EXISTS donated_rank INTEGER;
+ALTER TABLE characters ADD COLUMN IF NOT EXISTS
+    received_percentile DOUBLE PRECISION;
+ALTER TABLE characters ADD COLUMN IF NOT EXISTS
+    donated_percentile DOUBLE PRECISION;
+ALTER TABLE characters ADD COLUMN IF NOT EXISTS ranked_at TIMESTAMP;
@@ -0,0 +1,11 @@
+-- monthly snapshots of the top recipients and donators, taken by the worker
+CREATE TABLE IF NOT EXISTS leaderboard_history (
+    month        DATE      NOT NULL,
+    board        TEXT      NOT NULL,
+    position     INTEGER   NOT NULL,
+    character_id INTEGER   NOT NULL,
+    count        BIGINT    NOT NULL,
+    isk          BIGINT    NOT NULL,
+    created      TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    PRIMARY KEY (month, board, position)
+);
@@ -0,0 +1,3 @@
+-- donors can hide their name from the public pages of who they donated to
+ALTER TABLE preferences ADD COLUMN IF NOT EXISTS
+    anonymous BOOLEAN NOT NULL DEFAULT FALSE;
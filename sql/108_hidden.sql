@@ -0,0 +1,4 @@
+-- hidden characters are left out of leaderboards and public lookups, only
+-- the character themselves can see their page
+ALTER TABLE characters ADD COLUMN IF NOT EXISTS
+    hidden BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,12 @@
+-- vanity URL slugs, replaced slugs keep redirecting for 30 days
+CREATE TABLE IF NOT EXISTS slugs (
+    slug         TEXT      NOT NULL,
+    character_id INTEGER   NOT NULL,
+    claimed_at   TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    replaced_at  TIMESTAMP,
+    PRIMARY KEY (slug)
+);
+
+-- each character has at most one current slug
+CREATE UNIQUE INDEX IF NOT EXISTS slugs_current ON slugs (character_id)
+    WHERE replaced_at IS NULL;
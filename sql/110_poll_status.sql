@@ -0,0 +1,3 @@
+-- when each character was last polled successfully and last failed to poll
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_success TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_error TIMESTAMP;
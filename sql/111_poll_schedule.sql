@@ -0,0 +1,5 @@
+-- quiet characters are polled less often, poll_interval is the seconds from
+-- their last poll to next_poll_at, 0 for the base interval
+ALTER TABLE users ADD COLUMN IF NOT EXISTS next_poll_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS
+    poll_interval INTEGER NOT NULL DEFAULT 0;
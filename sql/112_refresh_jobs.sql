@@ -0,0 +1,15 @@
+-- polls of a character requested by its owner, outside the normal schedule
+CREATE TABLE IF NOT EXISTS refresh_jobs (
+    job_id       SERIAL    NOT NULL,
+    character_id INTEGER   NOT NULL,
+    status       TEXT      NOT NULL DEFAULT 'pending',
+    requested_at TIMESTAMP NOT NULL,
+    started_at   TIMESTAMP,
+    finished_at  TIMESTAMP,
+
+    PRIMARY KEY (job_id)
+);
+
+-- each character has at most one pending or running job, others coalesce
+CREATE UNIQUE INDEX IF NOT EXISTS refresh_jobs_active ON refresh_jobs
+    (character_id) WHERE status IN ('pending', 'running');
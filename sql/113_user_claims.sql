@@ -0,0 +1,3 @@
+-- workers claim users before polling them, so replicas never poll the same
+-- character at once. claims of crashed workers expire
+ALTER TABLE users ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMP;
@@ -0,0 +1,6 @@
+-- characters are backfilled with all available history on their first poll
+ALTER TABLE users ADD COLUMN IF NOT EXISTS backfilled_at TIMESTAMP;
+
+-- characters polled before backfills existed are re-run by hand if needed
+UPDATE users SET backfilled_at = last_processed
+WHERE backfilled_at IS NULL AND last_processed IS NOT NULL;
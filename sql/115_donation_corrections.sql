@@ -0,0 +1,9 @@
+-- voided donations are kept, but no longer shown or counted in any totals
+ALTER TABLE donations ADD COLUMN IF NOT EXISTS voided_at TIMESTAMP;
+
+-- adjustments are donations entered by an admin. their IDs are negative, so
+-- they never collide with the journal ref IDs of donations from ESI
+ALTER TABLE donations ADD COLUMN IF NOT EXISTS
+    adjustment BOOLEAN NOT NULL DEFAULT false;
+
+CREATE SEQUENCE IF NOT EXISTS donation_adjustments;
@@ -0,0 +1,23 @@
+-- the corporations and alliances characters have been seen in, a row is only
+-- added when either changes. alliance_id is 0 outside of an alliance
+CREATE TABLE IF NOT EXISTS character_affiliation_history (
+    character_id   INTEGER   NOT NULL,
+    corporation_id INTEGER   NOT NULL,
+    alliance_id    INTEGER   NOT NULL,
+    observed_at    TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
+);
+
+CREATE INDEX IF NOT EXISTS character_affiliation_history_character_id
+    ON character_affiliation_history (character_id, observed_at);
+
+-- history starts with the affiliation known when it was first kept
+INSERT INTO character_affiliation_history (
+    character_id,
+    corporation_id,
+    alliance_id
+)
+SELECT character_id, corporation_id, alliance_id FROM characters
+WHERE NOT EXISTS (
+    SELECT 1 FROM character_affiliation_history AS history
+    WHERE history.character_id = characters.character_id
+);
@@ -0,0 +1,8 @@
+-- the contacts of the standings character, replaced by each sync. characters
+-- are in good standing if they, their corporation or their alliance are set
+-- at or above the standing threshold
+CREATE TABLE IF NOT EXISTS standings_contacts (
+    contact_id   INTEGER     NOT NULL PRIMARY KEY,
+    contact_type VARCHAR(16) NOT NULL,
+    standing     REAL        NOT NULL
+);
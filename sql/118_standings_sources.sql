@@ -0,0 +1,11 @@
+-- contacts are kept per standings source character. those synced before are
+-- of no known source, the next sync of each source replaces them
+ALTER TABLE standings_contacts
+    ADD COLUMN IF NOT EXISTS source_id INTEGER NOT NULL DEFAULT 0;
+
+DELETE FROM standings_contacts WHERE source_id = 0;
+
+ALTER TABLE standings_contacts DROP CONSTRAINT IF EXISTS standings_contacts_pkey;
+
+CREATE UNIQUE INDEX IF NOT EXISTS standings_contacts_source_contact
+    ON standings_contacts (source_id, contact_id);
@@ -0,0 +1,4 @@
+-- characters ESI no longer knows, such as biomassed ones. their history and
+-- last known names are kept, but they are no longer polled or resolved
+ALTER TABLE characters ADD COLUMN IF NOT EXISTS
+    deleted BOOLEAN NOT NULL DEFAULT false;
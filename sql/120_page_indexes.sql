@@ -0,0 +1,10 @@
+-- pages of donations and contracts are read newest first by (timestamp, id),
+-- after a cursor, in each direction
+CREATE INDEX IF NOT EXISTS donations_receiver_page
+    ON donations (receiver, "timestamp" DESC, transaction_id DESC);
+CREATE INDEX IF NOT EXISTS donations_donator_page
+    ON donations (donator, "timestamp" DESC, transaction_id DESC);
+CREATE INDEX IF NOT EXISTS contracts_receiver_page
+    ON contracts (receiver, issued DESC, contract_id DESC);
+CREATE INDEX IF NOT EXISTS contracts_donator_page
+    ON contracts (donator, issued DESC, contract_id DESC);
@@ -0,0 +1,9 @@
+-- page views of each character, flushed from the counts of the API servers.
+-- the most viewed are cached when the API starts
+CREATE TABLE IF NOT EXISTS character_views (
+    character_id INTEGER NOT NULL PRIMARY KEY,
+    views        BIGINT  NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS character_views_views
+    ON character_views (views DESC);
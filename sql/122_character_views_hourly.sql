@@ -0,0 +1,11 @@
+-- page views of each character by hour, for the trending leaderboard. views
+-- are counted once per IP an hour, and hours are kept for two days
+CREATE TABLE IF NOT EXISTS character_views_hourly (
+    character_id INTEGER   NOT NULL,
+    hour         TIMESTAMP NOT NULL,
+    views        BIGINT    NOT NULL DEFAULT 0,
+    PRIMARY KEY (character_id, hour)
+);
+
+CREATE INDEX IF NOT EXISTS character_views_hourly_hour
+    ON character_views_hourly (hour);
@@ -0,0 +1,27 @@
+-- records admin actions and account deletions, who took them and on what
+CREATE TABLE IF NOT EXISTS audit_log (
+    id          BIGSERIAL NOT NULL,
+    actor       TEXT      NOT NULL,
+    action      TEXT      NOT NULL,
+    target      TEXT      NOT NULL,
+    request_id  TEXT      NOT NULL DEFAULT '',
+    detail      JSONB     NOT NULL DEFAULT '{}',
+    "timestamp" TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_action ON audit_log (action, id);
+CREATE INDEX IF NOT EXISTS audit_log_target ON audit_log (target, id);
+
+-- carry over the entries of the audit table it replaces, which only knew the
+-- character, once
+INSERT INTO audit_log (actor, action, target, detail, "timestamp")
+SELECT
+    '',
+    action,
+    'character:' || character_id,
+    jsonb_build_object('detail', detail),
+    "timestamp"
+FROM audit
+WHERE NOT EXISTS (SELECT 1 FROM audit_log)
+ORDER BY id;
@@ -0,0 +1,4 @@
+-- banned characters are hidden from everyone by an admin, their donations and
+-- contracts keep being stored so a ban can be lifted without losing any
+ALTER TABLE characters ADD COLUMN IF NOT EXISTS
+    banned BOOLEAN NOT NULL DEFAULT false;
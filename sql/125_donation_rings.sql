@@ -0,0 +1,18 @@
+-- characters sending each other roughly the same ISK, found nightly by the
+-- worker. members are in the order the ISK flows, from the lowest ID, so each
+-- ring is stored once. low and high are the least and most ISK of its legs
+CREATE TABLE IF NOT EXISTS donation_rings (
+    id          BIGSERIAL NOT NULL,
+    members     INTEGER[] NOT NULL,
+    low         BIGINT    NOT NULL,
+    high        BIGINT    NOT NULL,
+    detected_at TIMESTAMP NOT NULL,
+    last_seen   TIMESTAMP NOT NULL,
+    reviewed_at TIMESTAMP,
+    reviewed_by TEXT      NOT NULL DEFAULT '',
+    PRIMARY KEY (id),
+    UNIQUE (members)
+);
+
+CREATE INDEX IF NOT EXISTS donation_rings_pending
+    ON donation_rings (id) WHERE reviewed_at IS NULL;
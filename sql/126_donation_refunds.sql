@@ -0,0 +1,18 @@
+-- refunds are player_donation journal entries of a negative amount. once
+-- matched, the donation they refund is voided, and refund_id links them. a
+-- refund seen before its donation waits, without a transaction_id, and voids
+-- the donation once it is stored
+CREATE TABLE IF NOT EXISTS donation_refunds (
+    refund_id      BIGINT    NOT NULL,
+    donator        INTEGER   NOT NULL,
+    receiver       INTEGER   NOT NULL,
+    "timestamp"    TIMESTAMP NOT NULL,
+    amount         BIGINT    NOT NULL,
+    transaction_id BIGINT,
+    PRIMARY KEY (refund_id)
+);
+
+CREATE INDEX IF NOT EXISTS donation_refunds_pending
+    ON donation_refunds (donator, receiver) WHERE transaction_id IS NULL;
+
+ALTER TABLE donations ADD COLUMN IF NOT EXISTS refund_id BIGINT;
@@ -0,0 +1,16 @@
+-- contracts which ended unaccepted, by expiring or being rejected, deleted
+-- and so on, are terminal from terminal_at. they are pruned 90 days after
+-- they were issued. accepted contracts are kept, aged out of the 30 day
+-- totals once they were issued 30 days ago
+ALTER TABLE contracts ADD COLUMN IF NOT EXISTS terminal_at TIMESTAMP;
+ALTER TABLE contracts ADD COLUMN IF NOT EXISTS aged BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE contracts SET status = 'expired'
+WHERE status = 'outstanding' AND expires < NOW() AT TIME ZONE 'UTC';
+
+UPDATE contracts SET terminal_at = NOW() AT TIME ZONE 'UTC'
+WHERE terminal_at IS NULL AND NOT accepted
+AND status IN ('expired', 'rejected', 'cancelled', 'failed', 'deleted', 'reversed');
+
+CREATE INDEX IF NOT EXISTS contracts_terminal
+    ON contracts (issued) WHERE terminal_at IS NOT NULL AND NOT accepted;
@@ -0,0 +1,34 @@
+-- item types which make a contract notable. contracts are classified once
+-- their items are stored, contains_plex if any of them are plex, and
+-- notable_items counting all of them
+CREATE TABLE IF NOT EXISTS notable_types (
+    type_id INTEGER NOT NULL,
+    name    TEXT    NOT NULL,
+    plex    BOOLEAN NOT NULL DEFAULT false,
+    PRIMARY KEY (type_id)
+);
+
+INSERT INTO notable_types (type_id, name, plex) VALUES
+    (44992, 'PLEX', true),
+    (40520, 'Large Skill Injector', false),
+    (45635, 'Small Skill Injector', false),
+    (40519, 'Skill Extractor', false)
+ON CONFLICT (type_id) DO NOTHING;
+
+ALTER TABLE contracts ADD COLUMN IF NOT EXISTS
+    contains_plex BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE contracts ADD COLUMN IF NOT EXISTS
+    notable_items INTEGER NOT NULL DEFAULT 0;
+
+UPDATE contracts SET
+    contains_plex = notable.plex,
+    notable_items = notable.quantity
+FROM (
+    SELECT contract_id, bool_or(plex) AS plex, SUM(quantity) AS quantity
+    FROM contractItems JOIN notable_types USING (type_id)
+    GROUP BY contract_id
+) AS notable
+WHERE contracts.contract_id = notable.contract_id;
+
+CREATE INDEX IF NOT EXISTS contracts_notable
+    ON contracts (value DESC) WHERE notable_items > 0 AND accepted;
@@ -0,0 +1,18 @@
+-- names of the stations and structures contracts are at. structures the
+-- polled characters may not access are stored denied, as Unknown Structure,
+-- and only resolved again a week later
+CREATE TABLE IF NOT EXISTS locations (
+    location_id BIGINT    NOT NULL,
+    name        TEXT      NOT NULL,
+    denied      BOOLEAN   NOT NULL DEFAULT false,
+    resolved_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (location_id)
+);
+
+ALTER TABLE contracts ADD COLUMN IF NOT EXISTS
+    location_name TEXT NOT NULL DEFAULT '';
+
+UPDATE contracts SET location_name = locations.name
+FROM locations
+WHERE contracts.location = locations.location_id
+AND contracts.location_name <> locations.name;
@@ -0,0 +1,12 @@
+-- donations leave the donations table for the archive as they leave the 30
+-- day totals, so hot queries only read the last 30 days. only the history
+-- reads the archive. columns added to donations must be added here too, in
+-- the same order, as donations are archived with SELECT *
+CREATE TABLE IF NOT EXISTS donations_archive (LIKE donations INCLUDING DEFAULTS);
+
+CREATE UNIQUE INDEX IF NOT EXISTS donations_archive_id
+    ON donations_archive (transaction_id);
+CREATE INDEX IF NOT EXISTS donations_archive_receiver
+    ON donations_archive (receiver, "timestamp");
+CREATE INDEX IF NOT EXISTS donations_archive_donator
+    ON donations_archive (donator);
@@ -0,0 +1,125 @@
+-- rolling totals are kept over 7, 30 and 90 days. donations and accepted
+-- contracts record the longest window they aged out of in aged_days, they
+-- are still counted in the totals of longer ones. columns added to donations
+-- are added to the archive too, in the same order
+ALTER TABLE characters
+    ADD COLUMN IF NOT EXISTS received_7 BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS received_isk_7 BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS received_90 BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS received_isk_90 BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS donated_7 BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS donated_isk_7 BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS donated_90 BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS donated_isk_90 BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE donations
+    ADD COLUMN IF NOT EXISTS aged_days INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE donations_archive
+    ADD COLUMN IF NOT EXISTS aged_days INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE contracts
+    ADD COLUMN IF NOT EXISTS aged_days INTEGER NOT NULL DEFAULT 0;
+
+-- stored donations are in the 30 day totals, archived ones were aged out
+UPDATE donations SET aged_days = 7
+WHERE "timestamp" < NOW() - INTERVAL '7 days';
+UPDATE donations_archive SET aged_days = 30;
+UPDATE donations_archive SET aged_days = 90
+WHERE "timestamp" < NOW() - INTERVAL '90 days';
+
+-- aged replaces the boolean of contracts aged out of the 30 day totals
+UPDATE contracts SET aged_days = 30 WHERE aged;
+UPDATE contracts SET aged_days = 90
+WHERE aged AND issued < NOW() - INTERVAL '90 days';
+UPDATE contracts SET aged_days = 7
+WHERE accepted AND NOT aged AND issued < NOW() - INTERVAL '7 days';
+ALTER TABLE contracts DROP COLUMN IF EXISTS aged;
+
+-- archived donations are read by the 90 day totals until they age out
+CREATE INDEX IF NOT EXISTS donations_archive_unaged_receiver
+    ON donations_archive (receiver) WHERE aged_days < 90;
+CREATE INDEX IF NOT EXISTS donations_archive_unaged_donator
+    ON donations_archive (donator) WHERE aged_days < 90;
+
+-- the new totals start from the stored rows, as the hourly recalculation
+-- keeps them. voided and self donations are not counted
+UPDATE characters SET
+    received_7 = (
+        SELECT COUNT(*) FROM donations
+        WHERE voided_at IS NULL AND NOT self_donation AND aged_days < 7
+        AND receiver = characters.character_id
+    ) + (
+        SELECT COUNT(*) FROM contracts
+        WHERE accepted AND aged_days < 7 AND receiver = characters.character_id
+    ),
+    received_isk_7 = (
+        SELECT COALESCE(SUM(amount), 0) FROM donations
+        WHERE voided_at IS NULL AND NOT self_donation AND aged_days < 7
+        AND receiver = characters.character_id
+    ) + (
+        SELECT COALESCE(SUM(value), 0) FROM contracts
+        WHERE accepted AND aged_days < 7 AND receiver = characters.character_id
+    ),
+    donated_7 = (
+        SELECT COUNT(*) FROM donations
+        WHERE voided_at IS NULL AND NOT self_donation AND aged_days < 7
+        AND donator = characters.character_id
+    ) + (
+        SELECT COUNT(*) FROM contracts
+        WHERE accepted AND aged_days < 7 AND donator = characters.character_id
+    ),
+    donated_isk_7 = (
+        SELECT COALESCE(SUM(amount), 0) FROM donations
+        WHERE voided_at IS NULL AND NOT self_donation AND aged_days < 7
+        AND donator = characters.character_id
+    ) + (
+        SELECT COALESCE(SUM(value), 0) FROM contracts
+        WHERE accepted AND aged_days < 7 AND donator = characters.character_id
+    ),
+    received_90 = (
+        SELECT COUNT(*) FROM donations
+        WHERE voided_at IS NULL AND NOT self_donation
+        AND receiver = characters.character_id
+    ) + (
+        SELECT COUNT(*) FROM donations_archive
+        WHERE voided_at IS NULL AND NOT self_donation AND aged_days < 90
+        AND receiver = characters.character_id
+    ) + (
+        SELECT COUNT(*) FROM contracts
+        WHERE accepted AND aged_days < 90 AND receiver = characters.character_id
+    ),
+    received_isk_90 = (
+        SELECT COALESCE(SUM(amount), 0) FROM donations
+        WHERE voided_at IS NULL AND NOT self_donation
+        AND receiver = characters.character_id
+    ) + (
+        SELECT COALESCE(SUM(amount), 0) FROM donations_archive
+        WHERE voided_at IS NULL AND NOT self_donation AND aged_days < 90
+        AND receiver = characters.character_id
+    ) + (
+        SELECT COALESCE(SUM(value), 0) FROM contracts
+        WHERE accepted AND aged_days < 90 AND receiver = characters.character_id
+    ),
+    donated_90 = (
+        SELECT COUNT(*) FROM donations
+        WHERE voided_at IS NULL AND NOT self_donation
+        AND donator = characters.character_id
+    ) + (
+        SELECT COUNT(*) FROM donations_archive
+        WHERE voided_at IS NULL AND NOT self_donation AND aged_days < 90
+        AND donator = characters.character_id
+    ) + (
+        SELECT COUNT(*) FROM contracts
+        WHERE accepted AND aged_days < 90 AND donator = characters.character_id
+    ),
+    donated_isk_90 = (
+        SELECT COALESCE(SUM(amount), 0) FROM donations
+        WHERE voided_at IS NULL AND NOT self_donation
+        AND donator = characters.character_id
+    ) + (
+        SELECT COALESCE(SUM(amount), 0) FROM donations_archive
+        WHERE voided_at IS NULL AND NOT self_donation AND aged_days < 90
+        AND donator = characters.character_id
+    ) + (
+        SELECT COALESCE(SUM(value), 0) FROM contracts
+        WHERE accepted AND aged_days < 90 AND donator = characters.character_id
+    );
@@ -0,0 +1,51 @@
+-- the largest donation and contract each character received, null until they
+-- receive one. they are recorded as donations and contracts are counted, and
+-- set again by the hourly recalculation
+ALTER TABLE characters
+    ADD COLUMN IF NOT EXISTS largest_donation_id BIGINT,
+    ADD COLUMN IF NOT EXISTS largest_donation BIGINT,
+    ADD COLUMN IF NOT EXISTS largest_donation_donator INTEGER,
+    ADD COLUMN IF NOT EXISTS largest_donation_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS largest_contract_id INTEGER,
+    ADD COLUMN IF NOT EXISTS largest_contract BIGINT,
+    ADD COLUMN IF NOT EXISTS largest_contract_donator INTEGER,
+    ADD COLUMN IF NOT EXISTS largest_contract_at TIMESTAMP;
+
+-- the recalculation reads the biggest of each receiver
+CREATE INDEX IF NOT EXISTS donations_receiver_amount
+    ON donations (receiver, amount DESC);
+CREATE INDEX IF NOT EXISTS donations_archive_receiver_amount
+    ON donations_archive (receiver, amount DESC);
+CREATE INDEX IF NOT EXISTS contracts_receiver_value
+    ON contracts (receiver, value DESC) WHERE accepted;
+
+-- voided and self donations are not counted
+UPDATE characters SET (
+    largest_donation_id,
+    largest_donation,
+    largest_donation_donator,
+    largest_donation_at
+) = (
+    SELECT * FROM (
+        SELECT transaction_id, amount, donator, "timestamp" FROM donations
+        WHERE voided_at IS NULL AND NOT self_donation
+        AND receiver = characters.character_id
+        UNION ALL
+        SELECT transaction_id, amount, donator, "timestamp"
+        FROM donations_archive
+        WHERE voided_at IS NULL AND NOT self_donation
+        AND receiver = characters.character_id
+    ) AS received
+    ORDER BY amount DESC, "timestamp", transaction_id
+    LIMIT 1
+), (
+    largest_contract_id,
+    largest_contract,
+    largest_contract_donator,
+    largest_contract_at
+) = (
+    SELECT contract_id, value, donator, issued FROM contracts
+    WHERE accepted AND receiver = characters.character_id
+    ORDER BY value DESC, issued, contract_id
+    LIMIT 1
+);
@@ -0,0 +1,35 @@
+-- streaks of consecutive days (EVE time) with a donation received. the
+-- current streak is zeroed once a day passes without one, streak_day is the
+-- last day of the latest streak
+ALTER TABLE characters
+    ADD COLUMN IF NOT EXISTS streak_current INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS streak_best INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS streak_day DATE;
+
+-- from the stored donations, voided and self donations are not counted
+WITH days AS (
+    SELECT DISTINCT receiver, CAST("timestamp" AS DATE) AS day FROM (
+        SELECT receiver, "timestamp" FROM donations
+        WHERE voided_at IS NULL AND NOT self_donation
+        UNION ALL
+        SELECT receiver, "timestamp" FROM donations_archive
+        WHERE voided_at IS NULL AND NOT self_donation
+    ) AS received
+), streaks AS (
+    SELECT receiver, MAX(day) AS last_day, COUNT(*) AS days FROM (
+        SELECT receiver, day, day - CAST(ROW_NUMBER() OVER (
+            PARTITION BY receiver ORDER BY day
+        ) AS INTEGER) AS island
+        FROM days
+    ) AS islands
+    GROUP BY receiver, island
+)
+UPDATE characters SET (streak_current, streak_best, streak_day) = (
+    SELECT
+        COALESCE(MAX(days) FILTER (
+            WHERE last_day >= CAST(NOW() AT TIME ZONE 'UTC' AS DATE) - 1
+        ), 0),
+        COALESCE(MAX(days), 0),
+        MAX(last_day)
+    FROM streaks WHERE receiver = characters.character_id
+);
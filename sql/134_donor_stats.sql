@@ -0,0 +1,8 @@
+-- donor stats of each character, computed by the hourly maintenance from the
+-- stored donations as of stats_computed_at. they are only shown while fresh
+ALTER TABLE characters
+    ADD COLUMN IF NOT EXISTS average_donation BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS average_donation_30 BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS unique_donors INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS repeat_donors INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS stats_computed_at TIMESTAMP;
@@ -0,0 +1,24 @@
+-- the corporation of the donator when the donation was counted, null when it
+-- isn't known. columns added to donations are added to the archive too, in
+-- the same order
+ALTER TABLE donations
+    ADD COLUMN IF NOT EXISTS donator_corporation INTEGER;
+ALTER TABLE donations_archive
+    ADD COLUMN IF NOT EXISTS donator_corporation INTEGER;
+
+-- the breakdown of each recipient reads their donations by corporation
+CREATE INDEX IF NOT EXISTS donations_receiver_corporation
+    ON donations (receiver, donator_corporation);
+CREATE INDEX IF NOT EXISTS donations_archive_receiver_corporation
+    ON donations_archive (receiver, donator_corporation);
+
+-- stored donations are backfilled with the current corporation of the
+-- donator, the best guess there is
+UPDATE donations SET donator_corporation = characters.corporation_id
+FROM characters
+WHERE characters.character_id = donations.donator
+AND characters.corporation_id <> 0;
+UPDATE donations_archive SET donator_corporation = characters.corporation_id
+FROM characters
+WHERE characters.character_id = donations_archive.donator
+AND characters.corporation_id <> 0;
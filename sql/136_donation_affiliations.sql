@@ -0,0 +1,74 @@
+-- the corporations and alliances of the donor and recipient when a donation
+-- was counted, null when they aren't known. alliances are 0 outside of one.
+-- columns added to donations are added to the archive too, in the same order
+ALTER TABLE donations
+    ADD COLUMN IF NOT EXISTS donor_corporation_id INTEGER,
+    ADD COLUMN IF NOT EXISTS donor_alliance_id INTEGER,
+    ADD COLUMN IF NOT EXISTS recipient_corporation_id INTEGER,
+    ADD COLUMN IF NOT EXISTS recipient_alliance_id INTEGER;
+ALTER TABLE donations_archive
+    ADD COLUMN IF NOT EXISTS donor_corporation_id INTEGER,
+    ADD COLUMN IF NOT EXISTS donor_alliance_id INTEGER,
+    ADD COLUMN IF NOT EXISTS recipient_corporation_id INTEGER,
+    ADD COLUMN IF NOT EXISTS recipient_alliance_id INTEGER;
+
+CREATE INDEX IF NOT EXISTS donations_receiver_donor_corporation
+    ON donations (receiver, donor_corporation_id);
+CREATE INDEX IF NOT EXISTS donations_archive_receiver_donor_corporation
+    ON donations_archive (receiver, donor_corporation_id);
+
+-- stored donations take the affiliation last observed by their time, or the
+-- first observation if it was made within a day after, as it is when a new
+-- character is seen with the donation. donations from before any history
+-- stay null
+CREATE OR REPLACE FUNCTION pg_temp.observed_affiliation(
+    char_id INTEGER,
+    donated_at TIMESTAMP
+) RETURNS TABLE (corporation_id INTEGER, alliance_id INTEGER) AS $$
+    SELECT corporation_id, alliance_id FROM (
+        (
+            SELECT corporation_id, alliance_id, 0 AS preference
+            FROM character_affiliation_history
+            WHERE character_id = char_id AND observed_at <= donated_at
+            ORDER BY observed_at DESC
+            LIMIT 1
+        ) UNION ALL (
+            SELECT corporation_id, alliance_id, 1 AS preference
+            FROM character_affiliation_history
+            WHERE character_id = char_id
+            AND observed_at > donated_at
+            AND observed_at <= donated_at + INTERVAL '1 day'
+            ORDER BY observed_at
+            LIMIT 1
+        )
+    ) AS observed
+    WHERE corporation_id <> 0
+    ORDER BY preference
+    LIMIT 1
+$$ LANGUAGE SQL STABLE;
+
+UPDATE donations SET
+    (donor_corporation_id, donor_alliance_id) = (
+        SELECT corporation_id, alliance_id
+        FROM pg_temp.observed_affiliation(donator, "timestamp")
+    ),
+    (recipient_corporation_id, recipient_alliance_id) = (
+        SELECT corporation_id, alliance_id
+        FROM pg_temp.observed_affiliation(receiver, "timestamp")
+    )
+WHERE donor_corporation_id IS NULL AND recipient_corporation_id IS NULL;
+UPDATE donations_archive SET
+    (donor_corporation_id, donor_alliance_id) = (
+        SELECT corporation_id, alliance_id
+        FROM pg_temp.observed_affiliation(donator, "timestamp")
+    ),
+    (recipient_corporation_id, recipient_alliance_id) = (
+        SELECT corporation_id, alliance_id
+        FROM pg_temp.observed_affiliation(receiver, "timestamp")
+    )
+WHERE donor_corporation_id IS NULL AND recipient_corporation_id IS NULL;
+
+-- these replace the donator corporation of 135, which was guessed from the
+-- current corporations
+ALTER TABLE donations DROP COLUMN IF EXISTS donator_corporation;
+ALTER TABLE donations_archive DROP COLUMN IF EXISTS donator_corporation;
@@ -0,0 +1,4 @@
+-- the scopes granted with each refresh token, empty for tokens stored before
+-- they were kept
+ALTER TABLE users ADD COLUMN IF NOT EXISTS
+    scopes TEXT[] NOT NULL DEFAULT '{}';
@@ -226,14 +226,12 @@ function setCharID(charID) {
 
 // exposed as window.l because reasons
 function logout() {
-  delCookie();
-  createAlert('You have been logged out', 'success');
-  switchToMainPage();
+  // the session cookie is HttpOnly, only the server can remove it
+  window.location = '/logout';
 }
 
 function delCookie() {
   Cookie.remove('charID');
-  document.cookie = 'esi-isk=; expires=Thu, 01 Jan 1970 00:00:01 GMT;';
 }
 
 function frontPage() {
@@ -390,7 +388,14 @@ function donationRow(d, donation) {
 
   row.appendChild(contact);
   row.appendChild(createTD(formatISK(d.amount)));
-  row.appendChild(createTD(d.note || '', false));
+  let note = d.note || '';
+  if (d.self == true) {
+    note = '<span class="badge badge-secondary" title="between characters of one account">self</span> ' + note;
+  }
+  if (d.hidden == true) {
+    note = '<span class="badge badge-secondary">hidden</span>';
+  }
+  row.appendChild(createTD(note, false));
   row.appendChild(createTD(pad(ts.getUTCHours(), 2) + ':' + pad(ts.getUTCMinutes(), 2) + ':' + pad(ts.getUTCSeconds(), 2)));
 
   return row;
@@ -415,7 +420,7 @@ function contractRow(d, donation) {
 
   row.appendChild(contact);
   row.appendChild(createTD(formatISK(d.value)));
-  row.appendChild(createTD(d.note));
+  row.appendChild(createTD(d.hidden == true ? 'hidden' : d.note));
   row.appendChild(createTD(d.location));
   row.appendChild(createTD(d.accepted));
   row.appendChild(createTD(pad(ts.getUTCHours(), 2) + ':' + pad(ts.getUTCMinutes(), 2) + ':' + pad(ts.getUTCSeconds(), 2)));
@@ -613,8 +618,11 @@ function characterViewDiv(charID) {
     },
 
     error: function(r, s, e) {
-      if (r.status == 403) {
+      let code = errorCode(r);
+      if (code == 'forbidden') {
         createAlert('This is a private profile', 'warning');
+      } else if (code == 'not_found') {
+        createAlert('We have no details for ' + charID, 'warning');
       } else {
         console.log(r.status + ' ' + e);
         createAlert('Failed to get details for ' + charID, 'warning');
@@ -782,6 +790,13 @@ function prefsView(prefType, char) {
       }
       addPrefsButtons(form, switchType, switchText, combinedType, combinedText);
       updateExampleURL(link, charID, prefType, t);
+      if (t.needs_reauth) {
+        createAlert(
+          'Your EVE SSO login has expired or was revoked and we can no longer ' +
+          'track this character. Please <a href="/signup">sign in again</a>.',
+          'danger'
+        );
+      }
     },
     error: function(r, s, e) {
       console.log('Status: ' + s + ' Error: ' + e);
@@ -1093,16 +1108,31 @@ function postPrefs(prefType) {
     error: function(r, s, e) {
       console.log('Status: ' + s + ' Error: ' + e);
       console.log(r);
-      createAlert(
-        'Failed to save ' + typeName.toLowerCase() + ' preferences',
-        'warning'
-      );
+      let msg = 'Failed to save ' + typeName.toLowerCase() + ' preferences';
+      if (errorCode(r) == 'bad_request') {
+        msg += ': ' + escapeHTML(r.responseJSON.error.message);
+      }
+      createAlert(msg, 'warning');
     },
     dataType: "json",
     contentType: "application/json"
   });
 }
 
+// returns the code of an API error envelope, see isk/api/errors.go
+function errorCode(r) {
+  if (r.responseJSON != undefined && r.responseJSON.error != undefined) {
+    return r.responseJSON.error.code;
+  }
+  return undefined;
+}
+
+function escapeHTML(s) {
+  let div = document.createElement('div');
+  div.textContent = s;
+  return div.innerHTML;
+}
+
 function closeSpan() {
  let span = document.createElement('span');
  span.setAttribute('aria-hidden', 'true');
@@ -1154,12 +1184,10 @@ function createAlert(msg, cls) {
   }
 }
 
-// NB: this does not confirm the user isn't a hax0rman
+// NB: this does not confirm the user isn't a hax0rman, the charID cookie
+// is set alongside the (HttpOnly) session cookie for display purposes only
 function loggedIn() {
-  if (document.cookie != undefined) {
-    return document.cookie.split('=')[0] == 'esi-isk';
-  }
-  return false;
+  return Cookie.get('charID') != undefined;
 }
 
 function content() {
@@ -1206,6 +1234,10 @@ jQuery(function($){
   window.P = postPrefs;
   window.l = logout;
 
+  if (window.location.hash == '#logout') {
+    createAlert('You have been logged out', 'success');
+  }
+
   $(document).on("click", ".contract-collapsed", function(e) {
     let target = e.target;
     while (target.tagName != "TR") {